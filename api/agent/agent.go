@@ -3,6 +3,7 @@ package agent
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -21,6 +22,8 @@ import (
 	"github.com/fnproject/fn/api/common"
 	"github.com/fnproject/fn/api/id"
 	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
+	"github.com/fnproject/fn/api/secrets"
 	"github.com/fnproject/fn/fnext"
 	"github.com/fsnotify/fsnotify"
 	docker "github.com/fsouza/go-dockerclient"
@@ -30,10 +33,14 @@ import (
 	"go.opencensus.io/tag"
 	"go.opencensus.io/trace"
 	"go.opencensus.io/trace/propagation"
+	"golang.org/x/net/http2"
 )
 
 const (
 	pauseTimeout = 5 * time.Second // docker pause/unpause
+
+	// containerExitWait is how long a call whose container went away waits for its exit
+	containerExitWait = time.Second
 )
 
 // Agent exposes an api to create calls from various parameters and then submit
@@ -99,6 +106,19 @@ type agent struct {
 	// additional options to configure each call
 	callOpts []CallOpt
 
+	// resolves the secrets of calls, nil if the agent has no secret store
+	secrets secrets.Store
+
+	// hot containers ready to take calls, that may be profiled
+	hot hotContainers
+
+	// shares of the resources of fns, nil unless resources are shared fairly across fns
+	fair *fairShares
+
+	// last time in unix nanoseconds an interactive call waited for resources to launch a
+	// container, see priorityAdmission
+	interactiveWaited int64
+
 	// deferred actions to call at end of initialisation
 	onStartup []func()
 }
@@ -136,16 +156,35 @@ func New(options ...Option) Agent {
 
 	logrus.Infof("agent starting cfg=%+v", a.cfg)
 
+	if a.cfg.EnableFairShare {
+		a.fair = newFairShares(launchWaitPolls * a.cfg.HotPoll)
+	}
+
 	if a.driver == nil {
-		d, err := NewDockerDriver(&a.cfg)
+		d, err := NewDriver(&a.cfg)
 		if err != nil {
-			logrus.WithError(err).Fatal("failed to create docker driver ")
+			logrus.WithError(err).Fatalf("failed to create %s driver", a.cfg.ContainerDriver)
 		}
 		a.driver = d
 	}
 
+	if a.secrets == nil && a.cfg.SecretsURL != "" {
+		store, err := secrets.New(context.Background(), a.cfg.SecretsURL)
+		if err != nil {
+			logrus.WithError(err).Fatal("failed to create secret store")
+		}
+		a.secrets = store
+	}
+
 	a.resources = NewResourceTracker(&a.cfg)
 
+	if a.cfg.MemoryOvercommit > 100 && a.shutWg.AddSession(1) {
+		go func() {
+			a.evictUnderPressure()
+			a.shutWg.DoneSession()
+		}()
+	}
+
 	for _, sup := range a.onStartup {
 		sup()
 	}
@@ -177,6 +216,18 @@ func WithDockerDriver(drv drivers.Driver) Option {
 	}
 }
 
+// WithSecretStore provides the secret store the secrets of calls are resolved from, rather
+// than the one of the secrets URL of the config, see models.SecretsAnnotation
+func WithSecretStore(store secrets.Store) Option {
+	return func(a *agent) error {
+		if a.secrets != nil {
+			return errors.New("cannot add secret store to agent, secret store already exists")
+		}
+		a.secrets = store
+		return nil
+	}
+}
+
 // WithCallOverrider registers register a CallOverrider to modify a Call and extensions on call construction
 func WithCallOverrider(fn CallOverrider) Option {
 	return func(a *agent) error {
@@ -199,7 +250,23 @@ func WithCallOptions(opts ...CallOpt) Option {
 
 // NewDockerDriver creates a default docker driver from agent config
 func NewDockerDriver(cfg *Config) (drivers.Driver, error) {
-	return drivers.New("docker", drivers.Config{
+	return drivers.New("docker", driverConfig(cfg))
+}
+
+// NewDriver creates the container driver of the agent config, see EnvContainerDriver.
+// Drivers other than docker and podman are registered by importing them, eg. from an
+// extension, the default extensions register containerd and firecracker.
+func NewDriver(cfg *Config) (drivers.Driver, error) {
+	name := cfg.ContainerDriver
+	if name == "" {
+		name = "docker"
+	}
+	return drivers.New(name, driverConfig(cfg))
+}
+
+// driverConfig returns the config of container drivers from agent config
+func driverConfig(cfg *Config) drivers.Config {
+	return drivers.Config{
 		DockerNetworks:                cfg.DockerNetworks,
 		DockerLoadFile:                cfg.DockerLoadFile,
 		ServerVersion:                 cfg.MinDockerVersion,
@@ -215,7 +282,24 @@ func NewDockerDriver(cfg *Config) (drivers.Driver, error) {
 		ImageCleanExemptTags:          cfg.ImageCleanExemptTags,
 		ImageEnableVolume:             cfg.ImageEnableVolume,
 		DisableUnprivilegedContainers: cfg.DisableUnprivilegedContainers,
-	})
+		DockerRuntimes:                cfg.DockerRuntimes,
+		DockerRuntimeClasses:          cfg.DockerRuntimeClasses,
+		DockerGPURuntime:              dockerGPURuntime(cfg),
+		DockerRegistryMirrors:         cfg.DockerRegistryMirrors,
+		DockerIsolation:               cfg.DockerIsolation,
+		DockerBlkioDevices:            cfg.DockerBlkioDevices,
+		EgressPolicyImage:             cfg.EgressPolicyImage,
+		ImageVerifyKeys:               cfg.ImageVerifyKeys,
+		CheckpointRestore:             cfg.CheckpointRestore,
+	}
+}
+
+// dockerGPURuntime returns the OCI runtime of containers with GPUs, if the host has GPUs
+func dockerGPURuntime(cfg *Config) string {
+	if cfg.GPUDevices == "" {
+		return ""
+	}
+	return cfg.DockerGPURuntime
 }
 
 func (a *agent) Close() error {
@@ -401,8 +485,11 @@ func (a *agent) hotLauncher(ctx context.Context, call *call, caller *slotCaller)
 		timeout = idleTimeout
 	}
 
+	minWarm := a.minWarm(call)
+	call.slots.setMinWarm(minWarm)
+
 	logger := common.Logger(ctx)
-	logger.WithField("launcher_timeout", timeout).Debug("Hot function launcher starting")
+	logger.WithFields(logrus.Fields{"launcher_timeout": timeout, "min_warm": minWarm}).Debug("Hot function launcher starting")
 
 	// IMPORTANT: get a context that has a child span / logger but NO timeout
 	// TODO this is a 'FollowsFrom'
@@ -422,23 +509,77 @@ func (a *agent) hotLauncher(ctx context.Context, call *call, caller *slotCaller)
 		}
 	}()
 
+	// warm containers are relaunched, eg. after evictions, every HotPoll
+	var warmPoll <-chan time.Time
+	if minWarm > 0 {
+		ticker := time.NewTicker(a.cfg.HotPoll)
+		defer ticker.Stop()
+		warmPoll = ticker.C
+	}
+
+	calls := call.slots.callCount()
 	for {
 		ctx, cancel := context.WithTimeout(ctx, timeout)
 		a.checkLaunch(ctx, call, *caller)
 
-		select {
-		case <-ctx.Done(): // timed out
-			cancel()
-			if a.slotMgr.deleteSlotQueue(call.slots) {
-				logger.Debug("Hot function launcher timed out")
-				return
+		signalled := a.waitLaunch(ctx, call, warmPoll)
+		cancel()
+		if signalled != nil {
+			caller = signalled
+			call.slots.setMinWarm(minWarm)
+			continue
+		}
+
+		// timed out, calls taking idle containers do not signal, so the warm containers
+		// are kept as long as calls come in, and otherwise idle out before the slot queue
+		// is deleted
+		if minWarm > 0 {
+			if n := call.slots.callCount(); n != calls {
+				calls = n
+				continue
+			}
+			if call.slots.setMinWarm(0) > 0 {
+				continue
 			}
-		case caller = <-call.slots.signaller:
-			cancel()
+		}
+		if a.slotMgr.deleteSlotQueue(call.slots) {
+			logger.Debug("Hot function launcher timed out")
+			return
 		}
 	}
 }
 
+// waitLaunch waits for a call to signal hotLauncher, launching containers to keep warm on
+// every warmPoll meanwhile. Returns nil if ctx is done first.
+func (a *agent) waitLaunch(ctx context.Context, call *call, warmPoll <-chan time.Time) *slotCaller {
+	for {
+		select {
+		case caller := <-call.slots.signaller:
+			return caller
+		case <-warmPoll:
+			a.checkLaunch(ctx, call, slotCaller{})
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// minWarm returns the number of containers to keep warm for the calls of the slot queue of
+// call, from its min warm annotation up to MaxMinWarm, or else MinWarm
+func (a *agent) minWarm(call *call) uint64 {
+	if _, ok := call.Annotations.Get(models.MinWarmAnnotation); !ok {
+		return a.cfg.MinWarm
+	}
+	n, err := call.Annotations.MinWarm()
+	if err != nil {
+		return 0
+	}
+	if n > a.cfg.MaxMinWarm {
+		return a.cfg.MaxMinWarm
+	}
+	return n
+}
+
 func tryNotify(notifyChan chan error, err error) {
 	if notifyChan != nil && err != nil {
 		select {
@@ -452,6 +593,56 @@ func (a *agent) checkLaunch(ctx context.Context, call *call, caller slotCaller)
 	curStats := call.slots.getStats()
 	isBlocking := !a.cfg.EnableNBResourceTracker
 	if !isNewContainerNeeded(&curStats) {
+		if call.slots.isWarmNeeded() {
+			a.launchWarm(ctx, call)
+		}
+		return
+	}
+
+	mem := call.Memory + uint64(call.TmpFsSize)
+
+	// Containers launched on a host stalling for memory or CPU would slow down the containers
+	// running there. In blocking mode calls wait for the pressure to go down, otherwise they
+	// are rejected so that they can be placed elsewhere.
+	if reason, ok := a.pressureAdmission(); !ok {
+		if !isBlocking {
+			call.setRejectReason(reason)
+			tryNotify(caller.notify, models.ErrCallTimeoutServerBusy)
+		}
+		return
+	}
+
+	// Batch calls may not launch containers into the headroom kept for interactive calls.
+	// In blocking mode they wait for other containers to go away, otherwise they are
+	// rejected so that they can be placed elsewhere.
+	if call.Priority() == pool.PriorityBatch {
+		if reason, ok := a.batchAdmission(mem, call.CPUs); !ok {
+			if !isBlocking {
+				call.setRejectReason(reason)
+				tryNotify(caller.notify, models.ErrCallTimeoutServerBusy)
+			}
+			return
+		}
+	}
+
+	// Nor may they take up resources interactive calls wait for, or evict containers for
+	// them, likewise.
+	if !a.priorityAdmission(call, mem, call.CPUs) {
+		if !isBlocking {
+			call.setRejectReason(pool.RejectPriority)
+			tryNotify(caller.notify, models.ErrCallTimeoutServerBusy)
+		}
+		return
+	}
+
+	// Fns may not take up resources other fns with lower shares wait for. In blocking mode
+	// they wait for their share, otherwise they are rejected so that they can be placed
+	// elsewhere.
+	if !a.fairShareAdmission(call, mem, call.CPUs) {
+		if !isBlocking {
+			call.setRejectReason(pool.RejectFairShare)
+			tryNotify(caller.notify, models.ErrCallTimeoutServerBusy)
+		}
 		return
 	}
 
@@ -461,21 +652,19 @@ func (a *agent) checkLaunch(ctx context.Context, call *call, caller slotCaller)
 	state := NewContainerState()
 	state.UpdateState(ctx, ContainerStateWait, call)
 
-	mem := call.Memory + uint64(call.TmpFsSize)
-
 	var notifyChans []chan struct{}
 	var tok ResourceToken
 
-	// For blocking-mode, we wait on a channel for CPU/MEM for cfg.HotPoll duration.
+	// For blocking-mode, we wait on a channel for CPU/MEM/GPU for cfg.HotPoll duration.
 	// If the request is not satisfied during this wait, we perform a non-blocking
 	// GetResourceToken()) in an attempt to determine how much mem/cpu we need to evict.
 	if isBlocking {
 		ctx, cancel := context.WithTimeout(ctx, a.cfg.HotPoll)
-		tok = a.resources.GetResourceToken(ctx, mem, call.CPUs)
+		tok = a.resources.GetResourceToken(ctx, mem, call.CPUs, call.GPUs)
 		cancel()
 	}
 	if tok == nil {
-		tok = a.resources.GetResourceTokenNB(ctx, mem, call.CPUs)
+		tok = a.resources.GetResourceTokenNB(ctx, mem, call.CPUs, call.GPUs)
 	}
 
 	if tok != nil {
@@ -483,14 +672,22 @@ func (a *agent) checkLaunch(ctx context.Context, call *call, caller slotCaller)
 			if tok.Error() != CapacityFull {
 				tryNotify(caller.notify, tok.Error())
 			} else {
-				needMem, needCpu := tok.NeededCapacity()
-				notifyChans = a.evictor.PerformEviction(call.slotHashId, needMem, uint64(needCpu))
+				needMem, needCpu, needGpu := tok.NeededCapacity()
+				notifyChans = a.evictor.PerformEviction(call.slotHashId, needMem, uint64(needCpu), needGpu)
 				// For Non-blocking mode, if there's nothing to evict, we emit 503.
 				if len(notifyChans) == 0 && !isBlocking {
+					if needMem > 0 {
+						call.setRejectReason(pool.RejectMemory)
+					} else if needCpu > 0 {
+						call.setRejectReason(pool.RejectCPU)
+					} else {
+						call.setRejectReason(pool.RejectGPU)
+					}
 					tryNotify(caller.notify, models.ErrCallTimeoutServerBusy)
 				}
 			}
 		} else if a.shutWg.AddSession(1) {
+			tok = a.holdFairShare(call, mem, tok)
 			go func() {
 				// NOTE: runHot will not inherit the timeout from ctx (ignore timings)
 				a.runHot(ctx, caller, call, tok, state)
@@ -517,6 +714,84 @@ func (a *agent) checkLaunch(ctx context.Context, call *call, caller slotCaller)
 	}
 }
 
+// launchWarm launches a container to keep warm for the slot queue of call, if the host has
+// the resources. Unlike containers for waiting calls, it never evicts other containers.
+func (a *agent) launchWarm(ctx context.Context, call *call) {
+	if _, ok := a.pressureAdmission(); !ok {
+		return
+	}
+
+	mem := call.Memory + uint64(call.TmpFsSize)
+	tok := a.resources.GetResourceTokenNB(ctx, mem, call.CPUs, call.GPUs)
+	if tok.Error() != nil || !a.shutWg.AddSession(1) {
+		tok.Close()
+		return
+	}
+
+	tok = a.holdFairShare(call, mem, tok)
+	state := NewContainerState()
+	state.UpdateState(ctx, ContainerStateWait, call)
+	go func() {
+		// no call waits for the container, so failures are only logged
+		a.runHot(ctx, slotCaller{}, call, tok, state)
+		a.shutWg.DoneSession()
+	}()
+}
+
+// batchAdmission returns whether a container for a batch call with the given memory
+// and CPU fits outside of the configured batch headroom, and if not, which resource
+// it lacks
+func (a *agent) batchAdmission(mem uint64, cpus models.MilliCPUs) (pool.RejectReason, bool) {
+	if a.cfg.BatchHeadroom == 0 {
+		return pool.RejectUnknown, true
+	}
+
+	util := a.resources.GetUtilization()
+	memTotal := util.MemUsed + util.MemAvail
+	if util.MemUsed+mem > memTotal-memTotal/100*a.cfg.BatchHeadroom {
+		return pool.RejectMemory, false
+	}
+	cpuTotal := uint64(util.CpuUsed + util.CpuAvail)
+	if uint64(util.CpuUsed)+uint64(cpus) > cpuTotal-cpuTotal/100*a.cfg.BatchHeadroom {
+		return pool.RejectCPU, false
+	}
+	return pool.RejectUnknown, true
+}
+
+// priorityAdmission returns whether a container for call with the given memory and CPU may
+// take up resources. Free resources are granted to any call, otherwise batch calls are not
+// admitted while interactive calls wait for resources.
+func (a *agent) priorityAdmission(call *call, mem uint64, cpus models.MilliCPUs) bool {
+	util := a.resources.GetUtilization()
+	if mem <= util.MemAvail && cpus <= util.CpuAvail {
+		return true
+	}
+
+	now := time.Now().UnixNano()
+	if call.Priority() != pool.PriorityBatch {
+		atomic.StoreInt64(&a.interactiveWaited, now)
+		return true
+	}
+	return time.Duration(now-atomic.LoadInt64(&a.interactiveWaited)) > launchWaitPolls*a.cfg.HotPoll
+}
+
+// pressureAdmission returns whether containers may be launched with the memory and CPU
+// pressure of the host, and if not, RejectPressure
+func (a *agent) pressureAdmission() (pool.RejectReason, bool) {
+	if a.cfg.MaxMemoryPressure == 0 && a.cfg.MaxCPUPressure == 0 {
+		return pool.RejectUnknown, true
+	}
+
+	util := a.resources.GetUtilization()
+	if a.cfg.MaxMemoryPressure != 0 && util.MemPressure > float64(a.cfg.MaxMemoryPressure) {
+		return pool.RejectPressure, false
+	}
+	if a.cfg.MaxCPUPressure != 0 && util.CpuPressure > float64(a.cfg.MaxCPUPressure) {
+		return pool.RejectPressure, false
+	}
+	return pool.RejectUnknown, true
+}
+
 // waitHot pings and waits for a hot container from the slot queue
 func (a *agent) waitHot(ctx context.Context, call *call, caller *slotCaller) (Slot, error) {
 	ctx, span := trace.StartSpan(ctx, "agent_wait_hot")
@@ -525,7 +800,7 @@ func (a *agent) waitHot(ctx context.Context, call *call, caller *slotCaller) (Sl
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel() // shut down dequeuer if we grab a slot
 
-	ch := call.slots.startDequeuer(ctx)
+	ch := call.slots.startDequeuer(ctx, call.Priority())
 
 	// 1) if we can get a slot immediately, grab it.
 	// 2) if we don't, send a signaller every x msecs until we do.
@@ -648,16 +923,49 @@ func createUDSRequest(ctx context.Context, call *call) *http.Request {
 	return req
 }
 
+// createGRPCRequest returns the request of a gRPC call to the gRPC server of its container,
+// the path of the call request is the method called and its headers the metadata of the call
+func createGRPCRequest(ctx context.Context, call *call) *http.Request {
+	req, err := http.NewRequest("POST", "http://localhost"+call.req.URL.Path, call.req.Body)
+	if err != nil {
+		common.Logger(ctx).WithError(err).Error("somebody put a bad gRPC method in the call http request.")
+		panic(err)
+	}
+	req = req.WithContext(ctx)
+
+	// remove transport headers before passing to function, this keeps te: trailers
+	common.StripHopHeaders(call.req.Header)
+
+	req.Header = make(http.Header)
+	for k, vs := range call.req.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+
+	req.Header.Set("Fn-Call-Id", call.ID)
+	return req
+}
+
 func (s *hotSlot) dispatch(ctx context.Context, call *call) error {
 	ctx, span := trace.StartSpan(ctx, "agent_dispatch_httpstream")
 	defer span.End()
 
 	// TODO it's possible we can get rid of this (after getting rid of logs API) - may need for call id/debug mode still
 	// TODO there's a timeout race for swapping this back if the container doesn't get killed for timing out, and don't you forget it
-	swapBack := s.container.swap(call.stderr, &call.Stats)
-	defer swapBack()
+	// The logs and stats of containers taking concurrent calls cannot be told apart by call,
+	// so they are not swapped, and go to the runner logs (or syslog).
+	if s.container.concurrency == 1 {
+		swapBack := s.container.swap(call.stderr, &call.Stats)
+		defer swapBack()
+	}
 
-	req := createUDSRequest(ctx, call)
+	var req *http.Request
+	if call.protocol == models.ProtocolGRPC {
+		req = createGRPCRequest(ctx, call)
+	} else {
+		req = createUDSRequest(ctx, call)
+	}
 
 	var resp *http.Response
 	var err error
@@ -669,15 +977,22 @@ func (s *hotSlot) dispatch(ctx context.Context, call *call) error {
 	}
 
 	if err != nil {
-		// IMPORTANT: Container contract: If http-uds errors/timeout, container cannot continue
-		s.SetError(err)
 		// first filter out timeouts
 		if ctx.Err() == context.DeadlineExceeded {
+			// IMPORTANT: Container contract: If http-uds errors/timeout, container cannot continue
+			s.SetError(err)
 			return context.DeadlineExceeded
 		}
 		if strings.Contains(err.Error(), "server response headers exceeded ") {
+			s.SetError(err)
 			return models.ErrFunctionResponseHdrTooBig
 		}
+		// the container may have gone away, eg. out of memory, tell why before it is torn down
+		exitErr := s.container.waitExit(ctx, containerExitWait)
+		s.SetError(err)
+		if models.GetErrorCode(exitErr) == models.ErrorCodeFunctionOOM {
+			return exitErr
+		}
 		return models.ErrFunctionResponse
 	}
 	defer resp.Body.Close()
@@ -725,6 +1040,12 @@ func (s *hotSlot) writeResp(ctx context.Context, max uint64, resp *http.Response
 		return models.ErrFunctionInvalidResponse
 	}
 
+	// streaming responses are flushed to the client as the fn writes them
+	flusher, _ := rw.(http.Flusher)
+	if !IsStreamingContentType(resp.Header.Get("Content-Type")) {
+		flusher = nil
+	}
+
 	rw = newSizerRespWriter(max, rw)
 
 	// remove transport headers before copying to client response
@@ -741,10 +1062,43 @@ func (s *hotSlot) writeResp(ctx context.Context, max uint64, resp *http.Response
 	}
 	rw.WriteHeader(http.StatusOK)
 
-	_, ioErr := io.Copy(rw, resp.Body)
+	var ioErr error
+	if flusher != nil {
+		flusher.Flush()
+		ioErr = copyFlush(rw, flusher, resp.Body)
+	} else {
+		_, ioErr = io.Copy(rw, resp.Body)
+	}
+
+	// trailers are known once the body was read, eg. the status of gRPC responses
+	for k, vs := range resp.Trailer {
+		for _, v := range vs {
+			rw.Header().Add(http.TrailerPrefix+k, v)
+		}
+	}
 	return ioErr
 }
 
+// copyFlush copies src to w like io.Copy, flushing w after every read of src
+func copyFlush(w io.Writer, flusher http.Flusher, src io.Reader) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			flusher.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
 // XXX(reed): this is a remnant of old io.pipe plumbing, we need to get rid of
 // the buffers from the front-end in actuality, but only after removing other formats... so here, eat this
 type sizerRespWriter struct {
@@ -769,6 +1123,16 @@ func runHotFailure(ctx context.Context, err error, caller slotCaller) {
 	tryNotify(caller.notify, err)
 }
 
+// initTimeout returns how long a step of the cold start of a container for call, which began
+// at start, may take, def for the step in the config of the agent. The init timeout of call,
+// if set, bounds the whole cold start of image pull, container create and FDK init instead.
+func initTimeout(call *call, start time.Time, def time.Duration) time.Duration {
+	if call.InitTimeout <= 0 {
+		return def
+	}
+	return time.Duration(call.InitTimeout)*time.Second - time.Since(start)
+}
+
 func (a *agent) runHot(ctx context.Context, caller slotCaller, call *call, tok ResourceToken, state ContainerState) {
 	// IMPORTANT: get a context that has a child span / logger but NO timeout
 	// TODO this is a 'FollowsFrom'
@@ -784,7 +1148,7 @@ func (a *agent) runHot(ctx context.Context, caller slotCaller, call *call, tok R
 	ctrCreatePrepStart := time.Now()
 
 	id := id.New().String()
-	logger := logrus.WithFields(logrus.Fields{"container_id": id, "app_id": call.AppID, "fn_id": call.FnID, "image": call.Image, "memory": call.Memory, "cpus": call.CPUs, "idle_timeout": call.IdleTimeout})
+	logger := logrus.WithFields(logrus.Fields{"container_id": id, "app_id": call.AppID, "fn_id": call.FnID, "image": call.Image, "memory": call.Memory, "cpus": call.CPUs, "gpus": call.GPUs, "idle_timeout": call.IdleTimeout})
 	ctx, cancel := context.WithCancel(common.WithLogger(ctx, logger))
 
 	initialized := make(chan struct{}) // when closed, container is ready to handle requests
@@ -818,7 +1182,7 @@ func (a *agent) runHot(ctx context.Context, caller slotCaller, call *call, tok R
 			container.Close()
 		}
 
-		tok.Close() // release cpu/mem
+		tok.Close() // release cpu/mem/gpu
 
 		state.UpdateState(ctx, ContainerStateDone, call)
 		statsUtilization(ctx, a.resources.GetUtilization())
@@ -852,11 +1216,23 @@ func (a *agent) runHot(ctx context.Context, caller slotCaller, call *call, tok R
 		authToken = call.slots.getAuthToken()
 	}
 
-	container = newHotContainer(ctx, a.evictor, &caller, call, &a.cfg, id, authToken, udsWait)
+	container = newHotContainer(ctx, a.evictor, &caller, call, &a.cfg, tok.GPUs(), id, authToken, udsWait)
 	if container == nil {
 		return
 	}
 
+	err = a.expandConfig(ctx, call, container)
+	if err != nil {
+		runHotFailure(ctx, err, caller)
+		return
+	}
+
+	err = a.injectSecrets(ctx, call, container)
+	if err != nil {
+		runHotFailure(ctx, err, caller)
+		return
+	}
+
 	cookie, err = a.driver.CreateCookie(ctx, container)
 	if err != nil {
 		runHotFailure(ctx, err, caller)
@@ -867,7 +1243,7 @@ func (a *agent) runHot(ctx context.Context, caller slotCaller, call *call, tok R
 	atomic.StoreInt64(&call.ctrPrepTime, int64(time.Since(ctrCreatePrepStart)))
 	if needsPull {
 		waitStart := time.Now()
-		pullCtx, pullCancel := context.WithTimeout(ctx, a.cfg.HotPullTimeout)
+		pullCtx, pullCancel := context.WithTimeout(ctx, initTimeout(call, ctrCreatePrepStart, a.cfg.HotPullTimeout))
 		err = cookie.PullImage(pullCtx)
 		pullCancel()
 		if err != nil {
@@ -878,6 +1254,7 @@ func (a *agent) runHot(ctx context.Context, caller slotCaller, call *call, tok R
 			needsPull, err = cookie.ValidateImage(ctx) // uses original ctx timeout
 			if needsPull {
 				// Image must have removed by image cleaner, manual intervention, etc.
+				call.setRejectReason(pool.RejectImageNotCached)
 				err = models.ErrCallTimeoutServerBusy
 			}
 		}
@@ -914,7 +1291,7 @@ func (a *agent) runHot(ctx context.Context, caller slotCaller, call *call, tok R
 		// because monitoring go-routine may pick these events earlier and cancel the ctx.
 		initStart := time.Now()
 
-		timer := common.NewTimer(a.cfg.HotStartTimeout)
+		timer := common.NewTimer(initTimeout(call, ctrCreatePrepStart, a.cfg.HotStartTimeout))
 		defer timer.Stop()
 
 		// INIT BARRIER HERE. Wait for the initialization go-routine signal
@@ -943,31 +1320,58 @@ func (a *agent) runHot(ctx context.Context, caller slotCaller, call *call, tok R
 
 		timer.Stop() // no longer needed
 
-		for ctx.Err() == nil {
-			slot := &hotSlot{
-				done:          make(chan error, 1),
-				container:     container,
-				cfg:           &a.cfg,
-				containerSpan: trace.FromContext(ctx).SpanContext(),
+		// checkpoint the container before its first call, while it has no connections
+		if cp, ok := cookie.(drivers.Checkpointer); ok && a.cfg.CheckpointRestore {
+			if err := cp.Checkpoint(ctx); err != nil {
+				logger.WithError(err).Info("cannot checkpoint hot function")
 			}
+		}
 
-			if !a.runHotReq(ctx, call, state, logger, cookie, slot, container) {
-				return
-			}
+		a.hot.add(container, state)
+		defer a.hot.remove(container)
 
-			// wait for this call to finish
-			// NOTE do NOT select with shutdown / other channels. slot handles this.
-			if err := <-slot.done; err != nil {
-				logger.WithError(err).Info("hot function terminating")
-				return
-			}
+		// Each slot of the container takes one call at a time. Once a slot stops taking calls,
+		// eg. on idle timeout, the other slots finish their calls and stop too, but a call
+		// failing the container contract ends the container right away.
+		var wg sync.WaitGroup
+		for i := uint64(0); i < container.concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for ctx.Err() == nil && !container.isDraining() {
+					slot := &hotSlot{
+						done:          make(chan error, 1),
+						container:     container,
+						cfg:           &a.cfg,
+						containerSpan: trace.FromContext(ctx).SpanContext(),
+					}
+
+					if !a.runHotReq(ctx, call, state, logger, cookie, slot, container) {
+						return
+					}
+
+					// wait for this call to finish
+					// NOTE do NOT select with shutdown / other channels. slot handles this.
+					if err := <-slot.done; err != nil {
+						logger.WithError(err).Info("hot function terminating")
+						cancel()
+						return
+					}
+				}
+			}()
 		}
+		wg.Wait()
 	}()
 
 	runRes := waiter.Wait(ctx)
 	if runRes != nil && runRes.Error() != context.Canceled {
 		logger.WithError(runRes.Error()).Info("hot function terminated")
+		container.exitErr = runRes.Error()
+		if models.GetErrorCode(container.exitErr) == models.ErrorCodeFunctionOOM {
+			statsContainerOOM(ctx, call)
+		}
 	}
+	close(container.exited)
 }
 
 //checkSocketDestination verifies that the socket file created by the FDK is valid and permitted - notably verifying that any symlinks are relative to the socket dir
@@ -1071,20 +1475,28 @@ func (a *agent) runHotReq(ctx context.Context, call *call, state ContainerState,
 	var err error
 	isFrozen := false
 
-	freezeTimer := common.NewTimer(a.cfg.FreezeIdle)
-	idleTimer := common.NewTimer(time.Duration(call.IdleTimeout) * time.Second)
+	// containers taking concurrent calls are never frozen, as their other slots may be busy
+	freezeIdle := a.cfg.FreezeIdle
+	if c.concurrency > 1 {
+		freezeIdle = MaxMsDisabled
+	}
+	idleTimeout := time.Duration(call.IdleTimeout) * time.Second
+
+	freezeTimer := common.NewTimer(freezeIdle)
+	idleTimer := common.NewTimer(idleTimeout)
+	ageTimer := common.NewTimer(c.ageLeft(a.cfg.MaxContainerAge))
 
 	defer func() {
 		freezeTimer.Stop()
 		idleTimer.Stop()
+		ageTimer.Stop()
 		// log if any error is encountered
 		if err != nil {
 			logger.WithError(err).Error("hot function failure")
 		}
 	}()
 
-	state.UpdateState(ctx, ContainerStateIdle, call)
-	c.EnableEviction(call)
+	c.idleSlot(ctx, state, call)
 
 	s := call.slots.queueSlot(slot)
 
@@ -1096,8 +1508,22 @@ func (a *agent) runHotReq(ctx context.Context, call *call, state ContainerState,
 		case <-s.trigger: // slot already consumed
 		case <-ctx.Done(): // container shutdown
 		case <-a.shutWg.Closer(): // agent shutdown
+		case <-c.draining: // another slot stopped taking calls
 		case <-idleTimer.C:
+			if call.slots.keepWarm() {
+				idleTimer.Reset(idleTimeout)
+				continue
+			}
+			// the container idles out once none of its slots took a call for the idle timeout
+			if left := c.idleLeft(idleTimeout); left > 0 {
+				idleTimer.Reset(left)
+				continue
+			}
 		case <-freezeTimer.C:
+			if c.isProfiling() {
+				freezeTimer.Reset(freezeIdle)
+				continue
+			}
 			if !isFrozen {
 				ctx, cancel := context.WithTimeout(ctx, pauseTimeout)
 				err = cookie.Freeze(ctx)
@@ -1109,6 +1535,8 @@ func (a *agent) runHotReq(ctx context.Context, call *call, state ContainerState,
 				state.UpdateState(ctx, ContainerStatePaused, call)
 			}
 			continue
+		case <-ageTimer.C: // container retired
+			logger.Info("hot function reached its max age")
 		case <-evicted:
 		}
 		break
@@ -1123,12 +1551,13 @@ func (a *agent) runHotReq(ctx context.Context, call *call, state ContainerState,
 			statsContainerEvicted(ctx, state.GetState())
 		default:
 		}
+		c.drain()
 		return false
 	}
 
 	// We disable eviction after acquisition attempt above, since
 	// this can reinstall an eviction token if eviction has taken place.
-	c.DisableEviction(call)
+	c.busySlot(ctx, state, call)
 
 	// In case, timer/acquireSlot failure landed us here, make
 	// sure to unfreeze.
@@ -1142,7 +1571,6 @@ func (a *agent) runHotReq(ctx context.Context, call *call, state ContainerState,
 		isFrozen = false
 	}
 
-	state.UpdateState(ctx, ContainerStateBusy, call)
 	return true
 }
 
@@ -1164,7 +1592,18 @@ type container struct {
 	pendingSignals *uint64
 	messageQueue   *uint64
 	tmpFsSize      uint64
+	readOnlyRootFs bool
+	volumes        [][2]string
+	sidecars       []drivers.Sidecar
+	dns            drivers.DNS
+	egress         drivers.Egress
+	imageKeys      []string
+	platform       string
+	blkio          drivers.BlockIO
 	disableNet     bool
+	runtime        string
+	runtimeClass   string
+	gpus           []string
 	iofs           iofs
 	logCfg         drivers.LoggerConfig
 	close          func()
@@ -1183,12 +1622,44 @@ type container struct {
 
 	evictor    Evictor
 	evictToken *EvictToken
+
+	// concurrency is the number of slots of the container, each taking one call at a time.
+	// slotsMu protects the idle slots, when a call last took a slot, and the eviction token.
+	concurrency uint64
+	slotsMu     sync.Mutex
+	idleSlots   uint64
+	lastUsed    time.Time
+	drainOnce   sync.Once
+	draining    chan struct{}
+
+	// the container is retired once its slots took maxCalls calls, if not 0
+	maxCalls  uint64
+	calls     uint64
+	startedAt time.Time
+
+	// fn of the container and the number of profiles being collected from it, see
+	// agent.ProfileContainer
+	fnID      string
+	profiling int32
+
+	// exited is closed once the container exits, with exitErr the error it exited with
+	exited  chan struct{}
+	exitErr error
 }
 
 var _ drivers.ContainerTask = &container{}
 
+// fsSize returns the file system size of the container of call in MB, its fn's size
+// capped by the max of the agent, zero if neither is set
+func fsSize(cfg *Config, call *call) uint64 {
+	if call.FsSize == 0 || (cfg.MaxFsSize != 0 && call.FsSize > cfg.MaxFsSize) {
+		return cfg.MaxFsSize
+	}
+	return call.FsSize
+}
+
 // newHotContainer creates a container that can be used for multiple sequential events
-func newHotContainer(ctx context.Context, evictor Evictor, caller *slotCaller, call *call, cfg *Config, id, authToken string, udsWait chan error) *container {
+func newHotContainer(ctx context.Context, evictor Evictor, caller *slotCaller, call *call, cfg *Config, gpus []string, id, authToken string, udsWait chan error) *container {
 
 	var iofs iofs
 	var err error
@@ -1233,9 +1704,14 @@ func newHotContainer(ctx context.Context, evictor Evictor, caller *slotCaller, c
 		bufs = append(bufs, buf1)
 	}
 
-	baseTransport := &http.Transport{
-		MaxIdleConns:           1,
-		MaxIdleConnsPerHost:    1,
+	concurrency := call.Concurrency
+	if concurrency == 0 {
+		concurrency = 1
+	}
+
+	var baseTransport udsTransport = &http.Transport{
+		MaxIdleConns:           int(concurrency),
+		MaxIdleConnsPerHost:    int(concurrency),
 		MaxResponseHeaderBytes: int64(cfg.MaxHdrResponseSize),
 		IdleConnTimeout:        1 * time.Second, // TODO(jang): revert this to 120s at the point all FDKs are known to be fixed
 		// TODO(reed): since we only allow one, and we close them, this is gratuitous?
@@ -1244,9 +1720,43 @@ func newHotContainer(ctx context.Context, evictor Evictor, caller *slotCaller, c
 			return d.DialContext(ctx, "unix", filepath.Join(iofs.AgentPath(), udsFilename))
 		},
 	}
+	if call.protocol == models.ProtocolGRPC {
+		// gRPC servers speak HTTP/2 without TLS on the socket, all calls share a connection
+		baseTransport = &http2.Transport{
+			AllowHTTP:         true,
+			MaxHeaderListSize: uint32(cfg.MaxHdrResponseSize),
+			DialTLS: func(_, _ string, _ *tls.Config) (net.Conn, error) {
+				return net.Dial("unix", filepath.Join(iofs.AgentPath(), udsFilename))
+			},
+		}
+	}
 
 	env := cloneStrMap(call.Config) // clone to avoid data race
 
+	// annotations are validated with the app and fn
+	runtime, _ := call.Annotations.Runtime()
+	runtimeClass, _ := call.Annotations.RuntimeClass()
+	var dns drivers.DNS
+	if d, _ := call.Annotations.DNS(); d != nil {
+		dns = drivers.DNS{Servers: d.Servers, Search: d.Search, Hosts: d.Hosts}
+	}
+	var egress drivers.Egress
+	if e, _ := call.Annotations.Egress(); e != nil {
+		egress = drivers.Egress{Disabled: e.Disabled, Allow: e.Allow}
+	}
+	var imageKeys []string
+	if sig, _ := call.Annotations.ImageSignature(); sig != nil {
+		imageKeys = sig.Keys
+	}
+	var platform string
+	if p, _ := call.Annotations.Platform(); p != nil {
+		platform = p.String()
+	}
+	var blkio drivers.BlockIO
+	if b, _ := call.Annotations.BlockIO(); b != nil {
+		blkio = drivers.BlockIO{Weight: b.Weight, ReadBps: b.ReadBps, WriteBps: b.WriteBps, ReadIOps: b.ReadIOps, WriteIOps: b.WriteIOps}
+	}
+
 	// Debug info exposed to FDK/Container
 	if cfg.EnableFDKDebugInfo {
 		if caller != nil {
@@ -1261,14 +1771,30 @@ func newHotContainer(ctx context.Context, evictor Evictor, caller *slotCaller, c
 		extensions:     cloneStrMap(call.extensions), // avoid date race
 		memory:         call.Memory,
 		cpus:           uint64(call.CPUs),
-		fsSize:         cfg.MaxFsSize,
+		fsSize:         fsSize(cfg, call),
 		pids:           uint64(cfg.MaxPIDs),
 		openFiles:      cfg.MaxOpenFiles,
 		lockedMemory:   cfg.MaxLockedMemory,
 		pendingSignals: cfg.MaxPendingSignals,
 		messageQueue:   cfg.MaxMessageQueue,
 		tmpFsSize:      uint64(call.TmpFsSize),
+		readOnlyRootFs: call.ReadOnlyRootFs,
 		disableNet:     call.disableNet,
+		runtime:        runtime,
+		runtimeClass:   runtimeClass,
+		gpus:           gpus,
+		sidecars:       call.sidecars,
+		dns:            dns,
+		egress:         egress,
+		imageKeys:      imageKeys,
+		platform:       platform,
+		blkio:          blkio,
+		concurrency:    concurrency,
+		draining:       make(chan struct{}),
+		maxCalls:       cfg.MaxContainerCalls,
+		startedAt:      time.Now(),
+		fnID:           call.FnID,
+		exited:         make(chan struct{}),
 		iofs:           iofs,
 		dockerAuth:     call.dockerAuth,
 		authToken:      authToken,
@@ -1305,6 +1831,12 @@ func newHotContainer(ctx context.Context, evictor Evictor, caller *slotCaller, c
 	}
 }
 
+// udsTransport is the transport of the requests of calls to their container
+type udsTransport interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
 var _ propagation.HTTPFormat = noopOCHTTPFormat{}
 
 // we do not want to pass these to the user functions, since they're our internal traces...
@@ -1343,7 +1875,7 @@ func (c *container) Id() string                         { return c.id }
 func (c *container) Command() string                    { return "" }
 func (c *container) Input() io.Reader                   { return common.NoopReadWriteCloser{} }
 func (c *container) Logger() (io.Writer, io.Writer)     { return c.stderr, c.stderr }
-func (c *container) Volumes() [][2]string               { return nil }
+func (c *container) Volumes() [][2]string               { return c.volumes }
 func (c *container) WorkDir() string                    { return "" }
 func (c *container) Image() string                      { return c.image }
 func (c *container) EnvVars() map[string]string         { return c.env }
@@ -1356,12 +1888,22 @@ func (c *container) LockedMemory() *uint64              { return c.lockedMemory
 func (c *container) PendingSignals() *uint64            { return c.pendingSignals }
 func (c *container) MessageQueue() *uint64              { return c.messageQueue }
 func (c *container) TmpFsSize() uint64                  { return c.tmpFsSize }
+func (c *container) ReadOnlyRootFs() bool               { return c.readOnlyRootFs }
 func (c *container) Extensions() map[string]string      { return c.extensions }
 func (c *container) LoggerConfig() drivers.LoggerConfig { return c.logCfg }
 func (c *container) UDSAgentPath() string               { return c.iofs.AgentPath() }
 func (c *container) UDSDockerPath() string              { return c.iofs.DockerPath() }
 func (c *container) UDSDockerDest() string              { return iofsDockerMountDest }
 func (c *container) DisableNet() bool                   { return c.disableNet }
+func (c *container) Runtime() string                    { return c.runtime }
+func (c *container) RuntimeClass() string               { return c.runtimeClass }
+func (c *container) GPUs() []string                     { return c.gpus }
+func (c *container) Sidecars() []drivers.Sidecar        { return c.sidecars }
+func (c *container) DNS() drivers.DNS                   { return c.dns }
+func (c *container) Egress() drivers.Egress             { return c.egress }
+func (c *container) ImageKeys() []string                { return c.imageKeys }
+func (c *container) Platform() string                   { return c.platform }
+func (c *container) BlockIO() drivers.BlockIO           { return c.blkio }
 
 // WriteStat publishes each metric in the specified Stats structure as a histogram metric
 func (c *container) WriteStat(ctx context.Context, stat driver_stats.Stat) {
@@ -1378,10 +1920,94 @@ func (c *container) WriteStat(ctx context.Context, stat driver_stats.Stat) {
 	c.swapMu.Unlock()
 }
 
+// idleSlot marks a slot of the container idle. The container is idle while any of its slots
+// is, and may be evicted once all of them are.
+func (c *container) idleSlot(ctx context.Context, state ContainerState, call *call) {
+	c.slotsMu.Lock()
+	defer c.slotsMu.Unlock()
+	c.idleSlots++
+	state.UpdateState(ctx, ContainerStateIdle, call)
+	if c.idleSlots == c.concurrency {
+		c.EnableEviction(call)
+	}
+}
+
+// busySlot marks a slot of the container taken by a call. The container is busy once all of
+// its slots are.
+func (c *container) busySlot(ctx context.Context, state ContainerState, call *call) {
+	c.slotsMu.Lock()
+	defer c.slotsMu.Unlock()
+	if c.idleSlots == c.concurrency {
+		c.DisableEviction(call)
+	}
+	c.idleSlots--
+	c.lastUsed = time.Now()
+	if c.idleSlots == 0 {
+		state.UpdateState(ctx, ContainerStateBusy, call)
+	}
+	c.calls++
+	if c.maxCalls != 0 && c.calls >= c.maxCalls {
+		// this is its last call
+		common.Logger(ctx).Info("hot function reached its max calls")
+		c.drain()
+	}
+}
+
+// ageLeft returns how long until the container reaches maxAge and is retired, zero or less
+// once it did. Containers without maxAge are never retired.
+func (c *container) ageLeft(maxAge time.Duration) time.Duration {
+	if maxAge == 0 || maxAge == MaxMsDisabled {
+		return MaxMsDisabled
+	}
+	return maxAge - time.Since(c.startedAt)
+}
+
+// idleLeft returns how long until the container idles out, after idleTimeout without any
+// slot taking a call, zero or less once it did
+func (c *container) idleLeft(idleTimeout time.Duration) time.Duration {
+	c.slotsMu.Lock()
+	defer c.slotsMu.Unlock()
+	if c.idleSlots < c.concurrency {
+		return idleTimeout // other slots are busy
+	}
+	if c.lastUsed.IsZero() {
+		return 0
+	}
+	return idleTimeout - time.Since(c.lastUsed)
+}
+
+// drain makes the slots of the container stop taking calls, once their calls finish
+func (c *container) drain() {
+	c.drainOnce.Do(func() { close(c.draining) })
+}
+
+// waitExit returns the error the container exited with, waiting at most wait for it to
+// exit, nil if it is still running
+func (c *container) waitExit(ctx context.Context, wait time.Duration) error {
+	timer := common.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-c.exited:
+		return c.exitErr
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	return nil
+}
+
+func (c *container) isDraining() bool {
+	select {
+	case <-c.draining:
+		return true
+	default:
+		return false
+	}
+}
+
 // EnableEviction allows container eviction
 func (c *container) EnableEviction(call *call) {
 	if c.evictToken == nil {
-		c.evictToken = c.evictor.CreateEvictToken(call.slotHashId, call.Memory+uint64(call.TmpFsSize), uint64(call.CPUs))
+		c.evictToken = c.evictor.CreateEvictToken(call.slotHashId, call.Memory+uint64(call.TmpFsSize), uint64(call.CPUs), call.GPUs)
 	}
 	c.evictToken.SetEvictable(true)
 }
@@ -1402,7 +2028,7 @@ func (c *container) DisableEviction(call *call) {
 	}
 
 	c.evictor.DeleteEvictToken(c.evictToken)
-	c.evictToken = c.evictor.CreateEvictToken(call.slotHashId, call.Memory+uint64(call.TmpFsSize), uint64(call.CPUs))
+	c.evictToken = c.evictor.CreateEvictToken(call.slotHashId, call.Memory+uint64(call.TmpFsSize), uint64(call.CPUs), call.GPUs)
 }
 
 // Close closes container and releases resources associated with it
@@ -1448,6 +2074,8 @@ func (c *container) WrapAfterCall(wrapper func(after drivers.AfterCall) drivers.
 // GetEvictChan returns a channel that closes if an eviction occurs. Do not
 // refer to the same channel after calls to EnableEviction/DisableEviction flags or Close().
 func (c *container) GetEvictChan() chan struct{} {
+	c.slotsMu.Lock()
+	defer c.slotsMu.Unlock()
 	if c.evictToken != nil {
 		return c.evictToken.C
 	}
@@ -15,6 +15,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 	"sync"
@@ -278,6 +279,157 @@ func TestGetCallFromModelRoundTripACall(t *testing.T) {
 	}
 }
 
+func TestMinWarm(t *testing.T) {
+	a := &agent{cfg: Config{MinWarm: 1, MaxMinWarm: 3}}
+
+	c := &call{Call: &models.Call{Annotations: models.EmptyAnnotations()}}
+	if n := a.minWarm(c); n != 1 {
+		t.Fatalf("Expected the min warm of the config without annotation, got %d", n)
+	}
+
+	for val, expected := range map[int]uint64{0: 0, 2: 2, 5: 3} {
+		c.Annotations, _ = models.EmptyAnnotations().With(models.MinWarmAnnotation, val)
+		if n := a.minWarm(c); n != expected {
+			t.Fatalf("Expected %d warm containers for annotation %d, got %d", expected, val, n)
+		}
+	}
+}
+
+func TestContainerSlots(t *testing.T) {
+	ctx := context.Background()
+	c := &container{evictor: NewEvictor(), concurrency: 2, draining: make(chan struct{})}
+	call := &call{Call: &models.Call{Memory: 128}, slotHashId: "slots"}
+	call.slots = NewSlotQueue(call.slotHashId)
+	state := NewContainerState()
+
+	c.idleSlot(ctx, state, call)
+	if state.GetState() != "idle" || c.GetEvictChan() != nil {
+		t.Fatalf("Expected an idle container that cannot be evicted, got %s", state.GetState())
+	}
+
+	c.idleSlot(ctx, state, call)
+	if c.GetEvictChan() == nil {
+		t.Fatalf("Expected a container with all slots idle to be evictable")
+	}
+	if c.idleLeft(time.Second) != 0 {
+		t.Fatalf("Expected a container that never took a call to idle out")
+	}
+
+	c.busySlot(ctx, state, call)
+	if state.GetState() != "idle" || c.idleLeft(time.Second) != time.Second {
+		t.Fatalf("Expected an idle container with a busy slot, got %s", state.GetState())
+	}
+	c.busySlot(ctx, state, call)
+	if state.GetState() != "busy" {
+		t.Fatalf("Expected a busy container, got %s", state.GetState())
+	}
+
+	c.idleSlot(ctx, state, call)
+	c.idleSlot(ctx, state, call)
+	if left := c.idleLeft(time.Minute); left <= 0 || left > time.Minute {
+		t.Fatalf("Expected a container used lately not to idle out, got %v", left)
+	}
+
+	if c.isDraining() {
+		t.Fatalf("Expected a container taking calls")
+	}
+	c.drain()
+	c.drain()
+	if !c.isDraining() {
+		t.Fatalf("Expected a draining container")
+	}
+	c.Close()
+}
+
+func TestContainerRecycling(t *testing.T) {
+	ctx := context.Background()
+	c := &container{evictor: NewEvictor(), concurrency: 1, draining: make(chan struct{}), maxCalls: 2, startedAt: time.Now().Add(-time.Minute)}
+	call := &call{Call: &models.Call{Memory: 128}, slotHashId: "slots"}
+	call.slots = NewSlotQueue(call.slotHashId)
+	state := NewContainerState()
+
+	c.idleSlot(ctx, state, call)
+	c.busySlot(ctx, state, call)
+	if c.isDraining() {
+		t.Fatalf("Expected a container that took 1 of its 2 calls to take calls")
+	}
+	c.idleSlot(ctx, state, call)
+	c.busySlot(ctx, state, call)
+	if !c.isDraining() {
+		t.Fatalf("Expected a container that took its 2 calls to be retired")
+	}
+
+	if left := c.ageLeft(0); left != MaxMsDisabled {
+		t.Fatalf("Expected a container without max age never to be retired, got %v", left)
+	}
+	if left := c.ageLeft(time.Hour); left <= 0 || left > 59*time.Minute {
+		t.Fatalf("Expected a container a minute old to be retired within 59 minutes, got %v", left)
+	}
+	if left := c.ageLeft(time.Second); left > 0 {
+		t.Fatalf("Expected a container past its max age to be retired, got %v", left)
+	}
+	c.Close()
+}
+
+func TestContainerProfile(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/debug/pprof/profile":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprintf(w, "cpu %s", r.URL.Query().Get("seconds"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return net.Dial("tcp", srv.Listener.Addr().String())
+	}
+	newContainer := func(id string) *container {
+		return &container{id: id, fnID: "fn", udsClient: http.Client{Transport: &http.Transport{DialContext: dial}}}
+	}
+	call := &call{Call: &models.Call{Memory: 128}, slotHashId: "slots"}
+	call.slots = NewSlotQueue(call.slotHashId)
+
+	a := &agent{}
+	idle, busy, paused := newContainer("idle"), newContainer("busy"), newContainer("paused")
+	for c, s := range map[*container]ContainerStateType{idle: ContainerStateIdle, busy: ContainerStateBusy, paused: ContainerStatePaused} {
+		state := NewContainerState()
+		state.UpdateState(ctx, s, call)
+		a.hot.add(c, state)
+	}
+
+	prof, err := a.profileContainer(ctx, "fn", ProfileRequest{Type: ProfileCPU, Seconds: 1})
+	if err != nil || prof.ContainerID != "busy" || string(prof.Data) != "cpu 1" {
+		t.Fatalf("Expected a CPU profile of the busy container, got %+v %v", prof, err)
+	}
+	if busy.isProfiling() {
+		t.Fatalf("Expected the profile of the container to end")
+	}
+
+	a.hot.remove(busy)
+	if c := a.hot.startProfile("fn"); c != idle || !c.isProfiling() {
+		t.Fatalf("Expected the idle container to be profiled rather than the paused one")
+	}
+	a.hot.endProfile(idle)
+	if _, err := a.profileContainer(ctx, "fn", ProfileRequest{Type: ProfileHeap}); err != ErrProfileUnsupported {
+		t.Fatalf("Expected a container without heap profiles, got %v", err)
+	}
+
+	a.hot.remove(idle)
+	if _, err := a.profileContainer(ctx, "fn", ProfileRequest{Type: ProfileHeap}); err != ErrFnContainerNotFound {
+		t.Fatalf("Expected no container to profile, got %v", err)
+	}
+	if _, err := a.profileContainer(ctx, "fn", ProfileRequest{Type: ProfileCPU}); err != ErrInvalidProfileSeconds {
+		t.Fatalf("Expected invalid profile seconds, got %v", err)
+	}
+	if _, err := a.profileContainer(ctx, "fn", ProfileRequest{Type: "block"}); err != ErrInvalidProfileType {
+		t.Fatalf("Expected invalid profile type, got %v", err)
+	}
+}
+
 func TestLoggerIsStringerAndWorks(t *testing.T) {
 	// TODO test limit writer, logrus writer, etc etc
 
@@ -465,6 +617,54 @@ func TestHungFDK(t *testing.T) {
 	}
 }
 
+// TestFnInitTimeout checks that the init timeout of a fn bounds the cold start of its
+// containers instead of the start timeout of the agent
+func TestFnInitTimeout(t *testing.T) {
+	app := &models.App{ID: "app_id"}
+	initTimeout := int32(2)
+	fn := &models.Fn{
+		ID:     "fn_id",
+		Image:  "fnproject/fn-test-utils",
+		Config: models.Config{"ENABLE_INIT_DELAY_MSEC": "5000"},
+		ResourceConfig: models.ResourceConfig{
+			Timeout:     5,
+			IdleTimeout: 10,
+			InitTimeout: &initTimeout,
+			Memory:      128,
+		},
+	}
+
+	url := "http://127.0.0.1:8080/invoke/" + fn.ID
+
+	cfg, err := NewConfig()
+	cfg.HotStartTimeout = time.Duration(30) * time.Second
+	a := New(WithConfig(cfg))
+	defer checkClose(t, a)
+
+	req, err := http.NewRequest("GET", url, &dummyReader{Reader: strings.NewReader(`{}`)})
+	if err != nil {
+		t.Fatal("unexpected error building request", err)
+	}
+
+	var out bytes.Buffer
+	callI, err := a.GetCall(FromHTTPFnRequest(app, fn, req), WithWriter(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := callI.Model(); c.InitTimeout != initTimeout {
+		t.Fatalf("expected the call to get the init timeout of the fn %d, got %d", initTimeout, c.InitTimeout)
+	}
+
+	start := time.Now()
+	err = a.Submit(callI)
+	if err != models.ErrContainerInitTimeout {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("expected the init timeout of the fn to end the cold start, took %s", d)
+	}
+}
+
 func TestDockerPullHungRepo(t *testing.T) {
 	hung, cancel := context.WithCancel(context.Background())
 	garbageServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -698,6 +898,48 @@ func TestGetCallReturnsResourceImpossibility(t *testing.T) {
 	}
 }
 
+func TestGetCallFnCPUs(t *testing.T) {
+	app := &models.App{ID: "app_id"}
+	cpus := models.MilliCPUs(500)
+	fn := &models.Fn{
+		ID:    "fn_id",
+		Image: "fnproject/fn-test-utils",
+		ResourceConfig: models.ResourceConfig{
+			Timeout:     5,
+			IdleTimeout: 10,
+			Memory:      128,
+			CPUs:        &cpus,
+		},
+	}
+
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("bad config %+v", cfg)
+	}
+	cfg.MaxTotalCPU = 1000
+	a := New(WithConfig(cfg))
+	defer checkClose(t, a)
+
+	req, err := http.NewRequest("GET", "http://127.0.0.1:8080/invoke/"+fn.ID, nil)
+	if err != nil {
+		t.Fatal("unexpected error building request", err)
+	}
+	callI, err := a.GetCall(FromHTTPFnRequest(app, fn, req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := callI.Model(); c.CPUs != cpus {
+		t.Fatalf("expected the call to get the cpus of the fn %s, got %s", cpus, c.CPUs)
+	}
+
+	// the cpus of the fn are reserved from the ones of the runner
+	cpus = 2000
+	_, err = a.GetCall(FromHTTPFnRequest(app, fn, req))
+	if err != models.ErrCallResourceTooBig {
+		t.Fatal("did not get expected err, got: ", err)
+	}
+}
+
 //
 // Tmp directory should be RW by default.
 //
@@ -1287,7 +1529,7 @@ func TestDockerAuthExtn(t *testing.T) {
 
 	errC := make(chan error, 10)
 
-	c := newHotContainer(ctx, nil, nil, call, cfg, id.New().String(), "", errC)
+	c := newHotContainer(ctx, nil, nil, call, cfg, nil, id.New().String(), "", errC)
 	if c == nil {
 		err := <-errC
 		t.Fatal("got unexpected err: ", err)
@@ -1300,7 +1542,7 @@ func TestDockerAuthExtn(t *testing.T) {
 		t.Fatal("got unexpected err: ", err)
 	}
 
-	c = newHotContainer(ctx, nil, nil, call, cfg, id.New().String(), "TestRegistryToken", errC)
+	c = newHotContainer(ctx, nil, nil, call, cfg, nil, id.New().String(), "TestRegistryToken", errC)
 	if c == nil {
 		err := <-errC
 		t.Fatal("got unexpected err: ", err)
@@ -1408,7 +1650,7 @@ func TestContainerDisableIO(t *testing.T) {
 
 	errC := make(chan error, 10)
 
-	c := newHotContainer(ctx, nil, nil, call, cfg, id.New().String(), "", errC)
+	c := newHotContainer(ctx, nil, nil, call, cfg, nil, id.New().String(), "", errC)
 	if c == nil {
 		err := <-errC
 		t.Fatal("got unexpected err: ", err)
@@ -1437,6 +1679,62 @@ func TestContainerDisableIO(t *testing.T) {
 	}
 }
 
+func TestContainerFsLimits(t *testing.T) {
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("bad config %+v", cfg)
+	}
+	call := &call{Call: &models.Call{
+		ID:             id.New().String(),
+		Image:          "fnproject/fn-test-utils",
+		TmpFsSize:      32,
+		FsSize:         512,
+		ReadOnlyRootFs: true,
+	}}
+
+	errC := make(chan error, 10)
+	checkFs := func(maxFsSize, fsSize uint64) {
+		cfg.MaxFsSize = maxFsSize
+		c := newHotContainer(context.TODO(), nil, nil, call, cfg, nil, id.New().String(), "", errC)
+		if c == nil {
+			t.Fatal("got unexpected err: ", <-errC)
+		}
+		defer c.Close()
+		if c.FsSize() != fsSize || c.TmpFsSize() != 32 || !c.ReadOnlyRootFs() {
+			t.Fatalf("expected fs size %d, tmpfs size 32 and a read only root fs, got %d, %d and %v", fsSize, c.FsSize(), c.TmpFsSize(), c.ReadOnlyRootFs())
+		}
+	}
+
+	// the fs size of the fn is capped by the max of the agent
+	checkFs(0, 512)
+	checkFs(1024, 512)
+	checkFs(256, 256)
+}
+
+func TestContainerDNS(t *testing.T) {
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("bad config %+v", cfg)
+	}
+	dns := &models.DNS{Servers: []string{"10.0.0.2"}, Search: []string{"svc.internal"}, Hosts: map[string]string{"db.internal": "10.0.0.5"}}
+	annotations, err := models.EmptyAnnotations().With(models.DNSAnnotation, dns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := &call{Call: &models.Call{ID: id.New().String(), Image: "fnproject/fn-test-utils", Annotations: annotations}}
+
+	errC := make(chan error, 10)
+	c := newHotContainer(context.TODO(), nil, nil, call, cfg, nil, id.New().String(), "", errC)
+	if c == nil {
+		t.Fatal("got unexpected err: ", <-errC)
+	}
+	defer c.Close()
+	want := drivers.DNS{Servers: dns.Servers, Search: dns.Search, Hosts: dns.Hosts}
+	if !reflect.DeepEqual(c.DNS(), want) {
+		t.Fatalf("expected dns %+v, got %+v", want, c.DNS())
+	}
+}
+
 func TestSlotErrorRetention(t *testing.T) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10*time.Second))
@@ -8,13 +8,16 @@ import (
 	"net/http"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/fnproject/fn/api/agent/drivers"
 	"github.com/fnproject/fn/api/agent/drivers/docker"
 	"github.com/fnproject/fn/api/agent/drivers/stats"
 	"github.com/fnproject/fn/api/common"
 	"github.com/fnproject/fn/api/id"
 	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
 	"github.com/sirupsen/logrus"
 	"go.opencensus.io/trace"
 )
@@ -60,13 +63,18 @@ func FromHTTPFnRequest(app *models.App, fn *models.Fn, req *http.Request) CallOp
 			ID:    id,
 			Image: fn.Image,
 			// Delay: 0,
-			Type:        models.TypeSync,
-			Timeout:     fn.Timeout,
-			IdleTimeout: fn.IdleTimeout,
-			TmpFsSize:   0, // TODO clean up this
-			Memory:      fn.Memory,
-			CPUs:        0, // TODO clean up this
-			Config:      buildConfig(app, fn),
+			Type:           models.TypeSync,
+			Timeout:        fn.Timeout,
+			IdleTimeout:    fn.IdleTimeout,
+			InitTimeout:    fn.InitTimeoutSecs(),
+			TmpFsSize:      uint32(fn.TmpFsSizeMB()),
+			FsSize:         fn.FsSizeMB(),
+			ReadOnlyRootFs: fn.IsReadOnlyRootFs(),
+			Memory:         fn.Memory,
+			CPUs:           fn.CPUQuota(),
+			GPUs:           fn.GPUCount(),
+			Concurrency:    fn.ContainerConcurrency(),
+			Config:         buildConfig(app, fn),
 			// TODO - this wasn't really the intention here (that annotations would naturally cascade
 			// but seems to be necessary for some runner behaviour
 			Annotations: app.Annotations.MergeChange(fn.Annotations),
@@ -156,8 +164,11 @@ func FromModelAndInput(mCall *models.Call, in io.ReadCloser) CallOpt {
 // TODO consider removal, this is from a shuffle
 func WithTrigger(t *models.Trigger) CallOpt {
 	return func(c *call) error {
-		// right now just set the trigger id
 		c.TriggerID = t.ID
+		// annotations are validated with the trigger
+		if priority, _ := t.Annotations.CallPriority(); priority != "" {
+			c.priority = pool.CallPriority(priority)
+		}
 		return nil
 	}
 }
@@ -187,6 +198,15 @@ func InvokeDetached() CallOpt {
 	}
 }
 
+// InvokeStream marks a call to be a stream call. The request body streams to the
+// function while it writes its response, which it must start before reading the body.
+func InvokeStream() CallOpt {
+	return func(c *call) error {
+		c.Model().Type = models.TypeStream
+		return nil
+	}
+}
+
 // WithContext overrides the context on the call
 func WithContext(ctx context.Context) CallOpt {
 	return func(c *call) error {
@@ -204,6 +224,15 @@ func WithExtensions(extensions map[string]string) CallOpt {
 	}
 }
 
+// WithPriority sets the priority class of the call, which otherwise follows from
+// its trigger or its type, see Priority
+func WithPriority(priority pool.CallPriority) CallOpt {
+	return func(c *call) error {
+		c.priority = priority
+		return nil
+	}
+}
+
 // WithDockerAuth configures a call to retrieve credentials for an image pull
 func WithDockerAuth(auth docker.Auther) CallOpt {
 	return func(c *call) error {
@@ -244,15 +273,30 @@ func (a *agent) GetCall(opts ...CallOpt) (Call, error) {
 	}
 
 	mem := c.Memory + uint64(c.TmpFsSize)
-	if !a.resources.IsResourcePossible(mem, c.CPUs) {
+	if !a.resources.IsResourcePossible(mem, c.CPUs, c.GPUs) {
 		return nil, models.ErrCallResourceTooBig
 	}
 
+	protocol, err := c.Annotations.Protocol()
+	if err != nil {
+		return nil, err
+	}
+	c.protocol = protocol
+
+	sidecars, err := c.Annotations.Sidecars()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sidecars {
+		c.sidecars = append(c.sidecars, drivers.Sidecar{Name: s.Name, Image: s.Image, Cmd: s.Cmd, Env: s.Env, Init: s.Init})
+	}
+
 	if c.Call.Config == nil {
 		c.Call.Config = make(models.Config)
 	}
 	c.Call.Config["FN_LISTENER"] = "unix:" + filepath.Join(iofsDockerMountDest, udsFilename)
-	c.Call.Config["FN_FORMAT"] = "http-stream" // TODO: remove this after fdk's forget what it means
+	// TODO: remove this after fdk's forget what it means, grpc containers still need to know
+	c.Call.Config["FN_FORMAT"] = protocol
 	// TODO we could set type here too, for now, or anything else not based in fn/app/trigger config
 
 	setupCtx(&c)
@@ -290,6 +334,10 @@ type call struct {
 	slotHashId   string
 	disableNet   bool
 	dockerAuth   docker.Auther // pull config function
+	// the protocol of the container of the call, see models.ProtocolAnnotation
+	protocol string
+	// the sidecars of the container of the call, see models.SidecarsAnnotation
+	sidecars []drivers.Sidecar
 
 	// amount of time attributed to user-code execution
 	userExecTime *time.Duration
@@ -306,8 +354,14 @@ type call struct {
 	// Init wait start timestamp for goroutine in runHot
 	initStartTime int64
 
+	// why the call was rejected as too busy, if known. See setRejectReason.
+	rejectReason atomic.Value
+
 	// LB & Pure Runner Extra Config
 	extensions map[string]string
+
+	// priority class, if set with WithPriority
+	priority pool.CallPriority
 }
 
 // SlotHashId returns a string identity for this call that can be used to uniquely place the call in a given container
@@ -316,6 +370,18 @@ func (c *call) SlotHashId() string {
 	return c.slotHashId
 }
 
+// Priority returns the priority class of the call. Unless set with WithPriority or by
+// the annotations of its trigger, detached calls are batch and all others interactive.
+func (c *call) Priority() pool.CallPriority {
+	if c.priority != "" {
+		return c.priority
+	}
+	if c.Call != nil && c.Type == models.TypeDetached {
+		return pool.PriorityBatch
+	}
+	return pool.PriorityInteractive
+}
+
 func (c *call) Extensions() map[string]string {
 	return c.extensions
 }
@@ -355,6 +421,17 @@ func (c *call) GetUserExecutionTime() *time.Duration {
 
 func (c *call) Model() *models.Call { return c.Call }
 
+// setRejectReason records why the call is about to be rejected as too busy
+func (c *call) setRejectReason(reason pool.RejectReason) {
+	c.rejectReason.Store(reason)
+}
+
+// getRejectReason returns why the call was rejected as too busy, if known
+func (c *call) getRejectReason() pool.RejectReason {
+	reason, _ := c.rejectReason.Load().(pool.RejectReason)
+	return reason
+}
+
 func (c *call) Start(ctx context.Context) error {
 	ctx, span := trace.StartSpan(ctx, "agent_call_start")
 	defer span.End()
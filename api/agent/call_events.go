@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CallEvent is a structured record of a call outcome, emitted by the LB for every
+// call a runner finishes. It combines the runner reported CallFinished details,
+// the call model and values measured by the LB.
+type CallEvent struct {
+	CallID        string
+	AppID         string
+	FnID          string
+	Image         string
+	RunnerAddress string
+
+	Success    bool
+	ErrorUser  bool
+	ErrorCode  int32
+	ErrorStr   string
+	StatusCode int32
+
+	CreatedAt   common.DateTime
+	StartedAt   common.DateTime
+	CompletedAt common.DateTime
+
+	SchedulerDuration     time.Duration
+	ExecutionDuration     time.Duration
+	ImagePullWaitDuration time.Duration
+	CtrPrepDuration       time.Duration
+	CtrCreateDuration     time.Duration
+	InitStartTime         time.Duration
+
+	// Measured by LB: time from engagement until the finish message and
+	// response bytes written to the client
+	EngagementDuration time.Duration
+	ResponseBytes      int64
+}
+
+// CallEventSink receives call events. Emit must not block, see NewBufferedCallEventSink
+// to adapt a sink that may block.
+type CallEventSink interface {
+	Emit(ctx context.Context, event *CallEvent)
+}
+
+type bufferedCallEventSink struct {
+	sink   CallEventSink
+	events chan *CallEvent
+}
+
+// NewBufferedCallEventSink returns a CallEventSink that queues up to size events
+// and delivers them to sink from a separate go-routine. Events are dropped (and
+// counted in lb_call_events_dropped) when the queue is full.
+func NewBufferedCallEventSink(sink CallEventSink, size int) CallEventSink {
+	b := &bufferedCallEventSink{
+		sink:   sink,
+		events: make(chan *CallEvent, size),
+	}
+	go b.run()
+	return b
+}
+
+func (b *bufferedCallEventSink) run() {
+	for event := range b.events {
+		b.sink.Emit(context.Background(), event)
+	}
+}
+
+// implements CallEventSink
+func (b *bufferedCallEventSink) Emit(ctx context.Context, event *CallEvent) {
+	select {
+	case b.events <- event:
+	default:
+		statsCallEventDropped(ctx)
+		common.Logger(ctx).WithField("call_id", event.CallID).Debug("Call event queue full, dropping event")
+	}
+}
+
+// LogCallEventSink emits call events as structured log lines
+type LogCallEventSink struct{}
+
+// implements CallEventSink
+func (LogCallEventSink) Emit(ctx context.Context, event *CallEvent) {
+	logrus.WithFields(logrus.Fields{
+		"call_id":             event.CallID,
+		"app_id":              event.AppID,
+		"fn_id":               event.FnID,
+		"image":               event.Image,
+		"runner_addr":         event.RunnerAddress,
+		"success":             event.Success,
+		"error_user":          event.ErrorUser,
+		"error_code":          event.ErrorCode,
+		"error_str":           event.ErrorStr,
+		"http_status":         event.StatusCode,
+		"created_at":          event.CreatedAt,
+		"started_at":          event.StartedAt,
+		"completed_at":        event.CompletedAt,
+		"scheduler_duration":  event.SchedulerDuration,
+		"execution_duration":  event.ExecutionDuration,
+		"image_pull_wait":     event.ImagePullWaitDuration,
+		"ctr_prep_duration":   event.CtrPrepDuration,
+		"ctr_create_duration": event.CtrCreateDuration,
+		"init_start_time":     event.InitStartTime,
+		"engagement_duration": event.EngagementDuration,
+		"response_bytes":      event.ResponseBytes,
+	}).Info("Call event")
+}
+
+// newCallEvent builds a call event from a runner finish message
+func newCallEvent(msg *pb.CallFinished, c pool.RunnerCall, runnerAddress string, statusCode int32, engaged time.Time, respBytes int64) *CallEvent {
+	creat, _ := common.ParseDateTime(msg.GetCreatedAt())
+	start, _ := common.ParseDateTime(msg.GetStartedAt())
+	compl, _ := common.ParseDateTime(msg.GetCompletedAt())
+
+	event := &CallEvent{
+		CallID:                msg.GetDetails(),
+		Image:                 msg.GetImage(),
+		RunnerAddress:         runnerAddress,
+		Success:               msg.GetSuccess(),
+		ErrorUser:             msg.GetErrorUser(),
+		ErrorCode:             msg.GetErrorCode(),
+		ErrorStr:              msg.GetErrorStr(),
+		StatusCode:            statusCode,
+		CreatedAt:             creat,
+		StartedAt:             start,
+		CompletedAt:           compl,
+		SchedulerDuration:     time.Duration(msg.GetSchedulerDuration()),
+		ExecutionDuration:     time.Duration(msg.GetExecutionDuration()),
+		ImagePullWaitDuration: time.Duration(msg.GetImagePullWaitDuration()),
+		CtrPrepDuration:       time.Duration(msg.GetCtrPrepDuration()),
+		CtrCreateDuration:     time.Duration(msg.GetCtrCreateDuration()),
+		InitStartTime:         time.Duration(msg.GetInitStartTime()),
+		EngagementDuration:    time.Since(engaged),
+		ResponseBytes:         respBytes,
+	}
+
+	if model := c.Model(); model != nil {
+		event.AppID = model.AppID
+		event.FnID = model.FnID
+		if model.ID != "" {
+			event.CallID = model.ID
+		}
+		if event.Image == "" {
+			event.Image = model.Image
+		}
+	}
+	return event
+}
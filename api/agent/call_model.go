@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"net/http"
+	"time"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/models"
+)
+
+// CallModelHeader is the engagement response header in which a pure runner lists the
+// call model encodings it accepts in TryCall, besides JSON
+const CallModelHeader = "fn-call-model"
+
+// callModelProto is the CallModelHeader value of runners accepting TryCall.Call
+const callModelProto = "proto"
+
+// callModelToProto returns the proto encoding of c sent in TryCall.Call
+func callModelToProto(c *models.Call) *pb.CallModel {
+	m := &pb.CallModel{
+		Id:                c.ID,
+		Status:            c.Status,
+		Image:             c.Image,
+		Delay:             c.Delay,
+		Type:              c.Type,
+		Payload:           c.Payload,
+		Url:               c.URL,
+		Method:            c.Method,
+		Timeout:           c.Timeout,
+		IdleTimeout:       c.IdleTimeout,
+		TmpfsSize:         c.TmpFsSize,
+		FsSize:            c.FsSize,
+		ReadOnlyRootfs:    c.ReadOnlyRootFs,
+		Memory:            c.Memory,
+		Cpus:              uint64(c.CPUs),
+		Gpus:              c.GPUs,
+		Config:            c.Config,
+		SyslogUrl:         c.SyslogURL,
+		ExecutionDuration: int64(c.ExecutionDuration),
+		Error:             c.Error,
+		AppId:             c.AppID,
+		AppName:           c.AppName,
+		TriggerId:         c.TriggerID,
+		FnId:              c.FnID,
+		InitTimeout:       c.InitTimeout,
+		Concurrency:       c.Concurrency,
+	}
+	if len(c.Annotations) != 0 {
+		m.Annotations = make(map[string][]byte, len(c.Annotations))
+		for k := range c.Annotations {
+			m.Annotations[k], _ = c.Annotations.Get(k)
+		}
+	}
+	for k, vs := range c.Headers {
+		for _, v := range vs {
+			m.Headers = append(m.Headers, &pb.HttpHeader{Key: k, Value: v})
+		}
+	}
+	return m
+}
+
+// callModelFromProto is the inverse of callModelToProto. Dates and stats are left zero.
+func callModelFromProto(m *pb.CallModel) models.Call {
+	c := models.Call{
+		ID:                m.Id,
+		Status:            m.Status,
+		Image:             m.Image,
+		Delay:             m.Delay,
+		Type:              m.Type,
+		Payload:           m.Payload,
+		URL:               m.Url,
+		Method:            m.Method,
+		Timeout:           m.Timeout,
+		IdleTimeout:       m.IdleTimeout,
+		TmpFsSize:         m.TmpfsSize,
+		FsSize:            m.FsSize,
+		ReadOnlyRootFs:    m.ReadOnlyRootfs,
+		Memory:            m.Memory,
+		CPUs:              models.MilliCPUs(m.Cpus),
+		GPUs:              m.Gpus,
+		Config:            models.Config(m.Config),
+		Annotations:       models.AnnotationsFromRaw(m.Annotations),
+		SyslogURL:         m.SyslogUrl,
+		ExecutionDuration: time.Duration(m.ExecutionDuration),
+		Error:             m.Error,
+		AppID:             m.AppId,
+		AppName:           m.AppName,
+		TriggerID:         m.TriggerId,
+		FnID:              m.FnId,
+		InitTimeout:       m.InitTimeout,
+		Concurrency:       m.Concurrency,
+	}
+	if len(m.Headers) != 0 {
+		c.Headers = make(http.Header, len(m.Headers))
+		for _, h := range m.Headers {
+			// keys are kept as they were, like with the JSON encoding
+			c.Headers[h.Key] = append(c.Headers[h.Key], h.Value)
+		}
+	}
+	return c
+}
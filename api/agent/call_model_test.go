@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestCallModelProtoRoundTrip(t *testing.T) {
+	annotations, err := models.EmptyAnnotations().With("com.example/key", map[string]int{"a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &models.Call{
+		ID:                "call1",
+		Status:            "running",
+		Image:             "fnproject/hello",
+		Delay:             1,
+		Type:              models.TypeSync,
+		Payload:           "payload",
+		URL:               "http://localhost/invoke/fn1",
+		Method:            "POST",
+		Timeout:           30,
+		IdleTimeout:       60,
+		InitTimeout:       120,
+		TmpFsSize:         32,
+		FsSize:            1024,
+		ReadOnlyRootFs:    true,
+		Memory:            128,
+		CPUs:              models.MilliCPUs(500),
+		GPUs:              1,
+		Concurrency:       4,
+		Config:            models.Config{"FOO": "bar"},
+		Annotations:       annotations,
+		Headers:           http.Header{"Content-Type": {"text/plain"}, "X-Multi": {"a", "b"}},
+		SyslogURL:         "tcp://localhost:514",
+		ExecutionDuration: time.Second,
+		Error:             "error",
+		AppID:             "app1",
+		AppName:           "myapp",
+		TriggerID:         "trigger1",
+		FnID:              "fn1",
+	}
+
+	// every field a runner needs is set, so that fields added to models.Call are not
+	// dropped on the way to runners
+	notSent := map[string]bool{"CompletedAt": true, "CreatedAt": true, "StartedAt": true, "Stats": true}
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if name := v.Type().Field(i).Name; !notSent[name] && v.Field(i).IsZero() {
+			t.Fatalf("Expected models.Call.%s to be set, and sent in the proto call model", name)
+		}
+	}
+
+	// go through the wire encoding like a runner would
+	buf, err := proto.Marshal(callModelToProto(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m pb.CallModel
+	err = proto.Unmarshal(buf, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := callModelFromProto(&m)
+
+	// compare through JSON, annotation values are pointers
+	expected, _ := json.Marshal(c)
+	got, _ := json.Marshal(&decoded)
+	if string(expected) != string(got) {
+		t.Fatalf("Expected call model %s, got %s", expected, got)
+	}
+	if !decoded.Annotations.Equals(c.Annotations) || !reflect.DeepEqual(decoded.Headers, c.Headers) {
+		t.Fatalf("Expected annotations and headers to match, got %v %v", decoded.Annotations, decoded.Headers)
+	}
+}
+
+func TestCallModelNegotiation(t *testing.T) {
+	r := &gRPCRunner{}
+	r.updateCallModel(metadata.Pairs(CallModelHeader, "other, "+callModelProto))
+	if r.callModelOK != 1 {
+		t.Fatalf("Expected proto call model accepted")
+	}
+	r.updateCallModel(metadata.MD{})
+	if r.callModelOK != -1 {
+		t.Fatalf("Expected proto call model not accepted without header")
+	}
+
+	finished := &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}}
+	for _, accepted := range []int32{-1, 1} {
+		client := &scriptedRunnerProtocolClient{engagements: []*mockEngageClient{{msgs: []*pb.RunnerMsg{finished}}}}
+		r := &gRPCRunner{
+			shutWg:          common.NewWaitGroup(),
+			address:         "192.0.2.0",
+			clients:         []pb.RunnerProtocolClient{client},
+			maxDataChunk:    MaxDataChunk,
+			advertisedChunk: -1,
+			callModelOK:     accepted,
+		}
+		call := &mockRunnerCall{
+			r:     httptest.NewRequest("POST", "/", strings.NewReader("")),
+			rw:    httptest.NewRecorder(),
+			model: &models.Call{ID: "call1", Type: models.TypeSync},
+		}
+		placed, err := r.TryExec(context.Background(), call)
+		if !placed || err != nil {
+			t.Fatalf("Expected placed call, got placed=%v err=%v", placed, err)
+		}
+
+		try := client.tries[0]
+		if accepted > 0 && (try.Call.GetId() != "call1" || try.ModelsCallJson != "") {
+			t.Fatalf("Expected proto call model, got %v", try)
+		}
+		if accepted < 0 && (try.Call != nil || !strings.Contains(try.ModelsCallJson, `"id":"call1"`)) {
+			t.Fatalf("Expected JSON call model, got %v", try)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RunnerProtocolVersion is the version of the LB to pure runner protocol, exchanged
+// with the Capabilities call
+const RunnerProtocolVersion = 1
+
+// Optional protocol features listed in Capabilities
+const (
+	// FeatureCallModelProto is the proto encoding of the call model in TryCall.Call
+	FeatureCallModelProto = "call_model_proto"
+	// FeatureResponseWindow is response flow control, see GRPCRunnerWithResponseWindow
+	FeatureResponseWindow = "response_window"
+	// FeatureInvoke is the Invoke call, see GRPCRunnerWithInvokeThreshold
+	FeatureInvoke = "invoke"
+	// FeatureLogFrames is function stderr sent in log frames, see GRPCRunnerWithLogFrames
+	FeatureLogFrames = "log_frames"
+	// FeatureCancel is the client cancelling engaged calls, see GRPCRunnerWithCancelMessages
+	FeatureCancel = "cancel"
+)
+
+// protocolFeatures are the optional features implemented by both the LB and pure runner here
+var protocolFeatures = []string{FeatureCallModelProto, FeatureResponseWindow, FeatureInvoke, FeatureLogFrames, FeatureCancel}
+
+// localCapabilities returns the capabilities sent to the peer with maxDataChunk, compressors
+// and labels
+func localCapabilities(maxDataChunk int, compressors []string, labels map[string]string) *pb.Capabilities {
+	return &pb.Capabilities{
+		ProtocolVersion: RunnerProtocolVersion,
+		Features:        protocolFeatures,
+		Compressors:     compressors,
+		MaxDataChunk:    int64(maxDataChunk),
+		Labels:          labels,
+	}
+}
+
+// checkHandshake starts a background Capabilities call if the runner did not answer one
+// yet and none was made in readyCheckInterval. Until the runner answered, optional
+// features are negotiated with engagement headers.
+func (r *gRPCRunner) checkHandshake() {
+	if !r.handshakeEnabled || atomic.LoadInt32(&r.capsState) != 0 {
+		return
+	}
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&r.lastHandshake)
+	if now-last < int64(r.readyCheckInterval) || !atomic.CompareAndSwapInt64(&r.lastHandshake, last, now) {
+		return
+	}
+	if !r.shutWg.AddSession(1) {
+		return
+	}
+	go func() {
+		defer r.shutWg.DoneSession()
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultReadyCheckTimeout)
+		defer cancel()
+		r.handshake(ctx)
+	}()
+}
+
+// handshake exchanges capabilities with the runner and records the features it supports
+func (r *gRPCRunner) handshake(ctx context.Context) {
+	log := common.Logger(ctx).WithField("runner_addr", r.address)
+
+	var compressors []string
+	if r.compressor != "" {
+		compressors = []string{r.compressor}
+	}
+	caps, err := r.client().Capabilities(r.outgoingContext(ctx), localCapabilities(r.maxDataChunk, compressors, nil))
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			log.Info("Runner does not implement Capabilities, using engagement headers")
+			atomic.StoreInt32(&r.capsState, -1)
+			return
+		}
+		log.WithError(err).Info("Runner capabilities handshake failed")
+		return
+	}
+	log.WithField("protocol_version", caps.ProtocolVersion).WithField("features", caps.Features).WithField("labels", caps.Labels).Info("Runner capabilities")
+	r.applyCapabilities(caps)
+}
+
+// applyCapabilities records the features the runner reported in its capabilities
+func (r *gRPCRunner) applyCapabilities(caps *pb.Capabilities) {
+	features := make(map[string]bool, len(caps.Features))
+	for _, f := range caps.Features {
+		features[f] = true
+	}
+
+	atomic.StoreInt32(&r.callModelOK, negotiated(features[FeatureCallModelProto]))
+	if features[FeatureInvoke] {
+		atomic.StoreInt32(&r.invokeUnsupported, 0)
+	} else {
+		atomic.StoreInt32(&r.invokeUnsupported, 1)
+	}
+	if r.compressor != "" {
+		accepted := false
+		for _, name := range caps.Compressors {
+			accepted = accepted || name == r.compressor
+		}
+		atomic.StoreInt32(&r.compressorOK, negotiated(accepted))
+	}
+	size := int64(-1)
+	if caps.MaxDataChunk > 0 {
+		size = caps.MaxDataChunk
+	}
+	atomic.StoreInt64(&r.advertisedChunk, size)
+
+	labels := make(map[string]string, len(caps.Labels)+len(r.configLabels))
+	for k, v := range caps.Labels {
+		labels[k] = v
+	}
+	for k, v := range r.configLabels {
+		labels[k] = v
+	}
+	r.labels.Store(labels)
+	atomic.StoreInt32(&r.capsState, 1)
+}
+
+// negotiated returns the state of a negotiated feature, 1 if accepted and -1 if not
+func negotiated(accepted bool) int32 {
+	if accepted {
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/models"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// capabilitiesClient answers Capabilities with the pure runner, or with err if set
+type capabilitiesClient struct {
+	pb.RunnerProtocolClient
+	pr  *pureRunner
+	err error
+}
+
+func (c *capabilitiesClient) Capabilities(ctx context.Context, in *pb.Capabilities, opts ...grpc.CallOption) (*pb.Capabilities, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.pr.Capabilities(ctx, in)
+}
+
+func TestRunnerHandshake(t *testing.T) {
+	pr := &pureRunner{maxDataChunk: 1024, compressors: []string{"gzip"}}
+	r := &gRPCRunner{
+		address:    "192.0.2.0",
+		clients:    []pb.RunnerProtocolClient{&capabilitiesClient{pr: pr}},
+		compressor: "gzip",
+	}
+	r.handshake(context.Background())
+	if r.capsState != 1 || r.callModelOK != 1 || r.compressorOK != 1 || r.advertisedChunk != 1024 || r.invokeUnsupported != 0 {
+		t.Fatalf("Expected runner features from handshake, got %+v", r)
+	}
+
+	// features the runner does not list are not used
+	r.applyCapabilities(&pb.Capabilities{ProtocolVersion: RunnerProtocolVersion})
+	if r.callModelOK != -1 || r.compressorOK != -1 || r.advertisedChunk != -1 || r.invokeUnsupported != 1 {
+		t.Fatalf("Expected no runner features, got %+v", r)
+	}
+
+	r = &gRPCRunner{
+		address: "192.0.2.0",
+		clients: []pb.RunnerProtocolClient{&capabilitiesClient{err: status.Error(codes.Unimplemented, "unknown method")}},
+	}
+	r.handshake(context.Background())
+	if r.capsState != -1 {
+		t.Fatalf("Expected handshake not implemented, got %d", r.capsState)
+	}
+}
+
+func TestRunnerHandshakeLabels(t *testing.T) {
+	pr := &pureRunner{labels: map[string]string{"gpu": "true", "zone": "phx-1"}}
+	r := &gRPCRunner{
+		address:      "192.0.2.0",
+		clients:      []pb.RunnerProtocolClient{&capabilitiesClient{pr: pr}},
+		configLabels: map[string]string{"zone": "phx-2"},
+	}
+	if !reflect.DeepEqual(r.Labels(), map[string]string{"zone": "phx-2"}) {
+		t.Fatalf("Expected configured labels before the handshake, got %v", r.Labels())
+	}
+
+	// labels configured on the LB take precedence over advertised ones
+	r.handshake(context.Background())
+	expected := map[string]string{"gpu": "true", "zone": "phx-2"}
+	if !reflect.DeepEqual(r.Labels(), expected) {
+		t.Fatalf("Expected labels %v, got %v", expected, r.Labels())
+	}
+}
+
+func TestReceiveFromRunnerStrictMessages(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		call := &mockRunnerCall{rw: httptest.NewRecorder(), model: &models.Call{Type: models.TypeSync}}
+		msgs := []*pb.RunnerMsg{
+			{}, // body unknown to this LB
+			{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}},
+		}
+		done := make(chan error, 1)
+		receiveFromRunner(context.Background(), &mockEngageClient{msgs: msgs}, "192.0.2.0", call, receiveOptions{strictMessages: strict}, done)
+
+		var err error
+		for e := range done {
+			err = e
+		}
+		if strict && err != ErrorRunnerProtocol || !strict && err != nil {
+			t.Fatalf("Expected unknown message to fail=%v, got %v", strict, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type breakerOutcome int
+
+const (
+	// the attempt says nothing about the runner's health, eg. the client cancelled
+	breakerNeutral breakerOutcome = iota
+	breakerSuccess
+	breakerFailure
+)
+
+// circuitBreaker stops TryExec from engaging a runner after consecutive transport
+// errors or too busy NACKs. Once open, the runner is skipped for a backoff period,
+// after which a single trial call is let through. A failed trial reopens the breaker
+// with twice the backoff, up to a maximum. A nil breaker is always closed.
+type circuitBreaker struct {
+	address    string
+	threshold  int
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	mtx         sync.Mutex
+	failures    int
+	openBackoff time.Duration // zero while closed
+	openUntil   time.Time
+	trial       bool // half open trial call in flight
+}
+
+// GRPCRunnerWithCircuitBreaker opens a circuit breaker on the runner after threshold
+// consecutive transport errors or too busy NACKs. TryExec then refuses calls with
+// ErrorRunnerCircuitOpen for backoff, doubling on every failed trial up to maxBackoff.
+func GRPCRunnerWithCircuitBreaker(threshold int, backoff, maxBackoff time.Duration) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if r.breaker != nil {
+			return errors.New("Failed to create runner: circuit breaker already set")
+		}
+		if threshold <= 0 || backoff <= 0 || maxBackoff < backoff {
+			return fmt.Errorf("Invalid circuit breaker threshold=%d backoff=%v max_backoff=%v", threshold, backoff, maxBackoff)
+		}
+		r.breaker = &circuitBreaker{address: r.address, threshold: threshold, backoff: backoff, maxBackoff: maxBackoff}
+		return nil
+	}
+}
+
+// allow returns false if the breaker is open and the call should go to another runner
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.openBackoff == 0 {
+		return true
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// done records the outcome of a call let through by allow
+func (b *circuitBreaker) done(outcome breakerOutcome) {
+	if b == nil {
+		return
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	wasTrial := b.trial
+	b.trial = false
+
+	switch outcome {
+	case breakerSuccess:
+		if b.openBackoff != 0 {
+			logrus.WithField("runner_addr", b.address).Info("Runner circuit breaker closed")
+			statsRunnerCircuitOpen(context.Background(), -1)
+		}
+		b.failures = 0
+		b.openBackoff = 0
+	case breakerFailure:
+		b.failures++
+		if b.openBackoff == 0 && b.failures >= b.threshold {
+			b.openBackoff = b.backoff
+			statsRunnerCircuitOpen(context.Background(), 1)
+		} else if wasTrial {
+			b.openBackoff *= 2
+			if b.openBackoff > b.maxBackoff {
+				b.openBackoff = b.maxBackoff
+			}
+		} else {
+			return
+		}
+		b.openUntil = time.Now().Add(b.openBackoff)
+		logrus.WithFields(logrus.Fields{"runner_addr": b.address, "failures": b.failures, "backoff": b.openBackoff}).Info("Runner circuit breaker open")
+	}
+}
+
+// isOpen reports whether the breaker currently refuses calls
+func (b *circuitBreaker) isOpen() bool {
+	if b == nil {
+		return false
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.openBackoff != 0
+}
+
+// breakerOutcomeOf classifies the result of TryExec for the circuit breaker
+func breakerOutcomeOf(placed bool, err error) breakerOutcome {
+	if _, busy := pool.RejectReasonOf(err); busy {
+		return breakerFailure
+	}
+	if err != nil && status.Code(err) == codes.Unavailable {
+		return breakerFailure
+	}
+	if placed {
+		return breakerSuccess
+	}
+	return breakerNeutral
+}
@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := &circuitBreaker{address: "192.0.2.0", threshold: 2, backoff: 20 * time.Millisecond, maxBackoff: 30 * time.Millisecond}
+
+	if !b.allow() {
+		t.Fatalf("Expected closed breaker")
+	}
+	b.done(breakerFailure)
+	if !b.allow() || b.isOpen() {
+		t.Fatalf("Expected breaker to stay closed below threshold")
+	}
+	b.done(breakerFailure)
+	if b.allow() || !b.isOpen() {
+		t.Fatalf("Expected breaker to open at threshold")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if !b.allow() {
+		t.Fatalf("Expected a trial call after backoff")
+	}
+	if b.allow() {
+		t.Fatalf("Expected a single trial call")
+	}
+	b.done(breakerFailure)
+	if b.openBackoff != 30*time.Millisecond {
+		t.Fatalf("Expected backoff doubled up to max, got %v", b.openBackoff)
+	}
+
+	time.Sleep(35 * time.Millisecond)
+	if !b.allow() {
+		t.Fatalf("Expected a trial call after backoff")
+	}
+	b.done(breakerSuccess)
+	if !b.allow() || b.isOpen() {
+		t.Fatalf("Expected breaker closed after successful trial")
+	}
+
+	var nilBreaker *circuitBreaker
+	if !nilBreaker.allow() || nilBreaker.isOpen() {
+		t.Fatalf("Expected nil breaker to be closed")
+	}
+	nilBreaker.done(breakerFailure)
+}
+
+func TestCircuitBreakerOutcome(t *testing.T) {
+	tests := []struct {
+		placed   bool
+		err      error
+		expected breakerOutcome
+	}{
+		{false, models.ErrCallTimeoutServerBusy, breakerFailure},
+		{false, status.Error(codes.Unavailable, "connection refused"), breakerFailure},
+		{true, status.Error(codes.Unavailable, "transport is closing"), breakerFailure},
+		{true, nil, breakerSuccess},
+		{true, models.ErrFunctionResponse, breakerSuccess},
+		{false, ErrorRunnerCordoned, breakerNeutral},
+		{false, errors.New("client cancelled"), breakerNeutral},
+	}
+	for i, tt := range tests {
+		if outcome := breakerOutcomeOf(tt.placed, tt.err); outcome != tt.expected {
+			t.Fatalf("%d: expected outcome %d got %d for placed=%v err=%v", i, tt.expected, outcome, tt.placed, tt.err)
+		}
+	}
+}
+
+func TestTryExecCircuitOpen(t *testing.T) {
+	client := &mockRunnerProtocolClient{}
+	r := &gRPCRunner{shutWg: common.NewWaitGroup(), address: "192.0.2.0", clients: []pb.RunnerProtocolClient{client}}
+	err := GRPCRunnerWithCircuitBreaker(1, time.Minute, time.Minute)(r)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	r.breaker.done(breakerFailure)
+
+	placed, err := r.TryExec(context.Background(), &mockRunnerCall{model: &models.Call{Type: models.TypeSync}})
+	if placed || err != ErrorRunnerCircuitOpen {
+		t.Fatalf("Expected not placed with open breaker, got placed=%v err=%v", placed, err)
+	}
+	if client.engagements != 0 || !r.IsCircuitOpen() {
+		t.Fatalf("Expected no engagement with open breaker, got %d", client.engagements)
+	}
+}
@@ -11,16 +11,28 @@ import (
 
 // Config specifies various settings for an agent
 type Config struct {
+	ContainerDriver               string        `json:"container_driver"`
 	MinDockerVersion              string        `json:"min_docker_version"`
 	ContainerLabelTag             string        `json:"container_label_tag"`
 	DockerNetworks                string        `json:"docker_networks"`
 	DockerLoadFile                string        `json:"docker_load_file"`
+	DockerRuntimes                string        `json:"docker_runtimes"`
+	DockerRuntimeClasses          string        `json:"docker_runtime_classes"`
+	DockerGPURuntime              string        `json:"docker_gpu_runtime"`
+	DockerRegistryMirrors         string        `json:"docker_registry_mirrors"`
+	DockerIsolation               string        `json:"docker_isolation"`
+	DockerBlkioDevices            string        `json:"docker_blkio_devices"`
+	GPUDevices                    string        `json:"gpu_devices"`
 	DisableUnprivilegedContainers bool          `json:"disable_unprivileged_containers"`
 	FreezeIdle                    time.Duration `json:"freeze_idle_msecs"`
 	HotPoll                       time.Duration `json:"hot_poll_msecs"`
 	HotLauncherTimeout            time.Duration `json:"hot_launcher_timeout_msecs"`
 	HotPullTimeout                time.Duration `json:"hot_pull_timeout_msecs"`
 	HotStartTimeout               time.Duration `json:"hot_start_timeout_msecs"`
+	MinWarm                       uint64        `json:"min_warm"`
+	MaxMinWarm                    uint64        `json:"max_min_warm"`
+	MaxContainerCalls             uint64        `json:"max_container_calls"`
+	MaxContainerAge               time.Duration `json:"max_container_age_msecs"`
 	DetachedHeadRoom              time.Duration `json:"detached_head_room_msecs"`
 	MaxResponseSize               uint64        `json:"max_response_size_bytes"`
 	MaxHdrResponseSize            uint64        `json:"max_hdr_response_size_bytes"`
@@ -39,6 +51,12 @@ type Config struct {
 	PreForkUseOnce                uint64        `json:"pre_fork_use_once"`
 	PreForkNetworks               string        `json:"pre_fork_networks"`
 	EnableNBResourceTracker       bool          `json:"enable_nb_resource_tracker"`
+	BatchHeadroom                 uint64        `json:"batch_headroom_pct"`
+	EnableFairShare               bool          `json:"enable_fair_share"`
+	MaxMemoryPressure             uint64        `json:"max_memory_pressure_pct"`
+	MaxCPUPressure                uint64        `json:"max_cpu_pressure_pct"`
+	MemoryOvercommit              uint64        `json:"memory_overcommit_pct"`
+	OvercommitEvictPressure       uint64        `json:"overcommit_evict_pressure_pct"`
 	MaxTmpFsInodes                uint64        `json:"max_tmpfs_inodes"`
 	DisableReadOnlyRootFs         bool          `json:"disable_readonly_rootfs"`
 	DisableDebugUserLogs          bool          `json:"disable_debug_user_logs"`
@@ -50,9 +68,20 @@ type Config struct {
 	ImageCleanMaxSize             uint64        `json:"image_clean_max_size"`
 	ImageCleanExemptTags          string        `json:"image_clean_exempt_tags"`
 	ImageEnableVolume             bool          `json:"image_enable_volume"`
+	SecretsURL                    string        `json:"secrets_url"`
+	SecretsPrefix                 string        `json:"secrets_prefix"`
+	EgressPolicyImage             string        `json:"egress_policy_image"`
+	ImageVerifyKeys               string        `json:"image_verify_keys"`
+	CheckpointRestore             bool          `json:"checkpoint_restore"`
 }
 
 const (
+	// EnvContainerDriver is the name of the registered container driver that runs the
+	// containers of calls, see drivers.Register. Defaults to docker, podman runs them with
+	// the docker compatible API of podman, see docker.NewPodman, containerd with the
+	// native client of containerd, see containerd.NewContainerd, and firecracker in
+	// Firecracker microVMs, see firecracker.NewFirecracker.
+	EnvContainerDriver = "FN_CONTAINER_DRIVER"
 	// EnvContainerLabelTag is a classifier label tag that is used to distinguish fn managed containers
 	EnvContainerLabelTag = "FN_CONTAINER_LABEL_TAG"
 	// EnvImageCleanMaxSize enables image cleaner and sets the high water mark for image cache in bytes
@@ -63,6 +92,32 @@ const (
 	EnvImageEnableVolume = "FN_IMAGE_ENABLE_VOLUME"
 	// EnvDockerNetworks is a comma separated list of networks to attach to each container started
 	EnvDockerNetworks = "FN_DOCKER_NETWORKS"
+	// EnvDockerRuntimes is a space separated list of OCI runtimes, eg. runsc, the containers of
+	// fns may select with models.RuntimeAnnotation besides the default runtime of dockerd
+	EnvDockerRuntimes = "FN_DOCKER_RUNTIMES"
+	// EnvDockerRuntimeClasses is a space separated list of runtime classes the containers of fns
+	// may select with models.RuntimeClassAnnotation, each with its OCI runtime, eg.
+	// "kata=kata-runtime sandboxed=runsc"
+	EnvDockerRuntimeClasses = "FN_DOCKER_RUNTIME_CLASSES"
+	// EnvDockerGPURuntime is the OCI runtime of containers of fns with GPUs, which exposes the
+	// GPUs of the NVIDIA_VISIBLE_DEVICES env of a container to it. Defaults to nvidia.
+	EnvDockerGPURuntime = "FN_DOCKER_GPU_RUNTIME"
+	// EnvDockerRegistryMirrors is a space separated list of registry=mirror pairs, eg.
+	// "docker.io=mirror.internal:5000 *=cache.internal:5000", images are pulled from the
+	// mirror of their registry, or of the * registry, before their registry itself
+	EnvDockerRegistryMirrors = "FN_DOCKER_REGISTRY_MIRRORS"
+	// EnvDockerIsolation is the isolation the containers of fns must run with on a docker daemon
+	// of windows containers, process or hyperv. The agent does not start if the daemon runs
+	// containers with another isolation, eg. without --exec-opt isolation=hyperv, any
+	// isolation is fine if empty. Linux daemons have no other isolation than their default.
+	EnvDockerIsolation = "FN_DOCKER_ISOLATION"
+	// EnvDockerBlkioDevices is a space separated list of the block devices the block IO rates
+	// of the containers of fns are throttled on, eg. "/dev/sda", the disk of the docker data
+	// root. Rates are not throttled if empty, block IO weights are.
+	EnvDockerBlkioDevices = "FN_DOCKER_BLKIO_DEVICES"
+	// EnvGPUDevices is a space separated list of the ids of the GPUs of the host, eg. "0 1",
+	// containers of fns with GPUs are allotted GPUs of the list. There are none by default.
+	EnvGPUDevices = "FN_GPU_DEVICES"
 	// EnvDockerLoadFile is a file location for a file that contains a tarball of a docker image to load on startup
 	EnvDockerLoadFile = "FN_DOCKER_LOAD_FILE"
 	// EnvDisableUnprivilegedContainers disables docker security features like user name, cap drop etc.
@@ -78,6 +133,20 @@ const (
 	EnvHotPullTimeout = "FN_HOT_PULL_TIMEOUT_MSECS"
 	// EnvHotStartTimeout is the timeout for a hot container to become available for use for requests after EnvHotStartTimeout
 	EnvHotStartTimeout = "FN_HOT_START_TIMEOUT_MSECS"
+	// EnvMinWarm is the number of hot containers kept warm for fns without the min warm
+	// annotation once they ran a call, until their hot container queue times out (see
+	// EnvHotLauncherTimeout). Disabled if 0, the default.
+	EnvMinWarm = "FN_MIN_WARM"
+	// EnvMaxMinWarm is the largest number of hot containers kept warm for an fn with the
+	// min warm annotation, larger numbers are capped. Annotations are ignored if 0, the default.
+	EnvMaxMinWarm = "FN_MAX_MIN_WARM"
+	// EnvMaxContainerCalls is the number of calls after which a hot container is retired, it
+	// takes no more calls once its calls finish, and is replaced if calls still need it.
+	// Unlimited if 0, the default.
+	EnvMaxContainerCalls = "FN_MAX_CONTAINER_CALLS"
+	// EnvMaxContainerAge is likewise the age after which a hot container is retired, its calls
+	// may still run past it. Unlimited if 0, the default.
+	EnvMaxContainerAge = "FN_MAX_CONTAINER_AGE_MSECS"
 	// EnvMaxResponseSize is the maximum number of bytes that a function may return from an invocation
 	EnvMaxResponseSize = "FN_MAX_RESPONSE_SIZE"
 	// EnvHdrMaxResponseSize is the maximum number of bytes that a function may return in an invocation header
@@ -116,6 +185,29 @@ const (
 	// EnvEnableNBResourceTracker makes every request to the resource tracker non-blocking, meaning the resources are either
 	// available or it will return an error immediately
 	EnvEnableNBResourceTracker = "FN_ENABLE_NB_RESOURCE_TRACKER"
+	// EnvBatchHeadroom is the percentage of memory and CPU that containers for batch priority calls,
+	// eg. detached calls, may not take up, so that it is left for interactive calls
+	EnvBatchHeadroom = "FN_BATCH_HEADROOM_PCT"
+	// EnvEnableFairShare shares memory and CPU across the fns that wait for containers in
+	// proportion to their weights (see models.FairShareWeightAnnotation), so that a busy fn
+	// cannot starve the others. Off by default.
+	EnvEnableFairShare = "FN_ENABLE_FAIR_SHARE"
+	// EnvMaxMemoryPressure is the memory pressure of the host, the percentage of the last 10
+	// seconds that some tasks stalled waiting for memory (see /proc/pressure/memory), above
+	// which no containers are launched. Disabled if 0, the default.
+	EnvMaxMemoryPressure = "FN_MAX_MEMORY_PRESSURE_PCT"
+	// EnvMaxCPUPressure is likewise the CPU pressure of the host above which no containers
+	// are launched. Disabled if 0, the default.
+	EnvMaxCPUPressure = "FN_MAX_CPU_PRESSURE_PCT"
+	// EnvMemoryOvercommit is the percentage of the usable memory of the host that containers
+	// may reserve, eg. 150 to run hot containers for 1.5 times the memory of the host, as idle
+	// containers seldom use all of their memory. Idle containers are evicted when the memory
+	// pressure of the host goes above EnvOvercommitEvictPressure. Disabled if 0, the default,
+	// or 100.
+	EnvMemoryOvercommit = "FN_MEMORY_OVERCOMMIT_PCT"
+	// EnvOvercommitEvictPressure is the memory pressure of the host above which idle containers
+	// are evicted while memory is overcommitted, see EnvMemoryOvercommit. Defaults to 10.
+	EnvOvercommitEvictPressure = "FN_OVERCOMMIT_EVICT_PRESSURE_PCT"
 	// EnvMaxTmpFsInodes is the maximum number of inodes for /tmp in a container
 	EnvMaxTmpFsInodes = "FN_MAX_TMPFS_INODES"
 	// EnvDisableReadOnlyRootFs makes the root fs for a container have rw permissions, by default it is read only
@@ -132,6 +224,34 @@ const (
 	// EnvIOFSOpts are the options to set when mounting the iofs directory for unix socket files
 	EnvIOFSOpts = "FN_IOFS_OPTS"
 
+	// EnvSecretsURL is the URL of the secret store the secrets of fns are resolved from when
+	// their containers are created, eg. vault://vault.example.com:8200, see secrets.New
+	EnvSecretsURL = "FN_SECRETS_URL"
+	// EnvSecretsPrefix is the prefix of the references of the secrets of an app in the secret
+	// store, it must contain {app_id}, the ID of the app. The refs of the secrets of the fns
+	// of the app are relative to it, so that an app cannot read the secrets of others.
+	// Defaults to secret/data/fn/{app_id}/, the fn/<app id> path of a Vault KV version 2
+	// engine mounted at secret.
+	EnvSecretsPrefix = "FN_SECRETS_PREFIX"
+
+	// EnvEgressPolicyImage is an image with sh and iptables, eg. an alpine image with the
+	// iptables package, that the docker driver runs in the network namespace of containers
+	// to install their egress allow lists, see models.EgressAnnotation. Fns with an allow
+	// list fail on runners without it.
+	EnvEgressPolicyImage = "FN_EGRESS_POLICY_IMAGE"
+
+	// EnvImageVerifyKeys is a space separated list of files of PEM encoded public keys, the
+	// images of fns must be signed with cosign by one of them to run. Apps and fns may
+	// require keys of their own, see models.ImageSignatureAnnotation.
+	EnvImageVerifyKeys = "FN_IMAGE_VERIFY_KEYS"
+
+	// EnvCheckpointRestore enables the experimental checkpoint and restore of containers with
+	// CRIU: the first container of a fn to initialize is checkpointed, and later containers
+	// of the fn with the same config restore the checkpoint rather than initialize again.
+	// Containers that cannot be restored start as usual. Only the containerd driver
+	// supports it, see containerd.NewContainerd.
+	EnvCheckpointRestore = "FN_CHECKPOINT_RESTORE"
+
 	// EnvDetachedHeadroom is the extra room we want to give to a detached function to run.
 	EnvDetachedHeadroom = "FN_EXECUTION_HEADROOM"
 
@@ -143,10 +263,10 @@ const (
 	// DefaultHotPoll is the default value for EnvHotPoll
 	DefaultHotPoll = 200 * time.Millisecond
 
-	// TODO(reed): none of these consts above or below should be exported yo
+	// maxMemoryOvercommit is the largest EnvMemoryOvercommit
+	maxMemoryOvercommit = 400
 
-	// iofsDockerMountDest is the mount path for inside of the container to use for the iofs path
-	iofsDockerMountDest = "/tmp/iofs"
+	// TODO(reed): none of these consts above or below should be exported yo
 
 	// udsFilename is the file name for the uds socket
 	udsFilename = "lsnr.sock"
@@ -155,10 +275,13 @@ const (
 // NewConfig returns a config set from env vars, plus defaults
 func NewConfig() (*Config, error) {
 	cfg := &Config{
+		ContainerDriver:  "docker",
 		MinDockerVersion: "17.10.0-ce",
+		DockerGPURuntime: "nvidia",
 		MaxLogSize:       1 * 1024 * 1024,
 		PreForkImage:     "busybox",
 		PreForkCmd:       "tail -f /dev/null",
+		SecretsPrefix:    "secret/data/fn/{app_id}/",
 	}
 
 	defaultMaxPIDs := uint64(50)
@@ -166,6 +289,7 @@ func NewConfig() (*Config, error) {
 	defaultMaxLockedMemory := uint64(64 * 1024)
 	defaultMaxPendingSignals := uint64(5000)
 	defaultMaxMessageQueue := uint64(819200)
+	defaultOvercommitEvictPressure := uint64(10)
 
 	var err error
 	err = setEnvMsecs(err, EnvFreezeIdle, &cfg.FreezeIdle, 50*time.Millisecond)
@@ -173,6 +297,10 @@ func NewConfig() (*Config, error) {
 	err = setEnvMsecs(err, EnvHotLauncherTimeout, &cfg.HotLauncherTimeout, time.Duration(60)*time.Minute)
 	err = setEnvMsecs(err, EnvHotPullTimeout, &cfg.HotPullTimeout, time.Duration(10)*time.Minute)
 	err = setEnvMsecs(err, EnvHotStartTimeout, &cfg.HotStartTimeout, time.Duration(5)*time.Second)
+	err = setEnvUint(err, EnvMinWarm, &cfg.MinWarm, nil)
+	err = setEnvUint(err, EnvMaxMinWarm, &cfg.MaxMinWarm, nil)
+	err = setEnvUint(err, EnvMaxContainerCalls, &cfg.MaxContainerCalls, nil)
+	err = setEnvMsecs(err, EnvMaxContainerAge, &cfg.MaxContainerAge, 0)
 	err = setEnvMsecs(err, EnvDetachedHeadroom, &cfg.DetachedHeadRoom, time.Duration(360)*time.Second)
 	err = setEnvUint(err, EnvMaxResponseSize, &cfg.MaxResponseSize, nil)
 	err = setEnvUint(err, EnvMaxHdrResponseSize, &cfg.MaxHdrResponseSize, nil)
@@ -190,9 +318,17 @@ func NewConfig() (*Config, error) {
 	err = setEnvStr(err, EnvPreForkCmd, &cfg.PreForkCmd)
 	err = setEnvUint(err, EnvPreForkUseOnce, &cfg.PreForkUseOnce, nil)
 	err = setEnvStr(err, EnvPreForkNetworks, &cfg.PreForkNetworks)
+	err = setEnvStr(err, EnvContainerDriver, &cfg.ContainerDriver)
 	err = setEnvStr(err, EnvContainerLabelTag, &cfg.ContainerLabelTag)
 	err = setEnvStr(err, EnvDockerNetworks, &cfg.DockerNetworks)
 	err = setEnvStr(err, EnvDockerLoadFile, &cfg.DockerLoadFile)
+	err = setEnvStr(err, EnvDockerRuntimes, &cfg.DockerRuntimes)
+	err = setEnvStr(err, EnvDockerRuntimeClasses, &cfg.DockerRuntimeClasses)
+	err = setEnvStr(err, EnvDockerGPURuntime, &cfg.DockerGPURuntime)
+	err = setEnvStr(err, EnvDockerRegistryMirrors, &cfg.DockerRegistryMirrors)
+	err = setEnvStr(err, EnvDockerIsolation, &cfg.DockerIsolation)
+	err = setEnvStr(err, EnvDockerBlkioDevices, &cfg.DockerBlkioDevices)
+	err = setEnvStr(err, EnvGPUDevices, &cfg.GPUDevices)
 	err = setEnvBool(err, EnvDisableUnprivilegedContainers, &cfg.DisableUnprivilegedContainers)
 	err = setEnvUint(err, EnvMaxTmpFsInodes, &cfg.MaxTmpFsInodes, nil)
 	err = setEnvStr(err, EnvIOFSPath, &cfg.IOFSAgentPath)
@@ -200,11 +336,22 @@ func NewConfig() (*Config, error) {
 	err = setEnvStr(err, EnvIOFSOpts, &cfg.IOFSOpts)
 	err = setEnvBool(err, EnvIOFSEnableTmpfs, &cfg.IOFSEnableTmpfs)
 	err = setEnvBool(err, EnvEnableNBResourceTracker, &cfg.EnableNBResourceTracker)
+	err = setEnvUint(err, EnvBatchHeadroom, &cfg.BatchHeadroom, nil)
+	err = setEnvBool(err, EnvEnableFairShare, &cfg.EnableFairShare)
+	err = setEnvUint(err, EnvMaxMemoryPressure, &cfg.MaxMemoryPressure, nil)
+	err = setEnvUint(err, EnvMaxCPUPressure, &cfg.MaxCPUPressure, nil)
+	err = setEnvUint(err, EnvMemoryOvercommit, &cfg.MemoryOvercommit, nil)
+	err = setEnvUint(err, EnvOvercommitEvictPressure, &cfg.OvercommitEvictPressure, &defaultOvercommitEvictPressure)
 	err = setEnvBool(err, EnvDisableReadOnlyRootFs, &cfg.DisableReadOnlyRootFs)
 	err = setEnvBool(err, EnvDisableDebugUserLogs, &cfg.DisableDebugUserLogs)
 	err = setEnvUint(err, EnvImageCleanMaxSize, &cfg.ImageCleanMaxSize, nil)
 	err = setEnvStr(err, EnvImageCleanExemptTags, &cfg.ImageCleanExemptTags)
 	err = setEnvBool(err, EnvImageEnableVolume, &cfg.ImageEnableVolume)
+	err = setEnvStr(err, EnvSecretsURL, &cfg.SecretsURL)
+	err = setEnvStr(err, EnvSecretsPrefix, &cfg.SecretsPrefix)
+	err = setEnvStr(err, EnvEgressPolicyImage, &cfg.EgressPolicyImage)
+	err = setEnvStr(err, EnvImageVerifyKeys, &cfg.ImageVerifyKeys)
+	err = setEnvBool(err, EnvCheckpointRestore, &cfg.CheckpointRestore)
 
 	if err != nil {
 		return cfg, err
@@ -214,6 +361,24 @@ func NewConfig() (*Config, error) {
 		// for safety during uint64 to int conversions in Write()/Read(), etc.
 		return cfg, fmt.Errorf("error invalid %s %v > %v", EnvMaxLogSize, cfg.MaxLogSize, math.MaxInt64)
 	}
+	if cfg.BatchHeadroom > 100 {
+		return cfg, fmt.Errorf("error invalid %s %v > 100", EnvBatchHeadroom, cfg.BatchHeadroom)
+	}
+	if cfg.MaxMemoryPressure > 100 {
+		return cfg, fmt.Errorf("error invalid %s %v > 100", EnvMaxMemoryPressure, cfg.MaxMemoryPressure)
+	}
+	if cfg.MaxCPUPressure > 100 {
+		return cfg, fmt.Errorf("error invalid %s %v > 100", EnvMaxCPUPressure, cfg.MaxCPUPressure)
+	}
+	if cfg.MemoryOvercommit != 0 && (cfg.MemoryOvercommit < 100 || cfg.MemoryOvercommit > maxMemoryOvercommit) {
+		return cfg, fmt.Errorf("error invalid %s %v, it should be between 100 and %d", EnvMemoryOvercommit, cfg.MemoryOvercommit, maxMemoryOvercommit)
+	}
+	if cfg.OvercommitEvictPressure > 100 {
+		return cfg, fmt.Errorf("error invalid %s %v > 100", EnvOvercommitEvictPressure, cfg.OvercommitEvictPressure)
+	}
+	if !strings.Contains(cfg.SecretsPrefix, "{app_id}") {
+		return cfg, fmt.Errorf("error invalid %s %s, it should contain {app_id}", EnvSecretsPrefix, cfg.SecretsPrefix)
+	}
 
 	return cfg, nil
 }
@@ -242,6 +407,20 @@ func setEnvBool(err error, name string, dst *bool) error {
 	return nil
 }
 
+func setEnvFloat(err error, name string, dst *float64) error {
+	if err != nil {
+		return err
+	}
+	if tmp := os.Getenv(name); tmp != "" {
+		val, err := strconv.ParseFloat(tmp, 64)
+		if err != nil {
+			return fmt.Errorf("error invalid %s=%s", name, tmp)
+		}
+		*dst = val
+	}
+	return nil
+}
+
 func setEnvUint(err error, name string, dst *uint64, defaultValue *uint64) error {
 	if err != nil {
 		return err
@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	"github.com/sirupsen/logrus"
+)
+
+// secretTemplatePrefix starts the config templates of secrets, eg. ${secret:db#password}
+const secretTemplatePrefix = "secret:"
+
+// expandConfig expands the templates of the config of call in the env of c before it is
+// created, if the annotations of call turn them on, see models.ConfigTemplatesAnnotation
+func (a *agent) expandConfig(ctx context.Context, call *call, c *container) error {
+	// annotations are validated with the app and fn
+	if on, _ := call.Annotations.ConfigTemplates(); !on {
+		return nil
+	}
+
+	x := &configExpander{
+		raw:       c.env,
+		expanded:  make(map[string]string, len(c.env)),
+		expanding: make(map[string]bool),
+		secret: func(ref string) (string, error) {
+			if a.secrets == nil {
+				return "", models.ErrSecretsUnsupported
+			}
+			v, err := a.secrets.Get(ctx, a.secretRef(call, ref))
+			if err != nil {
+				// the error may carry the secret store address, the call only learns which secret failed
+				common.Logger(ctx).WithError(err).WithFields(logrus.Fields{"secret_ref": ref}).Error("failed to resolve secret")
+				return "", models.ErrSecretsUnavailable
+			}
+			return string(v), nil
+		},
+	}
+	env := make(map[string]string, len(c.env))
+	for k := range c.env {
+		v, err := x.get(k)
+		if err != nil {
+			return err
+		}
+		env[k] = v
+	}
+	c.env = env
+	return nil
+}
+
+// configExpander expands the templates of config values, each value once
+type configExpander struct {
+	raw       map[string]string
+	expanded  map[string]string
+	expanding map[string]bool
+	secret    func(ref string) (string, error)
+}
+
+func configTemplateError(key, format string, args ...interface{}) error {
+	return models.NewAPIError(http.StatusBadRequest, fmt.Errorf("Invalid template of config %s, %s", key, fmt.Sprintf(format, args...)))
+}
+
+// get returns the expanded value of the config var key
+func (x *configExpander) get(key string) (string, error) {
+	if v, ok := x.expanded[key]; ok {
+		return v, nil
+	}
+	if x.expanding[key] {
+		return "", configTemplateError(key, "it references itself, maybe through other vars")
+	}
+	x.expanding[key] = true
+	defer delete(x.expanding, key)
+
+	v, err := x.expand(key, x.raw[key])
+	if err != nil {
+		return "", err
+	}
+	x.expanded[key] = v
+	return v, nil
+}
+
+// expand returns s, the value of the config var key, with its templates expanded
+func (x *configExpander) expand(key, s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+
+		switch {
+		case strings.HasPrefix(s, "$${"):
+			b.WriteString("${")
+			s = s[3:]
+		case strings.HasPrefix(s, "${"):
+			end := strings.IndexByte(s, '}')
+			if end < 0 {
+				return "", configTemplateError(key, "a ${ is not closed")
+			}
+			v, err := x.resolve(key, s[2:end])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			s = s[end+1:]
+		default:
+			b.WriteByte('$')
+			s = s[1:]
+		}
+	}
+}
+
+// resolve returns the value of the template ref in the value of the config var key
+func (x *configExpander) resolve(key, ref string) (string, error) {
+	if strings.HasPrefix(ref, secretTemplatePrefix) {
+		// secrets are relative to the secrets of the app, see agent.secretRef
+		ref = strings.TrimPrefix(ref, secretTemplatePrefix)
+		if !models.ValidSecretRef(ref) {
+			return "", configTemplateError(key, "the secret %s is out of the secrets of the app", ref)
+		}
+		return x.secret(ref)
+	}
+
+	name, def, hasDef := ref, "", false
+	if i := strings.Index(ref, ":-"); i >= 0 {
+		name, def, hasDef = ref[:i], ref[i+2:], true
+	}
+	if name == "" {
+		return "", configTemplateError(key, "a template has no name")
+	}
+	if _, ok := x.raw[name]; !ok {
+		if hasDef {
+			return def, nil
+		}
+		return "", configTemplateError(key, "%s is not set", name)
+	}
+	return x.get(name)
+}
@@ -4,6 +4,11 @@ import (
 	"errors"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/agent/drivers/mock"
+	"github.com/fnproject/fn/grpcutil"
 )
 
 // TestSetEnvUintPointer tests the normal use cases
@@ -139,3 +144,125 @@ func TestSetEnvUintError(t *testing.T) {
 		})
 	}
 }
+
+func TestRunnerClientConfig(t *testing.T) {
+	defer os.Unsetenv(EnvRunnerMaxDataChunk)
+	defer os.Unsetenv(EnvRunnerGRPCWindowSize)
+
+	cfg, err := NewRunnerClientConfig()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cfg.Connections != 1 || cfg.MaxDataChunk != MaxDataChunk || cfg.InitialWindowSize != 0 {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+	if len(cfg.RunnerOptions()) != 2 || len(cfg.PureRunnerOptions()) != 2 {
+		t.Fatalf("expected no window size options for defaults")
+	}
+
+	os.Setenv(EnvRunnerMaxDataChunk, "65536")
+	os.Setenv(EnvRunnerGRPCWindowSize, "1048576")
+	cfg, err = NewRunnerClientConfig()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cfg.MaxDataChunk != 65536 || cfg.InitialWindowSize != 1048576 {
+		t.Fatalf("env not applied %+v", cfg)
+	}
+	if len(cfg.RunnerOptions()) != 3 || len(cfg.PureRunnerOptions()) != 3 {
+		t.Fatalf("expected window size options")
+	}
+
+	os.Setenv(EnvRunnerGRPCWindowSize, "1024")
+	_, err = NewRunnerClientConfig()
+	if err == nil {
+		t.Fatalf("expected error for window size below gRPC minimum")
+	}
+}
+
+func TestRunnerClientConfigKeepalive(t *testing.T) {
+	defer os.Unsetenv(EnvRunnerKeepaliveTime)
+
+	os.Setenv(EnvRunnerKeepaliveTime, "30000")
+	cfg, err := NewRunnerClientConfig()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cfg.KeepaliveTime != 30*time.Second || cfg.KeepaliveTimeout != 20*time.Second {
+		t.Fatalf("env not applied %+v", cfg)
+	}
+	if len(cfg.RunnerOptions()) != 3 || len(cfg.PureRunnerOptions()) != 3 {
+		t.Fatalf("expected keepalive options")
+	}
+
+	os.Setenv(EnvRunnerKeepaliveTime, "-1")
+	cfg, err = NewRunnerClientConfig()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if cfg.KeepaliveTime != 0 {
+		t.Fatalf("expected keepalive disabled, got %v", cfg.KeepaliveTime)
+	}
+}
+
+func TestRunnerClientConfigDialBackoff(t *testing.T) {
+	defer os.Unsetenv(EnvRunnerDialTimeout)
+	defer os.Unsetenv(EnvRunnerDialBackoffBase)
+	defer os.Unsetenv(EnvRunnerDialBackoffMultiplier)
+
+	os.Setenv(EnvRunnerDialTimeout, "2000")
+	os.Setenv(EnvRunnerDialBackoffBase, "250")
+	os.Setenv(EnvRunnerDialBackoffMultiplier, "2.5")
+	cfg, err := NewRunnerClientConfig()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	backoff, ok := cfg.DialBackoff()
+	if !ok || backoff.BaseDelay != 250*time.Millisecond || backoff.Multiplier != 2.5 || backoff.MaxDelay != grpcutil.DefaultBackoffConfig.MaxDelay {
+		t.Fatalf("env not applied %+v", backoff)
+	}
+	if cfg.DialTimeout != 2*time.Second || len(cfg.RunnerOptions()) != 4 {
+		t.Fatalf("expected dial timeout and backoff options")
+	}
+
+	os.Setenv(EnvRunnerDialBackoffMultiplier, "0.5")
+	_, err = NewRunnerClientConfig()
+	if err == nil {
+		t.Fatalf("expected error for a backoff multiplier below one")
+	}
+}
+
+// TestNewDriver tests selecting a registered container driver by name
+func TestNewDriver(t *testing.T) {
+	var got drivers.Config
+	drivers.Register("test-driver", func(config drivers.Config) (drivers.Driver, error) {
+		got = config
+		return mock.New(), nil
+	})
+
+	cfg := &Config{ContainerDriver: "test-driver", DockerNetworks: "fn-net"}
+	if _, err := NewDriver(cfg); err != nil {
+		t.Fatalf("Unexpected error creating the driver: %v", err)
+	}
+	if got.DockerNetworks != "fn-net" {
+		t.Fatalf("Expected the driver created with the agent config, got %+v", got)
+	}
+
+	cfg.ContainerDriver = "not-registered"
+	if _, err := NewDriver(cfg); err == nil {
+		t.Fatal("Expected an error for a driver that is not registered")
+	}
+}
+
+func TestSecretsPrefix(t *testing.T) {
+	cfg, err := NewConfig()
+	if err != nil || cfg.SecretsPrefix != "secret/data/fn/{app_id}/" {
+		t.Fatalf("Expected the default secrets prefix, got %s %v", cfg.SecretsPrefix, err)
+	}
+
+	os.Setenv(EnvSecretsPrefix, "secret/data/shared/")
+	defer os.Unsetenv(EnvSecretsPrefix)
+	if _, err := NewConfig(); err == nil {
+		t.Fatal("Expected an error for a secrets prefix shared by all apps")
+	}
+}
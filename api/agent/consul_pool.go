@@ -0,0 +1,316 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fnproject/fn/api/common"
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultConsulAddress is the Consul agent used if CONSUL_HTTP_ADDR is not set
+	DefaultConsulAddress = "127.0.0.1:8500"
+	// how long a blocking query for the registered runners waits for changes
+	consulWatchWait = 5 * time.Minute
+)
+
+// consulClient makes requests to the HTTP API of a Consul agent
+type consulClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// newConsulClient returns a client for the Consul agent at addr, a host:port or URL, or
+// CONSUL_HTTP_ADDR if empty. CONSUL_HTTP_TOKEN is used as ACL token, if set.
+func newConsulClient(addr string) *consulClient {
+	if addr == "" {
+		addr = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if addr == "" {
+		addr = DefaultConsulAddress
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &consulClient{
+		server: strings.TrimSuffix(addr, "/"),
+		token:  os.Getenv("CONSUL_HTTP_TOKEN"),
+		client: &http.Client{},
+	}
+}
+
+// do makes a request with body, if not nil, JSON encoded, and decodes a JSON response into
+// out, if not nil. Returns the response, with a closed body.
+func (c *consulClient) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, c.server+path+"?"+query.Encode(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return resp, fmt.Errorf("Consul returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil && resp.StatusCode == http.StatusOK {
+		err = json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp, err
+}
+
+// consulKVPair is an entry of a Consul KV listing
+type consulKVPair struct {
+	Key     string
+	Value   []byte
+	Session string
+}
+
+// consulRunnerPool manages the runners registered under a Consul KV prefix, see
+// RegisterConsulRunner
+type consulRunnerPool struct {
+	static *staticRunnerPool
+	client *consulClient
+	prefix string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func DefaultConsulRunnerPool(prefix string) pool.RunnerPool {
+	cfg, err := NewRunnerClientConfig()
+	if err != nil {
+		logrus.WithError(err).Fatalf("error in runner client config cfg=%+v", cfg)
+	}
+	return NewConsulRunnerPoolWithOptions("", prefix, nil, cfg.RunnerOptions()...)
+}
+
+// NewConsulRunnerPoolWithOptions creates a pool of the runners registered under prefix in
+// the KV store of the Consul agent at consulAddr (CONSUL_HTTP_ADDR if empty), each configured
+// with runnerOpts. The registrations are watched, runners that register are added and runners
+// whose registration is removed or expires are drained as with SetRunners of a static pool.
+func NewConsulRunnerPoolWithOptions(consulAddr, prefix string, tlsConf *tls.Config, runnerOpts ...GRPCRunnerOption) pool.RunnerPool {
+	return newConsulRunnerPool(newConsulClient(consulAddr), prefix, tlsConf, runnerOpts...)
+}
+
+func newConsulRunnerPool(client *consulClient, prefix string, tlsConf *tls.Config, runnerOpts ...GRPCRunnerOption) *consulRunnerPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	rp := &consulRunnerPool{
+		static: NewStaticRunnerPoolWithOptions(nil, tlsConf, runnerOpts...).(*staticRunnerPool),
+		client: client,
+		prefix: consulPrefix(prefix),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go rp.watch(ctx)
+	return rp
+}
+
+// consulPrefix returns the KV path of a registry prefix, ending with a slash
+func consulPrefix(prefix string) string {
+	return strings.Trim(prefix, "/") + "/"
+}
+
+// watch keeps the runners in sync with the registrations until ctx is done
+func (rp *consulRunnerPool) watch(ctx context.Context) {
+	defer close(rp.done)
+	log := logrus.WithField("consul_prefix", rp.prefix)
+
+	backoffCfg := common.BackOffConfig{MaxRetries: common.RetryForever, Interval: 100, MinDelay: 100, MaxDelay: 30000}
+	backoff := common.NewBackOff(backoffCfg)
+	var index uint64
+	for ctx.Err() == nil {
+		next, err := rp.poll(ctx, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.WithError(err).Warn("Failed to get registered runners, keeping current runners")
+			delay, _ := backoff.NextBackOff()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			continue
+		}
+		backoff = common.NewBackOff(backoffCfg)
+		// the index must only increase, start over if it went backwards
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
+
+// poll waits for the registrations to change after index, then updates the runners with
+// them. Returns the index of the registrations.
+func (rp *consulRunnerPool) poll(ctx context.Context, index uint64) (uint64, error) {
+	query := url.Values{"recurse": {"true"}, "wait": {fmt.Sprintf("%ds", int(consulWatchWait/time.Second))}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+	}
+
+	var pairs []consulKVPair
+	resp, err := rp.client.do(ctx, "GET", "/v1/kv/"+rp.prefix, query, nil, &pairs)
+	if err != nil {
+		return 0, err
+	}
+	next, err := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid X-Consul-Index from Consul: %v", err)
+	}
+
+	var addrs []string
+	for _, pair := range pairs {
+		var reg RunnerRegistration
+		if json.Unmarshal(pair.Value, &reg) != nil || reg.Address == "" {
+			logrus.WithField("consul_key", pair.Key).Warn("Ignoring invalid runner registration")
+			continue
+		}
+		addrs = append(addrs, reg.runnerAddress())
+	}
+
+	sort.Strings(addrs)
+	current := rp.static.RunnerAddresses()
+	sort.Strings(current)
+	if !equalStrings(addrs, current) {
+		logrus.WithFields(logrus.Fields{"consul_prefix": rp.prefix, "runners": addrs}).Info("Registered runners changed, updating runner pool")
+		err = rp.static.SetRunners(ctx, addrs)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to update runner pool")
+		}
+	}
+	return next, nil
+}
+
+func (rp *consulRunnerPool) Runners(ctx context.Context, call pool.RunnerCall) ([]pool.Runner, error) {
+	return rp.static.Runners(ctx, call)
+}
+
+// RunnersAdded implements pool.RunnerPoolNotifier
+func (rp *consulRunnerPool) RunnersAdded() <-chan struct{} {
+	return rp.static.RunnersAdded()
+}
+
+func (rp *consulRunnerPool) Shutdown(ctx context.Context) error {
+	rp.cancel()
+	select {
+	case <-rp.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return rp.static.Shutdown(ctx)
+}
+
+var _ pool.RunnerPoolNotifier = &consulRunnerPool{}
+
+// RegisterConsulRunner registers reg under prefix in the KV store of the Consul agent at
+// consulAddr (CONSUL_HTTP_ADDR if empty) until ctx is done, then removes the registration.
+// The registration is held by a Consul session with the given TTL, renewed at half the TTL,
+// so that it expires if the runner goes away without removing it. Registration failures
+// are retried with a backoff.
+func RegisterConsulRunner(ctx context.Context, consulAddr, prefix string, reg RunnerRegistration, ttl time.Duration) {
+	registerConsulRunner(ctx, newConsulClient(consulAddr), prefix, reg, ttl)
+}
+
+func registerConsulRunner(ctx context.Context, client *consulClient, prefix string, reg RunnerRegistration, ttl time.Duration) {
+	log := logrus.WithFields(logrus.Fields{"consul_prefix": prefix, "runner_addr": reg.Address})
+	key := "/v1/kv/" + consulPrefix(prefix) + url.PathEscape(reg.Address)
+
+	backoffCfg := common.BackOffConfig{MaxRetries: common.RetryForever, Interval: 100, MinDelay: 100, MaxDelay: 30000}
+	backoff := common.NewBackOff(backoffCfg)
+	for ctx.Err() == nil {
+		err := holdConsulRegistration(ctx, client, key, reg, ttl)
+		if ctx.Err() != nil {
+			return
+		}
+		log.WithError(err).Warn("Lost runner registration, registering again")
+
+		delay, _ := backoff.NextBackOff()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// holdConsulRegistration registers reg at key with a new session and renews the session
+// until ctx is done, when the session is destroyed, or renewing fails
+func holdConsulRegistration(ctx context.Context, client *consulClient, key string, reg RunnerRegistration, ttl time.Duration) error {
+	var session struct{ ID string }
+	_, err := client.do(ctx, "PUT", "/v1/session/create", nil, map[string]string{
+		"Name":      "fn-runner-" + reg.Address,
+		"TTL":       fmt.Sprintf("%ds", int((ttl+time.Second-1)/time.Second)),
+		"Behavior":  "delete",
+		"LockDelay": "0s",
+	}, &session)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// the registration is deleted along with the session
+		destroyCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		client.do(destroyCtx, "PUT", "/v1/session/destroy/"+session.ID, nil, nil, nil)
+	}()
+
+	var acquired bool
+	_, err = client.do(ctx, "PUT", key, url.Values{"acquire": {session.ID}}, &reg, &acquired)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return fmt.Errorf("Runner registration %s is held by another session", key)
+	}
+	logrus.WithField("runner_addr", reg.Address).Info("Registered runner in Consul")
+
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		resp, err := client.do(ctx, "PUT", "/v1/session/renew/"+session.ID, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("Consul session %s expired", session.ID)
+		}
+	}
+}
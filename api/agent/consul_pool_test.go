@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pool "github.com/fnproject/fn/api/runnerpool"
+)
+
+// fakeConsul implements the parts of the Consul HTTP API used by the runner registry
+type fakeConsul struct {
+	mtx      sync.Mutex
+	changed  *sync.Cond
+	index    uint64
+	kv       map[string]consulKVPair
+	sessions map[string]bool
+	nextID   int
+}
+
+func newFakeConsul() *fakeConsul {
+	c := &fakeConsul{kv: make(map[string]consulKVPair), sessions: make(map[string]bool)}
+	c.changed = sync.NewCond(&c.mtx)
+	return c
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	switch path := r.URL.Path; {
+	case path == "/v1/session/create":
+		c.nextID++
+		id := fmt.Sprintf("session%d", c.nextID)
+		c.sessions[id] = true
+		json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(path, "/v1/session/renew/"):
+		if !c.sessions[strings.TrimPrefix(path, "/v1/session/renew/")] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case strings.HasPrefix(path, "/v1/session/destroy/"):
+		id := strings.TrimPrefix(path, "/v1/session/destroy/")
+		delete(c.sessions, id)
+		for k, pair := range c.kv {
+			if pair.Session == id {
+				delete(c.kv, k)
+			}
+		}
+		c.index++
+		c.changed.Broadcast()
+	case r.Method == "PUT" && strings.HasPrefix(path, "/v1/kv/"):
+		key := strings.TrimPrefix(path, "/v1/kv/")
+		session := r.URL.Query().Get("acquire")
+		if pair, ok := c.kv[key]; !c.sessions[session] || (ok && pair.Session != session) {
+			fmt.Fprint(w, "false")
+			return
+		}
+		value, _ := ioutil.ReadAll(r.Body)
+		c.kv[key] = consulKVPair{Key: key, Value: value, Session: session}
+		c.index++
+		c.changed.Broadcast()
+		fmt.Fprint(w, "true")
+	case r.Method == "GET" && strings.HasPrefix(path, "/v1/kv/"):
+		index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		for index != 0 && index >= c.index {
+			c.changed.Wait()
+		}
+		var pairs []consulKVPair
+		for k, pair := range c.kv {
+			if strings.HasPrefix(k, strings.TrimPrefix(path, "/v1/kv/")) {
+				pairs = append(pairs, pair)
+			}
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(c.index, 10))
+		if len(pairs) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(pairs)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestConsulRunnerRegistry(t *testing.T) {
+	consul := newFakeConsul()
+	srv := httptest.NewServer(consul)
+	defer srv.Close()
+	defer func() {
+		// wake up blocking queries
+		consul.mtx.Lock()
+		consul.index++
+		consul.changed.Broadcast()
+		consul.mtx.Unlock()
+	}()
+
+	client := &consulClient{server: srv.URL, client: srv.Client()}
+	rp := newConsulRunnerPool(client, "fn/runners", nil)
+	defer rp.Shutdown(context.Background())
+
+	waitRunners := func(expected []string) {
+		for i := 0; i < 500; i++ {
+			if reflect.DeepEqual(sortedRunnerAddrs(rp), expected) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Expected runners %v, got %v", expected, sortedRunnerAddrs(rp))
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	go registerConsulRunner(ctx1, client, "fn/runners", RunnerRegistration{Address: "192.0.2.1:9190", Capacity: 2}, time.Second)
+	go registerConsulRunner(ctx2, client, "fn/runners", RunnerRegistration{Address: "192.0.2.2:9190"}, time.Second)
+	waitRunners([]string{"192.0.2.1:9190", "192.0.2.2:9190"})
+
+	runners, _ := rp.Runners(context.Background(), nil)
+	for _, r := range runners {
+		if r.Address() == "192.0.2.1:9190" && pool.RunnerWeight(r) != 2 {
+			t.Fatalf("Expected capacity as runner weight, got %d", pool.RunnerWeight(r))
+		}
+	}
+
+	// runners that stop remove their registration
+	cancel1()
+	waitRunners([]string{"192.0.2.2:9190"})
+
+	// registrations of expired sessions are removed, the runner registers again
+	consul.mtx.Lock()
+	for id := range consul.sessions {
+		delete(consul.sessions, id)
+	}
+	consul.kv = make(map[string]consulKVPair)
+	consul.index++
+	consul.changed.Broadcast()
+	sessions := consul.nextID
+	consul.mtx.Unlock()
+	for i := 0; i < 500; i++ {
+		consul.mtx.Lock()
+		registered := consul.nextID > sessions && len(consul.kv) == 1
+		consul.mtx.Unlock()
+		if registered {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitRunners([]string{"192.0.2.2:9190"})
+	consul.mtx.Lock()
+	defer consul.mtx.Unlock()
+	if consul.nextID <= sessions {
+		t.Fatal("Expected the runner to register again with a new session")
+	}
+}
+
+func TestParseRunnerLabels(t *testing.T) {
+	labels, err := ParseRunnerLabels("gpu=true, zone=phx-1,,arch=arm64")
+	expected := map[string]string{"gpu": "true", "zone": "phx-1", "arch": "arm64"}
+	if err != nil || !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("Expected labels %v, got %v %v", expected, labels, err)
+	}
+	_, err = ParseRunnerLabels("gpu")
+	if err == nil {
+		t.Fatal("Expected an error for a label without value")
+	}
+}
+
+func TestWithPlatformLabels(t *testing.T) {
+	labels := WithPlatformLabels(map[string]string{"zone": "phx-1"})
+	expected := map[string]string{"zone": "phx-1", "os": runtime.GOOS, "arch": runtime.GOARCH}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("Expected labels %v, got %v", expected, labels)
+	}
+	labels = WithPlatformLabels(map[string]string{"arch": "arm64"})
+	if labels["arch"] != "arm64" || labels["os"] != runtime.GOOS {
+		t.Fatalf("Expected the configured arch to be kept, got %v", labels)
+	}
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dnsRunnerPool manages the runners a DNS name resolves to, resolved again on an interval.
+// Unlike a static pool, the runners cannot be set otherwise.
+type dnsRunnerPool struct {
+	static   *staticRunnerPool
+	name     string
+	interval time.Duration
+	resolver dnsResolver
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// dnsResolver is the subset of net.Resolver the DNS runner pool uses
+type dnsResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+func DefaultDNSRunnerPool(name string, interval time.Duration) pool.RunnerPool {
+	cfg, err := NewRunnerClientConfig()
+	if err != nil {
+		logrus.WithError(err).Fatalf("error in runner client config cfg=%+v", cfg)
+	}
+	rp, err := NewDNSRunnerPoolWithOptions(name, interval, nil, cfg.RunnerOptions()...)
+	if err != nil {
+		logrus.WithError(err).Fatal("error in dns runner pool config")
+	}
+	return rp
+}
+
+// NewDNSRunnerPoolWithOptions creates a pool of the runners name resolves to, each configured
+// with runnerOpts, and resolves name again every interval to add and remove runners as with
+// SetRunners of a static pool. A name with a port, eg. "runners.example.com:9190", is resolved
+// to its A and AAAA records, the runners listening on that port. A name without one is resolved
+// to its SRV records, eg. "_fn._tcp.runners.example.com", of which only the records with the
+// lowest priority are used and the SRV weights become runner weights. Runners are kept when
+// name cannot be resolved.
+func NewDNSRunnerPoolWithOptions(name string, interval time.Duration, tlsConf *tls.Config, runnerOpts ...GRPCRunnerOption) (pool.RunnerPool, error) {
+	return newDNSRunnerPool(name, interval, net.DefaultResolver, tlsConf, runnerOpts...)
+}
+
+func newDNSRunnerPool(name string, interval time.Duration, resolver dnsResolver, tlsConf *tls.Config, runnerOpts ...GRPCRunnerOption) (*dnsRunnerPool, error) {
+	if name == "" {
+		return nil, fmt.Errorf("Invalid runner DNS name %q", name)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("Invalid runner DNS resolve interval %v", interval)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rp := &dnsRunnerPool{
+		static:   NewStaticRunnerPoolWithOptions(nil, tlsConf, runnerOpts...).(*staticRunnerPool),
+		name:     name,
+		interval: interval,
+		resolver: resolver,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	rp.resolve(ctx)
+	go rp.watch(ctx)
+	return rp, nil
+}
+
+// watch resolves the runners every interval until ctx is done
+func (rp *dnsRunnerPool) watch(ctx context.Context) {
+	defer close(rp.done)
+
+	ticker := time.NewTicker(rp.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rp.resolve(ctx)
+		}
+	}
+}
+
+// resolve updates the runners with the backends name resolves to
+func (rp *dnsRunnerPool) resolve(ctx context.Context) {
+	log := logrus.WithField("runner_dns_name", rp.name)
+
+	lookupCtx, cancel := context.WithTimeout(ctx, rp.interval)
+	addrs, err := rp.lookup(lookupCtx)
+	cancel()
+	if err != nil {
+		log.WithError(err).Warn("Failed to resolve runners, keeping current runners")
+		return
+	}
+
+	sort.Strings(addrs)
+	current := rp.static.RunnerAddresses()
+	sort.Strings(current)
+	if equalStrings(addrs, current) {
+		return
+	}
+
+	log.WithField("runners", addrs).Info("Resolved runners changed, updating runner pool")
+	err = rp.static.SetRunners(ctx, addrs)
+	if err != nil {
+		log.WithError(err).Error("Failed to update runner pool")
+	}
+}
+
+// lookup returns the runner addresses, with their weights, name resolves to
+func (rp *dnsRunnerPool) lookup(ctx context.Context) ([]string, error) {
+	if host, port, err := net.SplitHostPort(rp.name); err == nil {
+		ips, err := rp.resolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		addrs := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+		return addrs, nil
+	}
+
+	_, srvs, err := rp.resolver.LookupSRV(ctx, "", "", rp.name)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, srv := range srvs {
+		// records are sorted by priority, the others are backups
+		if srv.Priority != srvs[0].Priority {
+			break
+		}
+		target := srv.Target
+		if len(target) > 1 && target[len(target)-1] == '.' {
+			target = target[:len(target)-1]
+		}
+		addrs = append(addrs, runnerAddressEntry(net.JoinHostPort(target, strconv.Itoa(int(srv.Port))), int(srv.Weight), nil))
+	}
+	return addrs, nil
+}
+
+func (rp *dnsRunnerPool) Runners(ctx context.Context, call pool.RunnerCall) ([]pool.Runner, error) {
+	return rp.static.Runners(ctx, call)
+}
+
+// RunnersAdded implements pool.RunnerPoolNotifier
+func (rp *dnsRunnerPool) RunnersAdded() <-chan struct{} {
+	return rp.static.RunnersAdded()
+}
+
+func (rp *dnsRunnerPool) Shutdown(ctx context.Context) error {
+	rp.cancel()
+	select {
+	case <-rp.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return rp.static.Shutdown(ctx)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var _ pool.RunnerPoolNotifier = &dnsRunnerPool{}
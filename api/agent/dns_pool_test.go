@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	pool "github.com/fnproject/fn/api/runnerpool"
+)
+
+// mockResolver resolves names from its records
+type mockResolver struct {
+	mtx   sync.Mutex
+	hosts map[string][]string
+	srvs  map[string][]*net.SRV
+}
+
+func (r *mockResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (r *mockResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if srvs, ok := r.srvs[name]; ok {
+		return name, srvs, nil
+	}
+	return "", nil, errors.New("no such host")
+}
+
+func (r *mockResolver) setHosts(host string, addrs ...string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.hosts[host] = addrs
+}
+
+func sortedRunnerAddrs(rp pool.RunnerPool) []string {
+	runners, _ := rp.Runners(context.Background(), nil)
+	var addrs []string
+	for _, r := range runners {
+		addrs = append(addrs, r.Address())
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func TestDNSRunnerPool(t *testing.T) {
+	resolver := &mockResolver{hosts: map[string][]string{"runners.example.com": {"192.0.2.2", "192.0.2.1"}}}
+	rp, err := newDNSRunnerPool("runners.example.com:9190", 10*time.Millisecond, resolver, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer rp.Shutdown(context.Background())
+
+	expected := []string{"192.0.2.1:9190", "192.0.2.2:9190"}
+	if addrs := sortedRunnerAddrs(rp); !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("Expected runners %v, got %v", expected, addrs)
+	}
+	runners, _ := rp.Runners(context.Background(), nil)
+
+	added := rp.RunnersAdded()
+	resolver.setHosts("runners.example.com", "192.0.2.3", "192.0.2.1")
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected resolved runner to be added")
+	}
+	expected = []string{"192.0.2.1:9190", "192.0.2.3:9190"}
+	if addrs := sortedRunnerAddrs(rp); !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("Expected runners %v, got %v", expected, addrs)
+	}
+	kept, _ := rp.Runners(context.Background(), nil)
+	for _, r := range kept {
+		if r.Address() == "192.0.2.1:9190" && r != runners[0] && r != runners[1] {
+			t.Fatal("Expected runner resolved again to be kept")
+		}
+	}
+
+	// runners are kept when the name does not resolve
+	resolver.mtx.Lock()
+	delete(resolver.hosts, "runners.example.com")
+	resolver.mtx.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	if addrs := sortedRunnerAddrs(rp); !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("Expected runners %v, got %v", expected, addrs)
+	}
+}
+
+func TestDNSRunnerPoolSRV(t *testing.T) {
+	resolver := &mockResolver{srvs: map[string][]*net.SRV{"_fn._tcp.runners.example.com": {
+		{Target: "big.example.com.", Port: 9190, Priority: 1, Weight: 4},
+		{Target: "small.example.com.", Port: 9191, Priority: 1},
+		{Target: "backup.example.com.", Port: 9190, Priority: 2},
+	}}}
+	rp, err := newDNSRunnerPool("_fn._tcp.runners.example.com", time.Hour, resolver, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer rp.Shutdown(context.Background())
+
+	runners, _ := rp.Runners(context.Background(), nil)
+	if len(runners) != 2 || runners[0].Address() != "big.example.com:9190" || pool.RunnerWeight(runners[0]) != 4 || runners[1].Address() != "small.example.com:9191" {
+		t.Fatalf("Expected runners of the lowest priority, got %v", sortedRunnerAddrs(rp))
+	}
+}
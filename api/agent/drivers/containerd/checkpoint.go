@@ -0,0 +1,171 @@
+package containerd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	containerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/common"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// checkpointPrefix is the prefix of the names of the checkpoint images of the driver
+	checkpointPrefix = "fn-checkpoint-"
+	// checkpointLinkLabel prefixes the labels of checkpoint images with the symlinks of the
+	// iofs of the checkpointed container, which are not part of the checkpoint as the iofs
+	// is a bind mount
+	checkpointLinkLabel = "fn.iofs.link/"
+	// checkpointLabel is the label of containerd of checkpoint images
+	checkpointLabel = "containerd.io/checkpoint"
+)
+
+// checkpointName returns the name of the checkpoint image of the containers of the cookie
+// with image, from the digest of their image and the settings of their task, so that only
+// the containers of the same fn with the same config restore it. It is empty if the
+// containers cannot be checkpointed, ie. with GPUs.
+func (c *cookie) checkpointName(image digest.Digest) string {
+	if len(c.task.GPUs()) > 0 {
+		return ""
+	}
+	b, err := json.Marshal(struct {
+		Image          digest.Digest
+		Runtime        string
+		Cmd            []string
+		Env            map[string]string
+		Memory         uint64
+		CPUs           uint64
+		PIDs           uint64
+		OpenFiles      *uint64
+		LockedMemory   *uint64
+		PendingSignals *uint64
+		MessageQueue   *uint64
+		TmpFsSize      uint64
+		ReadOnlyRootFs bool
+		Volumes        [][2]string
+		WorkDir        string
+		UDSDockerDest  string
+		DisableNet     bool
+		DNS            drivers.DNS
+		BlockIO        drivers.BlockIO
+	}{
+		image, c.runtime, c.cmd, c.task.EnvVars(), c.task.Memory(), c.task.CPUs(), c.task.PIDs(),
+		c.task.OpenFiles(), c.task.LockedMemory(), c.task.PendingSignals(), c.task.MessageQueue(),
+		c.task.TmpFsSize(), c.task.ReadOnlyRootFs(), c.task.Volumes(), c.task.WorkDir(), c.task.UDSDockerDest(),
+		c.task.DisableNet(), c.task.DNS(), c.task.BlockIO(),
+	})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return checkpointPrefix + hex.EncodeToString(sum[:16])
+}
+
+// checkpoint returns the checkpoint image the container of the cookie is restored from,
+// nil if there is none
+func (c *cookie) checkpoint(ctx context.Context) containerd.Image {
+	if !c.drv.conf.CheckpointRestore || c.checkpointRef == "" {
+		return nil
+	}
+	img, err := c.drv.client.GetImage(ctx, c.checkpointRef)
+	if err != nil {
+		if !errdefs.IsNotFound(err) {
+			common.Logger(ctx).WithError(err).WithFields(logrus.Fields{"call_id": c.task.Id(), "checkpoint": c.checkpointRef}).Error("cannot get checkpoint")
+		}
+		return nil
+	}
+	return img
+}
+
+// removeCheckpoint removes the checkpoint of the cookie, which could not be restored, and
+// does not take another one for its fn
+func (c *cookie) removeCheckpoint(ctx context.Context) {
+	c.drv.checkpointed.Store(c.checkpointRef, struct{}{})
+	if err := c.drv.client.ImageService().Delete(ctx, c.checkpointRef); err != nil && !errdefs.IsNotFound(err) {
+		common.Logger(ctx).WithError(err).WithFields(logrus.Fields{"call_id": c.task.Id(), "checkpoint": c.checkpointRef}).Error("cannot remove checkpoint")
+	}
+	c.restore = nil
+}
+
+// Checkpoint implements drivers.Checkpointer, it checkpoints the running container of the
+// cookie with CRIU, which is paused meanwhile, unless it was restored from a checkpoint or
+// its fn was checkpointed already. Links of its iofs are kept in the labels of the
+// checkpoint to be restored with it.
+func (c *cookie) Checkpoint(ctx context.Context) error {
+	if !c.drv.conf.CheckpointRestore || c.ctask == nil || c.restore != nil || c.checkpointRef == "" {
+		return nil
+	}
+	if _, loaded := c.drv.checkpointed.LoadOrStore(c.checkpointRef, struct{}{}); loaded {
+		return nil
+	}
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "Checkpoint", "call_id": c.task.Id(), "checkpoint": c.checkpointRef})
+
+	labels, err := iofsLinks(c.task.UDSDockerPath())
+	if err != nil {
+		return err
+	}
+
+	img, err := c.ctask.Checkpoint(ctx, containerd.WithCheckpointName(c.checkpointRef))
+	if errdefs.IsAlreadyExists(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	labels[checkpointLabel] = "true"
+	if _, err := c.drv.client.ImageService().Update(ctx, images.Image{Name: img.Name(), Labels: labels}, "labels"); err != nil {
+		c.drv.client.ImageService().Delete(ctx, img.Name())
+		return err
+	}
+	log.Info("containerd checkpoint")
+	return nil
+}
+
+// iofsLinks returns the symlinks of the iofs dir as checkpoint labels
+func iofsLinks(dir string) (map[string]string, error) {
+	links := make(map[string]string)
+	if dir == "" {
+		return links, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		links[checkpointLinkLabel+f.Name()] = target
+	}
+	return links, nil
+}
+
+// restoreIOFSLinks creates the symlinks of the labels of checkpoint in the iofs dir, for
+// the agent to find the socket of the restored container
+func restoreIOFSLinks(dir string, labels map[string]string) error {
+	for k, target := range labels {
+		if !strings.HasPrefix(k, checkpointLinkLabel) {
+			continue
+		}
+		name := filepath.Base(strings.TrimPrefix(k, checkpointLinkLabel))
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
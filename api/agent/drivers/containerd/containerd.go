@@ -0,0 +1,353 @@
+package containerd
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	containerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/platforms"
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	FnAgentClassifierLabel = "fn-agent-classifier"
+	FnAgentInstanceLabel   = "fn-agent-instance"
+
+	// DefaultAddress is the socket of containerd used if neither drivers.Config.Docker nor
+	// the CONTAINERD_ADDRESS env are set
+	DefaultAddress = "/run/containerd/containerd.sock"
+	// DefaultNamespace is the containerd namespace of the containers and images of the
+	// driver if the CONTAINERD_NAMESPACE env is not set
+	DefaultNamespace = "fn"
+	// DefaultSnapshotter is the snapshotter of the images and containers of the driver if
+	// the CONTAINERD_SNAPSHOTTER env is not set
+	DefaultSnapshotter = containerd.DefaultSnapshotter
+)
+
+// ErrNetworkUnsupported is returned by CreateCookie for the tasks of functions with network
+// access, whose containers would need a network of their own
+var ErrNetworkUnsupported = models.NewAPIError(http.StatusNotImplemented, errors.New("Functions with network access are not supported by this runner, their egress must be disabled"))
+
+type runResult struct {
+	err    error
+	status string
+}
+
+func (r *runResult) Error() error   { return r.err }
+func (r *runResult) Status() string { return r.status }
+
+// ContainerdDriver implements drivers.Driver with the native client of containerd
+type ContainerdDriver struct {
+	cancel     func()
+	conf       drivers.Config
+	client     *containerd.Client
+	hostname   string
+	instanceId string
+	// platform of the containers of containerd
+	platform models.Platform
+	// snapshotter of images and containers, lazyPull whether it mounts the layers of
+	// images from their registry, see pullLazily
+	snapshotter string
+	lazyPull    bool
+	// names of the checkpoints taken or attempted, see cookie.Checkpoint
+	checkpointed sync.Map
+
+	// backoff/retry settings of image pulls
+	isRetriable drivers.RetryErrorChecker
+	backOffCfg  common.BackOffConfig
+}
+
+// NewContainerd returns a driver of the containerd at conf.Docker or else the
+// CONTAINERD_ADDRESS env, DefaultAddress if neither is set. Its containers and images are
+// in the namespace of the CONTAINERD_NAMESPACE env, DefaultNamespace if not set, and
+// unpacked with the snapshotter of the CONTAINERD_SNAPSHOTTER env, DefaultSnapshotter if
+// not set.
+//
+// With the CONTAINERD_LAZY_PULL env set to true, images are pulled lazily: layers are not
+// fetched but mounted from the registry by the snapshotter, which must be a remote
+// snapshotter such as the stargz or soci snapshotters, with containerd 1.4 or later. It
+// fetches layers with its own registry credentials. Functions then start before their
+// whole image is downloaded.
+//
+// With drivers.Config.CheckpointRestore, the containers of fns are checkpointed with CRIU
+// once initialized and later containers of the fns restore the checkpoints, which are
+// images of containerd named fn-checkpoint-<hash of the image and config of the fn>. CRIU
+// must be installed on the host. Containers with GPUs are not checkpointed.
+//
+// containerd has no networking of its own, containers run in a network namespace of
+// their own with only a loopback interface and calls of functions with network access
+// are rejected, their egress must be disabled. Sidecars, egress allow lists, image
+// signatures, storage size limits and docker networks and pools are not supported.
+func NewContainerd(conf drivers.Config) (*ContainerdDriver, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	if conf.ImageVerifyKeys != "" {
+		return nil, models.ErrImageVerifyUnsupported
+	}
+
+	// This is for testing purposes. Tests override with custom id
+	instanceId := conf.InstanceId
+	if instanceId == "" {
+		instanceId, err = generateRandUUID()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := containerd.New(containerdAddress(conf), containerd.WithDefaultNamespace(containerdNamespace()))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	driver := &ContainerdDriver{
+		cancel:      cancel,
+		conf:        conf,
+		client:      client,
+		hostname:    hostname,
+		instanceId:  instanceId,
+		platform:    hostPlatform(),
+		snapshotter: containerdSnapshotter(),
+		lazyPull:    os.Getenv("CONTAINERD_LAZY_PULL") == "true",
+		isRetriable: func(error) (bool, string) { return false, "" },
+	}
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+	logrus.WithFields(logrus.Fields{"version": version.Version, "platform": driver.platform, "snapshotter": driver.snapshotter, "lazy_pull": driver.lazyPull}).Info("containerd version")
+
+	go killLeakedContainers(ctx, driver)
+
+	return driver, nil
+}
+
+// containerdAddress returns the endpoint of containerd of conf, or of the
+// CONTAINERD_ADDRESS env, or its default
+func containerdAddress(conf drivers.Config) string {
+	if conf.Docker != "" {
+		return strings.TrimPrefix(conf.Docker, "unix://")
+	}
+	if addr := os.Getenv("CONTAINERD_ADDRESS"); addr != "" {
+		return addr
+	}
+	return DefaultAddress
+}
+
+// containerdNamespace returns the namespace of the CONTAINERD_NAMESPACE env, or its default
+func containerdNamespace() string {
+	if ns := os.Getenv("CONTAINERD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return DefaultNamespace
+}
+
+// containerdSnapshotter returns the snapshotter of the CONTAINERD_SNAPSHOTTER env, or its
+// default
+func containerdSnapshotter() string {
+	if sn := os.Getenv("CONTAINERD_SNAPSHOTTER"); sn != "" {
+		return sn
+	}
+	return DefaultSnapshotter
+}
+
+// hostPlatform returns the platform containerd pulls images for
+func hostPlatform() models.Platform {
+	p := platforms.DefaultSpec()
+	return models.Platform{OS: p.OS, Arch: models.NormalizeArch(p.Architecture)}
+}
+
+// killLeakedContainers removes the containers of former instances of the agent, with the
+// label tag of the driver, like the docker driver
+func killLeakedContainers(ctx context.Context, driver *ContainerdDriver) {
+	if driver.conf.ContainerLabelTag == "" {
+		return
+	}
+
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "killLeakedContainers"})
+
+	filter := fmt.Sprintf("labels.%q==%q", FnAgentClassifierLabel, driver.conf.ContainerLabelTag)
+	containers, err := driver.client.Containers(ctx, filter)
+	if err != nil {
+		log.WithError(err).Error("cannot list containers")
+		return
+	}
+
+	for _, c := range containers {
+		labels, err := c.Labels(ctx)
+		if err != nil || labels[FnAgentInstanceLabel] == driver.instanceId {
+			continue
+		}
+
+		logger := log.WithFields(logrus.Fields{"container_id": c.ID()})
+		logger.Info("Terminating dangling containerd container")
+
+		if t, err := c.Task(ctx, nil); err == nil {
+			if _, err := t.Delete(ctx, containerd.WithProcessKill); err != nil {
+				logger.WithError(err).Error("cannot remove task")
+			}
+		}
+		if err := c.Delete(ctx, containerd.WithSnapshotCleanup); err != nil {
+			logger.WithError(err).Error("cannot remove container")
+		}
+	}
+}
+
+func (drv *ContainerdDriver) Close() error {
+	if drv.cancel != nil {
+		drv.cancel()
+	}
+	return drv.client.Close()
+}
+
+func (drv *ContainerdDriver) SetPullImageRetryPolicy(policy common.BackOffConfig, checker drivers.RetryErrorChecker) error {
+	drv.backOffCfg = policy
+	drv.isRetriable = checker
+	return nil
+}
+
+// PrePullImage implements drivers.ImagePrePuller
+func (drv *ContainerdDriver) PrePullImage(ctx context.Context, image string) error {
+	ref := imageRef(image)
+	if _, err := drv.client.GetImage(ctx, ref); err == nil {
+		return nil
+	}
+	_, err := drv.pullImage(ctx, ref, drv.resolver(nil))
+	return err
+}
+
+func (drv *ContainerdDriver) CreateCookie(ctx context.Context, task drivers.ContainerTask) (drivers.Cookie, error) {
+
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "CreateCookie"})
+
+	runtime, err := drv.runtime(task)
+	if err != nil {
+		log.WithFields(logrus.Fields{"runtime": task.Runtime(), "runtime_class": task.RuntimeClass()}).WithError(err).Error("container runtime not available")
+		return nil, err
+	}
+	if err := drv.checkTaskPlatform(task); err != nil {
+		log.WithFields(logrus.Fields{"platform": task.Platform()}).WithError(err).Error("image platform not available")
+		return nil, err
+	}
+	if len(task.Egress().Allow) > 0 {
+		return nil, models.ErrEgressPolicyUnsupported
+	}
+	// containers have a network namespace of their own with only a loopback interface,
+	// containerd has no networking to connect them to
+	if !task.DisableNet() && !task.Egress().Disabled {
+		return nil, ErrNetworkUnsupported
+	}
+	if len(task.Sidecars()) > 0 {
+		return nil, models.ErrSidecarsUnsupported
+	}
+	if len(task.ImageKeys()) > 0 {
+		return nil, models.ErrImageVerifyUnsupported
+	}
+
+	cookie := &cookie{
+		task:    task,
+		drv:     drv,
+		ref:     imageRef(task.Image()),
+		runtime: runtime,
+	}
+
+	cookie.configureLabels(log)
+	cookie.configureMem(log)
+	cookie.configureCmd(log)
+	cookie.configureEnv(log)
+	cookie.configureGPUs(log)
+	cookie.configureCPU(log)
+	cookie.configureFsSize(log)
+	cookie.configureBlockIO(log)
+	cookie.configurePIDs(log)
+	cookie.configureULimits(log)
+	cookie.configureTmpFs(log)
+	cookie.configureVolumes(log)
+	cookie.configureWorkDir(log)
+	cookie.configureIOFS(log)
+	cookie.configureDNS(log)
+	cookie.configureHostname(log)
+	cookie.configureSecurity(log)
+
+	return cookie, nil
+}
+
+// runtime returns the containerd runtime of the container of task, eg.
+// io.containerd.runsc.v1, the runtime of its runtime class if it has one, see
+// drivers.Config.DockerRuntimeClasses, or its runtime if allowed, see
+// drivers.Config.DockerRuntimes. Empty is the default runtime of containerd.
+func (drv *ContainerdDriver) runtime(task drivers.ContainerTask) (string, error) {
+	if class := task.RuntimeClass(); class != "" {
+		for _, pair := range strings.Fields(drv.conf.DockerRuntimeClasses) {
+			if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 && kv[0] == class {
+				return kv[1], nil
+			}
+		}
+		return "", models.ErrRuntimeClassNotAvailable
+	}
+
+	runtime := task.Runtime()
+	if runtime == "" {
+		return "", nil
+	}
+	for _, allowed := range strings.Fields(drv.conf.DockerRuntimes) {
+		if allowed == runtime {
+			return runtime, nil
+		}
+	}
+	return "", models.ErrRuntimeNotAllowed
+}
+
+// checkTaskPlatform returns models.ErrImagePlatformMismatch if the task must run the image
+// of another platform than the one of containerd
+func (drv *ContainerdDriver) checkTaskPlatform(task drivers.ContainerTask) error {
+	if task.Platform() == "" {
+		return nil
+	}
+	p, err := models.ParsePlatform(task.Platform())
+	if err != nil {
+		return err
+	}
+	if !p.Matches(drv.platform) {
+		return models.ErrImagePlatformMismatch
+	}
+	return nil
+}
+
+func (drv *ContainerdDriver) GetSlotKeyExtensions(extn map[string]string) string {
+	return ""
+}
+
+var _ drivers.Driver = &ContainerdDriver{}
+var _ drivers.ImagePrePuller = &ContainerdDriver{}
+var _ drivers.Checkpointer = &cookie{}
+
+func init() {
+	drivers.Register("containerd", func(config drivers.Config) (drivers.Driver, error) {
+		return NewContainerd(config)
+	})
+}
+
+func generateRandUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	uuid := fmt.Sprintf("%x%x%x%x%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+
+	return uuid, nil
+}
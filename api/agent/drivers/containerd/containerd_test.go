@@ -0,0 +1,371 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/containerd/containerd/oci"
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/agent/drivers/stats"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+type taskContainerdTest struct {
+	id         string
+	cmd        string
+	disableNet bool
+	runtime    string
+	tmpFsSize  uint64
+	readOnly   bool
+	sidecars   []drivers.Sidecar
+	dns        drivers.DNS
+	egress     drivers.Egress
+	platform   string
+}
+
+func (f *taskContainerdTest) Command() string { return f.cmd }
+func (f *taskContainerdTest) EnvVars() map[string]string {
+	return map[string]string{"FN_FORMAT": "http-stream"}
+}
+func (f *taskContainerdTest) Id() string    { return f.id }
+func (f *taskContainerdTest) Image() string { return "busybox" }
+func (f *taskContainerdTest) Logger() (stdout, stderr io.Writer) {
+	return common.NoopReadWriteCloser{}, common.NoopReadWriteCloser{}
+}
+func (f *taskContainerdTest) WriteStat(context.Context, stats.Stat)                      {}
+func (f *taskContainerdTest) Volumes() [][2]string                                       { return [][2]string{} }
+func (f *taskContainerdTest) Memory() uint64                                             { return 256 * 1024 * 1024 }
+func (f *taskContainerdTest) CPUs() uint64                                               { return 500 }
+func (f *taskContainerdTest) FsSize() uint64                                             { return 0 }
+func (f *taskContainerdTest) PIDs() uint64                                               { return 64 }
+func (f *taskContainerdTest) OpenFiles() *uint64                                         { n := uint64(2048); return &n }
+func (f *taskContainerdTest) LockedMemory() *uint64                                      { return nil }
+func (f *taskContainerdTest) PendingSignals() *uint64                                    { return nil }
+func (f *taskContainerdTest) MessageQueue() *uint64                                      { return nil }
+func (f *taskContainerdTest) TmpFsSize() uint64                                          { return f.tmpFsSize }
+func (f *taskContainerdTest) ReadOnlyRootFs() bool                                       { return f.readOnly }
+func (f *taskContainerdTest) WorkDir() string                                            { return "" }
+func (f *taskContainerdTest) Close()                                                     {}
+func (f *taskContainerdTest) WrapClose(func(func()) func())                              {}
+func (f *taskContainerdTest) WrapBeforeCall(func(drivers.BeforeCall) drivers.BeforeCall) {}
+func (f *taskContainerdTest) WrapAfterCall(func(drivers.AfterCall) drivers.AfterCall)    {}
+func (f *taskContainerdTest) Input() io.Reader                                           { return common.NoopReadWriteCloser{} }
+func (f *taskContainerdTest) Extensions() map[string]string                              { return nil }
+func (f *taskContainerdTest) LoggerConfig() drivers.LoggerConfig                         { return drivers.LoggerConfig{} }
+func (f *taskContainerdTest) UDSAgentPath() string                                       { return "" }
+func (f *taskContainerdTest) UDSDockerPath() string                                      { return "/tmp/iofs" }
+func (f *taskContainerdTest) UDSDockerDest() string                                      { return "/tmp/iofs" }
+func (f *taskContainerdTest) DisableNet() bool                                           { return f.disableNet }
+func (f *taskContainerdTest) Runtime() string                                            { return f.runtime }
+func (f *taskContainerdTest) RuntimeClass() string                                       { return "" }
+func (f *taskContainerdTest) GPUs() []string                                             { return nil }
+func (f *taskContainerdTest) Sidecars() []drivers.Sidecar                                { return f.sidecars }
+func (f *taskContainerdTest) DNS() drivers.DNS                                           { return f.dns }
+func (f *taskContainerdTest) ImageKeys() []string                                        { return nil }
+func (f *taskContainerdTest) Egress() drivers.Egress                                     { return f.egress }
+func (f *taskContainerdTest) Platform() string                                           { return f.platform }
+func (f *taskContainerdTest) BlockIO() drivers.BlockIO                                   { return drivers.BlockIO{} }
+
+func (f *taskContainerdTest) BeforeCall(context.Context, *models.Call, drivers.CallExtensions) error {
+	return nil
+}
+func (f *taskContainerdTest) AfterCall(context.Context, *models.Call, drivers.CallExtensions) error {
+	return nil
+}
+
+// cookieSpec returns the spec of the container of the cookie of task, without the config
+// of its image
+func cookieSpec(t *testing.T, drv *ContainerdDriver, task drivers.ContainerTask) (*cookie, *oci.Spec) {
+	c, err := drv.CreateCookie(context.Background(), task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	ctx := namespaces.WithNamespace(context.Background(), DefaultNamespace)
+	s, err := oci.GenerateSpec(ctx, nil, &containers.Container{ID: task.Id()}, c.(*cookie).opts...)
+	if err != nil {
+		t.Fatalf("Couldn't generate spec: %v", err)
+	}
+	return c.(*cookie), s
+}
+
+func findMount(s *oci.Spec, dst string) *specs.Mount {
+	for i := range s.Mounts {
+		if s.Mounts[i].Destination == dst {
+			return &s.Mounts[i]
+		}
+	}
+	return nil
+}
+
+func hasNamespace(s *oci.Spec, typ specs.LinuxNamespaceType) bool {
+	for _, ns := range s.Linux.Namespaces {
+		if ns.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func TestImageRef(t *testing.T) {
+	for image, ref := range map[string]string{
+		"busybox":                         "docker.io/library/busybox:latest",
+		"fnproject/fn-test-utils:1.2":     "docker.io/fnproject/fn-test-utils:1.2",
+		"registry.local:5000/team/fn":     "registry.local:5000/team/fn:latest",
+		"busybox@sha256:0123456789abcdef": "docker.io/library/busybox@sha256:0123456789abcdef",
+		"localhost/fn:dev":                "localhost/fn:dev",
+	} {
+		if got := imageRef(image); got != ref {
+			t.Errorf("Expected %s to be pulled as %s, got %s", image, ref, got)
+		}
+	}
+}
+
+func TestContainerdCookieSpec(t *testing.T) {
+	drv := &ContainerdDriver{conf: drivers.Config{ContainerLabelTag: "fn"}, hostname: "fn-host", instanceId: "instance"}
+
+	task := &taskContainerdTest{id: "test-containerd-spec", cmd: "/fn serve", egress: drivers.Egress{Disabled: true}}
+	c, s := cookieSpec(t, drv, task)
+
+	if c.labels[FnAgentClassifierLabel] != "fn" || c.labels[FnAgentInstanceLabel] != "instance" {
+		t.Fatalf("Expected the labels of the agent, got %v", c.labels)
+	}
+	if len(c.cmd) != 2 || c.cmd[0] != "/fn" {
+		t.Fatalf("Expected the command of the task, got %v", c.cmd)
+	}
+	r := s.Linux.Resources
+	if r.Memory == nil || *r.Memory.Limit != 256*1024*1024 || *r.Memory.Swap != *r.Memory.Limit {
+		t.Fatalf("Expected a memory limit of 256MB without swap, got %+v", r.Memory)
+	}
+	if r.CPU == nil || *r.CPU.Quota != 50000 || *r.CPU.Period != 100000 {
+		t.Fatalf("Expected half a CPU, got %+v", r.CPU)
+	}
+	if r.Pids == nil || r.Pids.Limit != 64 {
+		t.Fatalf("Expected a limit of 64 pids, got %+v", r.Pids)
+	}
+	nofile := 0
+	for _, l := range s.Process.Rlimits {
+		if l.Type == "RLIMIT_NOFILE" {
+			nofile++
+			if l.Hard != 2048 || l.Soft != 2048 {
+				t.Fatalf("Expected 2048 open files, got %+v", l)
+			}
+		}
+	}
+	if nofile != 1 {
+		t.Fatalf("Expected one open files limit, got %+v", s.Process.Rlimits)
+	}
+	if s.Process.User.UID != FnUserId || s.Process.User.GID != FnGroupId || !s.Process.NoNewPrivileges || len(s.Process.Capabilities.Bounding) != 0 {
+		t.Fatalf("Expected an unprivileged process, got %+v", s.Process)
+	}
+	if s.Hostname != "fn-host" {
+		t.Fatalf("Expected the hostname of the driver, got %s", s.Hostname)
+	}
+	if m := findMount(s, "/tmp/iofs"); m == nil || m.Source != "/tmp/iofs" || m.Type != "bind" {
+		t.Fatalf("Expected the bind of the iofs, got %+v", s.Mounts)
+	}
+	if !hasNamespace(s, specs.NetworkNamespace) || findMount(s, "/etc/resolv.conf") != nil {
+		t.Fatalf("Expected a network namespace of its own, got %+v", s.Linux.Namespaces)
+	}
+	if s.Root.Readonly || findMount(s, "/tmp") != nil {
+		t.Fatal("Expected a writable root fs without tmpfs")
+	}
+
+	task.tmpFsSize = 32
+	task.readOnly = true
+	_, s = cookieSpec(t, drv, task)
+	if !s.Root.Readonly {
+		t.Fatal("Expected a read only root fs")
+	}
+	if m := findMount(s, "/tmp"); m == nil || m.Type != "tmpfs" || m.Options[len(m.Options)-1] != "size=32m" {
+		t.Fatalf("Expected a tmpfs of 32m, got %+v", m)
+	}
+}
+
+func TestContainerdDNSCookie(t *testing.T) {
+	drv := &ContainerdDriver{hostname: "fn-host"}
+
+	task := &taskContainerdTest{id: "test-containerd-dns", disableNet: true, dns: drivers.DNS{
+		Servers: []string{"10.0.0.53"},
+		Search:  []string{"fn.local"},
+		Hosts:   map[string]string{"db": "10.0.0.5"},
+	}}
+	c, s := cookieSpec(t, drv, task)
+	if c.dnsDir == "" {
+		t.Fatal("Expected the dns files of the container")
+	}
+
+	if m := findMount(s, "/etc/resolv.conf"); m == nil || m.Source != filepath.Join(c.dnsDir, "resolv.conf") {
+		t.Fatalf("Expected the resolv.conf of the container, got %+v", s.Mounts)
+	}
+	resolv, err := ioutil.ReadFile(filepath.Join(c.dnsDir, "resolv.conf"))
+	if err != nil || string(resolv) != "nameserver 10.0.0.53\nsearch fn.local\n" {
+		t.Fatalf("Unexpected resolv.conf %q: %v", resolv, err)
+	}
+	hosts, err := ioutil.ReadFile(filepath.Join(c.dnsDir, "hosts"))
+	if err != nil || string(hosts) != "127.0.0.1\tlocalhost\n::1\tlocalhost\n10.0.0.5\tdb\n" {
+		t.Fatalf("Unexpected hosts %q: %v", hosts, err)
+	}
+
+	if err := c.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.dnsDir); !os.IsNotExist(err) {
+		t.Fatalf("Expected the dns files to be removed, got %v", err)
+	}
+}
+
+func TestContainerdUnsupportedCookie(t *testing.T) {
+	drv := &ContainerdDriver{conf: drivers.Config{DockerRuntimes: "io.containerd.runsc.v1"}, platform: models.Platform{OS: "linux", Arch: "amd64"}}
+
+	for _, tc := range []struct {
+		task *taskContainerdTest
+		err  error
+	}{
+		{&taskContainerdTest{sidecars: []drivers.Sidecar{{Name: "proxy", Image: "envoy"}}}, models.ErrSidecarsUnsupported},
+		{&taskContainerdTest{egress: drivers.Egress{Allow: []string{"10.0.0.0/8"}}}, models.ErrEgressPolicyUnsupported},
+		{&taskContainerdTest{runtime: "kata"}, models.ErrRuntimeNotAllowed},
+		{&taskContainerdTest{platform: "linux/arm64"}, models.ErrImagePlatformMismatch},
+		{&taskContainerdTest{runtime: "io.containerd.runsc.v1"}, nil},
+	} {
+		tc.task.id = "test-containerd-unsupported"
+		tc.task.disableNet = true
+		_, err := drv.CreateCookie(context.Background(), tc.task)
+		if err != tc.err {
+			t.Errorf("Expected %v for %+v, got %v", tc.err, tc.task, err)
+		}
+	}
+}
+
+func TestLazyPullSkipsLayers(t *testing.T) {
+	for mediaType, skip := range map[string]bool{
+		ocispec.MediaTypeImageManifest:                false,
+		ocispec.MediaTypeImageConfig:                  false,
+		images.MediaTypeDockerSchema2ManifestList:     false,
+		ocispec.MediaTypeImageLayerGzip:               true,
+		images.MediaTypeDockerSchema2LayerGzip:        true,
+		images.MediaTypeDockerSchema2LayerForeign:     true,
+		"application/vnd.oci.image.layer.v1.tar+zstd": true,
+	} {
+		_, err := skipLayers(context.Background(), ocispec.Descriptor{MediaType: mediaType})
+		if skip != (err == images.ErrSkipDesc) {
+			t.Errorf("Expected fetch of %s to be skipped %v, got %v", mediaType, skip, err)
+		}
+	}
+}
+
+func TestLazyPullLayerLabels(t *testing.T) {
+	var layers []ocispec.Descriptor
+	for i := 0; i < 100; i++ {
+		layers = append(layers, ocispec.Descriptor{Digest: digest.FromString(fmt.Sprint(i))})
+	}
+	layers[1].Annotations = map[string]string{
+		"containerd.io/snapshot/remote/soci.index.digest": "sha256:0123",
+		"org.opencontainers.image.title":                  "layer",
+	}
+
+	labels := layerLabels("docker.io/library/busybox:latest", layers, 1)
+	if labels[targetImageRefLabel] != "docker.io/library/busybox:latest" || labels[targetLayerDigestLabel] != layers[1].Digest.String() {
+		t.Fatalf("Expected the image and layer to mount, got %v", labels)
+	}
+	if labels["containerd.io/snapshot/remote/soci.index.digest"] != "sha256:0123" || labels["org.opencontainers.image.title"] != "" {
+		t.Fatalf("Expected the snapshotter annotations of the layer only, got %v", labels)
+	}
+
+	digests := strings.Split(labels[targetImageLayersLabel], ",")
+	if len(digests) == 0 || digests[0] != layers[1].Digest.String() || len(digests) == len(layers)-1 {
+		t.Fatalf("Expected the digests of the layers from 1 that fit, got %d", len(digests))
+	}
+	if size := len(targetImageLayersLabel) + len(labels[targetImageLayersLabel]); size > maxLabelSize {
+		t.Fatalf("Expected the layers of a label of at most %d bytes, got %d", maxLabelSize, size)
+	}
+}
+
+func TestCheckpointName(t *testing.T) {
+	drv := &ContainerdDriver{hostname: "fn-host"}
+	image := digest.FromString("image")
+
+	cookieOf := func(task *taskContainerdTest) *cookie {
+		c, err := drv.CreateCookie(context.Background(), task)
+		if err != nil {
+			t.Fatalf("Couldn't create task cookie: %v", err)
+		}
+		return c.(*cookie)
+	}
+
+	name := cookieOf(&taskContainerdTest{id: "first", cmd: "/fn serve", disableNet: true}).checkpointName(image)
+	if !strings.HasPrefix(name, checkpointPrefix) {
+		t.Fatalf("Expected a checkpoint name, got %q", name)
+	}
+	if other := cookieOf(&taskContainerdTest{id: "second", cmd: "/fn serve", disableNet: true}).checkpointName(image); other != name {
+		t.Fatalf("Expected containers of the same task to share checkpoint %s, got %s", name, other)
+	}
+	if other := cookieOf(&taskContainerdTest{id: "second", cmd: "/fn serve", disableNet: true}).checkpointName(digest.FromString("other")); other == name {
+		t.Fatal("Expected containers of another image to have another checkpoint")
+	}
+	if other := cookieOf(&taskContainerdTest{id: "second", cmd: "/fn serve", disableNet: true, tmpFsSize: 32}).checkpointName(image); other == name {
+		t.Fatal("Expected containers of another config to have another checkpoint")
+	}
+}
+
+func TestCheckpointIOFSLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iofs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "phony.sock"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("phony.sock", filepath.Join(dir, "lsnr.sock")); err != nil {
+		t.Fatal(err)
+	}
+
+	labels, err := iofsLinks(dir)
+	if err != nil || len(labels) != 1 || labels[checkpointLinkLabel+"lsnr.sock"] != "phony.sock" {
+		t.Fatalf("Expected the link of the socket, got %v: %v", labels, err)
+	}
+
+	restored, err := ioutil.TempDir("", "iofs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(restored)
+	labels[checkpointLabel] = "true"
+	if err := restoreIOFSLinks(restored, labels); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(restored, "lsnr.sock")); err != nil || target != "phony.sock" {
+		t.Fatalf("Expected the restored link of the socket, got %q: %v", target, err)
+	}
+}
+
+func TestContainerdNetworkCookie(t *testing.T) {
+	drv := &ContainerdDriver{hostname: "fn-host"}
+
+	// never the network namespace of the host
+	task := &taskContainerdTest{id: "test-containerd-network"}
+	if _, err := drv.CreateCookie(context.Background(), task); err != ErrNetworkUnsupported {
+		t.Fatalf("Expected %v for a task with network, got %v", ErrNetworkUnsupported, err)
+	}
+	for _, task := range []*taskContainerdTest{
+		{id: "test-containerd-network", disableNet: true},
+		{id: "test-containerd-network", egress: drivers.Egress{Disabled: true}},
+	} {
+		if _, err := drv.CreateCookie(context.Background(), task); err != nil {
+			t.Fatalf("Expected a cookie of a task without network, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,561 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	containerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/containers"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/oci"
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	FnUserId  = 1000
+	FnGroupId = 1000
+)
+
+// A cookie identifies a unique request to run a task.
+type cookie struct {
+	// spec options of the container created by Driver.CreateCookie, applied on top of the
+	// config of its image by CreateContainer
+	opts []oci.SpecOpts
+	// labels of the container
+	labels map[string]string
+	// containerd runtime of the container, the default one if empty
+	runtime string
+	// command of the container, the one of its image if empty
+	cmd []string
+	// task associated with this cookie
+	task drivers.ContainerTask
+	// pointer to containerd driver
+	drv *ContainerdDriver
+
+	// fully qualified reference of the image of the task
+	ref string
+	// dir of the resolv.conf and hosts files of the container if it has its own name
+	// resolution
+	dnsDir string
+
+	// contains the image if ValidateImage() found it
+	image containerd.Image
+	// name of the checkpoint image of the containers of the task, see checkpointName, and
+	// the checkpoint the container is restored from if any
+	checkpointRef string
+	restore       containerd.Image
+	// contains created container if CreateContainer() is called
+	container containerd.Container
+	// contains the task of the container if Run() is called
+	ctask containerd.Task
+}
+
+// withResources returns spec options setting the cgroup limits of the container with set
+func withResources(set func(r *specs.LinuxResources)) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if s.Linux == nil {
+			s.Linux = &specs.Linux{}
+		}
+		if s.Linux.Resources == nil {
+			s.Linux.Resources = &specs.LinuxResources{}
+		}
+		set(s.Linux.Resources)
+		return nil
+	}
+}
+
+// withRlimit returns spec options setting the resource limit typ of the process of the
+// container, replacing the one of the default spec
+func withRlimit(typ string, value uint64) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		if s.Process == nil {
+			s.Process = &specs.Process{}
+		}
+		limit := specs.POSIXRlimit{Type: typ, Hard: value, Soft: value}
+		for i, l := range s.Process.Rlimits {
+			if l.Type == typ {
+				s.Process.Rlimits[i] = limit
+				return nil
+			}
+		}
+		s.Process.Rlimits = append(s.Process.Rlimits, limit)
+		return nil
+	}
+}
+
+func (c *cookie) configureLabels(log logrus.FieldLogger) {
+	if c.drv.conf.ContainerLabelTag == "" {
+		return
+	}
+
+	c.labels = map[string]string{
+		FnAgentClassifierLabel: c.drv.conf.ContainerLabelTag,
+		FnAgentInstanceLabel:   c.drv.instanceId,
+	}
+}
+
+func (c *cookie) configureMem(log logrus.FieldLogger) {
+	if c.task.Memory() == 0 {
+		return
+	}
+
+	mem := int64(c.task.Memory())
+	var zero uint64
+	c.opts = append(c.opts, withResources(func(r *specs.LinuxResources) {
+		r.Memory = &specs.LinuxMemory{
+			Limit:      &mem,
+			Swap:       &mem, // disables swap
+			Kernel:     &mem,
+			Swappiness: &zero, // disables host swap
+		}
+	}))
+}
+
+func (c *cookie) configureFsSize(log logrus.FieldLogger) {
+	if c.task.FsSize() == 0 {
+		return
+	}
+	// the overlay snapshotter of containerd cannot limit the size of containers
+	log.WithFields(logrus.Fields{"call_id": c.task.Id()}).Debug("ignoring storage size with containerd")
+}
+
+func (c *cookie) configureBlockIO(log logrus.FieldLogger) {
+	blkio := c.task.BlockIO()
+	if blkio == (drivers.BlockIO{}) {
+		return
+	}
+
+	// rates are throttled per device, on the devices the containers of the driver write to
+	var devices [][2]int64
+	for _, dev := range strings.Fields(c.drv.conf.DockerBlkioDevices) {
+		var st unix.Stat_t
+		if err := unix.Stat(dev, &st); err != nil {
+			log.WithError(err).WithFields(logrus.Fields{"device": dev, "call_id": c.task.Id()}).Error("cannot stat block io device")
+			continue
+		}
+		rdev := uint64(st.Rdev)
+		devices = append(devices, [2]int64{int64(unix.Major(rdev)), int64(unix.Minor(rdev))})
+	}
+	limits := func(rate uint64) []specs.LinuxThrottleDevice {
+		if rate == 0 {
+			return nil
+		}
+		var l []specs.LinuxThrottleDevice
+		for _, dev := range devices {
+			limit := specs.LinuxThrottleDevice{Rate: rate}
+			limit.Major, limit.Minor = dev[0], dev[1]
+			l = append(l, limit)
+		}
+		return l
+	}
+
+	weight := blkio.Weight
+	c.opts = append(c.opts, withResources(func(r *specs.LinuxResources) {
+		r.BlockIO = &specs.LinuxBlockIO{
+			ThrottleReadBpsDevice:   limits(blkio.ReadBps),
+			ThrottleWriteBpsDevice:  limits(blkio.WriteBps),
+			ThrottleReadIOPSDevice:  limits(blkio.ReadIOps),
+			ThrottleWriteIOPSDevice: limits(blkio.WriteIOps),
+		}
+		if weight != 0 {
+			r.BlockIO.Weight = &weight
+		}
+	}))
+	log.WithFields(logrus.Fields{"weight": blkio.Weight, "read_bps": blkio.ReadBps, "write_bps": blkio.WriteBps, "read_iops": blkio.ReadIOps, "write_iops": blkio.WriteIOps, "devices": devices, "call_id": c.task.Id()}).Debug("setting block io")
+}
+
+func (c *cookie) configurePIDs(log logrus.FieldLogger) {
+	pids := c.task.PIDs()
+	if pids == 0 {
+		return
+	}
+
+	pids64 := int64(pids)
+	log.WithFields(logrus.Fields{"pids": pids64, "call_id": c.task.Id()}).Debug("setting PIDs")
+	c.opts = append(c.opts, withResources(func(r *specs.LinuxResources) {
+		r.Pids = &specs.LinuxPids{Limit: pids64}
+	}))
+}
+
+func (c *cookie) configureULimits(log logrus.FieldLogger) {
+	c.configureULimit("RLIMIT_NOFILE", c.task.OpenFiles(), log)
+	c.configureULimit("RLIMIT_MEMLOCK", c.task.LockedMemory(), log)
+	c.configureULimit("RLIMIT_SIGPENDING", c.task.PendingSignals(), log)
+	c.configureULimit("RLIMIT_MSGQUEUE", c.task.MessageQueue(), log)
+}
+
+func (c *cookie) configureULimit(name string, value *uint64, log logrus.FieldLogger) {
+	if value == nil {
+		return
+	}
+
+	log.WithFields(logrus.Fields{"call_id": c.task.Id(), "ulimitName": name, "ulimitValue": *value}).Debugf("setting ulimit %s", name)
+	c.opts = append(c.opts, withRlimit(name, *value))
+}
+
+func (c *cookie) configureTmpFs(log logrus.FieldLogger) {
+	// if RO Root is NOT enabled and TmpFsSize does not have any limit, then we do not need
+	// any tmpfs in the container since function can freely write whereever it wants.
+	if c.task.TmpFsSize() == 0 && !c.readOnlyRootFs() {
+		return
+	}
+
+	options := []string{"nosuid", "nodev"}
+	if c.task.TmpFsSize() != 0 {
+		options = append(options, fmt.Sprintf("size=%dm", c.task.TmpFsSize()))
+		if c.drv.conf.MaxTmpFsInodes != 0 {
+			options = append(options, fmt.Sprintf("nr_inodes=%d", c.drv.conf.MaxTmpFsInodes))
+		}
+	}
+
+	log.WithFields(logrus.Fields{"target": "/tmp", "options": options, "call_id": c.task.Id()}).Debug("setting tmpfs")
+	c.opts = append(c.opts, oci.WithMounts([]specs.Mount{{Destination: "/tmp", Type: "tmpfs", Source: "tmpfs", Options: options}}))
+}
+
+// readOnlyRootFs returns whether the root file system of the container is read only
+func (c *cookie) readOnlyRootFs() bool {
+	return c.drv.conf.EnableReadOnlyRootFs || c.task.ReadOnlyRootFs()
+}
+
+// bind returns the bind mount of the host path src at dst in the container
+func bind(src, dst string, options ...string) specs.Mount {
+	return specs.Mount{Destination: dst, Type: "bind", Source: src, Options: append([]string{"rbind"}, options...)}
+}
+
+func (c *cookie) configureIOFS(log logrus.FieldLogger) {
+	path := c.task.UDSDockerPath()
+	if path == "" {
+		// TODO this should be required soon-ish
+		return
+	}
+
+	log.WithFields(logrus.Fields{"bind": path + ":" + c.task.UDSDockerDest(), "call_id": c.task.Id()}).Debug("setting bind")
+	c.opts = append(c.opts, oci.WithMounts([]specs.Mount{bind(path, c.task.UDSDockerDest(), "rw")}))
+}
+
+func (c *cookie) configureVolumes(log logrus.FieldLogger) {
+	if len(c.task.Volumes()) == 0 {
+		return
+	}
+
+	mounts := make([]specs.Mount, 0, len(c.task.Volumes()))
+	for _, mapping := range c.task.Volumes() {
+		mounts = append(mounts, bind(mapping[0], mapping[1], "rw"))
+		log.WithFields(logrus.Fields{"volumes": mapping[0] + ":" + mapping[1], "call_id": c.task.Id()}).Debug("setting volumes")
+	}
+	c.opts = append(c.opts, oci.WithMounts(mounts))
+}
+
+func (c *cookie) configureCPU(log logrus.FieldLogger) {
+	// Translate milli cpus into CPUQuota & CPUPeriod, like the docker driver
+	if c.task.CPUs() == 0 {
+		return
+	}
+
+	quota := int64(c.task.CPUs() * 100)
+	period := uint64(100000)
+
+	log.WithFields(logrus.Fields{"quota": quota, "period": period, "call_id": c.task.Id()}).Debug("setting CPU")
+	c.opts = append(c.opts, withResources(func(r *specs.LinuxResources) {
+		r.CPU = &specs.LinuxCPU{Quota: &quota, Period: &period}
+	}))
+}
+
+func (c *cookie) configureWorkDir(log logrus.FieldLogger) {
+	wd := c.task.WorkDir()
+	if wd == "" {
+		return
+	}
+
+	log.WithFields(logrus.Fields{"wd": wd, "call_id": c.task.Id()}).Debug("setting work dir")
+	c.opts = append(c.opts, oci.WithProcessCwd(wd))
+}
+
+func (c *cookie) configureDNS(log logrus.FieldLogger) {
+	dns := c.task.DNS()
+	if len(dns.Servers) == 0 && len(dns.Search) == 0 && len(dns.Hosts) == 0 {
+		return
+	}
+
+	log.WithFields(logrus.Fields{"dns": dns.Servers, "dns_search": dns.Search, "extra_hosts": dns.Hosts, "call_id": c.task.Id()}).Debug("setting dns")
+	c.opts = append(c.opts, c.withDNS(dns))
+}
+
+// withDNS returns spec options mounting the resolv.conf and hosts files of dns in the
+// container, written to a dir of the cookie when the container is created
+func (c *cookie) withDNS(dns drivers.DNS) oci.SpecOpts {
+	return func(_ context.Context, _ oci.Client, _ *containers.Container, s *oci.Spec) error {
+		dir, err := ioutil.TempDir("", "fn-"+c.task.Id())
+		if err != nil {
+			return err
+		}
+		c.dnsDir = dir
+
+		var resolv strings.Builder
+		for _, server := range dns.Servers {
+			fmt.Fprintf(&resolv, "nameserver %s\n", server)
+		}
+		if len(dns.Search) > 0 {
+			fmt.Fprintf(&resolv, "search %s\n", strings.Join(dns.Search, " "))
+		}
+		hosts := []string{"127.0.0.1\tlocalhost", "::1\tlocalhost"}
+		for host, ip := range dns.Hosts {
+			hosts = append(hosts, ip+"\t"+host)
+		}
+		sort.Strings(hosts[2:])
+
+		mounts := []specs.Mount{bind(filepath.Join(dir, "hosts"), "/etc/hosts", "ro")}
+		if err := ioutil.WriteFile(mounts[0].Source, []byte(strings.Join(hosts, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+		if len(dns.Servers) > 0 || len(dns.Search) > 0 {
+			mount := bind(filepath.Join(dir, "resolv.conf"), "/etc/resolv.conf", "ro")
+			if err := ioutil.WriteFile(mount.Source, []byte(resolv.String()), 0644); err != nil {
+				return err
+			}
+			mounts = append(mounts, mount)
+		}
+
+		s.Mounts = append(s.Mounts, mounts...)
+		return nil
+	}
+}
+
+func (c *cookie) configureHostname(log logrus.FieldLogger) {
+	log.WithFields(logrus.Fields{"hostname": c.drv.hostname, "call_id": c.task.Id()}).Debug("setting hostname")
+	c.opts = append(c.opts, oci.WithHostname(c.drv.hostname))
+}
+
+func (c *cookie) configureCmd(log logrus.FieldLogger) {
+	if c.task.Command() == "" {
+		return
+	}
+
+	// NOTE: this is hyper-sensitive and may not be correct like this even, but it passes old tests
+	c.cmd = strings.Fields(c.task.Command())
+	log.WithFields(logrus.Fields{"call_id": c.task.Id(), "cmd": c.cmd, "len": len(c.cmd)}).Debug("containerd command")
+}
+
+func (c *cookie) configureEnv(log logrus.FieldLogger) {
+	if len(c.task.EnvVars()) == 0 {
+		return
+	}
+
+	env := make([]string, 0, len(c.task.EnvVars()))
+	for name, val := range c.task.EnvVars() {
+		env = append(env, name+"="+val)
+	}
+	c.opts = append(c.opts, oci.WithEnv(env))
+}
+
+func (c *cookie) configureGPUs(log logrus.FieldLogger) {
+	gpuRuntime := c.drv.conf.DockerGPURuntime
+	if gpuRuntime == "" {
+		return
+	}
+
+	// the GPU runtime exposes the GPUs of the env, which images like the CUDA ones set to
+	// all, void keeps GPUs from containers without any
+	devices := "void"
+	if gpus := c.task.GPUs(); len(gpus) > 0 {
+		devices = strings.Join(gpus, ",")
+		if c.runtime == "" {
+			c.runtime = gpuRuntime
+		}
+	}
+	log.WithFields(logrus.Fields{"gpus": devices, "runtime": c.runtime, "call_id": c.task.Id()}).Debug("setting gpus")
+	c.opts = append(c.opts, oci.WithEnv([]string{"NVIDIA_VISIBLE_DEVICES=" + devices}))
+}
+
+func (c *cookie) configureSecurity(log logrus.FieldLogger) {
+	if c.readOnlyRootFs() {
+		c.opts = append(c.opts, oci.WithRootFSReadonly())
+	}
+	if c.drv.conf.DisableUnprivilegedContainers {
+		return
+	}
+	c.opts = append(c.opts, oci.WithUIDGID(FnUserId, FnGroupId), oci.WithCapabilities(nil), oci.WithNoNewPrivileges)
+	log.WithFields(logrus.Fields{"user": fmt.Sprintf("%v:%v", FnUserId, FnGroupId), "call_id": c.task.Id()}).Debug("setting security")
+}
+
+// implements Cookie
+func (c *cookie) Close(ctx context.Context) error {
+	log := common.Logger(ctx).WithFields(logrus.Fields{"call_id": c.task.Id()})
+
+	var err error
+	if c.ctask != nil {
+		if _, err = c.ctask.Delete(ctx, containerd.WithProcessKill); err != nil && !errdefs.IsNotFound(err) {
+			log.WithError(err).Error("error removing task")
+		}
+	}
+	if c.container != nil {
+		if err = c.container.Delete(ctx, containerd.WithSnapshotCleanup); err != nil {
+			log.WithError(err).Error("error removing container")
+		}
+	}
+	if c.dnsDir != "" {
+		os.RemoveAll(c.dnsDir)
+	}
+	return err
+}
+
+// implements Cookie
+func (c *cookie) Run(ctx context.Context) (drivers.WaitResult, error) {
+	return c.drv.run(ctx, c)
+}
+
+// implements Cookie
+func (c *cookie) ContainerOptions() interface{} {
+	return c.opts
+}
+
+// implements Cookie
+func (c *cookie) Freeze(ctx context.Context) error {
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "Freeze"})
+	log.WithFields(logrus.Fields{"call_id": c.task.Id()}).Debug("containerd pause")
+
+	if c.ctask == nil {
+		return nil
+	}
+	err := c.ctask.Pause(ctx)
+	if err != nil {
+		log.WithError(err).WithFields(logrus.Fields{"call_id": c.task.Id()}).Error("error pausing container")
+	}
+	return err
+}
+
+// implements Cookie
+func (c *cookie) Unfreeze(ctx context.Context) error {
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "Unfreeze"})
+	log.WithFields(logrus.Fields{"call_id": c.task.Id()}).Debug("containerd resume")
+
+	if c.ctask == nil {
+		return nil
+	}
+	err := c.ctask.Resume(ctx)
+	if err != nil {
+		log.WithError(err).WithFields(logrus.Fields{"call_id": c.task.Id()}).Error("error resuming container")
+	}
+	return err
+}
+
+// implements Cookie
+func (c *cookie) ValidateImage(ctx context.Context) (bool, error) {
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "ValidateImage"})
+	log.WithFields(logrus.Fields{"call_id": c.task.Id(), "image": c.ref}).Debug("containerd get image")
+
+	if c.image != nil {
+		return false, nil
+	}
+
+	img, err := c.drv.client.GetImage(ctx, c.ref)
+	if errdefs.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	// the content of images pulled by a former driver may not be unpacked yet, lazily
+	// pulled images have no layers to unpack and are pulled again
+	unpacked, err := img.IsUnpacked(ctx, c.drv.snapshotter)
+	if err == nil && !unpacked {
+		if c.drv.lazyPull {
+			return true, nil
+		}
+		err = img.Unpack(ctx, c.drv.snapshotter)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	c.image = img
+	return false, c.drv.verifyImage(ctx, c, img)
+}
+
+// implements Cookie
+func (c *cookie) PullImage(ctx context.Context) error {
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "PullImage"})
+	if c.image != nil {
+		return nil
+	}
+
+	auth, err := c.authImage(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(logrus.Fields{"call_id": c.task.Id(), "image": c.ref}).Debug("containerd pull")
+	_, err = c.drv.pullImage(ctx, c.ref, c.drv.resolver(auth))
+	return err
+}
+
+// newContainer creates the container of the cookie, from its checkpoint if restored
+func (c *cookie) newContainer(ctx context.Context) (containerd.Container, error) {
+	opts := []containerd.NewContainerOpts{containerd.WithSnapshotter(c.drv.snapshotter)}
+	if c.restore != nil {
+		// the image and file system of the container are restored, its spec is not as it
+		// has the mounts of the checkpointed container
+		opts = append(opts, containerd.WithCheckpoint(c.restore, c.task.Id()))
+	} else {
+		opts = append(opts, containerd.WithImage(c.image), containerd.WithNewSnapshot(c.task.Id(), c.image))
+	}
+	opts = append(opts, containerd.WithNewSpec(append([]oci.SpecOpts{oci.WithImageConfigArgs(c.image, c.cmd)}, c.opts...)...))
+	if c.labels != nil {
+		opts = append(opts, containerd.WithContainerLabels(c.labels))
+	}
+	if c.runtime != "" {
+		opts = append(opts, containerd.WithRuntime(c.runtime, nil))
+	}
+	return c.drv.client.NewContainer(ctx, c.task.Id(), opts...)
+}
+
+// implements Cookie
+func (c *cookie) CreateContainer(ctx context.Context) error {
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "CreateContainer"})
+	log.WithFields(logrus.Fields{"call_id": c.task.Id(), "image": c.ref}).Debug("containerd create container")
+
+	if c.image == nil {
+		log.Fatal("invalid usage: image not validated")
+	}
+	if c.container != nil {
+		return nil
+	}
+
+	c.checkpointRef = c.checkpointName(c.image.Target().Digest)
+	c.restore = c.checkpoint(ctx)
+
+	var err error
+	c.container, err = c.newContainer(ctx)
+	if err != nil && c.restore != nil {
+		log.WithError(err).WithFields(logrus.Fields{"checkpoint": c.checkpointRef}).Info("cannot create container from checkpoint")
+		c.drv.client.SnapshotService(c.drv.snapshotter).Remove(ctx, c.task.Id())
+		c.removeCheckpoint(ctx)
+		c.container, err = c.newContainer(ctx)
+	}
+
+	// the image was likely removed just after PullImage(), treat it as a temporary
+	// too busy event like the docker driver
+	if errdefs.IsNotFound(err) {
+		log.WithError(err).Error("Cannot CreateContainer image likely removed")
+		return models.ErrCallTimeoutServerBusy
+	}
+
+	if err != nil {
+		log.WithError(err).Error("Could not create container")
+		return err
+	}
+
+	return nil
+}
+
+var _ drivers.Cookie = &cookie{}
@@ -0,0 +1,7 @@
+// Package containerd provides a containerd driver for Fn. Provides an implementation
+// of
+//
+//	github.com/fnproject/fn/api/agent/drivers.Driver
+//
+// that runs images with the native client of containerd, on hosts without dockerd.
+package containerd
@@ -0,0 +1,146 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	containerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/fnproject/fn/api/agent/drivers"
+	dockerdriver "github.com/fnproject/fn/api/agent/drivers/docker"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	dockerclient "github.com/fsouza/go-dockerclient"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"go.opencensus.io/trace"
+)
+
+const defaultRegistry = "docker.io"
+
+var ErrImageWithVolume = models.NewAPIError(http.StatusBadRequest, errors.New("image has Volume definition"))
+
+// imageRef returns the fully qualified reference containerd knows image by, eg.
+// docker.io/library/busybox:latest for busybox
+func imageRef(image string) string {
+	reg, repo, tag := drivers.ParseImage(image)
+	if reg == "" {
+		reg = defaultRegistry
+	}
+	sep := ":"
+	if strings.Contains(tag, ":") {
+		// digests are algorithm:hex
+		sep = "@"
+	}
+	return path.Join(reg, repo) + sep + tag
+}
+
+// authImage returns the registry credentials of the task, see docker.Auther, nil for
+// anonymous pulls
+func (c *cookie) authImage(ctx context.Context) (*dockerclient.AuthConfiguration, error) {
+	task, ok := c.task.(dockerdriver.Auther)
+	if !ok {
+		return nil, nil
+	}
+	_, span := trace.StartSpan(ctx, "containerd_auth")
+	defer span.End()
+	return task.DockerAuth(ctx, c.task.Image())
+}
+
+// resolver returns the resolver of the registries images are pulled from, with the
+// credentials of auth if not nil
+func (drv *ContainerdDriver) resolver(auth *dockerclient.AuthConfiguration) remotes.Resolver {
+	opts := docker.ResolverOptions{}
+	if auth != nil {
+		opts.Credentials = func(string) (string, string, error) {
+			if auth.RegistryToken != "" {
+				// an empty user name makes the secret a token
+				return "", auth.RegistryToken, nil
+			}
+			return auth.Username, auth.Password, nil
+		}
+	}
+	return docker.NewResolver(opts)
+}
+
+// pullImage pulls and unpacks the image of ref, or pulls it lazily, retrying with the
+// retry policy of the driver
+func (drv *ContainerdDriver) pullImage(ctx context.Context, ref string, resolver remotes.Resolver) (containerd.Image, error) {
+	backoff := common.NewBackOff(drv.backOffCfg)
+	timer := common.NewTimer(time.Duration(drv.backOffCfg.MinDelay) * time.Millisecond)
+	defer timer.Stop()
+
+	for {
+		img, err := drv.pull(ctx, ref, resolver)
+		if err == nil {
+			return img, nil
+		}
+		ok, _ := drv.isRetriable(err)
+		if ok {
+			var delay time.Duration
+			delay, ok = backoff.NextBackOff()
+			timer.Reset(delay)
+		}
+		if !ok {
+			common.Logger(ctx).WithError(err).WithFields(logrus.Fields{"image": ref}).Info("Failed to pull image")
+			code := http.StatusBadGateway
+			if errdefs.IsNotFound(err) {
+				code = http.StatusNotFound
+			}
+			return nil, models.NewFuncError(models.NewAPIError(code, fmt.Errorf("Failed to pull image '%s': %s", ref, err)))
+		}
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// pull pulls the image of ref once, see pullImage
+func (drv *ContainerdDriver) pull(ctx context.Context, ref string, resolver remotes.Resolver) (containerd.Image, error) {
+	if drv.lazyPull {
+		return drv.pullLazily(ctx, ref, resolver)
+	}
+	return drv.client.Pull(ctx, ref, containerd.WithPullUnpack, containerd.WithPullSnapshotter(drv.snapshotter), containerd.WithResolver(resolver))
+}
+
+// verifyImage returns ErrImageWithVolume if img has volumes and the driver does not
+// allow them, models.ErrImagePlatformMismatch if img is built for another platform than
+// the one of containerd
+func (drv *ContainerdDriver) verifyImage(ctx context.Context, c *cookie, img containerd.Image) error {
+	desc, err := img.Config(ctx)
+	if err != nil {
+		return err
+	}
+	blob, err := content.ReadBlob(ctx, img.ContentStore(), desc)
+	if err != nil {
+		return err
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(blob, &config); err != nil {
+		return err
+	}
+
+	if !drv.conf.ImageEnableVolume && len(config.Config.Volumes) > 0 {
+		return ErrImageWithVolume
+	}
+	if config.OS != "" && config.Architecture != "" {
+		p := models.Platform{OS: config.OS, Arch: models.NormalizeArch(config.Architecture)}
+		if !p.Matches(drv.platform) {
+			common.Logger(ctx).WithFields(logrus.Fields{"call_id": c.task.Id(), "image": c.ref, "image_platform": p, "platform": drv.platform}).Error("image is not built for the platform of containerd")
+			return models.ErrImagePlatformMismatch
+		}
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	containerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/platforms"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/snapshots"
+	"github.com/fnproject/fn/api/common"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// Labels of the snapshots of the layers of lazily pulled images, the ones the CRI plugin
+// of containerd passes to remote snapshotters such as the stargz and soci snapshotters, so
+// that they can mount layers from their registry instead of having them unpacked
+const (
+	snapshotRefLabel       = "containerd.io/snapshot.ref"
+	snapshotLabelPrefix    = "containerd.io/snapshot/"
+	targetImageRefLabel    = "containerd.io/snapshot/cri.image-ref"
+	targetLayerDigestLabel = "containerd.io/snapshot/cri.layer-digest"
+	targetImageLayersLabel = "containerd.io/snapshot/cri.image-layers"
+
+	// maxLabelSize is the size limit of containerd for a label, key and value
+	maxLabelSize = 4096
+	// maxPrepareAttempts bounds the retries of snapshots whose key is already taken
+	maxPrepareAttempts = 3
+)
+
+// isLayer returns whether mediaType is the media type of an image layer, of the OCI or
+// the docker manifests
+func isLayer(mediaType string) bool {
+	return strings.Contains(mediaType, ".image.layer.") || strings.Contains(mediaType, ".image.rootfs.")
+}
+
+// skipLayers is the image handler of lazy pulls, it skips the fetch of the layers of
+// images, which are left to the snapshotter
+func skipLayers(_ context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	if isLayer(desc.MediaType) {
+		return nil, images.ErrSkipDesc
+	}
+	return nil, nil
+}
+
+// layerLabels returns the labels of the snapshot of layer i of the image of ref with
+// layers, the image and layer for the snapshotter to mount it from, and the digests of
+// the layers from i that fit in a label for it to prefetch. Annotations of the layer for
+// snapshotters, eg. the index of a soci layer, are passed as is.
+func layerLabels(ref string, layers []ocispec.Descriptor, i int) map[string]string {
+	labels := map[string]string{
+		targetImageRefLabel:    ref,
+		targetLayerDigestLabel: layers[i].Digest.String(),
+	}
+
+	var digests []string
+	size := len(targetImageLayersLabel)
+	for _, l := range layers[i:] {
+		d := l.Digest.String()
+		if size+len(d)+1 > maxLabelSize {
+			break
+		}
+		digests = append(digests, d)
+		size += len(d) + 1
+	}
+	labels[targetImageLayersLabel] = strings.Join(digests, ",")
+
+	for k, v := range layers[i].Annotations {
+		if strings.HasPrefix(k, snapshotLabelPrefix) {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// pullLazily pulls the manifest and config of the image of ref and prepares the snapshots
+// of its layers with the labels of remote snapshotters, which mount the layers they can
+// lazily from the registry. Layers the snapshotter does not mount, eg. layers that are
+// not eStargz for the stargz snapshotter, are fetched and unpacked.
+func (drv *ContainerdDriver) pullLazily(ctx context.Context, ref string, resolver remotes.Resolver) (containerd.Image, error) {
+	img, err := drv.client.Pull(ctx, ref, containerd.WithResolver(resolver), containerd.WithImageHandler(images.HandlerFunc(skipLayers)))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, done, err := drv.client.WithLease(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done(ctx)
+
+	manifest, err := images.Manifest(ctx, img.ContentStore(), img.Target(), platforms.Default())
+	if err != nil {
+		return nil, err
+	}
+	diffIDs, err := img.RootFS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(diffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("mismatched image rootfs and manifest layers of %s", ref)
+	}
+
+	sn := drv.client.SnapshotService(drv.snapshotter)
+	for i := range manifest.Layers {
+		chainID := identity.ChainID(diffIDs[:i+1]).String()
+		if _, err := sn.Stat(ctx, chainID); err == nil {
+			continue
+		} else if !errdefs.IsNotFound(err) {
+			return nil, err
+		}
+
+		labels := layerLabels(img.Name(), manifest.Layers, i)
+		labels[snapshotRefLabel] = chainID
+		parent := identity.ChainID(diffIDs[:i]).String()
+		if err := drv.prepareLayer(ctx, sn, resolver, img, manifest.Layers[i], diffIDs[i], parent, chainID, labels); err != nil {
+			return nil, err
+		}
+	}
+
+	// keep the snapshots of the image like containerd.Image.Unpack does
+	desc, err := img.Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gcLabel := fmt.Sprintf("containerd.io/gc.ref.snapshot.%s", drv.snapshotter)
+	info, err := img.ContentStore().Info(ctx, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	info.Labels = map[string]string{gcLabel: identity.ChainID(diffIDs).String()}
+	if _, err := img.ContentStore().Update(ctx, info, "labels."+gcLabel); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// prepareLayer creates the snapshot chainID of layer with its diff id on parent, which
+// the snapshotter mounts from the registry if it can with labels, else the layer is
+// fetched and applied
+func (drv *ContainerdDriver) prepareLayer(ctx context.Context, sn snapshots.Snapshotter, resolver remotes.Resolver, img containerd.Image, layer ocispec.Descriptor, diffID digest.Digest, parent, chainID string, labels map[string]string) error {
+	log := common.Logger(ctx).WithFields(logrus.Fields{"image": img.Name(), "layer": layer.Digest, "snapshotter": drv.snapshotter})
+
+	for attempt := 0; attempt < maxPrepareAttempts; attempt++ {
+		uid, err := generateRandUUID()
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprintf("extract-%s %s", uid, chainID)
+
+		mounts, err := sn.Prepare(ctx, key, parent, snapshots.WithLabels(labels))
+		if errdefs.IsAlreadyExists(err) {
+			// remote snapshotters commit chainID themselves when they mount the layer
+			if _, err := sn.Stat(ctx, chainID); err == nil {
+				log.Debug("layer mounted lazily")
+				return nil
+			} else if !errdefs.IsNotFound(err) {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		log.Debug("layer not mounted lazily, unpacking it")
+		if err := drv.applyLayer(ctx, resolver, img, layer, diffID, mounts); err != nil {
+			sn.Remove(ctx, key)
+			return err
+		}
+		if err := sn.Commit(ctx, chainID, key); err != nil {
+			sn.Remove(ctx, key)
+			if !errdefs.IsAlreadyExists(err) {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to prepare the snapshot of layer %s of %s", layer.Digest, img.Name())
+}
+
+// applyLayer fetches layer of img and unpacks it to mounts, checking its diff id
+func (drv *ContainerdDriver) applyLayer(ctx context.Context, resolver remotes.Resolver, img containerd.Image, layer ocispec.Descriptor, diffID digest.Digest, mounts []mount.Mount) error {
+	name, _, err := resolver.Resolve(ctx, img.Name())
+	if err != nil {
+		return err
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := remotes.FetchHandler(img.ContentStore(), fetcher)(ctx, layer); err != nil {
+		return err
+	}
+
+	diff, err := drv.client.DiffService().Apply(ctx, layer, mounts)
+	if err != nil {
+		return err
+	}
+	if diff.Digest != diffID {
+		return fmt.Errorf("wrong diff id %s of layer %s, expected %s", diff.Digest, layer.Digest, diffID)
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/containerd/cgroups"
+	containerd "github.com/containerd/containerd"
+	"github.com/containerd/containerd/cio"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/typeurl"
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/agent/drivers/stats"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	"github.com/sirupsen/logrus"
+	"go.opencensus.io/trace"
+)
+
+// statsInterval is how often the stats of running containers are collected
+const statsInterval = time.Second
+
+// run starts the task of the container of the cookie, with the input and logger of its
+// task as stdio. A container that cannot be restored from its checkpoint is created again
+// and started as usual.
+func (drv *ContainerdDriver) run(ctx context.Context, c *cookie) (drivers.WaitResult, error) {
+	log := common.Logger(ctx).WithFields(logrus.Fields{"container": c.task.Id(), "call_id": c.task.Id()})
+
+	t, exit, err := c.startTask(ctx)
+	if err != nil && c.restore != nil && ctx.Err() == nil {
+		// start the container from scratch, CRIU may not restore it on this host
+		log.WithError(err).WithFields(logrus.Fields{"checkpoint": c.checkpointRef}).Info("cannot restore checkpoint")
+		if err = c.container.Delete(common.BackgroundContext(ctx), containerd.WithSnapshotCleanup); err == nil {
+			c.removeCheckpoint(ctx)
+			if c.container, err = c.newContainer(ctx); err == nil {
+				t, exit, err = c.startTask(ctx)
+			} else {
+				c.container = nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// we want to stop trying to collect stats when the container exits
+	stopSignal := make(chan struct{})
+	peakMemory := new(uint64)
+	go drv.collectStats(ctx, stopSignal, t, c.task, peakMemory)
+
+	return &waitResult{
+		task:   t,
+		exit:   exit,
+		done:   stopSignal,
+		memory: c.task.Memory(),
+		peak:   peakMemory,
+	}, nil
+}
+
+// startTask creates and starts the task of the container of the cookie, restoring it
+// from the checkpoint of the cookie if any, and returns the channel of its exit
+func (c *cookie) startTask(ctx context.Context) (containerd.Task, <-chan containerd.ExitStatus, error) {
+	log := common.Logger(ctx).WithFields(logrus.Fields{"container": c.task.Id(), "call_id": c.task.Id()})
+	stdout, stderr := c.task.Logger()
+
+	var opts []containerd.NewTaskOpts
+	if c.restore != nil {
+		opts = append(opts, containerd.WithTaskCheckpoint(c.restore))
+	}
+	t, err := c.container.NewTask(ctx, cio.NewCreator(cio.WithStreams(c.task.Input(), stdout, stderr)), opts...)
+	if err != nil {
+		log.WithError(err).Error("error creating task")
+		return nil, nil, err
+	}
+	c.ctask = t
+
+	// wait before starting, so that the exit of quick tasks is not missed
+	exit, err := t.Wait(common.BackgroundContext(ctx))
+	if err != nil {
+		log.WithError(err).Error("error waiting task")
+		c.deleteTask(ctx)
+		return nil, nil, err
+	}
+
+	if err := t.Start(ctx); err != nil && ctx.Err() == nil {
+		log.WithError(err).Error("error starting task")
+		c.deleteTask(ctx)
+		return nil, nil, err
+	}
+	if c.restore != nil {
+		// the agent waits for the socket of the container behind the links of its iofs
+		if err := restoreIOFSLinks(c.task.UDSDockerPath(), c.restore.Labels()); err != nil {
+			log.WithError(err).Error("error restoring iofs links")
+			c.deleteTask(ctx)
+			return nil, nil, err
+		}
+	}
+	return t, exit, nil
+}
+
+// deleteTask kills and removes the task of the container of the cookie
+func (c *cookie) deleteTask(ctx context.Context) {
+	if _, err := c.ctask.Delete(common.BackgroundContext(ctx), containerd.WithProcessKill); err != nil && !errdefs.IsNotFound(err) {
+		common.Logger(ctx).WithError(err).WithFields(logrus.Fields{"call_id": c.task.Id()}).Error("error removing task")
+		return
+	}
+	c.ctask = nil
+}
+
+// waitResult implements drivers.WaitResult
+type waitResult struct {
+	task containerd.Task
+	exit <-chan containerd.ExitStatus
+	done chan struct{}
+	// memory limit of the container and peak memory usage in its stats, in bytes
+	memory uint64
+	peak   *uint64
+}
+
+// waitResult implements drivers.WaitResult
+func (w *waitResult) Wait(ctx context.Context) drivers.RunResult {
+	defer close(w.done)
+
+	// wait until container is stopped (or ctx is cancelled if sooner)
+	status, err := w.wait(ctx)
+	return &runResult{
+		status: status,
+		err:    err,
+	}
+}
+
+func (w *waitResult) wait(ctx context.Context) (status string, err error) {
+	select {
+	case <-ctx.Done():
+		// the task is killed now, Close removes it
+		if err := w.task.Kill(common.BackgroundContext(ctx), syscall.SIGKILL); err != nil {
+			common.Logger(ctx).WithError(err).WithFields(logrus.Fields{"container": w.task.ID()}).Error("error killing task")
+		}
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return drivers.StatusTimeout, context.DeadlineExceeded
+		default:
+			return drivers.StatusCancelled, context.Canceled
+		}
+	case st := <-w.exit:
+		w.task.IO().Wait()
+
+		exitCode, _, err := st.Result()
+		if err != nil {
+			return drivers.StatusError, err
+		}
+
+		switch exitCode {
+		default:
+			return drivers.StatusError, models.NewAPIError(http.StatusBadGateway, fmt.Errorf("container exit code %d", exitCode))
+		case 0:
+			return drivers.StatusSuccess, nil
+		case 137: // SIGKILL, eg. OOM
+			if err := w.oomError(ctx); err != nil {
+				return drivers.StatusKilled, err
+			}
+			return drivers.StatusKilled, models.NewAPIError(http.StatusBadGateway, fmt.Errorf("container exit code %d", exitCode))
+		}
+	}
+}
+
+// oomError returns the models.ErrorCodeFunctionOOM error of the killed container if it hit
+// its memory limit, nil if it was killed otherwise. Containers whose memory stats cannot be
+// read are taken as out of memory, like with the docker driver.
+func (w *waitResult) oomError(ctx context.Context) error {
+	if w.memory == 0 {
+		return nil
+	}
+	m, err := metrics(common.BackgroundContext(ctx), w.task)
+	if err == nil && (m.Memory == nil || m.Memory.Usage == nil || m.Memory.Usage.Failcnt == 0) {
+		return nil
+	}
+
+	peak := atomic.LoadUint64(w.peak)
+	if peak == 0 || peak > w.memory {
+		// no stats before it ran out, it used all of its memory
+		peak = w.memory
+	}
+	common.Logger(ctx).WithFields(logrus.Fields{"container": w.task.ID(), "memory": w.memory, "peak_memory": peak}).Error("containerd oom")
+	return models.NewFuncOOMError(w.memory/(1024*1024), peak/(1024*1024))
+}
+
+// metrics returns the cgroup metrics of task
+func metrics(ctx context.Context, task containerd.Task) (*cgroups.Metrics, error) {
+	metric, err := task.Metrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := typeurl.UnmarshalAny(metric.Data)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := data.(*cgroups.Metrics)
+	if !ok {
+		return nil, fmt.Errorf("unexpected metrics type %T", data)
+	}
+	return m, nil
+}
+
+// Repeatedly collect stats of the task every statsInterval until the stopSignal is closed
+// or the context is cancelled, keeping the peak memory usage of the container in peak
+func (drv *ContainerdDriver) collectStats(ctx context.Context, stopSignal <-chan struct{}, t containerd.Task, task drivers.ContainerTask, peak *uint64) {
+	ctx, span := trace.StartSpan(ctx, "containerd_collect_stats")
+	defer span.End()
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	var prev *cgroups.Metrics
+	var prevTime time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stopSignal:
+			return
+		case now := <-ticker.C:
+			m, err := metrics(ctx, t)
+			if err != nil {
+				common.Logger(ctx).WithError(err).WithFields(logrus.Fields{"container": t.ID(), "call_id": task.Id()}).Debug("error collecting containerd stats for task")
+				continue
+			}
+			recordPeakMemory(peak, m)
+			if prev != nil {
+				task.WriteStat(ctx, cherryPick(prev, m, now.Sub(prevTime), now))
+			}
+			prev, prevTime = m, now
+		}
+	}
+}
+
+// recordPeakMemory raises peak to the memory usage of the container in m, if higher
+func recordPeakMemory(peak *uint64, m *cgroups.Metrics) {
+	if m.Memory == nil || m.Memory.Usage == nil {
+		return
+	}
+	usage := m.Memory.Usage.Max
+	if m.Memory.Usage.Usage > usage {
+		usage = m.Memory.Usage.Usage
+	}
+	for {
+		old := atomic.LoadUint64(peak)
+		if usage <= old || atomic.CompareAndSwapUint64(peak, old, usage) {
+			return
+		}
+	}
+}
+
+// cherryPick returns the stat of the container at now with the metrics of the docker
+// driver from its cgroup metrics m, and prev collected interval before. CPU usage is a
+// percentage of one CPU over interval. There are no network metrics, containers only
+// have a loopback interface.
+func cherryPick(prev, m *cgroups.Metrics, interval time.Duration, now time.Time) stats.Stat {
+	var cpuUser, cpuKernel, cpuTotal uint64
+	if m.CPU != nil && m.CPU.Usage != nil && prev.CPU != nil && prev.CPU.Usage != nil && interval > 0 {
+		percent := func(cur, old uint64) uint64 {
+			if cur < old {
+				return 0
+			}
+			return uint64(float64(cur-old) / float64(interval.Nanoseconds()) * 100.0)
+		}
+		cpuUser = percent(m.CPU.Usage.User, prev.CPU.Usage.User)
+		cpuKernel = percent(m.CPU.Usage.Kernel, prev.CPU.Usage.Kernel)
+		cpuTotal = percent(m.CPU.Usage.Total, prev.CPU.Usage.Total)
+	}
+
+	var memLimit, memUsage uint64
+	if m.Memory != nil && m.Memory.Usage != nil {
+		memLimit = m.Memory.Usage.Limit
+		memUsage = m.Memory.Usage.Usage
+	}
+
+	var blkRead, blkWrite uint64
+	if m.Blkio != nil {
+		for _, bioEntry := range m.Blkio.IoServiceBytesRecursive {
+			switch strings.ToLower(bioEntry.Op) {
+			case "read":
+				blkRead = blkRead + bioEntry.Value
+			case "write":
+				blkWrite = blkWrite + bioEntry.Value
+			}
+		}
+	}
+
+	return stats.Stat{
+		Timestamp: common.DateTime(now),
+		Metrics: map[string]uint64{
+			// mem
+			"mem_limit": memLimit,
+			"mem_usage": memUsage,
+			// i/o
+			"disk_read":  blkRead,
+			"disk_write": blkWrite,
+			// cpu
+			"cpu_user":   cpuUser,
+			"cpu_total":  cpuTotal,
+			"cpu_kernel": cpuKernel,
+		},
+	}
+}
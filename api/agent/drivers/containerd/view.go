@@ -0,0 +1,69 @@
+package containerd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/mount"
+	"github.com/fnproject/fn/api/agent/drivers"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// errNoImage is returned for cookies that did not validate their image
+var errNoImage = errors.New("invalid usage: image not validated")
+
+// image returns the cookie of the driver c, which validated its image. With ImageConfig
+// and View, drivers that run the images of containerd other than as its containers, eg.
+// in microVMs, validate and pull images with cookies of the driver.
+func image(c drivers.Cookie) (*cookie, error) {
+	ck, ok := c.(*cookie)
+	if !ok || ck.image == nil {
+		return nil, errNoImage
+	}
+	return ck, nil
+}
+
+// ImageConfig returns the digest and the config of the image validated by the cookie c
+// of the driver
+func (drv *ContainerdDriver) ImageConfig(ctx context.Context, c drivers.Cookie) (digest.Digest, ocispec.ImageConfig, error) {
+	ck, err := image(c)
+	if err != nil {
+		return "", ocispec.ImageConfig{}, err
+	}
+	desc, err := ck.image.Config(ctx)
+	if err != nil {
+		return "", ocispec.ImageConfig{}, err
+	}
+	blob, err := content.ReadBlob(ctx, ck.image.ContentStore(), desc)
+	if err != nil {
+		return "", ocispec.ImageConfig{}, err
+	}
+	var config ocispec.Image
+	if err := json.Unmarshal(blob, &config); err != nil {
+		return "", ocispec.ImageConfig{}, err
+	}
+	return ck.image.Target().Digest, config.Config, nil
+}
+
+// View returns the mounts of a read only view key of the root file system of the image
+// validated by the cookie c of the driver, which is removed with RemoveView
+func (drv *ContainerdDriver) View(ctx context.Context, c drivers.Cookie, key string) ([]mount.Mount, error) {
+	ck, err := image(c)
+	if err != nil {
+		return nil, err
+	}
+	diffIDs, err := ck.image.RootFS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return drv.client.SnapshotService(drv.snapshotter).View(ctx, key, identity.ChainID(diffIDs).String())
+}
+
+// RemoveView removes the view key of View
+func (drv *ContainerdDriver) RemoveView(ctx context.Context, key string) error {
+	return drv.client.SnapshotService(drv.snapshotter).Remove(ctx, key)
+}
@@ -7,6 +7,19 @@
 //
 // The docker driver runs functions as Docker containers.
 //
+// Containerd Driver
+//
+// The containerd driver runs functions as containers of containerd, without dockerd.
+// With a remote snapshotter such as the stargz or soci snapshotters, it can pull images
+// lazily so that functions start before their whole image is downloaded. It can also
+// restore the containers of functions from CRIU checkpoints of initialized containers.
+//
+// Firecracker Driver
+//
+// The firecracker driver runs functions in Firecracker microVMs, each with its own
+// kernel, for hard isolation between tenants. It pulls images with containerd and
+// boots microVMs from snapshots of booted ones, so that functions start fast.
+//
 // Mock Driver
 //
 // The mock driver pretends to run functions but doesn't actually run them. This
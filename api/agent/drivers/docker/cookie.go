@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/fnproject/fn/api/agent/drivers"
@@ -51,6 +52,8 @@ type cookie struct {
 
 	// contains created container if CreateContainer() is called
 	container *docker.Container
+	// ids of the created sidecars of the container
+	sidecars []string
 }
 
 func (c *cookie) configureImage(log logrus.FieldLogger) {
@@ -107,8 +110,13 @@ func (c *cookie) configureMem(log logrus.FieldLogger) {
 
 	c.opts.Config.Memory = mem
 	c.opts.Config.MemorySwap = mem // disables swap
-	c.opts.Config.KernelMemory = mem
 	c.opts.HostConfig.MemorySwap = mem
+	if c.drv.cgroupV2 || c.drv.rootless {
+		// cgroup v2 has neither kernel memory limits nor swappiness, rootless podman cannot
+		// set them on cgroup v1
+		return
+	}
+	c.opts.Config.KernelMemory = mem
 	c.opts.HostConfig.KernelMemory = mem
 	var zero int64
 	c.opts.HostConfig.MemorySwappiness = &zero // disables host swap
@@ -118,6 +126,11 @@ func (c *cookie) configureFsSize(log logrus.FieldLogger) {
 	if c.task.FsSize() == 0 {
 		return
 	}
+	if c.drv.rootless {
+		// rootless storage cannot limit the size of containers, see NewPodman
+		log.WithFields(logrus.Fields{"call_id": c.task.Id()}).Debug("ignoring storage size with rootless podman")
+		return
+	}
 
 	// If defined, impose file system size limit. In MB units.
 	if c.opts.HostConfig.StorageOpt == nil {
@@ -129,6 +142,38 @@ func (c *cookie) configureFsSize(log logrus.FieldLogger) {
 	c.opts.HostConfig.StorageOpt["size"] = opt
 }
 
+func (c *cookie) configureBlockIO(log logrus.FieldLogger) {
+	blkio := c.task.BlockIO()
+	if blkio == (drivers.BlockIO{}) {
+		return
+	}
+	if c.drv.rootless {
+		// the io controller is seldom delegated to the cgroups of rootless podman
+		log.WithFields(logrus.Fields{"call_id": c.task.Id()}).Debug("ignoring block io with rootless podman")
+		return
+	}
+
+	c.opts.HostConfig.BlkioWeight = int64(blkio.Weight)
+
+	// rates are throttled per device, on the devices the containers of the driver write to
+	devices := strings.Fields(c.drv.conf.DockerBlkioDevices)
+	limits := func(rate uint64) []docker.BlockLimit {
+		if rate == 0 {
+			return nil
+		}
+		var l []docker.BlockLimit
+		for _, dev := range devices {
+			l = append(l, docker.BlockLimit{Path: dev, Rate: int64(rate)})
+		}
+		return l
+	}
+	c.opts.HostConfig.BlkioDeviceReadBps = limits(blkio.ReadBps)
+	c.opts.HostConfig.BlkioDeviceWriteBps = limits(blkio.WriteBps)
+	c.opts.HostConfig.BlkioDeviceReadIOps = limits(blkio.ReadIOps)
+	c.opts.HostConfig.BlkioDeviceWriteIOps = limits(blkio.WriteIOps)
+	log.WithFields(logrus.Fields{"weight": blkio.Weight, "read_bps": blkio.ReadBps, "write_bps": blkio.WriteBps, "read_iops": blkio.ReadIOps, "write_iops": blkio.WriteIOps, "devices": devices, "call_id": c.task.Id()}).Debug("setting block io")
+}
+
 func (c *cookie) configurePIDs(log logrus.FieldLogger) {
 	pids := c.task.PIDs()
 	if pids == 0 {
@@ -167,7 +212,7 @@ func (c *cookie) configureULimit(name string, value *uint64, log logrus.FieldLog
 func (c *cookie) configureTmpFs(log logrus.FieldLogger) {
 	// if RO Root is NOT enabled and TmpFsSize does not have any limit, then we do not need
 	// any tmpfs in the container since function can freely write whereever it wants.
-	if c.task.TmpFsSize() == 0 && !c.drv.conf.EnableReadOnlyRootFs {
+	if c.task.TmpFsSize() == 0 && !c.readOnlyRootFs() {
 		return
 	}
 
@@ -188,6 +233,11 @@ func (c *cookie) configureTmpFs(log logrus.FieldLogger) {
 	c.opts.HostConfig.Tmpfs["/tmp"] = tmpFsOption
 }
 
+// readOnlyRootFs returns whether the root file system of the container is read only
+func (c *cookie) readOnlyRootFs() bool {
+	return c.drv.conf.EnableReadOnlyRootFs || c.task.ReadOnlyRootFs()
+}
+
 func (c *cookie) configureIOFS(log logrus.FieldLogger) {
 	path := c.task.UDSDockerPath()
 	if path == "" {
@@ -220,7 +270,8 @@ func (c *cookie) configureVolumes(log logrus.FieldLogger) {
 }
 
 func (c *cookie) configureCPU(log logrus.FieldLogger) {
-	// Translate milli cpus into CPUQuota & CPUPeriod (see Linux cGroups CFS cgroup v1 documentation)
+	// Translate milli cpus into CPUQuota & CPUPeriod (see Linux cGroups CFS cgroup v1 documentation,
+	// which docker maps to cpu.max on cgroup v2)
 	// eg: task.CPUQuota() of 8000 means CPUQuota of 8 * 100000 usecs in 100000 usec period,
 	// which is approx 8 CPUS in CFS world.
 	// Also see docker run options --cpu-quota and --cpu-period
@@ -251,13 +302,13 @@ func (c *cookie) configureNetwork(log logrus.FieldLogger) {
 		return
 	}
 
-	if c.task.DisableNet() {
+	if c.task.DisableNet() || c.task.Egress().Disabled {
 		c.opts.HostConfig.NetworkMode = "none"
 		return
 	}
 
 	// If pool is enabled, we try to pick network from pool
-	if c.drv.pool != nil {
+	if c.drv.pool != nil && !c.egressAllowed() {
 		id, err := c.drv.pool.AllocPoolId()
 		if id != "" {
 			// We are able to fetch a container from pool. Now, use its
@@ -281,6 +332,27 @@ func (c *cookie) configureNetwork(log logrus.FieldLogger) {
 	}
 }
 
+func (c *cookie) configureDNS(log logrus.FieldLogger) {
+	dns := c.task.DNS()
+	if len(dns.Servers) == 0 && len(dns.Search) == 0 && len(dns.Hosts) == 0 {
+		return
+	}
+
+	// containers sharing the network of another container share its name resolution
+	if strings.HasPrefix(c.opts.HostConfig.NetworkMode, "container:") {
+		log.WithFields(logrus.Fields{"call_id": c.task.Id()}).Warn("ignoring dns of container sharing the network of a pool container")
+		return
+	}
+
+	c.opts.HostConfig.DNS = dns.Servers
+	c.opts.HostConfig.DNSSearch = dns.Search
+	for host, ip := range dns.Hosts {
+		c.opts.HostConfig.ExtraHosts = append(c.opts.HostConfig.ExtraHosts, host+":"+ip)
+	}
+	sort.Strings(c.opts.HostConfig.ExtraHosts)
+	log.WithFields(logrus.Fields{"dns": dns.Servers, "dns_search": dns.Search, "extra_hosts": c.opts.HostConfig.ExtraHosts, "call_id": c.task.Id()}).Debug("setting dns")
+}
+
 func (c *cookie) configureHostname(log logrus.FieldLogger) {
 	// hostname and container NetworkMode is not compatible.
 	if c.opts.HostConfig.NetworkMode != "" {
@@ -316,6 +388,25 @@ func (c *cookie) configureEnv(log logrus.FieldLogger) {
 	}
 }
 
+func (c *cookie) configureGPUs(log logrus.FieldLogger) {
+	gpuRuntime := c.drv.conf.DockerGPURuntime
+	if gpuRuntime == "" {
+		return
+	}
+
+	// the GPU runtime exposes the GPUs of the env, which images like the CUDA ones set to
+	// all, void keeps GPUs from containers without any
+	devices := "void"
+	if gpus := c.task.GPUs(); len(gpus) > 0 {
+		devices = strings.Join(gpus, ",")
+		if c.opts.HostConfig.Runtime == "" {
+			c.opts.HostConfig.Runtime = gpuRuntime
+		}
+	}
+	log.WithFields(logrus.Fields{"gpus": devices, "runtime": c.opts.HostConfig.Runtime, "call_id": c.task.Id()}).Debug("setting gpus")
+	c.opts.Config.Env = append(c.opts.Config.Env, "NVIDIA_VISIBLE_DEVICES="+devices)
+}
+
 func (c *cookie) configureSecurity(log logrus.FieldLogger) {
 	if c.drv.conf.DisableUnprivilegedContainers {
 		return
@@ -329,6 +420,8 @@ func (c *cookie) configureSecurity(log logrus.FieldLogger) {
 
 // implements Cookie
 func (c *cookie) Close(ctx context.Context) error {
+	c.removeSidecars(ctx)
+
 	var err error
 	if c.container != nil {
 		err = c.drv.docker.RemoveContainer(docker.RemoveContainerOptions{
@@ -353,7 +446,20 @@ func (c *cookie) Close(ctx context.Context) error {
 
 // implements Cookie
 func (c *cookie) Run(ctx context.Context) (drivers.WaitResult, error) {
-	return c.drv.run(ctx, c.task.Id(), c.task)
+	if err := c.runInitSidecars(ctx); err != nil {
+		return nil, err
+	}
+	waiter, err := c.drv.run(ctx, c.task.Id(), c.task)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.installEgress(ctx); err != nil {
+		return nil, err
+	}
+	if err := c.startSidecars(ctx); err != nil {
+		return nil, err
+	}
+	return waiter, nil
 }
 
 // implements Cookie
@@ -430,6 +536,8 @@ func (c *cookie) ValidateImage(ctx context.Context) (bool, error) {
 	// check image doesn't have Volumes
 	if !c.drv.conf.ImageEnableVolume && img.Config != nil && len(img.Config.Volumes) > 0 {
 		err = ErrImageWithVolume
+	} else if err = c.verifyPlatform(ctx, img); err == nil {
+		err = c.verifyImage(ctx, img)
 	}
 
 	c.image = &CachedImage{
@@ -440,7 +548,7 @@ func (c *cookie) ValidateImage(ctx context.Context) (bool, error) {
 	}
 
 	if c.drv.imgCache != nil {
-		if err == ErrImageWithVolume {
+		if err != nil {
 			c.drv.imgCache.Update(c.image)
 		} else {
 			c.drv.imgCache.MarkBusy(c.image)
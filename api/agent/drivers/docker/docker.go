@@ -3,10 +3,10 @@ package docker
 import (
 	"context"
 	"crypto/rand"
-	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"path"
 	"strings"
 	"time"
 
@@ -64,15 +64,32 @@ type DockerDriver struct {
 	auths    map[string]driverAuthConfig
 	pool     DockerPool
 	network  *DockerNetworks
+	// cgroupV2 is set when containers run on cgroup v2, without the kernel memory limits
+	// and swappiness of cgroup v1, see checkCgroupVersion. rootless is set when podman
+	// runs rootless, see NewPodman.
+	cgroupV2 bool
+	rootless bool
+	// windows is set when the daemon runs windows containers, on a host of ncpu CPUs
+	windows bool
+	ncpu    int
+	// platform of the containers of the daemon, nil if unknown
+	platform *models.Platform
 
 	instanceId string
 
 	imgCache  ImageCacher
 	imgPuller ImagePuller
+	verifier  *imageVerifier
 }
 
 // NewDocker implements drivers.Driver
 func NewDocker(conf drivers.Config) *DockerDriver {
+	return newDocker(conf, false)
+}
+
+// newDocker returns a driver of the daemon at conf.Docker, podman tells whether it is the
+// docker compatible API of podman
+func newDocker(conf drivers.Config, podman bool) *DockerDriver {
 	hostname, err := os.Hostname()
 	if err != nil {
 		logrus.WithError(err).Fatal("couldn't resolve hostname")
@@ -96,7 +113,7 @@ func NewDocker(conf drivers.Config) *DockerDriver {
 	driver := &DockerDriver{
 		cancel:     cancel,
 		conf:       conf,
-		docker:     newClient(ctx),
+		docker:     newClient(ctx, conf.Docker),
 		hostname:   hostname,
 		auths:      auths,
 		network:    NewDockerNetworks(conf),
@@ -109,6 +126,28 @@ func NewDocker(conf drivers.Config) *DockerDriver {
 		logrus.WithError(err).Fatal("docker version error")
 	}
 
+	err = checkWindows(ctx, driver)
+	if err != nil {
+		logrus.WithError(err).Fatal("docker info error")
+	}
+	err = checkPlatform(ctx, driver)
+	if err != nil {
+		logrus.WithError(err).Fatal("docker info error")
+	}
+
+	if podman {
+		err = checkPodmanRootless(ctx, driver)
+		if err != nil {
+			logrus.WithError(err).Fatal("podman info error")
+		}
+	}
+	if !driver.windows {
+		err = checkCgroupVersion(ctx, driver)
+		if err != nil {
+			logrus.WithError(err).Fatal("docker info error")
+		}
+	}
+
 	// start the cleanup jobs as early as possible
 	go func() {
 		killLeakedContainers(ctx, driver)
@@ -124,6 +163,16 @@ func NewDocker(conf drivers.Config) *DockerDriver {
 	}
 
 	driver.imgPuller = NewImagePuller(driver.docker)
+	mirrors, err := parseRegistryMirrors(conf.DockerRegistryMirrors)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid docker registry mirrors")
+	}
+	driver.imgPuller.SetMirrors(mirrors)
+
+	driver.verifier, err = newImageVerifier(conf)
+	if err != nil {
+		logrus.WithError(err).Fatal("cannot load image verification keys")
+	}
 
 	// finally spawn pool if enabled
 	if conf.PreForkPoolSize != 0 {
@@ -339,6 +388,20 @@ func checkDockerVersion(ctx context.Context, driver *DockerDriver) error {
 	return nil
 }
 
+// checkCgroupVersion finds out whether containers run on cgroup v2. The info of docker
+// has no cgroup version before API 1.41, but only cgroup v1 has kernel memory limits.
+func checkCgroupVersion(ctx context.Context, driver *DockerDriver) error {
+	info, err := driver.docker.Info(ctx)
+	if err != nil {
+		return err
+	}
+	driver.cgroupV2 = !info.KernelMemory
+	if driver.cgroupV2 {
+		logrus.Info("docker runs containers on cgroup v2")
+	}
+	return nil
+}
+
 func loadDockerImages(ctx context.Context, driver *DockerDriver) error {
 	if driver.conf.DockerLoadFile == "" {
 		return nil
@@ -365,10 +428,34 @@ func (drv *DockerDriver) SetPullImageRetryPolicy(policy common.BackOffConfig, ch
 	return drv.imgPuller.SetRetryPolicy(policy, checker)
 }
 
+// PrePullImage implements drivers.ImagePrePuller, with the registry credentials of the driver
+func (drv *DockerDriver) PrePullImage(ctx context.Context, image string) error {
+	_, err := drv.docker.InspectImage(ctx, image)
+	if err != docker.ErrNoSuchImage {
+		return err
+	}
+	reg, repo, tag := drivers.ParseImage(image)
+	return <-drv.imgPuller.PullImage(ctx, findRegistryConfig(reg, drv.auths), image, path.Join(reg, repo), tag)
+}
+
 func (drv *DockerDriver) CreateCookie(ctx context.Context, task drivers.ContainerTask) (drivers.Cookie, error) {
 
 	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "CreateCookie"})
 
+	runtime, err := drv.runtime(task)
+	if err != nil {
+		log.WithFields(logrus.Fields{"runtime": task.Runtime(), "runtime_class": task.RuntimeClass()}).WithError(err).Error("container runtime not available")
+		return nil, err
+	}
+	if err := drv.checkTaskPlatform(task); err != nil {
+		log.WithFields(logrus.Fields{"platform": task.Platform()}).WithError(err).Error("image platform not available")
+		return nil, err
+	}
+	if len(task.Egress().Allow) > 0 && (drv.conf.EgressPolicyImage == "" || drv.windows) {
+		log.Error("egress allow lists need an egress policy image and linux containers")
+		return nil, models.ErrEgressPolicyUnsupported
+	}
+
 	_, stdinOff := task.Input().(common.NoopReadWriteCloser)
 	stdout, stderr := task.Logger()
 	_, stdoutOff := stdout.(common.NoopReadWriteCloser)
@@ -385,8 +472,9 @@ func (drv *DockerDriver) CreateCookie(ctx context.Context, task drivers.Containe
 			AttachStderr: !stderrOff,
 		},
 		HostConfig: &docker.HostConfig{
-			ReadonlyRootfs: drv.conf.EnableReadOnlyRootFs,
+			ReadonlyRootfs: drv.conf.EnableReadOnlyRootFs || task.ReadOnlyRootFs(),
 			Init:           true,
+			Runtime:        runtime,
 		},
 		NetworkingConfig: &docker.NetworkingConfig{},
 	}
@@ -403,8 +491,10 @@ func (drv *DockerDriver) CreateCookie(ctx context.Context, task drivers.Containe
 	cookie.configureMem(log)
 	cookie.configureCmd(log)
 	cookie.configureEnv(log)
+	cookie.configureGPUs(log)
 	cookie.configureCPU(log)
 	cookie.configureFsSize(log)
+	cookie.configureBlockIO(log)
 	cookie.configurePIDs(log)
 	cookie.configureULimits(log)
 	cookie.configureTmpFs(log)
@@ -412,13 +502,41 @@ func (drv *DockerDriver) CreateCookie(ctx context.Context, task drivers.Containe
 	cookie.configureWorkDir(log)
 	cookie.configureIOFS(log)
 	cookie.configureNetwork(log)
+	cookie.configureDNS(log)
 	cookie.configureHostname(log)
 	cookie.configureImage(log)
 	cookie.configureSecurity(log)
+	cookie.configureSidecars(log)
+	cookie.configureWindows(log)
 
 	return cookie, nil
 }
 
+// runtime returns the OCI runtime of the container of task, the runtime of its runtime
+// class if it has one, see drivers.Config.DockerRuntimeClasses, or its runtime if allowed,
+// see drivers.Config.DockerRuntimes. Empty is the default runtime of dockerd.
+func (drv *DockerDriver) runtime(task drivers.ContainerTask) (string, error) {
+	if class := task.RuntimeClass(); class != "" {
+		for _, pair := range strings.Fields(drv.conf.DockerRuntimeClasses) {
+			if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 && kv[0] == class {
+				return kv[1], nil
+			}
+		}
+		return "", models.ErrRuntimeClassNotAvailable
+	}
+
+	runtime := task.Runtime()
+	if runtime == "" {
+		return "", nil
+	}
+	for _, allowed := range strings.Fields(drv.conf.DockerRuntimes) {
+		if allowed == runtime {
+			return runtime, nil
+		}
+	}
+	return "", models.ErrRuntimeNotAllowed
+}
+
 func (drv *DockerDriver) GetSlotKeyExtensions(extn map[string]string) string {
 	return ""
 }
@@ -480,7 +598,8 @@ func (drv *DockerDriver) run(ctx context.Context, container string, task drivers
 	// we want to stop trying to collect stats when the container exits
 	// collectStats will stop when stopSignal is closed or ctx is cancelled
 	stopSignal := make(chan struct{})
-	go drv.collectStats(ctx, stopSignal, container, task)
+	peakMemory := new(uint64)
+	go drv.collectStats(ctx, stopSignal, container, task, peakMemory)
 
 	err = drv.docker.StartContainerWithContext(container, nil, ctx)
 	if err != nil && ctx.Err() == nil {
@@ -498,6 +617,8 @@ func (drv *DockerDriver) run(ctx context.Context, container string, task drivers
 		waiter:    waiter,
 		drv:       drv,
 		done:      stopSignal,
+		memory:    task.Memory(),
+		peak:      peakMemory,
 	}, nil
 }
 
@@ -514,6 +635,9 @@ type waitResult struct {
 	waiter    docker.CloseWaiter
 	drv       *DockerDriver
 	done      chan struct{}
+	// memory limit of the container and peak memory usage in its stats, in bytes
+	memory uint64
+	peak   *uint64
 }
 
 // waitResult implements drivers.WaitResult
@@ -528,8 +652,9 @@ func (w *waitResult) Wait(ctx context.Context) drivers.RunResult {
 	}
 }
 
-// Repeatedly collect stats from the specified docker container until the stopSignal is closed or the context is cancelled
-func (drv *DockerDriver) collectStats(ctx context.Context, stopSignal <-chan struct{}, container string, task drivers.ContainerTask) {
+// Repeatedly collect stats from the specified docker container until the stopSignal is closed or the context is cancelled,
+// keeping the peak memory usage of the container in peak
+func (drv *DockerDriver) collectStats(ctx context.Context, stopSignal <-chan struct{}, container string, task drivers.ContainerTask, peak *uint64) {
 	ctx, span := trace.StartSpan(ctx, "docker_collect_stats")
 	defer span.End()
 
@@ -569,6 +694,7 @@ func (drv *DockerDriver) collectStats(ctx context.Context, stopSignal <-chan str
 			if !ok {
 				return
 			}
+			recordPeakMemory(peak, ds)
 			stats := cherryPick(ds)
 			if !time.Time(stats.Timestamp).IsZero() {
 				task.WriteStat(ctx, stats)
@@ -668,14 +794,16 @@ func (w *waitResult) wait(ctx context.Context) (status string, err error) {
 		return drivers.StatusError, models.NewAPIError(http.StatusBadGateway, fmt.Errorf("container exit code %d", exitCode))
 	case 0:
 		return drivers.StatusSuccess, nil
-	case 137: // OOM
-		common.Logger(ctx).Error("docker oom")
-		err := errors.New("container out of memory, you may want to raise fn.memory for this function (default: 128MB)")
-		return drivers.StatusKilled, models.NewAPIError(http.StatusBadGateway, err)
+	case 137: // SIGKILL, eg. OOM
+		if err := w.oomError(ctx); err != nil {
+			return drivers.StatusKilled, err
+		}
+		return drivers.StatusKilled, models.NewAPIError(http.StatusBadGateway, fmt.Errorf("container exit code %d", exitCode))
 	}
 }
 
 var _ drivers.Driver = &DockerDriver{}
+var _ drivers.ImagePrePuller = &DockerDriver{}
 
 func init() {
 	drivers.Register("docker", func(config drivers.Config) (drivers.Driver, error) {
@@ -36,6 +36,8 @@ type dockerClient interface {
 	UnpauseContainer(id string, ctx context.Context) error
 	PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
 	InspectImage(ctx context.Context, name string) (*docker.Image, error)
+	InspectContainerWithContext(id string, ctx context.Context) (*docker.Container, error)
+	TagImage(name string, opts docker.TagImageOptions) error
 	ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error)
 	RemoveImage(id string, opts docker.RemoveImageOptions) error
 	Stats(opts docker.StatsOptions) error
@@ -47,10 +49,16 @@ type dockerClient interface {
 }
 
 // TODO: switch to github.com/docker/engine-api
-func newClient(ctx context.Context) dockerClient {
-	// TODO this was much easier, don't need special settings at the moment
-	// docker, err := docker.NewClient(conf.Docker)
-	client, err := docker.NewClientFromEnv()
+// newClient connects to the daemon at endpoint, or to the one of the DOCKER_HOST env if
+// endpoint is empty
+func newClient(ctx context.Context, endpoint string) dockerClient {
+	var client *docker.Client
+	var err error
+	if endpoint != "" {
+		client, err = docker.NewClient(endpoint)
+	} else {
+		client, err = docker.NewClientFromEnv()
+	}
 	if err != nil {
 		logrus.WithError(err).Fatal("couldn't create docker client")
 	}
@@ -404,6 +412,20 @@ func (d *dockerWrap) InspectImage(ctx context.Context, name string) (img *docker
 	return img, err
 }
 
+func (d *dockerWrap) InspectContainerWithContext(id string, ctx context.Context) (c *docker.Container, err error) {
+	ctx, closer := makeTracker(ctx, "docker_inspect_container")
+	defer func() { closer(err) }()
+	c, err = d.docker.InspectContainerWithContext(id, ctx)
+	return c, err
+}
+
+func (d *dockerWrap) TagImage(name string, opts docker.TagImageOptions) (err error) {
+	_, closer := makeTracker(opts.Context, "docker_tag_image")
+	defer func() { closer(err) }()
+	err = d.docker.TagImage(name, opts)
+	return err
+}
+
 func (d *dockerWrap) Stats(opts docker.StatsOptions) (err error) {
 	_, closer := makeTracker(opts.Context, "docker_stats")
 	defer func() { closer(err) }()
@@ -69,6 +69,13 @@ func (c *poolTask) Memory() uint64                                 { return 0 }
 func (c *poolTask) CPUs() uint64                                   { return 0 }
 func (c *poolTask) FsSize() uint64                                 { return 0 }
 func (c *poolTask) TmpFsSize() uint64                              { return 0 }
+func (c *poolTask) ReadOnlyRootFs() bool                           { return false }
+func (c *poolTask) Sidecars() []drivers.Sidecar                    { return nil }
+func (c *poolTask) DNS() drivers.DNS                               { return drivers.DNS{} }
+func (c *poolTask) ImageKeys() []string                            { return nil }
+func (c *poolTask) Platform() string                               { return "" }
+func (c *poolTask) BlockIO() drivers.BlockIO                       { return drivers.BlockIO{} }
+func (c *poolTask) Egress() drivers.Egress                         { return drivers.Egress{} }
 func (c *poolTask) Extensions() map[string]string                  { return nil }
 func (c *poolTask) LoggerConfig() drivers.LoggerConfig             { return drivers.LoggerConfig{} }
 func (c *poolTask) WriteStat(ctx context.Context, stat stats.Stat) {}
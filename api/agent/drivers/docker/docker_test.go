@@ -3,7 +3,10 @@ package docker
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -18,13 +21,25 @@ import (
 )
 
 type taskDockerTest struct {
-	id         string
-	cmd        string
-	disableNet bool
-	input      io.Reader
-	output     io.Writer
-	errors     io.Writer
-	logURL     string
+	id           string
+	cmd          string
+	disableNet   bool
+	runtime      string
+	runtimeClass string
+	gpus         []string
+	fsSize       uint64
+	tmpFsSize    uint64
+	readOnly     bool
+	sidecars     []drivers.Sidecar
+	dns          drivers.DNS
+	egress       drivers.Egress
+	imageKeys    []string
+	platform     string
+	blkio        drivers.BlockIO
+	input        io.Reader
+	output       io.Writer
+	errors       io.Writer
+	logURL       string
 }
 
 func (f *taskDockerTest) Command() string                                            { return f.cmd }
@@ -37,13 +52,14 @@ func (f *taskDockerTest) WriteStat(context.Context, stats.Stat)
 func (f *taskDockerTest) Volumes() [][2]string                                       { return [][2]string{} }
 func (f *taskDockerTest) Memory() uint64                                             { return 256 * 1024 * 1024 }
 func (f *taskDockerTest) CPUs() uint64                                               { return 0 }
-func (f *taskDockerTest) FsSize() uint64                                             { return 0 }
+func (f *taskDockerTest) FsSize() uint64                                             { return f.fsSize }
 func (f *taskDockerTest) PIDs() uint64                                               { return 0 }
 func (f *taskDockerTest) OpenFiles() *uint64                                         { return nil }
 func (f *taskDockerTest) LockedMemory() *uint64                                      { return nil }
 func (f *taskDockerTest) PendingSignals() *uint64                                    { return nil }
 func (f *taskDockerTest) MessageQueue() *uint64                                      { return nil }
-func (f *taskDockerTest) TmpFsSize() uint64                                          { return 0 }
+func (f *taskDockerTest) TmpFsSize() uint64                                          { return f.tmpFsSize }
+func (f *taskDockerTest) ReadOnlyRootFs() bool                                       { return f.readOnly }
 func (f *taskDockerTest) WorkDir() string                                            { return "" }
 func (f *taskDockerTest) Close()                                                     {}
 func (f *taskDockerTest) WrapClose(func(func()) func())                              {}
@@ -55,10 +71,19 @@ func (f *taskDockerTest) Extensions() map[string]string
 func (f *taskDockerTest) LoggerConfig() drivers.LoggerConfig {
 	return drivers.LoggerConfig{URL: f.logURL}
 }
-func (f *taskDockerTest) UDSAgentPath() string  { return "" }
-func (f *taskDockerTest) UDSDockerPath() string { return "" }
-func (f *taskDockerTest) UDSDockerDest() string { return "" }
-func (f *taskDockerTest) DisableNet() bool      { return f.disableNet }
+func (f *taskDockerTest) UDSAgentPath() string        { return "" }
+func (f *taskDockerTest) UDSDockerPath() string       { return "" }
+func (f *taskDockerTest) UDSDockerDest() string       { return "" }
+func (f *taskDockerTest) DisableNet() bool            { return f.disableNet }
+func (f *taskDockerTest) Runtime() string             { return f.runtime }
+func (f *taskDockerTest) RuntimeClass() string        { return f.runtimeClass }
+func (f *taskDockerTest) GPUs() []string              { return f.gpus }
+func (f *taskDockerTest) Sidecars() []drivers.Sidecar { return f.sidecars }
+func (f *taskDockerTest) DNS() drivers.DNS            { return f.dns }
+func (f *taskDockerTest) ImageKeys() []string         { return f.imageKeys }
+func (f *taskDockerTest) Egress() drivers.Egress      { return f.egress }
+func (f *taskDockerTest) Platform() string            { return f.platform }
+func (f *taskDockerTest) BlockIO() drivers.BlockIO    { return f.blkio }
 
 func (f *taskDockerTest) BeforeCall(context.Context, *models.Call, drivers.CallExtensions) error {
 	return nil
@@ -550,6 +575,469 @@ func TestRunnerDockerNoNetwork(t *testing.T) {
 	// https://docs.docker.com/network/none/
 }
 
+// create cookies of containers with another runtime, which must be allowed
+func TestRunnerDockerRuntime(t *testing.T) {
+	ctx := context.Background()
+	conf := drivers.Config{DockerRuntimes: "runsc kata-runtime"}
+	dkr := &DockerDriver{conf: conf, network: NewDockerNetworks(conf)}
+
+	task := createTask("test-docker-runtime")
+	task.runtime = "runsc"
+	c, err := dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	if rt := c.(*cookie).opts.HostConfig.Runtime; rt != "runsc" {
+		t.Fatalf("Expected the container to run with runsc, got %q", rt)
+	}
+
+	task.runtime = ""
+	c, err = dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	if rt := c.(*cookie).opts.HostConfig.Runtime; rt != "" {
+		t.Fatalf("Expected the container to run with the default runtime, got %q", rt)
+	}
+
+	task.runtime = "runc-custom"
+	if _, err = dkr.CreateCookie(ctx, task); err != models.ErrRuntimeNotAllowed {
+		t.Fatalf("Expected a runtime that is not allowed to be rejected, got %v", err)
+	}
+}
+
+// create cookies of containers with a runtime class, which replaces the runtime
+func TestRunnerDockerRuntimeClass(t *testing.T) {
+	ctx := context.Background()
+	conf := drivers.Config{DockerRuntimeClasses: "kata=kata-runtime sandboxed=runsc"}
+	dkr := &DockerDriver{conf: conf, network: NewDockerNetworks(conf)}
+
+	task := createTask("test-docker-runtime-class")
+	task.runtime = "runc-custom"
+	task.runtimeClass = "kata"
+	c, err := dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	if rt := c.(*cookie).opts.HostConfig.Runtime; rt != "kata-runtime" {
+		t.Fatalf("Expected the container to run with kata-runtime, got %q", rt)
+	}
+
+	task.runtimeClass = "vm"
+	if _, err = dkr.CreateCookie(ctx, task); err != models.ErrRuntimeClassNotAvailable {
+		t.Fatalf("Expected a runtime class that is not available to be rejected, got %v", err)
+	}
+}
+
+// create cookies of containers with GPUs, which run with the GPU runtime
+func TestRunnerDockerGPUs(t *testing.T) {
+	ctx := context.Background()
+	conf := drivers.Config{DockerGPURuntime: "nvidia"}
+	dkr := &DockerDriver{conf: conf, network: NewDockerNetworks(conf)}
+
+	task := createTask("test-docker-gpus")
+	task.gpus = []string{"1", "3"}
+	c, err := dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	opts := c.(*cookie).opts
+	if opts.HostConfig.Runtime != "nvidia" {
+		t.Fatalf("Expected the container to run with nvidia, got %q", opts.HostConfig.Runtime)
+	}
+	if !hasEnv(opts.Config.Env, "NVIDIA_VISIBLE_DEVICES=1,3") {
+		t.Fatalf("Expected the container to see gpus 1 and 3, got %v", opts.Config.Env)
+	}
+
+	task.gpus = nil
+	c, err = dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	opts = c.(*cookie).opts
+	if opts.HostConfig.Runtime != "" {
+		t.Fatalf("Expected the container to run with the default runtime, got %q", opts.HostConfig.Runtime)
+	}
+	if !hasEnv(opts.Config.Env, "NVIDIA_VISIBLE_DEVICES=void") {
+		t.Fatalf("Expected the container to see no gpus, got %v", opts.Config.Env)
+	}
+}
+
+func hasEnv(env []string, kv string) bool {
+	for _, e := range env {
+		if e == kv {
+			return true
+		}
+	}
+	return false
+}
+
+// create cookies of containers of podman, without the limits it does not support
+func TestRunnerPodmanCookie(t *testing.T) {
+	ctx := context.Background()
+	conf := drivers.Config{}
+	dkr := &DockerDriver{conf: conf, network: NewDockerNetworks(conf), cgroupV2: true}
+
+	task := createTask("test-podman-cookie")
+	task.fsSize = 64
+	c, err := dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	opts := c.(*cookie).opts
+	if opts.HostConfig.MemorySwap != int64(task.Memory()) {
+		t.Fatalf("Expected swap to be disabled, got %d", opts.HostConfig.MemorySwap)
+	}
+	if opts.HostConfig.KernelMemory != 0 || opts.HostConfig.MemorySwappiness != nil {
+		t.Fatalf("Expected no kernel memory limit nor swappiness with podman, got %+v", opts.HostConfig)
+	}
+	if opts.HostConfig.StorageOpt["size"] != "64M" {
+		t.Fatalf("Expected a storage size with podman, got %v", opts.HostConfig.StorageOpt)
+	}
+
+	dkr.rootless = true
+	c, err = dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	if size, ok := c.(*cookie).opts.HostConfig.StorageOpt["size"]; ok {
+		t.Fatalf("Expected no storage size with rootless podman, got %s", size)
+	}
+
+	// rootless podman on cgroup v1 cannot set kernel memory limits either, podman of root can
+	dkr.cgroupV2 = false
+	c, err = dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	if opts := c.(*cookie).opts; opts.HostConfig.KernelMemory != 0 || opts.HostConfig.MemorySwappiness != nil {
+		t.Fatalf("Expected no kernel memory limit nor swappiness with rootless podman, got %+v", opts.HostConfig)
+	}
+	dkr.rootless = false
+	c, err = dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	if opts := c.(*cookie).opts; opts.HostConfig.KernelMemory != int64(task.Memory()) {
+		t.Fatalf("Expected a kernel memory limit with podman on cgroup v1, got %+v", opts.HostConfig)
+	}
+}
+
+// create cookies of fns with their own file system limits
+func TestRunnerDockerFsLimitsCookie(t *testing.T) {
+	ctx := context.Background()
+	conf := drivers.Config{EnableReadOnlyRootFs: false}
+	dkr := &DockerDriver{conf: conf, network: NewDockerNetworks(conf)}
+
+	task := createTask("test-fs-limits-cookie")
+	c, err := dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	opts := c.(*cookie).opts
+	if opts.HostConfig.ReadonlyRootfs || opts.HostConfig.Tmpfs != nil {
+		t.Fatalf("Expected a writable root fs without tmpfs, got %+v", opts.HostConfig)
+	}
+
+	task.tmpFsSize = 32
+	task.readOnly = true
+	c, err = dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	opts = c.(*cookie).opts
+	if !opts.HostConfig.ReadonlyRootfs {
+		t.Fatal("Expected a read only root fs")
+	}
+	if opts.HostConfig.Tmpfs["/tmp"] != "size=32m" {
+		t.Fatalf("Expected a tmpfs of 32m, got %v", opts.HostConfig.Tmpfs)
+	}
+}
+
+func TestRunnerDockerBlockIOCookie(t *testing.T) {
+	ctx := context.Background()
+	conf := drivers.Config{DockerBlkioDevices: "/dev/sda /dev/nvme0n1"}
+	dkr := &DockerDriver{conf: conf, network: NewDockerNetworks(conf)}
+
+	task := createTask("test-blkio-cookie")
+	task.fsSize = 512
+	task.blkio = drivers.BlockIO{Weight: 100, WriteBps: 10 << 20, WriteIOps: 500}
+	c, err := dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	host := c.(*cookie).opts.HostConfig
+	expected := []docker.BlockLimit{{Path: "/dev/sda", Rate: 10 << 20}, {Path: "/dev/nvme0n1", Rate: 10 << 20}}
+	if host.BlkioWeight != 100 || !reflect.DeepEqual(host.BlkioDeviceWriteBps, expected) {
+		t.Fatalf("Expected a weight of 100 and write rates %v, got %d and %v", expected, host.BlkioWeight, host.BlkioDeviceWriteBps)
+	}
+	if len(host.BlkioDeviceWriteIOps) != 2 || host.BlkioDeviceWriteIOps[1].Rate != 500 {
+		t.Fatalf("Expected write iops of 500, got %v", host.BlkioDeviceWriteIOps)
+	}
+	if host.BlkioDeviceReadBps != nil || host.BlkioDeviceReadIOps != nil {
+		t.Fatalf("Expected reads not to be throttled, got %v and %v", host.BlkioDeviceReadBps, host.BlkioDeviceReadIOps)
+	}
+	if host.StorageOpt["size"] != "512M" {
+		t.Fatalf("Expected a writable layer of 512M, got %v", host.StorageOpt)
+	}
+
+	// without devices only the weight applies
+	dkr.conf.DockerBlkioDevices = ""
+	c, err = dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	host = c.(*cookie).opts.HostConfig
+	if host.BlkioWeight != 100 || host.BlkioDeviceWriteBps != nil || host.BlkioDeviceWriteIOps != nil {
+		t.Fatalf("Expected only a weight of 100, got %+v", host)
+	}
+}
+
+// create cookies of containers with sidecars, which share their namespaces but not their mounts
+func TestRunnerDockerSidecarsCookie(t *testing.T) {
+	ctx := context.Background()
+	conf := drivers.Config{}
+	dkr := &DockerDriver{conf: conf, network: NewDockerNetworks(conf)}
+
+	task := createTask("test-sidecars-cookie")
+	task.disableNet = true
+	task.sidecars = []drivers.Sidecar{
+		{Name: "fetch", Image: "busybox", Cmd: []string{"true"}, Init: true},
+		{Name: "proxy", Image: "envoyproxy/envoy", Env: map[string]string{"LOG_LEVEL": "info"}},
+	}
+	c, err := dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	ck := c.(*cookie)
+	if ck.opts.HostConfig.IpcMode != "shareable" {
+		t.Fatalf("Expected a shareable ipc namespace, got %q", ck.opts.HostConfig.IpcMode)
+	}
+
+	fetch := ck.sidecarOptions(task.sidecars[0])
+	if fetch.Name != task.Id()+"-fetch" || fetch.HostConfig.NetworkMode != "none" || fetch.HostConfig.IpcMode != "" {
+		t.Fatalf("Expected an init sidecar on the network of the container, got %+v", fetch.HostConfig)
+	}
+	proxy := ck.sidecarOptions(task.sidecars[1])
+	if proxy.HostConfig.NetworkMode != "container:"+task.Id() || proxy.HostConfig.IpcMode != "container:"+task.Id() {
+		t.Fatalf("Expected a sidecar in the namespaces of the container, got %+v", proxy.HostConfig)
+	}
+	if proxy.HostConfig.Memory != ck.opts.HostConfig.Memory || len(proxy.HostConfig.Binds) != 0 {
+		t.Fatalf("Expected a sidecar with the limits but not the mounts of the container, got %+v", proxy.HostConfig)
+	}
+	if len(proxy.Config.Env) != 1 || proxy.Config.Env[0] != "LOG_LEVEL=info" {
+		t.Fatalf("Expected the env of the sidecar, got %v", proxy.Config.Env)
+	}
+}
+
+// create cookies of containers with their own name resolution
+func TestRunnerDockerDNSCookie(t *testing.T) {
+	ctx := context.Background()
+	conf := drivers.Config{}
+	dkr := &DockerDriver{conf: conf, network: NewDockerNetworks(conf)}
+
+	task := createTask("test-dns-cookie")
+	task.dns = drivers.DNS{
+		Servers: []string{"10.0.0.2"},
+		Search:  []string{"svc.internal"},
+		Hosts:   map[string]string{"db.internal": "10.0.0.5", "cache.internal": "10.0.0.6"},
+	}
+	c, err := dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	host := c.(*cookie).opts.HostConfig
+	if !reflect.DeepEqual(host.DNS, task.dns.Servers) || !reflect.DeepEqual(host.DNSSearch, task.dns.Search) {
+		t.Fatalf("Expected the dns servers and search domains of the task, got %v %v", host.DNS, host.DNSSearch)
+	}
+	if !reflect.DeepEqual(host.ExtraHosts, []string{"cache.internal:10.0.0.6", "db.internal:10.0.0.5"}) {
+		t.Fatalf("Expected the extra hosts of the task, got %v", host.ExtraHosts)
+	}
+}
+
+// create cookies of containers with egress policies
+func TestRunnerDockerEgressCookie(t *testing.T) {
+	ctx := context.Background()
+	conf := drivers.Config{}
+	dkr := &DockerDriver{conf: conf, network: NewDockerNetworks(conf)}
+
+	task := createTask("test-egress-cookie")
+	task.egress = drivers.Egress{Disabled: true}
+	c, err := dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	if mode := c.(*cookie).opts.HostConfig.NetworkMode; mode != "none" {
+		t.Fatalf("Expected a container without network, got %q", mode)
+	}
+
+	task.egress = drivers.Egress{Allow: []string{"10.0.0.0/8", "1.2.3.4", "fd00::1", "localhost"}}
+	if _, err := dkr.CreateCookie(ctx, task); err != models.ErrEgressPolicyUnsupported {
+		t.Fatalf("Expected allow lists to need an egress policy image, got %v", err)
+	}
+
+	dkr.conf.EgressPolicyImage = "fnproject/iptables"
+	c, err = dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	ck := c.(*cookie)
+	if ck.opts.HostConfig.NetworkMode == "none" {
+		t.Fatal("Expected a container with network")
+	}
+
+	nets, err := resolveEgress(ctx, task.egress.Allow)
+	if err != nil {
+		t.Fatalf("Couldn't resolve allow list: %v", err)
+	}
+	rules := egressRules(nets, false)
+	for _, rule := range []string{"-A OUTPUT -d 10.0.0.0/8 -j ACCEPT", "-A OUTPUT -d 1.2.3.4/32 -j ACCEPT", "-A OUTPUT -d 127.0.0.1/32 -j ACCEPT"} {
+		if !strings.Contains(rules, rule) {
+			t.Fatalf("Expected rule %s, got %s", rule, rules)
+		}
+	}
+	if strings.Contains(rules, "fd00::1") || !strings.HasSuffix(rules, "-A OUTPUT -j REJECT\nCOMMIT") {
+		t.Fatalf("Expected IPv4 rules rejecting other connections, got %s", rules)
+	}
+	if rules6 := egressRules(nets, true); !strings.Contains(rules6, "-A OUTPUT -d fd00::1/128 -j ACCEPT") || strings.Contains(rules6, "10.0.0.0/8") {
+		t.Fatalf("Expected IPv6 rules, got %s", rules6)
+	}
+
+	opts := ck.egressOptions(rules, "")
+	if opts.HostConfig.NetworkMode != "container:"+task.Id() || opts.Config.Image != "fnproject/iptables" {
+		t.Fatalf("Expected a policy container in the network of the container, got %+v", opts.HostConfig)
+	}
+}
+
+// create cookies of windows containers, without the options of linux containers
+func TestRunnerWindowsCookie(t *testing.T) {
+	ctx := context.Background()
+	conf := drivers.Config{EnableReadOnlyRootFs: true, EgressPolicyImage: "fnproject/iptables"}
+	dkr := &DockerDriver{conf: conf, network: NewDockerNetworks(conf), windows: true, ncpu: 4}
+
+	task := createTask("test-windows-cookie")
+	task.tmpFsSize = 16
+	task.sidecars = []drivers.Sidecar{{Name: "proxy", Image: "envoyproxy/envoy-windows"}}
+	c, err := dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	ck := c.(*cookie)
+	host := ck.opts.HostConfig
+	if host.Init || host.ReadonlyRootfs || host.Tmpfs != nil || host.IpcMode != "" {
+		t.Fatalf("Expected a writable container without init, tmpfs nor ipc mode, got %+v", host)
+	}
+	if host.MemorySwap != 0 || ck.opts.Config.MemorySwap != 0 || host.KernelMemory != 0 || host.MemorySwappiness != nil || ck.opts.Config.Memory != int64(task.Memory()) {
+		t.Fatalf("Expected a memory limit without swap nor kernel memory limits, got %+v", host)
+	}
+	if ck.opts.Config.User != windowsFnUser || host.CapDrop != nil || host.SecurityOpt != nil {
+		t.Fatalf("Expected an unprivileged windows user without capabilities, got %q %+v", ck.opts.Config.User, host)
+	}
+	if opts := ck.sidecarOptions(task.sidecars[0]); opts.HostConfig.Init || opts.HostConfig.IpcMode != "" || opts.HostConfig.NetworkMode != "container:"+task.Id() {
+		t.Fatalf("Expected a sidecar in the network of the container, got %+v", opts.HostConfig)
+	}
+
+	task.egress = drivers.Egress{Allow: []string{"10.0.0.0/8"}}
+	if _, err := dkr.CreateCookie(ctx, task); err != models.ErrEgressPolicyUnsupported {
+		t.Fatalf("Expected allow lists to be unsupported in windows containers, got %v", err)
+	}
+}
+
+func TestWindowsCPUPercent(t *testing.T) {
+	for _, tc := range []struct {
+		quota, period int64
+		ncpu          int
+		percent       int64
+	}{
+		{100000, 100000, 4, 25},
+		{150000, 100000, 4, 38},
+		{800000, 100000, 4, 100},
+		{100, 100000, 64, 1},
+		{100000, 100000, 0, 0},
+	} {
+		if percent := windowsCPUPercent(tc.quota, tc.period, tc.ncpu); percent != tc.percent {
+			t.Fatalf("Expected %d%% of %d CPUs for quota %d, got %d%%", tc.percent, tc.ncpu, tc.quota, percent)
+		}
+	}
+}
+
+type mockClientInspect struct {
+	dockerWrap
+	container *docker.Container
+	err       error
+}
+
+func (c *mockClientInspect) InspectContainerWithContext(id string, ctx context.Context) (*docker.Container, error) {
+	return c.container, c.err
+}
+
+func TestOOMError(t *testing.T) {
+	ctx := context.Background()
+	client := &mockClientInspect{container: &docker.Container{State: docker.State{OOMKilled: true}}}
+	w := &waitResult{container: "foo", drv: &DockerDriver{docker: client}, memory: 128 * 1024 * 1024, peak: new(uint64)}
+
+	var ds docker.Stats
+	ds.MemoryStats.Usage = 100 * 1024 * 1024
+	ds.MemoryStats.MaxUsage = 120 * 1024 * 1024
+	recordPeakMemory(w.peak, &ds)
+	ds.MemoryStats.Usage = 90 * 1024 * 1024
+	ds.MemoryStats.MaxUsage = 0
+	recordPeakMemory(w.peak, &ds)
+	err := w.oomError(ctx)
+	if models.GetErrorCode(err) != models.ErrorCodeFunctionOOM || err.Error() != models.NewFuncOOMError(128, 120).Error() {
+		t.Fatalf("Expected out of memory error with 120MB peak memory, got %v", err)
+	}
+
+	client.container.State.OOMKilled = false
+	if err := w.oomError(ctx); err != nil {
+		t.Fatalf("Expected no out of memory error of a container killed otherwise, got %v", err)
+	}
+
+	// containers that cannot be inspected ran out of memory, as before
+	client.err = errors.New("no such container")
+	w.peak = new(uint64)
+	err = w.oomError(ctx)
+	if !models.IsFuncError(err) || err.Error() != models.NewFuncOOMError(128, 128).Error() {
+		t.Fatalf("Expected out of memory error without peak memory, got %v", err)
+	}
+}
+
+// create cookies of fns of a platform, on a daemon of another platform
+func TestRunnerDockerPlatformCookie(t *testing.T) {
+	ctx := context.Background()
+	conf := drivers.Config{}
+	dkr := &DockerDriver{conf: conf, network: NewDockerNetworks(conf), platform: &models.Platform{OS: "linux", Arch: "arm64"}}
+
+	task := createTask("test-platform-cookie")
+	task.platform = "linux/amd64"
+	if _, err := dkr.CreateCookie(ctx, task); err != models.ErrImagePlatformMismatch {
+		t.Fatalf("Expected amd64 fns not to run on arm64, got %v", err)
+	}
+
+	task.platform = "linux/aarch64/v8"
+	c, err := dkr.CreateCookie(ctx, task)
+	if err != nil {
+		t.Fatalf("Couldn't create task cookie: %v", err)
+	}
+	ck := c.(*cookie)
+	if err := ck.verifyPlatform(ctx, &docker.Image{OS: "linux", Architecture: "amd64"}); err != models.ErrImagePlatformMismatch {
+		t.Fatalf("Expected an amd64 image not to run on arm64, got %v", err)
+	}
+	for _, img := range []*docker.Image{{OS: "linux", Architecture: "arm64"}, {}} {
+		if err := ck.verifyPlatform(ctx, img); err != nil {
+			t.Fatalf("Expected image %s/%s to run on arm64, got %v", img.OS, img.Architecture, err)
+		}
+	}
+}
+
+func TestPodmanHost(t *testing.T) {
+	os.Setenv("CONTAINER_HOST", "unix:///tmp/podman.sock")
+	defer os.Unsetenv("CONTAINER_HOST")
+	if host := podmanHost(); host != "unix:///tmp/podman.sock" {
+		t.Fatalf("Expected the podman endpoint of CONTAINER_HOST, got %s", host)
+	}
+}
+
 func TestRunnerDockerInvalidSyslog(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(30)*time.Second)
 	defer cancel()
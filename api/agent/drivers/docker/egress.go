@@ -0,0 +1,149 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// egressScript installs the rules of the env in the network namespace it runs in, the
+// IPv6 ones only if the namespace has IPv6. iptables-restore only flushes the filter
+// table, the nat rules of the embedded DNS of docker networks are kept.
+const egressScript = `set -e
+printf '%s\n' "$EGRESS_RULES" | iptables-restore
+if [ -e /proc/net/if_inet6 ]; then printf '%s\n' "$EGRESS_RULES6" | ip6tables-restore; fi`
+
+// egressAllowed reports whether the container has an egress allow list, its rules must
+// not be installed in a network namespace shared with other containers
+func (c *cookie) egressAllowed() bool {
+	return len(c.task.Egress().Allow) > 0
+}
+
+// resolveEgress returns the networks of the CIDRs, IPs and host names of allow, host names
+// are resolved once, so the containers may only connect to the IPs they had then
+func resolveEgress(ctx context.Context, allow []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, dest := range allow {
+		if strings.Contains(dest, "/") {
+			_, n, err := net.ParseCIDR(dest)
+			if err != nil {
+				return nil, err
+			}
+			nets = append(nets, n)
+			continue
+		}
+		ips := []net.IP{net.ParseIP(dest)}
+		if ips[0] == nil {
+			addrs, err := net.DefaultResolver.LookupIPAddr(ctx, dest)
+			if err != nil {
+				return nil, err
+			}
+			ips = ips[:0]
+			for _, addr := range addrs {
+				ips = append(ips, addr.IP)
+			}
+		}
+		for _, ip := range ips {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return nets, nil
+}
+
+// egressRules returns the iptables-restore rules, or with v6 the ip6tables-restore ones,
+// that reject the outbound connections not to nets, to loopback or for name resolution
+func egressRules(nets []*net.IPNet, v6 bool) string {
+	rules := []string{
+		"*filter",
+		":INPUT ACCEPT [0:0]",
+		":FORWARD ACCEPT [0:0]",
+		":OUTPUT ACCEPT [0:0]",
+		"-A OUTPUT -o lo -j ACCEPT",
+		"-A OUTPUT -m conntrack --ctstate ESTABLISHED,RELATED -j ACCEPT",
+		"-A OUTPUT -p udp --dport 53 -j ACCEPT",
+		"-A OUTPUT -p tcp --dport 53 -j ACCEPT",
+	}
+	for _, n := range nets {
+		if (n.IP.To4() == nil) == v6 {
+			rules = append(rules, fmt.Sprintf("-A OUTPUT -d %s -j ACCEPT", n))
+		}
+	}
+	rules = append(rules, "-A OUTPUT -j REJECT", "COMMIT")
+	return strings.Join(rules, "\n")
+}
+
+// egressOptions returns the create options of the container that installs rules and v6
+// rules in the network namespace of the container
+func (c *cookie) egressOptions(rules, rules6 string) docker.CreateContainerOptions {
+	return docker.CreateContainerOptions{
+		Name: c.task.Id() + "-egress",
+		Config: &docker.Config{
+			Image:  c.drv.conf.EgressPolicyImage,
+			Cmd:    []string{"sh", "-c", egressScript},
+			Env:    []string{"EGRESS_RULES=" + rules, "EGRESS_RULES6=" + rules6},
+			Labels: c.opts.Config.Labels,
+		},
+		HostConfig: &docker.HostConfig{
+			NetworkMode: "container:" + c.task.Id(),
+			CapDrop:     []string{"all"},
+			CapAdd:      []string{"NET_ADMIN", "NET_RAW"},
+			LogConfig:   c.opts.HostConfig.LogConfig,
+		},
+	}
+}
+
+// installEgress restricts the outbound connections of the container, and of its sidecars
+// which share its network, to its allow list. The container already runs, rules are
+// installed before it takes calls, and the container fails to start if they are not.
+func (c *cookie) installEgress(ctx context.Context) error {
+	allow := c.task.Egress().Allow
+	if len(allow) == 0 {
+		return nil
+	}
+	log := common.Logger(ctx).WithFields(logrus.Fields{"call_id": c.task.Id(), "image": c.drv.conf.EgressPolicyImage})
+
+	nets, err := resolveEgress(ctx, allow)
+	if err != nil {
+		log.WithError(err).Error("could not resolve egress allow list")
+		return models.ErrContainerInitFail
+	}
+	err = c.pullSidecarImage(ctx, drivers.Sidecar{Image: c.drv.conf.EgressPolicyImage})
+	if err != nil {
+		log.WithError(err).Error("could not pull egress policy image")
+		return err
+	}
+	opts := c.egressOptions(egressRules(nets, false), egressRules(nets, true))
+	opts.Context = ctx
+	container, err := c.drv.docker.CreateContainer(opts)
+	if err != nil {
+		log.WithError(err).Error("could not create egress policy container")
+		return err
+	}
+	// removed with the sidecars once the cookie is closed
+	c.sidecars = append(c.sidecars, container.ID)
+
+	if err := c.drv.docker.StartContainerWithContext(container.ID, nil, ctx); err != nil {
+		log.WithError(err).Error("could not start egress policy container")
+		return err
+	}
+	code, err := c.drv.docker.WaitContainerWithContext(container.ID, ctx)
+	if err == nil && code != 0 {
+		err = fmt.Errorf("egress policy container exited with %d", code)
+	}
+	if err != nil {
+		log.WithError(err).Error("could not install egress policy")
+		return models.ErrContainerInitFail
+	}
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 	"github.com/fnproject/fn/api/models"
 
 	"github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
 )
 
 // ImagePuller is an abstraction layer to handle concurrent docker-pulls. Docker internally
@@ -25,6 +28,7 @@ import (
 type ImagePuller interface {
 	PullImage(ctx context.Context, cfg *docker.AuthConfiguration, img, repo, tag string) chan error
 	SetRetryPolicy(policy common.BackOffConfig, checker drivers.RetryErrorChecker) error
+	SetMirrors(mirrors map[string]string)
 }
 
 type transfer struct {
@@ -49,6 +53,9 @@ type imagePuller struct {
 	// backoff/retry settings
 	isRetriable drivers.RetryErrorChecker
 	backOffCfg  common.BackOffConfig
+
+	// mirrors of registries, see parseRegistryMirrors
+	mirrors map[string]string
 }
 
 func NewImagePuller(docker dockerClient) ImagePuller {
@@ -67,6 +74,63 @@ func (i *imagePuller) SetRetryPolicy(policy common.BackOffConfig, checker driver
 	return nil
 }
 
+// SetMirrors sets the mirrors images are pulled from before their registry, by registry,
+// see parseRegistryMirrors
+func (i *imagePuller) SetMirrors(mirrors map[string]string) {
+	i.mirrors = mirrors
+}
+
+// parseRegistryMirrors parses the space separated registry=mirror pairs of
+// drivers.Config.DockerRegistryMirrors, eg. "docker.io=mirror.internal:5000". The * registry
+// is the mirror of registries without their own, eg. a pull-through cache of every registry.
+func parseRegistryMirrors(conf string) (map[string]string, error) {
+	mirrors := make(map[string]string)
+	for _, pair := range strings.Fields(conf) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid registry mirror %q, it must be of form registry=mirror", pair)
+		}
+		mirrors[kv[0]] = strings.TrimSuffix(kv[1], "/")
+	}
+	return mirrors, nil
+}
+
+// mirrorRepo returns the repository of img in the mirror of its registry, empty if its
+// registry has no mirror
+func (i *imagePuller) mirrorRepo(img string) string {
+	reg, repo, _ := drivers.ParseImage(img)
+	if reg == "" {
+		reg = "docker.io"
+	}
+	mirror, ok := i.mirrors[reg]
+	if !ok {
+		mirror, ok = i.mirrors["*"]
+	}
+	if !ok || mirror == reg {
+		return ""
+	}
+	return path.Join(mirror, repo)
+}
+
+// pullMirror pulls trx from the mirror of its registry, if it has one, and tags it as the
+// image of trx. Mirrors are pulled from without the credentials of the registry.
+func (i *imagePuller) pullMirror(trx *transfer) bool {
+	repo := i.mirrorRepo(trx.img)
+	if repo == "" {
+		return false
+	}
+	log := common.Logger(trx.ctx).WithFields(logrus.Fields{"image": trx.img, "mirror": repo})
+	err := i.docker.PullImage(docker.PullImageOptions{Repository: repo, Tag: trx.tag, Context: trx.ctx}, docker.AuthConfiguration{})
+	if err == nil {
+		err = i.docker.TagImage(repo+":"+trx.tag, docker.TagImageOptions{Repo: trx.repo, Tag: trx.tag, Force: true, Context: trx.ctx})
+	}
+	if err != nil {
+		log.WithError(err).Info("Failed to pull image from mirror, pulling it from its registry")
+		return false
+	}
+	return true
+}
+
 // newTransfer initiates a new docker-pull if there's no active docker-pull present for the same image.
 func (i *imagePuller) newTransfer(ctx context.Context, cfg *docker.AuthConfiguration, img, repo, tag string) chan error {
 
@@ -107,6 +171,9 @@ func (i *imagePuller) pullWithRetry(trx *transfer) error {
 	defer timer.Stop()
 
 	for {
+		if i.pullMirror(trx) {
+			return nil
+		}
 		err := i.docker.PullImage(docker.PullImageOptions{Repository: trx.repo, Tag: trx.tag, Context: trx.ctx}, *trx.cfg)
 		ok, reason := i.isRetriable(err)
 		if !ok {
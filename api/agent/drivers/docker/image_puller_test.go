@@ -47,27 +47,28 @@ func TestImagePullConcurrent1(t *testing.T) {
 
 	var wg sync.WaitGroup
 	wg.Add(20)
+	errs := make(chan error, 20)
 
 	for i := 0; i < 10; i++ {
 		go func() {
 			defer wg.Done()
-			err := <-puller.PullImage(ctx, &cfg, img, repo, tag1)
-			if err != nil {
-				t.Fatalf("err received %v", err)
-			}
+			errs <- <-puller.PullImage(ctx, &cfg, img, repo, tag1)
 		}()
 	}
 	for i := 0; i < 10; i++ {
 		go func() {
 			defer wg.Done()
-			err := <-puller.PullImage(ctx, &cfg, img, repo, tag2)
-			if err != nil {
-				t.Fatalf("err received %v", err)
-			}
+			errs <- <-puller.PullImage(ctx, &cfg, img, repo, tag2)
 		}()
 	}
 
 	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("err received %v", err)
+		}
+	}
 
 	// Should be two docker-pulls
 	if mock.numCalls != 2 || ctx.Err() != nil {
@@ -93,18 +94,22 @@ func TestImagePullConcurrent2(t *testing.T) {
 
 	var wg sync.WaitGroup
 	wg.Add(10)
+	errs := make(chan error, 10)
 
 	for i := 0; i < 10; i++ {
 		go func() {
 			defer wg.Done()
-			err := <-puller.PullImage(ctx, &cfg, img, repo, tag)
-			if err == nil || strings.Index(err.Error(), "yogurt") == -1 {
-				t.Fatalf("Unknown err received %v", err)
-			}
+			errs <- <-puller.PullImage(ctx, &cfg, img, repo, tag)
 		}()
 	}
 
 	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err == nil || strings.Index(err.Error(), "yogurt") == -1 {
+			t.Fatalf("Unknown err received %v", err)
+		}
+	}
 
 	// Should be one docker-pull
 	if mock.numCalls != 1 || ctx.Err() != nil {
@@ -145,21 +150,96 @@ func TestImagePullConcurrent3(t *testing.T) {
 
 	var wg sync.WaitGroup
 	wg.Add(10)
+	errs := make(chan error, 10)
 
 	for i := 0; i < 10; i++ {
 		go func() {
 			defer wg.Done()
-			err := <-puller.PullImage(ctx, &cfg, img, repo, tag)
-			if err == nil {
-				t.Fatalf("no err received")
-			}
+			errs <- <-puller.PullImage(ctx, &cfg, img, repo, tag)
 		}()
 	}
 
 	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err == nil {
+			t.Fatalf("no err received")
+		}
+	}
 
 	// Should be more than two docker-pulls with retries but less than 10
 	if mock.numCalls <= 1 || mock.numCalls >= 10 || ctx.Err() != nil {
 		t.Fatalf("fail numOfPulls=%d ctx=%v", mock.numCalls, ctx.Err())
 	}
 }
+
+type mockClientMirror struct {
+	dockerWrap
+
+	mtx    sync.Mutex
+	pulled []string
+	tagged []string
+	// repos whose pulls fail
+	fail map[string]bool
+}
+
+func (c *mockClientMirror) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.pulled = append(c.pulled, opts.Repository+":"+opts.Tag)
+	if c.fail[opts.Repository] {
+		return errors.New("yogurt")
+	}
+	return nil
+}
+
+func (c *mockClientMirror) TagImage(name string, opts docker.TagImageOptions) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.tagged = append(c.tagged, name+" "+opts.Repo+":"+opts.Tag)
+	return nil
+}
+
+// Lets pull images through registry mirrors, falling back to their registry if the mirror fails.
+func TestImagePullMirror(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(10*time.Second))
+	defer cancel()
+
+	mirrors, err := parseRegistryMirrors("docker.io=mirror.internal:5000 *=cache.internal/")
+	if err != nil {
+		t.Fatalf("err received %v", err)
+	}
+	cfg := docker.AuthConfiguration{}
+
+	mock := &mockClientMirror{fail: map[string]bool{}}
+	puller := NewImagePuller(mock)
+	puller.SetMirrors(mirrors)
+
+	if err := <-puller.PullImage(ctx, &cfg, "busybox:1.30", "library/busybox", "1.30"); err != nil {
+		t.Fatalf("err received %v", err)
+	}
+	if err := <-puller.PullImage(ctx, &cfg, "quay.io/foo/bar", "quay.io/foo/bar", "latest"); err != nil {
+		t.Fatalf("err received %v", err)
+	}
+	if len(mock.pulled) != 2 || mock.pulled[0] != "mirror.internal:5000/library/busybox:1.30" || mock.pulled[1] != "cache.internal/foo/bar:latest" {
+		t.Fatalf("expected pulls from the mirrors, got %v", mock.pulled)
+	}
+	if len(mock.tagged) != 2 || mock.tagged[0] != "mirror.internal:5000/library/busybox:1.30 library/busybox:1.30" {
+		t.Fatalf("expected mirror images tagged as their image, got %v", mock.tagged)
+	}
+
+	mock = &mockClientMirror{fail: map[string]bool{"mirror.internal:5000/library/busybox": true}}
+	puller = NewImagePuller(mock)
+	puller.SetMirrors(mirrors)
+
+	if err := <-puller.PullImage(ctx, &cfg, "busybox:1.30", "library/busybox", "1.30"); err != nil {
+		t.Fatalf("err received %v", err)
+	}
+	if len(mock.pulled) != 2 || mock.pulled[1] != "library/busybox:1.30" || len(mock.tagged) != 0 {
+		t.Fatalf("expected a pull from the registry after the mirror failed, got %v %v", mock.pulled, mock.tagged)
+	}
+
+	if _, err := parseRegistryMirrors("docker.io"); err == nil {
+		t.Fatal("expected invalid registry mirrors")
+	}
+}
@@ -0,0 +1,47 @@
+package docker
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// recordPeakMemory raises peak to the memory usage of the container in ds, if higher
+func recordPeakMemory(peak *uint64, ds *docker.Stats) {
+	usage := ds.MemoryStats.MaxUsage
+	if ds.MemoryStats.Usage > usage {
+		usage = ds.MemoryStats.Usage
+	}
+	for {
+		old := atomic.LoadUint64(peak)
+		if usage <= old || atomic.CompareAndSwapUint64(peak, old, usage) {
+			return
+		}
+	}
+}
+
+// oomError returns the models.ErrorCodeFunctionOOM error of the killed container if the
+// kernel killed it as it ran out of memory, nil if it was killed otherwise, eg. by docker
+// kill. Containers that cannot be inspected are taken as out of memory.
+func (w *waitResult) oomError(ctx context.Context) error {
+	log := common.Logger(ctx).WithFields(logrus.Fields{"container": w.container})
+
+	c, err := w.drv.docker.InspectContainerWithContext(w.container, common.BackgroundContext(ctx))
+	if err != nil {
+		log.WithError(err).Error("error inspecting killed container")
+	} else if !c.State.OOMKilled {
+		return nil
+	}
+
+	peak := atomic.LoadUint64(w.peak)
+	if peak == 0 || peak > w.memory {
+		// no stats before it ran out, it used all of its memory
+		peak = w.memory
+	}
+	log.WithFields(logrus.Fields{"memory": w.memory, "peak_memory": peak}).Error("docker oom")
+	return models.NewFuncOOMError(w.memory/(1024*1024), peak/(1024*1024))
+}
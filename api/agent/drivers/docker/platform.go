@@ -0,0 +1,56 @@
+package docker
+
+import (
+	"context"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// checkPlatform finds out the platform of the containers of the daemon, which pulls the
+// images of its platform from multi-arch images
+func checkPlatform(ctx context.Context, driver *DockerDriver) error {
+	info, err := driver.docker.Info(ctx)
+	if err != nil {
+		return err
+	}
+	if info.OSType != "" && info.Architecture != "" {
+		driver.platform = &models.Platform{OS: info.OSType, Arch: models.NormalizeArch(info.Architecture)}
+		logrus.WithFields(logrus.Fields{"platform": driver.platform}).Info("docker platform")
+	}
+	return nil
+}
+
+// checkTaskPlatform returns models.ErrImagePlatformMismatch if the task must run the image
+// of another platform than the one of the daemon
+func (drv *DockerDriver) checkTaskPlatform(task drivers.ContainerTask) error {
+	if task.Platform() == "" || drv.platform == nil {
+		return nil
+	}
+	p, err := models.ParsePlatform(task.Platform())
+	if err != nil {
+		return err
+	}
+	if !p.Matches(*drv.platform) {
+		return models.ErrImagePlatformMismatch
+	}
+	return nil
+}
+
+// verifyPlatform returns models.ErrImagePlatformMismatch if img is built for another platform
+// than the one of the daemon, eg. a single arch amd64 image on an arm64 runner, which would
+// fail to run its binaries. Images without platform are not checked.
+func (c *cookie) verifyPlatform(ctx context.Context, img *docker.Image) error {
+	if c.drv.platform == nil || img.OS == "" || img.Architecture == "" {
+		return nil
+	}
+	p := models.Platform{OS: img.OS, Arch: models.NormalizeArch(img.Architecture)}
+	if !p.Matches(*c.drv.platform) {
+		common.Logger(ctx).WithFields(logrus.Fields{"call_id": c.task.Id(), "image": c.task.Image(), "image_platform": p, "platform": c.drv.platform}).Error("image is not built for the platform of the docker daemon")
+		return models.ErrImagePlatformMismatch
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// minPodmanVersion is the first podman with a docker compatible API complete enough to
+	// run calls, it replaces the min docker version of drivers.Config
+	minPodmanVersion = "3.0.0"
+
+	// rootlessSecurityOption is reported in the security options of a rootless daemon
+	rootlessSecurityOption = "name=rootless"
+)
+
+// NewPodman returns a driver of the docker compatible API of podman, eg. `podman system
+// service`, at conf.Docker or else the CONTAINER_HOST env, which defaults to the socket
+// of the rootless podman of the user, or of the podman of root if the agent runs as root.
+//
+// Containers of rootless podman have no kernel memory limit and use the swappiness of the
+// host, like containers on cgroup v2, memory limits and disabled swap still apply.
+// Rootless podman cannot limit the size of the file system of containers either.
+func NewPodman(conf drivers.Config) *DockerDriver {
+	if conf.Docker == "" {
+		conf.Docker = podmanHost()
+	}
+	conf.ServerVersion = minPodmanVersion
+	return newDocker(conf, true)
+}
+
+// podmanHost returns the endpoint of podman of the CONTAINER_HOST env, or its default
+func podmanHost() string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" && os.Getuid() != 0 {
+		return "unix://" + filepath.Join(dir, "podman", "podman.sock")
+	}
+	return "unix:///run/podman/podman.sock"
+}
+
+// checkPodmanRootless finds out whether podman runs rootless
+func checkPodmanRootless(ctx context.Context, driver *DockerDriver) error {
+	info, err := driver.docker.Info(ctx)
+	if err != nil {
+		return err
+	}
+	for _, opt := range info.SecurityOptions {
+		if opt == rootlessSecurityOption {
+			driver.rootless = true
+		}
+	}
+	if driver.rootless {
+		logrus.Warn("podman runs rootless, fn file system sizes are not enforced")
+	}
+	return nil
+}
+
+func init() {
+	drivers.Register("podman", func(config drivers.Config) (drivers.Driver, error) {
+		return NewPodman(config), nil
+	})
+}
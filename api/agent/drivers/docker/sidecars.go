@@ -0,0 +1,159 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+)
+
+// configureSidecars makes the IPC namespace of the container shareable with its sidecars
+func (c *cookie) configureSidecars(log logrus.FieldLogger) {
+	for _, s := range c.task.Sidecars() {
+		if !s.Init {
+			log.WithFields(logrus.Fields{"call_id": c.task.Id()}).Debug("setting shareable ipc for sidecars")
+			c.opts.HostConfig.IpcMode = "shareable"
+			return
+		}
+	}
+}
+
+// sidecarOptions returns the create options of sidecar s of the container, which has the
+// labels, limits, security and logging of the container but none of its mounts
+func (c *cookie) sidecarOptions(s drivers.Sidecar) docker.CreateContainerOptions {
+	env := make([]string, 0, len(s.Env))
+	for name, val := range s.Env {
+		env = append(env, name+"="+val)
+	}
+
+	host := c.opts.HostConfig
+	opts := docker.CreateContainerOptions{
+		Name: c.task.Id() + "-" + s.Name,
+		Config: &docker.Config{
+			Image:  s.Image,
+			Cmd:    s.Cmd,
+			Env:    env,
+			Labels: c.opts.Config.Labels,
+			User:   c.opts.Config.User,
+		},
+		HostConfig: &docker.HostConfig{
+			Memory:               host.Memory,
+			MemorySwap:           host.MemorySwap,
+			MemorySwappiness:     host.MemorySwappiness,
+			KernelMemory:         host.KernelMemory,
+			CPUQuota:             host.CPUQuota,
+			CPUPeriod:            host.CPUPeriod,
+			CPUPercent:           host.CPUPercent,
+			BlkioWeight:          host.BlkioWeight,
+			BlkioDeviceReadBps:   host.BlkioDeviceReadBps,
+			BlkioDeviceWriteBps:  host.BlkioDeviceWriteBps,
+			BlkioDeviceReadIOps:  host.BlkioDeviceReadIOps,
+			BlkioDeviceWriteIOps: host.BlkioDeviceWriteIOps,
+			PidsLimit:            host.PidsLimit,
+			Ulimits:              host.Ulimits,
+			CapDrop:              host.CapDrop,
+			SecurityOpt:          host.SecurityOpt,
+			ReadonlyRootfs:       host.ReadonlyRootfs,
+			Tmpfs:                host.Tmpfs,
+			LogConfig:            host.LogConfig,
+			Runtime:              host.Runtime,
+			Init:                 host.Init,
+		},
+	}
+	if s.Init {
+		// the container does not run yet, init sidecars only share its network
+		opts.HostConfig.NetworkMode = host.NetworkMode
+	} else {
+		opts.HostConfig.NetworkMode = "container:" + c.task.Id()
+		// windows containers have no ipc modes, see configureWindows
+		if host.IpcMode != "" {
+			opts.HostConfig.IpcMode = "container:" + c.task.Id()
+		}
+	}
+	return opts
+}
+
+// pullSidecarImage pulls the image of sidecar s if it is not there yet
+func (c *cookie) pullSidecarImage(ctx context.Context, s drivers.Sidecar) error {
+	return c.drv.PrePullImage(ctx, s.Image)
+}
+
+// createSidecar creates sidecar s, which is removed once the cookie is closed
+func (c *cookie) createSidecar(ctx context.Context, s drivers.Sidecar) (string, error) {
+	if err := c.pullSidecarImage(ctx, s); err != nil {
+		return "", err
+	}
+	opts := c.sidecarOptions(s)
+	opts.Context = ctx
+	container, err := c.drv.docker.CreateContainer(opts)
+	if err != nil {
+		return "", err
+	}
+	c.sidecars = append(c.sidecars, container.ID)
+	return container.ID, nil
+}
+
+// runInitSidecars runs the init sidecars of the container to completion, in order
+func (c *cookie) runInitSidecars(ctx context.Context) error {
+	log := common.Logger(ctx).WithFields(logrus.Fields{"call_id": c.task.Id()})
+	for _, s := range c.task.Sidecars() {
+		if !s.Init {
+			continue
+		}
+		log := log.WithFields(logrus.Fields{"sidecar": s.Name, "image": s.Image})
+		id, err := c.createSidecar(ctx, s)
+		if err != nil {
+			log.WithError(err).Error("could not create init sidecar")
+			return err
+		}
+		if err := c.drv.docker.StartContainerWithContext(id, nil, ctx); err != nil {
+			log.WithError(err).Error("could not start init sidecar")
+			return err
+		}
+		code, err := c.drv.docker.WaitContainerWithContext(id, ctx)
+		if err == nil && code != 0 {
+			err = fmt.Errorf("init sidecar exited with %d", code)
+		}
+		if err != nil {
+			log.WithError(err).Error("init sidecar failed")
+			return models.ErrContainerInitFail
+		}
+	}
+	return nil
+}
+
+// startSidecars starts the sidecars of the container, which must run
+func (c *cookie) startSidecars(ctx context.Context) error {
+	log := common.Logger(ctx).WithFields(logrus.Fields{"call_id": c.task.Id()})
+	for _, s := range c.task.Sidecars() {
+		if s.Init {
+			continue
+		}
+		log := log.WithFields(logrus.Fields{"sidecar": s.Name, "image": s.Image})
+		id, err := c.createSidecar(ctx, s)
+		if err != nil {
+			log.WithError(err).Error("could not create sidecar")
+			return err
+		}
+		if err := c.drv.docker.StartContainerWithContext(id, nil, ctx); err != nil {
+			log.WithError(err).Error("could not start sidecar")
+			return err
+		}
+	}
+	return nil
+}
+
+// removeSidecars removes the sidecars of the container
+func (c *cookie) removeSidecars(ctx context.Context) {
+	for _, id := range c.sidecars {
+		err := c.drv.docker.RemoveContainer(docker.RemoveContainerOptions{ID: id, Force: true, RemoveVolumes: true, Context: ctx})
+		if err != nil {
+			common.Logger(ctx).WithError(err).WithFields(logrus.Fields{"call_id": c.task.Id(), "sidecar": id}).Error("error removing sidecar")
+		}
+	}
+	c.sidecars = nil
+}
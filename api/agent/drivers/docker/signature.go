@@ -0,0 +1,370 @@
+package docker
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// cosignSignatureAnnotation is the annotation of the layers of a cosign signature image
+	// that has the base64 signature of the layer, a simple signing payload
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+	// signatureCacheTTL is how long the signatures of an image are used before being
+	// fetched again, so that images keep starting during registry outages
+	signatureCacheTTL = 10 * time.Minute
+
+	// maxSignatureSize caps the manifests and payloads fetched from registries
+	maxSignatureSize = 1 << 20
+)
+
+// challengeParamPattern matches the params of a WWW-Authenticate challenge of a registry
+var challengeParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// imageSignature is a cosign signature of an image, of its payload
+type imageSignature struct {
+	payload []byte
+	sig     []byte
+}
+
+// imageVerifier verifies the cosign signatures of images, which cosign stores in the
+// repository of their image with the sha256-<digest>.sig tag
+type imageVerifier struct {
+	// keys every image must be signed by one of, see drivers.Config.ImageVerifyKeys
+	keys []interface{}
+
+	client *http.Client
+	// the fetched signatures of images, by repository and digest
+	sigs *cache.Cache
+}
+
+// newImageVerifier returns a verifier of images with the keys of drivers.Config.ImageVerifyKeys
+func newImageVerifier(conf drivers.Config) (*imageVerifier, error) {
+	v := &imageVerifier{
+		client: &http.Client{Timeout: 30 * time.Second},
+		sigs:   cache.New(signatureCacheTTL, signatureCacheTTL),
+	}
+	for _, file := range strings.Fields(conf.ImageVerifyKeys) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := models.ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image verification key %s: %v", file, err)
+		}
+		v.keys = append(v.keys, key)
+	}
+	return v, nil
+}
+
+// verifyImage checks that img, the image of the container, is signed by one of the keys of
+// the driver, if any, and by one of the keys of the task, if any
+func (c *cookie) verifyImage(ctx context.Context, img *docker.Image) error {
+	v := c.drv.verifier
+	taskKeys := c.task.ImageKeys()
+	if v == nil || (len(v.keys) == 0 && len(taskKeys) == 0) {
+		return nil
+	}
+	keys := make([]interface{}, 0, len(taskKeys))
+	for _, k := range taskKeys {
+		key, err := models.ParsePublicKey([]byte(k))
+		if err != nil {
+			return models.ErrInvalidImageSignature
+		}
+		keys = append(keys, key)
+	}
+	auth, err := c.authImage(ctx)
+	if err != nil {
+		return err
+	}
+	return v.verify(ctx, img, c.task.Image(), keys, auth)
+}
+
+// verify checks that a digest img was pulled by has signatures of one of v.keys, if any,
+// and of one of keys, if any
+func (v *imageVerifier) verify(ctx context.Context, img *docker.Image, image string, keys []interface{}, auth *docker.AuthConfiguration) error {
+	log := common.Logger(ctx).WithFields(logrus.Fields{"image": image})
+	reg, repo, _ := drivers.ParseImage(image)
+
+	// images built or loaded on the runner have no digest, they cannot be signed
+	var fetchErr error
+	for _, digest := range imageDigests(img) {
+		sigs, err := v.signatures(ctx, reg, repo, digest, auth)
+		if err != nil {
+			log.WithError(err).WithFields(logrus.Fields{"digest": digest}).Error("could not fetch image signatures")
+			fetchErr = err
+			continue
+		}
+		if signedBy(sigs, digest, v.keys) && signedBy(sigs, digest, keys) {
+			return nil
+		}
+	}
+	if fetchErr != nil {
+		return models.ErrImageSignatureUnavailable
+	}
+	log.Error("image is not signed by the keys it must be signed by")
+	return models.ErrImageNotSigned
+}
+
+// imageDigests returns the manifest digests of the repo digests of img
+func imageDigests(img *docker.Image) []string {
+	var digests []string
+	seen := make(map[string]bool)
+	for _, rd := range img.RepoDigests {
+		i := strings.LastIndex(rd, "@")
+		if i < 0 || seen[rd[i+1:]] {
+			continue
+		}
+		seen[rd[i+1:]] = true
+		digests = append(digests, rd[i+1:])
+	}
+	return digests
+}
+
+// signedBy reports whether one of sigs is a signature of digest by one of keys, which an
+// empty keys always is
+func signedBy(sigs []imageSignature, digest string, keys []interface{}) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	for _, s := range sigs {
+		var payload struct {
+			Critical struct {
+				Image struct {
+					Digest string `json:"docker-manifest-digest"`
+				} `json:"image"`
+			} `json:"critical"`
+		}
+		if err := json.Unmarshal(s.payload, &payload); err != nil || payload.Critical.Image.Digest != digest {
+			continue
+		}
+		for _, key := range keys {
+			if verifySignature(key, s.payload, s.sig) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifySignature reports whether sig is a signature of the sha256 of payload by key
+func verifySignature(key interface{}, payload, sig []byte) bool {
+	h := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) > 0 {
+			return false
+		}
+		return ecdsa.Verify(k, h[:], rs.R, rs.S)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) == nil
+	}
+	return false
+}
+
+// signatures returns the signatures of digest of repo in registry reg, cached for
+// signatureCacheTTL
+func (v *imageVerifier) signatures(ctx context.Context, reg, repo, digest string, auth *docker.AuthConfiguration) ([]imageSignature, error) {
+	key := reg + "/" + repo + "@" + digest
+	if sigs, ok := v.sigs.Get(key); ok {
+		return sigs.([]imageSignature), nil
+	}
+	sigs, err := v.fetchSignatures(ctx, reg, repo, digest, auth)
+	if err != nil {
+		return nil, err
+	}
+	v.sigs.Set(key, sigs, cache.DefaultExpiration)
+	return sigs, nil
+}
+
+// fetchSignatures fetches the signatures of digest of repo from registry reg, none if the
+// digest has no signature tag
+func (v *imageVerifier) fetchSignatures(ctx context.Context, reg, repo, digest string, auth *docker.AuthConfiguration) ([]imageSignature, error) {
+	rc := &registryClient{client: v.client, host: registryHost(reg), repo: repo, auth: auth}
+
+	tag := strings.Replace(digest, ":", "-", 1) + ".sig"
+	body, err := rc.get(ctx, "manifests/"+tag, "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json")
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var manifest struct {
+		Layers []struct {
+			Digest      string            `json:"digest"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, err
+	}
+
+	var sigs []imageSignature
+	for _, l := range manifest.Layers {
+		sig, err := base64.StdEncoding.DecodeString(l.Annotations[cosignSignatureAnnotation])
+		if err != nil || len(sig) == 0 {
+			continue
+		}
+		payload, err := rc.get(ctx, "blobs/"+l.Digest, "")
+		if err != nil {
+			return nil, err
+		}
+		if h := sha256.Sum256(payload); "sha256:"+hex.EncodeToString(h[:]) != l.Digest {
+			return nil, fmt.Errorf("signature payload does not match its digest %s", l.Digest)
+		}
+		sigs = append(sigs, imageSignature{payload: payload, sig: sig})
+	}
+	return sigs, nil
+}
+
+// registryHost returns the host of the API of registry reg, parsed by drivers.ParseImage
+func registryHost(reg string) string {
+	switch reg {
+	case "", "docker.io", "index.docker.io":
+		return "registry-1.docker.io"
+	}
+	return reg
+}
+
+var errNotFound = errors.New("not found")
+
+// registryClient gets blobs and manifests of a repository of a registry with the token or
+// basic auth the registry challenges for
+type registryClient struct {
+	client *http.Client
+	host   string
+	repo   string
+	auth   *docker.AuthConfiguration
+
+	authorization string
+}
+
+// get returns the body of the path of the repository, errNotFound if it has none
+func (rc *registryClient) get(ctx context.Context, path, accept string) ([]byte, error) {
+	u := fmt.Sprintf("https://%s/v2/%s/%s", rc.host, rc.repo, path)
+	resp, err := rc.do(ctx, u, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && rc.authorization == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := rc.authorize(ctx, challenge); err != nil {
+			return nil, err
+		}
+		resp, err = rc.do(ctx, u, accept)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("registry %s returned %s for %s", rc.host, resp.Status, path)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+}
+
+func (rc *registryClient) do(ctx context.Context, u, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if rc.authorization != "" {
+		req.Header.Set("Authorization", rc.authorization)
+	}
+	return rc.client.Do(req.WithContext(ctx))
+}
+
+// authorize answers the WWW-Authenticate challenge of the registry, with its credentials
+// if it has any
+func (rc *registryClient) authorize(ctx context.Context, challenge string) error {
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+	switch scheme {
+	case "basic":
+		if rc.auth == nil || rc.auth.Username == "" {
+			return fmt.Errorf("registry %s needs credentials", rc.host)
+		}
+		rc.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(rc.auth.Username+":"+rc.auth.Password))
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("registry %s has an unsupported authentication challenge %q", rc.host, challenge)
+	}
+
+	params := make(map[string]string)
+	for _, m := range challengeParamPattern.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || (realm.Scheme != "https" && realm.Scheme != "http") {
+		return fmt.Errorf("registry %s has an invalid token realm %q", rc.host, params["realm"])
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	q.Set("scope", "repository:"+rc.repo+":pull")
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if rc.auth != nil && rc.auth.Username != "" {
+		req.SetBasicAuth(rc.auth.Username, rc.auth.Password)
+	}
+	resp, err := rc.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token server of registry %s returned %s", rc.host, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	rc.authorization = "Bearer " + token.Token
+	return nil
+}
@@ -0,0 +1,130 @@
+package docker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/models"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// testRegistry serves the cosign signatures of digests of the foo/bar repository, behind a
+// token server
+type testRegistry struct {
+	srv *httptest.Server
+	// manifests and blobs by path
+	objects map[string][]byte
+	fetches int32
+}
+
+func newTestRegistry() *testRegistry {
+	reg := &testRegistry{objects: make(map[string][]byte)}
+	reg.srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:foo/bar:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "yogurt"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer yogurt" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, reg.srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		atomic.AddInt32(&reg.fetches, 1)
+		obj, ok := reg.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(obj)
+	}))
+	return reg
+}
+
+// sign adds the signatures of digest by keys to the registry
+func (reg *testRegistry) sign(t *testing.T, digest string, keys ...*ecdsa.PrivateKey) {
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"foo/bar"},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, digest))
+	h := sha256.Sum256(payload)
+	blob := "sha256:" + hex.EncodeToString(h[:])
+	reg.objects["/v2/foo/bar/blobs/"+blob] = payload
+
+	var layers []interface{}
+	for _, key := range keys {
+		sig, err := key.Sign(rand.Reader, h[:], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, map[string]interface{}{
+			"digest":      blob,
+			"annotations": map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+		})
+	}
+	manifest, _ := json.Marshal(map[string]interface{}{"layers": layers})
+	reg.objects["/v2/foo/bar/manifests/"+strings.Replace(digest, ":", "-", 1)+".sig"] = manifest
+}
+
+func TestImageVerify(t *testing.T) {
+	ctx := context.Background()
+	reg := newTestRegistry()
+	defer reg.srv.Close()
+
+	deployKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	appKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	signed := "sha256:" + strings.Repeat("a", 64)
+	reg.sign(t, signed, deployKey, appKey)
+	unsigned := "sha256:" + strings.Repeat("b", 64)
+
+	host := strings.TrimPrefix(reg.srv.URL, "https://")
+	image := host + "/foo/bar:1.0"
+	v, err := newImageVerifier(drivers.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.client = reg.srv.Client()
+	v.keys = []interface{}{&deployKey.PublicKey}
+	auth := &docker.AuthConfiguration{}
+
+	img := &docker.Image{RepoDigests: []string{host + "/foo/bar@" + signed}}
+	if err := v.verify(ctx, img, image, []interface{}{&appKey.PublicKey}, auth); err != nil {
+		t.Fatalf("Expected a signed image, got %v", err)
+	}
+	if err := v.verify(ctx, img, image, []interface{}{&otherKey.PublicKey}, auth); err != models.ErrImageNotSigned {
+		t.Fatalf("Expected an image not signed by the key of the app, got %v", err)
+	}
+	// the manifest and the payload of each signature, once
+	if fetches := atomic.LoadInt32(&reg.fetches); fetches != 3 {
+		t.Fatalf("Expected the signatures to be fetched once, got %d fetches", fetches)
+	}
+
+	img = &docker.Image{RepoDigests: []string{host + "/foo/bar@" + unsigned}}
+	if err := v.verify(ctx, img, image, nil, auth); err != models.ErrImageNotSigned {
+		t.Fatalf("Expected an unsigned image, got %v", err)
+	}
+	if err := v.verify(ctx, &docker.Image{}, image, nil, auth); err != models.ErrImageNotSigned {
+		t.Fatalf("Expected an image without digest to be unsigned, got %v", err)
+	}
+
+	reg.srv.Close()
+	img = &docker.Image{RepoDigests: []string{host + "/foo/bar@sha256:" + strings.Repeat("c", 64)}}
+	if err := v.verify(ctx, img, image, nil, auth); err != models.ErrImageSignatureUnavailable {
+		t.Fatalf("Expected unavailable signatures, got %v", err)
+	}
+}
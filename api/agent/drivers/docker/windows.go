@@ -0,0 +1,106 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// windowsOSType is the OS type in the info of a daemon that runs windows containers
+	windowsOSType = "windows"
+
+	// windowsFnUser is the unprivileged user of the windows base images, eg. nanoserver,
+	// which replaces FnDockerUser in windows containers
+	windowsFnUser = "ContainerUser"
+)
+
+// checkWindows finds out whether the daemon runs windows containers, and checks that they
+// run with the isolation of the driver config. The isolation of windows containers is the
+// default one of the daemon, eg. dockerd --exec-opt isolation=hyperv, containers of the
+// docker API of the driver have no isolation of their own.
+func checkWindows(ctx context.Context, driver *DockerDriver) error {
+	info, err := driver.docker.Info(ctx)
+	if err != nil {
+		return err
+	}
+	driver.windows = info.OSType == windowsOSType
+	driver.ncpu = info.NCPU
+
+	switch isolation := driver.conf.DockerIsolation; isolation {
+	case "", "default":
+	case "process", "hyperv":
+		if !driver.windows {
+			return fmt.Errorf("isolation %s needs a docker daemon of windows containers", isolation)
+		}
+		if info.Isolation != isolation {
+			return fmt.Errorf("docker runs containers with %s isolation, not %s, see the isolation exec-opt of dockerd", info.Isolation, isolation)
+		}
+	default:
+		return fmt.Errorf("invalid isolation %s", isolation)
+	}
+	if driver.windows {
+		logrus.WithFields(logrus.Fields{"isolation": info.Isolation}).Info("docker runs windows containers")
+	}
+	return nil
+}
+
+// configureWindows replaces the options of linux containers that windows containers do
+// not have: swap, pids, ulimits and block IO are not limited, the root file system is
+// writable and has no tmpfs, and CPUs are limited as a share of the CPUs of the host. It
+// must follow the other configure steps.
+func (c *cookie) configureWindows(log logrus.FieldLogger) {
+	if !c.drv.windows {
+		return
+	}
+	host := c.opts.HostConfig
+
+	// docker-init is a linux binary
+	host.Init = false
+	c.opts.Config.MemorySwap = 0
+	host.MemorySwap = 0
+	host.MemorySwappiness = nil
+	c.opts.Config.KernelMemory = 0
+	host.KernelMemory = 0
+	host.PidsLimit = nil
+	host.Ulimits = nil
+	host.BlkioWeight = 0
+	host.BlkioDeviceReadBps = nil
+	host.BlkioDeviceWriteBps = nil
+	host.BlkioDeviceReadIOps = nil
+	host.BlkioDeviceWriteIOps = nil
+	host.ReadonlyRootfs = false
+	host.Tmpfs = nil
+	// processes share the ipc of the job object of their container
+	host.IpcMode = ""
+
+	if host.CPUQuota != 0 {
+		host.CPUPercent = windowsCPUPercent(host.CPUQuota, host.CPUPeriod, c.drv.ncpu)
+		host.CPUQuota = 0
+		host.CPUPeriod = 0
+	}
+
+	if !c.drv.conf.DisableUnprivilegedContainers {
+		c.opts.Config.User = windowsFnUser
+		host.CapDrop = nil
+		host.SecurityOpt = nil
+	}
+	log.WithFields(logrus.Fields{"cpu_percent": host.CPUPercent, "user": c.opts.Config.User, "call_id": c.task.Id()}).Debug("setting windows container")
+}
+
+// windowsCPUPercent returns the share of ncpu CPUs of the CPU quota in period, in percent,
+// at least 1% and at most all of the CPUs
+func windowsCPUPercent(quota, period int64, ncpu int) int64 {
+	if ncpu <= 0 || period <= 0 {
+		return 0
+	}
+	percent := (quota*100 + period*int64(ncpu) - 1) / (period * int64(ncpu))
+	if percent > 100 {
+		return 100
+	}
+	if percent < 1 {
+		return 1
+	}
+	return percent
+}
@@ -83,6 +83,21 @@ type Driver interface {
 	Close() error
 }
 
+// ImagePrePuller is optionally implemented by a Driver that can pull images ahead of the
+// containers that run them
+type ImagePrePuller interface {
+	// PrePullImage pulls image if the driver does not have it yet
+	PrePullImage(ctx context.Context, image string) error
+}
+
+// Checkpointer is optionally implemented by a Cookie whose running container can be
+// checkpointed once initialized, so that the later containers of the same task restore
+// the checkpoint rather than initialize again, see Config.CheckpointRestore
+type Checkpointer interface {
+	// Checkpoint checkpoints the initialized container of the cookie, which keeps running
+	Checkpoint(ctx context.Context) error
+}
+
 // RunResult indicates only the final state of the task.
 type RunResult interface {
 	// Error is an actionable/checkable error from the container, nil if
@@ -109,6 +124,49 @@ type LoggerConfig struct {
 	Tags []LoggerTag
 }
 
+// Sidecar is an auxiliary container of a container, which the driver runs in the network
+// and IPC namespaces of the container, with its limits, and tears down with it. Init
+// sidecars rather run to completion before the container starts.
+type Sidecar struct {
+	Name  string
+	Image string
+	// Cmd is the command of the sidecar, the one of its image if empty
+	Cmd  []string
+	Env  map[string]string
+	Init bool
+}
+
+// DNS is the name resolution of a container
+type DNS struct {
+	// Servers are the IPs of the name servers
+	Servers []string
+	// Search are the search domains
+	Search []string
+	// Hosts are the IPs of host names, added to /etc/hosts
+	Hosts map[string]string
+}
+
+// Egress is the outbound connectivity of a container, the zero Egress leaves it open
+type Egress struct {
+	// Disabled cuts the container off the network
+	Disabled bool
+	// Allow are the CIDRs, IPs and host names the container may connect to, if not empty
+	// every other outbound connection is rejected
+	Allow []string
+}
+
+// BlockIO is the block IO throttling of a container, the zero BlockIO does not throttle it
+type BlockIO struct {
+	// Weight is the share of the block IO of the container, from 10 to 1000
+	Weight uint16
+	// ReadBps and WriteBps are the bytes per second the container may read and write
+	ReadBps  uint64
+	WriteBps uint64
+	// ReadIOps and WriteIOps are the IO operations per second the container may do
+	ReadIOps  uint64
+	WriteIOps uint64
+}
+
 // The ContainerTask interface guides container execution across a wide variety of
 // container oriented runtimes.
 type ContainerTask interface {
@@ -173,6 +231,10 @@ type ContainerTask interface {
 	// Tmpfs Filesystem size limit for the container, in megabytes.
 	TmpFsSize() uint64
 
+	// ReadOnlyRootFs mounts the root filesystem of the container read only, even if
+	// the driver is configured with a writable one.
+	ReadOnlyRootFs() bool
+
 	// WorkDir returns the working directory to use for the task. Empty string
 	// leaves it unset.
 	WorkDir() string
@@ -209,6 +271,38 @@ type ContainerTask interface {
 	// Returns true if network is disabled.
 	DisableNet() bool
 
+	// Runtime returns the OCI runtime to run the container with, eg. runsc. Empty
+	// string uses the default runtime.
+	Runtime() string
+
+	// RuntimeClass returns the runtime class of the container, eg. kata, which the
+	// driver maps to an OCI runtime and which replaces Runtime. Empty string has no class.
+	RuntimeClass() string
+
+	// GPUs returns the ids of the GPUs allotted to the container, none if empty.
+	GPUs() []string
+
+	// Sidecars returns the auxiliary containers of the container, none if empty.
+	Sidecars() []Sidecar
+
+	// DNS returns the name resolution of the container, the zero DNS keeps the one
+	// of the driver.
+	DNS() DNS
+
+	// Egress returns the outbound connectivity of the container.
+	Egress() Egress
+
+	// ImageKeys returns the PEM encoded public keys the image of the container must be
+	// signed by one of, on top of the keys of the driver. None if empty.
+	ImageKeys() []string
+
+	// Platform returns the platform the image of the container must be built for, as
+	// os/arch[/variant], any platform of the driver if empty.
+	Platform() string
+
+	// BlockIO returns the block IO throttling of the container.
+	BlockIO() BlockIO
+
 	// BeforeCall is invoked just prior to running an invocation.
 	// The Task is definitely going to be used for this invocation.
 	// Invocation extensions are passed to the Before and After calls
@@ -266,6 +360,29 @@ type Config struct {
 	ImageCleanExemptTags          string `json:"image_clean_exempt_tags"`
 	ImageEnableVolume             bool   `json:"image_enable_volume"`
 	DisableUnprivilegedContainers bool   `json:"disable_unprivileged_containers"`
+	// space separated OCI runtimes containers may run with besides the default one
+	DockerRuntimes string `json:"docker_runtimes"`
+	// space separated runtime classes and the OCI runtime of each, eg. kata=kata-runtime
+	DockerRuntimeClasses string `json:"docker_runtime_classes"`
+	// OCI runtime of containers with GPUs, eg. nvidia, empty if the host has no GPUs
+	DockerGPURuntime string `json:"docker_gpu_runtime"`
+	// space separated mirrors of registries images are pulled from first, eg.
+	// docker.io=mirror.internal:5000, the * registry mirrors every other registry
+	DockerRegistryMirrors string `json:"docker_registry_mirrors"`
+	// isolation windows containers must run with, process or hyperv, empty for any
+	DockerIsolation string `json:"docker_isolation"`
+	// space separated block devices the block IO rates of containers are throttled on, eg.
+	// the disk of the docker data root, rates are not throttled if empty
+	DockerBlkioDevices string `json:"docker_blkio_devices"`
+	// image with iptables that installs the egress allow lists of containers in their
+	// network namespace, empty if allow lists are not supported
+	EgressPolicyImage string `json:"egress_policy_image"`
+	// space separated files of PEM encoded public keys the images of containers must be
+	// signed with cosign by one of, images are not verified if empty
+	ImageVerifyKeys string `json:"image_verify_keys"`
+	// experimental, containers are checkpointed once initialized and later containers of
+	// the same task are restored from the checkpoint, with drivers that are Checkpointers
+	CheckpointRestore bool `json:"checkpoint_restore"`
 }
 
 // https://github.com/fsouza/go-dockerclient/blob/master/misc.go#L166
@@ -0,0 +1,289 @@
+package firecracker
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/agent/drivers/containerd"
+	"github.com/fnproject/fn/api/agent/drivers/firecracker/guest"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultPath is the PATH of fns whose image has none, like with containerd
+const defaultPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// seedSize is the number of random bytes microVMs add to the entropy of their kernel
+const seedSize = 64
+
+// A cookie identifies a unique request to run a task.
+type cookie struct {
+	// task associated with this cookie
+	task drivers.ContainerTask
+	// pointer to firecracker driver
+	drv *FirecrackerDriver
+	// cookie of containerd validating and pulling the image of the task
+	image drivers.Cookie
+
+	// vCPUs and memory in MiB of the microVM, see machineConfig
+	vcpus  int64
+	memory int64
+	// config of the fn in the guest, set by CreateContainer
+	config *guest.Config
+	// microVM of the fn if CreateContainer() is called
+	machine *machine
+	// socket of the fn on the host once it listens in the guest, see listenProxy
+	mu       sync.Mutex
+	listener net.Listener
+}
+
+// guestConfig returns the config of the fn of the task in the guest of its microVM, the
+// command, env and working dir of the containers of the image with config
+func (c *cookie) guestConfig(config ocispec.ImageConfig) (*guest.Config, error) {
+	g := &guest.Config{
+		Env:            withEnv(withEnv([]string{defaultPath}, config.Env), taskEnv(c.task)),
+		Dir:            config.WorkingDir,
+		UID:            containerd.FnUserId,
+		GID:            containerd.FnGroupId,
+		Hostname:       c.drv.hostname,
+		Hosts:          hosts(c.task.DNS()),
+		Resolv:         resolv(c.task.DNS()),
+		ReadOnlyRootFs: c.drv.conf.EnableReadOnlyRootFs || c.task.ReadOnlyRootFs(),
+		RootFsSize:     c.task.FsSize(),
+		TmpFsSize:      c.task.TmpFsSize(),
+		TmpFsInodes:    c.drv.conf.MaxTmpFsInodes,
+		IOFS:           c.task.UDSDockerDest(),
+		Rlimits:        rlimits(c.task),
+		Time:           time.Now().UnixNano(),
+		Seed:           make([]byte, seedSize),
+	}
+	if _, err := rand.Read(g.Seed); err != nil {
+		return nil, err
+	}
+
+	g.Args = append([]string(nil), config.Entrypoint...)
+	if cmd := c.task.Command(); cmd != "" {
+		// NOTE: split like the containerd driver
+		g.Args = append(g.Args, strings.Fields(cmd)...)
+	} else {
+		g.Args = append(g.Args, config.Cmd...)
+	}
+	if len(g.Args) == 0 {
+		return nil, errors.New("no command specified")
+	}
+	if wd := c.task.WorkDir(); wd != "" {
+		g.Dir = wd
+	}
+	if g.Dir == "" {
+		g.Dir = "/"
+	}
+
+	if c.drv.conf.DisableUnprivilegedContainers {
+		// the user of the image, numeric ids only as its passwd file is in the guest
+		g.UID, g.GID = 0, 0
+		if config.User != "" {
+			ids := strings.SplitN(config.User, ":", 2)
+			uid, err := strconv.ParseUint(ids[0], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("unsupported user %q of the image, it must be numeric", config.User)
+			}
+			g.UID, g.GID = uint32(uid), uint32(uid)
+			if len(ids) == 2 {
+				gid, err := strconv.ParseUint(ids[1], 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("unsupported user %q of the image, it must be numeric", config.User)
+				}
+				g.GID = uint32(gid)
+			}
+		}
+	}
+	return g, nil
+}
+
+// taskEnv returns the env of task as key=value
+func taskEnv(task drivers.ContainerTask) []string {
+	env := make([]string, 0, len(task.EnvVars()))
+	for name, val := range task.EnvVars() {
+		env = append(env, name+"="+val)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// withEnv returns env with the values of override, replaced or appended
+func withEnv(env, override []string) []string {
+	out := append([]string(nil), env...)
+next:
+	for _, kv := range override {
+		key := strings.SplitN(kv, "=", 2)[0]
+		for i, e := range out {
+			if strings.SplitN(e, "=", 2)[0] == key {
+				out[i] = kv
+				continue next
+			}
+		}
+		out = append(out, kv)
+	}
+	return out
+}
+
+// hosts returns the content of the hosts file of dns, empty to keep the one of the image
+func hosts(dns drivers.DNS) string {
+	if len(dns.Hosts) == 0 {
+		return ""
+	}
+	lines := []string{"127.0.0.1\tlocalhost", "::1\tlocalhost"}
+	for host, ip := range dns.Hosts {
+		lines = append(lines, ip+"\t"+host)
+	}
+	sort.Strings(lines[2:])
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// resolv returns the content of the resolv.conf file of dns, empty to keep the one of the
+// image
+func resolv(dns drivers.DNS) string {
+	var b strings.Builder
+	for _, server := range dns.Servers {
+		fmt.Fprintf(&b, "nameserver %s\n", server)
+	}
+	if len(dns.Search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(dns.Search, " "))
+	}
+	return b.String()
+}
+
+// rlimits returns the resource limits of the process of task
+func rlimits(task drivers.ContainerTask) []guest.Rlimit {
+	var limits []guest.Rlimit
+	for _, l := range []struct {
+		resource int
+		value    *uint64
+	}{
+		{guest.RlimitNofile, task.OpenFiles()},
+		{guest.RlimitMemlock, task.LockedMemory()},
+		{guest.RlimitSigpending, task.PendingSignals()},
+		{guest.RlimitMsgqueue, task.MessageQueue()},
+	} {
+		if l.value != nil {
+			limits = append(limits, guest.Rlimit{Resource: l.resource, Value: *l.value})
+		}
+	}
+	return limits
+}
+
+// implements Cookie
+func (c *cookie) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if c.listener != nil {
+		c.listener.Close()
+	}
+	c.mu.Unlock()
+	if c.machine != nil {
+		c.machine.stop()
+	}
+	return c.image.Close(ctx)
+}
+
+// implements Cookie
+func (c *cookie) Run(ctx context.Context) (drivers.WaitResult, error) {
+	return c.run(ctx)
+}
+
+// implements Cookie
+func (c *cookie) ContainerOptions() interface{} {
+	return c.config
+}
+
+// implements Cookie
+func (c *cookie) Freeze(ctx context.Context) error {
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "Freeze"})
+	log.WithFields(logrus.Fields{"call_id": c.task.Id()}).Debug("firecracker pause")
+
+	if c.machine == nil {
+		return nil
+	}
+	err := c.machine.pause(ctx)
+	if err != nil {
+		log.WithError(err).WithFields(logrus.Fields{"call_id": c.task.Id()}).Error("error pausing microVM")
+	}
+	return err
+}
+
+// implements Cookie
+func (c *cookie) Unfreeze(ctx context.Context) error {
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "Unfreeze"})
+	log.WithFields(logrus.Fields{"call_id": c.task.Id()}).Debug("firecracker resume")
+
+	if c.machine == nil {
+		return nil
+	}
+	err := c.machine.resume(ctx)
+	if err != nil {
+		log.WithError(err).WithFields(logrus.Fields{"call_id": c.task.Id()}).Error("error resuming microVM")
+	}
+	return err
+}
+
+// implements Cookie
+func (c *cookie) ValidateImage(ctx context.Context) (bool, error) {
+	return c.image.ValidateImage(ctx)
+}
+
+// implements Cookie
+func (c *cookie) PullImage(ctx context.Context) error {
+	return c.image.PullImage(ctx)
+}
+
+// implements Cookie
+func (c *cookie) CreateContainer(ctx context.Context) error {
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "CreateContainer"})
+	log.WithFields(logrus.Fields{"call_id": c.task.Id(), "image": c.task.Image()}).Debug("firecracker create microVM")
+
+	if c.machine != nil {
+		return nil
+	}
+
+	dgst, config, err := c.drv.images.ImageConfig(ctx, c.image)
+	if err != nil {
+		return err
+	}
+	if c.config, err = c.guestConfig(config); err != nil {
+		return models.NewAPIError(http.StatusBadRequest, err)
+	}
+	drive, err := c.drv.imageDrive(ctx, c.image, dgst)
+	if err != nil {
+		log.WithError(err).Error("Could not build image drive")
+		return err
+	}
+
+	c.machine, err = c.drv.startVM(ctx, c.task.Id(), drive, c.vcpus, c.memory)
+	if err != nil {
+		log.WithError(err).Error("Could not start microVM")
+		return err
+	}
+	return nil
+}
+
+// iofsPath returns the path of the socket of the fn on the host, empty without iofs
+func (c *cookie) iofsPath() string {
+	if c.task.UDSDockerPath() == "" {
+		return ""
+	}
+	return filepath.Join(c.task.UDSDockerPath(), guest.Listener)
+}
+
+var _ drivers.Cookie = &cookie{}
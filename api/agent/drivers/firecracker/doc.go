@@ -0,0 +1,8 @@
+// Package firecracker provides a Firecracker driver for Fn. Provides an implementation
+// of
+//
+//	github.com/fnproject/fn/api/agent/drivers.Driver
+//
+// that runs images in Firecracker microVMs, for hard isolation between the fns of
+// tenants on the same host.
+package firecracker
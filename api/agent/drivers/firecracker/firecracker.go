@@ -0,0 +1,292 @@
+package firecracker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/agent/drivers/containerd"
+	"github.com/fnproject/fn/api/agent/drivers/firecracker/guest"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultBin is the firecracker binary if the FIRECRACKER_BIN env is not set
+	DefaultBin = "firecracker"
+	// DefaultInit is the guest init binary, see cmd/fcinit, if the FIRECRACKER_INIT env is
+	// not set
+	DefaultInit = "fcinit"
+	// DefaultKernelArgs are the boot args of the kernel if the FIRECRACKER_KERNEL_ARGS env
+	// is not set, the driver adds the ones of the root device and init
+	DefaultKernelArgs = "console=ttyS0 reboot=k panic=1 pci=off"
+	// DefaultDir is the dir of the drives, snapshots and microVMs of the driver if the
+	// FIRECRACKER_DIR env is not set
+	DefaultDir = "/var/lib/fn/firecracker"
+)
+
+// Dirs of the dir of the driver
+const (
+	imagesDir    = "images"
+	snapshotsDir = "snapshots"
+	vmsDir       = "vms"
+	tmpDir       = "tmp"
+)
+
+const (
+	// memoryOverhead is the memory in MiB of microVMs on top of the memory of their fn,
+	// for the kernel and init of the guest
+	memoryOverhead = 64
+	// defaultMemory is the memory in MiB of fns without a memory limit
+	defaultMemory = 128
+)
+
+var (
+	ErrNetworkUnsupported = models.NewAPIError(http.StatusNotImplemented, errors.New("Functions with network access are not supported by this runner, their egress must be disabled"))
+	ErrGPUsUnsupported    = models.NewAPIError(http.StatusNotImplemented, errors.New("GPUs are not supported by this runner"))
+	ErrVolumesUnsupported = models.NewAPIError(http.StatusNotImplemented, errors.New("Volumes are not supported by this runner"))
+)
+
+// FirecrackerDriver implements drivers.Driver with microVMs of firecracker, their images
+// are pulled with a driver of containerd
+type FirecrackerDriver struct {
+	conf     drivers.Config
+	images   *containerd.ContainerdDriver
+	hostname string
+
+	// firecracker binary, kernel and its boot args of microVMs, and the dir of the driver
+	bin        string
+	kernel     string
+	kernelArgs string
+	dir        string
+	// initDrive is the drive of the guest init, kernelID identifies the kernel in the keys
+	// of snapshots
+	initDrive string
+	kernelID  string
+
+	// drives of images being built, by digest of image
+	mu       sync.Mutex
+	building map[digest.Digest]chan struct{}
+	// snapshots whether microVMs are restored from snapshots, snapshotted the keys of the
+	// snapshots taken or attempted, see startVM
+	snapshots   bool
+	snapshotted sync.Map
+}
+
+// NewFirecracker returns a driver running calls in microVMs of the firecracker binary of
+// the FIRECRACKER_BIN env, DefaultBin if not set, which boot the kernel of the
+// FIRECRACKER_KERNEL env, an uncompressed vmlinux with virtio block and vsock, with the
+// boot args of the FIRECRACKER_KERNEL_ARGS env, DefaultKernelArgs if not set. Their root
+// device is a drive of the static guest init of the FIRECRACKER_INIT env, DefaultInit in
+// the PATH if not set, which runs the fn in the root file system of its image.
+//
+// Images are pulled and unpacked with a driver of containerd, see
+// containerd.NewContainerd and its envs. The root file systems of images are converted to
+// ext4 drives with mkfs.ext4, of the e2fsprogs 1.43 or later, once per image. Drives and
+// snapshots are kept in the dir of the FIRECRACKER_DIR env, DefaultDir if not set, which
+// grows with the images and the fns run.
+//
+// Unless the FIRECRACKER_SNAPSHOTS env is false, the first microVM booted for a drive,
+// memory and vCPUs is snapshotted once its guest is up, and later microVMs with the same
+// ones are restored from the snapshot instead of booted. Snapshots take firecracker 1.1
+// or later and KVM of the same CPU.
+//
+// fns get ceil(CPUs / 1000) vCPUs and their memory plus 64 MiB for the kernel and init of
+// the guest. Writes to their root file system are kept in the memory of their microVM.
+// MicroVMs have no network, fns must have their egress disabled. GPUs, volumes, sidecars,
+// egress allow lists, image signatures and stats are not supported.
+func NewFirecracker(conf drivers.Config) (*FirecrackerDriver, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	drv := &FirecrackerDriver{
+		conf:       conf,
+		hostname:   hostname,
+		bin:        getEnv("FIRECRACKER_BIN", DefaultBin),
+		kernel:     os.Getenv("FIRECRACKER_KERNEL"),
+		kernelArgs: getEnv("FIRECRACKER_KERNEL_ARGS", DefaultKernelArgs),
+		dir:        getEnv("FIRECRACKER_DIR", DefaultDir),
+		building:   make(map[digest.Digest]chan struct{}),
+		snapshots:  os.Getenv("FIRECRACKER_SNAPSHOTS") != "false",
+	}
+	if drv.kernel == "" {
+		return nil, errors.New("the FIRECRACKER_KERNEL env of the kernel of microVMs is not set")
+	}
+	kernel, err := os.Stat(drv.kernel)
+	if err != nil {
+		return nil, err
+	}
+	drv.kernelID = fmt.Sprintf("%s %d %d", drv.kernel, kernel.Size(), kernel.ModTime().UnixNano())
+	if _, err := exec.LookPath(drv.bin); err != nil {
+		return nil, err
+	}
+
+	// the microVMs and temporary files of former drivers are gone
+	for _, dir := range []string{vmsDir, tmpDir} {
+		if err := os.RemoveAll(filepath.Join(drv.dir, dir)); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range []string{imagesDir, snapshotsDir, vmsDir, tmpDir} {
+		if err := os.MkdirAll(filepath.Join(drv.dir, dir), 0700); err != nil {
+			return nil, err
+		}
+	}
+	if drv.initDrive, err = drv.buildInitDrive(getEnv("FIRECRACKER_INIT", DefaultInit)); err != nil {
+		return nil, err
+	}
+
+	// the driver of containerd only pulls images
+	imagesConf := conf
+	imagesConf.CheckpointRestore = false
+	if drv.images, err = containerd.NewContainerd(imagesConf); err != nil {
+		return nil, err
+	}
+
+	logrus.WithFields(logrus.Fields{"kernel": drv.kernel, "init_drive": drv.initDrive, "dir": drv.dir, "snapshots": drv.snapshots}).Info("firecracker driver")
+	return drv, nil
+}
+
+// getEnv returns the value of the env key, def if not set
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// buildInitDrive returns the drive of the guest init binary init, with the mount points
+// of the guest, built once per binary
+func (drv *FirecrackerDriver) buildInitDrive(init string) (string, error) {
+	init, err := exec.LookPath(init)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(init)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	path := filepath.Join(drv.dir, "init-"+hex.EncodeToString(h.Sum(nil)[:16])+".ext4")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	dir, err := ioutil.TempDir(filepath.Join(drv.dir, tmpDir), "init-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	for _, p := range guest.MountPoints {
+		if err := os.MkdirAll(filepath.Join(dir, p), 0755); err != nil {
+			return "", err
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	dst, err := os.OpenFile(filepath.Join(dir, guest.InitPath), os.O_CREATE|os.O_WRONLY, 0755)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, f)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, mkfs(context.Background(), dir, path)
+}
+
+func (drv *FirecrackerDriver) Close() error {
+	return drv.images.Close()
+}
+
+func (drv *FirecrackerDriver) SetPullImageRetryPolicy(policy common.BackOffConfig, checker drivers.RetryErrorChecker) error {
+	return drv.images.SetPullImageRetryPolicy(policy, checker)
+}
+
+// PrePullImage implements drivers.ImagePrePuller
+func (drv *FirecrackerDriver) PrePullImage(ctx context.Context, image string) error {
+	return drv.images.PrePullImage(ctx, image)
+}
+
+func (drv *FirecrackerDriver) CreateCookie(ctx context.Context, task drivers.ContainerTask) (drivers.Cookie, error) {
+	if !task.DisableNet() && !task.Egress().Disabled {
+		return nil, ErrNetworkUnsupported
+	}
+	if len(task.GPUs()) > 0 {
+		return nil, ErrGPUsUnsupported
+	}
+	if len(task.Volumes()) > 0 {
+		return nil, ErrVolumesUnsupported
+	}
+
+	// validates the runtime, platform and images of the task like with containerd
+	image, err := drv.images.CreateCookie(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+
+	cookie := &cookie{
+		task:  task,
+		drv:   drv,
+		image: image,
+	}
+	cookie.vcpus, cookie.memory = machineConfig(task)
+	return cookie, nil
+}
+
+// machineConfig returns the vCPUs and the memory in MiB of the microVM of task
+func machineConfig(task drivers.ContainerTask) (vcpus, memory int64) {
+	vcpus = int64((task.CPUs() + 999) / 1000)
+	if vcpus == 0 {
+		vcpus = 1
+	}
+	memory = int64(task.Memory() / (1024 * 1024))
+	if memory == 0 {
+		memory = defaultMemory
+	}
+	return vcpus, memory + memoryOverhead
+}
+
+func (drv *FirecrackerDriver) GetSlotKeyExtensions(extn map[string]string) string {
+	return ""
+}
+
+var _ drivers.Driver = &FirecrackerDriver{}
+var _ drivers.ImagePrePuller = &FirecrackerDriver{}
+
+func init() {
+	drivers.Register("firecracker", func(config drivers.Config) (drivers.Driver, error) {
+		return NewFirecracker(config)
+	})
+}
+
+// snapshotKey returns the name of the snapshots of microVMs booted with the drive image,
+// vcpus and memory in MiB, see startVM
+func (drv *FirecrackerDriver) snapshotKey(image string, vcpus, memory int64) string {
+	key := strings.Join([]string{drv.kernelID, drv.kernelArgs, drv.initDrive, image, fmt.Sprint(vcpus), fmt.Sprint(memory)}, "\n")
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
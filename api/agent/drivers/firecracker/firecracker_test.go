@@ -0,0 +1,343 @@
+package firecracker
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/agent/drivers/firecracker/guest"
+	"github.com/fnproject/fn/api/agent/drivers/stats"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type taskFirecrackerTest struct {
+	id         string
+	cmd        string
+	memory     uint64
+	cpus       uint64
+	disableNet bool
+	gpus       []string
+	volumes    [][2]string
+	readOnly   bool
+	workDir    string
+	dns        drivers.DNS
+	egress     drivers.Egress
+}
+
+func (f *taskFirecrackerTest) Command() string { return f.cmd }
+func (f *taskFirecrackerTest) EnvVars() map[string]string {
+	return map[string]string{"FN_FORMAT": "http-stream", "HOME": "/fn"}
+}
+func (f *taskFirecrackerTest) Id() string    { return f.id }
+func (f *taskFirecrackerTest) Image() string { return "busybox" }
+func (f *taskFirecrackerTest) Logger() (stdout, stderr io.Writer) {
+	return common.NoopReadWriteCloser{}, common.NoopReadWriteCloser{}
+}
+func (f *taskFirecrackerTest) WriteStat(context.Context, stats.Stat)                      {}
+func (f *taskFirecrackerTest) Volumes() [][2]string                                       { return f.volumes }
+func (f *taskFirecrackerTest) Memory() uint64                                             { return f.memory }
+func (f *taskFirecrackerTest) CPUs() uint64                                               { return f.cpus }
+func (f *taskFirecrackerTest) FsSize() uint64                                             { return 0 }
+func (f *taskFirecrackerTest) PIDs() uint64                                               { return 0 }
+func (f *taskFirecrackerTest) OpenFiles() *uint64                                         { n := uint64(2048); return &n }
+func (f *taskFirecrackerTest) LockedMemory() *uint64                                      { return nil }
+func (f *taskFirecrackerTest) PendingSignals() *uint64                                    { return nil }
+func (f *taskFirecrackerTest) MessageQueue() *uint64                                      { return nil }
+func (f *taskFirecrackerTest) TmpFsSize() uint64                                          { return 0 }
+func (f *taskFirecrackerTest) ReadOnlyRootFs() bool                                       { return f.readOnly }
+func (f *taskFirecrackerTest) WorkDir() string                                            { return f.workDir }
+func (f *taskFirecrackerTest) Close()                                                     {}
+func (f *taskFirecrackerTest) WrapClose(func(func()) func())                              {}
+func (f *taskFirecrackerTest) WrapBeforeCall(func(drivers.BeforeCall) drivers.BeforeCall) {}
+func (f *taskFirecrackerTest) WrapAfterCall(func(drivers.AfterCall) drivers.AfterCall)    {}
+func (f *taskFirecrackerTest) Input() io.Reader                                           { return common.NoopReadWriteCloser{} }
+func (f *taskFirecrackerTest) Extensions() map[string]string                              { return nil }
+func (f *taskFirecrackerTest) LoggerConfig() drivers.LoggerConfig                         { return drivers.LoggerConfig{} }
+func (f *taskFirecrackerTest) UDSAgentPath() string                                       { return "" }
+func (f *taskFirecrackerTest) UDSDockerPath() string                                      { return "/tmp/iofs" }
+func (f *taskFirecrackerTest) UDSDockerDest() string                                      { return "/tmp/iofs" }
+func (f *taskFirecrackerTest) DisableNet() bool                                           { return f.disableNet }
+func (f *taskFirecrackerTest) Runtime() string                                            { return "" }
+func (f *taskFirecrackerTest) RuntimeClass() string                                       { return "" }
+func (f *taskFirecrackerTest) GPUs() []string                                             { return f.gpus }
+func (f *taskFirecrackerTest) Sidecars() []drivers.Sidecar                                { return nil }
+func (f *taskFirecrackerTest) DNS() drivers.DNS                                           { return f.dns }
+func (f *taskFirecrackerTest) ImageKeys() []string                                        { return nil }
+func (f *taskFirecrackerTest) Egress() drivers.Egress                                     { return f.egress }
+func (f *taskFirecrackerTest) Platform() string                                           { return "" }
+func (f *taskFirecrackerTest) BlockIO() drivers.BlockIO                                   { return drivers.BlockIO{} }
+
+func (f *taskFirecrackerTest) BeforeCall(context.Context, *models.Call, drivers.CallExtensions) error {
+	return nil
+}
+func (f *taskFirecrackerTest) AfterCall(context.Context, *models.Call, drivers.CallExtensions) error {
+	return nil
+}
+
+func TestMachineConfig(t *testing.T) {
+	for _, tc := range []struct {
+		cpus, memory  uint64
+		vcpus, memMiB int64
+	}{
+		{0, 0, 1, defaultMemory + memoryOverhead},
+		{500, 256 * 1024 * 1024, 1, 256 + memoryOverhead},
+		{1000, 128 * 1024 * 1024, 1, 128 + memoryOverhead},
+		{1500, 1024 * 1024 * 1024, 2, 1024 + memoryOverhead},
+	} {
+		vcpus, memory := machineConfig(&taskFirecrackerTest{cpus: tc.cpus, memory: tc.memory})
+		if vcpus != tc.vcpus || memory != tc.memMiB {
+			t.Errorf("machine config of %d mCPUs and %d bytes: got %d vCPUs and %d MiB, expected %d and %d", tc.cpus, tc.memory, vcpus, memory, tc.vcpus, tc.memMiB)
+		}
+	}
+}
+
+func TestCreateCookieUnsupported(t *testing.T) {
+	drv := &FirecrackerDriver{}
+	for _, tc := range []struct {
+		task drivers.ContainerTask
+		err  error
+	}{
+		{&taskFirecrackerTest{}, ErrNetworkUnsupported},
+		{&taskFirecrackerTest{disableNet: true, gpus: []string{"0"}}, ErrGPUsUnsupported},
+		{&taskFirecrackerTest{egress: drivers.Egress{Disabled: true}, volumes: [][2]string{{"/data", "/data"}}}, ErrVolumesUnsupported},
+	} {
+		if _, err := drv.CreateCookie(context.Background(), tc.task); err != tc.err {
+			t.Errorf("cookie of %+v: expected error %v, got %v", tc.task, tc.err, err)
+		}
+	}
+}
+
+func TestGuestConfig(t *testing.T) {
+	drv := &FirecrackerDriver{hostname: "runner"}
+	image := ocispec.ImageConfig{
+		Entrypoint: []string{"/entrypoint"},
+		Cmd:        []string{"serve"},
+		Env:        []string{"PATH=/app/bin", "HOME=/root"},
+		WorkingDir: "/app",
+		User:       "nobody",
+	}
+
+	c := &cookie{drv: drv, task: &taskFirecrackerTest{dns: drivers.DNS{Hosts: map[string]string{"db": "10.0.0.2"}}}}
+	g, err := c.guestConfig(image)
+	if err != nil {
+		t.Fatalf("Couldn't get guest config: %v", err)
+	}
+	if expected := []string{"/entrypoint", "serve"}; !reflect.DeepEqual(g.Args, expected) {
+		t.Errorf("expected args %v, got %v", expected, g.Args)
+	}
+	if expected := []string{"PATH=/app/bin", "HOME=/fn", "FN_FORMAT=http-stream"}; !reflect.DeepEqual(g.Env, expected) {
+		t.Errorf("expected env %v, got %v", expected, g.Env)
+	}
+	if g.Dir != "/app" || g.UID != 1000 || g.GID != 1000 || g.Hostname != "runner" || g.IOFS != "/tmp/iofs" {
+		t.Errorf("unexpected guest config %+v", g)
+	}
+	if g.Hosts != "127.0.0.1\tlocalhost\n::1\tlocalhost\n10.0.0.2\tdb\n" || g.Resolv != "" {
+		t.Errorf("unexpected hosts %q and resolv.conf %q", g.Hosts, g.Resolv)
+	}
+	if expected := []guest.Rlimit{{Resource: guest.RlimitNofile, Value: 2048}}; !reflect.DeepEqual(g.Rlimits, expected) {
+		t.Errorf("expected rlimits %v, got %v", expected, g.Rlimits)
+	}
+	if len(g.Seed) != seedSize || g.Time == 0 {
+		t.Errorf("guest config without seed or time")
+	}
+
+	c = &cookie{drv: drv, task: &taskFirecrackerTest{cmd: "./fn --debug", workDir: "/fn", readOnly: true}}
+	g, err = c.guestConfig(image)
+	if err != nil {
+		t.Fatalf("Couldn't get guest config: %v", err)
+	}
+	if expected := []string{"/entrypoint", "./fn", "--debug"}; !reflect.DeepEqual(g.Args, expected) {
+		t.Errorf("expected args %v, got %v", expected, g.Args)
+	}
+	if g.Dir != "/fn" || !g.ReadOnlyRootFs {
+		t.Errorf("unexpected guest config %+v", g)
+	}
+
+	c = &cookie{drv: drv, task: &taskFirecrackerTest{}}
+	if _, err := c.guestConfig(ocispec.ImageConfig{}); err == nil {
+		t.Errorf("expected an error without command")
+	}
+	if g, _ := c.guestConfig(ocispec.ImageConfig{Cmd: []string{"sh"}}); g.Env[0] != defaultPath || g.Dir != "/" {
+		t.Errorf("expected default PATH and dir, got %v and %q", g.Env, g.Dir)
+	}
+
+	drv.conf.DisableUnprivilegedContainers = true
+	for user, ids := range map[string][2]uint32{"": {0, 0}, "33": {33, 33}, "33:44": {33, 44}} {
+		image.User = user
+		g, err := c.guestConfig(image)
+		if err != nil {
+			t.Fatalf("Couldn't get guest config: %v", err)
+		}
+		if g.UID != ids[0] || g.GID != ids[1] {
+			t.Errorf("user %q: expected ids %v, got %d:%d", user, ids, g.UID, g.GID)
+		}
+	}
+	image.User = "nobody"
+	if _, err := c.guestConfig(image); err == nil {
+		t.Errorf("expected an error with user %q", image.User)
+	}
+}
+
+func TestSnapshotKey(t *testing.T) {
+	drv := &FirecrackerDriver{kernelID: "vmlinux 1 2", kernelArgs: DefaultKernelArgs, initDrive: "init.ext4"}
+	key := drv.snapshotKey("a.ext4", 1, 192)
+	if key != drv.snapshotKey("a.ext4", 1, 192) {
+		t.Errorf("snapshot keys are not stable")
+	}
+	for _, other := range []string{drv.snapshotKey("b.ext4", 1, 192), drv.snapshotKey("a.ext4", 2, 192), drv.snapshotKey("a.ext4", 1, 320)} {
+		if other == key {
+			t.Errorf("snapshots of different microVMs have the same key %s", key)
+		}
+	}
+}
+
+// serveVsock serves the vsock of a fake firecracker at path, connecting CONNECT 1024 to
+// an echo server
+func serveVsock(t *testing.T, path string) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				line, _ := r.ReadString('\n')
+				if line != "CONNECT 1024\n" {
+					return
+				}
+				io.WriteString(conn, "OK 1073741824\nhello")
+				io.Copy(conn, r)
+			}()
+		}
+	}()
+}
+
+func TestDialVsock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, vsockSocket)
+
+	// the socket does not exist yet, like before firecracker starts
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		serveVsock(t, path)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := dialVsock(ctx, path, guest.ConfigPort)
+	if err != nil {
+		t.Fatalf("Couldn't connect to vsock: %v", err)
+	}
+	defer conn.Close()
+
+	b := make([]byte, len("hello"))
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != "hello" {
+		t.Fatalf("expected the data of the guest after the reply, got %q: %v", b, err)
+	}
+	io.WriteString(conn, "world")
+	conn.CloseWrite()
+	if b, err := ioutil.ReadAll(conn); err != nil || string(b) != "world" {
+		t.Fatalf("expected echo, got %q: %v", b, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := dialVsock(ctx, path, guest.StdinPort); err == nil {
+		t.Fatalf("expected an error connecting to a port the guest does not listen on")
+	}
+}
+
+func TestMachineRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "firecracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, apiSocket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch && r.URL.Path == "/vm" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `{"fault_message": "Loading a microVM snapshot not allowed after configuring boot-specific resources."}`)
+	}))
+
+	m := &machine{dir: dir, api: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}}
+	ctx := context.Background()
+	if err := m.pause(ctx); err != nil {
+		t.Fatalf("Couldn't pause: %v", err)
+	}
+	err = m.load(ctx, "state", "memory")
+	if expected := "firecracker PUT /snapshot/load: Loading a microVM snapshot not allowed after configuring boot-specific resources."; err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestMkfs(t *testing.T) {
+	if _, err := exec.LookPath("mkfs.ext4"); err != nil {
+		t.Skip("mkfs.ext4 is not installed")
+	}
+	dir, err := ioutil.TempDir("", "rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "bin", "fn"), make([]byte, 3*blockSize+1), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	size, inodes, err := fsSize(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := int64(3); inodes < files+1024 || size < 4*blockSize+minFsSize || size%blockSize != 0 {
+		t.Fatalf("unexpected file system size %d and inodes %d", size, inodes)
+	}
+
+	drive := filepath.Join(dir, "image.ext4")
+	if err := mkfs(context.Background(), src, drive); err != nil {
+		t.Fatalf("Couldn't build drive: %v", err)
+	}
+	if fi, err := os.Stat(drive); err != nil || fi.Size() != size {
+		t.Fatalf("expected a drive of %d bytes: %v", size, err)
+	}
+	if _, err := os.Stat(drive + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected the temporary drive to be gone: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+// Package guest is the init of the microVMs of the firecracker driver, which runs the
+// process of a fn in the root file system of its image, and its protocol with the driver
+// over vsock. See cmd/fcinit for the init binary.
+package guest
+
+// Ports of vsock of the microVMs
+const (
+	// ConfigPort is the port of the guest the driver sends the Config of the fn to, the
+	// guest replies with the Status of the fn until it exits
+	ConfigPort = 1024
+	// StdinPort, StdoutPort and StderrPort are the ports of the guest of the stdio of the fn
+	StdinPort  = 1025
+	StdoutPort = 1026
+	StderrPort = 1027
+	// ListenerPort is the port of the guest whose connections are proxied to the socket of
+	// the fn in its iofs
+	ListenerPort = 1028
+	// ReadyPort is the port of the host the guest connects to once it listens on its
+	// ports, ie. once the microVM can be snapshotted
+	ReadyPort = 1029
+)
+
+const (
+	// InitPath is the path of the init on its drive, the root device of the microVMs
+	InitPath = "/init"
+	// ImageDevice is the drive of the root file system of the image of the fn
+	ImageDevice = "/dev/vdb"
+	// Listener is the name of the socket of fns in their iofs
+	Listener = "lsnr.sock"
+)
+
+// Mount points of the init drive: the image drive is mounted at ImageDir and the root
+// file system of the fn at RootDir, an overlay of the image with its writes in a tmpfs at
+// RWDir
+const (
+	ImageDir = "/mnt/image"
+	RWDir    = "/mnt/rw"
+	RootDir  = "/mnt/root"
+)
+
+// MountPoints are the dirs of the init drive
+var MountPoints = []string{"/proc", "/sys", "/dev", ImageDir, RWDir, RootDir}
+
+// Config is the process of a fn and the settings of its root file system
+type Config struct {
+	// Args, Env and Dir are the command line, the env as key=value and the working dir of
+	// the process, which runs as UID and GID
+	Args []string `json:"args"`
+	Env  []string `json:"env,omitempty"`
+	Dir  string   `json:"dir,omitempty"`
+	UID  uint32   `json:"uid"`
+	GID  uint32   `json:"gid"`
+	// Rlimits are the resource limits of the process
+	Rlimits []Rlimit `json:"rlimits,omitempty"`
+
+	Hostname string `json:"hostname,omitempty"`
+	// Hosts and Resolv are the content of the /etc/hosts and /etc/resolv.conf files, the
+	// ones of the image are kept if empty
+	Hosts  string `json:"hosts,omitempty"`
+	Resolv string `json:"resolv,omitempty"`
+
+	// ReadOnlyRootFs makes the root file system read only, else writes to it are kept in
+	// memory, RootFsSize MB at most if not 0
+	ReadOnlyRootFs bool   `json:"read_only_root_fs,omitempty"`
+	RootFsSize     uint64 `json:"root_fs_size,omitempty"`
+	// TmpFsSize is the size in MB of the tmpfs at /tmp and TmpFsInodes its number of
+	// inodes, unlimited if 0. /tmp is a tmpfs only with a read only root file system or a
+	// size.
+	TmpFsSize   uint64 `json:"tmp_fs_size,omitempty"`
+	TmpFsInodes uint64 `json:"tmp_fs_inodes,omitempty"`
+	// IOFS is the dir of the socket of the fn
+	IOFS string `json:"iofs,omitempty"`
+
+	// Time is the time of the host in ns since the epoch, Seed random bytes of the host,
+	// the clock and the entropy of microVMs restored from snapshots are the ones of the
+	// snapshot
+	Time int64  `json:"time"`
+	Seed []byte `json:"seed,omitempty"`
+}
+
+// Resources of Rlimit, the ones of linux on amd64 and arm64
+const (
+	RlimitNofile     = 7
+	RlimitMemlock    = 8
+	RlimitSigpending = 11
+	RlimitMsgqueue   = 12
+)
+
+// Rlimit is a resource limit, soft and hard, of the process of a fn
+type Rlimit struct {
+	Resource int    `json:"resource"`
+	Value    uint64 `json:"value"`
+}
+
+// Status is a status of the process of a fn
+type Status struct {
+	// Listening is sent once the fn listens on its socket
+	Listening bool `json:"listening,omitempty"`
+	// Exited is sent once the process exits with ExitCode, OOM if the kernel killed a
+	// process for lack of memory meanwhile
+	Exited   bool `json:"exited,omitempty"`
+	ExitCode int  `json:"exit_code,omitempty"`
+	OOM      bool `json:"oom,omitempty"`
+	// Error is sent if the process could not be run
+	Error string `json:"error,omitempty"`
+}
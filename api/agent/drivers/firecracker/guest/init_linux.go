@@ -0,0 +1,375 @@
+package guest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// defaultPath is the PATH of fns whose env has none
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// rndReseedCRNG is the ioctl of /dev/urandom reseeding the random number generator of the
+// kernel from its entropy pool
+const rndReseedCRNG = 0x5207
+
+// Init is the init of a microVM, process 1 of the guest. It mounts the image drive and
+// waits for the driver to send the Config of the fn, then runs the fn until the driver
+// stops the microVM. It only returns if the microVM cannot run the fn.
+func Init() error {
+	if err := mountInit(); err != nil {
+		return err
+	}
+
+	ports := []uint32{ConfigPort, StdinPort, StdoutPort, StderrPort, ListenerPort}
+	listeners := make(map[uint32]*vsockListener, len(ports))
+	for _, port := range ports {
+		l, err := listenVsock(port)
+		if err != nil {
+			return fmt.Errorf("cannot listen on vsock port %d: %v", port, err)
+		}
+		listeners[port] = l
+	}
+	if err := ready(); err != nil {
+		return err
+	}
+
+	conn, err := listeners[ConfigPort].Accept()
+	if err != nil {
+		return err
+	}
+	var config Config
+	if err := json.NewDecoder(conn).Decode(&config); err != nil {
+		return err
+	}
+	status := &statusWriter{enc: json.NewEncoder(conn)}
+	if err := run(&config, listeners, status); err != nil {
+		status.send(Status{Error: err.Error()})
+	}
+
+	// process 1 must not exit, the driver stops the microVM
+	for {
+		time.Sleep(time.Hour)
+	}
+}
+
+// mnt is a mount of the guest
+type mnt struct {
+	source, target, fstype string
+	flags                  uintptr
+	data                   string
+}
+
+// mountInit mounts the file systems of the init drive and the image drive
+func mountInit() error {
+	mounts := []mnt{
+		{"proc", "/proc", "proc", unix.MS_NOSUID | unix.MS_NODEV, ""},
+		{"sysfs", "/sys", "sysfs", unix.MS_NOSUID | unix.MS_NODEV, ""},
+		{"devtmpfs", "/dev", "devtmpfs", unix.MS_NOSUID, ""},
+		{ImageDevice, ImageDir, "ext4", unix.MS_RDONLY, ""},
+	}
+	for _, m := range mounts {
+		// the kernel mounts devtmpfs itself if configured to
+		if err := unix.Mount(m.source, m.target, m.fstype, m.flags, m.data); err != nil && err != unix.EBUSY {
+			return fmt.Errorf("cannot mount %s at %s: %v", m.source, m.target, err)
+		}
+	}
+	return nil
+}
+
+// ready tells the driver the guest listens on its ports
+func ready() error {
+	conn, err := dialVsock(ReadyPort)
+	if err != nil {
+		return fmt.Errorf("cannot connect to vsock port %d of the host: %v", ReadyPort, err)
+	}
+	return conn.Close()
+}
+
+// statusWriter sends the statuses of the fn to the driver
+type statusWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (w *statusWriter) send(s Status) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.enc.Encode(s)
+}
+
+// run runs the process of the fn of config with the connections of listeners as stdio
+func run(config *Config, listeners map[uint32]*vsockListener, status *statusWriter) error {
+	if len(config.Args) == 0 {
+		return errors.New("no command specified")
+	}
+	seed(config)
+	if err := mountRoot(config); err != nil {
+		return err
+	}
+	if err := unix.Sethostname([]byte(config.Hostname)); err != nil {
+		return err
+	}
+	for _, l := range config.Rlimits {
+		// the process inherits the limits of init, which needs few resources
+		if err := syscall.Setrlimit(l.Resource, &syscall.Rlimit{Cur: l.Value, Max: l.Value}); err != nil {
+			return fmt.Errorf("cannot set resource limit %d: %v", l.Resource, err)
+		}
+	}
+
+	var stdio [3]*vsockConn
+	for i, port := range []uint32{StdinPort, StdoutPort, StderrPort} {
+		conn, err := listeners[port].Accept()
+		if err != nil {
+			return err
+		}
+		stdio[i] = conn
+	}
+
+	path, err := lookPath(RootDir, config.Args[0], config.Env)
+	if err != nil {
+		return err
+	}
+	cmd := &exec.Cmd{
+		Path:   path,
+		Args:   config.Args,
+		Env:    config.Env,
+		Dir:    config.Dir,
+		Stdout: stdio[1],
+		Stderr: stdio[2],
+		SysProcAttr: &syscall.SysProcAttr{
+			Chroot:     RootDir,
+			Credential: &syscall.Credential{Uid: config.UID, Gid: config.GID, Groups: []uint32{}},
+			Setsid:     true,
+		},
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	ooms := oomKills()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		// the process may exit before its stdin is closed by the host
+		io.Copy(stdin, stdio[0])
+		stdin.Close()
+	}()
+	go serveListener(config, listeners[ListenerPort], status)
+
+	exitCode := 0
+	if err := cmd.Wait(); err != nil {
+		exitCode = -1
+		if exit, ok := err.(*exec.ExitError); ok {
+			if ws, ok := exit.Sys().(syscall.WaitStatus); ok {
+				exitCode = ws.ExitStatus()
+				if ws.Signaled() {
+					exitCode = 128 + int(ws.Signal())
+				}
+			}
+		}
+	}
+	stdio[1].Close()
+	stdio[2].Close()
+	status.send(Status{Exited: true, ExitCode: exitCode, OOM: oomKills() > ooms})
+	return nil
+}
+
+// seed sets the clock of the guest to the time of the host and adds the seed of the host
+// to the entropy of the kernel, the ones of microVMs restored from a snapshot are the
+// ones of the snapshot
+func seed(config *Config) {
+	if config.Time != 0 {
+		tv := syscall.NsecToTimeval(config.Time)
+		syscall.Settimeofday(&tv)
+	}
+	if len(config.Seed) == 0 {
+		return
+	}
+	f, err := os.OpenFile("/dev/urandom", os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(config.Seed)
+	unix.IoctlSetInt(int(f.Fd()), rndReseedCRNG, 0)
+}
+
+// mountRoot mounts the root file system of the fn at RootDir, an overlay of the image
+// with its writes in memory, and its /proc, /sys, /dev and /tmp
+func mountRoot(config *Config) error {
+	var rw string
+	if config.RootFsSize != 0 {
+		rw = fmt.Sprintf("size=%dm", config.RootFsSize)
+	}
+	if err := unix.Mount("tmpfs", RWDir, "tmpfs", 0, rw); err != nil {
+		return err
+	}
+	upper, work := filepath.Join(RWDir, "upper"), filepath.Join(RWDir, "work")
+	for _, dir := range []string{upper, work} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return err
+		}
+	}
+	overlay := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", ImageDir, upper, work)
+	if err := unix.Mount("overlay", RootDir, "overlay", 0, overlay); err != nil {
+		return err
+	}
+
+	files := map[string]string{"/etc/hosts": config.Hosts, "/etc/resolv.conf": config.Resolv}
+	for name, content := range files {
+		if content == "" {
+			continue
+		}
+		path := filepath.Join(RootDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		// the files of images may be links out of the root file system
+		os.Remove(path)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	mounts := []mnt{
+		{"proc", "/proc", "proc", unix.MS_NOSUID | unix.MS_NODEV, ""},
+		{"sysfs", "/sys", "sysfs", unix.MS_NOSUID | unix.MS_NODEV | unix.MS_RDONLY, ""},
+		{"/dev", "/dev", "", unix.MS_BIND | unix.MS_REC, ""},
+	}
+	if config.ReadOnlyRootFs || config.TmpFsSize != 0 {
+		tmp := "mode=1777"
+		if config.TmpFsSize != 0 {
+			tmp += fmt.Sprintf(",size=%dm", config.TmpFsSize)
+			if config.TmpFsInodes != 0 {
+				tmp += fmt.Sprintf(",nr_inodes=%d", config.TmpFsInodes)
+			}
+		}
+		mounts = append(mounts, mnt{"tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID | unix.MS_NODEV, tmp})
+	}
+	for _, m := range mounts {
+		target := filepath.Join(RootDir, m.target)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		if err := unix.Mount(m.source, target, m.fstype, m.flags, m.data); err != nil {
+			return fmt.Errorf("cannot mount %s at %s: %v", m.source, target, err)
+		}
+	}
+
+	if config.IOFS != "" {
+		iofs := filepath.Join(RootDir, config.IOFS)
+		if err := os.MkdirAll(iofs, 0755); err != nil {
+			return err
+		}
+		if err := os.Chown(iofs, int(config.UID), int(config.GID)); err != nil {
+			return err
+		}
+	}
+
+	if config.ReadOnlyRootFs {
+		return unix.Mount("overlay", RootDir, "overlay", unix.MS_REMOUNT|unix.MS_RDONLY, "")
+	}
+	return nil
+}
+
+// lookPath returns the path in the root file system at root of the executable file, in
+// the PATH of env if it has no slash, like exec.LookPath
+func lookPath(root, file string, env []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	path := defaultPath
+	for _, e := range env {
+		if strings.HasPrefix(e, "PATH=") {
+			path = strings.TrimPrefix(e, "PATH=")
+		}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		p := filepath.Join("/", dir, file)
+		// links are resolved in the root file system by the kernel
+		fi, err := os.Lstat(filepath.Join(root, p))
+		if err != nil {
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 || (fi.Mode().IsRegular() && fi.Mode()&0111 != 0) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("executable file %s not found in $PATH", file)
+}
+
+// oomKills returns the number of processes the kernel killed for lack of memory
+func oomKills() uint64 {
+	b, err := ioutil.ReadFile("/proc/vmstat")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "oom_kill" {
+			n, _ := strconv.ParseUint(f[1], 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+// serveListener waits for the fn of config to listen on its socket, tells the driver,
+// then proxies the connections of l to the socket
+func serveListener(config *Config, l *vsockListener, status *statusWriter) {
+	if config.IOFS == "" {
+		return
+	}
+	path := filepath.Join(RootDir, config.IOFS, Listener)
+	for {
+		// fns may link their socket to it once it listens, stat follows the link
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	status.send(Status{Listening: true})
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go proxy(conn, path)
+	}
+}
+
+// proxy copies conn to and from the unix socket at path until both are closed
+func proxy(conn *vsockConn, path string) {
+	defer conn.Close()
+	fn, err := net.Dial("unix", path)
+	if err != nil {
+		return
+	}
+	defer fn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(fn, conn)
+		fn.(*net.UnixConn).CloseWrite()
+		close(done)
+	}()
+	io.Copy(conn, fn)
+	conn.CloseWrite()
+	<-done
+}
@@ -0,0 +1,55 @@
+package guest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for _, dir := range []string{"bin", "usr/bin", "app"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, mode := range map[string]os.FileMode{"usr/bin/fn": 0755, "bin/fn": 0644, "app/fn": 0700} {
+		if err := ioutil.WriteFile(filepath.Join(root, path), nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// links are resolved in the guest, not in root
+	if err := os.Symlink("/usr/bin/fn", filepath.Join(root, "bin", "sh")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		file     string
+		env      []string
+		expected string
+	}{
+		{"fn", nil, "/usr/bin/fn"},
+		{"sh", nil, "/bin/sh"},
+		{"fn", []string{"PATH=/bin:/app"}, "/app/fn"},
+		{"./fn", []string{"PATH=/bin"}, "./fn"},
+		{"/missing", nil, "/missing"},
+		{"missing", nil, ""},
+		{"fn", []string{"PATH=/bin"}, ""},
+	} {
+		path, err := lookPath(root, tc.file, tc.env)
+		if tc.expected == "" {
+			if err == nil {
+				t.Errorf("lookPath of %s with %v: expected an error, got %s", tc.file, tc.env, path)
+			}
+			continue
+		}
+		if err != nil || path != tc.expected {
+			t.Errorf("lookPath of %s with %v: expected %s, got %s: %v", tc.file, tc.env, tc.expected, path, err)
+		}
+	}
+}
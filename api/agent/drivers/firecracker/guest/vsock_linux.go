@@ -0,0 +1,73 @@
+package guest
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// vsockListener listens on a vsock port of the guest
+type vsockListener struct {
+	fd int
+}
+
+// vsockConn is a connection of vsock
+type vsockConn struct {
+	*os.File
+}
+
+// CloseWrite shuts down the writing side of the connection
+func (c *vsockConn) CloseWrite() error {
+	return unix.Shutdown(int(c.Fd()), unix.SHUT_WR)
+}
+
+// listenVsock listens on port of vsock of the guest for connections of the host
+func listenVsock(port uint32) (*vsockListener, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrVM{CID: unix.VMADDR_CID_ANY, Port: port}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &vsockListener{fd: fd}, nil
+}
+
+// Accept waits for the next connection of the listener. Listeners are not reset when the
+// microVM is restored from a snapshot, unlike connections.
+func (l *vsockListener) Accept() (*vsockConn, error) {
+	for {
+		fd, _, err := unix.Accept4(l.fd, unix.SOCK_CLOEXEC)
+		if err == unix.EINTR || err == unix.ECONNABORTED {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &vsockConn{os.NewFile(uintptr(fd), "vsock")}, nil
+	}
+}
+
+// dialVsock connects to port of vsock of the host
+func dialVsock(port uint32) (*vsockConn, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err = unix.Connect(fd, &unix.SockaddrVM{CID: unix.VMADDR_CID_HOST, Port: port})
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &vsockConn{os.NewFile(uintptr(fd), "vsock")}, nil
+}
@@ -0,0 +1,233 @@
+package firecracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	firecracker "github.com/firecracker-microvm/firecracker-go-sdk"
+	fcmodels "github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+	"github.com/fnproject/fn/api/agent/drivers/firecracker/guest"
+	"github.com/fnproject/fn/api/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Files of the dir of a microVM, relative to it so that snapshots of microVMs, which
+// keep the paths of drives and vsock, are restored in the dirs of others
+const (
+	apiSocket   = "api.sock"
+	vsockSocket = "v.sock"
+	initDrive   = "init.ext4"
+	imageDrive  = "image.ext4"
+)
+
+const (
+	// guestCID is the vsock context id of guests, each microVM has a vsock of its own
+	guestCID = 3
+	// startTimeout bounds the start of the API of firecracker
+	startTimeout = 5 * time.Second
+)
+
+var errMachineExited = errors.New("firecracker exited")
+
+// machine is the firecracker process of a microVM, which runs in the dir of the microVM
+type machine struct {
+	dir    string
+	cancel func()
+	exited chan struct{}
+	output io.Closer
+
+	// client of the endpoints of the API of firecracker in the SDK, api of the others
+	client *firecracker.Client
+	api    *http.Client
+}
+
+// startMachine starts firecracker for the microVM id, with the drive of image
+func (drv *FirecrackerDriver) startMachine(ctx context.Context, id, image string) (*machine, error) {
+	log := common.Logger(ctx).WithFields(logrus.Fields{"call_id": id})
+
+	dir := filepath.Join(drv.dir, vmsDir, id)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	for link, target := range map[string]string{initDrive: drv.initDrive, imageDrive: image} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+
+	// the console of the guest and the logs of firecracker
+	output := log.WriterLevel(logrus.DebugLevel)
+	mctx, cancel := context.WithCancel(context.Background())
+	cmd := firecracker.VMCommandBuilder{}.
+		WithBin(drv.bin).
+		WithSocketPath(apiSocket).
+		WithStdout(output).
+		WithStderr(output).
+		Build(mctx)
+	cmd.Dir = dir
+	// firecracker does not outlive the agent
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		output.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	socket := filepath.Join(dir, apiSocket)
+	m := &machine{
+		dir:    dir,
+		cancel: cancel,
+		exited: make(chan struct{}),
+		output: output,
+		client: firecracker.NewClient(socket, log, false),
+		api: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}},
+	}
+	go func() {
+		cmd.Wait()
+		close(m.exited)
+	}()
+
+	if err := m.waitAPI(ctx); err != nil {
+		m.stop()
+		return nil, err
+	}
+	return m, nil
+}
+
+// waitAPI waits for the socket of the API of firecracker
+func (m *machine) waitAPI(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+	for {
+		if conn, err := net.Dial("unix", filepath.Join(m.dir, apiSocket)); err == nil {
+			return conn.Close()
+		}
+		select {
+		case <-m.exited:
+			return errMachineExited
+		case <-ctx.Done():
+			return fmt.Errorf("firecracker did not start: %v", ctx.Err())
+		case <-time.After(dialInterval):
+		}
+	}
+}
+
+// boot boots the kernel of the driver in the microVM with vcpus and memory in MiB
+func (m *machine) boot(ctx context.Context, drv *FirecrackerDriver, vcpus, memory int64) error {
+	if _, err := m.client.PutMachineConfiguration(ctx, &fcmodels.MachineConfiguration{VcpuCount: vcpus, MemSizeMib: memory}); err != nil {
+		return err
+	}
+	kernel := drv.kernel
+	args := drv.kernelArgs + " root=/dev/vda ro rootfstype=ext4 init=" + guest.InitPath
+	if _, err := m.client.PutGuestBootSource(ctx, &fcmodels.BootSource{KernelImagePath: &kernel, BootArgs: args}); err != nil {
+		return err
+	}
+	if err := m.drive(ctx, "init", initDrive, true); err != nil {
+		return err
+	}
+	if err := m.drive(ctx, "image", imageDrive, false); err != nil {
+		return err
+	}
+	// the vsock endpoint of the SDK has the id of former versions of firecracker
+	if err := m.request(ctx, http.MethodPut, "/vsock", map[string]interface{}{"guest_cid": guestCID, "uds_path": vsockSocket}); err != nil {
+		return err
+	}
+	_, err := m.client.CreateSyncAction(ctx, &fcmodels.InstanceActionInfo{ActionType: fcmodels.InstanceActionInfoActionTypeInstanceStart})
+	return err
+}
+
+// drive attaches the read only drive id of the file path to the microVM
+func (m *machine) drive(ctx context.Context, id, path string, root bool) error {
+	ro := true
+	_, err := m.client.PutGuestDriveByID(ctx, id, &fcmodels.Drive{DriveID: &id, PathOnHost: &path, IsRootDevice: &root, IsReadOnly: &ro})
+	return err
+}
+
+// pause pauses the vCPUs of the microVM
+func (m *machine) pause(ctx context.Context) error {
+	return m.request(ctx, http.MethodPatch, "/vm", map[string]string{"state": "Paused"})
+}
+
+// resume resumes the vCPUs of the paused microVM
+func (m *machine) resume(ctx context.Context) error {
+	return m.request(ctx, http.MethodPatch, "/vm", map[string]string{"state": "Resumed"})
+}
+
+// snapshot takes a full snapshot of the paused microVM to the files state and memory
+func (m *machine) snapshot(ctx context.Context, state, memory string) error {
+	return m.request(ctx, http.MethodPut, "/snapshot/create", map[string]string{
+		"snapshot_type": "Full",
+		"snapshot_path": state,
+		"mem_file_path": memory,
+	})
+}
+
+// load restores the microVM from the snapshot of the files state and memory, resumed
+func (m *machine) load(ctx context.Context, state, memory string) error {
+	return m.request(ctx, http.MethodPut, "/snapshot/load", map[string]interface{}{
+		"snapshot_path": state,
+		"mem_backend":   map[string]string{"backend_type": "File", "backend_path": memory},
+		"resume_vm":     true,
+	})
+}
+
+// request sends body to the endpoint path of the API of firecracker with method
+func (m *machine) request(ctx context.Context, method, path string, body interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, "http://localhost"+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.api.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	var fault struct {
+		Message string `json:"fault_message"`
+	}
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if json.Unmarshal(msg, &fault) == nil && fault.Message != "" {
+		msg = []byte(fault.Message)
+	}
+	return fmt.Errorf("firecracker %s %s: %s", method, path, msg)
+}
+
+// vsock returns the path of the unix socket of the vsock of the microVM
+func (m *machine) vsock() string {
+	return filepath.Join(m.dir, vsockSocket)
+}
+
+// stop kills firecracker and removes the dir of the microVM
+func (m *machine) stop() {
+	m.cancel()
+	<-m.exited
+	m.api.CloseIdleConnections()
+	m.output.Close()
+	os.RemoveAll(m.dir)
+}
@@ -0,0 +1,150 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/common"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// blockSize is the block size of the ext4 drives of the driver
+	blockSize = 4096
+	// minFsSize is the room in bytes of the metadata and journal of ext4 on top of files
+	minFsSize = 16 * 1024 * 1024
+)
+
+// imageDrive returns the path of the ext4 drive of the root file system of the image
+// with digest of the cookie of containerd image, which is built the first time
+func (drv *FirecrackerDriver) imageDrive(ctx context.Context, image drivers.Cookie, dgst digest.Digest) (string, error) {
+	path := filepath.Join(drv.dir, imagesDir, dgst.Encoded()+".ext4")
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		drv.mu.Lock()
+		done, building := drv.building[dgst]
+		if !building {
+			done = make(chan struct{})
+			drv.building[dgst] = done
+		}
+		drv.mu.Unlock()
+
+		if building {
+			// the drive is built or, if it failed, built again by a waiting cookie
+			select {
+			case <-done:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+
+		err := drv.buildImageDrive(ctx, image, path)
+		drv.mu.Lock()
+		delete(drv.building, dgst)
+		close(done)
+		drv.mu.Unlock()
+		return path, err
+	}
+}
+
+// buildImageDrive builds the drive at path from a view of the root file system of the
+// image of the cookie of containerd image
+func (drv *FirecrackerDriver) buildImageDrive(ctx context.Context, image drivers.Cookie, path string) error {
+	ctx, log := common.LoggerWithFields(ctx, logrus.Fields{"stack": "buildImageDrive", "drive": path})
+
+	dir, err := ioutil.TempDir(filepath.Join(drv.dir, tmpDir), "rootfs-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dir)
+
+	key := "fn-firecracker-" + filepath.Base(dir)
+	mounts, err := drv.images.View(ctx, image, key)
+	if err != nil {
+		return err
+	}
+	defer drv.images.RemoveView(common.BackgroundContext(ctx), key)
+
+	if err := mount.All(mounts, dir); err != nil {
+		return err
+	}
+	defer func() {
+		if err := mount.UnmountAll(dir, 0); err != nil {
+			log.WithError(err).Error("cannot unmount image view")
+		}
+	}()
+
+	log.Info("building firecracker image drive")
+	return mkfs(ctx, dir, path)
+}
+
+// mkfs creates the ext4 file system of the drive at path with the files of dir
+func mkfs(ctx context.Context, dir, path string) error {
+	size, inodes, err := fsSize(dir)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		var out []byte
+		cmd := exec.CommandContext(ctx, "mkfs.ext4", "-q", "-F", "-b", strconv.Itoa(blockSize), "-N", strconv.FormatInt(inodes, 10), "-d", dir, tmp)
+		if out, err = cmd.CombinedOutput(); err != nil {
+			err = fmt.Errorf("mkfs.ext4 of %s failed: %v: %s", dir, err, out)
+		}
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// fsSize returns the size in bytes and the number of inodes of an ext4 file system with
+// room for the files of dir
+func fsSize(dir string) (size, inodes int64, err error) {
+	err = filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		inodes++
+		blocks := int64(1)
+		if fi.Mode().IsRegular() {
+			blocks = (fi.Size() + blockSize - 1) / blockSize
+		}
+		size += blocks * blockSize
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	inodes += inodes/4 + 1024
+	// the inode tables take 256 bytes per inode
+	size += size/4 + inodes*256 + minFsSize
+	size = (size + blockSize - 1) / blockSize * blockSize
+	return size, inodes, nil
+}
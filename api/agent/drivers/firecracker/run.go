@@ -0,0 +1,225 @@
+package firecracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/agent/drivers/firecracker/guest"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	"github.com/sirupsen/logrus"
+)
+
+var errGuestExited = models.NewAPIError(http.StatusBadGateway, errors.New("microVM exited"))
+
+// run sends the config of the fn of the cookie to the guest of its microVM, which starts
+// it with the input and logger of its task as stdio
+func (c *cookie) run(ctx context.Context) (drivers.WaitResult, error) {
+	log := common.Logger(ctx).WithFields(logrus.Fields{"call_id": c.task.Id()})
+	if c.machine == nil {
+		log.Fatal("invalid usage: microVM not created")
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, startTimeout)
+	defer cancel()
+	var conns []*net.UnixConn
+	for _, port := range []uint32{guest.StdinPort, guest.StdoutPort, guest.StderrPort, guest.ConfigPort} {
+		conn, err := dialVsock(dctx, c.machine.vsock(), port)
+		if err != nil {
+			log.WithError(err).Error("error connecting to microVM")
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	stdin, stdout, stderr, config := conns[0], conns[1], conns[2], conns[3]
+
+	if err := json.NewEncoder(config).Encode(c.config); err != nil {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		return nil, err
+	}
+
+	go func() {
+		io.Copy(stdin, c.task.Input())
+		stdin.CloseWrite()
+	}()
+	w := &waitResult{
+		cookie: c,
+		conns:  conns,
+		exit:   make(chan guest.Status, 1),
+	}
+	taskStdout, taskStderr := c.task.Logger()
+	w.output.Add(2)
+	go func() {
+		defer w.output.Done()
+		io.Copy(taskStdout, stdout)
+	}()
+	go func() {
+		defer w.output.Done()
+		io.Copy(taskStderr, stderr)
+	}()
+	go c.readStatus(ctx, config, w.exit)
+	return w, nil
+}
+
+// readStatus reads the statuses of the fn from the guest on conn until it exits, it
+// proxies the socket of the fn from the host once the fn listens
+func (c *cookie) readStatus(ctx context.Context, conn net.Conn, exit chan<- guest.Status) {
+	dec := json.NewDecoder(conn)
+	for {
+		var status guest.Status
+		if err := dec.Decode(&status); err != nil {
+			// the guest is gone
+			exit <- guest.Status{Error: errGuestExited.Error()}
+			return
+		}
+		if !status.Listening {
+			exit <- status
+			return
+		}
+		if err := c.listen(ctx); err != nil {
+			exit <- guest.Status{Error: err.Error()}
+			return
+		}
+	}
+}
+
+// listen creates the socket of the fn on the host, see listenProxy
+func (c *cookie) listen(ctx context.Context) error {
+	path := c.iofsPath()
+	if path == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listener != nil {
+		return nil
+	}
+	l, err := listenProxy(ctx, path, c.machine)
+	if err != nil {
+		return fmt.Errorf("cannot listen on %s: %v", path, err)
+	}
+	c.listener = l
+	return nil
+}
+
+// waitResult implements drivers.WaitResult
+type waitResult struct {
+	cookie *cookie
+	conns  []*net.UnixConn
+	exit   chan guest.Status
+	// output is done once the stdout and stderr of the fn are copied
+	output sync.WaitGroup
+}
+
+// waitResult implements drivers.WaitResult
+func (w *waitResult) Wait(ctx context.Context) drivers.RunResult {
+	defer func() {
+		for _, conn := range w.conns {
+			conn.Close()
+		}
+	}()
+
+	status, err := w.wait(ctx)
+	return &runResult{
+		status: status,
+		err:    err,
+	}
+}
+
+func (w *waitResult) wait(ctx context.Context) (status string, err error) {
+	m := w.cookie.machine
+	select {
+	case <-ctx.Done():
+		// the microVM is stopped now, Close removes it
+		m.cancel()
+		switch ctx.Err() {
+		case context.DeadlineExceeded:
+			return drivers.StatusTimeout, context.DeadlineExceeded
+		default:
+			return drivers.StatusCancelled, context.Canceled
+		}
+	case <-m.exited:
+		return drivers.StatusError, errGuestExited
+	case st := <-w.exit:
+		w.output.Wait()
+
+		if st.Error != "" {
+			return drivers.StatusError, models.NewAPIError(http.StatusBadGateway, errors.New(st.Error))
+		}
+		switch {
+		case st.ExitCode == 0:
+			return drivers.StatusSuccess, nil
+		case st.OOM:
+			memory := uint64(w.cookie.memory - memoryOverhead)
+			common.Logger(ctx).WithFields(logrus.Fields{"call_id": w.cookie.task.Id(), "memory": memory}).Error("firecracker oom")
+			return drivers.StatusKilled, models.NewFuncOOMError(memory, memory)
+		case st.ExitCode == 137: // SIGKILL
+			return drivers.StatusKilled, models.NewAPIError(http.StatusBadGateway, fmt.Errorf("container exit code %d", st.ExitCode))
+		default:
+			return drivers.StatusError, models.NewAPIError(http.StatusBadGateway, fmt.Errorf("container exit code %d", st.ExitCode))
+		}
+	}
+}
+
+type runResult struct {
+	err    error
+	status string
+}
+
+func (r *runResult) Error() error   { return r.err }
+func (r *runResult) Status() string { return r.status }
+
+// listenProxy listens on the unix socket at path, the socket of the fn the agent waits
+// for in its iofs, and proxies its connections to the socket of the fn in the guest of m
+func listenProxy(ctx context.Context, path string, m *machine) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go proxy(ctx, conn.(*net.UnixConn), m)
+		}
+	}()
+	return l, nil
+}
+
+// proxy copies conn to and from a connection to the socket of the fn in the guest of m
+// until both are closed
+func proxy(ctx context.Context, conn *net.UnixConn, m *machine) {
+	defer conn.Close()
+	dctx, cancel := context.WithTimeout(common.BackgroundContext(ctx), startTimeout)
+	fn, err := dialVsock(dctx, m.vsock(), guest.ListenerPort)
+	cancel()
+	if err != nil {
+		common.Logger(ctx).WithError(err).Error("cannot connect to the socket of the fn")
+		return
+	}
+	defer fn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(fn, conn)
+		fn.CloseWrite()
+		close(done)
+	}()
+	io.Copy(conn, fn)
+	conn.CloseWrite()
+	<-done
+}
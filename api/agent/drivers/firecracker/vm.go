@@ -0,0 +1,140 @@
+package firecracker
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fnproject/fn/api/agent/drivers/firecracker/guest"
+	"github.com/fnproject/fn/api/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Files of the dirs of snapshots
+const (
+	stateFile  = "state"
+	memoryFile = "memory"
+)
+
+// bootTimeout bounds the boot of microVMs, until their guest is up
+const bootTimeout = 30 * time.Second
+
+// startVM starts the microVM id with the drive image, vcpus and memory in MiB, restored
+// from the snapshot of the microVMs booted with the same ones if any. Else it is booted,
+// and snapshotted for the next microVMs if it is the first one.
+func (drv *FirecrackerDriver) startVM(ctx context.Context, id, image string, vcpus, memory int64) (*machine, error) {
+	key := drv.snapshotKey(image, vcpus, memory)
+	log := common.Logger(ctx).WithFields(logrus.Fields{"call_id": id, "snapshot": key})
+	snapshot := filepath.Join(drv.dir, snapshotsDir, key)
+
+	if drv.snapshots {
+		if _, err := os.Stat(snapshot); err == nil {
+			m, err := drv.restore(ctx, id, image, snapshot)
+			if err == nil {
+				return m, nil
+			}
+			// boot it instead, the snapshot may have been taken with another CPU or
+			// firecracker, and do not take another one
+			log.WithError(err).Info("cannot restore firecracker snapshot")
+			drv.snapshotted.Store(key, struct{}{})
+			os.RemoveAll(snapshot)
+		}
+	}
+
+	m, err := drv.startMachine(ctx, id, image)
+	if err != nil {
+		return nil, err
+	}
+	ready, err := listenVsock(m.vsock(), guest.ReadyPort)
+	if err != nil {
+		m.stop()
+		return nil, err
+	}
+	defer ready.Close()
+	if err := m.boot(ctx, drv, vcpus, memory); err != nil {
+		m.stop()
+		return nil, err
+	}
+	if err := m.waitReady(ctx, ready); err != nil {
+		m.stop()
+		return nil, err
+	}
+
+	if drv.snapshots {
+		if _, loaded := drv.snapshotted.LoadOrStore(key, struct{}{}); !loaded {
+			if err := drv.takeSnapshot(ctx, m, snapshot); err != nil {
+				log.WithError(err).Error("cannot take firecracker snapshot")
+			} else {
+				log.Info("firecracker snapshot")
+			}
+		}
+	}
+	return m, nil
+}
+
+// restore starts the microVM id with the drive image from the snapshot in the dir
+// snapshot
+func (drv *FirecrackerDriver) restore(ctx context.Context, id, image, snapshot string) (*machine, error) {
+	m, err := drv.startMachine(ctx, id, image)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.load(ctx, filepath.Join(snapshot, stateFile), filepath.Join(snapshot, memoryFile)); err != nil {
+		m.stop()
+		return nil, err
+	}
+	return m, nil
+}
+
+// takeSnapshot snapshots the microVM m to the dir snapshot, it is paused meanwhile
+func (drv *FirecrackerDriver) takeSnapshot(ctx context.Context, m *machine, snapshot string) error {
+	tmp, err := ioutil.TempDir(filepath.Join(drv.dir, tmpDir), "snapshot-")
+	if err != nil {
+		return err
+	}
+	if err = m.pause(ctx); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	err = m.snapshot(ctx, filepath.Join(tmp, stateFile), filepath.Join(tmp, memoryFile))
+	if rerr := m.resume(ctx); err == nil {
+		err = rerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, snapshot)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+	}
+	return err
+}
+
+// waitReady waits for the guest of the booted microVM to connect to ready, once it is up
+func (m *machine) waitReady(ctx context.Context, ready *net.UnixListener) error {
+	ctx, cancel := context.WithTimeout(ctx, bootTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-m.exited:
+		}
+		ready.Close()
+	}()
+
+	conn, err := ready.Accept()
+	if err != nil {
+		select {
+		case <-m.exited:
+			return errMachineExited
+		default:
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return conn.Close()
+}
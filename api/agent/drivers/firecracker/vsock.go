@@ -0,0 +1,81 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// dialInterval is how often connections to the vsock ports of guests are tried until they
+// listen
+const dialInterval = 5 * time.Millisecond
+
+// maxVsockReply bounds the reply of firecracker to CONNECT
+const maxVsockReply = 64
+
+// dialVsock connects to port of the guest of the vsock of firecracker at path: it is a
+// unix socket the host connects to and writes CONNECT <port>, firecracker replies with OK
+// <host port> once connected to the guest. It retries until ctx is done as the guest may
+// not listen yet.
+func dialVsock(ctx context.Context, path string, port uint32) (*net.UnixConn, error) {
+	for {
+		conn, err := connectVsock(ctx, path, port)
+		if err == nil {
+			return conn, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("cannot connect to vsock port %d of the guest: %v", port, err)
+		case <-time.After(dialInterval):
+		}
+	}
+}
+
+// connectVsock tries once to connect to port of the guest, see dialVsock
+func connectVsock(ctx context.Context, path string, port uint32) (*net.UnixConn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*net.UnixConn)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", port); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// the reply is read a byte at a time, the data of the guest follows it
+	var reply strings.Builder
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if reply.Len() > maxVsockReply {
+			conn.Close()
+			return nil, fmt.Errorf("unexpected vsock reply %q", reply.String())
+		}
+		reply.WriteByte(b[0])
+	}
+	if !strings.HasPrefix(reply.String(), "OK ") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected vsock reply %q", reply.String())
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// listenVsock returns the listener of the connections of the guest to port of the host,
+// which firecracker connects to the unix socket <path of the vsock>_<port>
+func listenVsock(path string, port uint32) (*net.UnixListener, error) {
+	return net.ListenUnix("unix", &net.UnixAddr{Name: fmt.Sprintf("%s_%d", path, port), Net: "unix"})
+}
@@ -13,7 +13,7 @@ import (
 // Agent hot containers register themselves to the evictor system.
 // A starved request can call PerformEviction() to scan the evictable
 // hot containers and if a number of these can be evicted to satisfy
-// memory+cpu needs of the starved request, then those hot-containers
+// memory+cpu+gpu needs of the starved request, then those hot-containers
 // are evicted.
 
 type tokenKey struct {
@@ -21,6 +21,7 @@ type tokenKey struct {
 	slotId string
 	memory uint64
 	cpu    uint64
+	gpu    uint64
 }
 
 type EvictToken struct {
@@ -33,15 +34,15 @@ type EvictToken struct {
 type Evictor interface {
 	// CreateEvictToken creates an eviction token to be used in evictor tracking. Returns
 	// an eviction token.
-	CreateEvictToken(slotId string, mem, cpu uint64) *EvictToken
+	CreateEvictToken(slotId string, mem, cpu, gpu uint64) *EvictToken
 
 	// DeleteEvictToken deletes an eviction token from evictor system
 	DeleteEvictToken(token *EvictToken)
 
-	// PerformEviction performs evictions to satisfy cpu & mem arguments
+	// PerformEviction performs evictions to satisfy cpu, mem & gpu arguments
 	// and returns a slice of channels for evictions performed. The callers
 	// can wait on these channel to ensure evictions are completed.
-	PerformEviction(slotId string, mem, cpu uint64) []chan struct{}
+	PerformEviction(slotId string, mem, cpu, gpu uint64) []chan struct{}
 }
 
 type evictor struct {
@@ -79,19 +80,20 @@ func (token *EvictToken) SetEvictable(isEvictable bool) {
 func (tok *EvictToken) isEligible() bool {
 	// if no resource limits are in place, then this
 	// function is not eligible.
-	if tok.key.memory == 0 && tok.key.cpu == 0 {
+	if tok.key.memory == 0 && tok.key.cpu == 0 && tok.key.gpu == 0 {
 		return false
 	}
 	return true
 }
 
-func (e *evictor) CreateEvictToken(slotId string, mem, cpu uint64) *EvictToken {
+func (e *evictor) CreateEvictToken(slotId string, mem, cpu, gpu uint64) *EvictToken {
 
 	key := tokenKey{
 		id:     id.New().String(),
 		slotId: slotId,
 		memory: mem,
 		cpu:    cpu,
+		gpu:    gpu,
 	}
 
 	token := &EvictToken{
@@ -139,19 +141,20 @@ func (e *evictor) DeleteEvictToken(token *EvictToken) {
 	close(token.DoneChan)
 }
 
-func (e *evictor) PerformEviction(slotId string, mem, cpu uint64) []chan struct{} {
+func (e *evictor) PerformEviction(slotId string, mem, cpu, gpu uint64) []chan struct{} {
 	var notifyChans []chan struct{}
 
 	// if no resources are defined for this function, then
 	// we don't know what to do here. We cannot evict anyone
 	// in this case.
-	if mem == 0 && cpu == 0 {
+	if mem == 0 && cpu == 0 && gpu == 0 {
 		return notifyChans
 	}
 
 	// Our eviction sum so far
 	totalMemory := uint64(0)
 	totalCpu := uint64(0)
+	totalGpu := uint64(0)
 	isSatisfied := false
 
 	var keys []string
@@ -169,12 +172,18 @@ func (e *evictor) PerformEviction(slotId string, mem, cpu uint64) []chan struct{
 			continue
 		}
 
+		// nor evict containers that free nothing still needed, eg. without gpus for gpus
+		if !(totalMemory < mem && val.memory > 0) && !(totalCpu < cpu && val.cpu > 0) && !(totalGpu < gpu && val.gpu > 0) {
+			continue
+		}
+
 		totalMemory += val.memory
 		totalCpu += val.cpu
+		totalGpu += val.gpu
 		keys = append(keys, val.id)
 
 		// did we satisfy the need?
-		if totalMemory >= mem && totalCpu >= cpu {
+		if totalMemory >= mem && totalCpu >= cpu && totalGpu >= gpu {
 			isSatisfied = true
 			break
 		}
@@ -15,19 +15,19 @@ func TestEvictorSimple01(t *testing.T) {
 	_, mem1, cpu1 := getACall(slotId, 1, 100)
 	_, mem2, cpu2 := getACall(slotId, 1, 100)
 
-	token1 := evictor.CreateEvictToken(slotId, mem1, cpu1)
-	token2 := evictor.CreateEvictToken(slotId, mem2, cpu2)
+	token1 := evictor.CreateEvictToken(slotId, mem1, cpu1, 0)
+	token2 := evictor.CreateEvictToken(slotId, mem2, cpu2, 0)
 
 	token1.SetEvictable(true)
 	token2.SetEvictable(true)
 
-	if len(evictor.PerformEviction(slotId, mem1, cpu1)) > 0 {
+	if len(evictor.PerformEviction(slotId, mem1, cpu1, 0)) > 0 {
 		t.Fatalf("We should not be able to self evict")
 	}
-	if len(evictor.PerformEviction("foo", 0, 0)) > 0 {
+	if len(evictor.PerformEviction("foo", 0, 0, 0)) > 0 {
 		t.Fatalf("We should not be able to evict: zero cpu/mem")
 	}
-	if len(evictor.PerformEviction("foo", 1, 300)) > 0 {
+	if len(evictor.PerformEviction("foo", 1, 300, 0)) > 0 {
 		t.Fatalf("We should not be able to evict (resource not enough)")
 	}
 
@@ -38,7 +38,7 @@ func TestEvictorSimple01(t *testing.T) {
 		t.Fatalf("should not be evicted")
 	}
 
-	if len(evictor.PerformEviction("foo", 1, 100)) != 1 {
+	if len(evictor.PerformEviction("foo", 1, 100, 0)) != 1 {
 		t.Fatalf("We should be able to evict")
 	}
 
@@ -53,14 +53,41 @@ func TestEvictorSimple01(t *testing.T) {
 	evictor.DeleteEvictToken(token2)
 }
 
+func TestEvictorGPU(t *testing.T) {
+	evictor := NewEvictor()
+
+	token1 := evictor.CreateEvictToken("slot1", 1, 100, 0)
+	token2 := evictor.CreateEvictToken("slot2", 1, 100, 2)
+
+	token1.SetEvictable(true)
+	token2.SetEvictable(true)
+
+	if len(evictor.PerformEviction("foo", 0, 0, 3)) > 0 {
+		t.Fatalf("We should not be able to evict (resource not enough)")
+	}
+	if len(evictor.PerformEviction("foo", 0, 0, 1)) != 1 {
+		t.Fatalf("We should be able to evict")
+	}
+
+	if token1.isEvicted() {
+		t.Fatalf("should not be evicted")
+	}
+	if !token2.isEvicted() {
+		t.Fatalf("should be evicted")
+	}
+
+	evictor.DeleteEvictToken(token1)
+	evictor.DeleteEvictToken(token2)
+}
+
 func TestEvictorSimple02(t *testing.T) {
 	evictor := NewEvictor()
 
 	slotId1, mem1, cpu1 := getACall("slot1", 1, 100)
 	slotId2, mem2, cpu2 := getACall("slot1", 1, 100)
 
-	token1 := evictor.CreateEvictToken(slotId1, mem1, cpu1)
-	token2 := evictor.CreateEvictToken(slotId2, mem2, cpu2)
+	token1 := evictor.CreateEvictToken(slotId1, mem1, cpu1, 0)
+	token2 := evictor.CreateEvictToken(slotId2, mem2, cpu2, 0)
 
 	// add/rm/add
 	token1.SetEvictable(true)
@@ -71,10 +98,10 @@ func TestEvictorSimple02(t *testing.T) {
 	token2.SetEvictable(true)
 	token2.SetEvictable(false)
 
-	if len(evictor.PerformEviction(slotId1, mem1, cpu1)) > 0 {
+	if len(evictor.PerformEviction(slotId1, mem1, cpu1, 0)) > 0 {
 		t.Fatalf("We should not be able to self evict")
 	}
-	if len(evictor.PerformEviction("foo", 0, 0)) > 0 {
+	if len(evictor.PerformEviction("foo", 0, 0, 0)) > 0 {
 		t.Fatalf("We should not be able to evict: zero cpu/mem")
 	}
 	if token1.isEvicted() {
@@ -86,7 +113,7 @@ func TestEvictorSimple02(t *testing.T) {
 	// not registered... but should be OK
 	token2.SetEvictable(false)
 
-	if len(evictor.PerformEviction("foo", mem1, cpu1)) > 0 {
+	if len(evictor.PerformEviction("foo", mem1, cpu1, 0)) > 0 {
 		t.Fatalf("We should not be able to evict (unregistered)")
 	}
 	if token1.isEvicted() {
@@ -107,17 +134,17 @@ func TestEvictorSimple03(t *testing.T) {
 	_, mem2, cpu2 := getACall(slotId, 1, 100)
 	_, mem3, cpu3 := getACall(slotId, 1, 100)
 
-	token0 := evictor.CreateEvictToken(slotId0, mem0, cpu0)
-	token1 := evictor.CreateEvictToken(slotId, mem1, cpu1)
-	token2 := evictor.CreateEvictToken(slotId, mem2, cpu2)
-	token3 := evictor.CreateEvictToken(slotId, mem3, cpu3)
+	token0 := evictor.CreateEvictToken(slotId0, mem0, cpu0, 0)
+	token1 := evictor.CreateEvictToken(slotId, mem1, cpu1, 0)
+	token2 := evictor.CreateEvictToken(slotId, mem2, cpu2, 0)
+	token3 := evictor.CreateEvictToken(slotId, mem3, cpu3, 0)
 
 	token0.SetEvictable(true)
 	token1.SetEvictable(true)
 	token2.SetEvictable(true)
 	token3.SetEvictable(true)
 
-	if len(evictor.PerformEviction(taboo, 1, 200)) == 0 {
+	if len(evictor.PerformEviction(taboo, 1, 200, 0)) == 0 {
 		t.Fatalf("We should be able to evict")
 	}
 
@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	runner "github.com/fnproject/fn/api/agent/grpc"
+)
+
+// DefaultExecutionTokenTTL is how long a pure runner remembers an execution token
+// after accepting its call
+const DefaultExecutionTokenTTL = 10 * time.Minute
+
+// execution is a call a pure runner accepted with an execution token. Engagements
+// sending the token again wait for its finish message instead of running the call twice.
+type execution struct {
+	expiry   time.Time
+	done     chan struct{}
+	once     sync.Once
+	finished *runner.CallFinished
+}
+
+// finish records the finish message sent for the call and wakes up the engagements
+// waiting for it
+func (e *execution) finish(msg *runner.CallFinished) {
+	e.once.Do(func() {
+		e.finished = msg
+		close(e.done)
+	})
+}
+
+// executionTokens tracks the execution tokens of the calls a pure runner accepted,
+// so that an LB retrying an engagement it is unsure about cannot run a call twice.
+// Tokens are forgotten after ttl; expired tokens are pruned at most every ttl.
+type executionTokens struct {
+	ttl time.Duration
+
+	mtx       sync.Mutex
+	tokens    map[string]*execution
+	nextPrune time.Time
+}
+
+func newExecutionTokens(ttl time.Duration) *executionTokens {
+	return &executionTokens{ttl: ttl, tokens: make(map[string]*execution)}
+}
+
+// claim returns the execution of the token, and false if the token was already
+// claimed and has not expired
+func (t *executionTokens) claim(token string) (*execution, bool) {
+	now := time.Now()
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if now.After(t.nextPrune) {
+		for tok, e := range t.tokens {
+			if now.After(e.expiry) {
+				delete(t.tokens, tok)
+			}
+		}
+		t.nextPrune = now.Add(t.ttl)
+	}
+
+	if e, ok := t.tokens[token]; ok && !now.After(e.expiry) {
+		return e, false
+	}
+	e := &execution{expiry: now.Add(t.ttl), done: make(chan struct{})}
+	t.tokens[token] = e
+	return e, true
+}
+
+// release forgets a token whose call was not run, eg. rejected as too busy
+func (t *executionTokens) release(token string) {
+	t.mtx.Lock()
+	delete(t.tokens, token)
+	t.mtx.Unlock()
+}
@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+
+	"google.golang.org/grpc"
+)
+
+func TestExecutionTokens(t *testing.T) {
+	tokens := newExecutionTokens(20 * time.Millisecond)
+
+	a, ok := tokens.claim("a")
+	if !ok {
+		t.Fatalf("Expected first claim to succeed")
+	}
+	if e, ok := tokens.claim("a"); ok || e != a {
+		t.Fatalf("Expected second claim to fail with the execution of the first")
+	}
+	if _, ok := tokens.claim("b"); !ok {
+		t.Fatalf("Expected claim of another token to succeed")
+	}
+
+	tokens.release("b")
+	if _, ok := tokens.claim("b"); !ok {
+		t.Fatalf("Expected claim of a released token to succeed")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if e, ok := tokens.claim("a"); !ok || e == a {
+		t.Fatalf("Expected claim of an expired token to succeed with a new execution")
+	}
+	if _, ok := tokens.tokens["b"]; ok {
+		t.Fatalf("Expected expired token to be pruned")
+	}
+}
+
+// scriptedRunnerProtocolClient hands out the given engagements in order and records
+// the TryCalls sent to them
+type scriptedRunnerProtocolClient struct {
+	pb.RunnerProtocolClient
+	engagements []*mockEngageClient
+	tries       []*pb.TryCall
+}
+
+type tryRecordingEngageClient struct {
+	*mockEngageClient
+	client *scriptedRunnerProtocolClient
+}
+
+func (c *tryRecordingEngageClient) Send(msg *pb.ClientMsg) error {
+	if try := msg.GetTry(); try != nil {
+		c.client.tries = append(c.client.tries, try)
+	}
+	return c.mockEngageClient.Send(msg)
+}
+
+func (c *scriptedRunnerProtocolClient) Engage(ctx context.Context, opts ...grpc.CallOption) (pb.RunnerProtocol_EngageClient, error) {
+	if len(c.engagements) == 0 {
+		return nil, errors.New("no more engagements")
+	}
+	e := c.engagements[0]
+	c.engagements = c.engagements[1:]
+	return &tryRecordingEngageClient{mockEngageClient: e, client: c}, nil
+}
+
+func TestTryExecExecutionTokenRetry(t *testing.T) {
+	finished := &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}}
+
+	for _, tc := range []struct {
+		tokens   bool
+		retryErr error
+		placed   bool
+		expected error
+		tries    int
+	}{
+		// ambiguous failure, retried on the same runner with the same token
+		{true, nil, true, nil, 2},
+		// ambiguous retry, the call is assumed committed and not tried on another runner
+		{true, io.EOF, true, io.EOF, 2},
+		// ambiguous failure without tokens, the call is assumed committed
+		{false, nil, true, io.EOF, 1},
+	} {
+		failed := &mockEngageClient{sendErr: io.EOF}
+		retried := &mockEngageClient{msgs: []*pb.RunnerMsg{finished}, sendErr: tc.retryErr}
+		client := &scriptedRunnerProtocolClient{engagements: []*mockEngageClient{failed, retried}}
+		r := &gRPCRunner{
+			shutWg:          common.NewWaitGroup(),
+			address:         "192.0.2.0",
+			clients:         []pb.RunnerProtocolClient{client},
+			maxDataChunk:    MaxDataChunk,
+			advertisedChunk: -1,
+			executionTokens: tc.tokens,
+		}
+		call := &mockRunnerCall{
+			r:     httptest.NewRequest("POST", "/", strings.NewReader("")),
+			rw:    httptest.NewRecorder(),
+			model: &models.Call{ID: "call1", Type: models.TypeSync},
+		}
+
+		placed, err := r.TryExec(context.Background(), call)
+		if placed != tc.placed || err != tc.expected {
+			t.Fatalf("Expected placed=%v err=%v, got placed=%v err=%v", tc.placed, tc.expected, placed, err)
+		}
+		if tries := 2 - len(client.engagements); tries != tc.tries {
+			t.Fatalf("Expected %d engagements, got %d", tc.tries, tries)
+		}
+		for _, try := range client.tries {
+			if tc.tokens != (try.ExecutionToken == "call1") {
+				t.Fatalf("Expected TryCalls with execution token=%v, got %v", tc.tokens, client.tries)
+			}
+		}
+	}
+}
+
+// A call whose stream to runner A breaks after sending the TryCall is retried on A, which
+// knows its execution token, and never on runner B, which would run the call again
+func TestTryExecExecutionTokenRetrySameRunner(t *testing.T) {
+	finished := &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}}
+	newRunner := func(addr string, client pb.RunnerProtocolClient) *gRPCRunner {
+		return &gRPCRunner{
+			shutWg:          common.NewWaitGroup(),
+			address:         addr,
+			clients:         []pb.RunnerProtocolClient{client},
+			maxDataChunk:    MaxDataChunk,
+			advertisedChunk: -1,
+			executionTokens: true,
+		}
+	}
+
+	clientA := &scriptedRunnerProtocolClient{engagements: []*mockEngageClient{
+		{sendErr: io.EOF},
+		{msgs: []*pb.RunnerMsg{finished}},
+	}}
+	clientB := &scriptedRunnerProtocolClient{engagements: []*mockEngageClient{
+		{msgs: []*pb.RunnerMsg{finished}},
+	}}
+	call := &mockRunnerCall{
+		r:     httptest.NewRequest("POST", "/", strings.NewReader("")),
+		rw:    httptest.NewRecorder(),
+		model: &models.Call{ID: "call1", Type: models.TypeSync},
+	}
+
+	// place the call on the runners in order, as the placers do
+	for _, r := range []*gRPCRunner{newRunner("192.0.2.1", clientA), newRunner("192.0.2.2", clientB)} {
+		placed, err := r.TryExec(context.Background(), call)
+		if placed {
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			break
+		}
+	}
+
+	if len(clientA.tries) != 2 || clientA.tries[1].ExecutionToken != "call1" {
+		t.Fatalf("Expected the call to be retried on runner A with its token, got %v", clientA.tries)
+	}
+	if len(clientB.tries) != 0 {
+		t.Fatalf("Expected the call not to be sent to runner B, got %v", clientB.tries)
+	}
+}
+
+// Engagements with the execution token of a call that was already accepted get the
+// result of that call, without running it again
+func TestPureRunnerAttachesToExecution(t *testing.T) {
+	pr := &pureRunner{
+		status:     NewStatusTracker(),
+		executions: newExecutionTokens(time.Minute),
+	}
+	exec, _ := pr.executions.claim("call1")
+
+	e := &unaryEngagement{ctx: context.Background()}
+	state := NewCallHandle(e)
+	err := pr.handleTryCall(&pb.TryCall{Call: &pb.CallModel{Id: "call1"}, ExecutionToken: "call1"}, state)
+	if err != errCallAttached {
+		t.Fatalf("Expected call to attach to its execution, got %v", err)
+	}
+
+	select {
+	case <-state.doneQueue:
+		t.Fatalf("Expected engagement to wait for the execution to finish")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	exec.finish(&pb.CallFinished{Success: true, Details: "call1"})
+	if err := state.waitError(); err != nil {
+		t.Fatalf("Unexpected engagement error %v", err)
+	}
+	out, finished := e.messages()
+	if !finished || len(out) != 1 || !out[0].GetFinished().Success || out[0].GetFinished().Details != "call1" {
+		t.Fatalf("Expected the finish message of the execution, got %v", out)
+	}
+}
@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fnproject/fn/api/models"
+)
+
+// launchWaitPolls is the number of HotPoll after its last try to launch a container that a
+// fn or call still counts as waiting for resources
+const launchWaitPolls = 3
+
+// fairShares share the memory and CPU of an agent across the fns that wait for containers,
+// in proportion to their weights, see models.FairShareWeightAnnotation. Shares are dominant
+// resource shares: the larger of the shares of memory and CPU that the containers of a fn
+// hold, over its weight. Under contention, only fns with the lowest share of the waiting
+// fns may take up resources as they are freed or evict containers for them.
+type fairShares struct {
+	mu sync.Mutex
+	// window is how long a fn counts as waiting after its last try to launch a container
+	window time.Duration
+	fns    map[string]*fnShare
+}
+
+// fnShare is the resources that the containers of a fn hold
+type fnShare struct {
+	weight uint64
+	mem    uint64
+	cpus   models.MilliCPUs
+	// waited is the last time the fn tried to launch a container
+	waited time.Time
+}
+
+func newFairShares(window time.Duration) *fairShares {
+	return &fairShares{
+		window: window,
+		fns:    make(map[string]*fnShare),
+	}
+}
+
+// share returns the dominant share of the resources of util that s holds, over its weight
+func (s *fnShare) share(util ResourceUtilization) float64 {
+	var share float64
+	if memTotal := util.MemUsed + util.MemAvail; memTotal > 0 {
+		share = float64(s.mem) / float64(memTotal)
+	}
+	if cpuTotal := util.CpuUsed + util.CpuAvail; cpuTotal > 0 {
+		if cpuShare := float64(s.cpus) / float64(cpuTotal); cpuShare > share {
+			share = cpuShare
+		}
+	}
+	return share / float64(s.weight)
+}
+
+func (f *fairShares) get(fnID string) *fnShare {
+	s, ok := f.fns[fnID]
+	if !ok {
+		s = &fnShare{weight: 1}
+		f.fns[fnID] = s
+	}
+	return s
+}
+
+// admit records that fnID of weight waits for a container of mem and cpus, and returns
+// whether it may take up those resources. Free resources are granted to any fn, otherwise
+// no other waiting fn may have a lower share than fnID.
+func (f *fairShares) admit(fnID string, weight uint64, mem uint64, cpus models.MilliCPUs, util ResourceUtilization) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	s := f.get(fnID)
+	s.weight = weight
+	s.waited = now
+
+	if mem <= util.MemAvail && cpus <= util.CpuAvail {
+		return true
+	}
+
+	share := s.share(util)
+	for id, o := range f.fns {
+		if id == fnID {
+			continue
+		}
+		if now.Sub(o.waited) > f.window {
+			if o.mem == 0 && o.cpus == 0 {
+				delete(f.fns, id)
+			}
+			continue
+		}
+		if o.share(util) < share {
+			return false
+		}
+	}
+	return true
+}
+
+// hold adds the resources of tok, mem and cpus, to the share of fnID until tok is closed
+func (f *fairShares) hold(fnID string, mem uint64, cpus models.MilliCPUs, tok ResourceToken) ResourceToken {
+	f.mu.Lock()
+	s := f.get(fnID)
+	s.mem += mem
+	s.cpus += cpus
+	f.mu.Unlock()
+
+	return &fairShareToken{
+		ResourceToken: tok,
+		release: func() {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			s.mem -= mem
+			s.cpus -= cpus
+			if s.mem == 0 && s.cpus == 0 && time.Since(s.waited) > f.window {
+				delete(f.fns, fnID)
+			}
+		},
+	}
+}
+
+// fairShareToken is a resource token held in the share of a fn
+type fairShareToken struct {
+	ResourceToken
+	once    sync.Once
+	release func()
+}
+
+func (t *fairShareToken) Close() error {
+	t.once.Do(t.release)
+	return t.ResourceToken.Close()
+}
+
+// fairShareAdmission returns whether a container for call with the given memory and CPU may
+// take up resources, if the agent shares them fairly across fns
+func (a *agent) fairShareAdmission(call *call, mem uint64, cpus models.MilliCPUs) bool {
+	if a.fair == nil {
+		return true
+	}
+	// annotations are validated with the app and fn
+	weight, err := call.Annotations.FairShareWeight()
+	if err != nil {
+		weight = 1
+	}
+	return a.fair.admit(call.FnID, weight, mem, cpus, a.resources.GetUtilization())
+}
+
+// holdFairShare adds tok, a token for a container of call, to the share of the fn of call
+func (a *agent) holdFairShare(call *call, mem uint64, tok ResourceToken) ResourceToken {
+	if a.fair == nil {
+		return tok
+	}
+	return a.fair.hold(call.FnID, mem, call.CPUs, tok)
+}
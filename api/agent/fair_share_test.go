@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFairShares(t *testing.T) {
+	f := newFairShares(time.Minute)
+	const MB = 1024 * 1024
+
+	// 1000MB and 4 CPUs, of which fn a holds 800MB and 1 CPU
+	a := f.hold("a", 800*MB, 1000, &resourceToken{})
+	full := ResourceUtilization{MemUsed: 800 * MB, MemAvail: 200 * MB, CpuUsed: 1000, CpuAvail: 3000}
+
+	if !f.admit("a", 1, 256*MB, 1000, full) {
+		t.Fatal("Expected fn a to be admitted while no other fn waits")
+	}
+	if !f.admit("b", 1, 128*MB, 1000, full) {
+		t.Fatal("Expected fn b to be admitted to free resources")
+	}
+	if !f.admit("b", 1, 256*MB, 1000, full) {
+		t.Fatal("Expected fn b to be admitted with the lowest share")
+	}
+	if f.admit("a", 1, 256*MB, 1000, full) {
+		t.Fatal("Expected fn a not to be admitted over its share while fn b waits")
+	}
+
+	// fn b holds 300MB and fn a now has 4 times its weight, 0.8/4 < 0.3/1
+	b := f.hold("b", 300*MB, 1000, &resourceToken{})
+	if !f.admit("a", 4, 256*MB, 1000, full) {
+		t.Fatal("Expected fn a to be admitted with its weight")
+	}
+	if f.admit("b", 1, 256*MB, 1000, full) {
+		t.Fatal("Expected fn b not to be admitted over its share for the weight of fn a")
+	}
+
+	// CPU is the dominant share of fn b
+	b.Close()
+	b = f.hold("b", 100*MB, 3000, &resourceToken{})
+	if f.admit("b", 1, 256*MB, 1000, full) {
+		t.Fatal("Expected fn b not to be admitted over its share of CPU")
+	}
+
+	a.Close()
+	a.Close()
+	b.Close()
+	if fa := f.fns["a"]; fa == nil || fa.mem != 0 || fa.cpus != 0 {
+		t.Fatalf("Expected fn a to hold nothing once its token is closed, got %+v", fa)
+	}
+	if !f.admit("b", 1, 256*MB, 1000, full) {
+		t.Fatal("Expected fn b to be admitted once shares are released")
+	}
+
+	// fns that stopped waiting and hold nothing are forgotten
+	f.window = 0
+	time.Sleep(time.Millisecond)
+	f.admit("c", 1, 256*MB, 1000, full)
+	if _, ok := f.fns["a"]; ok {
+		t.Fatal("Expected fn a to be forgotten once it stopped waiting")
+	}
+}
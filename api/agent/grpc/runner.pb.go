@@ -9,9 +9,10 @@ import (
 	proto "github.com/golang/protobuf/proto"
 	empty "github.com/golang/protobuf/ptypes/empty"
 	_struct "github.com/golang/protobuf/ptypes/struct"
+	status "google.golang.org/genproto/googleapis/rpc/status"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
-	status "google.golang.org/grpc/status"
+	status1 "google.golang.org/grpc/status"
 	math "math"
 )
 
@@ -48,17 +49,33 @@ func (x LogResponseMsg_Container_Request_Line_Source) String() string {
 }
 
 func (LogResponseMsg_Container_Request_Line_Source) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{12, 0, 0, 0, 0}
+	return fileDescriptor_48eceea7e2abc593, []int{19, 0, 0, 0, 0}
 }
 
 // Request to allocate a slot for a call
 type TryCall struct {
-	ModelsCallJson       string            `protobuf:"bytes,1,opt,name=models_call_json,json=modelsCallJson,proto3" json:"models_call_json,omitempty"`
-	SlotHashId           string            `protobuf:"bytes,2,opt,name=slot_hash_id,json=slotHashId,proto3" json:"slot_hash_id,omitempty"`
-	Extensions           map[string]string `protobuf:"bytes,3,rep,name=extensions,proto3" json:"extensions,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
-	XXX_unrecognized     []byte            `json:"-"`
-	XXX_sizecache        int32             `json:"-"`
+	ModelsCallJson string            `protobuf:"bytes,1,opt,name=models_call_json,json=modelsCallJson,proto3" json:"models_call_json,omitempty"`
+	SlotHashId     string            `protobuf:"bytes,2,opt,name=slot_hash_id,json=slotHashId,proto3" json:"slot_hash_id,omitempty"`
+	Extensions     map[string]string `protobuf:"bytes,3,rep,name=extensions,proto3" json:"extensions,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// ask the runner to report a hash of the response body in CallFinished
+	VerifyResponseHash bool `protobuf:"varint,4,opt,name=verify_response_hash,json=verifyResponseHash,proto3" json:"verify_response_hash,omitempty"`
+	// ask the runner to report a CRC32C checksum of the response body in CallFinished
+	VerifyResponseCrc32C bool `protobuf:"varint,5,opt,name=verify_response_crc32c,json=verifyResponseCrc32c,proto3" json:"verify_response_crc32c,omitempty"`
+	// identifies the execution of the call across placement attempts. A runner
+	// rejects a token it already accepted, so the call can be retried safely.
+	ExecutionToken string `protobuf:"bytes,6,opt,name=execution_token,json=executionToken,proto3" json:"execution_token,omitempty"`
+	// the call model, replacing models_call_json for runners advertising support for it
+	Call *CallModel `protobuf:"bytes,7,opt,name=call,proto3" json:"call,omitempty"`
+	// bytes of response data the runner may send that the client has not acknowledged
+	// with a DataAck yet, zero disables flow control
+	ResponseWindow int64 `protobuf:"varint,8,opt,name=response_window,json=responseWindow,proto3" json:"response_window,omitempty"`
+	// ask the runner to send the response of a detached call once it acknowledged the
+	// call, up to this many bytes of its body, see CallFinished.detached_response. Zero
+	// discards the response.
+	DetachedResponseMax  int64    `protobuf:"varint,9,opt,name=detached_response_max,json=detachedResponseMax,proto3" json:"detached_response_max,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *TryCall) Reset()         { *m = TryCall{} }
@@ -107,6 +124,306 @@ func (m *TryCall) GetExtensions() map[string]string {
 	return nil
 }
 
+func (m *TryCall) GetVerifyResponseHash() bool {
+	if m != nil {
+		return m.VerifyResponseHash
+	}
+	return false
+}
+
+func (m *TryCall) GetVerifyResponseCrc32C() bool {
+	if m != nil {
+		return m.VerifyResponseCrc32C
+	}
+	return false
+}
+
+func (m *TryCall) GetExecutionToken() string {
+	if m != nil {
+		return m.ExecutionToken
+	}
+	return ""
+}
+
+func (m *TryCall) GetCall() *CallModel {
+	if m != nil {
+		return m.Call
+	}
+	return nil
+}
+
+func (m *TryCall) GetResponseWindow() int64 {
+	if m != nil {
+		return m.ResponseWindow
+	}
+	return 0
+}
+
+func (m *TryCall) GetDetachedResponseMax() int64 {
+	if m != nil {
+		return m.DetachedResponseMax
+	}
+	return 0
+}
+
+// The fields of models.Call a runner needs to run the call. Dates and stats are
+// not sent, the runner sets its own.
+type CallModel struct {
+	Id          string            `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Status      string            `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	Image       string            `protobuf:"bytes,3,opt,name=image,proto3" json:"image,omitempty"`
+	Delay       int32             `protobuf:"varint,4,opt,name=delay,proto3" json:"delay,omitempty"`
+	Type        string            `protobuf:"bytes,5,opt,name=type,proto3" json:"type,omitempty"`
+	Payload     string            `protobuf:"bytes,6,opt,name=payload,proto3" json:"payload,omitempty"`
+	Url         string            `protobuf:"bytes,7,opt,name=url,proto3" json:"url,omitempty"`
+	Method      string            `protobuf:"bytes,8,opt,name=method,proto3" json:"method,omitempty"`
+	Timeout     int32             `protobuf:"varint,9,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	IdleTimeout int32             `protobuf:"varint,10,opt,name=idle_timeout,json=idleTimeout,proto3" json:"idle_timeout,omitempty"`
+	TmpfsSize   uint32            `protobuf:"varint,11,opt,name=tmpfs_size,json=tmpfsSize,proto3" json:"tmpfs_size,omitempty"`
+	Memory      uint64            `protobuf:"varint,12,opt,name=memory,proto3" json:"memory,omitempty"`
+	Cpus        uint64            `protobuf:"varint,13,opt,name=cpus,proto3" json:"cpus,omitempty"`
+	Config      map[string]string `protobuf:"bytes,14,rep,name=config,proto3" json:"config,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// compacted JSON values
+	Annotations          map[string][]byte `protobuf:"bytes,15,rep,name=annotations,proto3" json:"annotations,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Headers              []*HttpHeader     `protobuf:"bytes,16,rep,name=headers,proto3" json:"headers,omitempty"`
+	SyslogUrl            string            `protobuf:"bytes,17,opt,name=syslog_url,json=syslogUrl,proto3" json:"syslog_url,omitempty"`
+	ExecutionDuration    int64             `protobuf:"varint,18,opt,name=execution_duration,json=executionDuration,proto3" json:"execution_duration,omitempty"`
+	Error                string            `protobuf:"bytes,19,opt,name=error,proto3" json:"error,omitempty"`
+	AppId                string            `protobuf:"bytes,20,opt,name=app_id,json=appId,proto3" json:"app_id,omitempty"`
+	AppName              string            `protobuf:"bytes,21,opt,name=app_name,json=appName,proto3" json:"app_name,omitempty"`
+	TriggerId            string            `protobuf:"bytes,22,opt,name=trigger_id,json=triggerId,proto3" json:"trigger_id,omitempty"`
+	FnId                 string            `protobuf:"bytes,23,opt,name=fn_id,json=fnId,proto3" json:"fn_id,omitempty"`
+	InitTimeout          int32             `protobuf:"varint,24,opt,name=init_timeout,json=initTimeout,proto3" json:"init_timeout,omitempty"`
+	Concurrency          uint64            `protobuf:"varint,25,opt,name=concurrency,proto3" json:"concurrency,omitempty"`
+	FsSize               uint64            `protobuf:"varint,26,opt,name=fs_size,json=fsSize,proto3" json:"fs_size,omitempty"`
+	ReadOnlyRootfs       bool              `protobuf:"varint,27,opt,name=read_only_rootfs,json=readOnlyRootfs,proto3" json:"read_only_rootfs,omitempty"`
+	Gpus                 uint64            `protobuf:"varint,28,opt,name=gpus,proto3" json:"gpus,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *CallModel) Reset()         { *m = CallModel{} }
+func (m *CallModel) String() string { return proto.CompactTextString(m) }
+func (*CallModel) ProtoMessage()    {}
+func (*CallModel) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{1}
+}
+
+func (m *CallModel) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CallModel.Unmarshal(m, b)
+}
+func (m *CallModel) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CallModel.Marshal(b, m, deterministic)
+}
+func (m *CallModel) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CallModel.Merge(m, src)
+}
+func (m *CallModel) XXX_Size() int {
+	return xxx_messageInfo_CallModel.Size(m)
+}
+func (m *CallModel) XXX_DiscardUnknown() {
+	xxx_messageInfo_CallModel.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CallModel proto.InternalMessageInfo
+
+func (m *CallModel) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *CallModel) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *CallModel) GetImage() string {
+	if m != nil {
+		return m.Image
+	}
+	return ""
+}
+
+func (m *CallModel) GetDelay() int32 {
+	if m != nil {
+		return m.Delay
+	}
+	return 0
+}
+
+func (m *CallModel) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *CallModel) GetPayload() string {
+	if m != nil {
+		return m.Payload
+	}
+	return ""
+}
+
+func (m *CallModel) GetUrl() string {
+	if m != nil {
+		return m.Url
+	}
+	return ""
+}
+
+func (m *CallModel) GetMethod() string {
+	if m != nil {
+		return m.Method
+	}
+	return ""
+}
+
+func (m *CallModel) GetTimeout() int32 {
+	if m != nil {
+		return m.Timeout
+	}
+	return 0
+}
+
+func (m *CallModel) GetIdleTimeout() int32 {
+	if m != nil {
+		return m.IdleTimeout
+	}
+	return 0
+}
+
+func (m *CallModel) GetTmpfsSize() uint32 {
+	if m != nil {
+		return m.TmpfsSize
+	}
+	return 0
+}
+
+func (m *CallModel) GetMemory() uint64 {
+	if m != nil {
+		return m.Memory
+	}
+	return 0
+}
+
+func (m *CallModel) GetCpus() uint64 {
+	if m != nil {
+		return m.Cpus
+	}
+	return 0
+}
+
+func (m *CallModel) GetConfig() map[string]string {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+func (m *CallModel) GetAnnotations() map[string][]byte {
+	if m != nil {
+		return m.Annotations
+	}
+	return nil
+}
+
+func (m *CallModel) GetHeaders() []*HttpHeader {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+func (m *CallModel) GetSyslogUrl() string {
+	if m != nil {
+		return m.SyslogUrl
+	}
+	return ""
+}
+
+func (m *CallModel) GetExecutionDuration() int64 {
+	if m != nil {
+		return m.ExecutionDuration
+	}
+	return 0
+}
+
+func (m *CallModel) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *CallModel) GetAppId() string {
+	if m != nil {
+		return m.AppId
+	}
+	return ""
+}
+
+func (m *CallModel) GetAppName() string {
+	if m != nil {
+		return m.AppName
+	}
+	return ""
+}
+
+func (m *CallModel) GetTriggerId() string {
+	if m != nil {
+		return m.TriggerId
+	}
+	return ""
+}
+
+func (m *CallModel) GetFnId() string {
+	if m != nil {
+		return m.FnId
+	}
+	return ""
+}
+
+func (m *CallModel) GetInitTimeout() int32 {
+	if m != nil {
+		return m.InitTimeout
+	}
+	return 0
+}
+
+func (m *CallModel) GetConcurrency() uint64 {
+	if m != nil {
+		return m.Concurrency
+	}
+	return 0
+}
+
+func (m *CallModel) GetFsSize() uint64 {
+	if m != nil {
+		return m.FsSize
+	}
+	return 0
+}
+
+func (m *CallModel) GetReadOnlyRootfs() bool {
+	if m != nil {
+		return m.ReadOnlyRootfs
+	}
+	return false
+}
+
+func (m *CallModel) GetGpus() uint64 {
+	if m != nil {
+		return m.Gpus
+	}
+	return 0
+}
+
 // Data sent C2S and S2C - as soon as the runner sees the first of these it
 // will start running. If empty content, there must be one of these with eof.
 // The runner will send these for the body of the response, AFTER it has sent
@@ -123,7 +440,7 @@ func (m *DataFrame) Reset()         { *m = DataFrame{} }
 func (m *DataFrame) String() string { return proto.CompactTextString(m) }
 func (*DataFrame) ProtoMessage()    {}
 func (*DataFrame) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{1}
+	return fileDescriptor_48eceea7e2abc593, []int{2}
 }
 
 func (m *DataFrame) XXX_Unmarshal(b []byte) error {
@@ -170,7 +487,7 @@ func (m *HttpHeader) Reset()         { *m = HttpHeader{} }
 func (m *HttpHeader) String() string { return proto.CompactTextString(m) }
 func (*HttpHeader) ProtoMessage()    {}
 func (*HttpHeader) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{2}
+	return fileDescriptor_48eceea7e2abc593, []int{3}
 }
 
 func (m *HttpHeader) XXX_Unmarshal(b []byte) error {
@@ -217,7 +534,7 @@ func (m *HttpRespMeta) Reset()         { *m = HttpRespMeta{} }
 func (m *HttpRespMeta) String() string { return proto.CompactTextString(m) }
 func (*HttpRespMeta) ProtoMessage()    {}
 func (*HttpRespMeta) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{3}
+	return fileDescriptor_48eceea7e2abc593, []int{4}
 }
 
 func (m *HttpRespMeta) XXX_Unmarshal(b []byte) error {
@@ -267,7 +584,7 @@ func (m *CallResultStart) Reset()         { *m = CallResultStart{} }
 func (m *CallResultStart) String() string { return proto.CompactTextString(m) }
 func (*CallResultStart) ProtoMessage()    {}
 func (*CallResultStart) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{4}
+	return fileDescriptor_48eceea7e2abc593, []int{5}
 }
 
 func (m *CallResultStart) XXX_Unmarshal(b []byte) error {
@@ -321,31 +638,46 @@ func (*CallResultStart) XXX_OneofWrappers() []interface{} {
 
 // Call has really finished, it might have completed or crashed
 type CallFinished struct {
-	Success               bool     `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
-	Details               string   `protobuf:"bytes,2,opt,name=details,proto3" json:"details,omitempty"`
-	ErrorCode             int32    `protobuf:"varint,3,opt,name=errorCode,proto3" json:"errorCode,omitempty"`
-	ErrorStr              string   `protobuf:"bytes,4,opt,name=errorStr,proto3" json:"errorStr,omitempty"`
-	CreatedAt             string   `protobuf:"bytes,5,opt,name=createdAt,proto3" json:"createdAt,omitempty"`
-	StartedAt             string   `protobuf:"bytes,6,opt,name=startedAt,proto3" json:"startedAt,omitempty"`
-	CompletedAt           string   `protobuf:"bytes,7,opt,name=completedAt,proto3" json:"completedAt,omitempty"`
-	SchedulerDuration     int64    `protobuf:"varint,8,opt,name=schedulerDuration,proto3" json:"schedulerDuration,omitempty"`
-	ExecutionDuration     int64    `protobuf:"varint,9,opt,name=executionDuration,proto3" json:"executionDuration,omitempty"`
-	ErrorUser             bool     `protobuf:"varint,10,opt,name=errorUser,proto3" json:"errorUser,omitempty"`
-	Image                 string   `protobuf:"bytes,11,opt,name=image,proto3" json:"image,omitempty"`
-	ImagePullWaitDuration int64    `protobuf:"varint,12,opt,name=imagePullWaitDuration,proto3" json:"imagePullWaitDuration,omitempty"`
-	CtrPrepDuration       int64    `protobuf:"varint,13,opt,name=ctrPrepDuration,proto3" json:"ctrPrepDuration,omitempty"`
-	CtrCreateDuration     int64    `protobuf:"varint,14,opt,name=ctrCreateDuration,proto3" json:"ctrCreateDuration,omitempty"`
-	InitStartTime         int64    `protobuf:"varint,15,opt,name=initStartTime,proto3" json:"initStartTime,omitempty"`
-	XXX_NoUnkeyedLiteral  struct{} `json:"-"`
-	XXX_unrecognized      []byte   `json:"-"`
-	XXX_sizecache         int32    `json:"-"`
+	Success               bool   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Details               string `protobuf:"bytes,2,opt,name=details,proto3" json:"details,omitempty"`
+	ErrorCode             int32  `protobuf:"varint,3,opt,name=errorCode,proto3" json:"errorCode,omitempty"`
+	ErrorStr              string `protobuf:"bytes,4,opt,name=errorStr,proto3" json:"errorStr,omitempty"`
+	CreatedAt             string `protobuf:"bytes,5,opt,name=createdAt,proto3" json:"createdAt,omitempty"`
+	StartedAt             string `protobuf:"bytes,6,opt,name=startedAt,proto3" json:"startedAt,omitempty"`
+	CompletedAt           string `protobuf:"bytes,7,opt,name=completedAt,proto3" json:"completedAt,omitempty"`
+	SchedulerDuration     int64  `protobuf:"varint,8,opt,name=schedulerDuration,proto3" json:"schedulerDuration,omitempty"`
+	ExecutionDuration     int64  `protobuf:"varint,9,opt,name=executionDuration,proto3" json:"executionDuration,omitempty"`
+	ErrorUser             bool   `protobuf:"varint,10,opt,name=errorUser,proto3" json:"errorUser,omitempty"`
+	Image                 string `protobuf:"bytes,11,opt,name=image,proto3" json:"image,omitempty"`
+	ImagePullWaitDuration int64  `protobuf:"varint,12,opt,name=imagePullWaitDuration,proto3" json:"imagePullWaitDuration,omitempty"`
+	CtrPrepDuration       int64  `protobuf:"varint,13,opt,name=ctrPrepDuration,proto3" json:"ctrPrepDuration,omitempty"`
+	CtrCreateDuration     int64  `protobuf:"varint,14,opt,name=ctrCreateDuration,proto3" json:"ctrCreateDuration,omitempty"`
+	InitStartTime         int64  `protobuf:"varint,15,opt,name=initStartTime,proto3" json:"initStartTime,omitempty"`
+	// sha256 of the response body, only set if requested in TryCall
+	ResponseSha256 []byte `protobuf:"bytes,16,opt,name=responseSha256,proto3" json:"responseSha256,omitempty"`
+	// big endian CRC32C (Castagnoli) of the response body, only set if requested in TryCall
+	ResponseCrc32C []byte `protobuf:"bytes,17,opt,name=responseCrc32c,proto3" json:"responseCrc32c,omitempty"`
+	// why a too busy runner rejected the call, eg. "memory", see runnerpool.RejectReason
+	RejectReason string `protobuf:"bytes,18,opt,name=rejectReason,proto3" json:"rejectReason,omitempty"`
+	// the error of a failed call, with google.rpc.RetryInfo, QuotaFailure and DebugInfo
+	// details. errorCode is still the HTTP status of the error.
+	ErrorStatus *status.Status `protobuf:"bytes,19,opt,name=errorStatus,proto3" json:"errorStatus,omitempty"`
+	// tells apart user errors of the same errorCode, eg. FunctionOutOfMemory, see
+	// models.ErrorCoder
+	ErrorName string `protobuf:"bytes,20,opt,name=errorName,proto3" json:"errorName,omitempty"`
+	// the status and headers of the response of a detached call, if requested in TryCall,
+	// as its CallResultStart is the acknowledgement of the call
+	DetachedResponse     *DetachedResponse `protobuf:"bytes,21,opt,name=detachedResponse,proto3" json:"detachedResponse,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
 func (m *CallFinished) Reset()         { *m = CallFinished{} }
 func (m *CallFinished) String() string { return proto.CompactTextString(m) }
 func (*CallFinished) ProtoMessage()    {}
 func (*CallFinished) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{5}
+	return fileDescriptor_48eceea7e2abc593, []int{6}
 }
 
 func (m *CallFinished) XXX_Unmarshal(b []byte) error {
@@ -471,10 +803,144 @@ func (m *CallFinished) GetInitStartTime() int64 {
 	return 0
 }
 
+func (m *CallFinished) GetResponseSha256() []byte {
+	if m != nil {
+		return m.ResponseSha256
+	}
+	return nil
+}
+
+func (m *CallFinished) GetResponseCrc32C() []byte {
+	if m != nil {
+		return m.ResponseCrc32C
+	}
+	return nil
+}
+
+func (m *CallFinished) GetRejectReason() string {
+	if m != nil {
+		return m.RejectReason
+	}
+	return ""
+}
+
+func (m *CallFinished) GetErrorStatus() *status.Status {
+	if m != nil {
+		return m.ErrorStatus
+	}
+	return nil
+}
+
+func (m *CallFinished) GetErrorName() string {
+	if m != nil {
+		return m.ErrorName
+	}
+	return ""
+}
+
+func (m *CallFinished) GetDetachedResponse() *DetachedResponse {
+	if m != nil {
+		return m.DetachedResponse
+	}
+	return nil
+}
+
+// The response of a detached call, its body is sent as DataFrames
+type DetachedResponse struct {
+	Http *HttpRespMeta `protobuf:"bytes,1,opt,name=http,proto3" json:"http,omitempty"`
+	// the body was larger than TryCall.detached_response_max, the rest was not sent
+	Truncated            bool     `protobuf:"varint,2,opt,name=truncated,proto3" json:"truncated,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DetachedResponse) Reset()         { *m = DetachedResponse{} }
+func (m *DetachedResponse) String() string { return proto.CompactTextString(m) }
+func (*DetachedResponse) ProtoMessage()    {}
+func (*DetachedResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{7}
+}
+
+func (m *DetachedResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DetachedResponse.Unmarshal(m, b)
+}
+func (m *DetachedResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DetachedResponse.Marshal(b, m, deterministic)
+}
+func (m *DetachedResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DetachedResponse.Merge(m, src)
+}
+func (m *DetachedResponse) XXX_Size() int {
+	return xxx_messageInfo_DetachedResponse.Size(m)
+}
+func (m *DetachedResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DetachedResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DetachedResponse proto.InternalMessageInfo
+
+func (m *DetachedResponse) GetHttp() *HttpRespMeta {
+	if m != nil {
+		return m.Http
+	}
+	return nil
+}
+
+func (m *DetachedResponse) GetTruncated() bool {
+	if m != nil {
+		return m.Truncated
+	}
+	return false
+}
+
+// Acknowledges response data written to the client, see TryCall.response_window
+type DataAck struct {
+	// total bytes of response data written so far
+	Bytes                int64    `protobuf:"varint,1,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DataAck) Reset()         { *m = DataAck{} }
+func (m *DataAck) String() string { return proto.CompactTextString(m) }
+func (*DataAck) ProtoMessage()    {}
+func (*DataAck) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{8}
+}
+
+func (m *DataAck) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DataAck.Unmarshal(m, b)
+}
+func (m *DataAck) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DataAck.Marshal(b, m, deterministic)
+}
+func (m *DataAck) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DataAck.Merge(m, src)
+}
+func (m *DataAck) XXX_Size() int {
+	return xxx_messageInfo_DataAck.Size(m)
+}
+func (m *DataAck) XXX_DiscardUnknown() {
+	xxx_messageInfo_DataAck.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DataAck proto.InternalMessageInfo
+
+func (m *DataAck) GetBytes() int64 {
+	if m != nil {
+		return m.Bytes
+	}
+	return 0
+}
+
 type ClientMsg struct {
 	// Types that are valid to be assigned to Body:
 	//	*ClientMsg_Try
 	//	*ClientMsg_Data
+	//	*ClientMsg_Ack
+	//	*ClientMsg_Cancel
 	Body                 isClientMsg_Body `protobuf_oneof:"body"`
 	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
 	XXX_unrecognized     []byte           `json:"-"`
@@ -485,7 +951,7 @@ func (m *ClientMsg) Reset()         { *m = ClientMsg{} }
 func (m *ClientMsg) String() string { return proto.CompactTextString(m) }
 func (*ClientMsg) ProtoMessage()    {}
 func (*ClientMsg) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{6}
+	return fileDescriptor_48eceea7e2abc593, []int{9}
 }
 
 func (m *ClientMsg) XXX_Unmarshal(b []byte) error {
@@ -518,10 +984,22 @@ type ClientMsg_Data struct {
 	Data *DataFrame `protobuf:"bytes,2,opt,name=data,proto3,oneof"`
 }
 
+type ClientMsg_Ack struct {
+	Ack *DataAck `protobuf:"bytes,3,opt,name=ack,proto3,oneof"`
+}
+
+type ClientMsg_Cancel struct {
+	Cancel *CallCancel `protobuf:"bytes,4,opt,name=cancel,proto3,oneof"`
+}
+
 func (*ClientMsg_Try) isClientMsg_Body() {}
 
 func (*ClientMsg_Data) isClientMsg_Body() {}
 
+func (*ClientMsg_Ack) isClientMsg_Body() {}
+
+func (*ClientMsg_Cancel) isClientMsg_Body() {}
+
 func (m *ClientMsg) GetBody() isClientMsg_Body {
 	if m != nil {
 		return m.Body
@@ -543,19 +1021,117 @@ func (m *ClientMsg) GetData() *DataFrame {
 	return nil
 }
 
+func (m *ClientMsg) GetAck() *DataAck {
+	if x, ok := m.GetBody().(*ClientMsg_Ack); ok {
+		return x.Ack
+	}
+	return nil
+}
+
+func (m *ClientMsg) GetCancel() *CallCancel {
+	if x, ok := m.GetBody().(*ClientMsg_Cancel); ok {
+		return x.Cancel
+	}
+	return nil
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*ClientMsg) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
 		(*ClientMsg_Try)(nil),
 		(*ClientMsg_Data)(nil),
+		(*ClientMsg_Ack)(nil),
+		(*ClientMsg_Cancel)(nil),
 	}
 }
 
+// Sent by the client that abandons a call it already engaged, eg. because its own
+// client went away. The runner aborts the call instead of running it to completion.
+type CallCancel struct {
+	Reason               string   `protobuf:"bytes,1,opt,name=reason,proto3" json:"reason,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CallCancel) Reset()         { *m = CallCancel{} }
+func (m *CallCancel) String() string { return proto.CompactTextString(m) }
+func (*CallCancel) ProtoMessage()    {}
+func (*CallCancel) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{10}
+}
+
+func (m *CallCancel) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CallCancel.Unmarshal(m, b)
+}
+func (m *CallCancel) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CallCancel.Marshal(b, m, deterministic)
+}
+func (m *CallCancel) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CallCancel.Merge(m, src)
+}
+func (m *CallCancel) XXX_Size() int {
+	return xxx_messageInfo_CallCancel.Size(m)
+}
+func (m *CallCancel) XXX_DiscardUnknown() {
+	xxx_messageInfo_CallCancel.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CallCancel proto.InternalMessageInfo
+
+func (m *CallCancel) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+// Output the function wrote to stderr, only sent if requested by the client
+type LogFrame struct {
+	Data                 []byte   `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LogFrame) Reset()         { *m = LogFrame{} }
+func (m *LogFrame) String() string { return proto.CompactTextString(m) }
+func (*LogFrame) ProtoMessage()    {}
+func (*LogFrame) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{11}
+}
+
+func (m *LogFrame) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LogFrame.Unmarshal(m, b)
+}
+func (m *LogFrame) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LogFrame.Marshal(b, m, deterministic)
+}
+func (m *LogFrame) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LogFrame.Merge(m, src)
+}
+func (m *LogFrame) XXX_Size() int {
+	return xxx_messageInfo_LogFrame.Size(m)
+}
+func (m *LogFrame) XXX_DiscardUnknown() {
+	xxx_messageInfo_LogFrame.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LogFrame proto.InternalMessageInfo
+
+func (m *LogFrame) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
 type RunnerMsg struct {
 	// Types that are valid to be assigned to Body:
 	//	*RunnerMsg_ResultStart
 	//	*RunnerMsg_Data
 	//	*RunnerMsg_Finished
+	//	*RunnerMsg_Log
 	Body                 isRunnerMsg_Body `protobuf_oneof:"body"`
 	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
 	XXX_unrecognized     []byte           `json:"-"`
@@ -566,7 +1142,7 @@ func (m *RunnerMsg) Reset()         { *m = RunnerMsg{} }
 func (m *RunnerMsg) String() string { return proto.CompactTextString(m) }
 func (*RunnerMsg) ProtoMessage()    {}
 func (*RunnerMsg) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{7}
+	return fileDescriptor_48eceea7e2abc593, []int{12}
 }
 
 func (m *RunnerMsg) XXX_Unmarshal(b []byte) error {
@@ -603,47 +1179,149 @@ type RunnerMsg_Finished struct {
 	Finished *CallFinished `protobuf:"bytes,3,opt,name=finished,proto3,oneof"`
 }
 
+type RunnerMsg_Log struct {
+	Log *LogFrame `protobuf:"bytes,4,opt,name=log,proto3,oneof"`
+}
+
 func (*RunnerMsg_ResultStart) isRunnerMsg_Body() {}
 
 func (*RunnerMsg_Data) isRunnerMsg_Body() {}
 
 func (*RunnerMsg_Finished) isRunnerMsg_Body() {}
 
+func (*RunnerMsg_Log) isRunnerMsg_Body() {}
+
 func (m *RunnerMsg) GetBody() isRunnerMsg_Body {
 	if m != nil {
-		return m.Body
+		return m.Body
+	}
+	return nil
+}
+
+func (m *RunnerMsg) GetResultStart() *CallResultStart {
+	if x, ok := m.GetBody().(*RunnerMsg_ResultStart); ok {
+		return x.ResultStart
+	}
+	return nil
+}
+
+func (m *RunnerMsg) GetData() *DataFrame {
+	if x, ok := m.GetBody().(*RunnerMsg_Data); ok {
+		return x.Data
+	}
+	return nil
+}
+
+func (m *RunnerMsg) GetFinished() *CallFinished {
+	if x, ok := m.GetBody().(*RunnerMsg_Finished); ok {
+		return x.Finished
+	}
+	return nil
+}
+
+func (m *RunnerMsg) GetLog() *LogFrame {
+	if x, ok := m.GetBody().(*RunnerMsg_Log); ok {
+		return x.Log
+	}
+	return nil
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*RunnerMsg) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*RunnerMsg_ResultStart)(nil),
+		(*RunnerMsg_Data)(nil),
+		(*RunnerMsg_Finished)(nil),
+		(*RunnerMsg_Log)(nil),
+	}
+}
+
+// Request of a call with a small body made in a single message, see Invoke
+type InvokeRequest struct {
+	Try                  *TryCall `protobuf:"bytes,1,opt,name=try,proto3" json:"try,omitempty"`
+	Body                 []byte   `protobuf:"bytes,2,opt,name=body,proto3" json:"body,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *InvokeRequest) Reset()         { *m = InvokeRequest{} }
+func (m *InvokeRequest) String() string { return proto.CompactTextString(m) }
+func (*InvokeRequest) ProtoMessage()    {}
+func (*InvokeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{13}
+}
+
+func (m *InvokeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InvokeRequest.Unmarshal(m, b)
+}
+func (m *InvokeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InvokeRequest.Marshal(b, m, deterministic)
+}
+func (m *InvokeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InvokeRequest.Merge(m, src)
+}
+func (m *InvokeRequest) XXX_Size() int {
+	return xxx_messageInfo_InvokeRequest.Size(m)
+}
+func (m *InvokeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_InvokeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InvokeRequest proto.InternalMessageInfo
+
+func (m *InvokeRequest) GetTry() *TryCall {
+	if m != nil {
+		return m.Try
 	}
 	return nil
 }
 
-func (m *RunnerMsg) GetResultStart() *CallResultStart {
-	if x, ok := m.GetBody().(*RunnerMsg_ResultStart); ok {
-		return x.ResultStart
+func (m *InvokeRequest) GetBody() []byte {
+	if m != nil {
+		return m.Body
 	}
 	return nil
 }
 
-func (m *RunnerMsg) GetData() *DataFrame {
-	if x, ok := m.GetBody().(*RunnerMsg_Data); ok {
-		return x.Data
-	}
-	return nil
+// The messages an engagement of the same call would have received
+type InvokeResponse struct {
+	Msgs                 []*RunnerMsg `protobuf:"bytes,1,rep,name=msgs,proto3" json:"msgs,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
 }
 
-func (m *RunnerMsg) GetFinished() *CallFinished {
-	if x, ok := m.GetBody().(*RunnerMsg_Finished); ok {
-		return x.Finished
-	}
-	return nil
+func (m *InvokeResponse) Reset()         { *m = InvokeResponse{} }
+func (m *InvokeResponse) String() string { return proto.CompactTextString(m) }
+func (*InvokeResponse) ProtoMessage()    {}
+func (*InvokeResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{14}
 }
 
-// XXX_OneofWrappers is for the internal use of the proto package.
-func (*RunnerMsg) XXX_OneofWrappers() []interface{} {
-	return []interface{}{
-		(*RunnerMsg_ResultStart)(nil),
-		(*RunnerMsg_Data)(nil),
-		(*RunnerMsg_Finished)(nil),
+func (m *InvokeResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InvokeResponse.Unmarshal(m, b)
+}
+func (m *InvokeResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InvokeResponse.Marshal(b, m, deterministic)
+}
+func (m *InvokeResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InvokeResponse.Merge(m, src)
+}
+func (m *InvokeResponse) XXX_Size() int {
+	return xxx_messageInfo_InvokeResponse.Size(m)
+}
+func (m *InvokeResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_InvokeResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InvokeResponse proto.InternalMessageInfo
+
+func (m *InvokeResponse) GetMsgs() []*RunnerMsg {
+	if m != nil {
+		return m.Msgs
 	}
+	return nil
 }
 
 type RunnerStatus struct {
@@ -677,7 +1355,7 @@ func (m *RunnerStatus) Reset()         { *m = RunnerStatus{} }
 func (m *RunnerStatus) String() string { return proto.CompactTextString(m) }
 func (*RunnerStatus) ProtoMessage()    {}
 func (*RunnerStatus) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{8}
+	return fileDescriptor_48eceea7e2abc593, []int{15}
 }
 
 func (m *RunnerStatus) XXX_Unmarshal(b []byte) error {
@@ -856,7 +1534,7 @@ func (m *ConfigMsg) Reset()         { *m = ConfigMsg{} }
 func (m *ConfigMsg) String() string { return proto.CompactTextString(m) }
 func (*ConfigMsg) ProtoMessage()    {}
 func (*ConfigMsg) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{9}
+	return fileDescriptor_48eceea7e2abc593, []int{16}
 }
 
 func (m *ConfigMsg) XXX_Unmarshal(b []byte) error {
@@ -894,7 +1572,7 @@ func (m *ConfigStatus) Reset()         { *m = ConfigStatus{} }
 func (m *ConfigStatus) String() string { return proto.CompactTextString(m) }
 func (*ConfigStatus) ProtoMessage()    {}
 func (*ConfigStatus) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{10}
+	return fileDescriptor_48eceea7e2abc593, []int{17}
 }
 
 func (m *ConfigStatus) XXX_Unmarshal(b []byte) error {
@@ -931,7 +1609,7 @@ func (m *LogRequestMsg) Reset()         { *m = LogRequestMsg{} }
 func (m *LogRequestMsg) String() string { return proto.CompactTextString(m) }
 func (*LogRequestMsg) ProtoMessage()    {}
 func (*LogRequestMsg) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{11}
+	return fileDescriptor_48eceea7e2abc593, []int{18}
 }
 
 func (m *LogRequestMsg) XXX_Unmarshal(b []byte) error {
@@ -1023,7 +1701,7 @@ func (m *LogRequestMsg_Start) Reset()         { *m = LogRequestMsg_Start{} }
 func (m *LogRequestMsg_Start) String() string { return proto.CompactTextString(m) }
 func (*LogRequestMsg_Start) ProtoMessage()    {}
 func (*LogRequestMsg_Start) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{11, 0}
+	return fileDescriptor_48eceea7e2abc593, []int{18, 0}
 }
 
 func (m *LogRequestMsg_Start) XXX_Unmarshal(b []byte) error {
@@ -1062,7 +1740,7 @@ func (m *LogRequestMsg_Ack) Reset()         { *m = LogRequestMsg_Ack{} }
 func (m *LogRequestMsg_Ack) String() string { return proto.CompactTextString(m) }
 func (*LogRequestMsg_Ack) ProtoMessage()    {}
 func (*LogRequestMsg_Ack) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{11, 1}
+	return fileDescriptor_48eceea7e2abc593, []int{18, 1}
 }
 
 func (m *LogRequestMsg_Ack) XXX_Unmarshal(b []byte) error {
@@ -1094,7 +1772,7 @@ func (m *LogRequestMsg_Ready) Reset()         { *m = LogRequestMsg_Ready{} }
 func (m *LogRequestMsg_Ready) String() string { return proto.CompactTextString(m) }
 func (*LogRequestMsg_Ready) ProtoMessage()    {}
 func (*LogRequestMsg_Ready) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{11, 2}
+	return fileDescriptor_48eceea7e2abc593, []int{18, 2}
 }
 
 func (m *LogRequestMsg_Ready) XXX_Unmarshal(b []byte) error {
@@ -1127,7 +1805,7 @@ func (m *LogResponseMsg) Reset()         { *m = LogResponseMsg{} }
 func (m *LogResponseMsg) String() string { return proto.CompactTextString(m) }
 func (*LogResponseMsg) ProtoMessage()    {}
 func (*LogResponseMsg) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{12}
+	return fileDescriptor_48eceea7e2abc593, []int{19}
 }
 
 func (m *LogResponseMsg) XXX_Unmarshal(b []byte) error {
@@ -1170,7 +1848,7 @@ func (m *LogResponseMsg_Container) Reset()         { *m = LogResponseMsg_Contain
 func (m *LogResponseMsg_Container) String() string { return proto.CompactTextString(m) }
 func (*LogResponseMsg_Container) ProtoMessage()    {}
 func (*LogResponseMsg_Container) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{12, 0}
+	return fileDescriptor_48eceea7e2abc593, []int{19, 0}
 }
 
 func (m *LogResponseMsg_Container) XXX_Unmarshal(b []byte) error {
@@ -1232,7 +1910,7 @@ func (m *LogResponseMsg_Container_Request) Reset()         { *m = LogResponseMsg
 func (m *LogResponseMsg_Container_Request) String() string { return proto.CompactTextString(m) }
 func (*LogResponseMsg_Container_Request) ProtoMessage()    {}
 func (*LogResponseMsg_Container_Request) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{12, 0, 0}
+	return fileDescriptor_48eceea7e2abc593, []int{19, 0, 0}
 }
 
 func (m *LogResponseMsg_Container_Request) XXX_Unmarshal(b []byte) error {
@@ -1281,7 +1959,7 @@ func (m *LogResponseMsg_Container_Request_Line) Reset()         { *m = LogRespon
 func (m *LogResponseMsg_Container_Request_Line) String() string { return proto.CompactTextString(m) }
 func (*LogResponseMsg_Container_Request_Line) ProtoMessage()    {}
 func (*LogResponseMsg_Container_Request_Line) Descriptor() ([]byte, []int) {
-	return fileDescriptor_48eceea7e2abc593, []int{12, 0, 0, 0}
+	return fileDescriptor_48eceea7e2abc593, []int{19, 0, 0, 0}
 }
 
 func (m *LogResponseMsg_Container_Request_Line) XXX_Unmarshal(b []byte) error {
@@ -1323,17 +2001,287 @@ func (m *LogResponseMsg_Container_Request_Line) GetData() []byte {
 	return nil
 }
 
+// Protocol version and optional features of an LB or pure runner, exchanged with
+// the Capabilities call
+type Capabilities struct {
+	ProtocolVersion int32    `protobuf:"varint,1,opt,name=protocol_version,json=protocolVersion,proto3" json:"protocol_version,omitempty"`
+	Features        []string `protobuf:"bytes,2,rep,name=features,proto3" json:"features,omitempty"`
+	// gRPC compressors accepted for engagement streams
+	Compressors []string `protobuf:"bytes,3,rep,name=compressors,proto3" json:"compressors,omitempty"`
+	// largest data frame accepted, 0 if not limited
+	MaxDataChunk int64 `protobuf:"varint,4,opt,name=max_data_chunk,json=maxDataChunk,proto3" json:"max_data_chunk,omitempty"`
+	// labels describing the runner, eg. its zone or hardware, matched against the
+	// placement constraints of functions
+	Labels               map[string]string `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *Capabilities) Reset()         { *m = Capabilities{} }
+func (m *Capabilities) String() string { return proto.CompactTextString(m) }
+func (*Capabilities) ProtoMessage()    {}
+func (*Capabilities) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{20}
+}
+
+func (m *Capabilities) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Capabilities.Unmarshal(m, b)
+}
+func (m *Capabilities) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Capabilities.Marshal(b, m, deterministic)
+}
+func (m *Capabilities) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Capabilities.Merge(m, src)
+}
+func (m *Capabilities) XXX_Size() int {
+	return xxx_messageInfo_Capabilities.Size(m)
+}
+func (m *Capabilities) XXX_DiscardUnknown() {
+	xxx_messageInfo_Capabilities.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Capabilities proto.InternalMessageInfo
+
+func (m *Capabilities) GetProtocolVersion() int32 {
+	if m != nil {
+		return m.ProtocolVersion
+	}
+	return 0
+}
+
+func (m *Capabilities) GetFeatures() []string {
+	if m != nil {
+		return m.Features
+	}
+	return nil
+}
+
+func (m *Capabilities) GetCompressors() []string {
+	if m != nil {
+		return m.Compressors
+	}
+	return nil
+}
+
+func (m *Capabilities) GetMaxDataChunk() int64 {
+	if m != nil {
+		return m.MaxDataChunk
+	}
+	return 0
+}
+
+func (m *Capabilities) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+// Image that a runner pulls ahead of the calls of its functions, see PullImage
+type PullImageRequest struct {
+	Image                string   `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PullImageRequest) Reset()         { *m = PullImageRequest{} }
+func (m *PullImageRequest) String() string { return proto.CompactTextString(m) }
+func (*PullImageRequest) ProtoMessage()    {}
+func (*PullImageRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{21}
+}
+
+func (m *PullImageRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PullImageRequest.Unmarshal(m, b)
+}
+func (m *PullImageRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PullImageRequest.Marshal(b, m, deterministic)
+}
+func (m *PullImageRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PullImageRequest.Merge(m, src)
+}
+func (m *PullImageRequest) XXX_Size() int {
+	return xxx_messageInfo_PullImageRequest.Size(m)
+}
+func (m *PullImageRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PullImageRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PullImageRequest proto.InternalMessageInfo
+
+func (m *PullImageRequest) GetImage() string {
+	if m != nil {
+		return m.Image
+	}
+	return ""
+}
+
+type PullImageResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PullImageResponse) Reset()         { *m = PullImageResponse{} }
+func (m *PullImageResponse) String() string { return proto.CompactTextString(m) }
+func (*PullImageResponse) ProtoMessage()    {}
+func (*PullImageResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{22}
+}
+
+func (m *PullImageResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PullImageResponse.Unmarshal(m, b)
+}
+func (m *PullImageResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PullImageResponse.Marshal(b, m, deterministic)
+}
+func (m *PullImageResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PullImageResponse.Merge(m, src)
+}
+func (m *PullImageResponse) XXX_Size() int {
+	return xxx_messageInfo_PullImageResponse.Size(m)
+}
+func (m *PullImageResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_PullImageResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PullImageResponse proto.InternalMessageInfo
+
+// Profile of a hot container of a function that a runner collects, see ProfileContainer
+type ProfileContainerRequest struct {
+	FnId string `protobuf:"bytes,1,opt,name=fnId,proto3" json:"fnId,omitempty"`
+	// cpu or heap
+	Type string `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	// how long a cpu profile lasts
+	Seconds              int32    `protobuf:"varint,3,opt,name=seconds,proto3" json:"seconds,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ProfileContainerRequest) Reset()         { *m = ProfileContainerRequest{} }
+func (m *ProfileContainerRequest) String() string { return proto.CompactTextString(m) }
+func (*ProfileContainerRequest) ProtoMessage()    {}
+func (*ProfileContainerRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{23}
+}
+
+func (m *ProfileContainerRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ProfileContainerRequest.Unmarshal(m, b)
+}
+func (m *ProfileContainerRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ProfileContainerRequest.Marshal(b, m, deterministic)
+}
+func (m *ProfileContainerRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ProfileContainerRequest.Merge(m, src)
+}
+func (m *ProfileContainerRequest) XXX_Size() int {
+	return xxx_messageInfo_ProfileContainerRequest.Size(m)
+}
+func (m *ProfileContainerRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ProfileContainerRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ProfileContainerRequest proto.InternalMessageInfo
+
+func (m *ProfileContainerRequest) GetFnId() string {
+	if m != nil {
+		return m.FnId
+	}
+	return ""
+}
+
+func (m *ProfileContainerRequest) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *ProfileContainerRequest) GetSeconds() int32 {
+	if m != nil {
+		return m.Seconds
+	}
+	return 0
+}
+
+type ProfileContainerResponse struct {
+	ContainerId          string   `protobuf:"bytes,1,opt,name=containerId,proto3" json:"containerId,omitempty"`
+	ContentType          string   `protobuf:"bytes,2,opt,name=contentType,proto3" json:"contentType,omitempty"`
+	Profile              []byte   `protobuf:"bytes,3,opt,name=profile,proto3" json:"profile,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ProfileContainerResponse) Reset()         { *m = ProfileContainerResponse{} }
+func (m *ProfileContainerResponse) String() string { return proto.CompactTextString(m) }
+func (*ProfileContainerResponse) ProtoMessage()    {}
+func (*ProfileContainerResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_48eceea7e2abc593, []int{24}
+}
+
+func (m *ProfileContainerResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ProfileContainerResponse.Unmarshal(m, b)
+}
+func (m *ProfileContainerResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ProfileContainerResponse.Marshal(b, m, deterministic)
+}
+func (m *ProfileContainerResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ProfileContainerResponse.Merge(m, src)
+}
+func (m *ProfileContainerResponse) XXX_Size() int {
+	return xxx_messageInfo_ProfileContainerResponse.Size(m)
+}
+func (m *ProfileContainerResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ProfileContainerResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ProfileContainerResponse proto.InternalMessageInfo
+
+func (m *ProfileContainerResponse) GetContainerId() string {
+	if m != nil {
+		return m.ContainerId
+	}
+	return ""
+}
+
+func (m *ProfileContainerResponse) GetContentType() string {
+	if m != nil {
+		return m.ContentType
+	}
+	return ""
+}
+
+func (m *ProfileContainerResponse) GetProfile() []byte {
+	if m != nil {
+		return m.Profile
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("LogResponseMsg_Container_Request_Line_Source", LogResponseMsg_Container_Request_Line_Source_name, LogResponseMsg_Container_Request_Line_Source_value)
 	proto.RegisterType((*TryCall)(nil), "TryCall")
 	proto.RegisterMapType((map[string]string)(nil), "TryCall.ExtensionsEntry")
+	proto.RegisterType((*CallModel)(nil), "CallModel")
+	proto.RegisterMapType((map[string][]byte)(nil), "CallModel.AnnotationsEntry")
+	proto.RegisterMapType((map[string]string)(nil), "CallModel.ConfigEntry")
 	proto.RegisterType((*DataFrame)(nil), "DataFrame")
 	proto.RegisterType((*HttpHeader)(nil), "HttpHeader")
 	proto.RegisterType((*HttpRespMeta)(nil), "HttpRespMeta")
 	proto.RegisterType((*CallResultStart)(nil), "CallResultStart")
 	proto.RegisterType((*CallFinished)(nil), "CallFinished")
+	proto.RegisterType((*DetachedResponse)(nil), "DetachedResponse")
+	proto.RegisterType((*DataAck)(nil), "DataAck")
 	proto.RegisterType((*ClientMsg)(nil), "ClientMsg")
+	proto.RegisterType((*CallCancel)(nil), "CallCancel")
+	proto.RegisterType((*LogFrame)(nil), "LogFrame")
 	proto.RegisterType((*RunnerMsg)(nil), "RunnerMsg")
+	proto.RegisterType((*InvokeRequest)(nil), "InvokeRequest")
+	proto.RegisterType((*InvokeResponse)(nil), "InvokeResponse")
 	proto.RegisterType((*RunnerStatus)(nil), "RunnerStatus")
 	proto.RegisterMapType((map[string]string)(nil), "RunnerStatus.CustomStatusEntry")
 	proto.RegisterType((*ConfigMsg)(nil), "ConfigMsg")
@@ -1348,95 +2296,164 @@ func init() {
 	proto.RegisterType((*LogResponseMsg_Container)(nil), "LogResponseMsg.Container")
 	proto.RegisterType((*LogResponseMsg_Container_Request)(nil), "LogResponseMsg.Container.Request")
 	proto.RegisterType((*LogResponseMsg_Container_Request_Line)(nil), "LogResponseMsg.Container.Request.Line")
+	proto.RegisterType((*Capabilities)(nil), "Capabilities")
+	proto.RegisterMapType((map[string]string)(nil), "Capabilities.LabelsEntry")
+	proto.RegisterType((*PullImageRequest)(nil), "PullImageRequest")
+	proto.RegisterType((*PullImageResponse)(nil), "PullImageResponse")
+	proto.RegisterType((*ProfileContainerRequest)(nil), "ProfileContainerRequest")
+	proto.RegisterType((*ProfileContainerResponse)(nil), "ProfileContainerResponse")
 }
 
 func init() { proto.RegisterFile("runner.proto", fileDescriptor_48eceea7e2abc593) }
 
 var fileDescriptor_48eceea7e2abc593 = []byte{
-	// 1326 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x57, 0x4f, 0x8f, 0xdb, 0x44,
-	0x14, 0x5f, 0xc7, 0x89, 0x93, 0xbc, 0xfc, 0xdd, 0xa1, 0x5d, 0x8c, 0xa9, 0x68, 0x08, 0xa5, 0x8a,
-	0x60, 0xeb, 0xd2, 0xa5, 0x95, 0x4a, 0x25, 0x40, 0x25, 0xbb, 0x55, 0x8a, 0x5a, 0x5a, 0x4d, 0xb6,
-	0x70, 0x5c, 0xcd, 0xda, 0xb3, 0x89, 0x89, 0x63, 0x87, 0x99, 0xf1, 0xd2, 0x95, 0x38, 0x70, 0x83,
-	0xaf, 0xc0, 0x05, 0x89, 0x23, 0x77, 0x3e, 0x07, 0x47, 0x3e, 0x0a, 0x67, 0x34, 0x7f, 0xe2, 0xfc,
-	0xdb, 0x6e, 0xbb, 0x12, 0x37, 0xbf, 0xdf, 0xef, 0xcd, 0xbc, 0x37, 0xcf, 0xbf, 0xf7, 0x3c, 0x86,
-	0x3a, 0xcb, 0x92, 0x84, 0x32, 0x7f, 0xc6, 0x52, 0x91, 0x7a, 0xef, 0x8e, 0xd2, 0x74, 0x14, 0xd3,
-	0xdb, 0xca, 0x3a, 0xce, 0x4e, 0x6e, 0xd3, 0xe9, 0x4c, 0x9c, 0x19, 0xf2, 0xda, 0x3a, 0xc9, 0x05,
-	0xcb, 0x02, 0xa1, 0xd9, 0xee, 0xdf, 0x16, 0x94, 0x0f, 0xd9, 0x59, 0x9f, 0xc4, 0x31, 0xea, 0x41,
-	0x7b, 0x9a, 0x86, 0x34, 0xe6, 0x47, 0x01, 0x89, 0xe3, 0xa3, 0xef, 0x79, 0x9a, 0xb8, 0x56, 0xc7,
-	0xea, 0x55, 0x71, 0x53, 0xe3, 0xd2, 0xeb, 0x6b, 0x9e, 0x26, 0xa8, 0x03, 0x75, 0x1e, 0xa7, 0xe2,
-	0x68, 0x4c, 0xf8, 0xf8, 0x28, 0x0a, 0xdd, 0x82, 0xf2, 0x02, 0x89, 0x0d, 0x08, 0x1f, 0x3f, 0x0e,
-	0xd1, 0x7d, 0x00, 0xfa, 0x52, 0xd0, 0x84, 0x47, 0x69, 0xc2, 0x5d, 0xbb, 0x63, 0xf7, 0x6a, 0x7b,
-	0xae, 0x6f, 0x22, 0xf9, 0x07, 0x39, 0x75, 0x90, 0x08, 0x76, 0x86, 0x97, 0x7c, 0xbd, 0xcf, 0xa1,
-	0xb5, 0x46, 0xa3, 0x36, 0xd8, 0x13, 0x7a, 0x66, 0x72, 0x91, 0x8f, 0xe8, 0x0a, 0x94, 0x4e, 0x49,
-	0x9c, 0x51, 0x13, 0x59, 0x1b, 0x0f, 0x0a, 0xf7, 0xad, 0xee, 0x1d, 0xa8, 0xee, 0x13, 0x41, 0x1e,
-	0x31, 0x32, 0xa5, 0x08, 0x41, 0x31, 0x24, 0x82, 0xa8, 0x95, 0x75, 0xac, 0x9e, 0xe5, 0x66, 0x34,
-	0x3d, 0x51, 0x0b, 0x2b, 0x58, 0x3e, 0x76, 0xef, 0x02, 0x0c, 0x84, 0x98, 0x0d, 0x28, 0x09, 0x29,
-	0x7b, 0xd3, 0x60, 0xdd, 0x6f, 0xa1, 0x2e, 0x57, 0x61, 0xca, 0x67, 0x4f, 0xa9, 0x20, 0xe8, 0x3a,
-	0xd4, 0xb8, 0x20, 0x22, 0xe3, 0x47, 0x41, 0x1a, 0x52, 0xb5, 0xbe, 0x84, 0x41, 0x43, 0xfd, 0x34,
-	0xa4, 0xe8, 0x43, 0x28, 0x8f, 0x55, 0x08, 0xee, 0x16, 0x54, 0x3d, 0x6a, 0xfe, 0x22, 0x2c, 0x9e,
-	0x73, 0xdd, 0x2f, 0xa0, 0x25, 0x6b, 0x84, 0x29, 0xcf, 0x62, 0x31, 0x14, 0x84, 0x09, 0xf4, 0x01,
-	0x14, 0xc7, 0x42, 0xcc, 0xdc, 0xb0, 0x63, 0xf5, 0x6a, 0x7b, 0x0d, 0x7f, 0x39, 0xee, 0x60, 0x0b,
-	0x2b, 0xf2, 0x2b, 0x07, 0x8a, 0x53, 0x2a, 0x48, 0xf7, 0xf7, 0x22, 0xd4, 0xe5, 0x06, 0x8f, 0xa2,
-	0x24, 0xe2, 0x63, 0x1a, 0x22, 0x17, 0xca, 0x3c, 0x0b, 0x02, 0xca, 0xb9, 0x4a, 0xaa, 0x82, 0xe7,
-	0xa6, 0x64, 0x42, 0x2a, 0x48, 0x14, 0x73, 0x73, 0xb4, 0xb9, 0x89, 0xae, 0x41, 0x95, 0x32, 0x96,
-	0x32, 0x99, 0xb8, 0x6b, 0xab, 0xa3, 0x2c, 0x00, 0xe4, 0x41, 0x45, 0x19, 0x43, 0xc1, 0xdc, 0xa2,
-	0x5a, 0x98, 0xdb, 0x72, 0x65, 0xc0, 0x28, 0x11, 0x34, 0x7c, 0x28, 0xdc, 0x92, 0x22, 0x17, 0x80,
-	0x64, 0xb9, 0x3c, 0x92, 0x62, 0x1d, 0xcd, 0xe6, 0x00, 0xea, 0x40, 0x2d, 0x48, 0xa7, 0xb3, 0x98,
-	0x6a, 0xbe, 0xac, 0xf8, 0x65, 0x08, 0xed, 0xc2, 0x36, 0x0f, 0xc6, 0x34, 0xcc, 0x62, 0xca, 0xf6,
-	0x33, 0x46, 0x44, 0x94, 0x26, 0x6e, 0xa5, 0x63, 0xf5, 0x6c, 0xbc, 0x49, 0x48, 0x6f, 0xfa, 0x92,
-	0x06, 0x99, 0x34, 0x72, 0xef, 0xaa, 0xf6, 0xde, 0x20, 0xf2, 0x33, 0xbf, 0xe0, 0x94, 0xb9, 0xa0,
-	0x2a, 0xb5, 0x00, 0xa4, 0x08, 0xa2, 0x29, 0x19, 0x51, 0xb7, 0xa6, 0x45, 0xa0, 0x0c, 0x74, 0x17,
-	0xae, 0xaa, 0x87, 0xe7, 0x59, 0x1c, 0x7f, 0x47, 0x22, 0x91, 0x47, 0xa9, 0xab, 0x28, 0xe7, 0x93,
-	0xa8, 0x07, 0xad, 0x40, 0xb0, 0xe7, 0x8c, 0xce, 0x72, 0xff, 0x86, 0xf2, 0x5f, 0x87, 0xe5, 0x09,
-	0x02, 0xc1, 0xfa, 0xaa, 0x7e, 0xb9, 0x6f, 0x53, 0x9f, 0x60, 0x83, 0x40, 0x37, 0xa0, 0x11, 0x25,
-	0x91, 0x16, 0xcd, 0x61, 0x34, 0xa5, 0x6e, 0x4b, 0x79, 0xae, 0x82, 0xdd, 0x21, 0x54, 0xfb, 0x71,
-	0x44, 0x13, 0xf1, 0x94, 0x8f, 0xd0, 0x35, 0xb0, 0x05, 0xd3, 0x6a, 0xaf, 0xed, 0x55, 0xe6, 0x0d,
-	0x3a, 0xd8, 0xc2, 0x12, 0x46, 0x1d, 0xd3, 0x3f, 0x05, 0x45, 0x83, 0x9f, 0x77, 0x96, 0x54, 0x9d,
-	0x64, 0xa4, 0xea, 0x8e, 0xd3, 0xf0, 0xac, 0xfb, 0x9b, 0x05, 0x55, 0xac, 0x66, 0x92, 0xdc, 0xf5,
-	0x1e, 0xd4, 0x99, 0xd2, 0xef, 0x91, 0x7a, 0xb9, 0x66, 0xfb, 0xb6, 0xbf, 0x26, 0xec, 0xc1, 0x16,
-	0xae, 0xb1, 0x25, 0x9d, 0xbf, 0x36, 0x1c, 0xfa, 0x18, 0x2a, 0x27, 0x46, 0xd7, 0x4a, 0x96, 0xb2,
-	0x1b, 0x96, 0xc5, 0x3e, 0xd8, 0xc2, 0xb9, 0x43, 0x9e, 0xdb, 0x3f, 0x0e, 0xd4, 0x75, 0x6e, 0x43,
-	0xd5, 0x8d, 0x68, 0x07, 0x1c, 0x12, 0x88, 0xe8, 0x54, 0x77, 0x74, 0x09, 0x1b, 0x4b, 0xe2, 0x27,
-	0x24, 0x8a, 0xcd, 0xde, 0x15, 0x6c, 0x2c, 0xd4, 0x84, 0x42, 0x14, 0x1a, 0xa5, 0x17, 0xa2, 0x70,
-	0xb9, 0x6f, 0x4a, 0x17, 0xf4, 0x8d, 0x73, 0x51, 0xdf, 0x94, 0x2f, 0xea, 0x9b, 0xca, 0x85, 0x7d,
-	0x53, 0x7d, 0x4d, 0xdf, 0xc0, 0x66, 0xdf, 0xec, 0x80, 0x13, 0x10, 0xd9, 0x1f, 0x4a, 0xbe, 0x15,
-	0x6c, 0x2c, 0xf4, 0x11, 0xb4, 0x19, 0xfd, 0x21, 0xa3, 0x5c, 0x70, 0x4c, 0x03, 0x1a, 0x9d, 0xd2,
-	0x50, 0x49, 0xb7, 0x88, 0x37, 0x70, 0xa9, 0xda, 0x39, 0x36, 0x20, 0x49, 0x28, 0xcb, 0xd4, 0x50,
-	0xae, 0xeb, 0x30, 0xea, 0x42, 0x7d, 0x12, 0x66, 0xd3, 0x19, 0x7f, 0x96, 0xec, 0x47, 0x7c, 0xa2,
-	0x04, 0x5b, 0xc4, 0x2b, 0xd8, 0xf9, 0x9d, 0xdc, 0xba, 0x54, 0x27, 0xb7, 0x5f, 0xd5, 0xc9, 0xbb,
-	0xb0, 0x1d, 0xf1, 0x6f, 0xa8, 0xf8, 0x31, 0x65, 0x93, 0xfd, 0x88, 0x93, 0x63, 0x99, 0xeb, 0xb6,
-	0x3a, 0xf8, 0x26, 0x81, 0xfa, 0x50, 0x0f, 0x32, 0x2e, 0xd2, 0xa9, 0x56, 0x87, 0x8b, 0xd4, 0x70,
-	0xbe, 0xee, 0x2f, 0x4b, 0xc6, 0xef, 0x2f, 0x79, 0xe8, 0x6f, 0xd6, 0xca, 0xa2, 0x57, 0x0f, 0x82,
-	0xb7, 0x2e, 0x39, 0x08, 0xae, 0x5c, 0x62, 0x10, 0x5c, 0x7d, 0xe3, 0x41, 0xb0, 0x73, 0xce, 0x20,
-	0xf0, 0xbe, 0x84, 0xed, 0x8d, 0x63, 0x5d, 0xea, 0x5b, 0x7b, 0x0a, 0xd5, 0x7e, 0x9a, 0x9c, 0x44,
-	0x23, 0xd9, 0xf3, 0x3e, 0x38, 0x81, 0x32, 0x5c, 0x4b, 0x15, 0x70, 0xc7, 0xcf, 0x39, 0xf3, 0xa4,
-	0xeb, 0x66, 0xbc, 0xbc, 0xcf, 0xa0, 0xb6, 0x04, 0x5f, 0x2a, 0x6e, 0x13, 0xea, 0x7a, 0xa9, 0x4e,
-	0xbc, 0xfb, 0x67, 0x01, 0x1a, 0x4f, 0xd2, 0x11, 0xd6, 0x32, 0x94, 0xc9, 0xec, 0x42, 0x69, 0x79,
-	0xf2, 0x5c, 0xf1, 0x57, 0x68, 0x7f, 0x3e, 0x7d, 0xb4, 0x13, 0xba, 0x09, 0x36, 0x09, 0x26, 0x66,
-	0xec, 0xa0, 0x35, 0xdf, 0x87, 0xc1, 0x44, 0x8e, 0x43, 0x12, 0x48, 0xcd, 0x96, 0x18, 0x25, 0xe1,
-	0x99, 0x19, 0x3d, 0xeb, 0xbb, 0x62, 0xc9, 0xc9, 0x5d, 0x95, 0x93, 0xf7, 0x13, 0x94, 0xf4, 0x58,
-	0xbb, 0xbf, 0x56, 0x99, 0xce, 0x79, 0xd9, 0xfc, 0xcf, 0x35, 0xf2, 0x4a, 0x60, 0x3f, 0x0c, 0x26,
-	0x5e, 0x19, 0x4a, 0x2a, 0xad, 0x7c, 0x18, 0xfe, 0x6b, 0x43, 0x53, 0x85, 0xe7, 0xb3, 0x34, 0xe1,
-	0x54, 0x16, 0xeb, 0x56, 0x7e, 0x4b, 0x92, 0xd9, 0xbd, 0xe3, 0xaf, 0xd2, 0x32, 0x31, 0x41, 0xa2,
-	0x84, 0x32, 0x3d, 0x83, 0xbd, 0xbf, 0x6c, 0xf5, 0xda, 0x35, 0x26, 0xa5, 0x46, 0x66, 0xb3, 0x38,
-	0x0a, 0x94, 0xf2, 0x1e, 0x87, 0x26, 0xbb, 0x55, 0x10, 0xbd, 0x07, 0x70, 0x92, 0x25, 0x81, 0x71,
-	0x31, 0xd7, 0xc5, 0x05, 0xa2, 0x27, 0x98, 0xd9, 0xf2, 0xb1, 0x1e, 0xbf, 0x6a, 0x82, 0xe5, 0x10,
-	0xba, 0x67, 0x92, 0x2c, 0xaa, 0x24, 0xdf, 0x7f, 0x65, 0x92, 0xbe, 0x29, 0xac, 0x49, 0xf6, 0x97,
-	0x02, 0x94, 0x0d, 0x22, 0x87, 0xa8, 0x99, 0x54, 0x79, 0x9a, 0x0b, 0x00, 0x3d, 0xc8, 0x3f, 0x3e,
-	0x32, 0xc0, 0xcd, 0xd7, 0x06, 0xf0, 0x9f, 0x44, 0x09, 0x35, 0x51, 0xfe, 0xb0, 0xa0, 0x28, 0x4d,
-	0x19, 0x42, 0x44, 0x53, 0xca, 0x05, 0x99, 0xce, 0x54, 0x08, 0x1b, 0x2f, 0x00, 0x74, 0x00, 0x0e,
-	0x4f, 0x33, 0x16, 0xe8, 0xd7, 0xd5, 0xdc, 0xbb, 0xf5, 0x66, 0x41, 0xfc, 0xa1, 0x5a, 0x84, 0xcd,
-	0xe2, 0xfc, 0x56, 0x6b, 0x2f, 0x6e, 0xb5, 0xdd, 0x0e, 0x38, 0xda, 0x0b, 0x01, 0x38, 0xc3, 0xc3,
-	0xfd, 0x67, 0x2f, 0x0e, 0xdb, 0x5b, 0xe6, 0xf9, 0x00, 0xe3, 0xb6, 0xb5, 0xf7, 0x73, 0x01, 0x9a,
-	0x7a, 0xa4, 0x3d, 0x97, 0x37, 0xff, 0x20, 0x8d, 0xd1, 0x0d, 0x70, 0x0e, 0x92, 0x91, 0xbc, 0xc7,
-	0x80, 0x9f, 0x5f, 0x09, 0x3c, 0xf0, 0xf3, 0x0f, 0x79, 0xcf, 0xfa, 0xc4, 0x42, 0x77, 0xc1, 0x99,
-	0x7f, 0x37, 0x7d, 0xfd, 0x2f, 0xe1, 0xcf, 0xff, 0x25, 0xfc, 0x03, 0xf9, 0xa3, 0xe1, 0x35, 0x56,
-	0x66, 0x65, 0xd7, 0xfe, 0xb5, 0x60, 0xa1, 0x5d, 0x68, 0x69, 0xe9, 0x66, 0x8c, 0x6a, 0x56, 0x06,
-	0x99, 0x4f, 0x04, 0xaf, 0xe1, 0x2f, 0x77, 0x30, 0xba, 0x03, 0x30, 0x14, 0x8c, 0x92, 0xe9, 0x93,
-	0x74, 0xc4, 0x51, 0x73, 0xb5, 0x41, 0xbc, 0xd6, 0x5a, 0x9d, 0x54, 0x5a, 0x77, 0xa0, 0xac, 0x17,
-	0xef, 0xa1, 0xb7, 0x37, 0xf2, 0x1a, 0xaa, 0x7f, 0x9c, 0xb5, 0xc4, 0x8e, 0x1d, 0xc5, 0x7f, 0xfa,
-	0x5f, 0x00, 0x00, 0x00, 0xff, 0xff, 0x64, 0x4e, 0xf9, 0x4b, 0x3e, 0x0d, 0x00, 0x00,
+	// 2327 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x58, 0xcf, 0x72, 0x23, 0xb7,
+	0xd1, 0x17, 0xff, 0x8a, 0x6c, 0x52, 0x14, 0x05, 0x69, 0xb5, 0xb3, 0xf4, 0x7a, 0x4d, 0xf3, 0x5b,
+	0xfb, 0xa3, 0x93, 0xf5, 0xd8, 0x2b, 0xaf, 0x53, 0x8e, 0xab, 0x6c, 0xd7, 0x46, 0x92, 0x23, 0xa5,
+	0x76, 0xed, 0x2d, 0x48, 0xb6, 0x0f, 0x39, 0xb0, 0xa0, 0x19, 0x90, 0x1a, 0x6b, 0x38, 0x33, 0x01,
+	0x30, 0xb2, 0xe8, 0xca, 0x3d, 0xa9, 0xca, 0x0b, 0xe4, 0x9a, 0x43, 0x0e, 0xb9, 0xa7, 0x52, 0x79,
+	0x12, 0x9f, 0xf2, 0x1c, 0x39, 0xa7, 0x1a, 0xc0, 0x0c, 0x87, 0xa4, 0xb4, 0xbb, 0xaa, 0xe4, 0x36,
+	0xfd, 0xeb, 0x6e, 0xa0, 0x81, 0x6e, 0xfc, 0x1a, 0x18, 0x68, 0x8b, 0x34, 0x8a, 0xb8, 0x70, 0x13,
+	0x11, 0xab, 0xb8, 0xf7, 0xc6, 0x24, 0x8e, 0x27, 0x21, 0xff, 0x40, 0x4b, 0x67, 0xe9, 0xf8, 0x03,
+	0x3e, 0x4d, 0xd4, 0xcc, 0x2a, 0xef, 0x2f, 0x2b, 0xa5, 0x12, 0xa9, 0xa7, 0xac, 0xf6, 0xae, 0xd5,
+	0x8a, 0xc4, 0xfb, 0x40, 0x2a, 0xa6, 0x52, 0x69, 0x14, 0x83, 0x7f, 0x55, 0x60, 0xfd, 0x54, 0xcc,
+	0xf6, 0x59, 0x18, 0x92, 0x21, 0x74, 0xa7, 0xb1, 0xcf, 0x43, 0x39, 0xf2, 0x58, 0x18, 0x8e, 0xbe,
+	0x97, 0x71, 0xe4, 0x94, 0xfa, 0xa5, 0x61, 0x93, 0x76, 0x0c, 0x8e, 0x56, 0xbf, 0x91, 0x71, 0x44,
+	0xfa, 0xd0, 0x96, 0x61, 0xac, 0x46, 0xe7, 0x4c, 0x9e, 0x8f, 0x02, 0xdf, 0x29, 0x6b, 0x2b, 0x40,
+	0xec, 0x88, 0xc9, 0xf3, 0x63, 0x9f, 0x7c, 0x02, 0xc0, 0xaf, 0x14, 0x8f, 0x64, 0x10, 0x47, 0xd2,
+	0xa9, 0xf4, 0x2b, 0xc3, 0xd6, 0x9e, 0xe3, 0xda, 0x99, 0xdc, 0xc3, 0x5c, 0x75, 0x18, 0x29, 0x31,
+	0xa3, 0x05, 0x5b, 0xf2, 0x21, 0xec, 0x5c, 0x72, 0x11, 0x8c, 0x67, 0x23, 0xc1, 0x65, 0x12, 0x47,
+	0x92, 0xeb, 0x69, 0x9c, 0x6a, 0xbf, 0x34, 0x6c, 0x50, 0x62, 0x74, 0xd4, 0xaa, 0x70, 0x36, 0xf2,
+	0x04, 0x76, 0x97, 0x3d, 0x3c, 0xe1, 0x7d, 0xb4, 0xe7, 0x39, 0x35, 0xed, 0xb3, 0xb3, 0xe8, 0xb3,
+	0xaf, 0x75, 0xe4, 0xff, 0x61, 0x93, 0x5f, 0x71, 0x2f, 0x55, 0x41, 0x1c, 0x8d, 0x54, 0x7c, 0xc1,
+	0x23, 0xa7, 0x6e, 0x16, 0x9b, 0xc3, 0xa7, 0x88, 0x92, 0x07, 0x50, 0xc5, 0xfd, 0x70, 0xd6, 0xfb,
+	0xa5, 0x61, 0x6b, 0x0f, 0x5c, 0x5c, 0xc1, 0x73, 0xdc, 0x0f, 0xaa, 0x71, 0x1c, 0x28, 0x9f, 0xf7,
+	0x87, 0x20, 0xf2, 0xe3, 0x1f, 0x9c, 0x46, 0xbf, 0x34, 0xac, 0xd0, 0x4e, 0x06, 0x7f, 0xa7, 0x51,
+	0xb2, 0x07, 0x77, 0x7c, 0xae, 0x98, 0x77, 0xce, 0xfd, 0x79, 0xa4, 0x53, 0x76, 0xe5, 0x34, 0xb5,
+	0xf9, 0x76, 0xa6, 0xcc, 0x02, 0x7d, 0xce, 0xae, 0x7a, 0x9f, 0xc1, 0xe6, 0xd2, 0x66, 0x91, 0x2e,
+	0x54, 0x2e, 0xf8, 0xcc, 0x66, 0x06, 0x3f, 0xc9, 0x0e, 0xd4, 0x2e, 0x59, 0x98, 0x72, 0x9b, 0x07,
+	0x23, 0x7c, 0x5a, 0xfe, 0xa4, 0x34, 0xf8, 0xc7, 0x3a, 0x34, 0xf3, 0x78, 0x49, 0x07, 0xca, 0x81,
+	0x6f, 0x1d, 0xcb, 0x81, 0x4f, 0x76, 0xa1, 0x6e, 0x8a, 0xc1, 0x3a, 0x5a, 0x09, 0xc7, 0x0b, 0xa6,
+	0x6c, 0xc2, 0x9d, 0x8a, 0x19, 0x4f, 0x0b, 0x88, 0xfa, 0x3c, 0x64, 0x33, 0x9d, 0x89, 0x1a, 0x35,
+	0x02, 0x21, 0x50, 0x55, 0xb3, 0x84, 0xeb, 0xad, 0x6e, 0x52, 0xfd, 0x4d, 0x1c, 0x58, 0x4f, 0xd8,
+	0x2c, 0x8c, 0x99, 0x6f, 0xb7, 0x34, 0x13, 0x31, 0xf6, 0x54, 0x98, 0xad, 0x6c, 0x52, 0xfc, 0xc4,
+	0x18, 0xa6, 0x5c, 0x9d, 0xc7, 0xbe, 0xde, 0xb4, 0x26, 0xb5, 0x12, 0x8e, 0xa1, 0x82, 0x29, 0x8f,
+	0x53, 0xa5, 0xb7, 0xa7, 0x46, 0x33, 0x91, 0xbc, 0x0d, 0xed, 0xc0, 0x0f, 0xf9, 0x28, 0x53, 0x83,
+	0x56, 0xb7, 0x10, 0x3b, 0xb5, 0x26, 0x6f, 0x02, 0xa8, 0x69, 0x32, 0x96, 0x23, 0x19, 0xfc, 0xc8,
+	0x9d, 0x56, 0xbf, 0x34, 0xdc, 0xa0, 0x4d, 0x8d, 0x9c, 0x04, 0x3f, 0x72, 0x33, 0xe7, 0x34, 0x16,
+	0x33, 0xa7, 0xdd, 0x2f, 0x0d, 0xab, 0xd4, 0x4a, 0xb8, 0x16, 0x2f, 0x49, 0xa5, 0xb3, 0xa1, 0x51,
+	0xfd, 0x4d, 0x5c, 0xa8, 0x7b, 0x71, 0x34, 0x0e, 0x26, 0x4e, 0x47, 0x17, 0xf1, 0xee, 0x3c, 0xff,
+	0xee, 0xbe, 0x56, 0x98, 0x12, 0xb6, 0x56, 0xe4, 0x33, 0x68, 0xb1, 0x28, 0x8a, 0x15, 0x53, 0xba,
+	0xf2, 0x37, 0xb5, 0xd3, 0x1b, 0x05, 0xa7, 0xa7, 0x73, 0xad, 0xf1, 0x2c, 0xda, 0x93, 0x77, 0x60,
+	0xfd, 0x9c, 0x33, 0x9f, 0x0b, 0xe9, 0x74, 0xb5, 0x6b, 0xcb, 0x3d, 0x52, 0x2a, 0x39, 0xd2, 0x18,
+	0xcd, 0x74, 0xb8, 0x40, 0x39, 0x93, 0x61, 0x3c, 0x19, 0xe1, 0x76, 0x6e, 0xe9, 0x9d, 0x6b, 0x1a,
+	0xe4, 0x1b, 0x11, 0x92, 0xf7, 0x81, 0xcc, 0x6b, 0xdb, 0x4f, 0x85, 0x1e, 0xdc, 0x21, 0xba, 0xcc,
+	0xb6, 0x72, 0xcd, 0x81, 0x55, 0x60, 0x66, 0xb9, 0x10, 0xb1, 0x70, 0xb6, 0x4d, 0xbe, 0xb5, 0x40,
+	0xee, 0x40, 0x9d, 0x25, 0x09, 0x1e, 0xef, 0x1d, 0x03, 0xb3, 0x24, 0x39, 0xf6, 0xc9, 0x3d, 0x68,
+	0x20, 0x1c, 0xb1, 0x29, 0x77, 0xee, 0x98, 0xec, 0xb2, 0x24, 0xf9, 0x8a, 0x4d, 0xb9, 0xde, 0x76,
+	0x11, 0x4c, 0x26, 0x5c, 0xa0, 0xd7, 0xae, 0x89, 0xca, 0x22, 0xc7, 0x3e, 0xd9, 0x86, 0xda, 0x38,
+	0x42, 0xcd, 0x5d, 0x53, 0x2b, 0xe3, 0xe8, 0xd8, 0xd7, 0xd9, 0x8c, 0x02, 0x95, 0x67, 0xd3, 0xb1,
+	0xd9, 0x8c, 0x02, 0x95, 0x65, 0xb3, 0x0f, 0x2d, 0x2f, 0x8e, 0xbc, 0x54, 0x08, 0x1e, 0x79, 0x33,
+	0xe7, 0x9e, 0xce, 0x4e, 0x11, 0x22, 0x77, 0x61, 0x3d, 0x4b, 0x76, 0xcf, 0x64, 0xd4, 0x66, 0x7a,
+	0x08, 0x5d, 0xc1, 0x99, 0x3f, 0x8a, 0xa3, 0x70, 0x36, 0x12, 0x71, 0xac, 0xc6, 0xd2, 0x79, 0x43,
+	0x93, 0x42, 0x07, 0xf1, 0xaf, 0xa3, 0x70, 0x46, 0x35, 0x8a, 0xb9, 0x9f, 0x60, 0xee, 0xef, 0x9b,
+	0xdc, 0xe3, 0x77, 0xef, 0x97, 0xd0, 0x2a, 0xa4, 0xf8, 0x36, 0x07, 0xaf, 0xf7, 0x39, 0x74, 0x97,
+	0x13, 0xfd, 0x2a, 0xff, 0x76, 0xf1, 0xe0, 0x3e, 0x86, 0xe6, 0x01, 0x53, 0xec, 0x4b, 0x81, 0xfb,
+	0x4a, 0xa0, 0xea, 0x33, 0xc5, 0xb4, 0x67, 0x9b, 0xea, 0x6f, 0x1c, 0x8c, 0xc7, 0x63, 0xed, 0xd8,
+	0xa0, 0xf8, 0x39, 0x78, 0x02, 0x30, 0x2f, 0x95, 0xd7, 0x0d, 0x76, 0xf0, 0x2d, 0xb4, 0xd1, 0x0b,
+	0x39, 0xe7, 0x39, 0x57, 0x8c, 0xbc, 0x05, 0x2d, 0xc3, 0x02, 0x23, 0x2f, 0xf6, 0xb9, 0xf6, 0xaf,
+	0x51, 0x30, 0xd0, 0x7e, 0xec, 0xf3, 0x62, 0x85, 0x96, 0x6f, 0xae, 0xd0, 0xc1, 0xe7, 0xb0, 0x89,
+	0x35, 0x4f, 0xb9, 0x4c, 0x43, 0x75, 0xa2, 0x98, 0x50, 0xe4, 0xff, 0xa0, 0x7a, 0xae, 0x54, 0xe2,
+	0xf8, 0x9a, 0x48, 0x37, 0xdc, 0xe2, 0xbc, 0x47, 0x6b, 0x54, 0x2b, 0x7f, 0x55, 0x87, 0xea, 0x94,
+	0x2b, 0x36, 0xf8, 0x6b, 0x1d, 0xda, 0x38, 0xc0, 0x97, 0x41, 0x14, 0xc8, 0x73, 0xae, 0x09, 0x41,
+	0xa6, 0x9e, 0xc7, 0xa5, 0xd4, 0x41, 0x35, 0x68, 0x26, 0xa2, 0x06, 0xa9, 0x33, 0x08, 0x33, 0x1e,
+	0xcb, 0x44, 0x72, 0x1f, 0x9a, 0xba, 0x96, 0x31, 0x70, 0x4d, 0x66, 0x35, 0x3a, 0x07, 0x48, 0x0f,
+	0x1a, 0x5a, 0x38, 0x51, 0x42, 0x73, 0x5a, 0x93, 0xe6, 0x32, 0x7a, 0x7a, 0x82, 0x33, 0xc5, 0xfd,
+	0xa7, 0xca, 0x72, 0xdb, 0x1c, 0x40, 0xad, 0xc4, 0x25, 0x69, 0xad, 0xa1, 0xb8, 0x39, 0x60, 0xea,
+	0x75, 0x9a, 0x84, 0xdc, 0xe8, 0x0d, 0xd9, 0x15, 0x21, 0xf2, 0x08, 0xb6, 0x24, 0x32, 0x7d, 0x1a,
+	0x72, 0x91, 0x9d, 0x42, 0xdb, 0x34, 0x56, 0x15, 0x68, 0xbd, 0x72, 0x66, 0x6d, 0xcf, 0x58, 0x55,
+	0xe4, 0x6b, 0xfe, 0x46, 0x72, 0xa1, 0xb9, 0xb1, 0x41, 0xe7, 0xc0, 0x9c, 0xda, 0x5b, 0x45, 0x6a,
+	0x7f, 0x02, 0x77, 0xf4, 0xc7, 0x8b, 0x34, 0x0c, 0xbf, 0x63, 0x81, 0xca, 0x67, 0x69, 0xeb, 0x59,
+	0xae, 0x57, 0x92, 0x21, 0x6c, 0x7a, 0x4a, 0xbc, 0x10, 0x3c, 0xc9, 0xed, 0x37, 0xb4, 0xfd, 0x32,
+	0x8c, 0x2b, 0xf0, 0x94, 0xd8, 0xd7, 0xfb, 0x97, 0xdb, 0x76, 0xcc, 0x0a, 0x56, 0x14, 0xe4, 0x21,
+	0x6c, 0xe0, 0xf1, 0xd7, 0x45, 0x83, 0x1c, 0xe0, 0x6c, 0x6a, 0xcb, 0x45, 0x90, 0xbc, 0x0b, 0x79,
+	0x7f, 0x3d, 0x39, 0x67, 0x7b, 0x1f, 0xff, 0xc2, 0xe9, 0xea, 0xe3, 0xb1, 0x84, 0x16, 0xed, 0x4c,
+	0xe7, 0x77, 0xb6, 0x16, 0xed, 0x0c, 0x4a, 0x06, 0xd0, 0x16, 0xfc, 0x7b, 0xee, 0x29, 0xca, 0x99,
+	0xb4, 0x6c, 0xd9, 0xa4, 0x0b, 0x18, 0x79, 0x02, 0x2d, 0x5b, 0x21, 0xba, 0x6b, 0x6e, 0xeb, 0x42,
+	0x26, 0xae, 0xb9, 0x5c, 0xb9, 0x22, 0xf1, 0x5c, 0xa3, 0xa1, 0x45, 0xb3, 0x3c, 0x23, 0xc8, 0x91,
+	0x96, 0x4b, 0xe7, 0x00, 0xf9, 0x0c, 0xba, 0xcb, 0x8d, 0x5f, 0xf3, 0x6a, 0x6b, 0x6f, 0xcb, 0x3d,
+	0x58, 0x52, 0xd0, 0x15, 0xd3, 0xc1, 0x09, 0x74, 0x97, 0xad, 0xc8, 0xdb, 0xf6, 0xa0, 0x95, 0xae,
+	0x39, 0x68, 0xe6, 0x98, 0x61, 0x4c, 0x4a, 0xa4, 0x91, 0x87, 0x05, 0x6d, 0x49, 0x64, 0x0e, 0x0c,
+	0xde, 0x82, 0x75, 0x64, 0x9f, 0xa7, 0xde, 0x05, 0x16, 0xcc, 0xd9, 0x4c, 0x71, 0x73, 0xe8, 0x2a,
+	0xd4, 0x08, 0x83, 0x3f, 0x97, 0xa0, 0xb9, 0x1f, 0x06, 0x3c, 0x52, 0xcf, 0xe5, 0x84, 0xdc, 0x87,
+	0x8a, 0x12, 0x33, 0x3b, 0x5d, 0x23, 0xbb, 0xe5, 0x1d, 0xad, 0x51, 0x84, 0x49, 0xdf, 0xb2, 0x57,
+	0xd9, 0xde, 0x9f, 0x72, 0x5e, 0xc3, 0x33, 0x8f, 0x1a, 0xf4, 0x67, 0xde, 0x85, 0x53, 0xb1, 0xfe,
+	0x76, 0x6a, 0xf4, 0x67, 0xde, 0x05, 0x79, 0x07, 0xea, 0x1e, 0x8b, 0x3c, 0x1e, 0xea, 0x43, 0x8a,
+	0x7c, 0x83, 0xa3, 0xef, 0x6b, 0xe8, 0x68, 0x8d, 0x5a, 0x25, 0x12, 0xc7, 0x59, 0xec, 0xcf, 0x06,
+	0x0f, 0x01, 0xe6, 0x7a, 0x6c, 0xf5, 0xc2, 0xe4, 0xd3, 0x30, 0xa1, 0x95, 0x06, 0x0f, 0xa0, 0xf1,
+	0x2c, 0x9e, 0xdc, 0x48, 0xaf, 0x83, 0x7f, 0x96, 0xa0, 0x49, 0xf5, 0xe5, 0x1b, 0x17, 0xf8, 0x31,
+	0xd6, 0x06, 0x12, 0xd9, 0x48, 0x9f, 0x72, 0xbb, 0xd2, 0xae, 0xbb, 0xc4, 0x70, 0x47, 0x6b, 0xb4,
+	0x25, 0xe6, 0xe2, 0x6b, 0xac, 0xfc, 0xe7, 0xd0, 0x18, 0x5b, 0x82, 0xb3, 0xcb, 0xdf, 0x70, 0x8b,
+	0xac, 0x77, 0xb4, 0x46, 0x73, 0x03, 0xf2, 0x26, 0x54, 0xc2, 0x78, 0x62, 0x77, 0xa1, 0xe9, 0x66,
+	0xf1, 0xe3, 0x3e, 0x85, 0xf1, 0x24, 0xdf, 0x80, 0x2f, 0x60, 0xe3, 0x38, 0xba, 0x8c, 0x2f, 0x38,
+	0xe5, 0xbf, 0x4b, 0xb9, 0x54, 0xa4, 0x77, 0x6d, 0x7a, 0x4c, 0x72, 0x88, 0x71, 0xb2, 0x0d, 0xc8,
+	0x0c, 0xf0, 0x21, 0x74, 0xb2, 0x01, 0x6c, 0x41, 0x3d, 0x80, 0xea, 0x54, 0x4e, 0xb0, 0x06, 0x2a,
+	0x7a, 0x21, 0xf9, 0xce, 0x50, 0x8d, 0x0f, 0x7e, 0xaa, 0x43, 0xdb, 0x60, 0xb6, 0xe4, 0x77, 0xa1,
+	0xce, 0x3c, 0x15, 0x5c, 0x9a, 0x66, 0x53, 0xa3, 0x56, 0x42, 0x7c, 0xcc, 0x82, 0xd0, 0xae, 0xb6,
+	0x41, 0xad, 0x64, 0x6f, 0xa6, 0xd5, 0xfc, 0x66, 0x5a, 0xa0, 0xf4, 0xda, 0x4b, 0x28, 0xbd, 0xfe,
+	0x32, 0x4a, 0x5f, 0x7f, 0x19, 0xa5, 0x37, 0x5e, 0x4a, 0xe9, 0xcd, 0x57, 0x50, 0x3a, 0xac, 0x52,
+	0xfa, 0x2e, 0x56, 0x29, 0x9e, 0x42, 0xcd, 0xac, 0x0d, 0x6a, 0x25, 0xf2, 0x33, 0xbc, 0x81, 0xe8,
+	0x3c, 0x48, 0xca, 0x3d, 0x1e, 0x5c, 0x72, 0xdf, 0xde, 0x3a, 0x57, 0x70, 0x24, 0xd4, 0x0c, 0x3b,
+	0x62, 0x91, 0x8f, 0xdb, 0x64, 0xae, 0xa2, 0xcb, 0x30, 0x92, 0xd5, 0x85, 0x9f, 0x4e, 0x13, 0xf9,
+	0x75, 0x74, 0x10, 0xc8, 0x0b, 0xcd, 0xa5, 0x55, 0xba, 0x80, 0x5d, 0xdf, 0x64, 0x36, 0x6f, 0xd5,
+	0x64, 0xba, 0x37, 0x35, 0x99, 0x47, 0xb0, 0x15, 0xc8, 0xaf, 0xb8, 0xfa, 0x21, 0x16, 0x17, 0x07,
+	0x81, 0x64, 0x67, 0x18, 0xeb, 0x96, 0x5e, 0xf8, 0xaa, 0x82, 0xec, 0x43, 0xdb, 0x4b, 0xa5, 0x8a,
+	0xa7, 0x96, 0x37, 0x89, 0x2e, 0xa3, 0xb7, 0xdc, 0x62, 0xc9, 0xb8, 0xfb, 0x05, 0x0b, 0x73, 0x31,
+	0x5e, 0x70, 0xba, 0xb9, 0x47, 0x6d, 0xdf, 0xb2, 0x47, 0xed, 0xdc, 0xa2, 0x47, 0xdd, 0x79, 0xed,
+	0x1e, 0xb5, 0x7b, 0x4d, 0x8f, 0xea, 0x7d, 0x01, 0x5b, 0x2b, 0xcb, 0xba, 0xd5, 0xfb, 0xed, 0x12,
+	0x9a, 0xe6, 0x06, 0x8a, 0x2c, 0x34, 0x7f, 0x8a, 0x94, 0xb2, 0xa7, 0x48, 0xa6, 0xbb, 0xee, 0x29,
+	0xf2, 0x5f, 0x5c, 0x5f, 0x07, 0x1d, 0x68, 0x1b, 0x57, 0x13, 0xf8, 0xe0, 0x6f, 0x65, 0xd8, 0x78,
+	0x16, 0x4f, 0x2c, 0xa3, 0x60, 0x30, 0x8f, 0xa0, 0x56, 0xe4, 0xc2, 0x1d, 0x77, 0x41, 0xed, 0x66,
+	0x7c, 0x68, 0x8c, 0xc8, 0xbb, 0x86, 0xe1, 0xcb, 0xb6, 0x61, 0x2e, 0xda, 0x16, 0xb8, 0xfe, 0x11,
+	0xd4, 0xf0, 0x5e, 0x3e, 0x73, 0x2a, 0xd7, 0x8e, 0x4a, 0x51, 0x87, 0xa3, 0x6a, 0xa3, 0xde, 0xef,
+	0xa1, 0x66, 0x88, 0xf6, 0x93, 0xa5, 0x9d, 0xe9, 0x5f, 0x17, 0xcd, 0xff, 0x78, 0x8f, 0x7a, 0x35,
+	0xa8, 0x3c, 0xf5, 0x2e, 0x7a, 0xeb, 0x50, 0xd3, 0x61, 0xe5, 0xfc, 0xfb, 0xef, 0x0a, 0x74, 0xf4,
+	0xf4, 0xf6, 0x15, 0x2f, 0x27, 0xe4, 0xfd, 0xbc, 0xc3, 0x60, 0x74, 0xf7, 0xdc, 0x45, 0x35, 0x06,
+	0xa6, 0x58, 0x10, 0x71, 0x61, 0xba, 0x42, 0xef, 0xef, 0x15, 0x68, 0xe6, 0x18, 0x96, 0x1a, 0x4b,
+	0x92, 0x30, 0xf0, 0x74, 0xe5, 0x1d, 0x67, 0x0f, 0xf8, 0x45, 0x90, 0x3c, 0x00, 0x18, 0xa7, 0x91,
+	0x67, 0x4d, 0x4c, 0xb0, 0x05, 0xc4, 0x3e, 0xa2, 0xcc, 0x90, 0xc7, 0xbe, 0x7d, 0xd9, 0x17, 0x21,
+	0xf2, 0xb1, 0x0d, 0xb2, 0xaa, 0x83, 0x7c, 0xfb, 0xc6, 0x20, 0x5d, 0xbb, 0xb1, 0x36, 0xd8, 0x3f,
+	0x94, 0x61, 0xdd, 0x22, 0x48, 0xa2, 0x96, 0xa9, 0xf2, 0x30, 0xe7, 0x00, 0xf9, 0x34, 0x6f, 0x87,
+	0x38, 0xc1, 0xbb, 0xaf, 0x9c, 0xc0, 0x7d, 0x16, 0x44, 0xdc, 0xce, 0xf2, 0x97, 0x12, 0x54, 0x51,
+	0xc4, 0x29, 0xf0, 0xa9, 0x28, 0x15, 0x9b, 0x26, 0xf6, 0x4e, 0x32, 0x07, 0xc8, 0x21, 0xd4, 0x65,
+	0x9c, 0x0a, 0xcf, 0xa4, 0xab, 0xb3, 0xf7, 0xfe, 0xeb, 0x4d, 0xe2, 0x9e, 0x68, 0x27, 0x6a, 0x9d,
+	0xf3, 0x1b, 0x41, 0xa5, 0x70, 0x23, 0xe8, 0x43, 0xdd, 0x58, 0x11, 0x80, 0xfa, 0xc9, 0xe9, 0xc1,
+	0xd7, 0xdf, 0x9c, 0x76, 0xd7, 0xec, 0xf7, 0x21, 0xa5, 0xdd, 0xd2, 0xe0, 0x4f, 0x65, 0x7c, 0xb2,
+	0x24, 0xec, 0x2c, 0x08, 0x03, 0x15, 0x70, 0x49, 0xde, 0x83, 0xae, 0xfe, 0xcb, 0xe6, 0xc5, 0xe1,
+	0xe8, 0x92, 0x0b, 0xfc, 0x87, 0x63, 0x1f, 0x54, 0x9b, 0x19, 0xfe, 0xad, 0x81, 0xb1, 0x71, 0x8d,
+	0x39, 0x53, 0xa9, 0xe0, 0xe6, 0x59, 0xd5, 0xa4, 0xb9, 0x9c, 0x35, 0x1f, 0xc1, 0xa5, 0x8c, 0x85,
+	0xf9, 0x99, 0xd6, 0xa4, 0x45, 0x88, 0x3c, 0x84, 0xce, 0x94, 0x5d, 0x8d, 0x30, 0xce, 0x91, 0x77,
+	0x9e, 0x46, 0x17, 0xba, 0x95, 0x56, 0x68, 0x7b, 0xca, 0xae, 0xf0, 0xd2, 0xb1, 0x8f, 0x18, 0x79,
+	0x0c, 0xf5, 0x90, 0x9d, 0x71, 0xdd, 0x53, 0x4d, 0x1d, 0x16, 0xa3, 0x75, 0x9f, 0x69, 0x9d, 0x3d,
+	0x1e, 0xc6, 0x10, 0x8f, 0x47, 0x01, 0xbe, 0x15, 0x85, 0x0c, 0xa1, 0x8b, 0x6c, 0x7c, 0x8c, 0xb4,
+	0x9c, 0xd5, 0x47, 0xfe, 0xfa, 0x28, 0x15, 0x5e, 0x1f, 0x83, 0x6d, 0xd8, 0x2a, 0x58, 0xda, 0x7b,
+	0xed, 0x6f, 0xe1, 0xee, 0x0b, 0x11, 0x8f, 0x83, 0x90, 0xcf, 0x4f, 0x87, 0x1d, 0x85, 0x80, 0xfe,
+	0x75, 0x60, 0x07, 0xd1, 0xdf, 0xf9, 0x6f, 0xa8, 0xf2, 0xe2, 0x6f, 0x28, 0xc9, 0xbd, 0x38, 0xf2,
+	0xa5, 0x7d, 0xfb, 0x65, 0xe2, 0xe0, 0x0a, 0x9c, 0xd5, 0xc1, 0xcd, 0xc4, 0xcb, 0x07, 0xa5, 0xb4,
+	0x7a, 0x50, 0xac, 0x05, 0x8f, 0xd4, 0xe9, 0x7c, 0xca, 0x22, 0x84, 0x33, 0x27, 0x66, 0x7c, 0x5b,
+	0x42, 0x99, 0xb8, 0xf7, 0x53, 0x05, 0x3a, 0xa6, 0xed, 0xbd, 0xb0, 0x15, 0x40, 0x1e, 0x42, 0xfd,
+	0x30, 0x9a, 0xe0, 0x33, 0x0c, 0xdc, 0xfc, 0x4e, 0xdd, 0x2b, 0x5c, 0xb2, 0x86, 0xa5, 0x0f, 0x4b,
+	0xe4, 0xd1, 0x52, 0x6d, 0x6d, 0x2c, 0x24, 0xaf, 0xb7, 0x28, 0x92, 0xf7, 0xa0, 0x6e, 0xae, 0x70,
+	0xa4, 0xe3, 0x2e, 0x5c, 0x06, 0x7b, 0x9b, 0xee, 0xd2, 0xdd, 0xee, 0x09, 0xd4, 0xb3, 0x4b, 0x5b,
+	0xf6, 0x90, 0xc9, 0xfe, 0x21, 0xbb, 0x87, 0xf8, 0x83, 0xb9, 0xb7, 0xb1, 0xd0, 0xa8, 0x07, 0x95,
+	0x3f, 0x96, 0x31, 0x9c, 0x4d, 0xc3, 0x9b, 0xa9, 0xe0, 0x46, 0x8b, 0xd1, 0x67, 0xed, 0xa8, 0xb7,
+	0x61, 0xbf, 0xed, 0xc8, 0x8f, 0x01, 0x4e, 0x94, 0xe0, 0x6c, 0xfa, 0x2c, 0x9e, 0x48, 0xd2, 0x59,
+	0x64, 0xe7, 0xde, 0xe6, 0xd2, 0x21, 0xd5, 0xeb, 0x7d, 0x0c, 0xeb, 0xc6, 0x79, 0x8f, 0xdc, 0x5d,
+	0x89, 0xeb, 0x44, 0xff, 0xdb, 0x5e, 0x0a, 0x8c, 0xec, 0x41, 0x33, 0xaf, 0x23, 0xb2, 0xe5, 0x2e,
+	0x57, 0x5f, 0x8f, 0xb8, 0x2b, 0x65, 0x46, 0x7e, 0x0d, 0xdd, 0xe5, 0x4a, 0x20, 0x8e, 0x7b, 0x43,
+	0xe5, 0xf5, 0xee, 0xb9, 0x37, 0x95, 0xcd, 0x59, 0x5d, 0x07, 0xf7, 0xd1, 0x7f, 0x06, 0x00, 0x19,
+	0xad, 0xda, 0x1e, 0xb3, 0x17, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -1452,6 +2469,12 @@ const _ = grpc.SupportPackageIsVersion4
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type RunnerProtocolClient interface {
 	Engage(ctx context.Context, opts ...grpc.CallOption) (RunnerProtocol_EngageClient, error)
+	// Tells the runner the capabilities of the LB and returns those of the runner.
+	// LBs call it once per runner before relying on optional protocol features.
+	Capabilities(ctx context.Context, in *Capabilities, opts ...grpc.CallOption) (*Capabilities, error)
+	// Runs a call like Engage, with the request and response body in a single message.
+	// Only meant for small bodies, the response size is limited.
+	Invoke(ctx context.Context, in *InvokeRequest, opts ...grpc.CallOption) (*InvokeResponse, error)
 	// Rather than rely on Prometheus for this, expose status that's specific to the runner lifecycle through this.
 	//
 	// Deprecated: Do not use.
@@ -1465,6 +2488,12 @@ type RunnerProtocolClient interface {
 	// Output from the container is sent back via RunnerStatus.Details
 	// as before.
 	Status2(ctx context.Context, in *_struct.Struct, opts ...grpc.CallOption) (*RunnerStatus, error)
+	// Pulls an image ahead of the calls of its functions, if the runner does not have it
+	// yet, so that the first calls after a deploy do not wait for the pull.
+	PullImage(ctx context.Context, in *PullImageRequest, opts ...grpc.CallOption) (*PullImageResponse, error)
+	// Collects a profile from a running hot container of a function, eg. a pprof CPU
+	// profile served by its FDK. Fails with NOT_FOUND if the runner has no such container.
+	ProfileContainer(ctx context.Context, in *ProfileContainerRequest, opts ...grpc.CallOption) (*ProfileContainerResponse, error)
 }
 
 type runnerProtocolClient struct {
@@ -1506,6 +2535,24 @@ func (x *runnerProtocolEngageClient) Recv() (*RunnerMsg, error) {
 	return m, nil
 }
 
+func (c *runnerProtocolClient) Capabilities(ctx context.Context, in *Capabilities, opts ...grpc.CallOption) (*Capabilities, error) {
+	out := new(Capabilities)
+	err := c.cc.Invoke(ctx, "/RunnerProtocol/Capabilities", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *runnerProtocolClient) Invoke(ctx context.Context, in *InvokeRequest, opts ...grpc.CallOption) (*InvokeResponse, error) {
+	out := new(InvokeResponse)
+	err := c.cc.Invoke(ctx, "/RunnerProtocol/Invoke", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Deprecated: Do not use.
 func (c *runnerProtocolClient) Status(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*RunnerStatus, error) {
 	out := new(RunnerStatus)
@@ -1565,9 +2612,33 @@ func (c *runnerProtocolClient) Status2(ctx context.Context, in *_struct.Struct,
 	return out, nil
 }
 
+func (c *runnerProtocolClient) PullImage(ctx context.Context, in *PullImageRequest, opts ...grpc.CallOption) (*PullImageResponse, error) {
+	out := new(PullImageResponse)
+	err := c.cc.Invoke(ctx, "/RunnerProtocol/PullImage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *runnerProtocolClient) ProfileContainer(ctx context.Context, in *ProfileContainerRequest, opts ...grpc.CallOption) (*ProfileContainerResponse, error) {
+	out := new(ProfileContainerResponse)
+	err := c.cc.Invoke(ctx, "/RunnerProtocol/ProfileContainer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // RunnerProtocolServer is the server API for RunnerProtocol service.
 type RunnerProtocolServer interface {
 	Engage(RunnerProtocol_EngageServer) error
+	// Tells the runner the capabilities of the LB and returns those of the runner.
+	// LBs call it once per runner before relying on optional protocol features.
+	Capabilities(context.Context, *Capabilities) (*Capabilities, error)
+	// Runs a call like Engage, with the request and response body in a single message.
+	// Only meant for small bodies, the response size is limited.
+	Invoke(context.Context, *InvokeRequest) (*InvokeResponse, error)
 	// Rather than rely on Prometheus for this, expose status that's specific to the runner lifecycle through this.
 	//
 	// Deprecated: Do not use.
@@ -1581,6 +2652,12 @@ type RunnerProtocolServer interface {
 	// Output from the container is sent back via RunnerStatus.Details
 	// as before.
 	Status2(context.Context, *_struct.Struct) (*RunnerStatus, error)
+	// Pulls an image ahead of the calls of its functions, if the runner does not have it
+	// yet, so that the first calls after a deploy do not wait for the pull.
+	PullImage(context.Context, *PullImageRequest) (*PullImageResponse, error)
+	// Collects a profile from a running hot container of a function, eg. a pprof CPU
+	// profile served by its FDK. Fails with NOT_FOUND if the runner has no such container.
+	ProfileContainer(context.Context, *ProfileContainerRequest) (*ProfileContainerResponse, error)
 }
 
 // UnimplementedRunnerProtocolServer can be embedded to have forward compatible implementations.
@@ -1588,19 +2665,31 @@ type UnimplementedRunnerProtocolServer struct {
 }
 
 func (*UnimplementedRunnerProtocolServer) Engage(srv RunnerProtocol_EngageServer) error {
-	return status.Errorf(codes.Unimplemented, "method Engage not implemented")
+	return status1.Errorf(codes.Unimplemented, "method Engage not implemented")
+}
+func (*UnimplementedRunnerProtocolServer) Capabilities(ctx context.Context, req *Capabilities) (*Capabilities, error) {
+	return nil, status1.Errorf(codes.Unimplemented, "method Capabilities not implemented")
+}
+func (*UnimplementedRunnerProtocolServer) Invoke(ctx context.Context, req *InvokeRequest) (*InvokeResponse, error) {
+	return nil, status1.Errorf(codes.Unimplemented, "method Invoke not implemented")
 }
 func (*UnimplementedRunnerProtocolServer) Status(ctx context.Context, req *empty.Empty) (*RunnerStatus, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+	return nil, status1.Errorf(codes.Unimplemented, "method Status not implemented")
 }
 func (*UnimplementedRunnerProtocolServer) ConfigureRunner(ctx context.Context, req *ConfigMsg) (*ConfigStatus, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method ConfigureRunner not implemented")
+	return nil, status1.Errorf(codes.Unimplemented, "method ConfigureRunner not implemented")
 }
 func (*UnimplementedRunnerProtocolServer) StreamLogs(srv RunnerProtocol_StreamLogsServer) error {
-	return status.Errorf(codes.Unimplemented, "method StreamLogs not implemented")
+	return status1.Errorf(codes.Unimplemented, "method StreamLogs not implemented")
 }
 func (*UnimplementedRunnerProtocolServer) Status2(ctx context.Context, req *_struct.Struct) (*RunnerStatus, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method Status2 not implemented")
+	return nil, status1.Errorf(codes.Unimplemented, "method Status2 not implemented")
+}
+func (*UnimplementedRunnerProtocolServer) PullImage(ctx context.Context, req *PullImageRequest) (*PullImageResponse, error) {
+	return nil, status1.Errorf(codes.Unimplemented, "method PullImage not implemented")
+}
+func (*UnimplementedRunnerProtocolServer) ProfileContainer(ctx context.Context, req *ProfileContainerRequest) (*ProfileContainerResponse, error) {
+	return nil, status1.Errorf(codes.Unimplemented, "method ProfileContainer not implemented")
 }
 
 func RegisterRunnerProtocolServer(s *grpc.Server, srv RunnerProtocolServer) {
@@ -1633,6 +2722,42 @@ func (x *runnerProtocolEngageServer) Recv() (*ClientMsg, error) {
 	return m, nil
 }
 
+func _RunnerProtocol_Capabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Capabilities)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RunnerProtocolServer).Capabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/RunnerProtocol/Capabilities",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RunnerProtocolServer).Capabilities(ctx, req.(*Capabilities))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RunnerProtocol_Invoke_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InvokeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RunnerProtocolServer).Invoke(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/RunnerProtocol/Invoke",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RunnerProtocolServer).Invoke(ctx, req.(*InvokeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _RunnerProtocol_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(empty.Empty)
 	if err := dec(in); err != nil {
@@ -1713,10 +2838,54 @@ func _RunnerProtocol_Status2_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _RunnerProtocol_PullImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PullImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RunnerProtocolServer).PullImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/RunnerProtocol/PullImage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RunnerProtocolServer).PullImage(ctx, req.(*PullImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RunnerProtocol_ProfileContainer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProfileContainerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RunnerProtocolServer).ProfileContainer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/RunnerProtocol/ProfileContainer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RunnerProtocolServer).ProfileContainer(ctx, req.(*ProfileContainerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _RunnerProtocol_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "RunnerProtocol",
 	HandlerType: (*RunnerProtocolServer)(nil),
 	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Capabilities",
+			Handler:    _RunnerProtocol_Capabilities_Handler,
+		},
+		{
+			MethodName: "Invoke",
+			Handler:    _RunnerProtocol_Invoke_Handler,
+		},
 		{
 			MethodName: "Status",
 			Handler:    _RunnerProtocol_Status_Handler,
@@ -1729,6 +2898,14 @@ var _RunnerProtocol_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Status2",
 			Handler:    _RunnerProtocol_Status2_Handler,
 		},
+		{
+			MethodName: "PullImage",
+			Handler:    _RunnerProtocol_PullImage_Handler,
+		},
+		{
+			MethodName: "ProfileContainer",
+			Handler:    _RunnerProtocol_ProfileContainer_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -0,0 +1,6 @@
+// +build !windows
+
+package agent
+
+// iofsDockerMountDest is the mount path for inside of the container to use for the iofs path
+const iofsDockerMountDest = "/tmp/iofs"
@@ -0,0 +1,5 @@
+package agent
+
+// iofsDockerMountDest is the mount path for inside of the container to use for the iofs path,
+// windows hosts run windows containers, whose mount paths are windows paths
+const iofsDockerMountDest = `C:\tmp\iofs`
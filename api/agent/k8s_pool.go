@@ -0,0 +1,366 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fnproject/fn/api/common"
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// how long a watch of the endpoints of the service lasts before the endpoints are listed again
+	k8sWatchTimeout = 5 * time.Minute
+)
+
+// k8sEndpointSlice is the part of a discovery.k8s.io/v1 EndpointSlice the runner pool uses
+type k8sEndpointSlice struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready       *bool `json:"ready"`
+			Terminating *bool `json:"terminating"`
+		} `json:"conditions"`
+	} `json:"endpoints"`
+	Ports []struct {
+		Name string `json:"name"`
+		Port int    `json:"port"`
+	} `json:"ports"`
+}
+
+type k8sEndpointSliceList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []k8sEndpointSlice `json:"items"`
+}
+
+type k8sWatchEvent struct {
+	Type   string           `json:"type"`
+	Object k8sEndpointSlice `json:"object"`
+}
+
+// k8sClient makes requests to the Kubernetes API server
+type k8sClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// newInClusterK8sClient returns a client for the API server of the cluster we run in,
+// authenticated with the service account of the pod
+func newInClusterK8sClient() (*k8sClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("Not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	token, err := ioutil.ReadFile(k8sServiceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ioutil.ReadFile(k8sServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, errors.New("Failed to load the Kubernetes service account CA certificate")
+	}
+	return &k8sClient{
+		server: "https://" + net.JoinHostPort(host, port),
+		token:  strings.TrimSpace(string(token)),
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}},
+	}, nil
+}
+
+func (c *k8sClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequest("GET", c.server+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("Kubernetes API server returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// k8sRunnerPool manages the runners behind a Kubernetes service, kept in sync with the
+// endpoint slices of the service
+type k8sRunnerPool struct {
+	static    *staticRunnerPool
+	client    *k8sClient
+	namespace string
+	service   string
+	port      string
+
+	// endpoint slices of the service by name
+	slicesMtx sync.Mutex
+	slices    map[string]*k8sEndpointSlice
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func DefaultK8sRunnerPool(service, port string) pool.RunnerPool {
+	cfg, err := NewRunnerClientConfig()
+	if err != nil {
+		logrus.WithError(err).Fatalf("error in runner client config cfg=%+v", cfg)
+	}
+	rp, err := NewK8sRunnerPoolWithOptions(service, port, nil, cfg.RunnerOptions()...)
+	if err != nil {
+		logrus.WithError(err).Fatal("error in kubernetes runner pool config")
+	}
+	return rp
+}
+
+// NewK8sRunnerPoolWithOptions creates a pool of the runners behind the Kubernetes service
+// "[namespace/]name", each configured with runnerOpts, using the in-cluster service account
+// to watch the endpoint slices of the service. The runners listen on port, the name or
+// number of a port of the service, which may be empty for services with a single port. The
+// namespace defaults to the namespace of the pod. Endpoints that are not ready are not
+// added, and endpoints that become unready or start terminating are removed and drained as
+// with SetRunners of a static pool.
+func NewK8sRunnerPoolWithOptions(service, port string, tlsConf *tls.Config, runnerOpts ...GRPCRunnerOption) (pool.RunnerPool, error) {
+	client, err := newInClusterK8sClient()
+	if err != nil {
+		return nil, err
+	}
+	namespace := ""
+	if i := strings.Index(service, "/"); i >= 0 {
+		namespace, service = service[:i], service[i+1:]
+	} else {
+		ns, err := ioutil.ReadFile(k8sServiceAccountDir + "/namespace")
+		if err != nil {
+			return nil, err
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	return newK8sRunnerPool(client, namespace, service, port, tlsConf, runnerOpts...)
+}
+
+func newK8sRunnerPool(client *k8sClient, namespace, service, port string, tlsConf *tls.Config, runnerOpts ...GRPCRunnerOption) (*k8sRunnerPool, error) {
+	if namespace == "" || service == "" {
+		return nil, fmt.Errorf("Invalid Kubernetes service %q in namespace %q", service, namespace)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rp := &k8sRunnerPool{
+		static:    NewStaticRunnerPoolWithOptions(nil, tlsConf, runnerOpts...).(*staticRunnerPool),
+		client:    client,
+		namespace: namespace,
+		service:   service,
+		port:      port,
+		slices:    make(map[string]*k8sEndpointSlice),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	go rp.watch(ctx)
+	return rp, nil
+}
+
+// watch keeps the runners in sync with the endpoint slices of the service until ctx is done
+func (rp *k8sRunnerPool) watch(ctx context.Context) {
+	defer close(rp.done)
+	log := logrus.WithFields(logrus.Fields{"k8s_namespace": rp.namespace, "k8s_service": rp.service})
+
+	backoffCfg := common.BackOffConfig{MaxRetries: common.RetryForever, Interval: 100, MinDelay: 100, MaxDelay: 30000}
+	backoff := common.NewBackOff(backoffCfg)
+	for ctx.Err() == nil {
+		version, err := rp.list(ctx)
+		if err == nil {
+			backoff = common.NewBackOff(backoffCfg)
+			err = rp.watchSlices(ctx, version)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		delay := time.Duration(0)
+		if err != nil {
+			log.WithError(err).Warn("Failed to watch service endpoints, keeping current runners")
+			delay, _ = backoff.NextBackOff()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (rp *k8sRunnerPool) slicesPath() (string, url.Values) {
+	path := fmt.Sprintf("/apis/discovery.k8s.io/v1/namespaces/%s/endpointslices", url.PathEscape(rp.namespace))
+	return path, url.Values{"labelSelector": {"kubernetes.io/service-name=" + rp.service}}
+}
+
+// list replaces the endpoint slices of the service, returning their resource version
+func (rp *k8sRunnerPool) list(ctx context.Context) (string, error) {
+	path, query := rp.slicesPath()
+	resp, err := rp.client.get(ctx, path, query)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list k8sEndpointSliceList
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	if err != nil {
+		return "", err
+	}
+
+	rp.slicesMtx.Lock()
+	rp.slices = make(map[string]*k8sEndpointSlice, len(list.Items))
+	for i := range list.Items {
+		rp.slices[list.Items[i].Metadata.Name] = &list.Items[i]
+	}
+	rp.slicesMtx.Unlock()
+
+	rp.update(ctx)
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watchSlices applies changes of the endpoint slices of the service from version on, until
+// the watch times out or fails
+func (rp *k8sRunnerPool) watchSlices(ctx context.Context, version string) error {
+	path, query := rp.slicesPath()
+	query.Set("watch", "true")
+	query.Set("resourceVersion", version)
+	query.Set("timeoutSeconds", strconv.Itoa(int(k8sWatchTimeout/time.Second)))
+	resp, err := rp.client.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var event k8sWatchEvent
+		err := dec.Decode(&event)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		rp.slicesMtx.Lock()
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			slice := event.Object
+			rp.slices[slice.Metadata.Name] = &slice
+		case "DELETED":
+			delete(rp.slices, event.Object.Metadata.Name)
+		case "ERROR":
+			// eg. the resource version is too old, list again
+			rp.slicesMtx.Unlock()
+			return errors.New("Kubernetes watch of service endpoints expired")
+		}
+		rp.slicesMtx.Unlock()
+
+		rp.update(ctx)
+	}
+}
+
+// update sets the runners to the ready endpoints of the service
+func (rp *k8sRunnerPool) update(ctx context.Context) {
+	rp.slicesMtx.Lock()
+	var addrs []string
+	for _, slice := range rp.slices {
+		port := rp.slicePort(slice)
+		if port == 0 {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			// ready is unset if unknown, which is to be interpreted as ready
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			if ep.Conditions.Terminating != nil && *ep.Conditions.Terminating {
+				continue
+			}
+			for _, addr := range ep.Addresses {
+				addrs = append(addrs, net.JoinHostPort(addr, strconv.Itoa(port)))
+			}
+		}
+	}
+	rp.slicesMtx.Unlock()
+
+	sort.Strings(addrs)
+	current := rp.static.RunnerAddresses()
+	sort.Strings(current)
+	if equalStrings(addrs, current) {
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{"k8s_namespace": rp.namespace, "k8s_service": rp.service, "runners": addrs}).Info("Service endpoints changed, updating runner pool")
+	err := rp.static.SetRunners(ctx, addrs)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to update runner pool")
+	}
+}
+
+// slicePort returns the runner port of the endpoints of slice, zero if it has none
+func (rp *k8sRunnerPool) slicePort(slice *k8sEndpointSlice) int {
+	if rp.port == "" && len(slice.Ports) == 1 {
+		return slice.Ports[0].Port
+	}
+	for _, p := range slice.Ports {
+		if p.Name == rp.port || strconv.Itoa(p.Port) == rp.port {
+			return p.Port
+		}
+	}
+	return 0
+}
+
+func (rp *k8sRunnerPool) Runners(ctx context.Context, call pool.RunnerCall) ([]pool.Runner, error) {
+	return rp.static.Runners(ctx, call)
+}
+
+// RunnersAdded implements pool.RunnerPoolNotifier
+func (rp *k8sRunnerPool) RunnersAdded() <-chan struct{} {
+	return rp.static.RunnersAdded()
+}
+
+func (rp *k8sRunnerPool) Shutdown(ctx context.Context) error {
+	rp.cancel()
+	select {
+	case <-rp.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return rp.static.Shutdown(ctx)
+}
+
+var _ pool.RunnerPoolNotifier = &k8sRunnerPool{}
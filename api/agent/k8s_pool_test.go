@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testEndpointSlice = `{"metadata":{"name":"runners-abc"},"ports":[{"name":"metrics","port":9100},{"name":"grpc","port":9190}],"endpoints":[
+	{"addresses":["10.0.0.1"],"conditions":{"ready":true}},
+	{"addresses":["10.0.0.2"],"conditions":{"ready":false}},
+	{"addresses":["10.0.0.3"],"conditions":{}}%s]}`
+
+func TestK8sRunnerPool(t *testing.T) {
+	events := make(chan string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/fn/endpointslices" || r.URL.Query().Get("labelSelector") != "kubernetes.io/service-name=runners" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("watch") == "" {
+			fmt.Fprintf(w, `{"metadata":{"resourceVersion":"1"},"items":[`+testEndpointSlice+`]}`, "")
+			return
+		}
+		if r.URL.Query().Get("resourceVersion") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-events:
+				fmt.Fprintln(w, event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	client := &k8sClient{server: srv.URL, token: "token", client: srv.Client()}
+	rp, err := newK8sRunnerPool(client, "fn", "runners", "grpc", nil)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer rp.Shutdown(context.Background())
+
+	waitRunners := func(expected []string) {
+		for i := 0; i < 500; i++ {
+			if reflect.DeepEqual(sortedRunnerAddrs(rp), expected) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Expected runners %v, got %v", expected, sortedRunnerAddrs(rp))
+	}
+
+	// endpoints that are not ready are skipped
+	waitRunners([]string{"10.0.0.1:9190", "10.0.0.3:9190"})
+
+	// terminating endpoints are removed, new ready ones added
+	events <- `{"type":"MODIFIED","object":` + fmt.Sprintf(testEndpointSlice, `,
+	{"addresses":["10.0.0.4"],"conditions":{"ready":true}}`) + `}`
+	waitRunners([]string{"10.0.0.1:9190", "10.0.0.3:9190", "10.0.0.4:9190"})
+	events <- `{"type":"MODIFIED","object":{"metadata":{"name":"runners-abc"},"ports":[{"name":"grpc","port":9190}],"endpoints":[
+	{"addresses":["10.0.0.1"],"conditions":{"ready":false,"terminating":true}},{"addresses":["10.0.0.4"],"conditions":{"ready":true}}]}}`
+	waitRunners([]string{"10.0.0.4:9190"})
+
+	events <- `{"type":"DELETED","object":{"metadata":{"name":"runners-abc"}}}`
+	waitRunners(nil)
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// size of latency windows kept per runner
+const latencyWindowSize = 1024
+
+// latencyWindow keeps the most recent latency samples in a fixed size ring
+// and estimates quantiles over them. Memory is bounded by the window size and
+// recording is O(1), quantiles are computed on demand.
+type latencyWindow struct {
+	mtx     sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{samples: make([]time.Duration, size)}
+}
+
+// Record adds a sample, replacing the oldest one if the window is full
+func (w *latencyWindow) Record(d time.Duration) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.samples[w.next] = d
+	w.next++
+	if w.next == len(w.samples) {
+		w.next = 0
+		w.full = true
+	}
+}
+
+// Quantiles returns the estimated latency for each quantile in qs (0.0 to 1.0)
+// and the number of samples the estimate is based on.
+func (w *latencyWindow) Quantiles(qs ...float64) ([]time.Duration, int) {
+	w.mtx.Lock()
+	n := w.next
+	if w.full {
+		n = len(w.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, w.samples[:n])
+	w.mtx.Unlock()
+
+	res := make([]time.Duration, len(qs))
+	if n == 0 {
+		return res, 0
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for i, q := range qs {
+		if q < 0 {
+			q = 0
+		} else if q > 1 {
+			q = 1
+		}
+		res[i] = sorted[int(q*float64(n-1)+0.5)]
+	}
+	return res, n
+}
+
+// Reset discards all samples, starting a new window
+func (w *latencyWindow) Reset() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.next = 0
+	w.full = false
+}
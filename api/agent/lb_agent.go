@@ -1,11 +1,9 @@
 package agent
 
 import (
-	"bytes"
 	"context"
 	"errors"
-	"io"
-	"io/ioutil"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -26,12 +24,68 @@ type lbAgent struct {
 	callOverrider CallOverrider
 	shutWg        *common.WaitGroup
 	callOpts      []CallOpt
+	callLogs      bool
+	// calls are mirrored to the shadow pool with this percentage, see WithLBShadowPool
+	shadowPool    pool.RunnerPool
+	shadowPercent float64
+	// named runner pools selected by apps and fns, see WithLBRunnerPool
+	pools map[string]lbRunnerPool
+	// computes capacity signals of the runner pools, see WithLBCapacitySignal
+	capacity       *capacityTracker
+	capacityCancel context.CancelFunc
+	// calls in flight on the runner pool of the agent, see WithLBMaxInFlight
+	maxInFlight int
+	limit       *inFlightLimit
+	// fraction of the in-flight cap reserved for reserved calls, see WithLBReservedCapacity
+	reservedCapacity float64
+	// calls all runners rejected wait in the queue of their pool, see WithLBCallQueue
+	queueDepth   int
+	queueMaxWait time.Duration
+	queue        *callQueue
+	// runners hosting the sessions of calls, see WithLBSessionAffinity
+	sessions *pool.SessionRunners
+	// calls are shed above this placement failure rate, see WithLBBackpressure
+	overloadThreshold float64
+	overloadWindow    time.Duration
+	overload          *overloadDetector
+	// responses of fns caching them, see WithLBResponseCache
+	cache *responseCache
+	// buffers the request bodies of calls, see WithLBBodySpool
+	spool bodySpooler
+	// calls in flight and the drain state, see Drain
+	drain *agentDrain
+	// results of detached calls, see WithLBCallResults
+	results       CallResultStore
+	resultMaxBody int64
 }
 
+// lbRunnerPool is a named runner pool along with the placer of its calls
+type lbRunnerPool struct {
+	name     string
+	rp       pool.RunnerPool
+	placer   pool.Placer
+	limit    *inFlightLimit
+	queue    *callQueue
+	overload *overloadDetector
+}
+
+// ErrRunnerPoolNotFound is returned for calls of apps or fns selecting a runner pool the
+// LB does not have, see models.RunnerPoolAnnotation
+var ErrRunnerPoolNotFound = models.NewAPIError(http.StatusBadRequest, errors.New("Runner pool selected by the function not found"))
+
 type DetachedResponseWriter struct {
 	Headers http.Header
 	status  int
 	acked   chan struct{}
+
+	// the response of the fn, which runners send once they acknowledged the call, is
+	// recorded up to maxBody bytes of its body for the result of the call, see
+	// WithLBCallResults
+	maxBody    int64
+	respStatus int
+	respHeader http.Header
+	body       []byte
+	truncated  bool
 }
 
 func (w *DetachedResponseWriter) Header() http.Header {
@@ -39,7 +93,13 @@ func (w *DetachedResponseWriter) Header() http.Header {
 }
 
 func (w *DetachedResponseWriter) Write(data []byte) (int, error) {
-	return len(data), nil
+	n := len(data)
+	if room := w.maxBody - int64(len(w.body)); int64(n) > room {
+		data = data[:room]
+		w.truncated = true
+	}
+	w.body = append(w.body, data...)
+	return n, nil
 }
 
 func (w *DetachedResponseWriter) WriteHeader(statusCode int) {
@@ -93,6 +153,36 @@ func WithLBCallOptions(opts ...CallOpt) LBAgentOption {
 
 }
 
+// WithLBCallLogs gives calls a stderr like the full agent does, so that function logs
+// sent by runners (see GRPCRunnerWithLogFrames) are logged and kept up to the max
+// log size of the agent config
+func WithLBCallLogs() LBAgentOption {
+	return func(a *lbAgent) error {
+		a.callLogs = true
+		return nil
+	}
+}
+
+// WithLBRunnerPool adds a runner pool that apps and fns select by name with the
+// models.RunnerPoolAnnotation annotation, eg. a pool of GPU runners. Calls of the pool are
+// placed by placer, which keeps its own state apart from the placers of other pools. Calls
+// that select no pool use the runner pool of NewLBAgent. rp is shut down with the agent.
+func WithLBRunnerPool(name string, rp pool.RunnerPool, placer pool.Placer) LBAgentOption {
+	return func(a *lbAgent) error {
+		if name == "" || rp == nil || placer == nil {
+			return errors.New("lb-agent runner pools need a name, runners and a placer")
+		}
+		if _, ok := a.pools[name]; ok {
+			return fmt.Errorf("lb-agent runner pool %s already exists", name)
+		}
+		if a.pools == nil {
+			a.pools = make(map[string]lbRunnerPool)
+		}
+		a.pools[name] = lbRunnerPool{name: name, rp: rp, placer: placer}
+		return nil
+	}
+}
+
 // NewLBAgent creates an Agent that knows how to load-balance function calls
 // across a group of runner nodes.
 func NewLBAgent(rp pool.RunnerPool, p pool.Placer, options ...LBAgentOption) (Agent, error) {
@@ -108,6 +198,7 @@ func NewLBAgent(rp pool.RunnerPool, p pool.Placer, options ...LBAgentOption) (Ag
 		rp:     rp,
 		placer: p,
 		shutWg: common.NewWaitGroup(),
+		drain:  &agentDrain{},
 	}
 
 	// Allow overriding config
@@ -118,10 +209,44 @@ func NewLBAgent(rp pool.RunnerPool, p pool.Placer, options ...LBAgentOption) (Ag
 		}
 	}
 
+	if a.reservedCapacity > 0 && a.maxInFlight <= 0 {
+		logrus.Fatal("error in lb-agent options, reserved capacity needs a cap of calls in flight")
+	}
+
+	// each runner pool has its own cap of calls in flight and call queue
+	a.limit = newInFlightLimit(a.maxInFlight, a.reservedCapacity)
+	a.queue = newCallQueue(DefaultRunnerPoolName, a.queueDepth, a.queueMaxWait)
+	a.overload = newOverloadDetector(a.overloadThreshold, a.overloadWindow)
+	for name, p := range a.pools {
+		p.limit = newInFlightLimit(a.maxInFlight, a.reservedCapacity)
+		p.queue = newCallQueue(name, a.queueDepth, a.queueMaxWait)
+		p.overload = newOverloadDetector(a.overloadThreshold, a.overloadWindow)
+		a.pools[name] = p
+	}
+
+	if a.capacity != nil {
+		pools := map[string]pool.RunnerPool{DefaultRunnerPoolName: a.rp}
+		for name, p := range a.pools {
+			pools[name] = p.rp
+		}
+		var ctx context.Context
+		ctx, a.capacityCancel = context.WithCancel(context.Background())
+		go a.capacity.run(ctx, pools)
+	}
+
 	logrus.Infof("lb-agent starting cfg=%+v", a.cfg)
 	return a, nil
 }
 
+// CapacitySignals implements CapacitySignaler, returns nothing unless the agent was
+// created with WithLBCapacitySignal
+func (a *lbAgent) CapacitySignals() []CapacitySignal {
+	if a.capacity == nil {
+		return nil
+	}
+	return a.capacity.CapacitySignals()
+}
+
 // implements Agent
 func (a *lbAgent) AddCallListener(listener fnext.CallListener) {
 	a.callListeners = append(a.callListeners, listener)
@@ -168,7 +293,11 @@ func (a *lbAgent) GetCall(opts ...CallOpt) (Call, error) {
 	setupCtx(&c)
 
 	c.ct = a
-	c.stderr = common.NoopReadWriteCloser{}
+	if a.callLogs {
+		c.stderr = setupLogger(c.req.Context(), a.cfg.MaxLogSize, !a.cfg.DisableDebugUserLogs, c.Call)
+	} else {
+		c.stderr = common.NoopReadWriteCloser{}
+	}
 	return &c, nil
 }
 
@@ -178,11 +307,25 @@ func (a *lbAgent) Close() error {
 	// start closing the front gate first
 	ch := a.shutWg.CloseGroupNB()
 
+	if a.capacityCancel != nil {
+		a.capacityCancel()
+	}
+
 	// finally shutdown the runner pool
 	err := a.rp.Shutdown(context.Background())
 	if err != nil {
 		logrus.WithError(err).Warn("Runner pool shutdown error")
 	}
+	for name, p := range a.pools {
+		if poolErr := p.rp.Shutdown(context.Background()); poolErr != nil {
+			logrus.WithError(poolErr).WithField("runner_pool", name).Warn("Runner pool shutdown error")
+		}
+	}
+	if a.shadowPool != nil {
+		if shadowErr := a.shadowPool.Shutdown(context.Background()); shadowErr != nil {
+			logrus.WithError(shadowErr).Warn("Shadow runner pool shutdown error")
+		}
+	}
 
 	// gate-on front-gate, should be completed if delegated agent & runner pool is gone.
 	<-ch
@@ -215,34 +358,131 @@ func (a *lbAgent) Submit(callI Call) error {
 
 	statsEnqueue(ctx)
 
-	// pre-read and buffer request body if already not done based
-	// on GetBody presence.
-	buf, err := a.setRequestBody(ctx, call)
-	if buf != nil {
-		defer bufPool.Put(buf)
+	// draining agents take no new calls. The call is in flight until it is placed, a detached
+	// call until spawnPlaceCall is done with it.
+	if !a.drain.add() {
+		return a.handleCallEnd(ctx, call, models.ErrServerDraining, false)
 	}
+	var spawned bool
+	defer func() {
+		if !spawned {
+			a.drain.done()
+		}
+	}()
+
+	p, err := a.runnerPool(call)
 	if err != nil {
 		return a.handleCallEnd(ctx, call, err, false)
 	}
 
-	err = call.Start(ctx)
+	class, err := call.Annotations.PlacementClass()
 	if err != nil {
 		return a.handleCallEnd(ctx, call, err, false)
 	}
+	detached := call.Type == models.TypeDetached
+
+	// shed calls the pool is unlikely to place before reading their body
+	if err := p.admit(); err != nil {
+		return a.handleCallEnd(ctx, call, err, false)
+	}
+
+	// preemptible sync calls are canceled if a reserved call needs their slot
+	var preempt context.CancelFunc
+	if class == models.PlacementClassPreemptible && !detached {
+		ctx, preempt = context.WithCancel(ctx)
+		defer preempt()
+	}
+
+	// fail fast when the pool has too many calls in flight, the slot is released once the
+	// call is placed, by spawnPlaceCall for detached calls
+	flight, ok := p.limit.acquire(class, preempt)
+	if !ok {
+		return a.handleCallEnd(ctx, call, models.ErrTooManyCallsInFlight, false)
+	}
+	defer func() {
+		if !spawned {
+			p.limit.release(flight)
+		}
+	}()
+
+	// pre-read and buffer request body if already not done based
+	// on GetBody presence. The body of stream calls streams as the call runs. The body
+	// is released once the call is placed, by spawnPlaceCall for detached calls.
+	releaseBody := func() {}
+	if call.Type != models.TypeStream {
+		releaseBody, err = a.spoolRequestBody(ctx, call)
+		if err != nil {
+			return a.handleCallEnd(ctx, call, a.preemptedErr(p, flight, err), false)
+		}
+	}
+	defer func() {
+		if !spawned {
+			releaseBody()
+		}
+	}()
+
+	// calls of fns caching their responses may be served without a runner
+	cacheKey, cacheTTL := a.responseCacheKey(call)
+	if cacheKey != "" && a.serveCachedResponse(ctx, call, cacheKey) {
+		statsResponseCacheHit(ctx)
+		return a.handleCallEnd(ctx, call, nil, false)
+	}
+
+	err = call.Start(ctx)
+	if err != nil {
+		return a.handleCallEnd(ctx, call, a.preemptedErr(p, flight, err), false)
+	}
 
 	statsDequeue(ctx)
 	statsStartRun(ctx)
 
-	if call.Type == models.TypeDetached {
-		return a.placeDetachCall(ctx, call)
+	a.shadowCallMaybe(ctx, call)
+
+	if detached {
+		spawned = true
+		a.recordDetachedResponse(call)
+		return a.placeDetachCall(ctx, call, p, flight, func() {
+			releaseBody()
+			a.drain.done()
+		})
+	}
+	if cacheKey != "" {
+		return a.placeCachedCall(ctx, call, p, flight, cacheKey, cacheTTL)
+	}
+	return a.placeCall(ctx, call, p, flight)
+}
+
+// preemptedErr returns models.ErrCallPreempted if the call failed with err because a
+// reserved call preempted it, err otherwise
+func (a *lbAgent) preemptedErr(p lbRunnerPool, flight *inFlightCall, err error) error {
+	if err != nil && p.limit.wasPreempted(flight) {
+		return models.ErrCallPreempted
 	}
-	return a.placeCall(ctx, call)
+	return err
 }
 
-func (a *lbAgent) placeDetachCall(ctx context.Context, call *call) error {
+// runnerPool returns the runner pool selected by the app or fn of call, the runner pool of
+// the agent if there is none
+func (a *lbAgent) runnerPool(call *call) (lbRunnerPool, error) {
+	name, err := call.Annotations.RunnerPool()
+	if err != nil {
+		return lbRunnerPool{}, err
+	}
+	if name == "" {
+		return lbRunnerPool{name: DefaultRunnerPoolName, rp: a.rp, placer: a.placer, limit: a.limit, queue: a.queue, overload: a.overload}, nil
+	}
+	p, ok := a.pools[name]
+	if !ok {
+		return lbRunnerPool{}, ErrRunnerPoolNotFound
+	}
+	return p, nil
+}
+
+// placeDetachCall places call in the background, release is called once the placement is done
+func (a *lbAgent) placeDetachCall(ctx context.Context, call *call, p lbRunnerPool, flight *inFlightCall, release func()) error {
 	errPlace := make(chan error, 1)
 	rw := call.respWriter.(*DetachedResponseWriter)
-	go a.spawnPlaceCall(ctx, call, errPlace)
+	go a.spawnPlaceCall(ctx, call, p, flight, release, errPlace)
 	select {
 	case err := <-errPlace:
 		return err
@@ -251,65 +491,48 @@ func (a *lbAgent) placeDetachCall(ctx context.Context, call *call) error {
 	}
 }
 
-func (a *lbAgent) placeCall(ctx context.Context, call *call) error {
-	err := a.placer.PlaceCall(ctx, a.rp, call)
+func (a *lbAgent) placeCall(ctx context.Context, call *call, p lbRunnerPool, flight *inFlightCall) error {
+	err := a.preemptedErr(p, flight, a.place(ctx, call, p))
+	if a.capacity != nil {
+		a.capacity.observe(p.name, call, err)
+	}
 	return a.handleCallEnd(ctx, call, err, true)
 }
 
-func (a *lbAgent) spawnPlaceCall(ctx context.Context, call *call, errCh chan error) {
+// place places call on the pool, on the runner of its session if it has one, queueing it
+// if the runners are busy. Once the call is done on a runner, the next queued call of the
+// pool is woken.
+func (a *lbAgent) place(ctx context.Context, call *call, p lbRunnerPool) error {
+	err := p.placer.PlaceCall(a.withSession(p.queue.withCallQueue(ctx), call), p.rp, call)
+	if _, busy := pool.RejectReasonOf(err); !busy {
+		p.queue.freed()
+	}
+	p.overload.observe(err)
+	return err
+}
+
+func (a *lbAgent) spawnPlaceCall(ctx context.Context, call *call, p lbRunnerPool, flight *inFlightCall, release func(), errCh chan error) {
+	defer p.limit.release(flight)
+	defer release()
 	var cancel func()
 	ctx = common.BackgroundContext(ctx)
-	cfg := a.placer.GetPlacerConfig()
+	cfg := p.placer.GetPlacerConfig()
 
-	// PlacerTimeout for Detached + call.Timeout (inside container) + headroom for docker-pull, gRPC network retrasmit etc.)
-	newCtxTimeout := cfg.DetachedPlacerTimeout + time.Duration(call.Timeout)*time.Second + a.cfg.DetachedHeadRoom
+	// PlacerTimeout for Detached (or its priority budget) + call.Timeout (inside container) + call.InitTimeout (cold start,
+	// if set) + headroom for docker-pull, gRPC network retrasmit etc.)
+	newCtxTimeout := cfg.PlacementBudget(call).Timeout + time.Duration(call.Timeout+call.InitTimeout)*time.Second + a.cfg.DetachedHeadRoom
 	ctx, cancel = context.WithTimeout(ctx, newCtxTimeout)
 	defer cancel()
 
-	err := a.placer.PlaceCall(ctx, a.rp, call)
-	errCh <- a.handleCallEnd(ctx, call, err, true)
-}
-
-// setRequestGetBody sets GetBody function on the given http.Request if it is missing.  GetBody allows
-// reading from the request body without mutating the state of the request.
-func (a *lbAgent) setRequestBody(ctx context.Context, call *call) (*bytes.Buffer, error) {
-
-	r := call.req
-	if r.Body == nil || r.GetBody != nil {
-		return nil, nil
-	}
-
-	buf := bufPool.Get().(*bytes.Buffer)
-	buf.Reset()
-
-	// WARNING: we need to handle IO in a separate go-routine below
-	// to be able to detect a ctx timeout. When we timeout, we
-	// let gin/http-server to unblock the go-routine below.
-	errApp := make(chan error, 1)
-	go func() {
-
-		_, err := buf.ReadFrom(r.Body)
-		if err != nil && err != io.EOF {
-			errApp <- err
-			return
-		}
-
-		r.Body = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
-
-		// GetBody does not mutate the state of the request body
-		r.GetBody = func() (io.ReadCloser, error) {
-			return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
-		}
-
-		close(errApp)
-	}()
-
-	select {
-	case err := <-errApp:
-		return buf, err
-	case <-ctx.Done():
-		return buf, ctx.Err()
+	// clients may ask for the result of the call as soon as it is acknowledged
+	a.putCallResult(ctx, call, false, nil)
+	err := a.place(ctx, call, p)
+	if a.capacity != nil {
+		a.capacity.observe(p.name, call, err)
 	}
+	err = a.handleCallEnd(ctx, call, err, true)
+	a.putCallResult(ctx, call, true, err)
+	errCh <- err
 }
 
 // implements Agent
@@ -351,6 +574,9 @@ func (a *lbAgent) handleCallEnd(ctx context.Context, call *call, err error, isFo
 		statsTooBusy(ctx)
 		recordCallLatency(ctx, call, serverBusyMetricName)
 		return models.ErrCallTimeoutServerBusy
+	} else if models.IsTooBusy(err) {
+		statsTooBusy(ctx)
+		recordCallLatency(ctx, call, serverBusyMetricName)
 	} else if err == context.Canceled {
 		statsCanceled(ctx)
 		recordCallLatency(ctx, call, canceledMetricName)
@@ -397,4 +623,5 @@ func recordCallLatency(ctx context.Context, call *call, status string) {
 }
 
 var _ Agent = &lbAgent{}
+var _ CapacitySignaler = &lbAgent{}
 var _ callTrigger = &lbAgent{}
@@ -2,13 +2,22 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
 	"github.com/fnproject/fn/api/models"
 	pool "github.com/fnproject/fn/api/runnerpool"
 )
@@ -98,12 +107,17 @@ func (r *mockRunner) Address() string {
 	return r.addr
 }
 
+func (r *mockRunner) Ready() bool {
+	return true
+}
+
 type mockRunnerCall struct {
 	r          *http.Request
 	rw         http.ResponseWriter
 	stdErr     io.ReadWriteCloser
 	model      *models.Call
 	slotHashId string
+	priority   pool.CallPriority
 
 	// amount of time user execution inside container
 	userExecTime *time.Duration
@@ -113,6 +127,10 @@ func (c *mockRunnerCall) SlotHashId() string {
 	return c.slotHashId
 }
 
+func (c *mockRunnerCall) Priority() pool.CallPriority {
+	return c.priority
+}
+
 func (c *mockRunnerCall) Extensions() map[string]string {
 	return nil
 }
@@ -295,3 +313,849 @@ func TestWithLBCallOptions(t *testing.T) {
 		t.Fatalf("Expected %s got %s", expected, actualType)
 	}
 }
+
+// shadowRunner records the request bodies of the calls placed on it
+type shadowRunner struct {
+	mockRunner
+	bodies chan string
+}
+
+func (r *shadowRunner) TryExec(ctx context.Context, call pool.RunnerCall) (bool, error) {
+	body, _ := ioutil.ReadAll(call.RequestBody())
+	call.ResponseWriter().WriteHeader(http.StatusOK)
+	call.ResponseWriter().Write([]byte("shadow response"))
+	r.bodies <- string(body)
+	return true, nil
+}
+
+func TestShadowPool(t *testing.T) {
+	cfg := pool.NewPlacerConfig()
+	shadow := &shadowRunner{mockRunner: mockRunner{addr: "192.0.2.1"}, bodies: make(chan string, 10)}
+	a, err := NewLBAgent(&mockRunnerPool{}, pool.NewNaivePlacer(&cfg), WithLBShadowPool(&mockRunnerPool{runners: []pool.Runner{shadow}}, 100))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+
+	req, _ := http.NewRequest("POST", "http://www.example.com", ioutil.NopCloser(strings.NewReader("payload")))
+	rw := httptest.NewRecorder()
+	c := &call{Call: &models.Call{ID: "call1", Type: models.TypeSync, Timeout: 1}, req: req, respWriter: rw}
+	lb := a.(*lbAgent)
+	release, err := lb.spoolRequestBody(context.Background(), c)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	lb.shadowCallMaybe(context.Background(), c)
+	// the mirrored call has a copy of the body
+	release()
+	if body := <-shadow.bodies; body != "payload" {
+		t.Fatalf("Expected the request body in the shadow call, got %q", body)
+	}
+	a.Close()
+	if rw.Body.Len() != 0 || rw.Code != http.StatusOK {
+		t.Fatalf("Expected the shadow response to be discarded, got %d %q", rw.Code, rw.Body.String())
+	}
+
+	// no calls are mirrored with 0%
+	lb.shadowPercent = 0
+	lb.shutWg = common.NewWaitGroup()
+	lb.shadowCallMaybe(context.Background(), c)
+	a.Close()
+	if len(shadow.bodies) != 0 {
+		t.Fatal("Expected no shadow call")
+	}
+
+	// bodies spooled out of memory are not copied for a shadow call
+	lb.shadowPercent = 100
+	lb.shutWg = common.NewWaitGroup()
+	if err := WithLBBodySpool(int64(len("payload")-1), 0, "")(lb); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	req, _ = http.NewRequest("POST", "http://www.example.com", ioutil.NopCloser(strings.NewReader("payload")))
+	c = &call{Call: &models.Call{ID: "call2", Type: models.TypeSync, Timeout: 1}, req: req, respWriter: httptest.NewRecorder()}
+	release, err = lb.spoolRequestBody(context.Background(), c)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	lb.shadowCallMaybe(context.Background(), c)
+	release()
+	a.Close()
+	if len(shadow.bodies) != 0 {
+		t.Fatal("Expected no shadow call of a spooled body")
+	}
+}
+
+func TestLBRunnerPools(t *testing.T) {
+	cfg := pool.NewPlacerConfig()
+	defaultPool := setupMockRunnerPool([]string{"192.0.2.0"}, 0, 1)
+	gpuPool := setupMockRunnerPool([]string{"192.0.2.1"}, 0, 1)
+	gpuPlacer := pool.NewNaivePlacer(&cfg)
+	a, err := NewLBAgent(defaultPool, pool.NewNaivePlacer(&cfg), WithLBRunnerPool("gpu", gpuPool, gpuPlacer))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+	lb := a.(*lbAgent)
+
+	c := &call{Call: &models.Call{}}
+	if p, err := lb.runnerPool(c); err != nil || p.rp != defaultPool {
+		t.Fatalf("Expected the default runner pool, got %v %v", p, err)
+	}
+
+	c.Annotations, _ = models.EmptyAnnotations().With(models.RunnerPoolAnnotation, "gpu")
+	if p, err := lb.runnerPool(c); err != nil || p.rp != gpuPool || p.placer != gpuPlacer {
+		t.Fatalf("Expected the gpu runner pool, got %v %v", p, err)
+	}
+
+	c.Annotations, _ = models.EmptyAnnotations().With(models.RunnerPoolAnnotation, "pci-isolated")
+	if _, err := lb.runnerPool(c); err != ErrRunnerPoolNotFound {
+		t.Fatalf("Expected runner pool not found, got %v", err)
+	}
+}
+
+// pullRunner is a mock runner that pulls images, failing for the images in fail
+type pullRunner struct {
+	mockRunner
+	fail map[string]bool
+}
+
+func (r *pullRunner) PullImage(ctx context.Context, image string) error {
+	if r.fail[image] {
+		return errors.New("pull access denied")
+	}
+	return nil
+}
+
+// profileRunner is a mock runner with a hot container of the fns in containers
+type profileRunner struct {
+	mockRunner
+	containers map[string]string
+}
+
+func (r *profileRunner) ProfileContainer(ctx context.Context, fnID, typ string, seconds int) (*pool.ContainerProfile, error) {
+	id, ok := r.containers[fnID]
+	if !ok {
+		return nil, ErrFnContainerNotFound
+	}
+	return &pool.ContainerProfile{ContainerID: id, ContentType: "application/octet-stream", Data: []byte(typ)}, nil
+}
+
+func TestLBProfileContainer(t *testing.T) {
+	cfg := pool.NewPlacerConfig()
+	rp := &mockRunnerPool{runners: []pool.Runner{
+		&mockRunner{addr: "192.0.2.1"},
+		&profileRunner{mockRunner: mockRunner{addr: "192.0.2.2"}},
+		&profileRunner{mockRunner: mockRunner{addr: "192.0.2.3"}, containers: map[string]string{"fn": "container"}},
+	}}
+	a, err := NewLBAgent(rp, pool.NewNaivePlacer(&cfg))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+	defer a.Close()
+
+	app := &models.App{Annotations: models.EmptyAnnotations()}
+	fn := &models.Fn{ID: "fn", Annotations: models.EmptyAnnotations()}
+	prof, err := a.(ContainerProfiler).ProfileContainer(context.Background(), app, fn, ProfileRequest{Type: ProfileHeap})
+	if err != nil || prof.Address != "192.0.2.3" || prof.ContainerID != "container" || string(prof.Data) != ProfileHeap {
+		t.Fatalf("Expected the heap profile of the container of the fn, got %+v %v", prof, err)
+	}
+
+	fn.ID = "other"
+	if _, err := a.(ContainerProfiler).ProfileContainer(context.Background(), app, fn, ProfileRequest{Type: ProfileHeap}); err != ErrFnContainerNotFound {
+		t.Fatalf("Expected no container of the fn, got %v", err)
+	}
+}
+
+func TestLBPrePullImages(t *testing.T) {
+	cfg := pool.NewPlacerConfig()
+	rp := &mockRunnerPool{runners: []pool.Runner{
+		&pullRunner{mockRunner: mockRunner{addr: "192.0.2.2"}, fail: map[string]bool{"envoyproxy/envoy:v1.14.1": true}},
+		&pullRunner{mockRunner: mockRunner{addr: "192.0.2.1"}},
+		&mockRunner{addr: "192.0.2.3"},
+	}}
+	a, err := NewLBAgent(rp, pool.NewNaivePlacer(&cfg))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+	defer a.Close()
+
+	app := &models.App{Annotations: models.EmptyAnnotations()}
+	fn := &models.Fn{Image: "fnproject/hello"}
+	fn.Annotations, _ = models.EmptyAnnotations().With(models.SidecarsAnnotation, []models.Sidecar{{Name: "proxy", Image: "envoyproxy/envoy:v1.14.1"}})
+
+	results := a.(ImagePrePuller).PrePullImages(context.Background(), app, fn)
+	want := []PrePullResult{
+		{Image: "envoyproxy/envoy:v1.14.1", Address: "192.0.2.1"},
+		{Image: "fnproject/hello", Address: "192.0.2.1"},
+		{Image: "envoyproxy/envoy:v1.14.1", Address: "192.0.2.2", Error: "pull access denied"},
+		{Image: "fnproject/hello", Address: "192.0.2.2"},
+		{Image: "envoyproxy/envoy:v1.14.1", Address: "192.0.2.3", Error: errRunnerPrePullUnsupported.Error()},
+		{Image: "fnproject/hello", Address: "192.0.2.3", Error: errRunnerPrePullUnsupported.Error()},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("Unexpected pre-pull results %+v", results)
+	}
+
+	// runners of other pools do not pull the image
+	fn.Annotations, _ = models.EmptyAnnotations().With(models.RunnerPoolAnnotation, "gpu")
+	results = a.(ImagePrePuller).PrePullImages(context.Background(), app, fn)
+	if len(results) != 1 || results[0].Error != ErrRunnerPoolNotFound.Error() {
+		t.Fatalf("Unexpected pre-pull results %+v", results)
+	}
+}
+
+// statusRunner is a mock runner reporting active calls in its status
+type statusRunner struct {
+	mockRunner
+	active int32
+}
+
+func (r *statusRunner) Status(ctx context.Context) (*pool.RunnerStatus, error) {
+	return &pool.RunnerStatus{ActiveRequestCount: r.active}, nil
+}
+
+func TestCapacitySignal(t *testing.T) {
+	signals := make(chan CapacitySignal, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var signal CapacitySignal
+		json.NewDecoder(r.Body).Decode(&signal)
+		signals <- signal
+	}))
+	defer srv.Close()
+
+	rp := &mockRunnerPool{runners: []pool.Runner{
+		&statusRunner{mockRunner: mockRunner{addr: "192.0.2.1"}, active: 3},
+		&statusRunner{mockRunner: mockRunner{addr: "192.0.2.2"}, active: 4},
+	}}
+	c := newCapacityTracker(CapacitySignalConfig{Interval: time.Second, TargetActiveCalls: 2, WebhookURL: srv.URL})
+
+	// 7 active calls need 4 runners of 2 calls
+	c.observe("default", &call{Call: &models.Call{}}, nil)
+	c.observe("default", &call{Call: &models.Call{}}, models.ErrCallTimeout)
+	c.update(context.Background(), "default", rp)
+	signal := <-signals
+	if signal.Pool != "default" || signal.Runners != 2 || signal.ActiveCalls != 7 || signal.DesiredRunners != 4 || signal.PlacedCalls != 1 || signal.FailedPlacements != 0 {
+		t.Fatalf("Unexpected capacity signal %+v", signal)
+	}
+
+	// unchanged signals are not sent again
+	c.update(context.Background(), "default", rp)
+	if len(signals) != 0 {
+		t.Fatal("Expected no webhook for an unchanged signal")
+	}
+
+	// placement failures grow the pool, by at least one runner
+	c.cfg.TargetActiveCalls = 0
+	c.observe("default", &call{Call: &models.Call{}}, models.ErrCallTimeoutServerBusy)
+	for i := 0; i < 3; i++ {
+		c.observe("default", &call{Call: &models.Call{}}, nil)
+	}
+	c.update(context.Background(), "default", rp)
+	signal = <-signals
+	if signal.FailedPlacements != 1 || signal.DesiredRunners != 3 {
+		t.Fatalf("Unexpected capacity signal %+v", signal)
+	}
+
+	if s := c.CapacitySignals(); len(s) != 1 || s[0].DesiredRunners != 3 {
+		t.Fatalf("Unexpected capacity signals %+v", s)
+	}
+}
+
+func TestLBMaxInFlight(t *testing.T) {
+	cfg := pool.NewPlacerConfig()
+	a, err := NewLBAgent(&mockRunnerPool{}, pool.NewNaivePlacer(&cfg),
+		WithLBRunnerPool("gpu", &mockRunnerPool{}, pool.NewNaivePlacer(&cfg)), WithLBMaxInFlight(1))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+	lb := a.(*lbAgent)
+
+	p, _ := lb.runnerPool(&call{Call: &models.Call{}})
+	flight, ok := p.limit.acquire(models.PlacementClassStandard, nil)
+	if !ok {
+		t.Fatal("Expected a call in flight to be admitted")
+	}
+
+	// calls over the cap fail right away
+	req, _ := http.NewRequest("GET", "http://www.example.com", nil)
+	c := &call{Call: &models.Call{ID: "call1", Type: models.TypeSync, CreatedAt: common.DateTime(time.Now())}, req: req}
+	if err := a.Submit(c); err != models.ErrTooManyCallsInFlight {
+		t.Fatalf("Expected too many calls in flight, got %v", err)
+	}
+
+	// other pools have their own cap
+	c.Annotations, _ = models.EmptyAnnotations().With(models.RunnerPoolAnnotation, "gpu")
+	gpu, _ := lb.runnerPool(c)
+	if _, ok := gpu.limit.acquire(models.PlacementClassStandard, nil); !ok {
+		t.Fatal("Expected a call in flight on the gpu pool to be admitted")
+	}
+
+	p.limit.release(flight)
+	if _, ok := p.limit.acquire(models.PlacementClassStandard, nil); !ok {
+		t.Fatal("Expected a call to be admitted once a call in flight is done")
+	}
+}
+
+func TestLBReservedCapacity(t *testing.T) {
+	l := newInFlightLimit(4, 0.5)
+
+	var canceled []int
+	var preemptible []*inFlightCall
+	for i := 0; i < 2; i++ {
+		i := i
+		f, ok := l.acquire(models.PlacementClassPreemptible, func() { canceled = append(canceled, i) })
+		if !ok {
+			t.Fatalf("Expected preemptible call %d to be admitted", i)
+		}
+		preemptible = append(preemptible, f)
+	}
+
+	// the rest of the cap is reserved
+	if _, ok := l.acquire(models.PlacementClassStandard, nil); ok {
+		t.Fatal("Expected a standard call to be rejected from reserved capacity")
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := l.acquire(models.PlacementClassReserved, nil); !ok {
+			t.Fatalf("Expected reserved call %d to be admitted", i)
+		}
+	}
+
+	// a full pool preempts the latest preemptible call for a reserved call
+	if _, ok := l.acquire(models.PlacementClassReserved, nil); !ok {
+		t.Fatal("Expected a reserved call to preempt a preemptible call")
+	}
+	if len(canceled) != 1 || canceled[0] != 1 || !l.wasPreempted(preemptible[1]) || l.wasPreempted(preemptible[0]) {
+		t.Fatalf("Expected the latest preemptible call preempted, got %v", canceled)
+	}
+	l.release(preemptible[1])
+
+	// preemptible calls that are done cannot be preempted
+	l.release(preemptible[0])
+	for i := 0; i < 2; i++ {
+		l.acquire(models.PlacementClassReserved, nil)
+	}
+	if _, ok := l.acquire(models.PlacementClassReserved, nil); ok {
+		t.Fatal("Expected a reserved call to be rejected without preemptible calls")
+	}
+	if len(canceled) != 1 {
+		t.Fatalf("Expected no other preemption, got %v", canceled)
+	}
+
+	var nilLimit *inFlightLimit
+	if f, ok := nilLimit.acquire(models.PlacementClassStandard, nil); !ok || nilLimit.wasPreempted(f) {
+		t.Fatal("Expected nil limit to admit all calls")
+	}
+}
+
+func TestLBPlacementClassInvalid(t *testing.T) {
+	cfg := pool.NewPlacerConfig()
+	a, err := NewLBAgent(&mockRunnerPool{}, pool.NewNaivePlacer(&cfg), WithLBMaxInFlight(2), WithLBReservedCapacity(0.5))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+
+	req, _ := http.NewRequest("GET", "http://www.example.com", nil)
+	c := &call{Call: &models.Call{ID: "call1", Type: models.TypeSync, CreatedAt: common.DateTime(time.Now())}, req: req}
+	c.Annotations, _ = models.EmptyAnnotations().With(models.PlacementClassAnnotation, "gold")
+	if err := a.Submit(c); err != models.ErrInvalidPlacementClass {
+		t.Fatalf("Expected invalid placement class, got %v", err)
+	}
+}
+
+func TestLBCallQueue(t *testing.T) {
+	q := newCallQueue("default", 2, time.Minute)
+	ctx := context.Background()
+	now := time.Now()
+
+	woken := make(chan string, 3)
+	wait := func(name string, priority pool.CallPriority, since time.Time) {
+		if q.Wait(ctx, &mockRunnerCall{priority: priority}, since) {
+			woken <- name
+		} else {
+			woken <- "rejected " + name
+		}
+	}
+	queued := func(n int) {
+		for i := 0; i < 100; i++ {
+			q.mtx.Lock()
+			l := len(q.waiting)
+			q.mtx.Unlock()
+			if l == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("Expected %d queued calls", n)
+	}
+
+	go wait("batch", pool.PriorityBatch, now.Add(-time.Second))
+	queued(1)
+	go wait("interactive", pool.PriorityInteractive, now)
+	queued(2)
+
+	// the queue is full
+	wait("another", pool.PriorityInteractive, now)
+	if w := <-woken; w != "rejected another" {
+		t.Fatalf("Expected a full queue to reject the call, got %s", w)
+	}
+
+	// interactive calls go first, then the oldest
+	q.freed()
+	if w := <-woken; w != "interactive" {
+		t.Fatalf("Expected the interactive call woken first, got %s", w)
+	}
+	q.freed()
+	if w := <-woken; w != "batch" {
+		t.Fatalf("Expected the batch call woken next, got %s", w)
+	}
+	q.freed()
+
+	// calls that waited too long are rejected
+	wait("late", pool.PriorityInteractive, now.Add(-time.Hour))
+	if w := <-woken; w != "rejected late" {
+		t.Fatalf("Expected a call waiting too long to be rejected, got %s", w)
+	}
+
+	var nilQueue *callQueue
+	nilQueue.freed()
+	if nilQueue.withCallQueue(ctx) != ctx {
+		t.Fatal("Expected a nil queue not to be used")
+	}
+}
+
+func TestLBCallQueueRecheck(t *testing.T) {
+	q := newCallQueue("default", 1, 40*time.Millisecond)
+	call := &mockRunnerCall{priority: pool.PriorityInteractive}
+	since := time.Now()
+
+	// queued calls try again several times before they are rejected
+	tries := 0
+	for q.Wait(context.Background(), call, since) {
+		tries++
+	}
+	if tries < queueRechecks-2 || tries > queueRechecks {
+		t.Fatalf("Expected about %d rechecks, got %d", queueRechecks, tries)
+	}
+	if time.Since(since) < 40*time.Millisecond {
+		t.Fatalf("Expected the call rejected after max wait, got %v", time.Since(since))
+	}
+}
+
+func TestLBBackpressure(t *testing.T) {
+	cfg := pool.NewPlacerConfig()
+	a, err := NewLBAgent(&mockRunnerPool{}, pool.NewNaivePlacer(&cfg),
+		WithLBCallQueue(1, 3*time.Second), WithLBBackpressure(0.5, time.Minute))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+	lb := a.(*lbAgent)
+	p := lbRunnerPool{queue: lb.queue, overload: lb.overload}
+
+	if after := lb.RetryAfter(); after != time.Minute {
+		t.Fatalf("Expected to retry after the window, got %v", after)
+	}
+
+	// every call fails to be placed in the last window
+	for i := 0; i < minBackpressureCalls; i++ {
+		lb.overload.observe(models.ErrCallTimeoutServerBusy)
+	}
+	lb.overload.mtx.Lock()
+	lb.overload.windowStart = time.Now().Add(-time.Minute)
+	lb.overload.mtx.Unlock()
+	if err := p.admit(); err != models.ErrServerOverloaded {
+		t.Fatalf("Expected the call to be shed, got %v", err)
+	}
+
+	// a full queue rejects calls before they are read
+	lb.queue.push(&queuedCall{since: time.Now(), wake: make(chan struct{}, 1)})
+	if err := p.admit(); err != models.ErrCallQueueFull {
+		t.Fatalf("Expected the call to be rejected by a full queue, got %v", err)
+	}
+	lb.queue.freed()
+
+	// the failure rate of a window mostly placing calls sheds nothing
+	for i := 0; i < minBackpressureCalls; i++ {
+		lb.overload.observe(nil)
+	}
+	lb.overload.mtx.Lock()
+	lb.overload.windowStart = time.Now().Add(-time.Minute)
+	lb.overload.mtx.Unlock()
+	for i := 0; i < 10; i++ {
+		if err := p.admit(); err != nil {
+			t.Fatalf("Expected the call to be admitted, got %v", err)
+		}
+	}
+
+	var nilDetector *overloadDetector
+	nilDetector.observe(models.ErrCallTimeoutServerBusy)
+	if nilDetector.shed() {
+		t.Fatal("Expected a nil detector not to shed calls")
+	}
+}
+
+func TestLBSessionAffinity(t *testing.T) {
+	cfg := pool.NewPlacerConfig()
+	a, err := NewLBAgent(&mockRunnerPool{}, pool.NewNaivePlacer(&cfg), WithLBSessionAffinity(10, time.Minute))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+	lb := a.(*lbAgent)
+
+	req, _ := http.NewRequest("GET", "http://www.example.com", nil)
+	req.Header.Set("Fn-Session-Id", "abc")
+	c := &call{Call: &models.Call{ID: "call1", FnID: "fn1"}, req: req}
+
+	// calls of fns without the annotation have no session
+	ctx := context.Background()
+	if lb.withSession(ctx, c) != ctx {
+		t.Fatal("Expected no session without the session header annotation")
+	}
+
+	c.Annotations, _ = models.EmptyAnnotations().With(models.SessionHeaderAnnotation, "Fn-Session-Id")
+	lb.sessions.Set("fn1/abc", "192.0.2.0")
+	if lb.withSession(ctx, c) == ctx {
+		t.Fatal("Expected a session for the session key of the call")
+	}
+
+	req.Header.Del("Fn-Session-Id")
+	if lb.withSession(ctx, c) != ctx {
+		t.Fatal("Expected no session for a call without a session key")
+	}
+}
+
+// echoRunner responds to calls with their request body
+type echoRunner struct {
+	mockRunner
+	calls int
+}
+
+func (r *echoRunner) TryExec(ctx context.Context, call pool.RunnerCall) (bool, error) {
+	r.mtx.Lock()
+	r.calls++
+	r.mtx.Unlock()
+	body, _ := ioutil.ReadAll(call.RequestBody())
+	w := call.ResponseWriter()
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+	return true, nil
+}
+
+func TestLBResponseCache(t *testing.T) {
+	cfg := pool.NewPlacerConfig()
+	r := &echoRunner{mockRunner: mockRunner{addr: "192.0.2.1"}}
+	a, err := NewLBAgent(&mockRunnerPool{runners: []pool.Runner{r}}, pool.NewNaivePlacer(&cfg), WithLBResponseCache(10, 16))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+	defer a.Close()
+	lb := a.(*lbAgent)
+
+	cached, _ := models.EmptyAnnotations().With(models.ResponseCacheTTLAnnotation, 60)
+	submit := func(annotations models.Annotations, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "http://www.example.com/invoke/fn1", strings.NewReader(body))
+		rw := httptest.NewRecorder()
+		rw.Header().Set("Fn-Call-Id", "call1")
+		c := &call{
+			Call:       &models.Call{ID: "call1", FnID: "fn1", Type: models.TypeSync, Method: req.Method, URL: req.URL.String(), Annotations: annotations, CreatedAt: common.DateTime(time.Now())},
+			req:        req,
+			respWriter: rw,
+			ct:         lb,
+			stderr:     common.NoopReadWriteCloser{},
+		}
+		if err := a.Submit(c); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if rw.Body.String() != body || rw.Header().Get("Content-Type") != "text/plain" || len(rw.Header()["Fn-Call-Id"]) != 1 {
+			t.Fatalf("Expected the response %q, got %q %v", body, rw.Body.String(), rw.Header())
+		}
+		return rw
+	}
+
+	if rw := submit(cached, "a"); rw.Header().Get(ResponseCacheHeader) != "" || r.calls != 1 {
+		t.Fatalf("Expected the first call to run, got %d calls %v", r.calls, rw.Header())
+	}
+	if rw := submit(cached, "a"); rw.Header().Get(ResponseCacheHeader) != "hit" || r.calls != 1 {
+		t.Fatalf("Expected the same call served from the cache, got %d calls %v", r.calls, rw.Header())
+	}
+	submit(cached, "b")
+	if r.calls != 2 {
+		t.Fatalf("Expected a call with another body to run, got %d calls", r.calls)
+	}
+	submit(models.EmptyAnnotations(), "c")
+	submit(models.EmptyAnnotations(), "c")
+	if r.calls != 4 {
+		t.Fatalf("Expected calls of fns without the annotation to run, got %d calls", r.calls)
+	}
+	// responses larger than the max body are not cached
+	submit(cached, "a large response body")
+	submit(cached, "a large response body")
+	if r.calls != 6 {
+		t.Fatalf("Expected large responses not to be cached, got %d calls", r.calls)
+	}
+
+	for _, elem := range lb.cache.entries {
+		elem.Value.(*cachedResponse).expires = time.Now()
+	}
+	submit(cached, "a")
+	if r.calls != 7 {
+		t.Fatalf("Expected the call to run once its response expired, got %d calls", r.calls)
+	}
+
+	// failed responses and those that are not to be stored are not cached
+	w := newCacheResponseWriter(httptest.NewRecorder(), 16)
+	w.WriteHeader(http.StatusBadGateway)
+	if w.response("key", time.Minute) != nil {
+		t.Fatal("Expected a failed response not to be cached")
+	}
+	w = newCacheResponseWriter(httptest.NewRecorder(), 16)
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.Write([]byte("secret"))
+	if w.response("key", time.Minute) != nil {
+		t.Fatal("Expected a no-store response not to be cached")
+	}
+}
+
+func TestLBBodySpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lb-body-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, opt := range []LBAgentOption{
+		WithLBBodySpool(0, 0, dir),
+		WithLBBodySpool(16, 8, dir),
+		WithLBBodySpool(16, 0, dir+"/missing"),
+	} {
+		if err := opt(&lbAgent{}); err == nil {
+			t.Fatal("Expected an invalid body spool to fail")
+		}
+	}
+	cfg := pool.NewPlacerConfig()
+	a, err := NewLBAgent(&mockRunnerPool{}, pool.NewNaivePlacer(&cfg), WithLBBodySpool(16, 64, dir))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+	defer a.Close()
+	lb := a.(*lbAgent)
+
+	spool := func(body string) (*call, func(), error) {
+		req, _ := http.NewRequest("POST", "http://www.example.com", ioutil.NopCloser(strings.NewReader(body)))
+		c := &call{Call: &models.Call{ID: "call1", Type: models.TypeSync}, req: req}
+		release, err := lb.spoolRequestBody(context.Background(), c)
+		return c, release, err
+	}
+	read := func(c *call) string {
+		body, err := c.req.GetBody()
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		data, _ := ioutil.ReadAll(body)
+		return string(data)
+	}
+
+	small := "payload"
+	c, release, err := spool(small)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 || read(c) != small {
+		t.Fatalf("Expected the small body in memory, got %d files", len(files))
+	}
+	release()
+
+	large := strings.Repeat("payload ", 8)
+	c, release, err = spool(large)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	// retried placements read the body again
+	for i := 0; i < 2; i++ {
+		if body := read(c); body != large {
+			t.Fatalf("Expected the spooled body, got %q", body)
+		}
+	}
+	release()
+
+	if _, _, err := spool(large + "!"); err != models.ErrRequestContentTooBig {
+		t.Fatalf("Expected the body over the max size to fail, got %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("Expected no spooled files left, got %d", len(files))
+	}
+}
+
+// blockingRunner is a mock runner that runs calls until release is closed
+type blockingRunner struct {
+	mockRunner
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingRunner) TryExec(ctx context.Context, call pool.RunnerCall) (bool, error) {
+	r.started <- struct{}{}
+	<-r.release
+	call.ResponseWriter().WriteHeader(http.StatusOK)
+	return true, nil
+}
+
+func TestLBDrain(t *testing.T) {
+	cfg := pool.NewPlacerConfig()
+	r := &blockingRunner{mockRunner: mockRunner{addr: "192.0.2.1"}, started: make(chan struct{}, 1), release: make(chan struct{})}
+	a, err := NewLBAgent(&mockRunnerPool{runners: []pool.Runner{r}}, pool.NewNaivePlacer(&cfg))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+	defer a.Close()
+	d := a.(Drainer)
+
+	newCall := func(id string) *call {
+		req, _ := http.NewRequest("GET", "http://www.example.com", nil)
+		return &call{
+			Call:       &models.Call{ID: id, Type: models.TypeSync, CreatedAt: common.DateTime(time.Now())},
+			req:        req,
+			respWriter: httptest.NewRecorder(),
+			ct:         a.(*lbAgent),
+			stderr:     common.NoopReadWriteCloser{},
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- a.Submit(newCall("call1")) }()
+	<-r.started
+
+	d.Drain(true)
+	if status := d.DrainStatus(); !status.Draining || status.Drained || status.CallsInFlight != 1 {
+		t.Fatalf("Expected a draining agent with a call in flight, got %+v", status)
+	}
+	if err := a.Submit(newCall("call2")); err != models.ErrServerDraining {
+		t.Fatalf("Expected new calls to fail while draining, got %v", err)
+	}
+
+	// the call in flight finishes
+	close(r.release)
+	if err := <-errs; err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if status := d.DrainStatus(); !status.Drained || status.CallsInFlight != 0 {
+		t.Fatalf("Expected a drained agent, got %+v", status)
+	}
+
+	d.Drain(false)
+	if status := d.DrainStatus(); status.Draining || status.Drained {
+		t.Fatalf("Expected the agent to take calls again, got %+v", status)
+	}
+	if err := a.Submit(newCall("call3")); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+// detachedRunner is a mock runner that acknowledges detached calls and runs them until
+// release is closed, responding with body
+type detachedRunner struct {
+	mockRunner
+	release chan struct{}
+	body    string
+}
+
+func (r *detachedRunner) TryExec(ctx context.Context, call pool.RunnerCall) (bool, error) {
+	w := call.ResponseWriter().(*DetachedResponseWriter)
+	w.WriteHeader(http.StatusAccepted)
+	<-r.release
+	w.Write([]byte(r.body))
+	w.setResponse(&pb.DetachedResponse{Http: &pb.HttpRespMeta{
+		StatusCode: http.StatusCreated,
+		Headers:    []*pb.HttpHeader{{Key: "Content-Type", Value: "text/plain"}},
+	}})
+	return true, nil
+}
+
+func TestLBCallResults(t *testing.T) {
+	if err := WithLBCallResults(nil, 1024)(&lbAgent{}); err == nil {
+		t.Fatal("Expected call results without a store to be rejected")
+	}
+
+	cfg := pool.NewPlacerConfig()
+	r := &detachedRunner{mockRunner: mockRunner{addr: "192.0.2.1"}, release: make(chan struct{}), body: "hello"}
+	a, err := NewLBAgent(&mockRunnerPool{runners: []pool.Runner{r}}, pool.NewNaivePlacer(&cfg),
+		WithLBCallResults(NewMemoryCallResultStore(10, time.Minute), 1024))
+	if err != nil {
+		t.Fatalf("Unexpected error in creating LB Agent, %s", err.Error())
+	}
+	defer a.Close()
+	results := a.(CallResults)
+	ctx := context.Background()
+
+	req, _ := http.NewRequest("POST", "http://www.example.com", nil)
+	c := &call{
+		Call:       &models.Call{ID: "call1", FnID: "fn1", Type: models.TypeDetached, CreatedAt: common.DateTime(time.Now())},
+		req:        req,
+		respWriter: NewDetachedResponseWriter(make(http.Header), http.StatusAccepted),
+		ct:         a.(*lbAgent),
+		stderr:     common.NoopReadWriteCloser{},
+	}
+	if err := a.Submit(c); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	result, err := results.CallResult(ctx, "fn1", "call1")
+	if err != nil || result.Done {
+		t.Fatalf("Expected the call to be running, got %+v %v", result, err)
+	}
+	if _, err := results.CallResult(ctx, "fn2", "call1"); err != models.ErrCallNotFound {
+		t.Fatalf("Expected no result of the call of another fn, got %v", err)
+	}
+
+	close(r.release)
+	for i := 0; ; i++ {
+		result, err = results.CallResult(ctx, "fn1", "call1")
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if result.Done {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Expected the call to be done")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if result.Status != http.StatusCreated || result.Header.Get("Content-Type") != "text/plain" || string(result.Body) != "hello" || result.Error != "" {
+		t.Fatalf("Expected the response of the fn, got %+v", result)
+	}
+
+	// responses over the max body fail the call
+	w := NewDetachedResponseWriter(make(http.Header), http.StatusAccepted)
+	w.maxBody = 4
+	w.Write([]byte("hello"))
+	result = &CallResult{}
+	w.result(result, nil)
+	if result.Status != http.StatusBadGateway || result.Error != models.ErrFunctionResponseTooBig.Error() {
+		t.Fatalf("Expected the call to fail with a response too big, got %+v", result)
+	}
+	w.result(result, errors.New("boom"))
+	if result.Status != http.StatusInternalServerError || result.Error == "boom" {
+		t.Fatalf("Expected internal errors to be hidden, got %+v", result)
+	}
+}
+
+func TestMemoryCallResultStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryCallResultStore(2, time.Minute)
+	for _, id := range []string{"call1", "call2", "call3"} {
+		s.PutCallResult(ctx, &CallResult{CallID: id, FnID: "fn1", Done: true})
+	}
+	if _, err := s.GetCallResult(ctx, "fn1", "call1"); err != models.ErrCallNotFound {
+		t.Fatalf("Expected the oldest result to be dropped, got %v", err)
+	}
+	if result, err := s.GetCallResult(ctx, "fn1", "call3"); err != nil || !result.Done {
+		t.Fatalf("Expected the result of call3, got %+v %v", result, err)
+	}
+
+	s = NewMemoryCallResultStore(2, 0)
+	s.PutCallResult(ctx, &CallResult{CallID: "call1", FnID: "fn1"})
+	time.Sleep(time.Millisecond)
+	if _, err := s.GetCallResult(ctx, "fn1", "call1"); err != models.ErrCallNotFound {
+		t.Fatalf("Expected the result to expire, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
+)
+
+// Backpressure is implemented by agents that shed calls when their runners cannot take
+// more, so that the API layer can tell clients when to come back
+type Backpressure interface {
+	// RetryAfter is how long clients should wait before retrying calls rejected as too
+	// busy, zero if the agent does not know
+	RetryAfter() time.Duration
+}
+
+// minBackpressureCalls is the least number of calls of a window for its placement failure
+// rate to shed calls
+const minBackpressureCalls = 10
+
+// WithLBBackpressure sheds calls of a runner pool before their request body is read, once
+// threshold of the calls of the last window failed to be placed as too busy, or while the
+// call queue of the pool is full, see WithLBCallQueue. Calls are shed with a probability
+// of the failure rate, with models.ErrServerOverloaded, so that the rest keep measuring
+// it, and with models.ErrCallQueueFull while the queue is full.
+func WithLBBackpressure(threshold float64, window time.Duration) LBAgentOption {
+	return func(a *lbAgent) error {
+		if threshold <= 0 || threshold > 1 || window <= 0 {
+			return errors.New("lb-agent backpressure needs a threshold between 0 and 1 and a window")
+		}
+		a.overloadThreshold = threshold
+		a.overloadWindow = window
+		return nil
+	}
+}
+
+// overloadDetector measures the placement failure rate of a runner pool over windows, a
+// nil detector never sheds calls
+type overloadDetector struct {
+	threshold float64
+	window    time.Duration
+
+	mtx         sync.Mutex
+	windowStart time.Time
+	placed      uint64
+	failed      uint64
+	// failure rate of the last window
+	rate float64
+}
+
+func newOverloadDetector(threshold float64, window time.Duration) *overloadDetector {
+	if threshold <= 0 {
+		return nil
+	}
+	return &overloadDetector{threshold: threshold, window: window, windowStart: time.Now()}
+}
+
+// observe records the placement of a call that ended with err
+func (d *overloadDetector) observe(err error) {
+	if d == nil {
+		return
+	}
+	_, busy := pool.RejectReasonOf(err)
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.rollLocked(time.Now())
+	if busy {
+		d.failed++
+	} else {
+		d.placed++
+	}
+}
+
+// shed returns true if a new call should be rejected
+func (d *overloadDetector) shed() bool {
+	if d == nil {
+		return false
+	}
+	d.mtx.Lock()
+	rate := d.rollLocked(time.Now())
+	d.mtx.Unlock()
+	return rate >= d.threshold && rand.Float64() < rate
+}
+
+// rollLocked starts a new window if the current one is over, returns the failure rate of
+// the last window
+func (d *overloadDetector) rollLocked(now time.Time) float64 {
+	elapsed := now.Sub(d.windowStart)
+	if elapsed < d.window {
+		return d.rate
+	}
+	d.rate = 0
+	if total := d.placed + d.failed; total >= minBackpressureCalls && elapsed < 2*d.window {
+		d.rate = float64(d.failed) / float64(total)
+	}
+	d.windowStart = now
+	d.placed, d.failed = 0, 0
+	return d.rate
+}
+
+// admit returns an error if a call of the pool should be shed
+func (p lbRunnerPool) admit() error {
+	if p.overload == nil {
+		return nil
+	}
+	if p.queue.full() {
+		return models.ErrCallQueueFull
+	}
+	if p.overload.shed() {
+		return models.ErrServerOverloaded
+	}
+	return nil
+}
+
+// RetryAfter implements Backpressure, the window of the placement failure rate or the max
+// wait of the call queue, whichever is longer, in whole seconds
+func (a *lbAgent) RetryAfter() time.Duration {
+	if a.overloadWindow <= 0 {
+		return 0
+	}
+	after := a.overloadWindow
+	if a.queueMaxWait > after {
+		after = a.queueMaxWait
+	}
+	if after < time.Second {
+		return time.Second
+	}
+	return after.Round(time.Second)
+}
@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+)
+
+// WithLBBodySpool bounds the memory the request bodies of calls take up on the LB, which
+// buffers bodies so that calls can be retried on other runners. Bodies of up to maxMemory
+// bytes are buffered in memory, larger ones are spooled to a file in dir, the default temp
+// dir if empty. Calls with bodies larger than maxSize bytes fail with
+// models.ErrRequestContentTooBig, no limit if 0. Without it, bodies are buffered in memory.
+func WithLBBodySpool(maxMemory, maxSize int64, dir string) LBAgentOption {
+	return func(a *lbAgent) error {
+		if maxMemory <= 0 || maxSize < 0 || (maxSize > 0 && maxSize < maxMemory) {
+			return errors.New("lb-agent body spool needs a max memory of at most the max body size")
+		}
+		if dir != "" {
+			if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+				return errors.New("lb-agent body spool dir is not a directory")
+			}
+		}
+		a.spool = bodySpooler{maxMemory: maxMemory, maxSize: maxSize, dir: dir}
+		return nil
+	}
+}
+
+// bodySpooler buffers request bodies in memory up to maxMemory bytes, and spools the larger
+// ones to files in dir, up to maxSize bytes. Buffers are unbounded if maxMemory is 0.
+type bodySpooler struct {
+	maxMemory int64
+	maxSize   int64
+	dir       string
+}
+
+// spoolRequestBody buffers the request body of call, if not already done, and sets GetBody
+// of its request to read the body from the start. The returned func releases the buffer of
+// the body once the call is done with it.
+func (a *lbAgent) spoolRequestBody(ctx context.Context, call *call) (func(), error) {
+	r := call.req
+	if r.Body == nil || r.GetBody != nil {
+		return func() {}, nil
+	}
+
+	// WARNING: we need to handle IO in a separate go-routine below
+	// to be able to detect a ctx timeout. When we timeout, we
+	// let gin/http-server to unblock the go-routine below.
+	var body *spooledBody
+	var err error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		body, err = a.spool.spool(r.Body)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		// the body is released once it is read
+		go func() {
+			<-done
+			if body != nil {
+				body.release()
+			}
+		}()
+		return func() {}, ctx.Err()
+	}
+	if err != nil {
+		return func() {}, err
+	}
+
+	r.Body = body.reader()
+	// GetBody does not mutate the state of the request body
+	r.GetBody = func() (io.ReadCloser, error) {
+		return body.reader(), nil
+	}
+	if body.file != nil {
+		common.Logger(ctx).WithField("size", body.size).Debug("spooled request body to disk")
+	}
+	return body.release, nil
+}
+
+// spooledBody is a request body buffered in memory, or in a file if file is not nil
+type spooledBody struct {
+	buf  *bytes.Buffer
+	file *os.File
+	size int64
+}
+
+// spool reads src until EOF, into memory up to maxMemory bytes and into a file past that
+func (s *bodySpooler) spool(src io.Reader) (*spooledBody, error) {
+	body := &spooledBody{buf: bufPool.Get().(*bytes.Buffer)}
+	body.buf.Reset()
+
+	inMemory := src
+	if s.maxMemory > 0 {
+		inMemory = io.LimitReader(src, s.maxMemory+1)
+	}
+	n, err := body.buf.ReadFrom(inMemory)
+	body.size = n
+	if err != nil {
+		body.release()
+		return nil, err
+	}
+	if s.maxMemory == 0 || n <= s.maxMemory {
+		return body, nil
+	}
+
+	body.file, err = ioutil.TempFile(s.dir, "fn-body-")
+	if err != nil {
+		body.release()
+		return nil, err
+	}
+	// the file is gone once closed, even if the LB dies
+	os.Remove(body.file.Name())
+
+	rest := src
+	if s.maxSize > 0 {
+		rest = io.LimitReader(src, s.maxSize+1-n)
+	}
+	if _, err = body.file.Write(body.buf.Bytes()); err == nil {
+		n, err = io.Copy(body.file, rest)
+		body.size += n
+	}
+	body.buf.Reset()
+	if err == nil && s.maxSize > 0 && body.size > s.maxSize {
+		err = models.ErrRequestContentTooBig
+	}
+	if err != nil {
+		body.release()
+		return nil, err
+	}
+	return body, nil
+}
+
+// reader returns a reader of the body from its start, readers of a body may read it at once
+func (b *spooledBody) reader() io.ReadCloser {
+	if b.file != nil {
+		return ioutil.NopCloser(io.NewSectionReader(b.file, 0, b.size))
+	}
+	return ioutil.NopCloser(bytes.NewReader(b.buf.Bytes()))
+}
+
+func (b *spooledBody) release() {
+	if b.file != nil {
+		b.file.Close()
+		// files of open handles are not removed on windows
+		os.Remove(b.file.Name())
+	}
+	b.buf.Reset()
+	bufPool.Put(b.buf)
+}
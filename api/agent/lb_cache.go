@@ -0,0 +1,236 @@
+package agent
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+)
+
+// ResponseCacheHeader is the header of responses served from the response cache of the LB,
+// see WithLBResponseCache
+const ResponseCacheHeader = "Fn-Response-Cache"
+
+// WithLBResponseCache caches the responses of up to size calls of fns with the
+// models.ResponseCacheTTLAnnotation annotation, the least recently used are dropped first.
+// Calls with the same fn, method, URL and body as a cached call are served the cached
+// response, with the ResponseCacheHeader header, without placing them on a runner. Responses
+// larger than maxBody bytes are not cached.
+func WithLBResponseCache(size, maxBody int) LBAgentOption {
+	return func(a *lbAgent) error {
+		if size <= 0 || maxBody <= 0 {
+			return errors.New("lb-agent response cache needs a number of responses and a max body size")
+		}
+		a.cache = newResponseCache(size, maxBody)
+		return nil
+	}
+}
+
+// responseCache is an LRU cache of the responses of calls by the hash of their request
+type responseCache struct {
+	mtx     sync.Mutex
+	size    int
+	maxBody int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type cachedResponse struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func newResponseCache(size, maxBody int) *responseCache {
+	return &responseCache{
+		size:    size,
+		maxBody: maxBody,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached response of key, nil if there is none or it expired
+func (c *responseCache) get(key string) *cachedResponse {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	resp := elem.Value.(*cachedResponse)
+	if time.Now().After(resp.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return resp
+}
+
+func (c *responseCache) set(resp *cachedResponse) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if elem, ok := c.entries[resp.key]; ok {
+		elem.Value = resp
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[resp.key] = c.lru.PushFront(resp)
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+// responseCacheKey returns the key of call in the response cache and how long its response
+// is cached, or an empty key if its response is not cached. The body of call is buffered.
+func (a *lbAgent) responseCacheKey(call *call) (string, time.Duration) {
+	if a.cache == nil || call.Type != models.TypeSync {
+		return "", 0
+	}
+	// annotations are validated with the app and fn
+	ttl, err := call.Annotations.ResponseCacheTTL()
+	if err != nil || ttl == 0 {
+		return "", 0
+	}
+
+	h := sha256.New()
+	for _, s := range []string{call.FnID, call.Method, call.URL} {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+	if body := call.RequestBody(); body != nil {
+		_, err := io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return "", 0
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), ttl
+}
+
+// serveCachedResponse writes the cached response of key for call, if there is one
+func (a *lbAgent) serveCachedResponse(ctx context.Context, call *call, key string) bool {
+	resp := a.cache.get(key)
+	if resp == nil {
+		return false
+	}
+	w := call.ResponseWriter()
+	for k, vs := range resp.header {
+		w.Header()[k] = append([]string(nil), vs...)
+	}
+	w.Header().Set(ResponseCacheHeader, "hit")
+	w.WriteHeader(resp.status)
+	if _, err := w.Write(resp.body); err != nil {
+		common.Logger(ctx).WithError(err).Info("failed to write cached response")
+	}
+	return true
+}
+
+// placeCachedCall places call like placeCall, and caches its response under key for ttl if it
+// succeeds
+func (a *lbAgent) placeCachedCall(ctx context.Context, call *call, p lbRunnerPool, flight *inFlightCall, key string, ttl time.Duration) error {
+	w := newCacheResponseWriter(call.ResponseWriter(), a.cache.maxBody)
+	call.respWriter = w
+	err := a.placeCall(ctx, call, p, flight)
+	if err == nil {
+		if resp := w.response(key, ttl); resp != nil {
+			a.cache.set(resp)
+		}
+	}
+	return err
+}
+
+// cacheResponseWriter records the response of a call for the response cache as it is written
+type cacheResponseWriter struct {
+	http.ResponseWriter
+	// headers of the response before the call ran, eg. Fn-Call-Id, which are not cached
+	pre     http.Header
+	status  int
+	body    bytes.Buffer
+	tooBig  bool
+	maxBody int
+}
+
+var _ http.Flusher = &cacheResponseWriter{}
+
+func newCacheResponseWriter(w http.ResponseWriter, maxBody int) *cacheResponseWriter {
+	return &cacheResponseWriter{ResponseWriter: w, pre: w.Header().Clone(), maxBody: maxBody}
+}
+
+func (w *cacheResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.tooBig {
+		if w.body.Len()+len(data) > w.maxBody {
+			w.tooBig = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(data)
+		}
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher, if the response writer of the call does
+func (w *cacheResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// response returns the recorded response to cache under key for ttl, nil if it is not
+// cacheable: not successful, too big or with Cache-Control: no-store
+func (w *cacheResponseWriter) response(key string, ttl time.Duration) *cachedResponse {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if w.tooBig || status < 200 || status > 299 {
+		return nil
+	}
+	header := make(http.Header)
+	for k, vs := range w.Header() {
+		if pre, ok := w.pre[k]; ok && reflect.DeepEqual(pre, vs) {
+			continue
+		}
+		header[k] = append([]string(nil), vs...)
+	}
+	for _, v := range header["Cache-Control"] {
+		if strings.Contains(strings.ToLower(v), "no-store") {
+			return nil
+		}
+	}
+	return &cachedResponse{
+		key:     key,
+		status:  status,
+		header:  header,
+		body:    append([]byte(nil), w.body.Bytes()...),
+		expires: time.Now().Add(ttl),
+	}
+}
@@ -0,0 +1,301 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fnproject/fn/api/common"
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/sirupsen/logrus"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// DefaultRunnerPoolName is the name of the runner pool of NewLBAgent in capacity signals
+const DefaultRunnerPoolName = "default"
+
+// CapacitySignalConfig configures how the LB agent computes the desired capacity of its
+// runner pools, see WithLBCapacitySignal
+type CapacitySignalConfig struct {
+	// Interval is how often the signal is computed from the calls of the interval
+	Interval time.Duration
+	// TargetActiveCalls is the number of calls running on a runner the pool is sized for.
+	// Zero keeps the current number of runners unless calls wait or fail.
+	TargetActiveCalls int
+	// MaxQueueWait is the average time calls may spend in the LB, excluding their execution,
+	// above which the pool should grow. Zero only grows the pool on placement failures.
+	MaxQueueWait time.Duration
+	// WebhookURL, if set, is sent the signals of a pool as JSON when its desired runners change
+	WebhookURL string
+}
+
+// CapacitySignal is the desired capacity of a runner pool computed by the LB agent, for
+// external autoscalers of the pure runners
+type CapacitySignal struct {
+	Pool string `json:"pool"`
+	// Runners is the number of runners in the pool, ReadyRunners those taking calls
+	Runners      int `json:"runners"`
+	ReadyRunners int `json:"ready_runners"`
+	// ActiveCalls is the number of calls running on the runners, from their status
+	ActiveCalls int `json:"active_calls"`
+	// PlacedCalls and FailedPlacements are the calls placed and the calls rejected as too
+	// busy during the interval
+	PlacedCalls      uint64 `json:"placed_calls"`
+	FailedPlacements uint64 `json:"failed_placements"`
+	// AvgQueueWait is the average time calls spent in the LB, excluding their execution
+	AvgQueueWait time.Duration `json:"avg_queue_wait_ns"`
+	// DesiredRunners is the number of runners the pool should have
+	DesiredRunners int             `json:"desired_runners"`
+	UpdatedAt      common.DateTime `json:"updated_at"`
+}
+
+// CapacitySignaler is implemented by agents that compute capacity signals of their runner pools
+type CapacitySignaler interface {
+	// CapacitySignals returns the last signals of the runner pools, ordered by pool
+	CapacitySignals() []CapacitySignal
+}
+
+// WithLBCapacitySignal makes the LB agent compute the desired capacity of its runner pools
+// every cfg.Interval from placement failures, the time calls wait in the LB and the active
+// calls of the runners. The signals are exported as the lb_desired_runners metric, by
+// CapacitySignals and, if configured, to a webhook.
+func WithLBCapacitySignal(cfg CapacitySignalConfig) LBAgentOption {
+	return func(a *lbAgent) error {
+		if cfg.Interval <= 0 || cfg.TargetActiveCalls < 0 || cfg.MaxQueueWait < 0 {
+			return fmt.Errorf("Invalid capacity signal config %+v", cfg)
+		}
+		a.capacity = newCapacityTracker(cfg)
+		return nil
+	}
+}
+
+// poolWindow are the calls of a pool during the current interval
+type poolWindow struct {
+	placed uint64
+	failed uint64
+	waited time.Duration
+}
+
+// capacityTracker computes the capacity signals of the runner pools of an LB agent
+type capacityTracker struct {
+	cfg     CapacitySignalConfig
+	client  *http.Client
+	mtx     sync.Mutex
+	windows map[string]*poolWindow
+	signals map[string]CapacitySignal
+}
+
+func newCapacityTracker(cfg CapacitySignalConfig) *capacityTracker {
+	return &capacityTracker{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Interval},
+		windows: make(map[string]*poolWindow),
+		signals: make(map[string]CapacitySignal),
+	}
+}
+
+// observe records the placement of a call on the named pool that ended with err
+func (c *capacityTracker) observe(name string, call *call, err error) {
+	if _, busy := pool.RejectReasonOf(err); err != nil && !busy {
+		// failures of the call itself say nothing about the capacity of the pool
+		return
+	}
+	wait := callOverhead(call)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	w := c.windows[name]
+	if w == nil {
+		w = &poolWindow{}
+		c.windows[name] = w
+	}
+	if err != nil {
+		w.failed++
+	} else {
+		w.placed++
+	}
+	w.waited += wait
+}
+
+// run computes the signals of pools every interval until ctx is done
+func (c *capacityTracker) run(ctx context.Context, pools map[string]pool.RunnerPool) {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for name, rp := range pools {
+			c.update(ctx, name, rp)
+		}
+	}
+}
+
+// update computes the signal of the named pool from the current interval and the status of
+// its runners, then starts a new interval
+func (c *capacityTracker) update(ctx context.Context, name string, rp pool.RunnerPool) {
+	signal := CapacitySignal{Pool: name, UpdatedAt: common.DateTime(time.Now())}
+
+	runners, err := rp.Runners(ctx, nil)
+	if err != nil {
+		logrus.WithError(err).WithField("runner_pool", name).Warn("Failed to list runners for capacity signal")
+		return
+	}
+	signal.Runners = len(runners)
+	signal.ActiveCalls = activeCalls(ctx, runners, c.cfg.Interval)
+	for _, r := range runners {
+		if r.Ready() {
+			signal.ReadyRunners++
+		}
+	}
+
+	c.mtx.Lock()
+	w := c.windows[name]
+	delete(c.windows, name)
+	last, seen := c.signals[name]
+	c.mtx.Unlock()
+	if w != nil {
+		signal.PlacedCalls, signal.FailedPlacements = w.placed, w.failed
+		if calls := w.placed + w.failed; calls > 0 {
+			signal.AvgQueueWait = w.waited / time.Duration(calls)
+		}
+	}
+	signal.DesiredRunners = c.desiredRunners(&signal)
+
+	c.mtx.Lock()
+	c.signals[name] = signal
+	c.mtx.Unlock()
+
+	statsDesiredRunners(name, signal.DesiredRunners)
+	if c.cfg.WebhookURL != "" && (!seen || last.DesiredRunners != signal.DesiredRunners) {
+		c.notify(ctx, signal)
+	}
+}
+
+// desiredRunners returns the runners a pool needs for its active calls, grown if calls
+// failed to be placed or waited longer than MaxQueueWait
+func (c *capacityTracker) desiredRunners(signal *CapacitySignal) int {
+	desired := signal.Runners
+	if c.cfg.TargetActiveCalls > 0 {
+		desired = int(math.Ceil(float64(signal.ActiveCalls) / float64(c.cfg.TargetActiveCalls)))
+	}
+
+	calls := signal.PlacedCalls + signal.FailedPlacements
+	if signal.FailedPlacements > 0 || (c.cfg.MaxQueueWait > 0 && signal.AvgQueueWait > c.cfg.MaxQueueWait) {
+		// grow by the share of calls that failed, by at least one runner
+		growth := 1
+		if calls > 0 {
+			growth = int(math.Ceil(float64(signal.Runners) * float64(signal.FailedPlacements) / float64(calls)))
+		}
+		if growth < 1 {
+			growth = 1
+		}
+		if desired < signal.Runners+growth {
+			desired = signal.Runners + growth
+		}
+	}
+	if desired < 1 && (calls > 0 || signal.ActiveCalls > 0) {
+		desired = 1
+	}
+	return desired
+}
+
+// notify posts signal to the webhook
+func (c *capacityTracker) notify(ctx context.Context, signal CapacitySignal) {
+	log := logrus.WithField("runner_pool", signal.Pool)
+	body, err := json.Marshal(signal)
+	if err != nil {
+		log.WithError(err).Error("Failed to encode capacity signal")
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, c.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		log.WithError(err).Error("Invalid capacity signal webhook")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		log.WithError(err).Warn("Failed to send capacity signal")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.WithField("status", resp.StatusCode).Warn("Capacity signal webhook failed")
+	}
+}
+
+// CapacitySignals implements CapacitySignaler, returns nothing before the first interval
+func (c *capacityTracker) CapacitySignals() []CapacitySignal {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	signals := make([]CapacitySignal, 0, len(c.signals))
+	for _, signal := range c.signals {
+		signals = append(signals, signal)
+	}
+	sort.Slice(signals, func(i, j int) bool {
+		return signals[i].Pool < signals[j].Pool
+	})
+	return signals
+}
+
+// activeCalls returns the sum of the active calls of runners, from their status, waiting at
+// most timeout. Runners whose status fails are not counted.
+func activeCalls(ctx context.Context, runners []pool.Runner, timeout time.Duration) int {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	counts := make(chan int, len(runners))
+	for _, r := range runners {
+		go func(r pool.Runner) {
+			status, err := r.Status(ctx)
+			if err != nil || status == nil || status.StatusFailed {
+				counts <- 0
+				return
+			}
+			counts <- int(status.ActiveRequestCount)
+		}(r)
+	}
+	active := 0
+	for range runners {
+		active += <-counts
+	}
+	return active
+}
+
+// callOverhead returns the time call spent in the LB, excluding its execution on a runner
+func callOverhead(call *call) time.Duration {
+	start := time.Time(call.StartedAt)
+	if start.IsZero() {
+		start = time.Time(call.CreatedAt)
+	}
+	if start.IsZero() {
+		return 0
+	}
+	overhead := time.Since(start)
+	if exec := call.GetUserExecutionTime(); exec != nil {
+		overhead -= *exec
+	}
+	if overhead < 0 {
+		return 0
+	}
+	return overhead
+}
+
+func statsDesiredRunners(name string, desired int) {
+	ctx, err := tag.New(context.Background(), tag.Upsert(runnerPoolKey, name))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	stats.Record(ctx, desiredRunnersMeasure.M(int64(desired)))
+}
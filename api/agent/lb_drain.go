@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Drainer is an agent that can be drained, eg. to roll the LB nodes of a deployment without
+// failing calls. A draining agent takes no new calls, they fail with models.ErrServerDraining,
+// and finishes the calls it has in flight.
+type Drainer interface {
+	// Drain drains the agent, or resumes taking calls if draining is false
+	Drain(draining bool)
+	// DrainStatus returns whether the agent is draining and the calls it has in flight
+	DrainStatus() DrainStatus
+}
+
+// DrainStatus is the drain state of an agent, see Drainer
+type DrainStatus struct {
+	Draining bool `json:"draining"`
+	// Drained is set once a draining agent has no calls in flight
+	Drained       bool  `json:"drained"`
+	CallsInFlight int64 `json:"calls_in_flight"`
+}
+
+var _ Drainer = &lbAgent{}
+
+// agentDrain counts the calls in flight of an agent and rejects new ones while draining
+type agentDrain struct {
+	// first for the 64-bit alignment of atomic operations
+	calls    int64
+	draining int32
+}
+
+// add adds a call in flight, returns false if the agent is draining. Calls are counted
+// before checking the drain, so that a drained agent has no call left about to start.
+func (d *agentDrain) add() bool {
+	atomic.AddInt64(&d.calls, 1)
+	if atomic.LoadInt32(&d.draining) != 0 {
+		d.done()
+		return false
+	}
+	return true
+}
+
+// done removes a call added by add
+func (d *agentDrain) done() {
+	atomic.AddInt64(&d.calls, -1)
+}
+
+// set sets whether the agent is draining, returns false if it already was
+func (d *agentDrain) set(draining bool) bool {
+	var state int32
+	if draining {
+		state = 1
+	}
+	return atomic.SwapInt32(&d.draining, state) != state
+}
+
+func (d *agentDrain) status() DrainStatus {
+	status := DrainStatus{
+		Draining:      atomic.LoadInt32(&d.draining) != 0,
+		CallsInFlight: atomic.LoadInt64(&d.calls),
+	}
+	status.Drained = status.Draining && status.CallsInFlight == 0
+	return status
+}
+
+// Drain implements Drainer
+func (a *lbAgent) Drain(draining bool) {
+	if a.drain.set(draining) {
+		logrus.WithFields(logrus.Fields{"draining": draining, "calls_in_flight": a.drain.status().CallsInFlight}).Info("lb-agent drain changed")
+	}
+}
+
+// DrainStatus implements Drainer. Detached calls are in flight until they are placed and
+// their runner is done with them, as sync calls.
+func (a *lbAgent) DrainStatus() DrainStatus {
+	return a.drain.status()
+}
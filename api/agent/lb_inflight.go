@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/fnproject/fn/api/models"
+)
+
+// WithLBMaxInFlight caps the calls being placed or running on each runner pool of the LB
+// agent to max. Calls over the cap are rejected right away with
+// models.ErrTooManyCallsInFlight instead of waiting for a runner, so that an overloaded
+// LB sheds load rather than piling up calls until they time out. Zero is no cap.
+func WithLBMaxInFlight(max int) LBAgentOption {
+	return func(a *lbAgent) error {
+		if max < 0 {
+			return errors.New("lb-agent max in-flight calls cannot be negative")
+		}
+		a.maxInFlight = max
+		return nil
+	}
+}
+
+// WithLBReservedCapacity reserves fraction of the in-flight cap of each runner pool, see
+// WithLBMaxInFlight, for calls of the models.PlacementClassReserved class. Calls of other
+// classes are rejected with models.ErrTooManyCallsInFlight once they would use reserved
+// capacity. A reserved call finding its pool full preempts the latest sync call of the
+// models.PlacementClassPreemptible class, which fails with models.ErrCallPreempted, or is
+// rejected if there is none. Detached calls are never preempted.
+func WithLBReservedCapacity(fraction float64) LBAgentOption {
+	return func(a *lbAgent) error {
+		if fraction < 0 || fraction > 1 {
+			return errors.New("lb-agent reserved capacity must be a fraction between 0 and 1")
+		}
+		a.reservedCapacity = fraction
+		return nil
+	}
+}
+
+// inFlightLimit counts the calls in flight on a runner pool, a nil limit has no cap
+type inFlightLimit struct {
+	max      int
+	reserved int
+
+	mtx     sync.Mutex
+	current int
+	// preemptible calls in flight, latest last
+	preemptible []*inFlightCall
+}
+
+// inFlightCall is a call admitted by an inFlightLimit
+type inFlightCall struct {
+	cancel    context.CancelFunc
+	preempted bool
+}
+
+func newInFlightLimit(max int, reservedFraction float64) *inFlightLimit {
+	if max <= 0 {
+		return nil
+	}
+	return &inFlightLimit{max: max, reserved: int(float64(max) * reservedFraction)}
+}
+
+// acquire adds a call of class in flight, returns false if the call is over the cap of its
+// class. cancel cancels the call if it is preemptible, nil if it cannot be preempted.
+func (l *inFlightLimit) acquire(class string, cancel context.CancelFunc) (*inFlightCall, bool) {
+	if l == nil {
+		return nil, true
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	max := l.max
+	if class != models.PlacementClassReserved {
+		max -= l.reserved
+	}
+	if l.current >= max {
+		if class != models.PlacementClassReserved || len(l.preemptible) == 0 {
+			return nil, false
+		}
+		// the slot of the victim is handed over once it is canceled
+		victim := l.preemptible[len(l.preemptible)-1]
+		l.preemptible = l.preemptible[:len(l.preemptible)-1]
+		victim.preempted = true
+		victim.cancel()
+		statsPreempted(context.Background())
+	}
+
+	f := &inFlightCall{}
+	l.current++
+	if class == models.PlacementClassPreemptible && cancel != nil {
+		f.cancel = cancel
+		l.preemptible = append(l.preemptible, f)
+	}
+	return f, true
+}
+
+// release removes a call added by acquire
+func (l *inFlightLimit) release(f *inFlightCall) {
+	if l == nil {
+		return
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.current--
+	for i, p := range l.preemptible {
+		if p == f {
+			l.preemptible = append(l.preemptible[:i], l.preemptible[i+1:]...)
+			break
+		}
+	}
+}
+
+// wasPreempted reports whether the call was canceled for a reserved call
+func (l *inFlightLimit) wasPreempted(f *inFlightCall) bool {
+	if l == nil || f == nil {
+		return false
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return f.preempted
+}
@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fnproject/fn/api/common"
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/sirupsen/logrus"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// WithLBCallQueue makes calls that all runners of their pool rejected wait in a queue of
+// the pool, instead of retrying the runners in a loop. Up to depth calls wait at most
+// maxWait each, interactive calls before batch calls and oldest first, and are woken to
+// try the runners again as calls of the pool finish. Calls finding the queue full, or
+// waiting longer than maxWait, fail as too busy.
+func WithLBCallQueue(depth int, maxWait time.Duration) LBAgentOption {
+	return func(a *lbAgent) error {
+		if depth <= 0 || maxWait <= 0 {
+			return errors.New("lb-agent call queue needs a depth and a max wait")
+		}
+		a.queueDepth = depth
+		a.queueMaxWait = maxWait
+		return nil
+	}
+}
+
+// queueRechecks is how many times during maxWait queued calls try the runners again even
+// if no call of the pool finished, eg. as calls placed by other LBs finish
+const queueRechecks = 4
+
+// queuedCall is a call waiting in a callQueue
+type queuedCall struct {
+	interactive bool
+	since       time.Time
+	wake        chan struct{}
+}
+
+// callQueue implements pool.CallQueue for a runner pool, a nil queue is not used
+type callQueue struct {
+	name    string
+	depth   int
+	maxWait time.Duration
+
+	mtx     sync.Mutex
+	waiting []*queuedCall
+}
+
+var _ pool.CallQueue = &callQueue{}
+
+func newCallQueue(name string, depth int, maxWait time.Duration) *callQueue {
+	if depth <= 0 {
+		return nil
+	}
+	return &callQueue{name: name, depth: depth, maxWait: maxWait}
+}
+
+// withCallQueue returns ctx for the placer to queue calls in q
+func (q *callQueue) withCallQueue(ctx context.Context) context.Context {
+	if q == nil {
+		return ctx
+	}
+	return pool.WithCallQueue(ctx, q)
+}
+
+// Wait implements pool.CallQueue
+func (q *callQueue) Wait(ctx context.Context, call pool.RunnerCall, since time.Time) bool {
+	wait := time.Until(since.Add(q.maxWait))
+	if wait <= 0 {
+		return false
+	}
+	recheck := q.maxWait / queueRechecks
+	if recheck > wait {
+		recheck = wait
+	}
+
+	w := &queuedCall{
+		interactive: call.Priority() != pool.PriorityBatch,
+		since:       since,
+		wake:        make(chan struct{}, 1),
+	}
+	if !q.push(w) {
+		return false
+	}
+	statsQueueDepth(q.name, 1)
+	start := time.Now()
+
+	t := common.NewTimer(recheck)
+	defer t.Stop()
+
+	var woken bool
+	select {
+	case <-w.wake:
+		woken = true
+	case <-t.C:
+		// try again unless this was the last chance
+		woken = recheck < wait
+	case <-ctx.Done():
+	}
+	q.remove(w)
+	statsQueueDepth(q.name, -1)
+	statsQueueWait(q.name, time.Since(start))
+	return woken
+}
+
+// push adds w to the queue in order, returns false if the queue is full
+func (q *callQueue) push(w *queuedCall) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.waiting) >= q.depth {
+		return false
+	}
+	i := sort.Search(len(q.waiting), func(i int) bool {
+		other := q.waiting[i]
+		if other.interactive != w.interactive {
+			return w.interactive
+		}
+		return w.since.Before(other.since)
+	})
+	q.waiting = append(q.waiting, nil)
+	copy(q.waiting[i+1:], q.waiting[i:])
+	q.waiting[i] = w
+	return true
+}
+
+// remove takes w out of the queue, if it is still queued
+func (q *callQueue) remove(w *queuedCall) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	for i, other := range q.waiting {
+		if other == w {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+// full reports whether calls queued now would be rejected
+func (q *callQueue) full() bool {
+	if q == nil {
+		return false
+	}
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return len(q.waiting) >= q.depth
+}
+
+// freed wakes the first queued call, as a call of the pool finished
+func (q *callQueue) freed() {
+	if q == nil {
+		return
+	}
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.waiting) == 0 {
+		return
+	}
+	w := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	w.wake <- struct{}{}
+}
+
+func statsQueueDepth(name string, delta int64) {
+	ctx, err := tag.New(context.Background(), tag.Upsert(runnerPoolKey, name))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	stats.Record(ctx, queueDepthMeasure.M(delta))
+}
+
+func statsQueueWait(name string, dur time.Duration) {
+	ctx, err := tag.New(context.Background(), tag.Upsert(runnerPoolKey, name))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	stats.Record(ctx, queueWaitMeasure.M(int64(dur/time.Millisecond)))
+}
@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+)
+
+// CallResultStatusHeader is the header of call results with the status of the call,
+// "running" until the call is done, then "success" or "error"
+const CallResultStatusHeader = "Fn-Call-Status"
+
+// CallResult is the result of a detached call, see WithLBCallResults
+type CallResult struct {
+	CallID string `json:"call_id"`
+	FnID   string `json:"fn_id"`
+	// Done is false while the call runs, the rest of the result is empty until then
+	Done bool `json:"done"`
+	// Status, Header and Body are the response of the fn. Status is the status of Error
+	// if the call failed.
+	Status      int             `json:"status,omitempty"`
+	Header      http.Header     `json:"header,omitempty"`
+	Body        []byte          `json:"body,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	CompletedAt common.DateTime `json:"completed_at"`
+}
+
+// CallResultStore stores the results of detached calls. Clients may retrieve the result of
+// a call from another LB than the one that took it, LBs sharing traffic need a shared store.
+type CallResultStore interface {
+	// PutCallResult stores result, replacing the result of the call stored before
+	PutCallResult(ctx context.Context, result *CallResult) error
+	// GetCallResult returns the result of the call of fnID, models.ErrCallNotFound if
+	// there is none
+	GetCallResult(ctx context.Context, fnID, callID string) (*CallResult, error)
+}
+
+// CallResults is implemented by agents keeping the results of detached calls
+type CallResults interface {
+	// CallResult returns the result of the call of fnID, models.ErrCallNotFound if there
+	// is none
+	CallResult(ctx context.Context, fnID, callID string) (*CallResult, error)
+	// KeepsCallResults reports whether the agent keeps the results of detached calls
+	KeepsCallResults() bool
+}
+
+// WithLBCallResults stores the results of detached calls in store, with up to maxBody bytes of
+// the body of their response. Calls with larger responses fail with
+// models.ErrFunctionResponseTooBig. Runners only send the responses of detached calls on
+// request, older runners send none and their calls have empty results.
+func WithLBCallResults(store CallResultStore, maxBody int64) LBAgentOption {
+	return func(a *lbAgent) error {
+		if store == nil || maxBody <= 0 {
+			return errors.New("lb-agent call results need a store and a max body size")
+		}
+		a.results = store
+		a.resultMaxBody = maxBody
+		return nil
+	}
+}
+
+// CallResult implements CallResults
+func (a *lbAgent) CallResult(ctx context.Context, fnID, callID string) (*CallResult, error) {
+	if a.results == nil {
+		return nil, models.ErrCallNotFound
+	}
+	return a.results.GetCallResult(ctx, fnID, callID)
+}
+
+// KeepsCallResults implements CallResults, with WithLBCallResults
+func (a *lbAgent) KeepsCallResults() bool {
+	return a.results != nil
+}
+
+// recordDetachedResponse has the response writer of the detached call record the response
+// of the fn, if the agent stores call results
+func (a *lbAgent) recordDetachedResponse(call *call) {
+	if a.results != nil {
+		call.respWriter.(*DetachedResponseWriter).maxBody = a.resultMaxBody
+	}
+}
+
+// putCallResult stores the result of the detached call, empty until it is done with err
+func (a *lbAgent) putCallResult(ctx context.Context, call *call, done bool, err error) {
+	if a.results == nil {
+		return
+	}
+	result := &CallResult{CallID: call.ID, FnID: call.FnID, Done: done}
+	if done {
+		call.respWriter.(*DetachedResponseWriter).result(result, err)
+	}
+	// the call may have timed out, the result is still stored
+	if err := a.results.PutCallResult(common.BackgroundContext(ctx), result); err != nil {
+		common.Logger(ctx).WithError(err).Error("failed to store call result")
+	}
+}
+
+// setResponse records the status and headers of the response of the fn, the runner sent
+// its body before
+func (w *DetachedResponseWriter) setResponse(resp *pb.DetachedResponse) {
+	w.respStatus = int(resp.GetHttp().GetStatusCode())
+	w.respHeader = make(http.Header)
+	for _, h := range resp.GetHttp().GetHeaders() {
+		w.respHeader.Add(h.Key, h.Value)
+	}
+	if resp.Truncated {
+		w.truncated = true
+	}
+}
+
+// result fills in result with the recorded response, or err if the call failed with it
+func (w *DetachedResponseWriter) result(result *CallResult, err error) {
+	result.CompletedAt = common.DateTime(time.Now())
+	if err == nil && w.truncated {
+		err = models.ErrFunctionResponseTooBig
+	}
+	if err != nil {
+		result.Status = models.GetAPIErrorCode(err)
+		result.Error = err.Error()
+		if result.Status == 0 {
+			// internal errors are not shown to clients
+			result.Status = http.StatusInternalServerError
+			result.Error = http.StatusText(result.Status)
+		}
+		return
+	}
+	result.Status = w.respStatus
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	result.Header = w.respHeader
+	result.Body = w.body
+}
+
+// memoryResultStore keeps up to size call results in memory for ttl, the least recently
+// stored are dropped first
+type memoryResultStore struct {
+	mtx     sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type storedResult struct {
+	result  *CallResult
+	expires time.Time
+}
+
+var _ CallResultStore = &memoryResultStore{}
+
+// NewMemoryCallResultStore returns a CallResultStore keeping up to size results in memory
+// for ttl, for a single LB
+func NewMemoryCallResultStore(size int, ttl time.Duration) CallResultStore {
+	return &memoryResultStore{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// PutCallResult implements CallResultStore
+func (s *memoryResultStore) PutCallResult(ctx context.Context, result *CallResult) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	stored := &storedResult{result: result, expires: time.Now().Add(s.ttl)}
+	if elem, ok := s.entries[result.CallID]; ok {
+		elem.Value = stored
+		s.lru.MoveToFront(elem)
+		return nil
+	}
+	s.entries[result.CallID] = s.lru.PushFront(stored)
+	if s.lru.Len() > s.size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*storedResult).result.CallID)
+	}
+	return nil
+}
+
+// GetCallResult implements CallResultStore
+func (s *memoryResultStore) GetCallResult(ctx context.Context, fnID, callID string) (*CallResult, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	elem, ok := s.entries[callID]
+	if !ok {
+		return nil, models.ErrCallNotFound
+	}
+	stored := elem.Value.(*storedResult)
+	if time.Now().After(stored.expires) {
+		s.lru.Remove(elem)
+		delete(s.entries, callID)
+		return nil, models.ErrCallNotFound
+	}
+	if stored.result.FnID != fnID {
+		return nil, models.ErrCallNotFound
+	}
+	return stored.result, nil
+}
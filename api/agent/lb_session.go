@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pool "github.com/fnproject/fn/api/runnerpool"
+)
+
+// WithLBSessionAffinity places calls of apps and fns with the
+// models.SessionHeaderAnnotation annotation on the runner hosting their session, the
+// runner that took the last call with the same session key. Up to size sessions are
+// remembered, each for ttl after its last call. Calls of a session whose runner is gone,
+// not ready or busy are placed as usual, and the runner they land on hosts the session.
+func WithLBSessionAffinity(size int, ttl time.Duration) LBAgentOption {
+	return func(a *lbAgent) error {
+		if size <= 0 || ttl <= 0 {
+			return errors.New("lb-agent session affinity needs a number of sessions and a ttl")
+		}
+		a.sessions = pool.NewSessionRunners(size, ttl)
+		return nil
+	}
+}
+
+// withSession returns ctx for the placer to place call on the runner of its session, if
+// session affinity is enabled and the call has a session key
+func (a *lbAgent) withSession(ctx context.Context, call *call) context.Context {
+	if a.sessions == nil || call.req == nil {
+		return ctx
+	}
+	header, err := call.Annotations.SessionHeader()
+	if err != nil || header == "" {
+		return ctx
+	}
+	key := call.req.Header.Get(header)
+	if key == "" {
+		return ctx
+	}
+	// sessions are scoped to their fn
+	return pool.WithSession(ctx, a.sessions, call.FnID+"/"+key)
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
+)
+
+// WithLBShadowPool mirrors percent of the calls to the runners of rp, eg. canary pure runners
+// with a new build, in addition to placing them as usual. Mirrored calls are fire-and-forget:
+// their responses and logs are discarded and their failures do not affect the original call.
+// Functions must tolerate running mirrored calls twice. Calls whose request body is spooled
+// to a file are not mirrored, see WithLBBodySpool. rp is shut down with the agent.
+func WithLBShadowPool(rp pool.RunnerPool, percent float64) LBAgentOption {
+	return func(a *lbAgent) error {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("Invalid shadow traffic percentage %v", percent)
+		}
+		a.shadowPool = rp
+		a.shadowPercent = percent
+		return nil
+	}
+}
+
+// shadowCall is a copy of a call mirrored to the shadow pool, the response is discarded
+type shadowCall struct {
+	model      models.Call
+	slotHashId string
+	priority   pool.CallPriority
+	extensions map[string]string
+	body       []byte
+	w          *discardResponseWriter
+}
+
+func (c *shadowCall) SlotHashId() string                     { return c.slotHashId }
+func (c *shadowCall) Priority() pool.CallPriority            { return c.priority }
+func (c *shadowCall) Extensions() map[string]string          { return c.extensions }
+func (c *shadowCall) ResponseWriter() http.ResponseWriter    { return c.w }
+func (c *shadowCall) StdErr() io.ReadWriteCloser             { return common.NoopReadWriteCloser{} }
+func (c *shadowCall) Model() *models.Call                    { return &c.model }
+func (c *shadowCall) AddUserExecutionTime(dur time.Duration) {}
+func (c *shadowCall) GetUserExecutionTime() *time.Duration   { return nil }
+
+func (c *shadowCall) RequestBody() io.ReadCloser {
+	return ioutil.NopCloser(bytes.NewReader(c.body))
+}
+
+var _ pool.RunnerCall = &shadowCall{}
+
+// discardResponseWriter is a response writer discarding the response of a shadow call
+type discardResponseWriter struct {
+	headers http.Header
+	status  int
+}
+
+func (w *discardResponseWriter) Header() http.Header            { return w.headers }
+func (w *discardResponseWriter) Write(data []byte) (int, error) { return len(data), nil }
+func (w *discardResponseWriter) WriteHeader(statusCode int)     { w.status = statusCode }
+
+// shadowCallMaybe mirrors call to the shadow pool, if there is one, with the configured
+// probability. The mirrored call is placed in the background.
+func (a *lbAgent) shadowCallMaybe(ctx context.Context, call *call) {
+	if a.shadowPool == nil || rand.Float64()*100 >= a.shadowPercent {
+		return
+	}
+	// the body of stream calls cannot be read by a second call
+	if call.Type == models.TypeStream {
+		return
+	}
+
+	// the request body is reused once Submit returns, copy it now. Bodies the body spool
+	// keeps out of memory are not copied into memory, those calls are not mirrored.
+	var body []byte
+	if rdr := call.RequestBody(); rdr != nil {
+		if a.spool.maxMemory > 0 {
+			rdr = ioutil.NopCloser(io.LimitReader(rdr, a.spool.maxMemory+1))
+		}
+		var err error
+		body, err = ioutil.ReadAll(rdr)
+		if err != nil {
+			common.Logger(ctx).WithError(err).Warn("Failed to read request body of shadow call")
+			return
+		}
+		if a.spool.maxMemory > 0 && int64(len(body)) > a.spool.maxMemory {
+			common.Logger(ctx).Debug("Not mirroring call with a spooled request body")
+			return
+		}
+	}
+
+	if !a.shutWg.AddSession(1) {
+		return
+	}
+
+	sc := &shadowCall{
+		model:      *call.Call,
+		slotHashId: call.SlotHashId(),
+		priority:   call.Priority(),
+		extensions: call.Extensions(),
+		body:       body,
+		w:          &discardResponseWriter{headers: make(http.Header)},
+	}
+	ctx = common.BackgroundContext(ctx)
+	cfg := a.placer.GetPlacerConfig()
+	timeout := cfg.PlacementBudget(sc).Timeout + time.Duration(call.Timeout+call.InitTimeout)*time.Second
+
+	go func() {
+		defer a.shutWg.DoneSession()
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		statsShadowCall(ctx)
+		err := a.placer.PlaceCall(ctx, a.shadowPool, sc)
+		if err != nil {
+			statsShadowError(ctx)
+			common.Logger(ctx).WithError(err).Debug("Shadow call failed")
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// overcommitEvictCooldown is how long no idle containers are evicted after evictions for
+// memory pressure, as the pressure is a 10 second average that takes time to come down
+const overcommitEvictCooldown = 10 * time.Second
+
+// evictUnderPressure evicts idle containers while memory is overcommitted (see
+// EnvMemoryOvercommit) and the memory pressure of the host is above OvercommitEvictPressure,
+// to free memory before the kernel kills containers, or else the host, out of memory. Hosts
+// without PSI have no pressure, their containers are not evicted.
+func (a *agent) evictUnderPressure() {
+	ticker := time.NewTicker(pressureRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-a.shutWg.Closer():
+			return
+		}
+
+		notifyChans := a.pressureEviction()
+		if len(notifyChans) == 0 {
+			continue
+		}
+		for _, wait := range notifyChans {
+			select {
+			case <-wait:
+			case <-a.shutWg.Closer():
+				return
+			}
+		}
+		select {
+		case <-time.After(overcommitEvictCooldown):
+		case <-a.shutWg.Closer():
+			return
+		}
+	}
+}
+
+// pressureEviction evicts idle containers, the oldest first, if the memory pressure of the
+// host is above OvercommitEvictPressure. It evicts the memory reserved past the usable memory
+// of the host, or at least one container, and returns the channels of the evictions.
+func (a *agent) pressureEviction() []chan struct{} {
+	util := a.resources.GetUtilization()
+	if util.MemPressure <= float64(a.cfg.OvercommitEvictPressure) {
+		return nil
+	}
+
+	usable := (util.MemUsed + util.MemAvail) / a.cfg.MemoryOvercommit * 100
+	need := uint64(1)
+	if util.MemUsed > usable {
+		need = util.MemUsed - usable
+	}
+
+	// no slot is excluded, any idle container may be evicted
+	notifyChans := a.evictor.PerformEviction("", need, 0, 0)
+	if len(notifyChans) == 0 && need > 1 {
+		notifyChans = a.evictor.PerformEviction("", 1, 0, 0)
+	}
+	if len(notifyChans) != 0 {
+		logrus.WithFields(logrus.Fields{
+			"memory_pressure": util.MemPressure,
+			"memory_used":     util.MemUsed,
+			"usable_memory":   usable,
+			"evictions":       len(notifyChans),
+		}).Warn("evicting idle containers under memory pressure")
+	}
+	return notifyChans
+}
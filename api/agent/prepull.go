@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/fnproject/fn/api/agent/drivers"
+	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
+)
+
+var (
+	errPrePullUnsupported       = errors.New("Container driver cannot pull images ahead of calls")
+	errRunnerPrePullUnsupported = errors.New("Runner cannot pull images ahead of calls")
+)
+
+// PrePullResult is the outcome of pulling an image on a runner ahead of calls
+type PrePullResult struct {
+	Image string `json:"image"`
+	// Address of the runner, empty for the runner of a full agent
+	Address string `json:"address,omitempty"`
+	// Error is why the image could not be pulled, empty if it was
+	Error string `json:"error,omitempty"`
+}
+
+// ImagePrePuller is optionally implemented by an Agent that can pull the images of fns on
+// its runners ahead of their calls, eg. right after a deploy, so that the first calls do
+// not wait for the pull
+type ImagePrePuller interface {
+	// PrePullImages pulls the image of fn, and the images of its sidecars, on every runner
+	// that may run its calls, and returns the outcome on each runner. Images are pulled
+	// for at most the hot pull timeout of the agent config.
+	PrePullImages(ctx context.Context, app *models.App, fn *models.Fn) []PrePullResult
+}
+
+// imagePuller is implemented by an Agent that pulls images itself, which pure runners use
+// to pull the images the LB asks for, see pureRunner.PullImage
+type imagePuller interface {
+	// prePullImage pulls image for at most the hot pull timeout of the agent config
+	prePullImage(ctx context.Context, image string) error
+}
+
+var _ imagePuller = &agent{}
+
+// prePullImages returns the images of fn, whose annotations are merged with the annotations
+// of its app
+func prePullImages(fn *models.Fn, annotations models.Annotations) []string {
+	images := []string{fn.Image}
+	// annotations are validated with the app and fn
+	sidecars, _ := annotations.Sidecars()
+	for _, s := range sidecars {
+		images = append(images, s.Image)
+	}
+	return images
+}
+
+func newPrePullResult(image, addr string, err error) PrePullResult {
+	res := PrePullResult{Image: image, Address: addr}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// PrePullImages implements ImagePrePuller, with the driver of the agent
+func (a *agent) PrePullImages(ctx context.Context, app *models.App, fn *models.Fn) []PrePullResult {
+	var results []PrePullResult
+	for _, image := range prePullImages(fn, app.Annotations.MergeChange(fn.Annotations)) {
+		results = append(results, newPrePullResult(image, "", a.prePullImage(ctx, image)))
+	}
+	return results
+}
+
+// implements imagePuller, with the driver of the agent
+func (a *agent) prePullImage(ctx context.Context, image string) error {
+	puller, ok := a.driver.(drivers.ImagePrePuller)
+	if !ok {
+		return errPrePullUnsupported
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.cfg.HotPullTimeout)
+	defer cancel()
+	err := puller.PrePullImage(ctx, image)
+	if ctx.Err() == context.DeadlineExceeded {
+		return models.ErrDockerPullTimeout
+	}
+	return err
+}
+
+// PrePullImages implements ImagePrePuller, it pulls the images on every runner of the runner
+// pool of fn that matches its runner constraints, in parallel
+func (a *lbAgent) PrePullImages(ctx context.Context, app *models.App, fn *models.Fn) []PrePullResult {
+	ctx, cancel := context.WithTimeout(ctx, a.cfg.HotPullTimeout)
+	defer cancel()
+
+	annotations := app.Annotations.MergeChange(fn.Annotations)
+	images := prePullImages(fn, annotations)
+	p, err := a.runnerPool(&call{Call: &models.Call{Annotations: annotations}})
+	if err != nil {
+		return []PrePullResult{newPrePullResult(fn.Image, "", err)}
+	}
+	runners, err := p.rp.Runners(ctx, nil)
+	if err != nil {
+		return []PrePullResult{newPrePullResult(fn.Image, "", err)}
+	}
+	constraints, _ := annotations.RunnerConstraints()
+
+	results := make(chan PrePullResult)
+	n := 0
+	for _, r := range runners {
+		if !pool.MatchesConstraints(r, constraints) {
+			continue
+		}
+		for _, image := range images {
+			n++
+			go func(r pool.Runner, image string) {
+				err := errRunnerPrePullUnsupported
+				if puller, ok := r.(pool.ImagePullRunner); ok {
+					err = puller.PullImage(ctx, image)
+				}
+				results <- newPrePullResult(image, r.Address(), err)
+			}(r, image)
+		}
+	}
+
+	all := make([]PrePullResult, 0, n)
+	for i := 0; i < n; i++ {
+		all = append(all, <-results)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Address != all[j].Address {
+			return all[i].Address < all[j].Address
+		}
+		return all[i].Image < all[j].Image
+	})
+	return all
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// procPressureDir has the pressure stall information (PSI) of the host, see
+	// https://www.kernel.org/doc/html/latest/accounting/psi.html
+	procPressureDir = "/proc/pressure"
+
+	// pressureRefresh is how long read pressures are used before being read again
+	pressureRefresh = time.Second
+)
+
+// pressureReader reads the memory and CPU pressure of the host, as the share of the last
+// 10 seconds in percent that some tasks stalled waiting for memory or CPU. Pressures are
+// zero on hosts without PSI, eg. kernels before 4.20 or other OSes.
+type pressureReader struct {
+	dir string
+
+	mtx  sync.Mutex
+	read time.Time
+	mem  float64
+	cpu  float64
+}
+
+func newPressureReader(dir string) *pressureReader {
+	return &pressureReader{dir: dir}
+}
+
+// pressures returns the memory and CPU pressure, read at most once per pressureRefresh
+func (p *pressureReader) pressures() (mem, cpu float64) {
+	if p == nil {
+		return 0, 0
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if now := time.Now(); now.Sub(p.read) >= pressureRefresh {
+		p.read = now
+		// errors are hosts without PSI, which have no pressure
+		p.mem, _ = readPressure(filepath.Join(p.dir, "memory"))
+		p.cpu, _ = readPressure(filepath.Join(p.dir, "cpu"))
+	}
+	return p.mem, p.cpu
+}
+
+// readPressure returns the 10 second average of the some line of a PSI file, of form:
+// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func readPressure(fileName string) (float64, error) {
+	value, err := readString(fileName)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(value, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "some" || !strings.HasPrefix(fields[1], "avg10=") {
+			continue
+		}
+		return strconv.ParseFloat(strings.TrimPrefix(fields[1], "avg10="), 64)
+	}
+	return 0, fmt.Errorf("no some avg10 in %s", fileName)
+}
@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ProfileCPU is a CPU profile of the container over the seconds of the profile request
+	ProfileCPU = "cpu"
+	// ProfileHeap is a heap profile of the container
+	ProfileHeap = "heap"
+
+	// MaxProfileSeconds is the longest CPU profile of a container
+	MaxProfileSeconds = 60
+
+	// maxProfileSize is the largest profile returned, larger profiles fail
+	maxProfileSize = 3 * 1024 * 1024
+
+	// profileHeadRoom is how long a container may take to return its profile, past the
+	// seconds of CPU profiles
+	profileHeadRoom = 10 * time.Second
+)
+
+var (
+	// ErrInvalidProfileType is returned for profiles of other types than ProfileCPU or ProfileHeap
+	ErrInvalidProfileType = models.NewAPIError(http.StatusBadRequest, fmt.Errorf("Invalid profile type, it should be %s or %s", ProfileCPU, ProfileHeap))
+	// ErrInvalidProfileSeconds is returned for CPU profiles too short or too long
+	ErrInvalidProfileSeconds = models.NewAPIError(http.StatusBadRequest, fmt.Errorf("Invalid profile seconds, it should be between 1 and %d", MaxProfileSeconds))
+	// ErrFnContainerNotFound is returned when no hot container of the fn runs, or when all
+	// of them idle and are frozen
+	ErrFnContainerNotFound = models.NewAPIError(http.StatusNotFound, errors.New("No running hot container of the function to profile"))
+	// ErrProfileUnsupported is returned when the container of the fn does not serve
+	// profiles, its FDK may not support them
+	ErrProfileUnsupported = models.NewAPIError(http.StatusNotImplemented, errors.New("The container of the function does not serve profiles, its FDK may not support them"))
+	// ErrProfileTooBig is returned for profiles larger than maxProfileSize
+	ErrProfileTooBig = models.NewAPIError(http.StatusBadGateway, fmt.Errorf("The profile of the container is larger than %d bytes", maxProfileSize))
+
+	errRunnerProfileUnsupported = errors.New("Runner cannot profile containers")
+)
+
+// ProfileRequest is a profile to collect from a hot container
+type ProfileRequest struct {
+	// Type is ProfileCPU or ProfileHeap
+	Type string
+	// Seconds is how long a CPU profile lasts
+	Seconds int
+}
+
+// Validate returns an error if the request is not a CPU profile of 1 to MaxProfileSeconds
+// seconds or a heap profile
+func (r ProfileRequest) Validate() error {
+	switch r.Type {
+	case ProfileCPU:
+		if r.Seconds < 1 || r.Seconds > MaxProfileSeconds {
+			return ErrInvalidProfileSeconds
+		}
+	case ProfileHeap:
+	default:
+		return ErrInvalidProfileType
+	}
+	return nil
+}
+
+// path returns the path of the profile in the container, the FDK serves profiles at the
+// paths of net/http/pprof on its unix socket
+func (r ProfileRequest) path() string {
+	if r.Type == ProfileCPU {
+		return fmt.Sprintf("/debug/pprof/profile?seconds=%d", r.Seconds)
+	}
+	return "/debug/pprof/heap"
+}
+
+// Profile is a profile collected from a hot container, eg. a pprof CPU profile
+type Profile struct {
+	// Address of the runner of the container, empty for the runner of a full agent
+	Address     string
+	ContainerID string
+	ContentType string
+	Data        []byte
+}
+
+// ContainerProfiler is optionally implemented by an Agent that can profile the hot
+// containers of fns, to debug slow fns while they run
+type ContainerProfiler interface {
+	// ProfileContainer collects a profile from a running hot container of fn, busy ones
+	// first. ErrFnContainerNotFound is returned if there is none.
+	ProfileContainer(ctx context.Context, app *models.App, fn *models.Fn, req ProfileRequest) (*Profile, error)
+}
+
+// hotContainers are the hot containers of an agent that are ready to take calls, with their
+// state
+type hotContainers struct {
+	mu         sync.Mutex
+	containers map[*container]ContainerState
+}
+
+func (h *hotContainers) add(c *container, state ContainerState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.containers == nil {
+		h.containers = make(map[*container]ContainerState)
+	}
+	h.containers[c] = state
+}
+
+func (h *hotContainers) remove(c *container) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.containers, c)
+}
+
+// startProfile returns a busy hot container of fnID, or else an idle one that is not
+// frozen, which is not frozen until endProfile. It returns nil if there is none.
+func (h *hotContainers) startProfile(fnID string) *container {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var idle *container
+	for c, state := range h.containers {
+		if c.fnID != fnID {
+			continue
+		}
+		switch state.GetState() {
+		case containerStateKeys[ContainerStateBusy]:
+			atomic.AddInt32(&c.profiling, 1)
+			return c
+		case containerStateKeys[ContainerStateIdle]:
+			idle = c
+		}
+	}
+	if idle != nil {
+		atomic.AddInt32(&idle.profiling, 1)
+	}
+	return idle
+}
+
+func (h *hotContainers) endProfile(c *container) {
+	atomic.AddInt32(&c.profiling, -1)
+}
+
+// profiler is implemented by an Agent that runs containers itself, which pure runners use
+// to profile the containers the LB asks for, see pureRunner.ProfileContainer
+type profiler interface {
+	profileContainer(ctx context.Context, fnID string, req ProfileRequest) (*Profile, error)
+}
+
+var _ profiler = &agent{}
+var _ ContainerProfiler = &agent{}
+
+// ProfileContainer implements ContainerProfiler, with the hot containers of the agent
+func (a *agent) ProfileContainer(ctx context.Context, app *models.App, fn *models.Fn, req ProfileRequest) (*Profile, error) {
+	return a.profileContainer(ctx, fn.ID, req)
+}
+
+// implements profiler
+func (a *agent) profileContainer(ctx context.Context, fnID string, req ProfileRequest) (*Profile, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	c := a.hot.startProfile(fnID)
+	if c == nil {
+		return nil, ErrFnContainerNotFound
+	}
+	defer a.hot.endProfile(c)
+
+	common.Logger(ctx).WithFields(logrus.Fields{"fn_id": fnID, "container_id": c.id, "profile": req.Type}).Info("profiling container")
+	return c.profile(ctx, req)
+}
+
+// profile asks the FDK of the container for a profile, over its unix socket
+func (c *container) profile(ctx context.Context, req ProfileRequest) (*Profile, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(req.Seconds)*time.Second+profileHeadRoom)
+	defer cancel()
+
+	hreq, err := http.NewRequest(http.MethodGet, "http://localhost"+req.path(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.udsClient.Do(hreq.WithContext(ctx))
+	if err != nil {
+		return nil, models.NewAPIError(http.StatusBadGateway, fmt.Errorf("Failed to profile container: %v", err))
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, ErrProfileUnsupported
+	default:
+		return nil, models.NewAPIError(http.StatusBadGateway, fmt.Errorf("Failed to profile container, status code %d", resp.StatusCode))
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProfileSize+1))
+	if err != nil {
+		return nil, models.NewAPIError(http.StatusBadGateway, fmt.Errorf("Failed to read the profile of the container: %v", err))
+	}
+	if len(data) > maxProfileSize {
+		return nil, ErrProfileTooBig
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &Profile{ContainerID: c.id, ContentType: contentType, Data: data}, nil
+}
+
+// isProfiling returns whether a profile of the container is being collected, the container
+// is not frozen meanwhile
+func (c *container) isProfiling() bool {
+	return atomic.LoadInt32(&c.profiling) > 0
+}
+
+// ProfileContainer implements ContainerProfiler, it asks the runners of the runner pool of fn
+// that match its runner constraints one after the other, until one of them has a hot
+// container of fn
+func (a *lbAgent) ProfileContainer(ctx context.Context, app *models.App, fn *models.Fn, req ProfileRequest) (*Profile, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	annotations := app.Annotations.MergeChange(fn.Annotations)
+	p, err := a.runnerPool(&call{Call: &models.Call{Annotations: annotations}})
+	if err != nil {
+		return nil, err
+	}
+	runners, err := p.rp.Runners(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	constraints, _ := annotations.RunnerConstraints()
+
+	for _, r := range runners {
+		if !pool.MatchesConstraints(r, constraints) {
+			continue
+		}
+		profiler, ok := r.(pool.ContainerProfileRunner)
+		if !ok {
+			continue
+		}
+		prof, err := profiler.ProfileContainer(ctx, fn.ID, req.Type, req.Seconds)
+		if err == ErrFnContainerNotFound || err == errRunnerProfileUnsupported {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &Profile{Address: r.Address(), ContainerID: prof.ContainerID, ContentType: prof.ContentType, Data: prof.Data}, nil
+	}
+	return nil, ErrFnContainerNotFound
+}
@@ -3,14 +3,19 @@ package agent
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,15 +23,20 @@ import (
 	runner "github.com/fnproject/fn/api/agent/grpc"
 	"github.com/fnproject/fn/api/common"
 	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
 	"github.com/fnproject/fn/fnext"
 	"github.com/fnproject/fn/grpcutil"
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/empty"
 	pbst "github.com/golang/protobuf/ptypes/struct"
 	"github.com/sirupsen/logrus"
 	"go.opencensus.io/plugin/ocgrpc"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
@@ -67,6 +77,10 @@ import (
 var (
 	ErrorExpectedTry  = errors.New("Protocol failure: expected ClientMsg_Try")
 	ErrorExpectedData = errors.New("Protocol failure: expected ClientMsg_Data")
+
+	// errCallAttached is returned by handleTryCall for calls that were already accepted
+	// with the same execution token, the engagement gets the result of that call
+	errCallAttached = errors.New("Call was already accepted by the runner")
 )
 
 // callHandle represents the state of the call as handled by the pure runner, and additionally it implements the
@@ -97,6 +111,35 @@ type callHandle struct {
 	pipeToFnR *io.PipeReader
 
 	eofSeen uint64 // Has pipe sender seen eof?
+
+	// largest data frame sent to the client
+	maxDataChunk int
+
+	// running hash and checksum of the response body, if requested by the client
+	respHash     hash.Hash
+	respCRC      hash.Hash
+	respHashLock sync.Mutex
+
+	// execution token claimed for the call and its execution, if the client sent one
+	executionToken string
+	execution      *execution
+
+	// response flow control, see waitResponseWindow. Zero window disables it.
+	respWindow int64
+	respSent   int64
+	respAcked  int64 // atomic, updated by the receiver
+	ackNotify  chan struct{}
+
+	// logMtx serializes log frames with the finish message, no log frames are sent
+	// once logsDone is set. See logFrameWriter.
+	logMtx   sync.Mutex
+	logsDone bool
+
+	// the most response body bytes of a detached call sent to the client, see
+	// TryCall.DetachedResponseMax. Zero discards the response.
+	detachedMax       int64
+	detachedSent      int64
+	detachedTruncated bool
 }
 
 func NewCallHandle(engagement runner.RunnerProtocol_EngageServer) *callHandle {
@@ -117,6 +160,8 @@ func NewCallHandle(engagement runner.RunnerProtocol_EngageServer) *callHandle {
 		pipeToFnW:    pipeW,
 		pipeToFnR:    pipeR,
 		eofSeen:      0,
+		maxDataChunk: MaxDataChunk,
+		ackNotify:    make(chan struct{}, 1),
 	}
 
 	// Wrap parent ctx with a cancel function so we can abort the call if
@@ -234,6 +279,7 @@ func (ch *callHandle) enqueueCallResponse(err error) {
 	var ctrCreateDuration int64
 	var ctrPrepDuration int64
 	var initStartTime int64
+	var rejectReason string
 
 	log := common.Logger(ch.ctx)
 
@@ -277,27 +323,50 @@ func (ch *callHandle) enqueueCallResponse(err error) {
 		imagePullWaitDuration = ch.c.imagePullWaitTime
 		ctrCreateDuration = ch.c.ctrCreateTime
 		initStartTime = ch.c.initStartTime
+		if nErr == models.ErrCallTimeoutServerBusy {
+			rejectReason = string(ch.c.getRejectReason())
+		}
+	}
+	var errStatus *spb.Status
+	if nErr != nil {
+		errStatus = callErrorStatus(nErr, errCode, errUser, pool.RejectReason(rejectReason))
 	}
 	log.Debugf("Sending Call Finish details=%v", details)
 
-	errTmp := ch.enqueueMsgStrict(&runner.RunnerMsg{
-		Body: &runner.RunnerMsg_Finished{Finished: &runner.CallFinished{
-			CompletedAt:           completedAt,
-			CreatedAt:             createdAt,
-			CtrCreateDuration:     ctrCreateDuration,
-			CtrPrepDuration:       ctrPrepDuration,
-			Details:               details,
-			ErrorCode:             int32(errCode),
-			ErrorStr:              errStr,
-			ErrorUser:             errUser,
-			ExecutionDuration:     int64(executionDuration),
-			Image:                 image,
-			ImagePullWaitDuration: imagePullWaitDuration,
-			InitStartTime:         initStartTime,
-			SchedulerDuration:     int64(schedulerDuration),
-			StartedAt:             startedAt,
-			Success:               nErr == nil,
-		}}})
+	// the client does not expect any log frames after the finish message
+	ch.logMtx.Lock()
+	ch.logsDone = true
+	ch.logMtx.Unlock()
+
+	finished := &runner.CallFinished{
+		CompletedAt:           completedAt,
+		CreatedAt:             createdAt,
+		CtrCreateDuration:     ctrCreateDuration,
+		CtrPrepDuration:       ctrPrepDuration,
+		Details:               details,
+		ErrorCode:             int32(errCode),
+		ErrorName:             models.GetErrorCode(nErr),
+		ErrorStr:              errStr,
+		ErrorUser:             errUser,
+		ExecutionDuration:     int64(executionDuration),
+		Image:                 image,
+		ImagePullWaitDuration: imagePullWaitDuration,
+		InitStartTime:         initStartTime,
+		SchedulerDuration:     int64(schedulerDuration),
+		StartedAt:             startedAt,
+		Success:               nErr == nil,
+		ResponseSha256:        ch.responseSum(),
+		ResponseCrc32C:        ch.responseCRC32C(),
+		RejectReason:          rejectReason,
+		ErrorStatus:           errStatus,
+		DetachedResponse:      ch.detachedResponse(nErr),
+	}
+	if ch.execution != nil {
+		// engagements sending the execution token again get the same result
+		ch.execution.finish(finished)
+	}
+
+	errTmp := ch.enqueueMsgStrict(&runner.RunnerMsg{Body: &runner.RunnerMsg_Finished{Finished: finished}})
 
 	if errTmp != nil {
 		log.WithError(errTmp).Infof("enqueueCallResponse Send Error details=%v err=%v:%v", details, errCode, errStr)
@@ -310,6 +379,28 @@ func (ch *callHandle) enqueueCallResponse(err error) {
 	}
 }
 
+// enqueueExecutionResult waits for the call of exec, which was accepted on another
+// engagement, to finish and enqueues its finish message to the LB. It then initiates a
+// graceful shutdown of the session.
+func (ch *callHandle) enqueueExecutionResult(exec *execution) {
+	select {
+	case <-exec.done:
+	case <-ch.ctx.Done():
+		ch.shutdown(ch.ctx.Err())
+		return
+	}
+
+	// every engagement gets its own copy, engagements may amend the message they send
+	finished := proto.Clone(exec.finished).(*runner.CallFinished)
+	err := ch.enqueueMsgStrict(&runner.RunnerMsg{Body: &runner.RunnerMsg_Finished{Finished: finished}})
+	if err == nil {
+		err = ch.finalize()
+	}
+	if err != nil {
+		common.Logger(ch.ctx).WithError(err).Info("enqueueExecutionResult Send Error")
+	}
+}
+
 // Used to short circuit the error path when its necessary to return a well
 // formed error to the LB and we don't want to complete the call.  Errors
 // qeueued here will supercede any errors returned by the function invocation,
@@ -387,6 +478,18 @@ func (ch *callHandle) spawnReceiver() {
 				ch.shutdown(err)
 				return
 			}
+			// acks may arrive at any time, even after the request body
+			if ack := msg.GetAck(); ack != nil {
+				ch.ackResponse(ack.Bytes)
+				continue
+			}
+			// so may the client cancelling the call, which we abort rather than run to
+			// completion for nobody
+			if cancel := msg.GetCancel(); cancel != nil {
+				common.Logger(ch.ctx).WithField("reason", cancel.Reason).Info("Call cancelled by client")
+				ch.enqueueCallErrorResponse(models.ErrClientCancel)
+				continue
+			}
 
 			select {
 			case ch.inQueue <- msg:
@@ -440,8 +543,8 @@ func (ch *callHandle) WriteHeader(status int) {
 	// consumed.  If the user's container attempts to write before a Eof
 	// frame has been seen, then return an error.  Only perform this check
 	// for HTTP 200s so that it does not trip on errors or detach mode accept
-	// invocations.
-	if status == http.StatusOK {
+	// invocations. Stream calls write while their request streams.
+	if status == http.StatusOK && ch.c.Model().Type != models.TypeStream {
 		eofSeen := atomic.LoadUint64(&ch.eofSeen)
 		if eofSeen == 0 {
 			ch.enqueueCallErrorResponse(models.ErrFunctionPrematureWrite)
@@ -497,10 +600,47 @@ func (ch *callHandle) prepHeaders() []*runner.HttpHeader {
 // Write also sends http headers/state to the LB.
 func (ch *callHandle) Write(data []byte) (int, error) {
 	if ch.c.Model().Type == models.TypeDetached {
-		//If it is an detached call we just /dev/null the data coming back from the container
-		return len(data), nil
+		return ch.writeDetached(data)
+	}
+	return ch.writeData(data)
+}
+
+// writeDetached sends up to detachedMax bytes of the response of a detached call, which
+// was acknowledged before the fn ran. Past that we just /dev/null the data coming back
+// from the container.
+func (ch *callHandle) writeDetached(data []byte) (int, error) {
+	n := len(data)
+	if room := ch.detachedMax - ch.detachedSent; int64(n) > room {
+		data = data[:room]
+		ch.detachedTruncated = true
+	}
+	if len(data) == 0 {
+		return n, nil
+	}
+	sent, err := ch.writeData(data)
+	ch.detachedSent += int64(sent)
+	if err != nil {
+		return sent, err
 	}
+	return n, nil
+}
+
+// detachedResponse returns the status and headers of the response of a detached call that
+// succeeded, if the client asked for its response
+func (ch *callHandle) detachedResponse(err error) *runner.DetachedResponse {
+	if err != nil || ch.detachedMax <= 0 || ch.c == nil || ch.c.Type != models.TypeDetached {
+		return nil
+	}
+	return &runner.DetachedResponse{
+		Http: &runner.HttpRespMeta{
+			Headers:    ch.prepHeaders(),
+			StatusCode: int32(ch.status),
+		},
+		Truncated: ch.detachedTruncated,
+	}
+}
 
+func (ch *callHandle) writeData(data []byte) (int, error) {
 	ch.WriteHeader(ch.status)
 	// if we have any error during the WriteHeader the doneQueue will be closed by the
 	// shutdown process. We check here if that happens, if so we return immediately
@@ -522,13 +662,18 @@ func (ch *callHandle) Write(data []byte) (int, error) {
 	// split up data into gRPC chunks
 	for {
 		chunkSize := len(data)
-		if chunkSize > MaxDataChunk {
-			chunkSize = MaxDataChunk
+		if chunkSize > ch.maxDataChunk {
+			chunkSize = ch.maxDataChunk
 		}
 		if chunkSize == 0 {
 			break
 		}
 
+		err = ch.waitResponseWindow(chunkSize)
+		if err != nil {
+			return total, err
+		}
+
 		// we cannot retain 'data'
 		cpData := make([]byte, chunkSize)
 		copy(cpData, data[0:chunkSize])
@@ -546,12 +691,91 @@ func (ch *callHandle) Write(data []byte) (int, error) {
 		if err != nil {
 			return total, err
 		}
+		ch.hashResponse(cpData)
 		total += chunkSize
 	}
 
 	return total, nil
 }
 
+// setResponseWindow enables response flow control with the window requested by the
+// client. The window is at least two data chunks, so that clients acknowledging
+// every half window cannot stall the call.
+func (ch *callHandle) setResponseWindow(window int64) {
+	if window > 0 && window < 2*int64(ch.maxDataChunk) {
+		window = 2 * int64(ch.maxDataChunk)
+	}
+	ch.respWindow = window
+}
+
+// waitResponseWindow blocks until size more bytes of response data fit in the
+// response window, ie. until the client acknowledged enough of the data sent
+// before. This keeps a slow client from piling up response data in gRPC buffers.
+func (ch *callHandle) waitResponseWindow(size int) error {
+	if ch.respWindow <= 0 {
+		return nil
+	}
+	for ch.respSent+int64(size)-atomic.LoadInt64(&ch.respAcked) > ch.respWindow {
+		select {
+		case <-ch.ackNotify:
+		case <-ch.sctx.Done():
+			return io.EOF
+		case <-ch.ctx.Done():
+			return io.EOF
+		case <-ch.doneQueue:
+			return io.EOF
+		}
+	}
+	ch.respSent += int64(size)
+	return nil
+}
+
+// ackResponse records the response bytes the client acknowledged
+func (ch *callHandle) ackResponse(acked int64) {
+	for {
+		prev := atomic.LoadInt64(&ch.respAcked)
+		if acked <= prev || atomic.CompareAndSwapInt64(&ch.respAcked, prev, acked) {
+			break
+		}
+	}
+	select {
+	case ch.ackNotify <- struct{}{}:
+	default:
+	}
+}
+
+// hashResponse adds response data sent to the client to the response hash
+func (ch *callHandle) hashResponse(data []byte) {
+	ch.respHashLock.Lock()
+	defer ch.respHashLock.Unlock()
+	if ch.respHash != nil {
+		ch.respHash.Write(data)
+	}
+	if ch.respCRC != nil {
+		ch.respCRC.Write(data)
+	}
+}
+
+// responseSum returns the response hash or nil if not requested
+func (ch *callHandle) responseSum() []byte {
+	ch.respHashLock.Lock()
+	defer ch.respHashLock.Unlock()
+	if ch.respHash == nil {
+		return nil
+	}
+	return ch.respHash.Sum(nil)
+}
+
+// responseCRC32C returns the response checksum or nil if not requested
+func (ch *callHandle) responseCRC32C() []byte {
+	ch.respHashLock.Lock()
+	defer ch.respHashLock.Unlock()
+	if ch.respCRC == nil {
+		return nil
+	}
+	return ch.respCRC.Sum(nil)
+}
+
 // getTryMsg fetches/waits for a TryCall message from
 // the LB using inQueue (gRPC receiver)
 func (ch *callHandle) getTryMsg() *runner.TryCall {
@@ -631,6 +855,13 @@ type pureRunner struct {
 	callHandleLock sync.Mutex
 	enableDetach   bool
 	configFunc     func(context.Context, *runner.ConfigMsg) (*runner.ConfigStatus, error)
+	maxDataChunk   int
+	compressors    []string
+	// calls with less time than this left until their deadline are rejected
+	minDeadlineSlack time.Duration
+	executions       *executionTokens
+	// labels advertised in the capabilities, see PureRunnerWithLabels
+	labels map[string]string
 }
 
 // implements Agent
@@ -673,9 +904,19 @@ func (pr *pureRunner) removeCallHandle(cID string) {
 
 }
 
+// releaseExecutionToken lets a client retry a call that was not run
+func (pr *pureRunner) releaseExecutionToken(state *callHandle) {
+	if state.executionToken != "" {
+		pr.executions.release(state.executionToken)
+	}
+}
+
 func (pr *pureRunner) spawnSubmit(state *callHandle) {
 	go func() {
 		err := pr.a.Submit(state.c)
+		if err == models.ErrCallTimeoutServerBusy {
+			pr.releaseExecutionToken(state)
+		}
 		state.enqueueCallResponse(err)
 	}()
 }
@@ -684,19 +925,26 @@ func (pr *pureRunner) spawnDetachSubmit(state *callHandle) {
 	go func() {
 		pr.saveCallHandle(state)
 		err := pr.a.Submit(state.c)
+		if err == models.ErrCallTimeoutServerBusy {
+			pr.releaseExecutionToken(state)
+		}
 		state.enqueueCallResponse(err)
 		pr.removeCallHandle(state.c.Model().ID)
 	}()
 }
 
 // handleTryCall based on the TryCall message, tries to place the call on NBIO Agent
-func (pr *pureRunner) handleTryCall(tc *runner.TryCall, state *callHandle) error {
+func (pr *pureRunner) handleTryCall(tc *runner.TryCall, state *callHandle) (err error) {
 
 	var c models.Call
-	err := json.Unmarshal([]byte(tc.ModelsCallJson), &c)
-	if err != nil {
-		state.enqueueCallResponse(err)
-		return err
+	if tc.Call != nil {
+		c = callModelFromProto(tc.Call)
+	} else {
+		err = json.Unmarshal([]byte(tc.ModelsCallJson), &c)
+		if err != nil {
+			state.enqueueCallResponse(err)
+			return err
+		}
 	}
 
 	// Status image is reserved for internal Status checks.
@@ -707,6 +955,31 @@ func (pr *pureRunner) handleTryCall(tc *runner.TryCall, state *callHandle) error
 		return err
 	}
 
+	if slack, ok := callSlackFromContext(state.ctx); ok && slack <= pr.minDeadlineSlack {
+		common.Logger(state.ctx).WithField("slack", slack).Info("Rejecting call with no time left until its deadline")
+		err = models.ErrCallTimeoutServerBusy
+		state.enqueueCallResponse(err)
+		return err
+	}
+
+	if tc.ExecutionToken != "" {
+		exec, claimed := pr.executions.claim(tc.ExecutionToken)
+		if !claimed {
+			// The client is unsure whether we got the call before, do not run it twice
+			// but wait for the result of the call we got.
+			common.Logger(state.ctx).WithField("execution_token", tc.ExecutionToken).Info("Attaching to call that was already accepted")
+			go state.enqueueExecutionResult(exec)
+			return errCallAttached
+		}
+		state.executionToken = tc.ExecutionToken
+		state.execution = exec
+		defer func() {
+			if err != nil {
+				pr.releaseExecutionToken(state)
+			}
+		}()
+	}
+
 	// IMPORTANT: We clear/initialize these dates as start/created/completed dates from
 	// unmarshalled Model from LB-agent represent unrelated time-line events.
 	// From this point, CreatedAt/StartedAt/CompletedAt are based on our local clock.
@@ -714,18 +987,38 @@ func (pr *pureRunner) handleTryCall(tc *runner.TryCall, state *callHandle) error
 	c.StartedAt = common.DateTime(time.Time{})
 	c.CompletedAt = common.DateTime(time.Time{})
 
-	agentCall, err := pr.a.GetCall(FromModelAndInput(&c, state.pipeToFnR),
-		WithLogger(common.NoopReadWriteCloser{}),
+	var stderr io.ReadWriteCloser = common.NoopReadWriteCloser{}
+	if c.Type != models.TypeDetached && logFramesRequested(state.ctx) {
+		stderr = &logFrameWriter{ch: state}
+	}
+
+	opts := []CallOpt{
+		FromModelAndInput(&c, state.pipeToFnR),
+		WithLogger(stderr),
 		WithWriter(state),
 		WithContext(state.sctx),
 		WithExtensions(tc.GetExtensions()),
-	)
+	}
+	if priority, ok := callPriority(state.ctx); ok {
+		opts = append(opts, WithPriority(priority))
+	}
+
+	agentCall, err := pr.a.GetCall(opts...)
 	if err != nil {
 		state.enqueueCallResponse(err)
 		return err
 	}
 
 	state.c = agentCall.(*call)
+	if tc.VerifyResponseHash && state.c.Type != models.TypeDetached {
+		state.respHash = sha256.New()
+	}
+	if tc.VerifyResponseCrc32C && state.c.Type != models.TypeDetached {
+		state.respCRC = crc32.New(crc32cTable)
+	}
+	if state.c.Type != models.TypeDetached {
+		state.setResponseWindow(tc.ResponseWindow)
+	}
 	if tc.SlotHashId != "" {
 		hashID, err := hex.DecodeString(tc.SlotHashId)
 		if err != nil {
@@ -741,6 +1034,9 @@ func (pr *pureRunner) handleTryCall(tc *runner.TryCall, state *callHandle) error
 			state.enqueueCallResponse(err)
 			return err
 		}
+		if tc.DetachedResponseMax > 0 {
+			state.detachedMax = tc.DetachedResponseMax
+		}
 		pr.spawnDetachSubmit(state)
 		return nil
 	}
@@ -748,6 +1044,83 @@ func (pr *pureRunner) handleTryCall(tc *runner.TryCall, state *callHandle) error
 	return nil
 }
 
+// callPriority returns the priority class the client sent for the call, if it
+// sent a known one
+func callPriority(ctx context.Context) (pool.CallPriority, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	for _, p := range md.Get(CallPriorityHeader) {
+		switch priority := pool.CallPriority(p); priority {
+		case pool.PriorityInteractive, pool.PriorityBatch:
+			return priority, true
+		}
+	}
+	return "", false
+}
+
+// logFramesRequested reports whether the client asked for log frames in the engagement metadata
+func logFramesRequested(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	return ok && len(md.Get(LogFramesHeader)) > 0
+}
+
+// logFrameWriter is the stderr of calls for which the client asked for log frames.
+// It sends what the function writes in log frames of at most maxDataChunk bytes.
+type logFrameWriter struct {
+	ch *callHandle
+}
+
+func (w *logFrameWriter) Write(data []byte) (int, error) {
+	ch := w.ch
+	ch.logMtx.Lock()
+	defer ch.logMtx.Unlock()
+
+	// Logs are best effort, errors would only stop the agent from copying stderr.
+	for total := 0; total < len(data) && !ch.logsDone; {
+		chunkSize := len(data) - total
+		if chunkSize > ch.maxDataChunk {
+			chunkSize = ch.maxDataChunk
+		}
+		// we cannot retain 'data'
+		cpData := make([]byte, chunkSize)
+		copy(cpData, data[total:total+chunkSize])
+		err := ch.enqueueMsg(&runner.RunnerMsg{Body: &runner.RunnerMsg_Log{Log: &runner.LogFrame{Data: cpData}}})
+		if err != nil {
+			break
+		}
+		total += chunkSize
+	}
+	return len(data), nil
+}
+
+func (w *logFrameWriter) Read(data []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (w *logFrameWriter) Close() error {
+	return nil
+}
+
+// callSlackFromContext returns the time the client had left until the call deadline
+// when it engaged, as sent in the engagement metadata
+func callSlackFromContext(ctx context.Context) (time.Duration, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, false
+	}
+	vals := md.Get(CallSlackHeader)
+	if len(vals) == 0 {
+		return 0, false
+	}
+	slack, err := strconv.ParseInt(vals[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(slack) * time.Millisecond, true
+}
+
 // implements RunnerProtocolServer
 // Handles a client engagement
 func (pr *pureRunner) Engage(engagement runner.RunnerProtocol_EngageServer) error {
@@ -767,7 +1140,23 @@ func (pr *pureRunner) Engage(engagement runner.RunnerProtocol_EngageServer) erro
 	if ok {
 		log.Debug("MD is ", md)
 	}
+
+	// Advertise the largest data frame we accept before anything else is sent
+	// and the compressors clients may use for the engagement streams.
+	header := metadata.Pairs(
+		MaxDataChunkHeader, strconv.Itoa(pr.maxDataChunk),
+		CallModelHeader, callModelProto,
+		ResponseWindowHeader, "1",
+	)
+	if len(pr.compressors) != 0 {
+		header.Set(CompressorsHeader, strings.Join(pr.compressors, ","))
+	}
+	if err := engagement.SendHeader(header); err != nil {
+		log.WithError(err).Info("Failed to send engagement header")
+	}
+
 	state := NewCallHandle(engagement)
+	state.maxDataChunk = pr.maxDataChunk
 	defer state.scancel()
 
 	tryMsg := state.getTryMsg()
@@ -809,6 +1198,146 @@ func (pr *pureRunner) Engage(engagement runner.RunnerProtocol_EngageServer) erro
 	return err
 }
 
+// implements RunnerProtocolServer
+// Runs a call with its request and response body in single messages by handing the
+// request to Engage, see unaryEngagement
+func (pr *pureRunner) Invoke(ctx context.Context, req *runner.InvokeRequest) (*runner.InvokeResponse, error) {
+	if req.GetTry() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Invoke request without TryCall")
+	}
+	// the response is sent in one message, no acks ever open a response window
+	req.Try.ResponseWindow = 0
+
+	engagement := &unaryEngagement{ctx: ctx, in: []*runner.ClientMsg{
+		{Body: &runner.ClientMsg_Try{Try: req.Try}},
+		{Body: &runner.ClientMsg_Data{Data: &runner.DataFrame{Data: req.Body, Eof: true}}},
+	}}
+	err := pr.Engage(engagement)
+
+	msgs, finished := engagement.messages()
+	if !finished {
+		if err == nil {
+			err = status.Error(codes.Internal, "Call ended without finish message")
+		}
+		return nil, err
+	}
+	// the error, if any, is reported to the client in the finish message
+	return &runner.InvokeResponse{Msgs: msgs}, nil
+}
+
+// unaryEngagement is the engagement stream of an Invoke request. It delivers the
+// TryCall and request body, and collects the messages sent back. If the response body
+// exceeds MaxInvokeResponseBody, only a finish message failing the call is kept.
+type unaryEngagement struct {
+	runner.RunnerProtocol_EngageServer
+	ctx context.Context
+	in  []*runner.ClientMsg
+
+	mtx      sync.Mutex
+	out      []*runner.RunnerMsg
+	size     int
+	tooBig   bool
+	finished bool
+}
+
+func (e *unaryEngagement) Context() context.Context {
+	return e.ctx
+}
+
+func (e *unaryEngagement) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (e *unaryEngagement) Recv() (*runner.ClientMsg, error) {
+	if len(e.in) == 0 {
+		return nil, io.EOF
+	}
+	msg := e.in[0]
+	e.in = e.in[1:]
+	return msg, nil
+}
+
+func (e *unaryEngagement) Send(msg *runner.RunnerMsg) error {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	switch body := msg.Body.(type) {
+	case *runner.RunnerMsg_Data:
+		e.size += len(body.Data.Data)
+		e.tooBig = e.tooBig || e.size > MaxInvokeResponseBody
+	case *runner.RunnerMsg_Finished:
+		e.finished = true
+		if e.tooBig {
+			err := models.ErrFunctionResponseTooBig
+			body.Finished.Success = false
+			body.Finished.ErrorCode = int32(models.GetAPIErrorCode(err))
+			body.Finished.ErrorStr = err.Error()
+			body.Finished.ErrorUser = true
+			body.Finished.ErrorStatus = callErrorStatus(err, err.Code(), true, pool.RejectUnknown)
+			e.out = []*runner.RunnerMsg{msg}
+			return nil
+		}
+	}
+	if !e.tooBig {
+		e.out = append(e.out, msg)
+	}
+	return nil
+}
+
+// messages returns the messages sent and whether the call finished
+func (e *unaryEngagement) messages() ([]*runner.RunnerMsg, bool) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.out, e.finished
+}
+
+// implements RunnerProtocolServer
+func (pr *pureRunner) PullImage(ctx context.Context, req *runner.PullImageRequest) (*runner.PullImageResponse, error) {
+	if req.GetImage() == "" {
+		return nil, status.Error(codes.InvalidArgument, "PullImage request without image")
+	}
+	a, ok := pr.a.(imagePuller)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, errPrePullUnsupported.Error())
+	}
+	if err := a.prePullImage(ctx, req.GetImage()); err != nil {
+		if err == errPrePullUnsupported {
+			return nil, status.Error(codes.Unimplemented, err.Error())
+		}
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &runner.PullImageResponse{}, nil
+}
+
+// implements RunnerProtocolServer
+func (pr *pureRunner) ProfileContainer(ctx context.Context, req *runner.ProfileContainerRequest) (*runner.ProfileContainerResponse, error) {
+	a, ok := pr.a.(profiler)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, errRunnerProfileUnsupported.Error())
+	}
+	prof, err := a.profileContainer(ctx, req.GetFnId(), ProfileRequest{Type: req.GetType(), Seconds: int(req.GetSeconds())})
+	switch err {
+	case nil:
+	case ErrFnContainerNotFound:
+		return nil, status.Error(codes.NotFound, err.Error())
+	case ErrProfileUnsupported:
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case ErrProfileTooBig:
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case ErrInvalidProfileType, ErrInvalidProfileSeconds:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	default:
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &runner.ProfileContainerResponse{ContainerId: prof.ContainerID, ContentType: prof.ContentType, Profile: prof.Data}, nil
+}
+
+// implements RunnerProtocolServer
+func (pr *pureRunner) Capabilities(ctx context.Context, caps *runner.Capabilities) (*runner.Capabilities, error) {
+	common.Logger(ctx).WithField("protocol_version", caps.GetProtocolVersion()).WithField("features", caps.GetFeatures()).Debug("Client capabilities")
+	return localCapabilities(pr.maxDataChunk, pr.compressors, pr.labels), nil
+}
+
 // implements RunnerProtocolServer
 func (pr *pureRunner) Status(ctx context.Context, e *empty.Empty) (*runner.RunnerStatus, error) {
 	return pr.status.Status(ctx, e)
@@ -859,14 +1388,23 @@ func (pr *pureRunner) AfterCall(ctx context.Context, call *models.Call) error {
 	return nil
 }
 
-func DefaultPureRunner(cancel context.CancelFunc, addr string, tlsCfg *tls.Config) (Agent, error) {
+// DefaultPureRunner creates a pure runner configured from the environment, see
+// NewRunnerClientConfig, with options applied after those of the environment
+func DefaultPureRunner(cancel context.CancelFunc, addr string, tlsCfg *tls.Config, options ...PureRunnerOption) (Agent, error) {
+	cfg, err := NewRunnerClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	agent := New()
 
+	opts := append([]PureRunnerOption{PureRunnerWithAgent(agent)}, cfg.PureRunnerOptions()...)
+
 	// WARNING: SSL creds are optional.
-	if tlsCfg == nil {
-		return NewPureRunner(cancel, addr, PureRunnerWithAgent(agent))
+	if tlsCfg != nil {
+		opts = append(opts, PureRunnerWithSSL(tlsCfg))
 	}
-	return NewPureRunner(cancel, addr, PureRunnerWithAgent(agent), PureRunnerWithSSL(tlsCfg))
+	return NewPureRunner(cancel, addr, append(opts, options...)...)
 }
 
 type PureRunnerOption func(*pureRunner) error
@@ -966,6 +1504,56 @@ func PureRunnerWithKdumpsOnDisk(numKdumps uint64) PureRunnerOption {
 	}
 }
 
+// PureRunnerWithMaxDataChunk sets the largest data frame the runner advertises
+// to clients on engagement. Clients will not send frames larger than this.
+func PureRunnerWithMaxDataChunk(size int) PureRunnerOption {
+	return func(pr *pureRunner) error {
+		if size <= 0 {
+			return fmt.Errorf("Invalid max data chunk size %d", size)
+		}
+		pr.maxDataChunk = size
+		return nil
+	}
+}
+
+// PureRunnerWithCompressors sets the gRPC compressors the runner advertises to
+// clients, replacing the default of gzip. The compressors must be registered
+// with grpc/encoding. With no names, clients are told not to compress.
+func PureRunnerWithCompressors(names ...string) PureRunnerOption {
+	return func(pr *pureRunner) error {
+		for _, name := range names {
+			if encoding.GetCompressor(name) == nil {
+				return fmt.Errorf("Unknown gRPC compressor %s", name)
+			}
+		}
+		pr.compressors = names
+		return nil
+	}
+}
+
+// PureRunnerWithMinDeadlineSlack rejects calls that have less than slack left
+// until their deadline when they reach the runner. By default only calls whose
+// deadline already passed are rejected.
+func PureRunnerWithMinDeadlineSlack(slack time.Duration) PureRunnerOption {
+	return func(pr *pureRunner) error {
+		if slack < 0 {
+			return fmt.Errorf("Invalid min deadline slack %v", slack)
+		}
+		pr.minDeadlineSlack = slack
+		return nil
+	}
+}
+
+// PureRunnerWithLabels sets labels describing the runner, eg. its zone or hardware, which
+// the runner advertises to clients. LB placers match them against the placement
+// constraints of functions, see models.RunnerConstraintsAnnotation.
+func PureRunnerWithLabels(labels map[string]string) PureRunnerOption {
+	return func(pr *pureRunner) error {
+		pr.labels = labels
+		return nil
+	}
+}
+
 func PureRunnerWithDetached() PureRunnerOption {
 	return func(pr *pureRunner) error {
 		pr.AddCallListener(pr)
@@ -976,7 +1564,11 @@ func PureRunnerWithDetached() PureRunnerOption {
 
 func NewPureRunner(cancel context.CancelFunc, addr string, options ...PureRunnerOption) (Agent, error) {
 
-	pr := &pureRunner{}
+	pr := &pureRunner{
+		maxDataChunk: MaxDataChunk,
+		compressors:  []string{gzip.Name},
+		executions:   newExecutionTokens(DefaultExecutionTokenTTL),
+	}
 	pr.status = NewStatusTracker()
 
 	for _, option := range options {
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -23,8 +24,11 @@ const (
 	Mem1MB = 1024 * 1024
 	Mem1GB = 1024 * 1024 * 1024
 
-	// Assume 2GB RAM on non-linux systems
+	// Assume 2GB RAM on systems other than linux and windows
 	DefaultNonLinuxMemory = 2048 * Mem1MB
+
+	// cgroupRoot is where cgroups are mounted, the unified hierarchy with cgroup v2
+	cgroupRoot = "/sys/fs/cgroup"
 )
 
 var CapacityFull = errors.New("max capacity reached")
@@ -38,24 +42,34 @@ type ResourceUtilization struct {
 	MemUsed uint64
 	// Memory available in bytes
 	MemAvail uint64
+	// GPUs in use
+	GpuUsed uint64
+	// GPUs available
+	GpuAvail uint64
+	// Memory pressure of the host, the share of the last 10 seconds in percent that some
+	// tasks stalled waiting for memory
+	MemPressure float64
+	// CPU pressure of the host, likewise
+	CpuPressure float64
 }
 
-// A simple resource (memory, cpu, disk, etc.) tracker for scheduling.
+// A simple resource (memory, cpu, gpu, disk, etc.) tracker for scheduling.
 // TODO: disk, network IO for future
 type ResourceTracker interface {
 	// GetResourceToken returns a resource token.
-	// Memory is expected to be provided in MB units.
-	GetResourceToken(ctx context.Context, memory uint64, cpuQuota models.MilliCPUs) ResourceToken
+	// Memory is expected to be provided in MB units. GPUs are allotted by id, see
+	// ResourceToken.GPUs.
+	GetResourceToken(ctx context.Context, memory uint64, cpuQuota models.MilliCPUs, gpus uint64) ResourceToken
 
 	// GetResourceTokenNB is the non-blocking equivalent of GetResourceToken. The return value is the
 	// resource token itself. If the request cannot be satisfied, a token with CapacityFull error set is
 	// returned.
 	// Memory is expected to be provided in MB units.
-	GetResourceTokenNB(ctx context.Context, memory uint64, cpuQuota models.MilliCPUs) ResourceToken
+	GetResourceTokenNB(ctx context.Context, memory uint64, cpuQuota models.MilliCPUs, gpus uint64) ResourceToken
 
 	// IsResourcePossible returns whether it's possible to fulfill the requested resources on this machine.
 	// Memory is expected to be provided in MB units.
-	IsResourcePossible(memory uint64, cpuQuota models.MilliCPUs) bool
+	IsResourcePossible(memory uint64, cpuQuota models.MilliCPUs, gpus uint64) bool
 
 	// Retrieve current stats/usage
 	GetUtilization() ResourceUtilization
@@ -72,6 +86,13 @@ type resourceTracker struct {
 	cpuTotal uint64
 	// cpuUsed is cpu reserved for running containers including hot/idle
 	cpuUsed uint64
+	// gpuTotal is the number of usable gpus for functions
+	gpuTotal uint64
+	// gpuFree are the ids of the gpus not allotted to running containers including hot/idle
+	gpuFree []string
+
+	// pressure reads the pressure of the host, nil if not linux
+	pressure *pressureReader
 }
 
 func NewResourceTracker(cfg *Config) ResourceTracker {
@@ -82,6 +103,10 @@ func NewResourceTracker(cfg *Config) ResourceTracker {
 
 	obj.initializeMemory(cfg)
 	obj.initializeCPU(cfg)
+	obj.initializeGPU(cfg)
+	if runtime.GOOS == "linux" {
+		obj.pressure = newPressureReader(procPressureDir)
+	}
 	return obj
 }
 
@@ -89,7 +114,9 @@ type ResourceToken interface {
 	// Close must be called by any thread that receives a token.
 	io.Closer
 	Error() error
-	NeededCapacity() (uint64, models.MilliCPUs)
+	NeededCapacity() (uint64, models.MilliCPUs, uint64)
+	// GPUs returns the ids of the gpus allotted to the token
+	GPUs() []string
 }
 
 type resourceToken struct {
@@ -97,6 +124,8 @@ type resourceToken struct {
 	err       error
 	needCpu   models.MilliCPUs
 	needMem   uint64
+	needGpu   uint64
+	gpus      []string
 	decrement func()
 }
 
@@ -104,8 +133,12 @@ func (t *resourceToken) Error() error {
 	return t.err
 }
 
-func (t *resourceToken) NeededCapacity() (uint64, models.MilliCPUs) {
-	return t.needMem, t.needCpu
+func (t *resourceToken) NeededCapacity() (uint64, models.MilliCPUs, uint64) {
+	return t.needMem, t.needCpu, t.needGpu
+}
+
+func (t *resourceToken) GPUs() []string {
+	return t.gpus
 }
 
 func (t *resourceToken) Close() error {
@@ -117,12 +150,13 @@ func (t *resourceToken) Close() error {
 	return nil
 }
 
-func (a *resourceTracker) isResourceAvailableLocked(memory uint64, cpuQuota models.MilliCPUs) bool {
+func (a *resourceTracker) isResourceAvailableLocked(memory uint64, cpuQuota models.MilliCPUs, gpus uint64) bool {
 
 	availMem := a.ramTotal - a.ramUsed
 	availCPU := a.cpuTotal - a.cpuUsed
+	availGPU := uint64(len(a.gpuFree))
 
-	return availMem >= memory && availCPU >= uint64(cpuQuota)
+	return availMem >= memory && availCPU >= uint64(cpuQuota) && availGPU >= gpus
 }
 
 func (a *resourceTracker) GetUtilization() ResourceUtilization {
@@ -132,31 +166,41 @@ func (a *resourceTracker) GetUtilization() ResourceUtilization {
 
 	util.CpuUsed = models.MilliCPUs(a.cpuUsed)
 	util.MemUsed = a.ramUsed
+	util.GpuAvail = uint64(len(a.gpuFree))
 
 	a.cond.L.Unlock()
 
 	util.CpuAvail = models.MilliCPUs(a.cpuTotal) - util.CpuUsed
 	util.MemAvail = a.ramTotal - util.MemUsed
+	util.GpuUsed = a.gpuTotal - util.GpuAvail
+	util.MemPressure, util.CpuPressure = a.pressure.pressures()
 
 	return util
 }
 
 // is this request possible to meet? If no, fail quick
-func (a *resourceTracker) IsResourcePossible(memory uint64, cpuQuota models.MilliCPUs) bool {
+func (a *resourceTracker) IsResourcePossible(memory uint64, cpuQuota models.MilliCPUs, gpus uint64) bool {
 	memory = memory * Mem1MB
-	return memory <= a.ramTotal && uint64(cpuQuota) <= a.cpuTotal
+	return memory <= a.ramTotal && uint64(cpuQuota) <= a.cpuTotal && gpus <= a.gpuTotal
 }
 
-func (a *resourceTracker) allocResourcesLocked(memory uint64, cpuQuota models.MilliCPUs) ResourceToken {
+func (a *resourceTracker) allocResourcesLocked(memory uint64, cpuQuota models.MilliCPUs, gpus uint64) ResourceToken {
 
 	a.ramUsed += memory
 	a.cpuUsed += uint64(cpuQuota)
 
-	return &resourceToken{decrement: func() {
+	var ids []string
+	if gpus > 0 {
+		ids = append(ids, a.gpuFree[:gpus]...)
+		a.gpuFree = a.gpuFree[gpus:]
+	}
+
+	return &resourceToken{gpus: ids, decrement: func() {
 
 		a.cond.L.Lock()
 		a.ramUsed -= memory
 		a.cpuUsed -= uint64(cpuQuota)
+		a.gpuFree = append(a.gpuFree, ids...)
 		a.cond.L.Unlock()
 
 		// WARNING: yes, we wake up everyone pool has space, but the cost of this
@@ -166,27 +210,29 @@ func (a *resourceTracker) allocResourcesLocked(memory uint64, cpuQuota models.Mi
 	}}
 }
 
-func (a *resourceTracker) GetResourceTokenNB(ctx context.Context, memory uint64, cpuQuota models.MilliCPUs) ResourceToken {
+func (a *resourceTracker) GetResourceTokenNB(ctx context.Context, memory uint64, cpuQuota models.MilliCPUs, gpus uint64) ResourceToken {
 
 	ctx, span := trace.StartSpan(ctx, "agent_get_resource_token_nb")
 	defer span.End()
 
-	if !a.IsResourcePossible(memory, cpuQuota) {
-		return &resourceToken{err: CapacityFull, needCpu: cpuQuota, needMem: memory}
+	if !a.IsResourcePossible(memory, cpuQuota, gpus) {
+		return &resourceToken{err: CapacityFull, needCpu: cpuQuota, needMem: memory, needGpu: gpus}
 	}
 	memory = memory * Mem1MB
 
 	var t ResourceToken
 	var needMem uint64
 	var needCpu models.MilliCPUs
+	var needGpu uint64
 
 	a.cond.L.Lock()
 
 	availMem := a.ramTotal - a.ramUsed
 	availCPU := a.cpuTotal - a.cpuUsed
+	availGPU := uint64(len(a.gpuFree))
 
-	if availMem >= memory && availCPU >= uint64(cpuQuota) {
-		t = a.allocResourcesLocked(memory, cpuQuota)
+	if availMem >= memory && availCPU >= uint64(cpuQuota) && availGPU >= gpus {
+		t = a.allocResourcesLocked(memory, cpuQuota, gpus)
 	} else {
 		if availMem < memory {
 			needMem = (memory - availMem) / Mem1MB
@@ -194,22 +240,25 @@ func (a *resourceTracker) GetResourceTokenNB(ctx context.Context, memory uint64,
 		if availCPU < uint64(cpuQuota) {
 			needCpu = models.MilliCPUs(uint64(cpuQuota) - availCPU)
 		}
+		if availGPU < gpus {
+			needGpu = gpus - availGPU
+		}
 
-		t = &resourceToken{err: CapacityFull, needCpu: needCpu, needMem: needMem}
+		t = &resourceToken{err: CapacityFull, needCpu: needCpu, needMem: needMem, needGpu: needGpu}
 	}
 
 	a.cond.L.Unlock()
 	return t
 }
 
-func (a *resourceTracker) GetResourceToken(ctx context.Context, memory uint64, cpuQuota models.MilliCPUs) ResourceToken {
+func (a *resourceTracker) GetResourceToken(ctx context.Context, memory uint64, cpuQuota models.MilliCPUs, gpus uint64) ResourceToken {
 
 	ctx, span := trace.StartSpan(ctx, "agent_get_resource_token")
 	defer span.End()
 
 	var t ResourceToken
 
-	if !a.IsResourcePossible(memory, cpuQuota) || ctx.Err() != nil {
+	if !a.IsResourcePossible(memory, cpuQuota, gpus) || ctx.Err() != nil {
 		return t
 	}
 
@@ -235,13 +284,13 @@ func (a *resourceTracker) GetResourceToken(ctx context.Context, memory uint64, c
 	c.L.Lock()
 
 	isWaiting = true
-	for !a.isResourceAvailableLocked(memory, cpuQuota) && ctx.Err() == nil {
+	for !a.isResourceAvailableLocked(memory, cpuQuota, gpus) && ctx.Err() == nil {
 		c.Wait()
 	}
 	isWaiting = false
 
 	if ctx.Err() == nil {
-		t = a.allocResourcesLocked(memory, cpuQuota)
+		t = a.allocResourcesLocked(memory, cpuQuota, gpus)
 	}
 
 	c.L.Unlock()
@@ -326,6 +375,18 @@ func (a *resourceTracker) initializeCPU(cfg *Config) {
 	}
 }
 
+// initializeGPU takes the gpus of the host from config, there are none unless configured
+func (a *resourceTracker) initializeGPU(cfg *Config) {
+	if cfg == nil {
+		return
+	}
+	a.gpuFree = strings.Fields(cfg.GPUDevices)
+	a.gpuTotal = uint64(len(a.gpuFree))
+	if a.gpuTotal > 0 {
+		logrus.WithFields(logrus.Fields{"gpus": a.gpuFree}).Info("available gpus")
+	}
+}
+
 func (a *resourceTracker) initializeMemory(cfg *Config) {
 
 	availMemory := uint64(DefaultNonLinuxMemory)
@@ -360,6 +421,24 @@ func (a *resourceTracker) initializeMemory(cfg *Config) {
 			"head_room":    headRoom,
 			"cgroup_limit": cGroupLimit,
 		}).Info("available memory")
+	} else if runtime.GOOS == "windows" {
+
+		// windows containers have no cgroups, only the memory of the host bounds them
+		totalMemory, err := checkHostMem()
+		if err != nil {
+			logrus.WithError(err).Fatal("Cannot get the proper memory information to size server.")
+		}
+
+		headRoom, err := getMemoryHeadRoom(totalMemory, cfg)
+		if err != nil {
+			logrus.WithError(err).Fatal("Out of memory")
+		}
+		availMemory = totalMemory - headRoom
+
+		logrus.WithFields(logrus.Fields{
+			"total_memory": totalMemory,
+			"head_room":    headRoom,
+		}).Info("available memory")
 	}
 
 	// now based on cfg, further clamp on calculated values
@@ -367,9 +446,18 @@ func (a *resourceTracker) initializeMemory(cfg *Config) {
 		availMemory = minUint64(cfg.MaxTotalMemory, availMemory)
 	}
 
+	// idle containers may reserve more than the memory of the host, see EnvMemoryOvercommit
+	if cfg != nil && cfg.MemoryOvercommit > 100 {
+		logrus.WithFields(logrus.Fields{
+			"usable_memory":  availMemory,
+			"overcommit_pct": cfg.MemoryOvercommit,
+		}).Info("overcommitting memory")
+		availMemory = availMemory / 100 * cfg.MemoryOvercommit
+	}
+
 	a.ramTotal = availMemory
 
-	// For non-linux OS, we expect these (or their defaults) properly configured from command-line/env
+	// For OS other than linux and windows, we expect these (or their defaults) properly configured from command-line/env
 	logrus.WithFields(logrus.Fields{
 		"avail_memory": a.ramTotal,
 	}).Info("ram reservations")
@@ -418,7 +506,32 @@ func readString(fileName string) (string, error) {
 	return strings.TrimSpace(value), nil
 }
 
+// isCgroupV2 returns whether cgroups are the unified cgroup v2 hierarchy
+func isCgroupV2() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+// cgroupV2Dir returns the directory of the cgroup of the agent in the unified hierarchy,
+// the root if the agent runs in a cgroup namespace, eg. in a container
+func cgroupV2Dir() string {
+	value, err := readString("/proc/self/cgroup")
+	if err != nil {
+		return cgroupRoot
+	}
+	// with cgroup v2, the only line is of form 0::/path
+	for _, line := range strings.Split(value, "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return filepath.Join(cgroupRoot, strings.TrimPrefix(line, "0::"))
+		}
+	}
+	return cgroupRoot
+}
+
 func checkCgroupMem() (uint64, error) {
+	if isCgroupV2() {
+		return checkCgroupV2Mem(cgroupV2Dir())
+	}
 	value, err := readString("/sys/fs/cgroup/memory/memory.limit_in_bytes")
 	if err != nil {
 		return 0, err
@@ -426,7 +539,22 @@ func checkCgroupMem() (uint64, error) {
 	return strconv.ParseUint(value, 10, 64)
 }
 
+// checkCgroupV2Mem returns the memory.max of the cgroup v2 at dir
+func checkCgroupV2Mem(dir string) (uint64, error) {
+	value, err := readString(filepath.Join(dir, "memory.max"))
+	if err != nil {
+		return 0, err
+	}
+	if value == "max" {
+		return math.MaxUint64, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
 func checkCgroupCPU() uint64 {
+	if isCgroupV2() {
+		return checkCgroupV2CPU(cgroupV2Dir())
+	}
 
 	periodStr, err := readString("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
 	if err != nil {
@@ -437,6 +565,26 @@ func checkCgroupCPU() uint64 {
 		return 0
 	}
 
+	return cfsCPU(quotaStr, periodStr)
+}
+
+// checkCgroupV2CPU returns the cpu.max of the cgroup v2 at dir in milli CPUs, zero if
+// there is no limit. cpu.max is of form "$MAX $PERIOD", of which $MAX may be max.
+func checkCgroupV2CPU(dir string) uint64 {
+	value, err := readString(filepath.Join(dir, "cpu.max"))
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(value)
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0
+	}
+	return cfsCPU(fields[0], fields[1])
+}
+
+// cfsCPU returns the milli CPUs of a CFS quota and period, zero if there is no limit
+func cfsCPU(quotaStr, periodStr string) uint64 {
+
 	period, err := strconv.ParseUint(periodStr, 10, 64)
 	if err != nil {
 		logrus.Warn("Cannot parse CFS period", err)
@@ -0,0 +1,11 @@
+// +build !windows
+
+package agent
+
+import "errors"
+
+// checkHostMem returns the memory available on hosts other than linux, which only windows
+// hosts report, the memory of other hosts must be configured
+func checkHostMem() (uint64, error) {
+	return 0, errors.New("memory of the host is unknown")
+}
@@ -2,8 +2,16 @@ package agent
 
 import (
 	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
+
+	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
 )
 
 func setTrackerTestVals(tr *resourceTracker, vals *trackerVals) {
@@ -70,7 +78,7 @@ func TestResourceGetSimple(t *testing.T) {
 
 	// ask for 4GB and 10 CPU
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(500)*time.Millisecond)
-	tok := trI.GetResourceToken(ctx, 4*1024, 1000)
+	tok := trI.GetResourceToken(ctx, 4*1024, 1000, 0)
 	defer cancel()
 
 	if tok != nil {
@@ -82,7 +90,7 @@ func TestResourceGetSimple(t *testing.T) {
 	setTrackerTestVals(tr, &vals)
 
 	ctx, cancel = context.WithTimeout(context.Background(), time.Duration(500)*time.Millisecond)
-	tok = trI.GetResourceToken(ctx, 4*1024, 1000)
+	tok = trI.GetResourceToken(ctx, 4*1024, 1000, 0)
 	defer cancel()
 	if tok == nil {
 		t.Fatalf("full system should hand out token")
@@ -90,7 +98,7 @@ func TestResourceGetSimple(t *testing.T) {
 
 	// ask for another 4GB and 10 CPU
 	ctx, cancel = context.WithTimeout(context.Background(), time.Duration(500)*time.Millisecond)
-	tok1 := trI.GetResourceToken(ctx, 4*1024, 1000)
+	tok1 := trI.GetResourceToken(ctx, 4*1024, 1000, 0)
 	defer cancel()
 
 	if tok1 != nil {
@@ -101,7 +109,7 @@ func TestResourceGetSimple(t *testing.T) {
 	tok.Close()
 
 	ctx, cancel = context.WithTimeout(context.Background(), time.Duration(500)*time.Millisecond)
-	tok = trI.GetResourceToken(ctx, 4*1024, 1000)
+	tok = trI.GetResourceToken(ctx, 4*1024, 1000, 0)
 	defer cancel()
 	if tok == nil {
 		t.Fatalf("full system should hand out token")
@@ -119,6 +127,44 @@ func TestResourceGetSimple(t *testing.T) {
 	}
 }
 
+func TestResourceGetGPUs(t *testing.T) {
+	trI := NewResourceTracker(&Config{GPUDevices: "0 1 2"})
+	ctx := context.Background()
+
+	if trI.IsResourcePossible(128, 0, 4) {
+		t.Fatalf("more gpus than the host has should not be possible")
+	}
+
+	tok := trI.GetResourceTokenNB(ctx, 128, 0, 2)
+	if tok.Error() != nil {
+		t.Fatalf("empty system should hand out token")
+	}
+	if gpus := tok.GPUs(); len(gpus) != 2 || gpus[0] != "0" || gpus[1] != "1" {
+		t.Fatalf("token should have gpus 0 and 1, got %v", gpus)
+	}
+
+	tok1 := trI.GetResourceTokenNB(ctx, 128, 0, 2)
+	if tok1.Error() != CapacityFull {
+		t.Fatalf("system without enough gpus should not hand out token")
+	}
+	if _, _, needGpu := tok1.NeededCapacity(); needGpu != 1 {
+		t.Fatalf("token should need 1 gpu, got %d", needGpu)
+	}
+
+	tok.Close()
+	tok1 = trI.GetResourceTokenNB(ctx, 128, 0, 3)
+	if tok1.Error() != nil || len(tok1.GPUs()) != 3 {
+		t.Fatalf("released gpus should be handed out again, got %v %v", tok1.Error(), tok1.GPUs())
+	}
+	if util := trI.GetUtilization(); util.GpuUsed != 3 || util.GpuAvail != 0 {
+		t.Fatalf("faulty state GPU %#v", util)
+	}
+	tok1.Close()
+	if util := trI.GetUtilization(); util.GpuUsed != 0 || util.GpuAvail != 3 {
+		t.Fatalf("faulty state GPU %#v", util)
+	}
+}
+
 func TestResourceGetSimpleNB(t *testing.T) {
 
 	var vals trackerVals
@@ -135,7 +181,7 @@ func TestResourceGetSimpleNB(t *testing.T) {
 
 	// ask for 4GB and 10 CPU
 	ctx, cancel := context.WithCancel(context.Background())
-	tok := trI.GetResourceTokenNB(ctx, 4*1024, 1000)
+	tok := trI.GetResourceTokenNB(ctx, 4*1024, 1000, 0)
 	defer cancel()
 
 	if tok.Error() == nil {
@@ -146,14 +192,14 @@ func TestResourceGetSimpleNB(t *testing.T) {
 	vals.setDefaults()
 	setTrackerTestVals(tr, &vals)
 
-	tok1 := trI.GetResourceTokenNB(ctx, 4*1024, 1000)
+	tok1 := trI.GetResourceTokenNB(ctx, 4*1024, 1000, 0)
 	if tok1.Error() != nil {
 		t.Fatalf("empty system should hand out token")
 	}
 
 	// ask for another 4GB and 10 CPU
 	ctx, cancel = context.WithCancel(context.Background())
-	tok = trI.GetResourceTokenNB(ctx, 4*1024, 1000)
+	tok = trI.GetResourceTokenNB(ctx, 4*1024, 1000, 0)
 	defer cancel()
 
 	if tok.Error() == nil {
@@ -163,7 +209,7 @@ func TestResourceGetSimpleNB(t *testing.T) {
 	// close means, giant token resources released
 	tok1.Close()
 
-	tok = trI.GetResourceTokenNB(ctx, 4*1024, 1000)
+	tok = trI.GetResourceTokenNB(ctx, 4*1024, 1000, 0)
 	if tok.Error() != nil {
 		t.Fatalf("empty system should hand out token")
 	}
@@ -179,3 +225,195 @@ func TestResourceGetSimpleNB(t *testing.T) {
 		t.Fatalf("faulty state CPU %#v", vals)
 	}
 }
+
+func TestBatchAdmission(t *testing.T) {
+	trI := NewResourceTracker(nil)
+	tr := trI.(*resourceTracker)
+	a := &agent{cfg: Config{BatchHeadroom: 20}, resources: trI}
+
+	var vals trackerVals
+	vals.setDefaults()
+	vals.mt, vals.mu = 1000, 700
+	vals.ct, vals.cu = 1000, 0
+	setTrackerTestVals(tr, &vals)
+
+	if _, ok := a.batchAdmission(100, 0); !ok {
+		t.Fatalf("Expected batch call below the headroom to be admitted")
+	}
+	if reason, ok := a.batchAdmission(101, 0); ok || reason != pool.RejectMemory {
+		t.Fatalf("Expected batch call reaching into the headroom rejected for memory, got %v %q", ok, reason)
+	}
+	if reason, ok := a.batchAdmission(100, 900); ok || reason != pool.RejectCPU {
+		t.Fatalf("Expected batch call reaching into the headroom rejected for CPU, got %v %q", ok, reason)
+	}
+
+	a.cfg.BatchHeadroom = 0
+	if _, ok := a.batchAdmission(300, 1000); !ok {
+		t.Fatalf("Expected batch call admitted without headroom")
+	}
+}
+
+func TestPriorityAdmission(t *testing.T) {
+	trI := NewResourceTracker(nil)
+	tr := trI.(*resourceTracker)
+	a := &agent{cfg: Config{HotPoll: time.Minute}, resources: trI}
+
+	var vals trackerVals
+	vals.setDefaults()
+	vals.mt, vals.mu = 1000, 700
+	vals.ct, vals.cu = 1000, 0
+	setTrackerTestVals(tr, &vals)
+
+	interactive := &call{Call: &models.Call{}}
+	batch := &call{Call: &models.Call{Type: models.TypeDetached}}
+
+	if !a.priorityAdmission(batch, 400, 0) {
+		t.Fatalf("Expected batch call admitted while no interactive call waits")
+	}
+	if !a.priorityAdmission(interactive, 300, 0) {
+		t.Fatalf("Expected interactive call admitted to free resources")
+	}
+	if !a.priorityAdmission(batch, 300, 0) {
+		t.Fatalf("Expected batch call admitted to free resources")
+	}
+	if !a.priorityAdmission(interactive, 400, 0) {
+		t.Fatalf("Expected interactive call admitted")
+	}
+	if a.priorityAdmission(batch, 400, 0) {
+		t.Fatalf("Expected batch call not admitted while an interactive call waits")
+	}
+
+	a.cfg.HotPoll = 0
+	if !a.priorityAdmission(batch, 400, 0) {
+		t.Fatalf("Expected batch call admitted once the interactive call stopped waiting")
+	}
+}
+
+func TestCgroupV2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, value string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("memory.max", "max")
+	if mem, err := checkCgroupV2Mem(dir); err != nil || mem != math.MaxUint64 {
+		t.Fatalf("Expected no memory limit, got %v %v", mem, err)
+	}
+	write("memory.max", "1073741824")
+	if mem, err := checkCgroupV2Mem(dir); err != nil || mem != Mem1GB {
+		t.Fatalf("Expected 1GB memory limit, got %v %v", mem, err)
+	}
+
+	write("cpu.max", "max 100000")
+	if cpu := checkCgroupV2CPU(dir); cpu != 0 {
+		t.Fatalf("Expected no CPU limit, got %v", cpu)
+	}
+	write("cpu.max", "200000 100000")
+	if cpu := checkCgroupV2CPU(dir); cpu != 2000 {
+		t.Fatalf("Expected 2000 milli CPUs, got %v", cpu)
+	}
+}
+
+func TestPressureAdmission(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pressure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	trI := NewResourceTracker(nil)
+	tr := trI.(*resourceTracker)
+	tr.pressure = newPressureReader(dir)
+	a := &agent{cfg: Config{MaxMemoryPressure: 20}, resources: trI}
+
+	// hosts without PSI have no pressure
+	if _, ok := a.pressureAdmission(); !ok {
+		t.Fatalf("Expected call admitted without PSI")
+	}
+
+	psi := "some avg10=35.50 avg60=10.00 avg300=2.00 total=1000\nfull avg10=20.00 avg60=5.00 avg300=1.00 total=500\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory"), []byte(psi), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := readPressure(filepath.Join(dir, "memory")); err != nil || p != 35.5 {
+		t.Fatalf("Expected memory pressure of 35.5, got %v %v", p, err)
+	}
+
+	tr.pressure = newPressureReader(dir)
+	if reason, ok := a.pressureAdmission(); ok || reason != pool.RejectPressure {
+		t.Fatalf("Expected call rejected for memory pressure, got %v %q", ok, reason)
+	}
+
+	a.cfg.MaxMemoryPressure = 50
+	if _, ok := a.pressureAdmission(); !ok {
+		t.Fatalf("Expected call below the max memory pressure to be admitted")
+	}
+}
+
+func TestPressureEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pressure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{MemoryOvercommit: 150, OvercommitEvictPressure: 10, MaxTotalMemory: 1000 * Mem1MB}
+	trI := NewResourceTracker(cfg)
+	tr := trI.(*resourceTracker)
+	if tr.ramTotal != 1500*Mem1MB {
+		t.Fatalf("Expected 1500MB of overcommitted memory, got %v", tr.ramTotal)
+	}
+	tr.pressure = newPressureReader(dir)
+	a := &agent{cfg: *cfg, resources: trI, evictor: NewEvictor()}
+
+	var tokens []*EvictToken
+	for i, mem := range []uint64{100, 150, 300} {
+		tok := a.evictor.CreateEvictToken("slot"+strconv.Itoa(i), mem*Mem1MB, 0, 0)
+		tok.SetEvictable(true)
+		tokens = append(tokens, tok)
+	}
+
+	var vals trackerVals
+	vals.setDefaults()
+	vals.mt, vals.mu = 1500*Mem1MB, 1200*Mem1MB
+	setTrackerTestVals(tr, &vals)
+
+	// hosts without PSI have no pressure
+	if chans := a.pressureEviction(); len(chans) != 0 {
+		t.Fatalf("Expected no evictions without PSI, got %d", len(chans))
+	}
+
+	psi := "some avg10=35.50 avg60=10.00 avg300=2.00 total=1000\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "memory"), []byte(psi), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr.pressure = newPressureReader(dir)
+
+	// 200MB are reserved past the usable memory
+	if chans := a.pressureEviction(); len(chans) != 2 || !tokens[0].isEvicted() || !tokens[1].isEvicted() || tokens[2].isEvicted() {
+		t.Fatalf("Expected the 2 oldest containers evicted, got %d", len(chans))
+	}
+
+	vals.mu = 800 * Mem1MB
+	setTrackerTestVals(tr, &vals)
+	if chans := a.pressureEviction(); len(chans) != 1 || !tokens[2].isEvicted() {
+		t.Fatalf("Expected a container evicted within the usable memory, got %d", len(chans))
+	}
+	if chans := a.pressureEviction(); len(chans) != 0 {
+		t.Fatalf("Expected no evictions without idle containers, got %d", len(chans))
+	}
+
+	tok := a.evictor.CreateEvictToken("slot", 100*Mem1MB, 0, 0)
+	tok.SetEvictable(true)
+	a.cfg.OvercommitEvictPressure = 50
+	if chans := a.pressureEviction(); len(chans) != 0 || tok.isEvicted() {
+		t.Fatalf("Expected no evictions below the evict pressure, got %d", len(chans))
+	}
+}
@@ -1,20 +1,33 @@
 package agent
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.opencensus.io/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the gzip compressor
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
@@ -25,31 +38,573 @@ import (
 	"github.com/fnproject/fn/grpcutil"
 
 	pb_empty "github.com/golang/protobuf/ptypes/empty"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/sirupsen/logrus"
 )
 
+// crc32cTable is used for response checksums, see GRPCRunnerWithResponseCRC32C
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 var (
-	ErrorRunnerClosed    = errors.New("Runner is closed")
-	ErrorPureRunnerNoEOF = errors.New("Purerunner missing EOF response")
+	ErrorRunnerClosed      = errors.New("Runner is closed")
+	ErrorPureRunnerNoEOF   = errors.New("Purerunner missing EOF response")
+	ErrorRunnerCircuitOpen = errors.New("Runner circuit breaker is open")
+	ErrorRunnerCordoned    = errors.New("Runner is cordoned")
+	ErrorRunnerDraining    = errors.New("Runner is draining")
+	// ErrorRunnerProtocol is returned when a runner that exchanged capabilities sends a
+	// message the LB does not understand
+	ErrorRunnerProtocol = errors.New("Runner sent an unexpected message")
 )
 
 const (
 	// max buffer size for grpc data messages, 10K
 	MaxDataChunk          = 10 * 1024
 	DefaultConnectTimeout = 100 * time.Millisecond
+	// how long to wait for a runner we have not engaged before to advertise its max data chunk
+	DefaultHandshakeTimeout = 100 * time.Millisecond
+
+	// MaxDataChunkHeader is the engagement header a pure runner uses to advertise
+	// the largest data frame it accepts
+	MaxDataChunkHeader = "fn-max-data-chunk"
+	// DefaultReadyCheckInterval is how often Ready re-checks runner Status in the background
+	DefaultReadyCheckInterval = 5 * time.Second
+	// DefaultReadyCheckTimeout is the timeout of the Status call made by a ready check
+	DefaultReadyCheckTimeout = 10 * time.Second
+	// CompressorsHeader is the engagement response header in which a pure runner lists
+	// the gRPC compressors it accepts, comma separated
+	CompressorsHeader = "fn-compressors"
+	// CallDeadlineHeader is the engagement metadata with the wall clock deadline of the call
+	CallDeadlineHeader = "fn-call-deadline"
+	// CallSlackHeader is the engagement metadata with the time left until the call
+	// deadline in milliseconds, measured by the client when engaging. Unlike the
+	// deadline it does not depend on the clocks of client and runner agreeing.
+	CallSlackHeader = "fn-call-slack-ms"
+	// ResponseWindowHeader is the engagement header a pure runner sets if it honors
+	// TryCall.ResponseWindow, see GRPCRunnerWithResponseWindow
+	ResponseWindowHeader = "fn-response-window"
+	// LogFramesHeader is the engagement metadata with which a client asks the pure
+	// runner to send function stderr in log frames, see GRPCRunnerWithLogFrames
+	LogFramesHeader = "fn-log-frames"
+	// CallPriorityHeader is the engagement metadata with the priority class of the call
+	CallPriorityHeader = "fn-call-priority"
 )
 
 type gRPCRunner struct {
 	shutWg  *common.WaitGroup
 	address string
-	conn    *grpc.ClientConn
-	client  pb.RunnerProtocolClient
+	// pool of connections to the runner, streams are assigned round robin
+	conns      []*grpc.ClientConn
+	clients    []pb.RunnerProtocolClient
+	nextClient uint64
+	numConns   int
+
+	connectTimeout time.Duration
+	dialOpts       []grpc.DialOption
+	// reconnection policy, nil for the gRPC default, see GRPCRunnerWithDialBackoff
+	dialBackoff *grpcutil.BackoffConfig
+	// client interceptors, chained in the order they were added
+	unaryInterceptors  []grpc.UnaryClientInterceptor
+	streamInterceptors []grpc.StreamClientInterceptor
+
+	maxExtensionsSize int
+	extensionsPolicy  ExtensionsLimitPolicy
+	essentialExts     map[string]bool
+
+	cordonMtx   sync.Mutex
+	cordonTimer *time.Timer
+	// set while the runner is draining, see Drain
+	draining int32
+	// calls placed on the runner that did not finish yet
+	callsInFlight int32
+
+	maxDataChunk int
+	// data chunk size advertised by the runner: 0 if never negotiated, -1 if not advertised
+	advertisedChunk int64
+
+	events    CallEventSink
+	schedWait *latencyWindow
+
+	// compressor to use for engagements once the runner has advertised it
+	compressor string
+	// whether the runner accepts compressor: 0 if never negotiated, 1 if it does, -1 if not
+	compressorOK int32
+	// whether the runner accepts TryCall.Call, same values as compressorOK
+	callModelOK int32
+
+	// whether capabilities are exchanged with the runner, see checkHandshake
+	handshakeEnabled bool
+	// result of the handshake: 0 if not done yet, 1 if done, -1 if not implemented by the runner
+	capsState int32
+	// unix nanos of the last handshake attempt
+	lastHandshake int64
+
+	breaker *circuitBreaker
+	ejector *runnerEjector
+
+	// result of the last Status call: 0 if none finished yet, 1 if it succeeded, -1 if not
+	statusState int32
+	// unix nanos of the last ready check, to rate limit background Status calls
+	lastReadyCheck     int64
+	readyCheckInterval time.Duration
+	// nil unless Status results are cached, see GRPCRunnerWithStatusCache
+	statusCache *runnerStatusCache
+
+	verifyResponseHash   bool
+	verifyResponseCRC32C bool
+	executionTokens      bool
+	metadataFunc         MetadataFunc
+	recording            *sessionRecordingConfig
+
+	// calls with request bodies up to this size are run with Invoke, zero disables it
+	invokeThreshold int
+	// set once the runner turned out not to implement Invoke
+	invokeUnsupported int32
+
+	// response bytes the runner may send before we acknowledge them, zero disables it
+	responseWindow int64
+	// ask runners for function stderr, see GRPCRunnerWithLogFrames
+	logFrames bool
+	// how long to wait for the runner after cancelling a call, zero disables cancel messages
+	cancelGrace time.Duration
+	// how long Close waits for in-flight calls, zero waits until the context of Close is done
+	drainTimeout time.Duration
+	// relative share of calls placers give the runner, see pool.WeightedRunner
+	weight int
+	// labels of the runner configured on the LB, see GRPCRunnerWithLabels
+	configLabels map[string]string
+	// map[string]string, configLabels merged with the labels advertised by the runner
+	labels atomic.Value
+}
+
+// MetadataFunc returns additional gRPC metadata to send to the runner for the call
+// or status request with the given context
+type MetadataFunc func(ctx context.Context) metadata.MD
+
+// receiveOptions configures how receiveFromRunner processes runner messages
+type receiveOptions struct {
+	// invoked with the call event for every finished call
+	onFinish func(context.Context, *CallEvent)
+	// if set, invoked when the runner accepts the call. Nothing is written to the
+	// call if it returns false.
+	onAccept func() bool
+	// verify the response hash and checksum reported by the runner, if any
+	verifyResponseHash   bool
+	verifyResponseCRC32C bool
+	// if set, acknowledge the response bytes written to the client, see GRPCRunnerWithResponseWindow
+	responseWindow int64
+	// fail calls on unknown messages instead of ignoring them, set for runners that
+	// exchanged capabilities and so only send messages the LB understands
+	strictMessages bool
+}
+
+// GRPCRunnerOption configures a runner created with NewgRPCRunnerWithOptions
+type GRPCRunnerOption func(*gRPCRunner) error
+
+// ExtensionsLimitPolicy determines what TryExec does with a call whose
+// extensions exceed the configured maximum size
+type ExtensionsLimitPolicy int
+
+const (
+	// ExtensionsLimitFail rejects the call
+	ExtensionsLimitFail ExtensionsLimitPolicy = iota
+	// ExtensionsLimitDrop drops non-essential extension keys, largest first,
+	// until the extensions fit. The call is rejected if they still do not fit.
+	ExtensionsLimitDrop
+)
+
+// GRPCRunnerWithConnectTimeout sets the timeout for the initial connection to the runner
+func GRPCRunnerWithConnectTimeout(timeout time.Duration) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		r.connectTimeout = timeout
+		return nil
+	}
+}
+
+// GRPCRunnerWithDialBackoff sets the policy for retrying failed connection attempts
+// to the runner, which otherwise is the gRPC default
+func GRPCRunnerWithDialBackoff(backoff grpcutil.BackoffConfig) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		err := backoff.Validate()
+		if err != nil {
+			return err
+		}
+		r.dialBackoff = &backoff
+		return nil
+	}
+}
+
+// GRPCRunnerWithConnections sets the number of connections opened to the runner.
+// Engage streams and status calls are spread over them round robin.
+func GRPCRunnerWithConnections(n int) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if n <= 0 {
+			return fmt.Errorf("Invalid number of runner connections %d", n)
+		}
+		r.numConns = n
+		return nil
+	}
+}
+
+// GRPCRunnerWithMaxDataChunk sets the largest data frame sent to the runner. The
+// runner may advertise a smaller limit on engagement, in which case that is used.
+func GRPCRunnerWithMaxDataChunk(size int) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if size <= 0 {
+			return fmt.Errorf("Invalid max data chunk size %d", size)
+		}
+		r.maxDataChunk = size
+		return nil
+	}
+}
+
+// GRPCRunnerWithCompression compresses engagement streams with the named gRPC
+// compressor, e.g. "gzip". The compressor must be registered with grpc/encoding.
+// Streams are only compressed once the runner has advertised support for it.
+func GRPCRunnerWithCompression(name string) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if r.compressor != "" {
+			return errors.New("Failed to create runner: compression already set")
+		}
+		if encoding.GetCompressor(name) == nil {
+			return fmt.Errorf("Unknown gRPC compressor %s", name)
+		}
+		r.compressor = name
+		return nil
+	}
+}
+
+// GRPCRunnerWithKeepalive pings idle runner connections so connections silently
+// dropped by the network are detected before the next TryExec uses them. Runners
+// must permit pings at this rate, see RunnerClientConfig.PureRunnerOptions.
+func GRPCRunnerWithKeepalive(params keepalive.ClientParameters) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if params.Time <= 0 {
+			return fmt.Errorf("Invalid keepalive time %v", params.Time)
+		}
+		r.dialOpts = append(r.dialOpts, grpc.WithKeepaliveParams(params))
+		return nil
+	}
+}
+
+// GRPCRunnerWithDialOptions adds grpc dial options used to connect to the runner
+func GRPCRunnerWithDialOptions(dialOpts ...grpc.DialOption) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		r.dialOpts = append(r.dialOpts, dialOpts...)
+		return nil
+	}
+}
+
+// GRPCRunnerWithUnaryInterceptors adds client interceptors to unary runner calls such as
+// Status, eg. to add auth tokens. Interceptors run in the order they were added. Do not
+// combine with a grpc.WithUnaryInterceptor dial option, only one of them would be used.
+func GRPCRunnerWithUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		r.unaryInterceptors = append(r.unaryInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// GRPCRunnerWithStreamInterceptors adds client interceptors to runner engagements.
+// Interceptors run in the order they were added. Do not combine with a
+// grpc.WithStreamInterceptor dial option, only one of them would be used.
+func GRPCRunnerWithStreamInterceptors(interceptors ...grpc.StreamClientInterceptor) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		r.streamInterceptors = append(r.streamInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// GRPCRunnerWithExtensionsLimit limits the total size (sum of key and value lengths)
+// of call extensions sent to the runner. Keys listed in essentialKeys are never
+// dropped by the ExtensionsLimitDrop policy.
+func GRPCRunnerWithExtensionsLimit(maxSize int, policy ExtensionsLimitPolicy, essentialKeys ...string) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if maxSize <= 0 {
+			return fmt.Errorf("Invalid extensions size limit %d", maxSize)
+		}
+		r.maxExtensionsSize = maxSize
+		r.extensionsPolicy = policy
+		r.essentialExts = make(map[string]bool, len(essentialKeys))
+		for _, k := range essentialKeys {
+			r.essentialExts[k] = true
+		}
+		return nil
+	}
+}
+
+// Cordon stops the runner from accepting new calls for the given duration. TryExec
+// returns a retriable not-placed error while cordoned, calls already in flight are
+// not affected. Cordoning an already cordoned runner restarts the cordon period.
+func (r *gRPCRunner) Cordon(duration time.Duration) {
+	r.cordonMtx.Lock()
+	defer r.cordonMtx.Unlock()
+
+	if r.cordonTimer != nil {
+		r.cordonTimer.Stop()
+	} else {
+		statsRunnerCordoned(context.Background(), 1)
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		r.cordonMtx.Lock()
+		defer r.cordonMtx.Unlock()
+		// timer is assigned under cordonMtx, only lift the cordon if it is still the active one
+		if timer == r.cordonTimer {
+			r.uncordonLocked()
+		}
+	})
+	r.cordonTimer = timer
+	logrus.WithFields(logrus.Fields{"runner_addr": r.address, "duration": duration}).Info("Runner cordoned")
+}
+
+// Uncordon allows the runner to accept new calls again
+func (r *gRPCRunner) Uncordon() {
+	r.cordonMtx.Lock()
+	defer r.cordonMtx.Unlock()
+
+	if r.cordonTimer != nil {
+		r.uncordonLocked()
+	}
+}
+
+// uncordonLocked lifts the active cordon, cordonMtx must be held
+func (r *gRPCRunner) uncordonLocked() {
+	r.cordonTimer.Stop()
+	r.cordonTimer = nil
+	statsRunnerCordoned(context.Background(), -1)
+	logrus.WithField("runner_addr", r.address).Info("Runner uncordoned")
+}
+
+// Drain implements pool.DrainableRunner. Unlike a cordon, draining lasts until it is
+// lifted and makes the runner not ready, so placers do not try it at all.
+func (r *gRPCRunner) Drain(draining bool) {
+	var state int32
+	if draining {
+		state = 1
+	}
+	if atomic.SwapInt32(&r.draining, state) != state {
+		logrus.WithFields(logrus.Fields{"runner_addr": r.address, "draining": draining, "calls_in_flight": r.CallsInFlight()}).Info("Runner drain changed")
+	}
+}
+
+// IsDraining implements pool.DrainableRunner
+func (r *gRPCRunner) IsDraining() bool {
+	return atomic.LoadInt32(&r.draining) != 0
+}
+
+// CallsInFlight implements pool.DrainableRunner
+func (r *gRPCRunner) CallsInFlight() int {
+	return int(atomic.LoadInt32(&r.callsInFlight))
+}
+
+// IsCordoned returns true if the runner is currently cordoned
+func (r *gRPCRunner) IsCordoned() bool {
+	r.cordonMtx.Lock()
+	defer r.cordonMtx.Unlock()
+	return r.cordonTimer != nil
+}
+
+// IsCircuitOpen reports whether the runner's circuit breaker is open, see
+// GRPCRunnerWithCircuitBreaker
+func (r *gRPCRunner) IsCircuitOpen() bool {
+	return r.breaker.isOpen()
+}
+
+// IsEjected reports whether the runner is ejected from placement, see
+// GRPCRunnerWithEjection
+func (r *gRPCRunner) IsEjected() bool {
+	return r.ejector.isEjected()
+}
+
+// GRPCRunnerWithCallEventSink emits a CallEvent to sink for every call finished by
+// the runner. The sink is invoked from the receive path and must not block, wrap
+// it with NewBufferedCallEventSink if needed.
+func GRPCRunnerWithCallEventSink(sink CallEventSink) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if r.events != nil {
+			return errors.New("Failed to create runner: call event sink already set")
+		}
+		r.events = sink
+		return nil
+	}
+}
+
+// SchedulerWaitQuantiles returns the estimated scheduler wait (time a call waited
+// for a slot on the runner) for each quantile in qs, over the most recent calls
+// finished on this runner, along with the number of calls in the estimate.
+func (r *gRPCRunner) SchedulerWaitQuantiles(qs ...float64) ([]time.Duration, int) {
+	return r.schedWait.Quantiles(qs...)
+}
+
+// ResetSchedulerWait starts a new scheduler wait window
+func (r *gRPCRunner) ResetSchedulerWait() {
+	r.schedWait.Reset()
+}
+
+// handleCallEvent is invoked by receiveFromRunner for every finished call
+func (r *gRPCRunner) handleCallEvent(ctx context.Context, event *CallEvent) {
+	if event.SchedulerDuration != 0 {
+		r.schedWait.Record(event.SchedulerDuration)
+	}
+	if r.events != nil {
+		r.events.Emit(ctx, event)
+	}
+}
+
+// GRPCRunnerWithResponseHashVerification asks the runner to report a hash of
+// the response body and fails calls where it does not match the data received.
+// Runners that do not report a hash are unaffected.
+func GRPCRunnerWithResponseHashVerification() GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		r.verifyResponseHash = true
+		return nil
+	}
+}
+
+// GRPCRunnerWithResponseCRC32C asks the runner to report a CRC32C checksum of the
+// response body and fails calls where it does not match the data received. This is
+// cheaper than GRPCRunnerWithResponseHashVerification and catches data corruption,
+// not tampering. Runners that do not report a checksum are unaffected.
+func GRPCRunnerWithResponseCRC32C() GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		r.verifyResponseCRC32C = true
+		return nil
+	}
+}
+
+// GRPCRunnerWithExecutionTokens sends the call ID as execution token with every TryCall.
+// If sending the TryCall fails in a way that leaves it unclear whether the runner got
+// the call, it is sent to the same runner again instead of failing the call. A runner
+// engaged again with a token it accepted does not run the call again, it sends the result
+// of the call it accepted.
+func GRPCRunnerWithExecutionTokens() GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		r.executionTokens = true
+		return nil
+	}
+}
+
+// GRPCRunnerWithResponseWindow limits the response data a runner sends ahead of what
+// was written to the client to window bytes. Without it a slow HTTP client makes the
+// response pile up in gRPC buffers on both sides. The runner raises windows smaller
+// than two data frames. Runners that do not advertise ResponseWindowHeader are unaffected.
+func GRPCRunnerWithResponseWindow(window int64) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if window < 0 {
+			return fmt.Errorf("Invalid response window %d", window)
+		}
+		r.responseWindow = window
+		return nil
+	}
+}
+
+// GRPCRunnerWithLogFrames asks the runner to send what functions write to stderr
+// along with the response of engaged calls, which TryExec writes to the StdErr of
+// the call. Runners that do not implement log frames send no logs.
+func GRPCRunnerWithLogFrames() GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		r.logFrames = true
+		return nil
+	}
+}
+
+// GRPCRunnerWithCancelMessages makes TryExec tell the runner when the context of an
+// engaged call is cancelled, instead of only tearing down the engagement, so that the
+// runner aborts the call with ErrClientCancel rather than treating it as a network
+// failure. TryExec waits up to grace for the runner to finish the engagement. Calls that
+// time out are not cancelled this way, runners enforce the call deadline themselves.
+func GRPCRunnerWithCancelMessages(grace time.Duration) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if grace <= 0 {
+			return fmt.Errorf("Invalid cancel grace period %v", grace)
+		}
+		r.cancelGrace = grace
+		return nil
+	}
+}
+
+// GRPCRunnerWithDrainTimeout limits how long Close waits for calls in flight on the
+// runner to finish before closing its connections, which aborts the remaining calls.
+// TryExec places no new calls on a runner that is closing.
+func GRPCRunnerWithDrainTimeout(timeout time.Duration) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if timeout <= 0 {
+			return fmt.Errorf("Invalid drain timeout %v", timeout)
+		}
+		r.drainTimeout = timeout
+		return nil
+	}
+}
+
+// GRPCRunnerWithWeight sets the relative share of calls placers give the runner, the
+// default weight is 1
+func GRPCRunnerWithWeight(weight int) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if weight <= 0 {
+			return fmt.Errorf("Invalid runner weight %d", weight)
+		}
+		r.weight = weight
+		return nil
+	}
+}
+
+// GRPCRunnerWithLabels sets labels of the runner placers match against the placement
+// constraints of calls, eg. from a runner registry. Labels the runner advertises in its
+// capabilities are added to these, labels set here take precedence.
+func GRPCRunnerWithLabels(labels map[string]string) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		r.configLabels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			r.configLabels[k] = v
+		}
+		return nil
+	}
+}
+
+// GRPCRunnerWithMetadataFunc adds the metadata returned by fn to every Engage and
+// Status request. Request ID and trace metadata keys set by fn are ignored.
+func GRPCRunnerWithMetadataFunc(fn MetadataFunc) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if r.metadataFunc != nil {
+			return errors.New("Failed to create runner: metadata func already set")
+		}
+		r.metadataFunc = fn
+		return nil
+	}
 }
 
 // implements Runner
-func (r *gRPCRunner) Close(context.Context) error {
-	r.shutWg.CloseGroup()
-	return r.conn.Close()
+// Close stops placing calls on the runner and waits for calls in flight to finish,
+// until ctx is done or the drain timeout expires, before closing the connections.
+func (r *gRPCRunner) Close(ctx context.Context) error {
+	drained := r.shutWg.CloseGroupNB()
+	if r.drainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.drainTimeout)
+		defer cancel()
+	}
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		common.Logger(ctx).WithField("runner_addr", r.address).Warn("Closing runner connections with calls in flight")
+	}
+
+	var retErr error
+	for _, conn := range r.conns {
+		err := conn.Close()
+		// grab the first error only for now.
+		if err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+	return retErr
+}
+
+// client returns the next runner client in round robin order
+func (r *gRPCRunner) client() pb.RunnerProtocolClient {
+	idx := atomic.AddUint64(&r.nextClient, 1)
+	return r.clients[idx%uint64(len(r.clients))]
 }
 
 func NewgRPCRunner(addr string, tlsConf *tls.Config, dialOpts ...grpc.DialOption) (pool.Runner, error) {
@@ -58,21 +613,51 @@ func NewgRPCRunner(addr string, tlsConf *tls.Config, dialOpts ...grpc.DialOption
 }
 
 func NewgRPCRunnerWithTimeout(addr string, tlsConf *tls.Config, timeout time.Duration, dialOpts ...grpc.DialOption) (pool.Runner, error) {
-	conn, client, err := runnerConnection(addr, tlsConf, timeout, dialOpts...)
-	if err != nil {
-		return nil, err
+	return NewgRPCRunnerWithOptions(addr, tlsConf, GRPCRunnerWithConnectTimeout(timeout), GRPCRunnerWithDialOptions(dialOpts...))
+}
+
+// NewgRPCRunnerWithOptions creates a runner client for the pure runner at addr
+func NewgRPCRunnerWithOptions(addr string, tlsConf *tls.Config, options ...GRPCRunnerOption) (pool.Runner, error) {
+	r := &gRPCRunner{
+		shutWg:             common.NewWaitGroup(),
+		address:            addr,
+		connectTimeout:     DefaultConnectTimeout,
+		numConns:           1,
+		maxDataChunk:       MaxDataChunk,
+		readyCheckInterval: DefaultReadyCheckInterval,
+		schedWait:          newLatencyWindow(latencyWindowSize),
 	}
 
-	return &gRPCRunner{
-		shutWg:  common.NewWaitGroup(),
-		address: addr,
-		conn:    conn,
-		client:  client,
-	}, nil
+	for _, option := range options {
+		err := option(r)
+		if err != nil {
+			return nil, err
+		}
+	}
 
+	if len(r.unaryInterceptors) != 0 {
+		r.dialOpts = append(r.dialOpts, grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(r.unaryInterceptors...)))
+	}
+	if len(r.streamInterceptors) != 0 {
+		r.dialOpts = append(r.dialOpts, grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(r.streamInterceptors...)))
+	}
+
+	for i := 0; i < r.numConns; i++ {
+		conn, client, err := runnerConnection(addr, tlsConf, r.connectTimeout, r.dialBackoff, r.dialOpts...)
+		if err != nil {
+			r.Close(context.Background())
+			return nil, err
+		}
+		r.conns = append(r.conns, conn)
+		r.clients = append(r.clients, client)
+	}
+
+	r.handshakeEnabled = true
+	r.checkHandshake()
+	return r, nil
 }
 
-func runnerConnection(address string, tlsConf *tls.Config, timeout time.Duration, dialOpts ...grpc.DialOption) (*grpc.ClientConn, pb.RunnerProtocolClient, error) {
+func runnerConnection(address string, tlsConf *tls.Config, timeout time.Duration, backoff *grpcutil.BackoffConfig, dialOpts ...grpc.DialOption) (*grpc.ClientConn, pb.RunnerProtocolClient, error) {
 
 	ctx := context.Background()
 	logger := common.Logger(ctx).WithField("runner_addr", address)
@@ -84,9 +669,16 @@ func runnerConnection(address string, tlsConf *tls.Config, timeout time.Duration
 	}
 
 	// we want to set a very short timeout to fail-fast if something goes wrong
-	conn, err := grpcutil.DialWithBackoff(ctx, address, creds, timeout, grpc.DefaultBackoffConfig, dialOpts...)
+	var conn *grpc.ClientConn
+	var err error
+	if backoff != nil {
+		conn, err = grpcutil.DialWithBackoffConfig(ctx, address, creds, timeout, *backoff, dialOpts...)
+	} else {
+		conn, err = grpcutil.DialWithBackoff(ctx, address, creds, timeout, grpc.DefaultBackoffConfig, dialOpts...)
+	}
 	if err != nil {
 		logger.WithError(err).Error("Unable to connect to runner node")
+		return nil, nil, err
 	}
 
 	protocolClient := pb.NewRunnerProtocolClient(conn)
@@ -95,11 +687,106 @@ func runnerConnection(address string, tlsConf *tls.Config, timeout time.Duration
 	return conn, protocolClient, nil
 }
 
+// implements Runner
+// The runner is ready if one of its connections is ready or idle and the last Status
+// call did not fail. Status is re-checked in the background at most every
+// readyCheckInterval, so a runner that failed its Status becomes ready again once
+// its Status succeeds.
+func (r *gRPCRunner) Ready() bool {
+	if r.IsDraining() || !r.ejector.ready() {
+		return false
+	}
+	r.checkReady()
+	if atomic.LoadInt32(&r.statusState) < 0 {
+		return false
+	}
+	for _, conn := range r.conns {
+		switch conn.GetState() {
+		case connectivity.Ready, connectivity.Idle:
+			return true
+		}
+	}
+	return false
+}
+
+// checkReady starts a background Status call if none was made in readyCheckInterval
+func (r *gRPCRunner) checkReady() {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&r.lastReadyCheck)
+	if now-last < int64(r.readyCheckInterval) || !atomic.CompareAndSwapInt64(&r.lastReadyCheck, last, now) {
+		return
+	}
+	if !r.shutWg.AddSession(1) {
+		return
+	}
+	go func() {
+		defer r.shutWg.DoneSession()
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultReadyCheckTimeout)
+		defer cancel()
+		_, err := r.Status(ctx)
+		if err != nil {
+			logrus.WithError(err).WithField("runner_addr", r.address).Info("Runner ready check failed")
+		}
+	}()
+}
+
+// implements pool.ImagePullRunner
+func (r *gRPCRunner) PullImage(ctx context.Context, image string) error {
+	ctx = r.outgoingContext(ctx)
+	_, err := r.client().PullImage(ctx, &pb.PullImageRequest{Image: image})
+	if status.Code(err) == codes.Unimplemented {
+		return errRunnerPrePullUnsupported
+	}
+	if err != nil {
+		common.Logger(ctx).WithError(err).WithFields(logrus.Fields{"runner_addr": r.address, "image": image}).Info("Failed to pull image on runner")
+		return errors.New(status.Convert(err).Message())
+	}
+	return nil
+}
+
+// implements pool.ContainerProfileRunner
+func (r *gRPCRunner) ProfileContainer(ctx context.Context, fnID, typ string, seconds int) (*pool.ContainerProfile, error) {
+	ctx = r.outgoingContext(ctx)
+	resp, err := r.client().ProfileContainer(ctx, &pb.ProfileContainerRequest{FnId: fnID, Type: typ, Seconds: int32(seconds)})
+	switch status.Code(err) {
+	case codes.OK:
+		return &pool.ContainerProfile{ContainerID: resp.GetContainerId(), ContentType: resp.GetContentType(), Data: resp.GetProfile()}, nil
+	case codes.Unimplemented:
+		return nil, errRunnerProfileUnsupported
+	case codes.NotFound:
+		return nil, ErrFnContainerNotFound
+	case codes.FailedPrecondition:
+		return nil, ErrProfileUnsupported
+	case codes.ResourceExhausted:
+		return nil, ErrProfileTooBig
+	case codes.InvalidArgument:
+		return nil, models.NewAPIError(http.StatusBadRequest, errors.New(status.Convert(err).Message()))
+	}
+	common.Logger(ctx).WithError(err).WithFields(logrus.Fields{"runner_addr": r.address, "fn_id": fnID}).Info("Failed to profile container on runner")
+	return nil, models.NewAPIError(http.StatusBadGateway, errors.New(status.Convert(err).Message()))
+}
+
 // implements Runner
 func (r *gRPCRunner) Address() string {
 	return r.address
 }
 
+// implements pool.WeightedRunner
+func (r *gRPCRunner) Weight() int {
+	if r.weight <= 0 {
+		return 1
+	}
+	return r.weight
+}
+
+// implements pool.LabeledRunner
+func (r *gRPCRunner) Labels() map[string]string {
+	if labels, ok := r.labels.Load().(map[string]string); ok {
+		return labels
+	}
+	return r.configLabels
+}
+
 // isTooBusy checks if the error is a retriable error (503) that is explicitly sent
 // by runner. If isTooBusy returns true then we can idempotently run this call
 // on the same or another runner.
@@ -160,97 +847,504 @@ func TranslateGRPCStatusToRunnerStatus(status *pb.RunnerStatus) *pool.RunnerStat
 	}
 }
 
-// implements Runner
-func (r *gRPCRunner) Status(ctx context.Context) (*pool.RunnerStatus, error) {
-	log := common.Logger(ctx).WithField("runner_addr", r.address)
+// reserved metadata keys that a MetadataFunc cannot set
+var reservedMetadataKeys = []string{common.RequestIDContextKey, "grpc-trace-bin", CallDeadlineHeader, CallSlackHeader}
+
+// outgoingContext adds the request ID and any custom metadata to the gRPC metadata of ctx
+func (r *gRPCRunner) outgoingContext(ctx context.Context) context.Context {
+	var md metadata.MD
+	if r.metadataFunc != nil {
+		md = r.metadataFunc(ctx).Copy()
+		for _, key := range reservedMetadataKeys {
+			delete(md, key)
+		}
+	}
+
 	rid := common.RequestIDFromContext(ctx)
 	if rid != "" {
 		// Create a new gRPC metadata where we store the request ID
-		mp := metadata.Pairs(common.RequestIDContextKey, rid)
-		ctx = metadata.NewOutgoingContext(ctx, mp)
+		md = metadata.Join(md, metadata.Pairs(common.RequestIDContextKey, rid))
 	}
 
-	status, err := r.client.Status(ctx, &pb_empty.Empty{})
+	if len(md) == 0 {
+		return ctx
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// withCallDeadline adds the deadline of ctx, if any, to the gRPC metadata so the runner
+// can reject calls it has no time left to run
+func withCallDeadline(ctx context.Context) context.Context {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return ctx
+	}
+	slack := time.Until(deadline) / time.Millisecond
+	return metadata.AppendToOutgoingContext(ctx,
+		CallDeadlineHeader, deadline.UTC().Format(time.RFC3339Nano),
+		CallSlackHeader, strconv.FormatInt(int64(slack), 10))
+}
+
+// implements Runner
+func (r *gRPCRunner) Status(ctx context.Context) (*pool.RunnerStatus, error) {
+	if r.statusCache == nil {
+		return r.fetchStatus(ctx)
+	}
+	if pool.IsStatusRefresh(ctx) {
+		status, err := r.fetchStatus(ctx)
+		r.statusCache.set(ctx, status, err)
+		return status, err
+	}
+	status, err := r.statusCache.get(ctx, r.fetchStatus)
+	if status != nil {
+		status.IsCordoned = r.IsCordoned()
+		status.IsDraining = r.IsDraining()
+	}
+	return status, err
+}
+
+// fetchStatus calls Status on the runner
+func (r *gRPCRunner) fetchStatus(ctx context.Context) (*pool.RunnerStatus, error) {
+	log := common.Logger(ctx).WithField("runner_addr", r.address)
+	ctx = r.outgoingContext(ctx)
+
+	status, err := r.client().Status(ctx, &pb_empty.Empty{})
 	log.WithError(err).Debugf("Status Call %+v", status)
-	return TranslateGRPCStatusToRunnerStatus(status), err
+	if err != nil || status.GetFailed() {
+		atomic.StoreInt32(&r.statusState, -1)
+	} else {
+		atomic.StoreInt32(&r.statusState, 1)
+	}
+	runnerStatus := TranslateGRPCStatusToRunnerStatus(status)
+	if runnerStatus != nil {
+		runnerStatus.IsCordoned = r.IsCordoned()
+		runnerStatus.IsDraining = r.IsDraining()
+	}
+	return runnerStatus, err
+}
+
+func extensionsSize(exts map[string]string) int {
+	size := 0
+	for k, v := range exts {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+// limitExtensions enforces the configured extensions size limit, returning
+// the extensions to send to the runner
+func (r *gRPCRunner) limitExtensions(log logrus.FieldLogger, exts map[string]string) (map[string]string, error) {
+	size := extensionsSize(exts)
+	if r.maxExtensionsSize <= 0 || size <= r.maxExtensionsSize {
+		return exts, nil
+	}
+	if r.extensionsPolicy != ExtensionsLimitDrop {
+		return nil, models.ErrCallExtensionsTooBig
+	}
+
+	var droppable []string
+	for k := range exts {
+		if !r.essentialExts[k] {
+			droppable = append(droppable, k)
+		}
+	}
+	// drop largest entries first, break ties by key to remain deterministic
+	sort.Slice(droppable, func(i, j int) bool {
+		si := len(droppable[i]) + len(exts[droppable[i]])
+		sj := len(droppable[j]) + len(exts[droppable[j]])
+		if si != sj {
+			return si > sj
+		}
+		return droppable[i] < droppable[j]
+	})
+
+	var dropped []string
+	for _, k := range droppable {
+		if size <= r.maxExtensionsSize {
+			break
+		}
+		size -= len(k) + len(exts[k])
+		dropped = append(dropped, k)
+	}
+	if size > r.maxExtensionsSize {
+		return nil, models.ErrCallExtensionsTooBig
+	}
+
+	limited := make(map[string]string, len(exts)-len(dropped))
+	for k, v := range exts {
+		limited[k] = v
+	}
+	for _, k := range dropped {
+		delete(limited, k)
+	}
+	log.WithField("dropped_keys", dropped).Warn("Dropped call extensions exceeding size limit")
+	return limited, nil
 }
 
 // implements Runner
 func (r *gRPCRunner) TryExec(ctx context.Context, call pool.RunnerCall) (bool, error) {
+	return r.TryExecWithAck(ctx, call, nil)
+}
+
+// implements pool.AckRunner, the runner accepts the call with its first response
+// message unless that is a too busy NACK
+func (r *gRPCRunner) TryExecWithAck(ctx context.Context, call pool.RunnerCall, ack func() bool) (bool, error) {
+	if !r.ejector.allow() {
+		return false, ErrorRunnerEjected
+	}
+	if !r.breaker.allow() {
+		r.ejector.done(ejectionNeutral)
+		// try another runner until the breaker lets a trial call through.
+		return false, ErrorRunnerCircuitOpen
+	}
+	start := time.Now()
+	placed, err := r.tryExec(ctx, call, r.timedAck(ctx, start, ack))
+	r.breaker.done(breakerOutcomeOf(placed, err))
+	r.ejector.done(ejectionOutcomeOf(placed, err))
+	if placed {
+		statsRunnerCallLatency(ctx, r.address, time.Since(start))
+	}
+	return placed, err
+}
+
+// timedAck wraps ack to record the time from start until the runner accepts the call
+func (r *gRPCRunner) timedAck(ctx context.Context, start time.Time, ack func() bool) func() bool {
+	return func() bool {
+		if ack != nil && !ack() {
+			return false
+		}
+		statsRunnerPlacementLatency(ctx, r.address, time.Since(start))
+		return true
+	}
+}
+
+func (r *gRPCRunner) tryExec(ctx context.Context, call pool.RunnerCall, ack func() bool) (bool, error) {
 	log := common.Logger(ctx).WithField("runner_addr", r.address)
 
 	log.Debug("Attempting to place call")
+	if err := ctx.Err(); err != nil {
+		// call is already cancelled or timed out, do not bother the runner
+		return false, err
+	}
 	if !r.shutWg.AddSession(1) {
 		// try another runner if this one is closed.
 		return false, ErrorRunnerClosed
 	}
 	defer r.shutWg.DoneSession()
 
-	// extract the call's model data to pass on to the pure runner
-	modelJSON, err := json.Marshal(call.Model())
+	if r.IsCordoned() {
+		// try another runner while this one sheds load.
+		return false, ErrorRunnerCordoned
+	}
+	if r.IsDraining() {
+		return false, ErrorRunnerDraining
+	}
+	atomic.AddInt32(&r.callsInFlight, 1)
+	defer atomic.AddInt32(&r.callsInFlight, -1)
+	r.checkHandshake()
+
+	tryCall := &pb.TryCall{
+		SlotHashId:           hex.EncodeToString([]byte(call.SlotHashId())),
+		VerifyResponseHash:   r.verifyResponseHash,
+		VerifyResponseCrc32C: r.verifyResponseCRC32C,
+		ResponseWindow:       r.responseWindow,
+	}
+	if w, ok := call.ResponseWriter().(*DetachedResponseWriter); ok {
+		tryCall.DetachedResponseMax = w.maxBody
+	}
+
+	// extract the call's model data to pass on to the pure runner, in JSON unless the
+	// runner advertised it accepts the proto encoding
+	if atomic.LoadInt32(&r.callModelOK) > 0 && call.Model() != nil {
+		tryCall.Call = callModelToProto(call.Model())
+	} else {
+		modelJSON, err := json.Marshal(call.Model())
+		if err != nil {
+			log.WithError(err).Error("Failed to encode model as JSON")
+			// If we can't encode the model, no runner will ever be able to run this. Give up.
+			return true, err
+		}
+		tryCall.ModelsCallJson = string(modelJSON)
+	}
+
+	extensions, err := r.limitExtensions(log, call.Extensions())
 	if err != nil {
-		log.WithError(err).Error("Failed to encode model as JSON")
-		// If we can't encode the model, no runner will ever be able to run this. Give up.
+		log.WithError(err).Error("Call extensions are too large to send to runner")
+		// Every runner would see the same extensions, do not retry.
 		return true, err
 	}
+	tryCall.Extensions = extensions
 
-	rid := common.RequestIDFromContext(ctx)
-	if rid != "" {
-		// Create a new gRPC metadata where we store the request ID
-		mp := metadata.Pairs(common.RequestIDContextKey, rid)
-		ctx = metadata.NewOutgoingContext(ctx, mp)
+	var callOpts []grpc.CallOption
+	if r.compressor != "" && atomic.LoadInt32(&r.compressorOK) > 0 {
+		callOpts = append(callOpts, grpc.UseCompressor(r.compressor))
+	}
+
+	if r.executionTokens && call.Model() != nil {
+		tryCall.ExecutionToken = call.Model().ID
+	}
+
+	ctx = withCallDeadline(r.outgoingContext(ctx))
+	if priority := call.Priority(); priority != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, CallPriorityHeader, string(priority))
+	}
+
+	// the body of stream calls streams from the client, it cannot be sent again to another
+	// runner, so it is only sent once a runner accepted the call by starting its response
+	var accepted chan struct{}
+	if call.Model() != nil && call.Model().Type == models.TypeStream {
+		accepted = make(chan struct{})
+		ack = acceptStream(ack, accepted)
+	}
+
+	var body io.Reader
+	if r.useInvoke() && accepted == nil {
+		body = call.RequestBody()
+		prefix, small, err := readSmallBody(body, r.invokeThreshold)
+		if small {
+			placed, err := r.invoke(ctx, call, tryCall, prefix, callOpts, ack)
+			if err != errInvokeUnimplemented {
+				return placed, err
+			}
+		} else if err != nil {
+			log.WithError(err).Info("Failed to read request body for invoke")
+		}
+		body = io.MultiReader(bytes.NewReader(prefix), body)
+	}
+
+	runnerConnection, closeConnection, placed, err := r.startEngagement(ctx, call, tryCall, callOpts)
+	if err != nil && placed && tryCall.ExecutionToken != "" {
+		// The runner may or may not have received the call. Only this runner knows the
+		// token, so ask it again: it attaches to the call if it already accepted it. The
+		// call is never tried on another runner, which could run it a second time.
+		log.WithError(err).Info("Retrying engagement with execution token")
+		runnerConnection, closeConnection, _, err = r.startEngagement(ctx, call, tryCall, callOpts)
+	}
+	if err != nil {
+		return placed, err
+	}
+	defer closeConnection()
+
+	// IMPORTANT: After this point TryCall was sent, we assume "COMMITTED" unless pure runner
+	// send explicit NACK. Remember that requests may have no body and TryCall can contain all
+	// data to execute a request.
+
+	if body == nil {
+		body = call.RequestBody()
+	}
+	if r.responseWindow > 0 || r.cancelGrace > 0 {
+		// acks and cancel messages are sent while the request body may still be streaming
+		runnerConnection = &syncSendEngageClient{RunnerProtocol_EngageClient: runnerConnection}
+	}
+	recvDone := make(chan error, 1)
+
+	go receiveFromRunner(ctx, runnerConnection, r.address, call, receiveOptions{
+		onFinish:             r.handleCallEvent,
+		onAccept:             ack,
+		verifyResponseHash:   r.verifyResponseHash,
+		verifyResponseCRC32C: r.verifyResponseCRC32C,
+		responseWindow:       r.responseWindow,
+		strictMessages:       atomic.LoadInt32(&r.capsState) > 0,
+	}, recvDone)
+	sendCtx, cancelSend := context.WithCancel(ctx)
+	defer cancelSend()
+	go func() {
+		if accepted != nil {
+			select {
+			case <-accepted:
+			case <-sendCtx.Done():
+				return
+			}
+		}
+		sendToRunner(ctx, runnerConnection, r.address, body, r.dataChunkSize(ctx, runnerConnection))
+	}()
+
+	select {
+	case <-ctx.Done():
+		log.Infof("Engagement Context ended ctxErr=%v", ctx.Err())
+		if r.cancelGrace > 0 && ctx.Err() == context.Canceled {
+			r.cancelEngagement(log, runnerConnection, recvDone)
+		}
+		return true, ctx.Err()
+	case recvErr := <-recvDone:
+		return r.placementResult(ctx, recvErr)
 	}
-	runnerConnection, err := r.client.Engage(ctx)
+}
+
+// placementResult returns whether the call was placed given the error received from the runner
+func (r *gRPCRunner) placementResult(ctx context.Context, recvErr error) (bool, error) {
+	if isTooBusy(recvErr) {
+		statsRunnerStreamError(ctx, r.address, runnerErrorTooBusy)
+		// Try on next runner, passing on why the runner was too busy if it told us
+		if busyErr, ok := recvErr.(*pool.RunnerBusyError); ok {
+			return false, busyErr
+		}
+		return false, models.ErrCallTimeoutServerBusy
+	}
+	if recvErr == pool.ErrHedgeLost {
+		return false, recvErr
+	}
+	return true, recvErr
+}
+
+// cancelEngagement tells the runner the call was cancelled and waits up to cancelGrace
+// for it to finish the engagement
+func (r *gRPCRunner) cancelEngagement(log logrus.FieldLogger, runnerConnection pb.RunnerProtocol_EngageClient, recvDone chan error) {
+	err := runnerConnection.Send(&pb.ClientMsg{Body: &pb.ClientMsg_Cancel{Cancel: &pb.CallCancel{Reason: context.Canceled.Error()}}})
 	if err != nil {
+		log.WithError(err).Info("Failed to send cancel to runner node")
+		return
+	}
+
+	timer := time.NewTimer(r.cancelGrace)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-recvDone:
+			if !ok {
+				return
+			}
+		case <-timer.C:
+			log.Info("Runner did not finish cancelled call in time")
+			return
+		}
+	}
+}
+
+// valueOnlyContext has the values of its parent context, but not its deadline or cancellation
+type valueOnlyContext struct {
+	context.Context
+}
+
+func (valueOnlyContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valueOnlyContext) Done() <-chan struct{}       { return nil }
+func (valueOnlyContext) Err() error                  { return nil }
+
+// startEngagement engages the runner and sends it the TryCall. On failure it returns
+// whether the runner may have received the call anyway. On success the returned func
+// must be called once the engagement is over.
+func (r *gRPCRunner) startEngagement(ctx context.Context, call pool.RunnerCall, tryCall *pb.TryCall, callOpts []grpc.CallOption) (pb.RunnerProtocol_EngageClient, func(), bool, error) {
+	log := common.Logger(ctx).WithField("runner_addr", r.address)
+
+	if r.logFrames {
+		ctx = metadata.AppendToOutgoingContext(ctx, LogFramesHeader, "1")
+	}
+	engageCtx, cancelEngage := ctx, func() {}
+	if r.cancelGrace > 0 {
+		// the engagement must outlive ctx to tell the runner that ctx was cancelled
+		engageCtx, cancelEngage = context.WithCancel(valueOnlyContext{ctx})
+	}
+	runnerConnection, err := r.client().Engage(engageCtx, callOpts...)
+	if err != nil {
+		cancelEngage()
 		// We are going to retry on a different runner, it is ok to log this error as Info
 		log.WithError(err).Info("Unable to create client to runner node")
+		statsRunnerStreamError(ctx, r.address, runnerErrorDial)
 		// Try on next runner
-		return false, err
+		return nil, nil, false, err
 	}
 
-	err = runnerConnection.Send(&pb.ClientMsg{Body: &pb.ClientMsg_Try{Try: &pb.TryCall{
-		ModelsCallJson: string(modelJSON),
-		SlotHashId:     hex.EncodeToString([]byte(call.SlotHashId())),
-		Extensions:     call.Extensions(),
-	}}})
+	closeConnection := cancelEngage
+	if r.recording != nil {
+		rec := newRecordingEngageClient(ctx, runnerConnection, r.recording, r.address, call)
+		closeConnection = func() {
+			rec.Close()
+			cancelEngage()
+		}
+		runnerConnection = rec
+	}
+
+	err = runnerConnection.Send(&pb.ClientMsg{Body: &pb.ClientMsg_Try{Try: tryCall}})
 	if err != nil {
+		closeConnection()
 		// We are going to retry on a different runner, it is ok to log this error as Info
 		log.WithError(err).Info("Failed to send message to runner node")
+		statsRunnerStreamError(ctx, r.address, runnerErrorSend)
 		// Let's ensure this is a codes.Unavailable error, otherwise we should
 		// not assume that no data was transferred to the server. If the error is
 		// retriable, then we can bubble up "not placed" to the caller to enable
 		// a retry on this or different runner.
 		isRetriable := status.Code(err) == codes.Unavailable
-		return !isRetriable, err
+		return nil, nil, !isRetriable, err
 	}
+	return runnerConnection, closeConnection, true, nil
+}
 
-	// IMPORTANT: After this point TryCall was sent, we assume "COMMITTED" unless pure runner
-	// send explicit NACK. Remember that requests may have no body and TryCall can contain all
-	// data to execute a request.
+// dataChunkSize returns the data frame size to use for an engagement, the smaller of
+// the local max data chunk and the one advertised by the runner in its engagement
+// header. If we have never heard from this runner, we wait briefly for the header,
+// otherwise the last advertised value is used and refreshed in the background.
+// The compressors advertised in the same header are recorded for later engagements.
+func (r *gRPCRunner) dataChunkSize(ctx context.Context, protocolClient pb.RunnerProtocol_EngageClient) int {
+	advertised := make(chan int64, 1)
+	go func() {
+		md, err := protocolClient.Header()
+		if err != nil {
+			return
+		}
+		r.updateCompression(md)
+		r.updateCallModel(md)
+		size := int64(-1)
+		if vals := md.Get(MaxDataChunkHeader); len(vals) > 0 {
+			v, err := strconv.ParseInt(vals[0], 10, 64)
+			if err == nil && v > 0 {
+				size = v
+			}
+		}
+		atomic.StoreInt64(&r.advertisedChunk, size)
+		advertised <- size
+	}()
 
-	recvDone := make(chan error, 1)
+	size := atomic.LoadInt64(&r.advertisedChunk)
+	if size == 0 {
+		select {
+		case size = <-advertised:
+		case <-time.After(DefaultHandshakeTimeout):
+			// older runners do not advertise, do not wait for them again
+			atomic.CompareAndSwapInt64(&r.advertisedChunk, 0, -1)
+		case <-ctx.Done():
+		}
+	}
 
-	go receiveFromRunner(ctx, runnerConnection, r.address, call, recvDone)
-	go sendToRunner(ctx, runnerConnection, r.address, call)
+	if size > 0 && size < int64(r.maxDataChunk) {
+		return int(size)
+	}
+	return r.maxDataChunk
+}
 
-	select {
-	case <-ctx.Done():
-		log.Infof("Engagement Context ended ctxErr=%v", ctx.Err())
-		return true, ctx.Err()
-	case recvErr := <-recvDone:
-		if isTooBusy(recvErr) {
-			// Try on next runner
-			return false, models.ErrCallTimeoutServerBusy
+// updateCompression records whether the runner accepts our compressor
+func (r *gRPCRunner) updateCompression(md metadata.MD) {
+	if r.compressor == "" {
+		return
+	}
+	accepted := int32(-1)
+	for _, vals := range md.Get(CompressorsHeader) {
+		for _, name := range strings.Split(vals, ",") {
+			if strings.TrimSpace(name) == r.compressor {
+				accepted = 1
+			}
+		}
+	}
+	atomic.StoreInt32(&r.compressorOK, accepted)
+}
+
+// updateCallModel records whether the runner accepts the proto encoding of the call model
+func (r *gRPCRunner) updateCallModel(md metadata.MD) {
+	accepted := int32(-1)
+	for _, vals := range md.Get(CallModelHeader) {
+		for _, name := range strings.Split(vals, ",") {
+			if strings.TrimSpace(name) == callModelProto {
+				accepted = 1
+			}
 		}
-		return true, recvErr
 	}
+	atomic.StoreInt32(&r.callModelOK, accepted)
 }
 
-func sendToRunner(ctx context.Context, protocolClient pb.RunnerProtocol_EngageClient, runnerAddress string, call pool.RunnerCall) {
+func sendToRunner(ctx context.Context, protocolClient pb.RunnerProtocol_EngageClient, runnerAddress string, bodyReader io.Reader, maxDataChunk int) {
 	var errorMsg string
 	var infoMsg string
-	bodyReader := call.RequestBody()
-	writeBuffer := make([]byte, MaxDataChunk)
+	writeBuffer := make([]byte, maxDataChunk)
 	_, span := trace.StartSpan(ctx, "send_to_runner", trace.WithSpanKind(trace.SpanKindClient))
 	defer span.End()
 	log := common.Logger(ctx).WithField("runner_addr", runnerAddress)
@@ -292,6 +1386,7 @@ func sendToRunner(ctx context.Context, protocolClient pb.RunnerProtocol_EngageCl
 				errorMsg = fmt.Sprintf("Failed to send data frame size=%d isEOF=%v", n, isEOF)
 				span.SetStatus(trace.Status{Code: int32(trace.StatusCodeDataLoss), Message: errorMsg})
 				log.WithError(sendErr).Errorf(errorMsg)
+				statsRunnerStreamError(ctx, runnerAddress, runnerErrorSend)
 			}
 			return
 		}
@@ -301,18 +1396,35 @@ func sendToRunner(ctx context.Context, protocolClient pb.RunnerProtocol_EngageCl
 	}
 }
 
+// acceptStream wraps the ack of a stream call to close accepted once the call is accepted
+func acceptStream(ack func() bool, accepted chan struct{}) func() bool {
+	return func() bool {
+		if ack != nil && !ack() {
+			return false
+		}
+		close(accepted)
+		return true
+	}
+}
+
 func parseError(msg *pb.CallFinished) error {
 	if msg.GetSuccess() {
 		return nil
 	}
+	if st := msg.GetErrorStatus(); st != nil {
+		return callErrorFromStatus(msg, st)
+	}
 	eCode := msg.GetErrorCode()
 	eStr := msg.GetErrorStr()
 	if eStr == "" {
 		eStr = "Unknown Error From Pure Runner"
 	}
+	if reason := msg.GetRejectReason(); reason != "" && int(eCode) == models.ErrCallTimeoutServerBusy.Code() {
+		return &pool.RunnerBusyError{Reason: pool.RejectReason(reason)}
+	}
 	err := models.NewAPIError(int(eCode), errors.New(eStr))
 	if msg.GetErrorUser() {
-		return models.NewFuncError(err)
+		return newFuncError(msg, err)
 	}
 	return err
 }
@@ -359,7 +1471,27 @@ func cloneHeaders(src http.Header) http.Header {
 	return dst
 }
 
-func receiveFromRunner(ctx context.Context, protocolClient pb.RunnerProtocol_EngageClient, runnerAddress string, c pool.RunnerCall, done chan error) {
+// streamingContentTypes are the content types of responses that fns stream as they
+// produce them, eg. tokens of a model as server-sent events or newline delimited json, or
+// the messages of gRPC methods
+var streamingContentTypes = []string{"text/event-stream", "application/x-ndjson", "application/grpc"}
+
+// IsStreamingContentType reports whether a response with the given content type
+// should be flushed to the client after every write (eg. server-sent events), rather
+// than buffered until the fn is done
+func IsStreamingContentType(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	for _, t := range streamingContentTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func receiveFromRunner(ctx context.Context, protocolClient pb.RunnerProtocol_EngageClient, runnerAddress string, c pool.RunnerCall, opts receiveOptions, done chan error) {
+	engaged := time.Now()
+	var respBytes int64
 	var errorMsg string
 	var infoMsg string
 	w := c.ResponseWriter()
@@ -369,18 +1501,48 @@ func receiveFromRunner(ctx context.Context, protocolClient pb.RunnerProtocol_Eng
 	log := common.Logger(ctx).WithField("runner_addr", runnerAddress)
 	statusCode := int32(0)
 	// Make a copy of header to avoid concurrent read/write error when logCallFinish runs.
-	clonedHeaders := cloneHeaders(w.Header())
+	// Taken once the first message shows the runner accepted the call, see acceptCall.
+	var clonedHeaders http.Header
 	isPartialWrite := false
+	// set once result start advertises a streaming content type, see IsStreamingContentType
+	var flusher http.Flusher
+	var respHash hash.Hash
+	if opts.verifyResponseHash {
+		respHash = sha256.New()
+	}
+	var respCRC hash.Hash
+	if opts.verifyResponseCRC32C {
+		respCRC = crc32.New(crc32cTable)
+	}
+	// response bytes last acknowledged, -1 if the runner does not honor the window
+	var ackedBytes int64
+	if opts.responseWindow <= 0 {
+		ackedBytes = -1
+	}
 
 DataLoop:
 	for {
 		msg, err := protocolClient.Recv()
 		if err != nil {
 			log.WithError(err).Info("Receive error from runner")
+			statsRecvError(ctx, runnerAddress)
 			tryQueueError(err, done)
 			return
 		}
 
+		if clonedHeaders == nil {
+			if ackedBytes == 0 && !advertisesResponseWindow(protocolClient) {
+				ackedBytes = -1
+			}
+			var ok bool
+			clonedHeaders, ok = acceptCall(msg, w, opts)
+			if !ok {
+				log.Debug("Call was accepted by another runner, abandoning engagement")
+				tryQueueError(pool.ErrHedgeLost, done)
+				return
+			}
+		}
+
 		switch body := msg.Body.(type) {
 
 		// Process HTTP header/status message. This may not arrive depending on
@@ -395,6 +1557,9 @@ DataLoop:
 					clonedHeaders.Add(header.Key, header.Value)
 					w.Header().Add(header.Key, header.Value)
 				}
+				if IsStreamingContentType(w.Header().Get("Content-Type")) {
+					flusher, _ = w.(http.Flusher)
+				}
 				if meta.Http.StatusCode > 0 {
 					statusCode = meta.Http.StatusCode
 					w.WriteHeader(int(meta.Http.StatusCode))
@@ -410,18 +1575,47 @@ DataLoop:
 			infoMsg = fmt.Sprintf("Received data from runner len=%d isEOF=%v", len(body.Data.Data), body.Data.Eof)
 			span.Annotate([]trace.Attribute{trace.StringAttribute("status", infoMsg)}, "")
 			log.Debugf(infoMsg)
+			if respHash != nil {
+				respHash.Write(body.Data.Data)
+			}
+			if respCRC != nil {
+				respCRC.Write(body.Data.Data)
+			}
 			if !isPartialWrite {
 				// WARNING: blocking write
 				n, err := w.Write(body.Data.Data)
+				respBytes += int64(n)
 				if n != len(body.Data.Data) {
 					isPartialWrite = true
 					errorMsg = fmt.Sprintf("Failed to write full response (%d of %d) to client", n, len(body.Data.Data))
 					span.SetStatus(trace.Status{Code: int32(trace.StatusCodeDataLoss), Message: errorMsg})
 					log.WithError(err).Infof(errorMsg)
+					statsRunnerStreamError(ctx, runnerAddress, runnerErrorPartialWrite)
 					if err == nil {
 						err = io.ErrShortWrite
 					}
 					tryQueueError(err, done)
+				} else if flusher != nil {
+					flusher.Flush()
+				}
+				// acknowledge every half window so the runner rarely waits for us
+				if ackedBytes >= 0 && !isPartialWrite && respBytes-ackedBytes >= opts.responseWindow/2 {
+					err := protocolClient.Send(&pb.ClientMsg{Body: &pb.ClientMsg_Ack{Ack: &pb.DataAck{Bytes: respBytes}}})
+					if err != nil {
+						log.WithError(err).Info("Failed to acknowledge response data, disabling acks")
+						ackedBytes = -1
+					} else {
+						ackedBytes = respBytes
+					}
+				}
+			}
+
+		// May arrive if function logs were requested, see GRPCRunnerWithLogFrames
+		case *pb.RunnerMsg_Log:
+			if stderr := c.StdErr(); stderr != nil {
+				_, err := stderr.Write(body.Log.Data)
+				if err != nil {
+					log.WithError(err).Debug("Failed to write function logs")
 				}
 			}
 
@@ -429,6 +1623,9 @@ DataLoop:
 		case *pb.RunnerMsg_Finished:
 			logCallFinish(log, body, clonedHeaders, statusCode)
 			recordFinishStats(ctx, body.Finished, c)
+			if opts.onFinish != nil {
+				opts.onFinish(ctx, newCallEvent(body.Finished, c, runnerAddress, statusCode, engaged, respBytes))
+			}
 			span.Annotate([]trace.Attribute{
 				trace.BoolAttribute("error_user", body.Finished.GetErrorUser()),
 				trace.BoolAttribute("success", body.Finished.GetSuccess()),
@@ -447,13 +1644,31 @@ DataLoop:
 				trace.StringAttribute("fn.call_id", body.Finished.GetDetails()),
 			)
 			span.SetStatus(trace.Status{Code: body.Finished.GetErrorCode(), Message: body.Finished.GetErrorStr()})
+			if resp := body.Finished.GetDetachedResponse(); resp != nil {
+				if dw, ok := w.(*DetachedResponseWriter); ok {
+					dw.setResponse(resp)
+				}
+			}
 			if !body.Finished.Success {
 				err := parseError(body.Finished)
 				tryQueueError(err, done)
+			} else if !responseSumMatches(respHash, body.Finished.ResponseSha256) || !responseSumMatches(respCRC, body.Finished.ResponseCrc32C) {
+				errorMsg = "Response hash or checksum reported by runner does not match response received"
+				span.SetStatus(trace.Status{Code: int32(trace.StatusCodeDataLoss), Message: errorMsg})
+				log.Errorf(errorMsg)
+				statsResponseHashMismatch(ctx)
+				tryQueueError(models.ErrResponseHashMismatch, done)
 			}
 			break DataLoop
 
 		default:
+			if opts.strictMessages {
+				errorMsg = fmt.Sprintf("Unexpected message type %T from runner", body)
+				span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: errorMsg})
+				log.Errorf(errorMsg)
+				tryQueueError(ErrorRunnerProtocol, done)
+				return
+			}
 			errorMsg = fmt.Sprintf("Ignoring unknown message type %T from runner, possible client/server mismatch", body)
 			span.SetStatus(trace.Status{Code: trace.StatusCodeUnauthenticated, Message: errorMsg})
 			log.Errorf(errorMsg)
@@ -468,6 +1683,7 @@ DataLoop:
 		}
 		if err != nil {
 			log.WithError(err).Infof("Call Waiting EOF received error")
+			statsRecvError(ctx, runnerAddress)
 			tryQueueError(err, done)
 			break
 		}
@@ -476,10 +1692,59 @@ DataLoop:
 		default:
 			log.Infof("Call Waiting EOF ignoring message %T", body)
 		}
+		statsRunnerStreamError(ctx, runnerAddress, runnerErrorNoEOF)
 		tryQueueError(ErrorPureRunnerNoEOF, done)
 	}
 }
 
+// advertisesResponseWindow reports whether the runner of the engagement honors TryCall.ResponseWindow
+func advertisesResponseWindow(protocolClient pb.RunnerProtocol_EngageClient) bool {
+	md, err := protocolClient.Header()
+	return err == nil && len(md.Get(ResponseWindowHeader)) > 0
+}
+
+// syncSendEngageClient serializes Send, which gRPC streams do not allow concurrently
+type syncSendEngageClient struct {
+	pb.RunnerProtocol_EngageClient
+	mtx sync.Mutex
+}
+
+func (c *syncSendEngageClient) Send(msg *pb.ClientMsg) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.RunnerProtocol_EngageClient.Send(msg)
+}
+
+// statsRecvError records a receive error unless the engagement was cancelled on our side
+func statsRecvError(ctx context.Context, runnerAddress string) {
+	if ctx.Err() == nil {
+		statsRunnerStreamError(ctx, runnerAddress, runnerErrorRecv)
+	}
+}
+
+// acceptCall is invoked with the first message from the runner and returns the call
+// headers to log. It returns false if receiveOptions.onAccept rejects the call, in
+// which case another runner is writing to the call and it must not be touched.
+func acceptCall(msg *pb.RunnerMsg, w http.ResponseWriter, opts receiveOptions) (http.Header, bool) {
+	if opts.onAccept == nil {
+		return cloneHeaders(w.Header()), true
+	}
+	if fin := msg.GetFinished(); fin != nil && isTooBusy(parseError(fin)) {
+		// a NACK does not accept the call, another runner may be writing to it
+		return http.Header{}, true
+	}
+	if !opts.onAccept() {
+		return nil, false
+	}
+	return cloneHeaders(w.Header()), true
+}
+
+// responseSumMatches reports whether the hash of the received response matches the
+// one reported by the runner. It matches if either was not computed.
+func responseSumMatches(h hash.Hash, reported []byte) bool {
+	return h == nil || len(reported) == 0 || bytes.Equal(h.Sum(nil), reported)
+}
+
 func logCallFinish(log logrus.FieldLogger, msg *pb.RunnerMsg_Finished, headers http.Header, httpStatus int32) {
 
 	fin := msg.Finished
@@ -524,3 +1789,8 @@ func logCallFinish(log logrus.FieldLogger, msg *pb.RunnerMsg_Finished, headers h
 }
 
 var _ pool.Runner = &gRPCRunner{}
+var _ pool.WeightedRunner = &gRPCRunner{}
+var _ pool.DrainableRunner = &gRPCRunner{}
+var _ pool.LabeledRunner = &gRPCRunner{}
+var _ pool.ImagePullRunner = &gRPCRunner{}
+var _ pool.ContainerProfileRunner = &gRPCRunner{}
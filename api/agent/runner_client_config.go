@@ -0,0 +1,356 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/fnproject/fn/grpcutil"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// RunnerClientConfig holds the tunables for the gRPC streams between the LB agent
+// and pure runners. The same settings are applied on both sides, the LB uses them
+// for its runner clients and the pure runner for its gRPC server.
+type RunnerClientConfig struct {
+	Connections           uint64 `json:"runner_connections"`
+	MaxDataChunk          uint64 `json:"runner_max_data_chunk"`
+	InitialWindowSize     uint64 `json:"runner_grpc_window_size"`
+	InitialConnWindowSize uint64 `json:"runner_grpc_conn_window_size"`
+	Compression           string `json:"runner_compression"`
+
+	KeepaliveTime                time.Duration `json:"runner_keepalive_time"`
+	KeepaliveTimeout             time.Duration `json:"runner_keepalive_timeout"`
+	KeepalivePermitWithoutStream bool          `json:"runner_keepalive_permit_without_stream"`
+
+	MinDeadlineSlack time.Duration `json:"runner_min_deadline_slack"`
+
+	CircuitBreakerThreshold  uint64        `json:"runner_circuit_breaker_threshold"`
+	CircuitBreakerBackoff    time.Duration `json:"runner_circuit_breaker_backoff"`
+	CircuitBreakerMaxBackoff time.Duration `json:"runner_circuit_breaker_max_backoff"`
+
+	EjectionThreshold  uint64        `json:"runner_ejection_threshold"`
+	EjectionPenalty    time.Duration `json:"runner_ejection_penalty"`
+	EjectionMaxPenalty time.Duration `json:"runner_ejection_max_penalty"`
+	EjectionCanaries   uint64        `json:"runner_ejection_canaries"`
+
+	ResponseChecksum string `json:"runner_response_checksum"`
+	ExecutionTokens  bool   `json:"runner_execution_tokens"`
+	InvokeThreshold  uint64 `json:"runner_invoke_threshold"`
+	ResponseWindow   uint64 `json:"runner_response_window"`
+
+	StatusCacheTTL    time.Duration `json:"runner_status_cache_ttl"`
+	StatusCacheJitter time.Duration `json:"runner_status_cache_jitter"`
+
+	LogFrames   bool          `json:"runner_log_frames"`
+	CancelGrace time.Duration `json:"runner_cancel_grace"`
+
+	DrainTimeout          time.Duration `json:"runner_drain_timeout"`
+	DialTimeout           time.Duration `json:"runner_dial_timeout"`
+	DialBackoffBase       time.Duration `json:"runner_dial_backoff_base"`
+	DialBackoffMultiplier float64       `json:"runner_dial_backoff_multiplier"`
+	DialBackoffMax        time.Duration `json:"runner_dial_backoff_max"`
+}
+
+const (
+	// EnvRunnerConnections is the number of gRPC connections the LB opens to each runner
+	EnvRunnerConnections = "FN_RUNNER_CONNECTIONS"
+	// EnvRunnerMaxDataChunk is the largest data frame sent between LB and runner, in bytes
+	EnvRunnerMaxDataChunk = "FN_RUNNER_MAX_DATA_CHUNK"
+	// EnvRunnerGRPCWindowSize is the initial gRPC per stream window size, in bytes
+	EnvRunnerGRPCWindowSize = "FN_RUNNER_GRPC_WINDOW_SIZE"
+	// EnvRunnerGRPCConnWindowSize is the initial gRPC per connection window size, in bytes
+	EnvRunnerGRPCConnWindowSize = "FN_RUNNER_GRPC_CONN_WINDOW_SIZE"
+	// EnvRunnerCompression is the gRPC compressor the LB uses for runner streams, e.g. gzip
+	EnvRunnerCompression = "FN_RUNNER_COMPRESSION"
+	// EnvRunnerKeepaliveTime is the idle time after which the LB pings a runner connection,
+	// zero or negative disables keepalive. Runners accept pings at this interval.
+	EnvRunnerKeepaliveTime = "FN_RUNNER_KEEPALIVE_TIME_MSECS"
+	// EnvRunnerKeepaliveTimeout is how long the LB waits for a ping ack before closing the connection
+	EnvRunnerKeepaliveTimeout = "FN_RUNNER_KEEPALIVE_TIMEOUT_MSECS"
+	// EnvRunnerKeepalivePermitWithoutStream enables pings on connections without active engagements
+	EnvRunnerKeepalivePermitWithoutStream = "FN_RUNNER_KEEPALIVE_PERMIT_WITHOUT_STREAM"
+	// EnvRunnerMinDeadlineSlack is the least time a call must have left until its deadline
+	// for a runner to accept it
+	EnvRunnerMinDeadlineSlack = "FN_RUNNER_MIN_DEADLINE_SLACK_MSECS"
+	// EnvRunnerCircuitBreakerThreshold is the number of consecutive transport errors or too busy
+	// NACKs after which the LB stops using a runner for a while, zero disables the breaker
+	EnvRunnerCircuitBreakerThreshold = "FN_RUNNER_CIRCUIT_BREAKER_THRESHOLD"
+	// EnvRunnerCircuitBreakerBackoff is how long a runner is skipped once its breaker opens
+	EnvRunnerCircuitBreakerBackoff = "FN_RUNNER_CIRCUIT_BREAKER_BACKOFF_MSECS"
+	// EnvRunnerCircuitBreakerMaxBackoff caps the backoff, which doubles on every failed trial call
+	EnvRunnerCircuitBreakerMaxBackoff = "FN_RUNNER_CIRCUIT_BREAKER_MAX_BACKOFF_MSECS"
+	// EnvRunnerEjectionThreshold is the number of consecutive placement or transport failures
+	// after which the LB ejects a runner from placement, zero disables ejection
+	EnvRunnerEjectionThreshold = "FN_RUNNER_EJECTION_THRESHOLD"
+	// EnvRunnerEjectionPenalty is how long a runner is ejected the first time
+	EnvRunnerEjectionPenalty = "FN_RUNNER_EJECTION_PENALTY_MSECS"
+	// EnvRunnerEjectionMaxPenalty caps the penalty, which doubles on every recent ejection
+	EnvRunnerEjectionMaxPenalty = "FN_RUNNER_EJECTION_MAX_PENALTY_MSECS"
+	// EnvRunnerEjectionCanaries is the number of successful canary calls after which an
+	// ejected runner is reinstated
+	EnvRunnerEjectionCanaries = "FN_RUNNER_EJECTION_CANARIES"
+	// EnvRunnerResponseChecksum makes the LB verify response bodies with a checksum reported by
+	// the runner, either crc32c or sha256
+	EnvRunnerResponseChecksum = "FN_RUNNER_RESPONSE_CHECKSUM"
+	// EnvRunnerExecutionTokens makes the LB send execution tokens, so that engagements
+	// failing before the runner confirmed the call can be retried on the same runner
+	EnvRunnerExecutionTokens = "FN_RUNNER_EXECUTION_TOKENS"
+	// EnvRunnerInvokeThreshold is the largest request body, in bytes, of calls the LB runs with
+	// a single Invoke request rather than an engagement stream, zero disables Invoke
+	EnvRunnerInvokeThreshold = "FN_RUNNER_INVOKE_THRESHOLD"
+	// EnvRunnerResponseWindow is the response data, in bytes, a runner may send ahead of
+	// what the LB wrote to the client, zero disables the limit
+	EnvRunnerResponseWindow = "FN_RUNNER_RESPONSE_WINDOW"
+	// EnvRunnerStatusCacheTTL is how long the LB reuses the result of a runner Status call,
+	// zero disables the cache
+	EnvRunnerStatusCacheTTL = "FN_RUNNER_STATUS_CACHE_TTL_MSECS"
+	// EnvRunnerStatusCacheJitter is the largest random time added to the status cache TTL
+	EnvRunnerStatusCacheJitter = "FN_RUNNER_STATUS_CACHE_JITTER_MSECS"
+	// EnvRunnerLogFrames makes the LB ask runners for the function logs of calls
+	EnvRunnerLogFrames = "FN_RUNNER_LOG_FRAMES"
+	// EnvRunnerCancelGrace is how long the LB waits for a runner to abort a cancelled call,
+	// zero disables cancel messages
+	EnvRunnerCancelGrace = "FN_RUNNER_CANCEL_GRACE_MSECS"
+	// EnvRunnerDrainTimeout is how long the LB waits for calls in flight on a runner when
+	// closing it, by default it waits as long as the shutdown allows
+	EnvRunnerDrainTimeout = "FN_RUNNER_DRAIN_TIMEOUT_MSECS"
+	// EnvRunnerDialTimeout is the timeout of a single attempt to connect to a runner
+	EnvRunnerDialTimeout = "FN_RUNNER_DIAL_TIMEOUT_MSECS"
+	// EnvRunnerDialBackoffBase is the delay before reconnecting to a runner after the first failed attempt
+	EnvRunnerDialBackoffBase = "FN_RUNNER_DIAL_BACKOFF_BASE_MSECS"
+	// EnvRunnerDialBackoffMultiplier is applied to the reconnect delay after every further failed attempt
+	EnvRunnerDialBackoffMultiplier = "FN_RUNNER_DIAL_BACKOFF_MULTIPLIER"
+	// EnvRunnerDialBackoffMax is the largest delay before reconnecting to a runner
+	EnvRunnerDialBackoffMax = "FN_RUNNER_DIAL_BACKOFF_MAX_MSECS"
+
+	// minGRPCWindowSize is the smallest window gRPC accepts, smaller values are ignored
+	minGRPCWindowSize = 64 * 1024
+)
+
+// NewRunnerClientConfig returns the runner client configuration from the environment.
+// Window sizes left at zero keep the gRPC defaults.
+func NewRunnerClientConfig() (*RunnerClientConfig, error) {
+	cfg := &RunnerClientConfig{}
+
+	defaultConnections := uint64(1)
+	defaultMaxDataChunk := uint64(MaxDataChunk)
+	defaultEjectionCanaries := uint64(3)
+
+	var err error
+	err = setEnvUint(err, EnvRunnerConnections, &cfg.Connections, &defaultConnections)
+	err = setEnvUint(err, EnvRunnerMaxDataChunk, &cfg.MaxDataChunk, &defaultMaxDataChunk)
+	err = setEnvUint(err, EnvRunnerGRPCWindowSize, &cfg.InitialWindowSize, nil)
+	err = setEnvUint(err, EnvRunnerGRPCConnWindowSize, &cfg.InitialConnWindowSize, nil)
+	err = setEnvStr(err, EnvRunnerCompression, &cfg.Compression)
+	err = setEnvMsecs(err, EnvRunnerKeepaliveTime, &cfg.KeepaliveTime, 0)
+	err = setEnvMsecs(err, EnvRunnerKeepaliveTimeout, &cfg.KeepaliveTimeout, 20*time.Second)
+	err = setEnvBool(err, EnvRunnerKeepalivePermitWithoutStream, &cfg.KeepalivePermitWithoutStream)
+	err = setEnvMsecs(err, EnvRunnerMinDeadlineSlack, &cfg.MinDeadlineSlack, 0)
+	err = setEnvUint(err, EnvRunnerCircuitBreakerThreshold, &cfg.CircuitBreakerThreshold, nil)
+	err = setEnvMsecs(err, EnvRunnerCircuitBreakerBackoff, &cfg.CircuitBreakerBackoff, time.Second)
+	err = setEnvMsecs(err, EnvRunnerCircuitBreakerMaxBackoff, &cfg.CircuitBreakerMaxBackoff, 30*time.Second)
+	err = setEnvUint(err, EnvRunnerEjectionThreshold, &cfg.EjectionThreshold, nil)
+	err = setEnvMsecs(err, EnvRunnerEjectionPenalty, &cfg.EjectionPenalty, time.Second)
+	err = setEnvMsecs(err, EnvRunnerEjectionMaxPenalty, &cfg.EjectionMaxPenalty, 5*time.Minute)
+	err = setEnvUint(err, EnvRunnerEjectionCanaries, &cfg.EjectionCanaries, &defaultEjectionCanaries)
+	err = setEnvStr(err, EnvRunnerResponseChecksum, &cfg.ResponseChecksum)
+	err = setEnvBool(err, EnvRunnerExecutionTokens, &cfg.ExecutionTokens)
+	err = setEnvUint(err, EnvRunnerInvokeThreshold, &cfg.InvokeThreshold, nil)
+	err = setEnvUint(err, EnvRunnerResponseWindow, &cfg.ResponseWindow, nil)
+	err = setEnvMsecs(err, EnvRunnerStatusCacheTTL, &cfg.StatusCacheTTL, 0)
+	err = setEnvMsecs(err, EnvRunnerStatusCacheJitter, &cfg.StatusCacheJitter, 0)
+	err = setEnvBool(err, EnvRunnerLogFrames, &cfg.LogFrames)
+	err = setEnvMsecs(err, EnvRunnerCancelGrace, &cfg.CancelGrace, 0)
+	err = setEnvMsecs(err, EnvRunnerDrainTimeout, &cfg.DrainTimeout, 0)
+	err = setEnvMsecs(err, EnvRunnerDialTimeout, &cfg.DialTimeout, DefaultConnectTimeout)
+	err = setEnvMsecs(err, EnvRunnerDialBackoffBase, &cfg.DialBackoffBase, 0)
+	err = setEnvFloat(err, EnvRunnerDialBackoffMultiplier, &cfg.DialBackoffMultiplier)
+	err = setEnvMsecs(err, EnvRunnerDialBackoffMax, &cfg.DialBackoffMax, 0)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.Connections == 0 || cfg.Connections > math.MaxInt32 {
+		return cfg, fmt.Errorf("error invalid %s=%d", EnvRunnerConnections, cfg.Connections)
+	}
+	if cfg.MaxDataChunk == 0 || cfg.MaxDataChunk > math.MaxInt32 {
+		return cfg, fmt.Errorf("error invalid %s=%d", EnvRunnerMaxDataChunk, cfg.MaxDataChunk)
+	}
+	if !validWindowSize(cfg.InitialWindowSize) {
+		return cfg, fmt.Errorf("error invalid %s=%d must be at least %d", EnvRunnerGRPCWindowSize, cfg.InitialWindowSize, minGRPCWindowSize)
+	}
+	if !validWindowSize(cfg.InitialConnWindowSize) {
+		return cfg, fmt.Errorf("error invalid %s=%d must be at least %d", EnvRunnerGRPCConnWindowSize, cfg.InitialConnWindowSize, minGRPCWindowSize)
+	}
+	if cfg.CircuitBreakerThreshold > math.MaxInt32 {
+		return cfg, fmt.Errorf("error invalid %s=%d", EnvRunnerCircuitBreakerThreshold, cfg.CircuitBreakerThreshold)
+	}
+	if cfg.CircuitBreakerThreshold != 0 && (cfg.CircuitBreakerBackoff <= 0 || cfg.CircuitBreakerMaxBackoff < cfg.CircuitBreakerBackoff) {
+		return cfg, fmt.Errorf("error invalid %s=%v %s=%v", EnvRunnerCircuitBreakerBackoff, cfg.CircuitBreakerBackoff, EnvRunnerCircuitBreakerMaxBackoff, cfg.CircuitBreakerMaxBackoff)
+	}
+	if cfg.EjectionThreshold > math.MaxInt32 || cfg.EjectionCanaries > math.MaxInt32 {
+		return cfg, fmt.Errorf("error invalid %s=%d %s=%d", EnvRunnerEjectionThreshold, cfg.EjectionThreshold, EnvRunnerEjectionCanaries, cfg.EjectionCanaries)
+	}
+	if cfg.EjectionThreshold != 0 && (cfg.EjectionPenalty <= 0 || cfg.EjectionMaxPenalty < cfg.EjectionPenalty || cfg.EjectionCanaries == 0) {
+		return cfg, fmt.Errorf("error invalid %s=%v %s=%v %s=%d", EnvRunnerEjectionPenalty, cfg.EjectionPenalty, EnvRunnerEjectionMaxPenalty, cfg.EjectionMaxPenalty, EnvRunnerEjectionCanaries, cfg.EjectionCanaries)
+	}
+	if cfg.InvokeThreshold > MaxInvokeRequestBody {
+		return cfg, fmt.Errorf("error invalid %s=%d must be at most %d", EnvRunnerInvokeThreshold, cfg.InvokeThreshold, MaxInvokeRequestBody)
+	}
+	if cfg.ResponseWindow > math.MaxInt64 {
+		return cfg, fmt.Errorf("error invalid %s=%d", EnvRunnerResponseWindow, cfg.ResponseWindow)
+	}
+	if cfg.DialTimeout <= 0 || cfg.DialTimeout == MaxMsDisabled {
+		return cfg, fmt.Errorf("error invalid %s=%v", EnvRunnerDialTimeout, cfg.DialTimeout)
+	}
+	if backoff, ok := cfg.DialBackoff(); ok && backoff.Validate() != nil {
+		return cfg, fmt.Errorf("error invalid %s=%v %s=%v %s=%v", EnvRunnerDialBackoffBase, cfg.DialBackoffBase,
+			EnvRunnerDialBackoffMultiplier, cfg.DialBackoffMultiplier, EnvRunnerDialBackoffMax, cfg.DialBackoffMax)
+	}
+	switch cfg.ResponseChecksum {
+	case "", "crc32c", "sha256":
+	default:
+		return cfg, fmt.Errorf("error invalid %s=%s", EnvRunnerResponseChecksum, cfg.ResponseChecksum)
+	}
+	if cfg.KeepaliveTime == MaxMsDisabled {
+		cfg.KeepaliveTime = 0
+	}
+	if cfg.MinDeadlineSlack == MaxMsDisabled {
+		cfg.MinDeadlineSlack = 0
+	}
+	if cfg.StatusCacheTTL == MaxMsDisabled {
+		cfg.StatusCacheTTL = 0
+	}
+	if cfg.StatusCacheJitter == MaxMsDisabled {
+		cfg.StatusCacheJitter = 0
+	}
+	if cfg.CancelGrace == MaxMsDisabled {
+		cfg.CancelGrace = 0
+	}
+	if cfg.DrainTimeout == MaxMsDisabled {
+		cfg.DrainTimeout = 0
+	}
+	return cfg, nil
+}
+
+func validWindowSize(size uint64) bool {
+	return size == 0 || (size >= minGRPCWindowSize && size <= math.MaxInt32)
+}
+
+// DialBackoff returns the configured policy for reconnecting to runners, if any.
+// Settings left at zero are taken from the gRPC default policy.
+func (cfg *RunnerClientConfig) DialBackoff() (grpcutil.BackoffConfig, bool) {
+	backoff := grpcutil.DefaultBackoffConfig
+	if cfg.DialBackoffBase == 0 && cfg.DialBackoffMultiplier == 0 && cfg.DialBackoffMax == 0 {
+		return backoff, false
+	}
+	if cfg.DialBackoffBase != 0 {
+		backoff.BaseDelay = cfg.DialBackoffBase
+	}
+	if cfg.DialBackoffMultiplier != 0 {
+		backoff.Multiplier = cfg.DialBackoffMultiplier
+	}
+	if cfg.DialBackoffMax != 0 {
+		backoff.MaxDelay = cfg.DialBackoffMax
+	}
+	return backoff, true
+}
+
+// RunnerOptions returns the options to configure a gRPC runner client with
+func (cfg *RunnerClientConfig) RunnerOptions() []GRPCRunnerOption {
+	opts := []GRPCRunnerOption{
+		GRPCRunnerWithConnections(int(cfg.Connections)),
+		GRPCRunnerWithMaxDataChunk(int(cfg.MaxDataChunk)),
+	}
+	if cfg.Compression != "" {
+		opts = append(opts, GRPCRunnerWithCompression(cfg.Compression))
+	}
+	switch cfg.ResponseChecksum {
+	case "crc32c":
+		opts = append(opts, GRPCRunnerWithResponseCRC32C())
+	case "sha256":
+		opts = append(opts, GRPCRunnerWithResponseHashVerification())
+	}
+	if cfg.ExecutionTokens {
+		opts = append(opts, GRPCRunnerWithExecutionTokens())
+	}
+	if cfg.InvokeThreshold != 0 {
+		opts = append(opts, GRPCRunnerWithInvokeThreshold(int(cfg.InvokeThreshold)))
+	}
+	if cfg.ResponseWindow != 0 {
+		opts = append(opts, GRPCRunnerWithResponseWindow(int64(cfg.ResponseWindow)))
+	}
+	if cfg.LogFrames {
+		opts = append(opts, GRPCRunnerWithLogFrames())
+	}
+	if cfg.CancelGrace != 0 {
+		opts = append(opts, GRPCRunnerWithCancelMessages(cfg.CancelGrace))
+	}
+	if cfg.DrainTimeout != 0 {
+		opts = append(opts, GRPCRunnerWithDrainTimeout(cfg.DrainTimeout))
+	}
+	if cfg.DialTimeout != DefaultConnectTimeout {
+		opts = append(opts, GRPCRunnerWithConnectTimeout(cfg.DialTimeout))
+	}
+	if backoff, ok := cfg.DialBackoff(); ok {
+		opts = append(opts, GRPCRunnerWithDialBackoff(backoff))
+	}
+	if cfg.StatusCacheTTL != 0 {
+		opts = append(opts, GRPCRunnerWithStatusCache(cfg.StatusCacheTTL, cfg.StatusCacheJitter))
+	}
+	if cfg.CircuitBreakerThreshold != 0 {
+		opts = append(opts, GRPCRunnerWithCircuitBreaker(int(cfg.CircuitBreakerThreshold), cfg.CircuitBreakerBackoff, cfg.CircuitBreakerMaxBackoff))
+	}
+	if cfg.EjectionThreshold != 0 {
+		opts = append(opts, GRPCRunnerWithEjection(int(cfg.EjectionThreshold), cfg.EjectionPenalty, cfg.EjectionMaxPenalty, int(cfg.EjectionCanaries)))
+	}
+	if cfg.KeepaliveTime != 0 {
+		opts = append(opts, GRPCRunnerWithKeepalive(keepalive.ClientParameters{
+			Time:                cfg.KeepaliveTime,
+			Timeout:             cfg.KeepaliveTimeout,
+			PermitWithoutStream: cfg.KeepalivePermitWithoutStream,
+		}))
+	}
+
+	var dialOpts []grpc.DialOption
+	if cfg.InitialWindowSize != 0 {
+		dialOpts = append(dialOpts, grpc.WithInitialWindowSize(int32(cfg.InitialWindowSize)))
+	}
+	if cfg.InitialConnWindowSize != 0 {
+		dialOpts = append(dialOpts, grpc.WithInitialConnWindowSize(int32(cfg.InitialConnWindowSize)))
+	}
+	if len(dialOpts) != 0 {
+		opts = append(opts, GRPCRunnerWithDialOptions(dialOpts...))
+	}
+	return opts
+}
+
+// PureRunnerOptions returns the options to configure a pure runner with
+func (cfg *RunnerClientConfig) PureRunnerOptions() []PureRunnerOption {
+	opts := []PureRunnerOption{
+		PureRunnerWithMaxDataChunk(int(cfg.MaxDataChunk)),
+		PureRunnerWithMinDeadlineSlack(cfg.MinDeadlineSlack),
+	}
+
+	var srvOpts []grpc.ServerOption
+	if cfg.InitialWindowSize != 0 {
+		srvOpts = append(srvOpts, grpc.InitialWindowSize(int32(cfg.InitialWindowSize)))
+	}
+	if cfg.InitialConnWindowSize != 0 {
+		srvOpts = append(srvOpts, grpc.InitialConnWindowSize(int32(cfg.InitialConnWindowSize)))
+	}
+	if cfg.KeepaliveTime != 0 {
+		// without this the runner closes connections of clients pinging more than every 5 minutes
+		srvOpts = append(srvOpts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cfg.KeepaliveTime,
+			PermitWithoutStream: cfg.KeepalivePermitWithoutStream,
+		}))
+	}
+	if len(srvOpts) != 0 {
+		opts = append(opts, PureRunnerWithGRPCServerOptions(srvOpts...))
+	}
+	return opts
+}
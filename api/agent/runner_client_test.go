@@ -0,0 +1,1244 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/sirupsen/logrus"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+// mockEngageClient replays a canned sequence of runner messages, followed by io.EOF
+type mockEngageClient struct {
+	grpc.ClientStream
+	header  metadata.MD
+	msgs    []*pb.RunnerMsg
+	sent    []*pb.ClientMsg
+	sendErr error
+}
+
+func (c *mockEngageClient) Header() (metadata.MD, error) {
+	return c.header, nil
+}
+
+func (c *mockEngageClient) Send(msg *pb.ClientMsg) error {
+	if c.sendErr != nil {
+		return c.sendErr
+	}
+	c.sent = append(c.sent, msg)
+	return nil
+}
+
+func (c *mockEngageClient) Recv() (*pb.RunnerMsg, error) {
+	if len(c.msgs) == 0 {
+		return nil, io.EOF
+	}
+	msg := c.msgs[0]
+	c.msgs = c.msgs[1:]
+	return msg, nil
+}
+
+func (c *mockEngageClient) CloseSend() error {
+	return nil
+}
+
+type flushCountingRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (r *flushCountingRecorder) Flush() {
+	r.flushes++
+	r.ResponseRecorder.Flush()
+}
+
+func resultStartMsg(contentType string) *pb.RunnerMsg {
+	return &pb.RunnerMsg{Body: &pb.RunnerMsg_ResultStart{ResultStart: &pb.CallResultStart{
+		Meta: &pb.CallResultStart_Http{Http: &pb.HttpRespMeta{
+			StatusCode: 200,
+			Headers:    []*pb.HttpHeader{{Key: "Content-Type", Value: contentType}},
+		}},
+	}}}
+}
+
+func dataMsg(data string) *pb.RunnerMsg {
+	return &pb.RunnerMsg{Body: &pb.RunnerMsg_Data{Data: &pb.DataFrame{Data: []byte(data)}}}
+}
+
+func finishedMsg() *pb.RunnerMsg {
+	return &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}}
+}
+
+func runReceiveFromRunner(t *testing.T, rw *flushCountingRecorder, msgs ...*pb.RunnerMsg) {
+	call := &mockRunnerCall{rw: rw, model: &models.Call{Type: models.TypeSync}}
+	done := make(chan error, 1)
+	receiveFromRunner(context.Background(), &mockEngageClient{msgs: msgs}, "192.0.2.0", call, receiveOptions{}, done)
+	for err := range done {
+		if err != nil {
+			t.Fatalf("Unexpected error from receiveFromRunner %v", err)
+		}
+	}
+}
+
+func TestReceiveFromRunnerFlushesEventStream(t *testing.T) {
+	rw := &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+	runReceiveFromRunner(t, rw,
+		resultStartMsg("text/event-stream; charset=utf-8"),
+		dataMsg("data: one\n\n"),
+		dataMsg("data: two\n\n"),
+		finishedMsg(),
+	)
+
+	if rw.flushes != 2 {
+		t.Fatalf("Expected a flush per data frame, got %d flushes", rw.flushes)
+	}
+	if rw.Body.String() != "data: one\n\ndata: two\n\n" {
+		t.Fatalf("Unexpected body %q", rw.Body.String())
+	}
+}
+
+func TestReceiveFromRunnerNoFlushByDefault(t *testing.T) {
+	rw := &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+	runReceiveFromRunner(t, rw,
+		resultStartMsg("application/json"),
+		dataMsg("{}"),
+		finishedMsg(),
+	)
+
+	if rw.flushes != 0 {
+		t.Fatalf("Expected no flushes for non-streaming response, got %d", rw.flushes)
+	}
+}
+
+func TestIsStreamingContentType(t *testing.T) {
+	for _, ct := range []string{"text/event-stream", " Text/Event-Stream; charset=utf-8", "application/x-ndjson"} {
+		if !IsStreamingContentType(ct) {
+			t.Fatalf("Expected %q to be streamed", ct)
+		}
+	}
+	for _, ct := range []string{"", "application/json", "text/plain"} {
+		if IsStreamingContentType(ct) {
+			t.Fatalf("Expected %q to be buffered", ct)
+		}
+	}
+}
+
+func TestWriteRespFlushesEventStream(t *testing.T) {
+	for _, tc := range []struct {
+		contentType string
+		flushes     int
+	}{
+		{"text/event-stream", 3},
+		{"application/json", 0},
+	} {
+		rw := &flushCountingRecorder{ResponseRecorder: httptest.NewRecorder()}
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {tc.contentType}},
+			Body:       ioutil.NopCloser(io.MultiReader(strings.NewReader("data: one\n\n"), strings.NewReader("data: two\n\n"))),
+		}
+		if err := new(hotSlot).writeResp(context.Background(), 1024, resp, rw); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if rw.flushes != tc.flushes {
+			t.Fatalf("Expected %d flushes for %s, got %d", tc.flushes, tc.contentType, rw.flushes)
+		}
+		if rw.Body.String() != "data: one\n\ndata: two\n\n" {
+			t.Fatalf("Unexpected body %q", rw.Body.String())
+		}
+	}
+}
+
+func TestWriteRespTrailers(t *testing.T) {
+	rw := httptest.NewRecorder()
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/grpc"}},
+		Body:       ioutil.NopCloser(strings.NewReader("message")),
+		Trailer:    http.Header{"Grpc-Status": {"0"}},
+	}
+	if err := new(hotSlot).writeResp(context.Background(), 1024, resp, rw); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if v := rw.Result().Trailer.Get("Grpc-Status"); v != "0" {
+		t.Fatalf("Expected the trailers of the response, got %v", rw.Result().Trailer)
+	}
+}
+
+func TestCreateGRPCRequest(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://fn.example.com/helloworld.Greeter/SayHello", strings.NewReader("message"))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("Connection", "close")
+	c := &call{Call: &models.Call{ID: "call_id"}, req: req}
+
+	greq := createGRPCRequest(context.Background(), c)
+	if greq.URL.Path != "/helloworld.Greeter/SayHello" || greq.Method != "POST" {
+		t.Fatalf("Expected the gRPC method as path, got %s %s", greq.Method, greq.URL)
+	}
+	if greq.Header.Get("Te") != "trailers" || greq.Header.Get("Content-Type") != "application/grpc" || greq.Header.Get("Fn-Call-Id") != "call_id" {
+		t.Fatalf("Expected the gRPC metadata of the call, got %v", greq.Header)
+	}
+	if greq.Header.Get("Connection") != "" {
+		t.Fatalf("Expected no hop headers, got %v", greq.Header)
+	}
+}
+
+func TestLimitExtensions(t *testing.T) {
+	exts := map[string]string{
+		"fn.essential": "aaaaaaaaaa",
+		"big":          "bbbbbbbbbbbbbbbbbbbb",
+		"small":        "c",
+	}
+
+	r := &gRPCRunner{}
+	out, err := r.limitExtensions(logrus.New(), exts)
+	if err != nil || len(out) != len(exts) {
+		t.Fatalf("Expected extensions to pass through without a limit, got %v %v", out, err)
+	}
+
+	err = GRPCRunnerWithExtensionsLimit(40, ExtensionsLimitFail)(r)
+	if err != nil {
+		t.Fatalf("Unexpected error setting extensions limit %v", err)
+	}
+	_, err = r.limitExtensions(logrus.New(), exts)
+	if err != models.ErrCallExtensionsTooBig {
+		t.Fatalf("Expected ErrCallExtensionsTooBig, got %v", err)
+	}
+
+	err = GRPCRunnerWithExtensionsLimit(40, ExtensionsLimitDrop, "fn.essential")(r)
+	if err != nil {
+		t.Fatalf("Unexpected error setting extensions limit %v", err)
+	}
+	out, err = r.limitExtensions(logrus.New(), exts)
+	if err != nil {
+		t.Fatalf("Unexpected error dropping extensions %v", err)
+	}
+	if _, ok := out["big"]; ok || len(out) != 2 {
+		t.Fatalf("Expected only the largest non-essential key to be dropped, got %v", out)
+	}
+	if len(exts) != 3 {
+		t.Fatal("Call extensions should not be modified")
+	}
+
+	err = GRPCRunnerWithExtensionsLimit(10, ExtensionsLimitDrop, "fn.essential")(r)
+	if err != nil {
+		t.Fatalf("Unexpected error setting extensions limit %v", err)
+	}
+	_, err = r.limitExtensions(logrus.New(), exts)
+	if err != models.ErrCallExtensionsTooBig {
+		t.Fatalf("Expected ErrCallExtensionsTooBig when essential keys exceed the limit, got %v", err)
+	}
+}
+
+func TestCordonRunner(t *testing.T) {
+	r := &gRPCRunner{shutWg: common.NewWaitGroup(), address: "192.0.2.0"}
+	call := &mockRunnerCall{model: &models.Call{Type: models.TypeSync}}
+
+	r.Cordon(time.Hour)
+	if !r.IsCordoned() {
+		t.Fatal("Runner should be cordoned")
+	}
+	placed, err := r.TryExec(context.Background(), call)
+	if placed || err != ErrorRunnerCordoned {
+		t.Fatalf("Expected retriable cordon error, got placed=%v err=%v", placed, err)
+	}
+
+	r.Uncordon()
+	if r.IsCordoned() {
+		t.Fatal("Runner should be uncordoned")
+	}
+
+	r.Cordon(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if r.IsCordoned() {
+		t.Fatal("Runner should have been uncordoned on expiry")
+	}
+}
+
+func TestDrainRunner(t *testing.T) {
+	r := &gRPCRunner{shutWg: common.NewWaitGroup(), address: "192.0.2.0"}
+	call := &mockRunnerCall{model: &models.Call{Type: models.TypeSync}}
+
+	r.Drain(true)
+	if !r.IsDraining() || r.Ready() {
+		t.Fatal("Draining runner should not be ready")
+	}
+	placed, err := r.TryExec(context.Background(), call)
+	if placed || err != ErrorRunnerDraining {
+		t.Fatalf("Expected retriable drain error, got placed=%v err=%v", placed, err)
+	}
+	if r.CallsInFlight() != 0 {
+		t.Fatalf("Expected no calls in flight, got %d", r.CallsInFlight())
+	}
+
+	r.Drain(false)
+	if r.IsDraining() {
+		t.Fatal("Runner should not be draining")
+	}
+}
+
+func TestDataChunkSizeNegotiation(t *testing.T) {
+	ctx := context.Background()
+
+	r := &gRPCRunner{maxDataChunk: MaxDataChunk}
+	size := r.dataChunkSize(ctx, &mockEngageClient{header: metadata.Pairs(MaxDataChunkHeader, "4096")})
+	if size != 4096 {
+		t.Fatalf("Expected advertised chunk size 4096, got %d", size)
+	}
+
+	r = &gRPCRunner{maxDataChunk: MaxDataChunk}
+	size = r.dataChunkSize(ctx, &mockEngageClient{header: metadata.Pairs(MaxDataChunkHeader, "1048576")})
+	if size != MaxDataChunk {
+		t.Fatalf("Expected local chunk size %d, got %d", MaxDataChunk, size)
+	}
+
+	r = &gRPCRunner{maxDataChunk: MaxDataChunk}
+	size = r.dataChunkSize(ctx, &mockEngageClient{header: metadata.MD{}})
+	if size != MaxDataChunk {
+		t.Fatalf("Expected fallback chunk size %d, got %d", MaxDataChunk, size)
+	}
+}
+
+type chanCallEventSink chan *CallEvent
+
+func (s chanCallEventSink) Emit(ctx context.Context, event *CallEvent) {
+	s <- event
+}
+
+func TestReceiveFromRunnerEmitsCallEvent(t *testing.T) {
+	events := make(chanCallEventSink, 1)
+	rw := httptest.NewRecorder()
+	call := &mockRunnerCall{rw: rw, model: &models.Call{ID: "call1", AppID: "app1", FnID: "fn1", Type: models.TypeSync}}
+	done := make(chan error, 1)
+	msgs := []*pb.RunnerMsg{
+		resultStartMsg("application/json"),
+		dataMsg("{}"),
+		{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true, Image: "fnproject/hello", ExecutionDuration: int64(time.Second)}}},
+	}
+	receiveFromRunner(context.Background(), &mockEngageClient{msgs: msgs}, "192.0.2.0", call, receiveOptions{onFinish: events.Emit}, done)
+
+	select {
+	case event := <-events:
+		if event.CallID != "call1" || event.AppID != "app1" || event.FnID != "fn1" || !event.Success {
+			t.Fatalf("Unexpected call event %+v", event)
+		}
+		if event.StatusCode != 200 || event.ResponseBytes != 2 || event.ExecutionDuration != time.Second {
+			t.Fatalf("Unexpected call event measurements %+v", event)
+		}
+	default:
+		t.Fatal("Expected a call event on finish")
+	}
+}
+
+type blockingCallEventSink struct {
+	release chan struct{}
+}
+
+func (s *blockingCallEventSink) Emit(ctx context.Context, event *CallEvent) {
+	<-s.release
+}
+
+func TestBufferedCallEventSinkDoesNotBlock(t *testing.T) {
+	blocking := &blockingCallEventSink{release: make(chan struct{})}
+	defer close(blocking.release)
+	sink := NewBufferedCallEventSink(blocking, 1)
+
+	finished := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			sink.Emit(context.Background(), &CallEvent{})
+		}
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Emit blocked on a full event queue")
+	}
+}
+
+// mockRunnerProtocolClient counts engagements, any other call panics
+type mockRunnerProtocolClient struct {
+	pb.RunnerProtocolClient
+	engagements  int
+	md           metadata.MD
+	statusFailed bool
+}
+
+func (c *mockRunnerProtocolClient) Engage(ctx context.Context, opts ...grpc.CallOption) (pb.RunnerProtocol_EngageClient, error) {
+	c.engagements++
+	c.md, _ = metadata.FromOutgoingContext(ctx)
+	return nil, errors.New("engage is not supported by mock")
+}
+
+func (c *mockRunnerProtocolClient) Status(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*pb.RunnerStatus, error) {
+	c.md, _ = metadata.FromOutgoingContext(ctx)
+	return &pb.RunnerStatus{Failed: c.statusFailed}, nil
+}
+
+func TestTryExecCancelledContext(t *testing.T) {
+	client := &mockRunnerProtocolClient{}
+	r := &gRPCRunner{shutWg: common.NewWaitGroup(), address: "192.0.2.0", clients: []pb.RunnerProtocolClient{client}}
+	call := &mockRunnerCall{model: &models.Call{Type: models.TypeSync}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	placed, err := r.TryExec(ctx, call)
+	if placed || err != context.Canceled {
+		t.Fatalf("Expected not placed with context.Canceled, got placed=%v err=%v", placed, err)
+	}
+	if client.engagements != 0 {
+		t.Fatalf("Expected no engagement on a cancelled context, got %d", client.engagements)
+	}
+}
+
+func TestSchedulerWaitQuantiles(t *testing.T) {
+	r := &gRPCRunner{schedWait: newLatencyWindow(100)}
+
+	qs, n := r.SchedulerWaitQuantiles(0.5)
+	if n != 0 || qs[0] != 0 {
+		t.Fatalf("Expected empty window, got %v %d", qs, n)
+	}
+
+	for i := 1; i <= 200; i++ {
+		r.handleCallEvent(context.Background(), &CallEvent{SchedulerDuration: time.Duration(i) * time.Millisecond})
+	}
+
+	// only the last 100 samples (101ms-200ms) are kept
+	qs, n = r.SchedulerWaitQuantiles(0, 0.5, 0.99, 1)
+	if n != 100 {
+		t.Fatalf("Expected 100 samples, got %d", n)
+	}
+	if qs[0] != 101*time.Millisecond || qs[3] != 200*time.Millisecond {
+		t.Fatalf("Unexpected min/max %v", qs)
+	}
+	if qs[1] < 145*time.Millisecond || qs[1] > 155*time.Millisecond || qs[2] < 195*time.Millisecond {
+		t.Fatalf("Unexpected quantiles %v", qs)
+	}
+
+	r.ResetSchedulerWait()
+	if _, n = r.SchedulerWaitQuantiles(0.5); n != 0 {
+		t.Fatalf("Expected empty window after reset, got %d samples", n)
+	}
+}
+
+func TestReceiveFromRunnerResponseHash(t *testing.T) {
+	body := "hello world"
+	sum := sha256.Sum256([]byte(body))
+
+	for _, tc := range []struct {
+		reported []byte
+		expected error
+	}{
+		{sum[:], nil},
+		{nil, nil}, // runner did not report a hash
+		{[]byte("bogus"), models.ErrResponseHashMismatch},
+	} {
+		call := &mockRunnerCall{rw: httptest.NewRecorder(), model: &models.Call{Type: models.TypeSync}}
+		done := make(chan error, 1)
+		msgs := []*pb.RunnerMsg{
+			dataMsg(body),
+			{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true, ResponseSha256: tc.reported}}},
+		}
+		receiveFromRunner(context.Background(), &mockEngageClient{msgs: msgs}, "192.0.2.0", call, receiveOptions{verifyResponseHash: true}, done)
+
+		var err error
+		for e := range done {
+			err = e
+		}
+		if err != tc.expected {
+			t.Fatalf("Expected %v for reported hash %x, got %v", tc.expected, tc.reported, err)
+		}
+	}
+}
+
+func TestReceiveFromRunnerResponseCRC32C(t *testing.T) {
+	body := "hello world"
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum([]byte(body), crc32cTable))
+
+	for _, tc := range []struct {
+		reported []byte
+		expected error
+	}{
+		{sum, nil},
+		{nil, nil}, // runner did not report a checksum
+		{[]byte{0, 0, 0, 0}, models.ErrResponseHashMismatch},
+	} {
+		call := &mockRunnerCall{rw: httptest.NewRecorder(), model: &models.Call{Type: models.TypeSync}}
+		done := make(chan error, 1)
+		msgs := []*pb.RunnerMsg{
+			dataMsg("hello "),
+			dataMsg("world"),
+			{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true, ResponseCrc32C: tc.reported}}},
+		}
+		receiveFromRunner(context.Background(), &mockEngageClient{msgs: msgs}, "192.0.2.0", call, receiveOptions{verifyResponseCRC32C: true}, done)
+
+		var err error
+		for e := range done {
+			err = e
+		}
+		if err != tc.expected {
+			t.Fatalf("Expected %v for reported checksum %x, got %v", tc.expected, tc.reported, err)
+		}
+	}
+}
+
+func TestReceiveFromRunnerDetachedResponse(t *testing.T) {
+	w := NewDetachedResponseWriter(make(http.Header), http.StatusAccepted)
+	w.maxBody = 8
+	call := &mockRunnerCall{rw: w, model: &models.Call{Type: models.TypeDetached}}
+	done := make(chan error, 1)
+	msgs := []*pb.RunnerMsg{
+		{Body: &pb.RunnerMsg_ResultStart{ResultStart: &pb.CallResultStart{Meta: &pb.CallResultStart_Http{Http: &pb.HttpRespMeta{StatusCode: http.StatusAccepted}}}}},
+		dataMsg("hello "),
+		dataMsg("world"),
+		{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true, DetachedResponse: &pb.DetachedResponse{
+			Http: &pb.HttpRespMeta{
+				StatusCode: http.StatusCreated,
+				Headers:    []*pb.HttpHeader{{Key: "Content-Type", Value: "text/plain"}},
+			},
+		}}}},
+	}
+	receiveFromRunner(context.Background(), &mockEngageClient{msgs: msgs}, "192.0.2.0", call, receiveOptions{}, done)
+	for err := range done {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if w.respStatus != http.StatusCreated || w.respHeader.Get("Content-Type") != "text/plain" {
+		t.Fatalf("Expected the response of the fn, got %d %v", w.respStatus, w.respHeader)
+	}
+	if string(w.body) != "hello wo" || !w.truncated {
+		t.Fatalf("Expected the body to be truncated to the max body, got %q", w.body)
+	}
+}
+
+func TestReceiveFromRunnerStreamErrorStats(t *testing.T) {
+	v := common.CreateView(runnerStreamErrorsMeasure, view.Count(), []string{"runner_addr", "runner_error"})
+	err := view.Register(v)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer view.Unregister(v)
+
+	finished := &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}}
+	for _, msgs := range [][]*pb.RunnerMsg{
+		nil,                          // stream closed before the call finished
+		{finished, dataMsg("extra")}, // message after the call finished
+	} {
+		call := &mockRunnerCall{rw: httptest.NewRecorder(), model: &models.Call{Type: models.TypeSync}}
+		done := make(chan error, 1)
+		receiveFromRunner(context.Background(), &mockEngageClient{msgs: msgs}, "192.0.2.0", call, receiveOptions{}, done)
+		for range done {
+		}
+	}
+
+	rows, err := view.RetrieveData(v.Name)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	counts := make(map[string]int64)
+	for _, row := range rows {
+		var addr, class string
+		for _, tg := range row.Tags {
+			switch tg.Key {
+			case runnerAddrKey:
+				addr = tg.Value
+			case runnerErrorKey:
+				class = tg.Value
+			}
+		}
+		if addr != "192.0.2.0" {
+			t.Fatalf("Expected runner address tag, got %q", addr)
+		}
+		counts[class] = row.Data.(*view.CountData).Value
+	}
+	if counts[runnerErrorRecv] != 1 || counts[runnerErrorNoEOF] != 1 || len(counts) != 2 {
+		t.Fatalf("Expected one recv and one no_eof error, got %v", counts)
+	}
+}
+
+func TestMetadataFunc(t *testing.T) {
+	client := &mockRunnerProtocolClient{}
+	r := &gRPCRunner{shutWg: common.NewWaitGroup(), address: "192.0.2.0", clients: []pb.RunnerProtocolClient{client}}
+	err := GRPCRunnerWithMetadataFunc(func(ctx context.Context) metadata.MD {
+		return metadata.Pairs("x-auth", "secret", common.RequestIDContextKey, "spoofed")
+	})(r)
+	if err != nil {
+		t.Fatalf("Unexpected error setting metadata func %v", err)
+	}
+
+	ctx := common.WithRequestID(context.Background(), "rid1")
+
+	_, err = r.Status(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected status error %v", err)
+	}
+	if v := client.md.Get("x-auth"); len(v) != 1 || v[0] != "secret" {
+		t.Fatalf("Expected custom metadata in status call, got %v", client.md)
+	}
+	if v := client.md.Get(common.RequestIDContextKey); len(v) != 1 || v[0] != "rid1" {
+		t.Fatalf("Request ID should not be overwritten, got %v", client.md)
+	}
+
+	call := &mockRunnerCall{model: &models.Call{Type: models.TypeSync}}
+	client.md = nil
+	r.TryExec(ctx, call)
+	if v := client.md.Get("x-auth"); len(v) != 1 || v[0] != "secret" {
+		t.Fatalf("Expected custom metadata in engage call, got %v", client.md)
+	}
+	if v := client.md.Get(common.RequestIDContextKey); len(v) != 1 || v[0] != "rid1" {
+		t.Fatalf("Request ID should not be overwritten, got %v", client.md)
+	}
+}
+
+func TestRunnerConnectionPool(t *testing.T) {
+	// TEST-NET-1 unreachable
+	runner, err := NewgRPCRunnerWithOptions("192.0.2.255:8080", nil, GRPCRunnerWithConnections(3))
+	if err != nil {
+		t.Fatalf("Failed to create runner %v", err)
+	}
+	r := runner.(*gRPCRunner)
+	if len(r.conns) != 3 || len(r.clients) != 3 {
+		t.Fatalf("Expected 3 connections, got %d", len(r.conns))
+	}
+
+	seen := make(map[pb.RunnerProtocolClient]bool)
+	for i := 0; i < 3; i++ {
+		seen[r.client()] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("Expected round robin over all connections, got %d", len(seen))
+	}
+
+	err = r.Close(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error from close %v", err)
+	}
+
+	_, err = NewgRPCRunnerWithOptions("192.0.2.255:8080", nil, GRPCRunnerWithConnections(0))
+	if err == nil {
+		t.Fatal("Expected an error for an invalid number of connections")
+	}
+}
+
+func TestRunnerInterceptors(t *testing.T) {
+	errIntercepted := errors.New("intercepted")
+	var callsMtx sync.Mutex
+	var calls []string
+	unary := func(name string, err error) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			// the capabilities handshake runs in the background
+			if method != "/RunnerProtocol/Capabilities" {
+				callsMtx.Lock()
+				calls = append(calls, name+method)
+				callsMtx.Unlock()
+			}
+			if err != nil {
+				return err
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+	stream := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		callsMtx.Lock()
+		calls = append(calls, "stream"+method)
+		callsMtx.Unlock()
+		return nil, errIntercepted
+	}
+
+	// TEST-NET-1 unreachable, the interceptors never invoke the call
+	runner, err := NewgRPCRunnerWithOptions("192.0.2.255:8080", nil,
+		GRPCRunnerWithUnaryInterceptors(unary("first", nil)),
+		GRPCRunnerWithUnaryInterceptors(unary("second", errIntercepted)),
+		GRPCRunnerWithStreamInterceptors(stream),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create runner %v", err)
+	}
+	defer runner.Close(context.Background())
+
+	_, err = runner.Status(context.Background())
+	if err != errIntercepted {
+		t.Fatalf("Expected intercepted status call, got %v", err)
+	}
+	placed, err := runner.TryExec(context.Background(), &mockRunnerCall{model: &models.Call{Type: models.TypeSync}})
+	if placed || err != errIntercepted {
+		t.Fatalf("Expected intercepted engagement, got placed=%v err=%v", placed, err)
+	}
+
+	expected := []string{"first/RunnerProtocol/Status", "second/RunnerProtocol/Status", "stream/RunnerProtocol/Engage"}
+	callsMtx.Lock()
+	defer callsMtx.Unlock()
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("Expected interceptor calls %v, got %v", expected, calls)
+	}
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	ctx := context.Background()
+
+	r := &gRPCRunner{maxDataChunk: MaxDataChunk}
+	err := GRPCRunnerWithCompression("no-such-compressor")(r)
+	if err == nil {
+		t.Fatalf("Expected error for unknown compressor")
+	}
+	err = GRPCRunnerWithCompression("gzip")(r)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	r.dataChunkSize(ctx, &mockEngageClient{header: metadata.Pairs(MaxDataChunkHeader, "4096", CompressorsHeader, "snappy,gzip")})
+	if atomic.LoadInt32(&r.compressorOK) != 1 {
+		t.Fatalf("Expected runner to accept gzip")
+	}
+
+	// older runners do not advertise compressors
+	r = &gRPCRunner{maxDataChunk: MaxDataChunk, compressor: "gzip"}
+	r.dataChunkSize(ctx, &mockEngageClient{header: metadata.Pairs(MaxDataChunkHeader, "4096")})
+	if atomic.LoadInt32(&r.compressorOK) != -1 {
+		t.Fatalf("Expected runner to not accept gzip")
+	}
+}
+
+func TestCallDeadlinePropagation(t *testing.T) {
+	client := &mockRunnerProtocolClient{}
+	r := &gRPCRunner{shutWg: common.NewWaitGroup(), address: "192.0.2.0", clients: []pb.RunnerProtocolClient{client}}
+	call := &mockRunnerCall{model: &models.Call{Type: models.TypeSync}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r.TryExec(ctx, call)
+
+	if v := client.md.Get(CallDeadlineHeader); len(v) != 1 {
+		t.Fatalf("Expected call deadline in engagement metadata, got %v", client.md)
+	}
+
+	// the runner sees the client's outgoing metadata as incoming
+	slack, ok := callSlackFromContext(metadata.NewIncomingContext(context.Background(), client.md))
+	if !ok || slack <= 0 || slack > 10*time.Second {
+		t.Fatalf("Expected slack of at most 10s, got %v %v", slack, ok)
+	}
+
+	_, ok = callSlackFromContext(context.Background())
+	if ok {
+		t.Fatalf("Expected no slack without metadata")
+	}
+
+	client.md = nil
+	r.TryExec(context.Background(), call)
+	if v := client.md.Get(CallSlackHeader); len(v) != 0 {
+		t.Fatalf("Expected no slack for a call without deadline, got %v", v)
+	}
+}
+
+func TestReceiveFromRunnerAccept(t *testing.T) {
+	receive := func(accept bool, msgs ...*pb.RunnerMsg) (*httptest.ResponseRecorder, int, error) {
+		rw := httptest.NewRecorder()
+		call := &mockRunnerCall{rw: rw, model: &models.Call{Type: models.TypeSync}}
+		acks := 0
+		done := make(chan error, 1)
+		receiveFromRunner(context.Background(), &mockEngageClient{msgs: msgs}, "192.0.2.0", call, receiveOptions{
+			onAccept: func() bool {
+				acks++
+				return accept
+			},
+		}, done)
+		var recvErr error
+		for err := range done {
+			recvErr = err
+		}
+		return rw, acks, recvErr
+	}
+
+	rw, acks, err := receive(false, resultStartMsg("text/plain"), dataMsg("lost"), finishedMsg())
+	if err != pool.ErrHedgeLost || acks != 1 {
+		t.Fatalf("Expected hedge lost after one ack, got %v acks=%d", err, acks)
+	}
+	if rw.Body.Len() != 0 || rw.Header().Get("Content-Type") != "" {
+		t.Fatalf("Expected nothing written to a call accepted by another runner")
+	}
+
+	rw, acks, err = receive(true, resultStartMsg("text/plain"), dataMsg("won"), finishedMsg())
+	if err != nil || acks != 1 || rw.Body.String() != "won" {
+		t.Fatalf("Expected accepted call to be written, got %v acks=%d body=%q", err, acks, rw.Body.String())
+	}
+
+	nack := &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{
+		ErrorCode: int32(models.GetAPIErrorCode(models.ErrCallTimeoutServerBusy)),
+		ErrorStr:  "busy",
+	}}}
+	_, acks, err = receive(false, nack)
+	if !isTooBusy(err) || acks != 0 {
+		t.Fatalf("Expected too busy NACK without accepting, got %v acks=%d", err, acks)
+	}
+}
+
+func TestAcceptStream(t *testing.T) {
+	accepted := make(chan struct{})
+	if acceptStream(func() bool { return false }, accepted)() {
+		t.Fatalf("Expected the stream call to be accepted by another runner")
+	}
+	select {
+	case <-accepted:
+		t.Fatalf("Expected the body of a call accepted by another runner not to be sent")
+	default:
+	}
+
+	if !acceptStream(func() bool { return true }, accepted)() {
+		t.Fatalf("Expected the stream call to be accepted")
+	}
+	<-accepted
+
+	accepted = make(chan struct{})
+	if !acceptStream(nil, accepted)() {
+		t.Fatalf("Expected the stream call to be accepted without an ack")
+	}
+	<-accepted
+}
+
+func TestRunnerReady(t *testing.T) {
+	client := &mockRunnerProtocolClient{statusFailed: true}
+	// no background ready checks during the test
+	r := &gRPCRunner{shutWg: common.NewWaitGroup(), address: "192.0.2.0", clients: []pb.RunnerProtocolClient{client},
+		readyCheckInterval: time.Hour, lastReadyCheck: time.Now().UnixNano()}
+
+	// no connections
+	if r.Ready() {
+		t.Fatalf("Expected runner without connections to not be ready")
+	}
+
+	_, err := r.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected status error %v", err)
+	}
+	if atomic.LoadInt32(&r.statusState) != -1 || r.Ready() {
+		t.Fatalf("Expected runner with failed status to not be ready")
+	}
+
+	client.statusFailed = false
+	_, err = r.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected status error %v", err)
+	}
+	if atomic.LoadInt32(&r.statusState) != 1 {
+		t.Fatalf("Expected successful status to be recorded")
+	}
+}
+
+func TestReceiveFromRunnerResponseWindowAcks(t *testing.T) {
+	for _, tc := range []struct {
+		header metadata.MD
+		acks   []int64
+	}{
+		{metadata.Pairs(ResponseWindowHeader, "1"), []int64{4, 8}},
+		// runners that do not honor the window are not sent acks
+		{metadata.MD{}, nil},
+	} {
+		call := &mockRunnerCall{rw: httptest.NewRecorder(), model: &models.Call{Type: models.TypeSync}}
+		client := &mockEngageClient{header: tc.header, msgs: []*pb.RunnerMsg{
+			dataMsg("aaaa"),
+			dataMsg("bb"),
+			dataMsg("cc"),
+			dataMsg("d"),
+			dataMsg("e"),
+			{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}},
+		}}
+		done := make(chan error, 1)
+		receiveFromRunner(context.Background(), client, "192.0.2.0", call, receiveOptions{responseWindow: 8}, done)
+		for e := range done {
+			t.Fatalf("Unexpected error %v", e)
+		}
+
+		var acks []int64
+		for _, msg := range client.sent {
+			acks = append(acks, msg.GetAck().GetBytes())
+		}
+		if !reflect.DeepEqual(acks, tc.acks) {
+			t.Fatalf("Expected acks %v, got %v", tc.acks, acks)
+		}
+	}
+}
+
+func TestResponseWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := &callHandle{
+		ctx:          context.Background(),
+		sctx:         ctx,
+		doneQueue:    make(chan struct{}),
+		maxDataChunk: 10,
+		ackNotify:    make(chan struct{}, 1),
+	}
+	ch.setResponseWindow(5)
+	if ch.respWindow != 20 {
+		t.Fatalf("Expected window raised to two data chunks, got %d", ch.respWindow)
+	}
+
+	for i := 0; i < 2; i++ {
+		err := ch.waitResponseWindow(10)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- ch.waitResponseWindow(10)
+	}()
+	select {
+	case err := <-waited:
+		t.Fatalf("Expected to wait for an ack with a full window, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	ch.ackResponse(10)
+	err := <-waited
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	go func() {
+		waited <- ch.waitResponseWindow(10)
+	}()
+	cancel()
+	if err := <-waited; err != io.EOF {
+		t.Fatalf("Expected EOF once the call is cancelled, got %v", err)
+	}
+}
+
+func TestTryExecRejectReason(t *testing.T) {
+	busy := int32(models.ErrCallTimeoutServerBusy.Code())
+	for _, tc := range []struct {
+		reason   string
+		expected error
+	}{
+		{"memory", &pool.RunnerBusyError{Reason: pool.RejectMemory}},
+		// older runners do not report why they are busy
+		{"", models.ErrCallTimeoutServerBusy},
+	} {
+		nack := &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{ErrorCode: busy, ErrorStr: "busy", RejectReason: tc.reason}}}
+		client := &scriptedRunnerProtocolClient{engagements: []*mockEngageClient{{msgs: []*pb.RunnerMsg{nack}}}}
+		r := &gRPCRunner{
+			shutWg:          common.NewWaitGroup(),
+			address:         "192.0.2.0",
+			clients:         []pb.RunnerProtocolClient{client},
+			maxDataChunk:    MaxDataChunk,
+			advertisedChunk: -1,
+		}
+		call := &mockRunnerCall{
+			r:     httptest.NewRequest("POST", "/", strings.NewReader("")),
+			rw:    httptest.NewRecorder(),
+			model: &models.Call{ID: "call1", Type: models.TypeSync},
+		}
+		placed, err := r.TryExec(context.Background(), call)
+		if placed || !reflect.DeepEqual(err, tc.expected) {
+			t.Fatalf("Expected not placed with %v, got placed=%v err=%v", tc.expected, placed, err)
+		}
+	}
+}
+
+type bufferReadWriteCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferReadWriteCloser) Close() error { return nil }
+
+func TestReceiveFromRunnerLogFrames(t *testing.T) {
+	stderr := &bufferReadWriteCloser{}
+	rw := httptest.NewRecorder()
+	call := &mockRunnerCall{rw: rw, stdErr: stderr, model: &models.Call{Type: models.TypeSync}}
+	logMsg := func(data string) *pb.RunnerMsg {
+		return &pb.RunnerMsg{Body: &pb.RunnerMsg_Log{Log: &pb.LogFrame{Data: []byte(data)}}}
+	}
+	msgs := []*pb.RunnerMsg{
+		logMsg("starting\n"),
+		dataMsg("hello"),
+		logMsg("done\n"),
+		{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}},
+	}
+	done := make(chan error, 1)
+	receiveFromRunner(context.Background(), &mockEngageClient{msgs: msgs}, "192.0.2.0", call, receiveOptions{strictMessages: true}, done)
+	for e := range done {
+		t.Fatalf("Unexpected error %v", e)
+	}
+	if stderr.String() != "starting\ndone\n" || rw.Body.String() != "hello" {
+		t.Fatalf("Expected logs and response kept apart, got logs %q response %q", stderr.String(), rw.Body.String())
+	}
+}
+
+func TestLogFrameWriter(t *testing.T) {
+	ch := &callHandle{
+		ctx:          context.Background(),
+		doneQueue:    make(chan struct{}),
+		outQueue:     make(chan *pb.RunnerMsg, 10),
+		maxDataChunk: 4,
+	}
+	w := &logFrameWriter{ch: ch}
+
+	n, err := w.Write([]byte("0123456789"))
+	if n != 10 || err != nil {
+		t.Fatalf("Unexpected write result %d %v", n, err)
+	}
+	var frames []string
+	for len(ch.outQueue) > 0 {
+		frames = append(frames, string((<-ch.outQueue).GetLog().GetData()))
+	}
+	if !reflect.DeepEqual(frames, []string{"0123", "4567", "89"}) {
+		t.Fatalf("Expected log frames of at most 4 bytes, got %q", frames)
+	}
+
+	// nothing is sent after the finish message
+	ch.logsDone = true
+	n, err = w.Write([]byte("late"))
+	if n != 4 || err != nil || len(ch.outQueue) != 0 {
+		t.Fatalf("Expected log dropped after finish, got %d %v with %d queued", n, err, len(ch.outQueue))
+	}
+}
+
+// cancellableEngageClient blocks in Recv until the client cancels the call, then
+// finishes it like a pure runner would
+type cancellableEngageClient struct {
+	mockEngageClient
+	ctx       context.Context
+	cancelled chan struct{}
+	// whether the engagement context was still alive when the cancel arrived
+	aliveOnCancel bool
+}
+
+func (c *cancellableEngageClient) Send(msg *pb.ClientMsg) error {
+	if msg.GetCancel() != nil {
+		c.aliveOnCancel = c.ctx.Err() == nil
+		close(c.cancelled)
+	}
+	return c.mockEngageClient.Send(msg)
+}
+
+func (c *cancellableEngageClient) Recv() (*pb.RunnerMsg, error) {
+	select {
+	case <-c.cancelled:
+	case <-c.ctx.Done():
+	}
+	return c.mockEngageClient.Recv()
+}
+
+type cancellableRunnerProtocolClient struct {
+	pb.RunnerProtocolClient
+	engagement *cancellableEngageClient
+}
+
+func (c *cancellableRunnerProtocolClient) Engage(ctx context.Context, opts ...grpc.CallOption) (pb.RunnerProtocol_EngageClient, error) {
+	c.engagement.ctx = ctx
+	return c.engagement, nil
+}
+
+func TestTryExecCancelMessage(t *testing.T) {
+	finished := &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{ErrorCode: int32(models.ErrClientCancel.Code())}}}
+	engagement := &cancellableEngageClient{
+		mockEngageClient: mockEngageClient{msgs: []*pb.RunnerMsg{finished}},
+		cancelled:        make(chan struct{}),
+	}
+	r := &gRPCRunner{
+		shutWg:          common.NewWaitGroup(),
+		address:         "192.0.2.0",
+		clients:         []pb.RunnerProtocolClient{&cancellableRunnerProtocolClient{engagement: engagement}},
+		maxDataChunk:    MaxDataChunk,
+		advertisedChunk: -1,
+	}
+	err := GRPCRunnerWithCancelMessages(time.Second)(r)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	call := &mockRunnerCall{
+		r:     httptest.NewRequest("POST", "/", strings.NewReader("")),
+		rw:    httptest.NewRecorder(),
+		model: &models.Call{ID: "call1", Type: models.TypeSync},
+	}
+	placed, err := r.TryExec(ctx, call)
+	if !placed || err != context.Canceled {
+		t.Fatalf("Expected placed call cancelled, got placed=%v err=%v", placed, err)
+	}
+
+	select {
+	case <-engagement.cancelled:
+	default:
+		t.Fatalf("Expected cancel message sent to runner")
+	}
+	if !engagement.aliveOnCancel {
+		t.Fatalf("Expected engagement alive until the cancel message was sent")
+	}
+	if engagement.ctx.Err() == nil {
+		t.Fatalf("Expected engagement torn down after the call")
+	}
+
+	err = GRPCRunnerWithCancelMessages(0)(&gRPCRunner{})
+	if err == nil {
+		t.Fatalf("Expected an error for a zero cancel grace period")
+	}
+}
+
+func TestTryExecCallPriority(t *testing.T) {
+	finished := &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}}
+	engagement := &cancellableEngageClient{
+		mockEngageClient: mockEngageClient{msgs: []*pb.RunnerMsg{finished}},
+		cancelled:        make(chan struct{}),
+	}
+	close(engagement.cancelled)
+	r := &gRPCRunner{
+		shutWg:          common.NewWaitGroup(),
+		address:         "192.0.2.0",
+		clients:         []pb.RunnerProtocolClient{&cancellableRunnerProtocolClient{engagement: engagement}},
+		maxDataChunk:    MaxDataChunk,
+		advertisedChunk: -1,
+	}
+	call := &mockRunnerCall{
+		r:        httptest.NewRequest("POST", "/", strings.NewReader("")),
+		rw:       httptest.NewRecorder(),
+		model:    &models.Call{ID: "call1", Type: models.TypeDetached},
+		priority: pool.PriorityBatch,
+	}
+	placed, err := r.TryExec(context.Background(), call)
+	if !placed || err != nil {
+		t.Fatalf("Expected placed call, got placed=%v err=%v", placed, err)
+	}
+
+	md, _ := metadata.FromOutgoingContext(engagement.ctx)
+	if p := md.Get(CallPriorityHeader); len(p) != 1 || p[0] != string(pool.PriorityBatch) {
+		t.Fatalf("Expected batch priority metadata, got %v", p)
+	}
+	priority, ok := callPriority(metadata.NewIncomingContext(context.Background(), md))
+	if !ok || priority != pool.PriorityBatch {
+		t.Fatalf("Expected runner to read batch priority, got %q %v", priority, ok)
+	}
+	_, ok = callPriority(metadata.NewIncomingContext(context.Background(), metadata.Pairs(CallPriorityHeader, "urgent")))
+	if ok {
+		t.Fatalf("Expected unknown priority to be ignored")
+	}
+}
+
+func TestTryExecRunnerLatencyStats(t *testing.T) {
+	var views []*view.View
+	for _, m := range []*stats.Int64Measure{runnerPlacementLatencyMeasure, runnerCallLatencyMeasure} {
+		v := common.CreateView(m, view.Distribution(1, 10, 100), []string{"runner_addr"})
+		if err := view.Register(v); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		defer view.Unregister(v)
+		views = append(views, v)
+	}
+
+	finished := &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}}
+	engagement := &cancellableEngageClient{
+		mockEngageClient: mockEngageClient{msgs: []*pb.RunnerMsg{finished}},
+		cancelled:        make(chan struct{}),
+	}
+	close(engagement.cancelled)
+	r := &gRPCRunner{
+		shutWg:          common.NewWaitGroup(),
+		address:         "192.0.2.0",
+		clients:         []pb.RunnerProtocolClient{&cancellableRunnerProtocolClient{engagement: engagement}},
+		maxDataChunk:    MaxDataChunk,
+		advertisedChunk: -1,
+	}
+	call := &mockRunnerCall{
+		r:     httptest.NewRequest("POST", "/", strings.NewReader("")),
+		rw:    httptest.NewRecorder(),
+		model: &models.Call{ID: "call1", Type: models.TypeSync},
+	}
+	placed, err := r.TryExec(context.Background(), call)
+	if !placed || err != nil {
+		t.Fatalf("Expected placed call, got placed=%v err=%v", placed, err)
+	}
+
+	for _, v := range views {
+		rows, err := view.RetrieveData(v.Name)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		if len(rows) != 1 || len(rows[0].Tags) != 1 || rows[0].Tags[0].Value != "192.0.2.0" {
+			t.Fatalf("Expected a row for the runner in %s, got %v", v.Name, rows)
+		}
+		if count := rows[0].Data.(*view.DistributionData).Count; count != 1 {
+			t.Fatalf("Expected one latency in %s, got %d", v.Name, count)
+		}
+	}
+}
+
+func TestRunnerCloseDrains(t *testing.T) {
+	r := &gRPCRunner{shutWg: common.NewWaitGroup(), address: "192.0.2.0"}
+	err := GRPCRunnerWithDrainTimeout(time.Second)(r)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	// a call in flight
+	if !r.shutWg.AddSession(1) {
+		t.Fatalf("Expected session to be added")
+	}
+	closed := make(chan error, 1)
+	go func() {
+		closed <- r.Close(context.Background())
+	}()
+
+	// no new calls are placed while draining
+	time.Sleep(10 * time.Millisecond)
+	placed, err := r.TryExec(context.Background(), &mockRunnerCall{})
+	if placed || err != ErrorRunnerClosed {
+		t.Fatalf("Expected closing runner to reject calls, got placed=%v err=%v", placed, err)
+	}
+	select {
+	case <-closed:
+		t.Fatalf("Expected Close to wait for the call in flight")
+	default:
+	}
+
+	r.shutWg.DoneSession()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("Expected Close to finish once the call was done")
+	}
+
+	// calls that outlast the drain timeout are cut off
+	r = &gRPCRunner{shutWg: common.NewWaitGroup(), address: "192.0.2.0", drainTimeout: 20 * time.Millisecond}
+	r.shutWg.AddSession(1)
+	defer r.shutWg.DoneSession()
+	start := time.Now()
+	err = r.Close(context.Background())
+	if err != nil || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("Expected Close to give up after the drain timeout, got %v after %v", err, time.Since(start))
+	}
+}
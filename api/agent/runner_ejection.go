@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorRunnerEjected is returned by TryExec while the runner is ejected, see
+// GRPCRunnerWithEjection
+var ErrorRunnerEjected = errors.New("Runner is ejected")
+
+// runnerEjector takes a runner out of the candidate set after consecutive placement or
+// transport failures. Unlike the circuit breaker, an ejected runner is not ready, so
+// placers do not try it at all. The penalty doubles with every ejection, and the count of
+// recent ejections decays by half every maxPenalty, so a runner that failed long ago is
+// ejected for the base penalty again. Once the penalty is over the runner takes canary
+// calls, one at a time and at most one per base penalty, and is reinstated after enough
+// of them succeed. A failed canary ejects it again. A nil ejector never ejects.
+type runnerEjector struct {
+	address    string
+	threshold  int
+	penalty    time.Duration
+	maxPenalty time.Duration
+	canaries   int
+
+	mtx      sync.Mutex
+	failures int
+	// recent ejections, decayed as of decayedAt
+	level     float64
+	decayedAt time.Time
+	ejected   bool
+	// while ejected, the runner takes no calls until ejectedUntil, then canaries
+	ejectedUntil   time.Time
+	canariesLeft   int
+	nextCanary     time.Time
+	canaryInFlight bool
+}
+
+type ejectionOutcome int
+
+const (
+	// the attempt says nothing about the runner's ability to take calls, eg. too busy
+	ejectionNeutral ejectionOutcome = iota
+	ejectionSuccess
+	ejectionFailure
+)
+
+// GRPCRunnerWithEjection ejects the runner from placement for penalty after threshold
+// consecutive placement or transport failures, doubling on every ejection up to
+// maxPenalty, then reinstates it after canaries successful canary calls.
+func GRPCRunnerWithEjection(threshold int, penalty, maxPenalty time.Duration, canaries int) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if r.ejector != nil {
+			return errors.New("Failed to create runner: ejection already set")
+		}
+		if threshold <= 0 || penalty <= 0 || maxPenalty < penalty || canaries <= 0 {
+			return fmt.Errorf("Invalid runner ejection threshold=%d penalty=%v max_penalty=%v canaries=%d", threshold, penalty, maxPenalty, canaries)
+		}
+		r.ejector = &runnerEjector{address: r.address, threshold: threshold, penalty: penalty, maxPenalty: maxPenalty, canaries: canaries}
+		return nil
+	}
+}
+
+// ready returns false while the runner is ejected and no canary call is due
+func (e *runnerEjector) ready() bool {
+	if e == nil {
+		return true
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return !e.ejected || e.canaryDueLocked(time.Now())
+}
+
+// allow returns false if the call should go to another runner, a call allowed while the
+// runner is ejected is a canary
+func (e *runnerEjector) allow() bool {
+	if e == nil {
+		return true
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if !e.ejected {
+		return true
+	}
+	now := time.Now()
+	if !e.canaryDueLocked(now) {
+		return false
+	}
+	e.canaryInFlight = true
+	e.nextCanary = now.Add(e.penalty)
+	return true
+}
+
+func (e *runnerEjector) canaryDueLocked(now time.Time) bool {
+	return !e.canaryInFlight && !now.Before(e.ejectedUntil) && !now.Before(e.nextCanary)
+}
+
+// done records the outcome of a call let through by allow
+func (e *runnerEjector) done(outcome ejectionOutcome) {
+	if e == nil {
+		return
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	wasCanary := e.canaryInFlight
+	e.canaryInFlight = false
+
+	switch outcome {
+	case ejectionSuccess:
+		e.failures = 0
+		if wasCanary {
+			e.canariesLeft--
+			if e.canariesLeft <= 0 {
+				e.ejected = false
+				statsRunnerEjected(context.Background(), -1)
+				logrus.WithField("runner_addr", e.address).Info("Runner reinstated")
+			}
+		}
+	case ejectionFailure:
+		e.failures++
+		if wasCanary || (!e.ejected && e.failures >= e.threshold) {
+			e.ejectLocked(time.Now())
+		}
+	}
+}
+
+// ejectLocked ejects the runner, or extends its ejection after a failed canary
+func (e *runnerEjector) ejectLocked(now time.Time) {
+	if !e.decayedAt.IsZero() {
+		e.level *= math.Pow(0.5, float64(now.Sub(e.decayedAt))/float64(e.maxPenalty))
+	}
+	e.decayedAt = now
+
+	penalty := time.Duration(float64(e.penalty) * math.Pow(2, e.level))
+	if penalty > e.maxPenalty || penalty <= 0 {
+		penalty = e.maxPenalty
+	}
+	e.level++
+
+	if !e.ejected {
+		statsRunnerEjected(context.Background(), 1)
+	}
+	e.ejected = true
+	e.failures = 0
+	e.ejectedUntil = now.Add(penalty)
+	e.nextCanary = e.ejectedUntil
+	e.canariesLeft = e.canaries
+	logrus.WithFields(logrus.Fields{"runner_addr": e.address, "penalty": penalty}).Info("Runner ejected")
+}
+
+// isEjected reports whether the runner is ejected, including while it takes canaries
+func (e *runnerEjector) isEjected() bool {
+	if e == nil {
+		return false
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.ejected
+}
+
+// ejectionOutcomeOf classifies the result of TryExec for the ejector. Only failures to
+// reach the runner or talk to it count, too busy NACKs are left to the circuit breaker.
+func ejectionOutcomeOf(placed bool, err error) ejectionOutcome {
+	if err != nil && status.Code(err) == codes.Unavailable {
+		return ejectionFailure
+	}
+	if err == ErrorPureRunnerNoEOF || err == ErrorRunnerProtocol {
+		return ejectionFailure
+	}
+	if placed {
+		return ejectionSuccess
+	}
+	return ejectionNeutral
+}
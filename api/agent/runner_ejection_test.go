@@ -0,0 +1,125 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRunnerEjector(t *testing.T) {
+	e := &runnerEjector{address: "192.0.2.0", threshold: 2, penalty: 20 * time.Millisecond, maxPenalty: time.Minute, canaries: 2}
+
+	e.done(ejectionFailure)
+	if !e.ready() || e.isEjected() {
+		t.Fatalf("Expected runner not ejected below threshold")
+	}
+	e.done(ejectionFailure)
+	if e.ready() || e.allow() || !e.isEjected() {
+		t.Fatalf("Expected runner ejected at threshold")
+	}
+
+	// canaries are let through one at a time, once per penalty
+	time.Sleep(25 * time.Millisecond)
+	if !e.ready() || !e.allow() {
+		t.Fatalf("Expected a canary call after the penalty")
+	}
+	if e.ready() || e.allow() {
+		t.Fatalf("Expected a single canary call")
+	}
+	e.done(ejectionSuccess)
+	if e.allow() || !e.isEjected() {
+		t.Fatalf("Expected the next canary to wait")
+	}
+
+	// a failed canary ejects the runner again, with twice the penalty
+	time.Sleep(25 * time.Millisecond)
+	if !e.allow() {
+		t.Fatalf("Expected a canary call after the penalty")
+	}
+	e.done(ejectionFailure)
+	if penalty := e.ejectedUntil.Sub(e.decayedAt); penalty < 39*time.Millisecond || penalty > 41*time.Millisecond {
+		t.Fatalf("Expected the penalty doubled, got %v", penalty)
+	}
+
+	time.Sleep(45 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if !e.allow() {
+			t.Fatalf("Expected canary call %d", i)
+		}
+		e.done(ejectionSuccess)
+		e.nextCanary = time.Time{}
+	}
+	if !e.ready() || !e.allow() || e.isEjected() {
+		t.Fatalf("Expected runner reinstated after successful canaries")
+	}
+
+	var nilEjector *runnerEjector
+	if !nilEjector.ready() || !nilEjector.allow() || nilEjector.isEjected() {
+		t.Fatalf("Expected nil ejector to never eject")
+	}
+	nilEjector.done(ejectionFailure)
+}
+
+func TestRunnerEjectorDecay(t *testing.T) {
+	e := &runnerEjector{address: "192.0.2.0", threshold: 1, penalty: time.Second, maxPenalty: time.Minute, canaries: 1}
+	now := time.Now()
+
+	e.ejectLocked(now)
+	e.ejectLocked(now)
+	if penalty := e.ejectedUntil.Sub(now); penalty != 2*time.Second {
+		t.Fatalf("Expected the penalty doubled, got %v", penalty)
+	}
+
+	// recent ejections halve every max penalty
+	later := now.Add(10 * time.Minute)
+	e.ejectLocked(later)
+	if penalty := e.ejectedUntil.Sub(later); penalty > 1010*time.Millisecond {
+		t.Fatalf("Expected the penalty decayed to the base penalty, got %v", penalty)
+	}
+}
+
+func TestRunnerEjectionOutcome(t *testing.T) {
+	tests := []struct {
+		placed   bool
+		err      error
+		expected ejectionOutcome
+	}{
+		{false, status.Error(codes.Unavailable, "connection refused"), ejectionFailure},
+		{true, status.Error(codes.Unavailable, "transport is closing"), ejectionFailure},
+		{true, ErrorPureRunnerNoEOF, ejectionFailure},
+		{false, models.ErrCallTimeoutServerBusy, ejectionNeutral},
+		{false, ErrorRunnerCircuitOpen, ejectionNeutral},
+		{true, nil, ejectionSuccess},
+		{true, models.ErrFunctionResponse, ejectionSuccess},
+	}
+	for i, tt := range tests {
+		if outcome := ejectionOutcomeOf(tt.placed, tt.err); outcome != tt.expected {
+			t.Fatalf("%d: expected outcome %d got %d for placed=%v err=%v", i, tt.expected, outcome, tt.placed, tt.err)
+		}
+	}
+}
+
+func TestTryExecEjected(t *testing.T) {
+	client := &mockRunnerProtocolClient{}
+	r := &gRPCRunner{shutWg: common.NewWaitGroup(), address: "192.0.2.0", clients: []pb.RunnerProtocolClient{client}}
+	err := GRPCRunnerWithEjection(1, time.Minute, time.Minute, 1)(r)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	r.ejector.done(ejectionFailure)
+
+	placed, err := r.TryExec(context.Background(), &mockRunnerCall{model: &models.Call{Type: models.TypeSync}})
+	if placed || err != ErrorRunnerEjected {
+		t.Fatalf("Expected not placed while ejected, got placed=%v err=%v", placed, err)
+	}
+	if client.engagements != 0 || !r.IsEjected() || r.Ready() {
+		t.Fatalf("Expected no engagement while ejected, got %d", client.engagements)
+	}
+}
@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+// callErrorStatus returns the google.rpc.Status a pure runner reports for a failed call
+// with the given HTTP status. Too busy rejections come with RetryInfo and a QuotaFailure
+// naming the reject reason, other errors that are not the user's fault with DebugInfo
+// holding the root cause, if known.
+func callErrorStatus(err error, httpCode int, user bool, reason pool.RejectReason) *spb.Status {
+	st := &spb.Status{Code: int32(grpcCodeOf(httpCode)), Message: err.Error()}
+
+	var details []proto.Message
+	switch {
+	case httpCode == models.ErrCallTimeoutServerBusy.Code():
+		details = append(details,
+			&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(0)},
+			&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{
+				{Subject: string(reason), Description: err.Error()},
+			}})
+	case !user:
+		if w, ok := err.(models.APIErrorWrapper); ok && w.RootError() != nil {
+			details = append(details, &errdetails.DebugInfo{Detail: w.RootError().Error()})
+		}
+	}
+
+	for _, d := range details {
+		any, err := ptypes.MarshalAny(d)
+		if err == nil {
+			st.Details = append(st.Details, any)
+		}
+	}
+	return st
+}
+
+// newFuncError returns the user error of a failed call, with the error name of msg, if any
+func newFuncError(msg *pb.CallFinished, err models.APIError) error {
+	if name := msg.GetErrorName(); name != "" {
+		return models.NewCodedFuncError(err, name)
+	}
+	return models.NewFuncError(err)
+}
+
+// callErrorFromStatus returns the error of a failed call from its google.rpc.Status, see
+// callErrorStatus. Too busy rejections are returned as RunnerBusyError, user errors as
+// models.FuncError and all others as RunnerCallError.
+func callErrorFromStatus(msg *pb.CallFinished, st *spb.Status) error {
+	code := int(msg.GetErrorCode())
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+	message := st.GetMessage()
+	if message == "" {
+		message = "Unknown Error From Pure Runner"
+	}
+
+	var quota *errdetails.QuotaFailure
+	var retry *errdetails.RetryInfo
+	var debug *errdetails.DebugInfo
+	for _, any := range st.GetDetails() {
+		var detail ptypes.DynamicAny
+		if ptypes.UnmarshalAny(any, &detail) != nil {
+			// a detail we do not know about
+			continue
+		}
+		switch d := detail.Message.(type) {
+		case *errdetails.QuotaFailure:
+			quota = d
+		case *errdetails.RetryInfo:
+			retry = d
+		case *errdetails.DebugInfo:
+			debug = d
+		}
+	}
+
+	if quota != nil && code == models.ErrCallTimeoutServerBusy.Code() {
+		var reason pool.RejectReason
+		if len(quota.GetViolations()) != 0 {
+			reason = pool.RejectReason(quota.GetViolations()[0].GetSubject())
+		}
+		return &pool.RunnerBusyError{Reason: reason}
+	}
+
+	apiErr := models.NewAPIError(code, errors.New(message))
+	if msg.GetErrorUser() {
+		return newFuncError(msg, apiErr)
+	}
+
+	e := &pool.RunnerCallError{APIError: apiErr, Class: pool.ErrorClassInfra}
+	if quota != nil {
+		e.Class = pool.ErrorClassQuota
+	}
+	if retry != nil {
+		e.Retriable = true
+		e.RetryDelay, _ = ptypes.Duration(retry.GetRetryDelay())
+	}
+	if debug != nil {
+		e.Debug = debug.GetDetail()
+	}
+	return e
+}
+
+// grpcCodeOf returns the gRPC code closest to an HTTP status
+func grpcCodeOf(httpCode int) codes.Code {
+	switch httpCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	case models.ErrClientCancel.Code():
+		return codes.Canceled
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	switch {
+	case httpCode >= 500:
+		return codes.Internal
+	case httpCode >= 400:
+		return codes.FailedPrecondition
+	}
+	return codes.Unknown
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/golang/protobuf/proto"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+// finishedWithError returns the finish message a runner sends for a call failed with err,
+// after going through the wire encoding
+func finishedWithError(t *testing.T, err error, reason pool.RejectReason) *pb.CallFinished {
+	code := models.GetAPIErrorCode(err)
+	user := models.IsFuncError(err)
+	buf, err := proto.Marshal(&pb.CallFinished{
+		ErrorCode:   int32(code),
+		ErrorStr:    err.Error(),
+		ErrorUser:   user,
+		ErrorName:   models.GetErrorCode(err),
+		ErrorStatus: callErrorStatus(err, code, user, reason),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg pb.CallFinished
+	err = proto.Unmarshal(buf, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &msg
+}
+
+func TestRunnerErrorStatus(t *testing.T) {
+	msg := finishedWithError(t, models.ErrCallTimeoutServerBusy, pool.RejectMemory)
+	if codes.Code(msg.ErrorStatus.Code) != codes.Unavailable || len(msg.ErrorStatus.Details) != 2 {
+		t.Fatalf("Expected unavailable status with retry and quota details, got %v", msg.ErrorStatus)
+	}
+	err := parseError(msg)
+	if reason, ok := pool.RejectReasonOf(err); !ok || reason != pool.RejectMemory || pool.ErrorClassOf(err) != pool.ErrorClassQuota {
+		t.Fatalf("Expected quota error rejected for memory, got %v", err)
+	}
+
+	err = parseError(finishedWithError(t, models.ErrFunctionResponseTooBig, ""))
+	if !models.IsFuncError(err) || pool.ErrorClassOf(err) != pool.ErrorClassUser || models.GetAPIErrorCode(err) != models.ErrFunctionResponseTooBig.Code() {
+		t.Fatalf("Expected user error, got %v", err)
+	}
+
+	oom := models.NewFuncOOMError(128, 127)
+	err = parseError(finishedWithError(t, oom, ""))
+	if !models.IsFuncError(err) || models.GetErrorCode(err) != models.ErrorCodeFunctionOOM || err.Error() != oom.Error() {
+		t.Fatalf("Expected out of memory user error, got %v", err)
+	}
+
+	infra := models.NewAPIError(http.StatusInternalServerError, errors.New("Failed to start container"))
+	root := errors.New("docker daemon unreachable")
+	err = parseError(finishedWithError(t, models.NewAPIErrorWrapper(infra, root), ""))
+	callErr, ok := err.(*pool.RunnerCallError)
+	if !ok || callErr.Class != pool.ErrorClassInfra || callErr.Debug != root.Error() || callErr.Retriable {
+		t.Fatalf("Expected infrastructure error with debug info, got %#v", err)
+	}
+	if callErr.Code() != infra.Code() || callErr.Error() != infra.Error() {
+		t.Fatalf("Expected code and message of the runner error, got %d %q", callErr.Code(), callErr.Error())
+	}
+
+	// details we do not know about are skipped
+	msg = finishedWithError(t, models.ErrCallTimeoutServerBusy, pool.RejectMemory)
+	msg.ErrorStatus.Details[0].TypeUrl = "type.googleapis.com/example.Unknown"
+	err = parseError(msg)
+	if reason, ok := pool.RejectReasonOf(err); !ok || reason != pool.RejectMemory {
+		t.Fatalf("Expected rejection for memory, got %v", err)
+	}
+
+	err = parseError(&pb.CallFinished{ErrorCode: 500, ErrorStatus: &spb.Status{Code: int32(codes.Internal)}})
+	if pool.ErrorClassOf(err) != pool.ErrorClassInfra || err.Error() != "Unknown Error From Pure Runner" {
+		t.Fatalf("Expected unknown infrastructure error, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MaxInvokeRequestBody is the largest invoke threshold, see GRPCRunnerWithInvokeThreshold
+	MaxInvokeRequestBody = 1024 * 1024
+	// MaxInvokeResponseBody is the largest response body a pure runner returns from Invoke,
+	// which keeps the response below the default gRPC message size limit of 4MB
+	MaxInvokeResponseBody = 3 * 1024 * 1024
+)
+
+var errInvokeUnimplemented = errors.New("Runner does not implement Invoke")
+
+// GRPCRunnerWithInvokeThreshold makes TryExec run calls with a request body of at most
+// threshold bytes with a single Invoke request instead of an engagement stream. The
+// runner fails such calls with ErrFunctionResponseTooBig if the response body exceeds
+// MaxInvokeResponseBody. Runners that do not implement Invoke are engaged as before.
+// Invoke is not used while session recording is enabled.
+func GRPCRunnerWithInvokeThreshold(threshold int) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if threshold < 0 || threshold > MaxInvokeRequestBody {
+			return fmt.Errorf("Invalid invoke threshold %d", threshold)
+		}
+		r.invokeThreshold = threshold
+		return nil
+	}
+}
+
+// useInvoke reports whether calls with small bodies should be invoked on the runner
+func (r *gRPCRunner) useInvoke() bool {
+	return r.invokeThreshold > 0 && r.recording == nil && atomic.LoadInt32(&r.invokeUnsupported) == 0
+}
+
+// readSmallBody reads body if it is at most threshold bytes. Otherwise the bytes read
+// so far are returned and the rest of the body is left unread.
+func readSmallBody(body io.Reader, threshold int) ([]byte, bool, error) {
+	buf, err := ioutil.ReadAll(io.LimitReader(body, int64(threshold)+1))
+	return buf, err == nil && len(buf) <= threshold, err
+}
+
+// invoke runs the call with a single Invoke request and processes the response like
+// the messages of an engagement. It returns errInvokeUnimplemented if the runner does
+// not implement Invoke, in which case the call was not placed.
+func (r *gRPCRunner) invoke(ctx context.Context, call pool.RunnerCall, tryCall *pb.TryCall, body []byte, callOpts []grpc.CallOption, ack func() bool) (bool, error) {
+	log := common.Logger(ctx).WithField("runner_addr", r.address)
+
+	// there is no stream to ack the response on, the runner sends it in one message
+	if tryCall.ResponseWindow != 0 {
+		tryCall = proto.Clone(tryCall).(*pb.TryCall)
+		tryCall.ResponseWindow = 0
+	}
+
+	resp, err := r.client().Invoke(ctx, &pb.InvokeRequest{Try: tryCall, Body: body}, callOpts...)
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			log.Info("Runner does not implement Invoke, using engagements")
+			atomic.StoreInt32(&r.invokeUnsupported, 1)
+			return false, errInvokeUnimplemented
+		}
+		// We are going to retry on a different runner, it is ok to log this error as Info
+		log.WithError(err).Info("Failed to invoke call on runner node")
+		statsRunnerStreamError(ctx, r.address, runnerErrorSend)
+		// as with engagements, only codes.Unavailable tells us the runner did not get the call
+		return status.Code(err) != codes.Unavailable, err
+	}
+
+	done := make(chan error, 1)
+	receiveFromRunner(ctx, &invokeEngageClient{msgs: resp.GetMsgs()}, r.address, call, receiveOptions{
+		onFinish:             r.handleCallEvent,
+		onAccept:             ack,
+		verifyResponseHash:   r.verifyResponseHash,
+		verifyResponseCRC32C: r.verifyResponseCRC32C,
+		strictMessages:       atomic.LoadInt32(&r.capsState) > 0,
+	}, done)
+
+	var recvErr error
+	for e := range done {
+		recvErr = e
+	}
+	return r.placementResult(ctx, recvErr)
+}
+
+// invokeEngageClient plays back the messages of an Invoke response
+type invokeEngageClient struct {
+	pb.RunnerProtocol_EngageClient
+	msgs []*pb.RunnerMsg
+}
+
+func (c *invokeEngageClient) Recv() (*pb.RunnerMsg, error) {
+	if len(c.msgs) == 0 {
+		return nil, io.EOF
+	}
+	msg := c.msgs[0]
+	c.msgs = c.msgs[1:]
+	return msg, nil
+}
@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// invokeRunnerProtocolClient answers Invoke with msgs, or with err if set
+type invokeRunnerProtocolClient struct {
+	scriptedRunnerProtocolClient
+	msgs     []*pb.RunnerMsg
+	err      error
+	requests []*pb.InvokeRequest
+}
+
+func (c *invokeRunnerProtocolClient) Invoke(ctx context.Context, in *pb.InvokeRequest, opts ...grpc.CallOption) (*pb.InvokeResponse, error) {
+	c.requests = append(c.requests, in)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &pb.InvokeResponse{Msgs: c.msgs}, nil
+}
+
+func TestTryExecInvoke(t *testing.T) {
+	finished := &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}}
+
+	for _, tc := range []struct {
+		body        string
+		invokeErr   error
+		invokes     int
+		engagements int
+	}{
+		{"small", nil, 1, 0},
+		{"larger than the threshold", nil, 0, 1},
+		// runners without Invoke are engaged instead, and not invoked again
+		{"small", status.Error(codes.Unimplemented, "unknown method"), 1, 1},
+	} {
+		client := &invokeRunnerProtocolClient{
+			scriptedRunnerProtocolClient: scriptedRunnerProtocolClient{engagements: []*mockEngageClient{
+				{msgs: []*pb.RunnerMsg{dataMsg("engaged"), finished}},
+			}},
+			msgs: []*pb.RunnerMsg{dataMsg("invoked"), finished},
+			err:  tc.invokeErr,
+		}
+		r := &gRPCRunner{
+			shutWg:          common.NewWaitGroup(),
+			address:         "192.0.2.0",
+			clients:         []pb.RunnerProtocolClient{client},
+			maxDataChunk:    MaxDataChunk,
+			advertisedChunk: -1,
+		}
+		err := GRPCRunnerWithInvokeThreshold(len("small"))(r)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		rw := httptest.NewRecorder()
+		call := &mockRunnerCall{
+			r:     httptest.NewRequest("POST", "/", strings.NewReader(tc.body)),
+			rw:    rw,
+			model: &models.Call{ID: "call1", Type: models.TypeSync},
+		}
+		placed, err := r.TryExec(context.Background(), call)
+		if !placed || err != nil {
+			t.Fatalf("Expected placed call, got placed=%v err=%v", placed, err)
+		}
+		if len(client.requests) != tc.invokes || 1-len(client.engagements) != tc.engagements {
+			t.Fatalf("Expected %d invokes and %d engagements, got %d and %d", tc.invokes, tc.engagements, len(client.requests), 1-len(client.engagements))
+		}
+
+		expected := "invoked"
+		if tc.engagements != 0 {
+			expected = "engaged"
+		} else if string(client.requests[0].Body) != tc.body || client.requests[0].Try == nil {
+			t.Fatalf("Expected invoke request with body %q, got %v", tc.body, client.requests[0])
+		}
+		if rw.Body.String() != expected {
+			t.Fatalf("Expected response %q, got %q", expected, rw.Body.String())
+		}
+		if tc.invokeErr != nil && r.useInvoke() {
+			t.Fatalf("Expected invoke disabled for runner without Invoke")
+		}
+	}
+
+	err := GRPCRunnerWithInvokeThreshold(MaxInvokeRequestBody + 1)(&gRPCRunner{})
+	if err == nil {
+		t.Fatalf("Expected an error for an invoke threshold above %d", MaxInvokeRequestBody)
+	}
+}
+
+// Invoke responses come in one message without acks, a response larger than the response
+// window must not wait for the window to open
+func TestTryExecInvokeResponseWindow(t *testing.T) {
+	finished := &pb.RunnerMsg{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true}}}
+	response := strings.Repeat("x", 64)
+
+	client := &invokeRunnerProtocolClient{msgs: []*pb.RunnerMsg{dataMsg(response[:32]), dataMsg(response[32:]), finished}}
+	r := &gRPCRunner{
+		shutWg:          common.NewWaitGroup(),
+		address:         "192.0.2.0",
+		clients:         []pb.RunnerProtocolClient{client},
+		maxDataChunk:    MaxDataChunk,
+		advertisedChunk: -1,
+	}
+	for _, opt := range []GRPCRunnerOption{GRPCRunnerWithInvokeThreshold(1024), GRPCRunnerWithResponseWindow(16)} {
+		if err := opt(r); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+
+	rw := httptest.NewRecorder()
+	call := &mockRunnerCall{
+		r:     httptest.NewRequest("POST", "/", strings.NewReader("small")),
+		rw:    rw,
+		model: &models.Call{ID: "call1", Type: models.TypeSync},
+	}
+	placed, err := r.TryExec(context.Background(), call)
+	if !placed || err != nil {
+		t.Fatalf("Expected placed call, got placed=%v err=%v", placed, err)
+	}
+	if len(client.requests) != 1 || client.requests[0].Try.ResponseWindow != 0 {
+		t.Fatalf("Expected one invoke request without response window, got %v", client.requests)
+	}
+	if rw.Body.String() != response {
+		t.Fatalf("Expected response %q, got %q", response, rw.Body.String())
+	}
+}
+
+func TestUnaryEngagementResponseTooBig(t *testing.T) {
+	e := &unaryEngagement{ctx: context.Background()}
+	msgs := []*pb.RunnerMsg{
+		{Body: &pb.RunnerMsg_ResultStart{ResultStart: &pb.CallResultStart{}}},
+		{Body: &pb.RunnerMsg_Data{Data: &pb.DataFrame{Data: make([]byte, MaxInvokeResponseBody)}}},
+		{Body: &pb.RunnerMsg_Data{Data: &pb.DataFrame{Data: []byte("x")}}},
+		{Body: &pb.RunnerMsg_Finished{Finished: &pb.CallFinished{Success: true, Details: "call1"}}},
+	}
+	for _, msg := range msgs {
+		err := e.Send(msg)
+		if err != nil {
+			t.Fatalf("Unexpected send error %v", err)
+		}
+	}
+
+	out, finished := e.messages()
+	if !finished || len(out) != 1 {
+		t.Fatalf("Expected only the finish message, got %d messages finished=%v", len(out), finished)
+	}
+	fin := out[0].GetFinished()
+	if fin.Success || fin.ErrorCode != int32(models.GetAPIErrorCode(models.ErrFunctionResponseTooBig)) || fin.Details != "call1" {
+		t.Fatalf("Expected failed call with response too big, got %v", fin)
+	}
+}
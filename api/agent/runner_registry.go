@@ -0,0 +1,55 @@
+package agent
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/fnproject/fn/api/models"
+)
+
+// RunnerRegistration is what a pure runner registers about itself in a runner registry,
+// see RegisterConsulRunner
+type RunnerRegistration struct {
+	// Address is the address LBs reach the runner's gRPC server at
+	Address string `json:"address"`
+	// Capacity is the relative share of calls the runner can take, used as its weight
+	Capacity int `json:"capacity,omitempty"`
+	// Labels describe the runner, eg. its zone or hardware
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// runnerAddress returns the runner address entry of the registration, see parseRunnerAddress
+func (reg *RunnerRegistration) runnerAddress() string {
+	return runnerAddressEntry(reg.Address, reg.Capacity, reg.Labels)
+}
+
+// ParseRunnerLabels parses runner labels of the form "key=value,key=value"
+func ParseRunnerLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("Invalid runner label %q", kv)
+		}
+		labels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return labels, nil
+}
+
+// WithPlatformLabels adds the os and arch of the runner to labels, for the calls of fns with
+// models.PlatformAnnotation, unless labels already have them, eg. for a runner in front of
+// a remote docker daemon of another platform
+func WithPlatformLabels(labels map[string]string) map[string]string {
+	if _, ok := labels[models.RunnerOSLabel]; !ok {
+		labels[models.RunnerOSLabel] = runtime.GOOS
+	}
+	if _, ok := labels[models.RunnerArchLabel]; !ok {
+		labels[models.RunnerArchLabel] = runtime.GOARCH
+	}
+	return labels
+}
@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	pool "github.com/fnproject/fn/api/runnerpool"
+)
+
+// GRPCRunnerWithStatusCache makes Status return the result of the last Status call
+// for ttl plus a random jitter of up to jitter, so that health checks and placers
+// polling many runners do not flood them with Status calls. Concurrent callers
+// share a single Status call. Contexts from runnerpool.WithStatusRefresh bypass
+// the cache. Cached results have Cached set.
+func GRPCRunnerWithStatusCache(ttl, jitter time.Duration) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if ttl <= 0 || jitter < 0 {
+			return errors.New("Failed to create runner: invalid status cache ttl or jitter")
+		}
+		r.statusCache = &runnerStatusCache{ttl: ttl, jitter: jitter}
+		return nil
+	}
+}
+
+// runnerStatusCache caches the result of gRPCRunner Status calls
+type runnerStatusCache struct {
+	ttl    time.Duration
+	jitter time.Duration
+
+	// mtx protects the fields below. wait is closed when the Status call in
+	// progress, if any, is done.
+	mtx    sync.Mutex
+	expiry time.Time
+	status *pool.RunnerStatus
+	err    error
+	wait   chan struct{}
+}
+
+// get returns the cached status, calling fetch if it expired
+func (c *runnerStatusCache) get(ctx context.Context, fetch func(context.Context) (*pool.RunnerStatus, error)) (*pool.RunnerStatus, error) {
+	c.mtx.Lock()
+	if time.Now().Before(c.expiry) {
+		defer c.mtx.Unlock()
+		return c.cached()
+	}
+	wait := c.wait
+	if wait == nil {
+		wait = make(chan struct{})
+		c.wait = wait
+		c.mtx.Unlock()
+		return c.refresh(ctx, fetch, wait)
+	}
+	c.mtx.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-wait:
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.status == nil && c.err == nil {
+		// the Status call was cancelled by its caller, nothing to share
+		return fetch(ctx)
+	}
+	return c.cached()
+}
+
+// refresh calls fetch and caches its result, then wakes up the callers waiting for it
+func (c *runnerStatusCache) refresh(ctx context.Context, fetch func(context.Context) (*pool.RunnerStatus, error), wait chan struct{}) (*pool.RunnerStatus, error) {
+	status, err := fetch(ctx)
+
+	c.mtx.Lock()
+	if ctx.Err() == nil {
+		c.store(status, err)
+	} else {
+		// do not share the result of a call cancelled by its caller
+		c.status, c.err = nil, nil
+	}
+	c.wait = nil
+	c.mtx.Unlock()
+
+	close(wait)
+	return status, err
+}
+
+// set caches the result of a Status call made without the cache, eg. a forced refresh
+func (c *runnerStatusCache) set(ctx context.Context, status *pool.RunnerStatus, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	c.mtx.Lock()
+	c.store(status, err)
+	c.mtx.Unlock()
+}
+
+// store caches a copy of a Status result and sets its expiry, c.mtx must be held
+func (c *runnerStatusCache) store(status *pool.RunnerStatus, err error) {
+	if status != nil {
+		cp := *status
+		status = &cp
+	}
+	c.status, c.err = status, err
+	c.expiry = time.Now().Add(c.ttl)
+	if c.jitter > 0 {
+		c.expiry = c.expiry.Add(time.Duration(rand.Int63n(int64(c.jitter))))
+	}
+}
+
+// cached returns a copy of the cached status, c.mtx must be held
+func (c *runnerStatusCache) cached() (*pool.RunnerStatus, error) {
+	if c.status == nil {
+		return nil, c.err
+	}
+	status := *c.status
+	status.Cached = true
+	return &status, c.err
+}
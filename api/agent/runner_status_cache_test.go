@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+)
+
+// countingStatusClient counts Status calls, which block until release is closed if set
+type countingStatusClient struct {
+	pb.RunnerProtocolClient
+	calls   int32
+	release chan struct{}
+}
+
+func (c *countingStatusClient) Status(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*pb.RunnerStatus, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if c.release != nil {
+		<-c.release
+	}
+	return &pb.RunnerStatus{Active: 1}, nil
+}
+
+func newStatusCacheRunner(t *testing.T, client pb.RunnerProtocolClient, ttl time.Duration) *gRPCRunner {
+	r := &gRPCRunner{shutWg: common.NewWaitGroup(), address: "192.0.2.0", clients: []pb.RunnerProtocolClient{client}}
+	err := GRPCRunnerWithStatusCache(ttl, ttl/2)(r)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	return r
+}
+
+func TestRunnerStatusCache(t *testing.T) {
+	client := &countingStatusClient{}
+	r := newStatusCacheRunner(t, client, time.Minute)
+	ctx := context.Background()
+
+	for i, cached := range []bool{false, true, true} {
+		status, err := r.Status(ctx)
+		if err != nil || status.ActiveRequestCount != 1 || status.Cached != cached {
+			t.Fatalf("Expected status cached=%v on call %d, got %+v err=%v", cached, i, status, err)
+		}
+	}
+	if client.calls != 1 {
+		t.Fatalf("Expected a single Status call, got %d", client.calls)
+	}
+
+	status, err := r.Status(pool.WithStatusRefresh(ctx))
+	if err != nil || status.Cached || client.calls != 2 {
+		t.Fatalf("Expected forced Status call, got %+v err=%v calls=%d", status, err, client.calls)
+	}
+
+	r = newStatusCacheRunner(t, client, time.Millisecond)
+	r.Status(ctx)
+	time.Sleep(5 * time.Millisecond)
+	r.Status(ctx)
+	if client.calls != 4 {
+		t.Fatalf("Expected Status call once the cached status expired, got %d calls", client.calls)
+	}
+}
+
+func TestRunnerStatusCacheSharesCalls(t *testing.T) {
+	client := &countingStatusClient{release: make(chan struct{})}
+	r := newStatusCacheRunner(t, client, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, err := r.Status(context.Background())
+			if err != nil || status.ActiveRequestCount != 1 {
+				t.Errorf("Unexpected status %+v err=%v", status, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(client.release)
+	wg.Wait()
+
+	if client.calls != 1 {
+		t.Fatalf("Expected concurrent callers to share a Status call, got %d calls", client.calls)
+	}
+
+	err := GRPCRunnerWithStatusCache(0, 0)(&gRPCRunner{})
+	if err == nil {
+		t.Fatalf("Expected an error for a zero status cache ttl")
+	}
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	"github.com/sirupsen/logrus"
+)
+
+// secretsDockerMountDest is the mount path inside of the container of the directory that
+// secrets injected as files are written to
+const secretsDockerMountDest = "/run/secrets"
+
+// injectSecrets resolves the secrets of call from the secret store of the agent and injects
+// them into c before it is created, see models.SecretsAnnotation. Secrets injected as files
+// are written to a directory like the iofs of c, a per container tmpfs if the iofs is one,
+// which is mounted at secretsDockerMountDest and removed once c is closed.
+func (a *agent) injectSecrets(ctx context.Context, call *call, c *container) error {
+	secrets, err := call.Annotations.Secrets()
+	if err != nil {
+		return err
+	}
+	if len(secrets) == 0 {
+		return nil
+	}
+	if a.secrets == nil {
+		return models.ErrSecretsUnsupported
+	}
+
+	logger := common.Logger(ctx)
+	var dir iofs
+	for _, s := range secrets {
+		v, err := a.secrets.Get(ctx, a.secretRef(call, s.Ref))
+		if err != nil {
+			// the error may carry the secret store address, the call only learns which secret failed
+			logger.WithError(err).WithFields(logrus.Fields{"secret_ref": s.Ref}).Error("failed to resolve secret")
+			return models.ErrSecretsUnavailable
+		}
+		if s.Env != "" {
+			c.env[s.Env] = string(v)
+			continue
+		}
+
+		if dir == nil {
+			dir, err = newSecretsDir(ctx, &a.cfg)
+			if err != nil {
+				return err
+			}
+			closeDir := dir
+			c.WrapClose(func(closer func()) func() {
+				return func() {
+					closer()
+					if err := closeDir.Close(); err != nil {
+						logger.WithError(err).Error("Error closing secrets dir")
+					}
+				}
+			})
+			c.volumes = append(c.volumes, [2]string{dir.DockerPath(), secretsDockerMountDest})
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir.AgentPath(), s.File), v, 0444); err != nil {
+			return fmt.Errorf("cannot write secret file: %v", err)
+		}
+	}
+	return nil
+}
+
+// secretRef returns the reference in the secret store of the secret ref of the app of call,
+// relative to the secrets prefix of the app, see EnvSecretsPrefix. Refs must not escape the
+// prefix, see models.ValidSecretRef.
+func (a *agent) secretRef(call *call, ref string) string {
+	return strings.Replace(a.cfg.SecretsPrefix, "{app_id}", call.AppID, -1) + ref
+}
+
+// newSecretsDir creates the directory of the secrets of a container injected as files, on
+// a tmpfs if the iofs of containers is one
+func newSecretsDir(ctx context.Context, cfg *Config) (iofs, error) {
+	if cfg.IOFSEnableTmpfs {
+		return newTmpfsIOFS(ctx, cfg)
+	}
+	return newDirectoryIOFS(ctx, cfg)
+}
@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fnproject/fn/api/id"
+	"github.com/fnproject/fn/api/models"
+)
+
+type mapSecretStore map[string]string
+
+func (s mapSecretStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	v, ok := s[ref]
+	if !ok {
+		return nil, errors.New("no such secret")
+	}
+	return []byte(v), nil
+}
+
+func TestInjectSecrets(t *testing.T) {
+	cfg, err := NewConfig()
+	if err != nil {
+		t.Fatalf("bad config %+v", cfg)
+	}
+	cfg.IOFSAgentPath, err = ioutil.TempDir("", "fn-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cfg.IOFSAgentPath)
+	a := &agent{cfg: *cfg, secrets: mapSecretStore{"secret/data/fn/app1/db#password": "hunter2", "secret/data/fn/app1/tls#key": "private"}}
+
+	newContainer := func(appID string, secrets ...models.Secret) (*call, *container) {
+		annotations, err := models.EmptyAnnotations().With(models.SecretsAnnotation, secrets)
+		if err != nil {
+			t.Fatal(err)
+		}
+		call := &call{Call: &models.Call{ID: id.New().String(), AppID: appID, Image: "fnproject/fn-test-utils", Annotations: annotations}}
+		errC := make(chan error, 10)
+		c := newHotContainer(context.TODO(), nil, nil, call, &a.cfg, nil, id.New().String(), "", errC)
+		if c == nil {
+			t.Fatal("got unexpected err: ", <-errC)
+		}
+		return call, c
+	}
+
+	call, c := newContainer("app1", models.Secret{Ref: "db#password", Env: "DB_PASSWORD"}, models.Secret{Ref: "tls#key", File: "tls.key"})
+	if err := a.injectSecrets(context.TODO(), call, c); err != nil {
+		t.Fatal(err)
+	}
+	if c.EnvVars()["DB_PASSWORD"] != "hunter2" {
+		t.Fatalf("expected the secret in the env, got %v", c.EnvVars())
+	}
+	if len(c.Volumes()) != 1 || c.Volumes()[0][1] != secretsDockerMountDest {
+		t.Fatalf("expected the secrets dir to be mounted, got %v", c.Volumes())
+	}
+	dir := c.Volumes()[0][0]
+	b, err := ioutil.ReadFile(filepath.Join(dir, "tls.key"))
+	if err != nil || string(b) != "private" {
+		t.Fatalf("expected the secret in a file, got %s %v", b, err)
+	}
+	c.Close()
+	if _, err := ioutil.ReadDir(dir); err == nil {
+		t.Fatal("expected the secrets dir to be removed once the container is closed")
+	}
+
+	call, c = newContainer("app1", models.Secret{Ref: "db#user", Env: "DB_USER"})
+	defer c.Close()
+	if err := a.injectSecrets(context.TODO(), call, c); err != models.ErrSecretsUnavailable {
+		t.Fatalf("expected unavailable secrets, got %v", err)
+	}
+
+	// the secrets of an app are out of reach of the fns of others
+	other, otherC := newContainer("app2", models.Secret{Ref: "db#password", Env: "DB_PASSWORD"})
+	defer otherC.Close()
+	if err := a.injectSecrets(context.TODO(), other, otherC); err != models.ErrSecretsUnavailable {
+		t.Fatalf("expected the secrets of another app to be unavailable, got %v", err)
+	}
+
+	a.secrets = nil
+	if err := a.injectSecrets(context.TODO(), call, c); err != models.ErrSecretsUnsupported {
+		t.Fatalf("expected unsupported secrets, got %v", err)
+	}
+}
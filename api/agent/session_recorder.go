@@ -0,0 +1,277 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/common"
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/sirupsen/logrus"
+)
+
+// Session recording writes the runner protocol messages exchanged during a TryExec
+// to a file, one JSON record per line. Recorded sessions can be fed back into
+// receiveFromRunner with ReplaySession to reproduce runner interactions.
+
+const (
+	sessionSend = "send"
+	sessionRecv = "recv"
+)
+
+// sessionRecord is a single line of a recorded session. Msg is the jsonpb encoding
+// of a ClientMsg (send) or RunnerMsg (recv). Recv errors are recorded in Error,
+// with io.EOF recorded as "EOF".
+type sessionRecord struct {
+	Time  time.Time       `json:"time"`
+	Dir   string          `json:"dir"`
+	Msg   json.RawMessage `json:"msg,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+type sessionRecordingConfig struct {
+	dir        string
+	maxBytes   int64
+	redactBody bool
+}
+
+// GRPCRunnerWithSessionRecording records every runner engagement to a file in dir.
+// Each recording stops once it reaches maxBytes. If redactBody is set, the data
+// of request and response frames is not recorded, only its length.
+func GRPCRunnerWithSessionRecording(dir string, maxBytes int64, redactBody bool) GRPCRunnerOption {
+	return func(r *gRPCRunner) error {
+		if r.recording != nil {
+			return errors.New("Failed to create runner: session recording already configured")
+		}
+		if maxBytes <= 0 {
+			return fmt.Errorf("Invalid session recording size limit %d", maxBytes)
+		}
+		err := os.MkdirAll(dir, 0750)
+		if err != nil {
+			return err
+		}
+		r.recording = &sessionRecordingConfig{dir: dir, maxBytes: maxBytes, redactBody: redactBody}
+		return nil
+	}
+}
+
+// recordingEngageClient records messages going through the wrapped engagement
+type recordingEngageClient struct {
+	pb.RunnerProtocol_EngageClient
+
+	cfg     *sessionRecordingConfig
+	mtx     sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	written int64
+	log     logrus.FieldLogger
+}
+
+func newRecordingEngageClient(ctx context.Context, client pb.RunnerProtocol_EngageClient, cfg *sessionRecordingConfig, runnerAddress string, call pool.RunnerCall) *recordingEngageClient {
+	log := common.Logger(ctx).WithField("runner_addr", runnerAddress)
+
+	callID := "unknown"
+	if call.Model() != nil && call.Model().ID != "" {
+		callID = call.Model().ID
+	}
+	name := fmt.Sprintf("%s-%s-%d.session", callID, strings.Replace(runnerAddress, ":", "_", -1), time.Now().UnixNano())
+	path := filepath.Join(cfg.dir, filepath.Base(name))
+
+	rec := &recordingEngageClient{
+		RunnerProtocol_EngageClient: client,
+		cfg:                         cfg,
+		log:                         log,
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		log.WithError(err).Error("Failed to create session recording, not recording")
+		return rec
+	}
+	rec.file = file
+	rec.w = bufio.NewWriter(file)
+	log.WithField("path", path).Debug("Recording runner session")
+	return rec
+}
+
+func (c *recordingEngageClient) record(dir string, msg proto.Message, recvErr error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.w == nil {
+		return
+	}
+
+	entry := sessionRecord{Time: time.Now(), Dir: dir}
+	if recvErr != nil {
+		entry.Error = recvErr.Error()
+	} else {
+		if c.cfg.redactBody {
+			msg = redactSessionMsg(msg)
+		}
+		buf, err := (&jsonpb.Marshaler{}).MarshalToString(msg)
+		if err != nil {
+			c.log.WithError(err).Error("Failed to encode session message")
+			return
+		}
+		entry.Msg = json.RawMessage(buf)
+	}
+
+	line, err := json.Marshal(&entry)
+	if err != nil {
+		c.log.WithError(err).Error("Failed to encode session record")
+		return
+	}
+	if c.written+int64(len(line))+1 > c.cfg.maxBytes {
+		c.log.Warn("Session recording reached its size limit, recording stopped")
+		c.closeLocked()
+		return
+	}
+	c.written += int64(len(line)) + 1
+	c.w.Write(line)
+	c.w.WriteByte('\n')
+}
+
+// redactSessionMsg returns a copy of msg with data frame contents replaced by
+// zero bytes of the same length
+func redactSessionMsg(msg proto.Message) proto.Message {
+	switch m := msg.(type) {
+	case *pb.ClientMsg:
+		if body, ok := m.Body.(*pb.ClientMsg_Data); ok {
+			return &pb.ClientMsg{Body: &pb.ClientMsg_Data{Data: redactDataFrame(body.Data)}}
+		}
+	case *pb.RunnerMsg:
+		switch body := m.Body.(type) {
+		case *pb.RunnerMsg_Data:
+			return &pb.RunnerMsg{Body: &pb.RunnerMsg_Data{Data: redactDataFrame(body.Data)}}
+		case *pb.RunnerMsg_Log:
+			return &pb.RunnerMsg{Body: &pb.RunnerMsg_Log{Log: &pb.LogFrame{Data: make([]byte, len(body.Log.GetData()))}}}
+		}
+	}
+	return msg
+}
+
+func redactDataFrame(frame *pb.DataFrame) *pb.DataFrame {
+	return &pb.DataFrame{Data: make([]byte, len(frame.GetData())), Eof: frame.GetEof()}
+}
+
+func (c *recordingEngageClient) Send(msg *pb.ClientMsg) error {
+	c.record(sessionSend, msg, nil)
+	return c.RunnerProtocol_EngageClient.Send(msg)
+}
+
+func (c *recordingEngageClient) Recv() (*pb.RunnerMsg, error) {
+	msg, err := c.RunnerProtocol_EngageClient.Recv()
+	c.record(sessionRecv, msg, err)
+	return msg, err
+}
+
+// Close flushes and closes the recording, further messages are not recorded
+func (c *recordingEngageClient) Close() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.closeLocked()
+}
+
+func (c *recordingEngageClient) closeLocked() {
+	if c.w == nil {
+		return
+	}
+	err := c.w.Flush()
+	if err != nil {
+		c.log.WithError(err).Error("Failed to write session recording")
+	}
+	err = c.file.Close()
+	if err != nil {
+		c.log.WithError(err).Error("Failed to close session recording")
+	}
+	c.w = nil
+	c.file = nil
+}
+
+// replayEngageClient plays back the runner messages of a recorded session
+type replayEngageClient struct {
+	pb.RunnerProtocol_EngageClient
+	records []sessionRecord
+}
+
+func (c *replayEngageClient) Send(msg *pb.ClientMsg) error {
+	return nil
+}
+
+func (c *replayEngageClient) CloseSend() error {
+	return nil
+}
+
+func (c *replayEngageClient) Recv() (*pb.RunnerMsg, error) {
+	if len(c.records) == 0 {
+		return nil, io.EOF
+	}
+	rec := c.records[0]
+	c.records = c.records[1:]
+
+	if rec.Error != "" {
+		if rec.Error == io.EOF.Error() {
+			return nil, io.EOF
+		}
+		return nil, errors.New(rec.Error)
+	}
+	var msg pb.RunnerMsg
+	err := jsonpb.UnmarshalString(string(rec.Msg), &msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// NewReplayEngageClient returns an engagement client that replays the runner
+// messages of the session recorded in src. Messages sent to it are discarded.
+func NewReplayEngageClient(src io.Reader) (pb.RunnerProtocol_EngageClient, error) {
+	var records []sessionRecord
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var rec sessionRecord
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return nil, err
+		}
+		if rec.Dir == sessionRecv {
+			records = append(records, rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &replayEngageClient{records: records}, nil
+}
+
+// ReplaySession feeds the runner messages recorded in src through the same receive
+// path TryExec uses, writing the response to call, and returns the resulting error.
+func ReplaySession(ctx context.Context, src io.Reader, call pool.RunnerCall) error {
+	client, err := NewReplayEngageClient(src)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	receiveFromRunner(ctx, client, "replay", call, receiveOptions{}, done)
+
+	var recvErr error
+	for e := range done {
+		recvErr = e
+	}
+	return recvErr
+}
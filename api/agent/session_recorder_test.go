@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pb "github.com/fnproject/fn/api/agent/grpc"
+	"github.com/fnproject/fn/api/models"
+)
+
+func recordSession(t *testing.T, dir string, redact bool, maxBytes int64) string {
+	r := &gRPCRunner{}
+	err := GRPCRunnerWithSessionRecording(dir, maxBytes, redact)(r)
+	if err != nil {
+		t.Fatalf("Unexpected error configuring recording %v", err)
+	}
+
+	call := &mockRunnerCall{rw: httptest.NewRecorder(), model: &models.Call{ID: "call1", Type: models.TypeSync}}
+	client := &mockEngageClient{msgs: []*pb.RunnerMsg{
+		resultStartMsg("text/plain"),
+		dataMsg("hello"),
+		finishedMsg(),
+	}}
+
+	rec := newRecordingEngageClient(context.Background(), client, r.recording, "192.0.2.0:9190", call)
+	rec.Send(&pb.ClientMsg{Body: &pb.ClientMsg_Try{Try: &pb.TryCall{ModelsCallJson: "{}"}}})
+	done := make(chan error, 1)
+	receiveFromRunner(context.Background(), rec, "192.0.2.0:9190", call, receiveOptions{}, done)
+	for err := range done {
+		t.Fatalf("Unexpected error from receiveFromRunner %v", err)
+	}
+	rec.Close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "call1-*.session"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one session recording, got %v %v", files, err)
+	}
+	return files[0]
+}
+
+func TestSessionRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fn-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := recordSession(t, dir, false, 1024*1024)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rw := httptest.NewRecorder()
+	call := &mockRunnerCall{rw: rw, model: &models.Call{Type: models.TypeSync}}
+	err = ReplaySession(context.Background(), f, call)
+	if err != nil {
+		t.Fatalf("Unexpected error replaying session %v", err)
+	}
+	if rw.Body.String() != "hello" || rw.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("Unexpected replayed response %q %v", rw.Body.String(), rw.Header())
+	}
+}
+
+func TestSessionRecordRedactAndLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fn-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	buf, err := ioutil.ReadFile(recordSession(t, dir, true, 1024*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// base64 of "hello"
+	if strings.Contains(string(buf), "aGVsbG8") {
+		t.Fatalf("Response data should be redacted: %s", buf)
+	}
+
+	os.RemoveAll(dir)
+	buf, err = ioutil.ReadFile(recordSession(t, dir, false, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) > 200 {
+		t.Fatalf("Recording exceeds its size limit, %d bytes", len(buf))
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"unsafe"
+
+	pool "github.com/fnproject/fn/api/runnerpool"
 )
 
 //
@@ -34,6 +36,9 @@ type slotQueueMgr struct {
 type slotQueueStats struct {
 	requestStates   [RequestStateMax]uint64
 	containerStates [ContainerStateMax]uint64
+	// freeSlots is the number of queued slots, idle containers taking concurrent calls
+	// queue a slot for each call they can take
+	freeSlots uint64
 }
 
 type slotToken struct {
@@ -57,8 +62,13 @@ type slotQueue struct {
 	slots     []*slotToken
 	nextId    uint64
 	signaller chan *slotCaller
-	statsLock sync.Mutex // protects stats below
+	statsLock sync.Mutex // protects stats, calls and minWarm below
 	stats     slotQueueStats
+	calls     uint64 // calls that entered the queue
+	minWarm   uint64 // containers kept warm
+
+	// interactive is the number of dequeuers of interactive calls, protected by cond
+	interactive uint64
 
 	authLock  sync.Mutex
 	authToken string
@@ -103,15 +113,29 @@ func (a *slotQueue) acquireSlot(s *slotToken) bool {
 	return true
 }
 
-func (a *slotQueue) startDequeuer(ctx context.Context) chan *slotToken {
+// startDequeuer returns a channel of the slots of the queue for a call of priority until
+// ctx is done. Dequeuers of batch calls take no slots while dequeuers of interactive calls
+// wait for some.
+func (a *slotQueue) startDequeuer(ctx context.Context, priority pool.CallPriority) chan *slotToken {
 
 	isWaiting := false
+	isBatch := priority == pool.PriorityBatch
 	output := make(chan *slotToken)
 
+	if !isBatch {
+		a.cond.L.Lock()
+		a.interactive++
+		a.cond.L.Unlock()
+	}
+
 	go func() {
 		<-ctx.Done()
 		a.cond.L.Lock()
-		if isWaiting {
+		if !isBatch {
+			a.interactive--
+			// wake up the dequeuers of batch calls
+			a.cond.Broadcast()
+		} else if isWaiting {
 			a.cond.Broadcast()
 		}
 		a.cond.L.Unlock()
@@ -122,7 +146,7 @@ func (a *slotQueue) startDequeuer(ctx context.Context) chan *slotToken {
 			a.cond.L.Lock()
 
 			isWaiting = true
-			for len(a.slots) <= 0 && (ctx.Err() == nil) {
+			for (len(a.slots) <= 0 || (isBatch && a.interactive > 0)) && (ctx.Err() == nil) {
 				a.cond.Wait()
 			}
 			isWaiting = false
@@ -180,17 +204,66 @@ func (a *slotQueue) isIdle() bool {
 	return isIdle
 }
 
+// setMinWarm sets the number of containers to keep warm, returns the previous number
+func (a *slotQueue) setMinWarm(n uint64) uint64 {
+	a.statsLock.Lock()
+	prev := a.minWarm
+	a.minWarm = n
+	a.statsLock.Unlock()
+	return prev
+}
+
+// isWarmNeeded returns true if fewer containers than the containers to keep warm are
+// running or starting
+func (a *slotQueue) isWarmNeeded() bool {
+	a.statsLock.Lock()
+	defer a.statsLock.Unlock()
+	return a.containersLocked() < a.minWarm
+}
+
+// keepWarm returns true if an idle container should be kept rather than idle out, as there
+// are no more containers than the containers to keep warm
+func (a *slotQueue) keepWarm() bool {
+	a.statsLock.Lock()
+	defer a.statsLock.Unlock()
+	return a.minWarm > 0 && a.containersLocked() <= a.minWarm
+}
+
+// containersLocked returns the number of running or starting containers, statsLock must
+// be held
+func (a *slotQueue) containersLocked() uint64 {
+	return a.stats.containerStates[ContainerStateWait] +
+		a.stats.containerStates[ContainerStateStart] +
+		a.stats.containerStates[ContainerStateIdle] +
+		a.stats.containerStates[ContainerStatePaused] +
+		a.stats.containerStates[ContainerStateBusy]
+}
+
+// callCount returns the number of calls that entered the queue
+func (a *slotQueue) callCount() uint64 {
+	a.statsLock.Lock()
+	defer a.statsLock.Unlock()
+	return a.calls
+}
+
 func (a *slotQueue) getStats() slotQueueStats {
 	var out slotQueueStats
 	a.statsLock.Lock()
 	out = a.stats
 	a.statsLock.Unlock()
+
+	a.cond.L.Lock()
+	out.freeSlots = uint64(len(a.slots))
+	a.cond.L.Unlock()
 	return out
 }
 
 func isNewContainerNeeded(cur *slotQueueStats) bool {
 
 	idleWorkers := cur.containerStates[ContainerStateIdle] + cur.containerStates[ContainerStatePaused]
+	if cur.freeSlots > idleWorkers {
+		idleWorkers = cur.freeSlots
+	}
 	starters := cur.containerStates[ContainerStateStart]
 	startWaiters := cur.containerStates[ContainerStateWait]
 
@@ -223,6 +296,9 @@ func (a *slotQueue) enterRequestState(reqType RequestStateType) {
 	if reqType > RequestStateNone && reqType < RequestStateMax {
 		a.statsLock.Lock()
 		a.stats.requestStates[reqType] += 1
+		if reqType == RequestStateWait {
+			a.calls++
+		}
 		a.statsLock.Unlock()
 	}
 }
@@ -324,6 +400,9 @@ func getSlotQueueKey(call *call, slotExtns string) string {
 	binary.LittleEndian.PutUint32(byt[:4], uint32(call.IdleTimeout))
 	hash.Write(byt[:4])
 
+	binary.LittleEndian.PutUint32(byt[:4], uint32(call.InitTimeout))
+	hash.Write(byt[:4])
+
 	binary.LittleEndian.PutUint32(byt[:4], uint32(call.TmpFsSize))
 	hash.Write(byt[:4])
 
@@ -332,6 +411,19 @@ func getSlotQueueKey(call *call, slotExtns string) string {
 
 	binary.LittleEndian.PutUint64(byt[:], uint64(call.CPUs))
 	hash.Write(byt[:])
+
+	binary.LittleEndian.PutUint64(byt[:], call.GPUs)
+	hash.Write(byt[:])
+
+	binary.LittleEndian.PutUint64(byt[:], call.Concurrency)
+	hash.Write(byt[:])
+
+	binary.LittleEndian.PutUint64(byt[:], call.FsSize)
+	hash.Write(byt[:])
+
+	if call.ReadOnlyRootFs {
+		hash.Write(unsafeBytes("\x01"))
+	}
 	hash.Write(unsafeBytes("\x00"))
 
 	// we have to sort these before printing, yay.
@@ -10,6 +10,7 @@ import (
 
 	"github.com/fnproject/fn/api/id"
 	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
 )
 
 type testSlot struct {
@@ -47,7 +48,7 @@ func checkGetTokenId(t *testing.T, a *slotQueue, dur time.Duration, id uint64) e
 	ctx, cancel := context.WithTimeout(context.Background(), dur)
 	defer cancel()
 
-	outChan := a.startDequeuer(ctx)
+	outChan := a.startDequeuer(ctx, pool.PriorityInteractive)
 
 	for {
 		select {
@@ -205,6 +206,57 @@ func TestSlotNewContainerLogic1(t *testing.T) {
 	if !isNewContainerNeeded(&cur) {
 		t.Fatalf("Should need a new container cur: %#v", cur)
 	}
+
+	// CASE: an idle container taking concurrent calls has free slots for the requests
+	cur = statsHelperSet(3, 0, 0, 0, 1, 0)
+	cur.freeSlots = 4
+	if isNewContainerNeeded(&cur) {
+		t.Fatalf("Should not need a new container cur: %#v", cur)
+	}
+}
+
+func TestSlotMinWarm(t *testing.T) {
+	obj := NewSlotQueue("warm")
+
+	// CASE: no containers to keep warm
+	if obj.isWarmNeeded() || obj.keepWarm() {
+		t.Fatalf("Should not keep containers warm")
+	}
+
+	// CASE: fewer containers than the containers to keep warm
+	obj.setMinWarm(2)
+	obj.enterContainerState(ContainerStateStart)
+	if !obj.isWarmNeeded() {
+		t.Fatalf("Should need a warm container")
+	}
+
+	// CASE: as many containers as the containers to keep warm
+	obj.enterContainerState(ContainerStateIdle)
+	if obj.isWarmNeeded() {
+		t.Fatalf("Should not need a warm container")
+	}
+	if !obj.keepWarm() {
+		t.Fatalf("Should keep idle containers warm")
+	}
+
+	// CASE: more containers than the containers to keep warm
+	obj.enterContainerState(ContainerStatePaused)
+	if obj.keepWarm() {
+		t.Fatalf("Should not keep idle containers warm")
+	}
+
+	if prev := obj.setMinWarm(0); prev != 2 {
+		t.Fatalf("Should have kept 2 containers warm, got %d", prev)
+	}
+	if obj.keepWarm() {
+		t.Fatalf("Should not keep containers warm")
+	}
+
+	obj.enterRequestState(RequestStateWait)
+	obj.enterRequestState(RequestStateExec)
+	if obj.callCount() != 1 {
+		t.Fatalf("Should count 1 call, got %d", obj.callCount())
+	}
 }
 
 func TestSlotQueueBasic3(t *testing.T) {
@@ -266,6 +318,46 @@ func TestSlotQueueBasic3(t *testing.T) {
 	}
 }
 
+func TestSlotQueuePriority(t *testing.T) {
+	obj := NewSlotQueue("test4")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interactive := obj.startDequeuer(ctx, pool.PriorityInteractive)
+	batchCtx, batchCancel := context.WithCancel(context.Background())
+	defer batchCancel()
+	batch := obj.startDequeuer(batchCtx, pool.PriorityBatch)
+
+	obj.queueSlot(NewTestSlot(1))
+
+	// the batch call waits while the interactive call takes the slot
+	select {
+	case tok := <-batch:
+		t.Fatalf("Expected the batch call to wait for the interactive call, got slot %d", tok.id)
+	case <-time.After(100 * time.Millisecond):
+	}
+	select {
+	case tok := <-interactive:
+		if !obj.acquireSlot(tok) || tok.id != 0 {
+			t.Fatalf("Expected the interactive call to acquire slot 0, got %d", tok.id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the interactive call to get a slot")
+	}
+
+	// once no interactive call waits, batch calls take slots
+	cancel()
+	obj.queueSlot(NewTestSlot(2))
+	select {
+	case tok := <-batch:
+		if !obj.acquireSlot(tok) || tok.id != 1 {
+			t.Fatalf("Expected the batch call to acquire slot 1, got %d", tok.id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the batch call to get a slot")
+	}
+}
+
 func BenchmarkSlotKey(b *testing.B) {
 	appName := "myapp"
 	appID := id.New().String()
@@ -3,7 +3,14 @@ package agent
 import (
 	"context"
 	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/fnproject/fn/api/models"
 	pool "github.com/fnproject/fn/api/runnerpool"
 
 	"github.com/sirupsen/logrus"
@@ -13,48 +20,256 @@ import (
 
 // manages a single set of runners ignoring lb groups
 type staticRunnerPool struct {
+	tlsConf    *tls.Config
+	runnerOpts []GRPCRunnerOption
+
+	mtx     sync.Mutex
 	runners []pool.Runner
+	// runner address entries of runners, in the same order
+	entries []string
+	// closed and replaced when runners are added, see RunnersAdded
+	added chan struct{}
+	// removed runners that are still draining
+	closing sync.WaitGroup
 }
 
 func DefaultStaticRunnerPool(runnerAddresses []string) pool.RunnerPool {
-	return NewStaticRunnerPool(runnerAddresses, nil)
+	cfg, err := NewRunnerClientConfig()
+	if err != nil {
+		logrus.WithError(err).Fatalf("error in runner client config cfg=%+v", cfg)
+	}
+	return NewStaticRunnerPoolWithOptions(runnerAddresses, nil, cfg.RunnerOptions()...)
 }
 
 func NewStaticRunnerPool(runnerAddresses []string, tlsConf *tls.Config, dialOpts ...grpc.DialOption) pool.RunnerPool {
+	return NewStaticRunnerPoolWithOptions(runnerAddresses, tlsConf, GRPCRunnerWithDialOptions(dialOpts...))
+}
+
+// NewStaticRunnerPoolWithOptions creates a pool of the given runners, each configured with runnerOpts.
+// A runner address may be followed by ";weight=N" to give the runner N times the share of calls
+// of runners without a weight, see GRPCRunnerWithWeight. The runners can be replaced with
+// SetRunners, see pool.RunnerPoolUpdater.
+func NewStaticRunnerPoolWithOptions(runnerAddresses []string, tlsConf *tls.Config, runnerOpts ...GRPCRunnerOption) pool.RunnerPool {
 	logrus.WithField("runners", runnerAddresses).Info("Starting static runner pool")
-	var runners []pool.Runner
-	dialOpts = append(dialOpts, grpc.WithStatsHandler(new(ocgrpc.ClientHandler)))
-	for _, addr := range runnerAddresses {
-		r, err := NewgRPCRunner(addr, tlsConf, dialOpts...)
+	rp := &staticRunnerPool{
+		tlsConf:    tlsConf,
+		runnerOpts: append(runnerOpts, GRPCRunnerWithDialOptions(grpc.WithStatsHandler(new(ocgrpc.ClientHandler)))),
+		added:      make(chan struct{}),
+	}
+	for _, runnerAddr := range runnerAddresses {
+		entry, r := rp.newRunner(runnerAddr)
+		if r != nil {
+			rp.runners = append(rp.runners, r)
+			rp.entries = append(rp.entries, entry)
+		}
+	}
+	return rp
+}
+
+// newRunner returns the runner for a runner address of the pool along with its normalized
+// address entry, or nil if the runner could not be created
+func (rp *staticRunnerPool) newRunner(runnerAddr string) (string, pool.Runner) {
+	addr, weight, labels, err := parseRunnerAddress(runnerAddr)
+	if err != nil {
+		logrus.WithError(err).WithField("runner_addr", runnerAddr).Warn("Invalid runner")
+		return "", nil
+	}
+	opts := rp.runnerOpts[:len(rp.runnerOpts):len(rp.runnerOpts)]
+	if weight > 0 {
+		opts = append(opts, GRPCRunnerWithWeight(weight))
+	}
+	if len(labels) > 0 {
+		opts = append(opts, GRPCRunnerWithLabels(labels))
+	}
+	r, err := NewgRPCRunnerWithOptions(addr, rp.tlsConf, opts...)
+	if err != nil {
+		logrus.WithError(err).WithField("runner_addr", addr).Warn("Invalid runner")
+		return "", nil
+	}
+	logrus.WithField("runner_addr", addr).Debug("Adding runner to pool")
+	return runnerAddressEntry(addr, weight, labels), r
+}
+
+// SetRunners implements pool.RunnerPoolUpdater. Runners are identified by their address,
+// weight and labels, runners whose weight or labels changed are replaced. Removed runners are closed in the
+// background, which waits for their calls in flight, see GRPCRunnerWithDrainTimeout.
+func (rp *staticRunnerPool) SetRunners(ctx context.Context, runnerAddresses []string) error {
+	wanted := make([]string, 0, len(runnerAddresses))
+	for _, runnerAddr := range runnerAddresses {
+		addr, weight, labels, err := parseRunnerAddress(runnerAddr)
 		if err != nil {
-			logrus.WithError(err).WithField("runner_addr", addr).Warn("Invalid runner")
+			return models.NewAPIError(http.StatusBadRequest, fmt.Errorf("Invalid runner address %q: %v", runnerAddr, err))
+		}
+		wanted = append(wanted, runnerAddressEntry(addr, weight, labels))
+	}
+
+	rp.mtx.Lock()
+	defer rp.mtx.Unlock()
+
+	current := make(map[string]pool.Runner, len(rp.runners))
+	for i, entry := range rp.entries {
+		current[entry] = rp.runners[i]
+	}
+
+	var runners []pool.Runner
+	var entries []string
+	added := false
+	seen := make(map[string]bool, len(wanted))
+	for _, entry := range wanted {
+		if seen[entry] {
 			continue
 		}
-		logrus.WithField("runner_addr", addr).Debug("Adding runner to pool")
+		seen[entry] = true
+
+		r, ok := current[entry]
+		if ok {
+			delete(current, entry)
+		} else {
+			_, r = rp.newRunner(entry)
+			if r == nil {
+				continue
+			}
+			added = true
+		}
 		runners = append(runners, r)
+		entries = append(entries, entry)
 	}
-	return &staticRunnerPool{
-		runners: runners,
+	rp.runners = runners
+	rp.entries = entries
+
+	for entry, r := range current {
+		logrus.WithField("runner_addr", entry).Info("Removing runner from pool")
+		rp.closing.Add(1)
+		go func(r pool.Runner) {
+			defer rp.closing.Done()
+			err := r.Close(context.Background())
+			if err != nil {
+				logrus.WithError(err).WithField("runner_addr", r.Address()).Error("Error closing removed runner")
+			}
+		}(r)
 	}
+
+	if added {
+		close(rp.added)
+		rp.added = make(chan struct{})
+	}
+	logrus.WithField("runners", rp.entries).Info("Updated static runner pool")
+	return nil
+}
+
+// RunnerAddresses returns the runner address entries of the pool
+func (rp *staticRunnerPool) RunnerAddresses() []string {
+	rp.mtx.Lock()
+	defer rp.mtx.Unlock()
+	entries := make([]string, len(rp.entries))
+	copy(entries, rp.entries)
+	return entries
+}
+
+// RunnersAdded implements pool.RunnerPoolNotifier
+func (rp *staticRunnerPool) RunnersAdded() <-chan struct{} {
+	rp.mtx.Lock()
+	defer rp.mtx.Unlock()
+	return rp.added
+}
+
+// runnerAddressEntry returns the runner address of a runner with weight and labels, see
+// parseRunnerAddress
+func runnerAddressEntry(addr string, weight int, labels map[string]string) string {
+	entry := addr
+	if weight > 0 {
+		entry += fmt.Sprintf(";weight=%d", weight)
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		entry += fmt.Sprintf(";label.%s=%s", k, labels[k])
+	}
+	return entry
+}
+
+// parseRunnerAddress splits a runner address of the static pool into the address, the
+// weight of the runner, zero if it has none, and its labels. Parameters follow the address
+// separated by semicolons, "weight=N" and "label.<key>=<value>".
+func parseRunnerAddress(runnerAddr string) (string, int, map[string]string, error) {
+	parts := strings.Split(strings.TrimSpace(runnerAddr), ";")
+	weight := 0
+	var labels map[string]string
+	for _, param := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		switch {
+		case len(kv) != 2:
+			return "", 0, nil, fmt.Errorf("Invalid runner address parameter %q", param)
+		case kv[0] == "weight":
+			w, err := strconv.Atoi(kv[1])
+			if err != nil || w <= 0 {
+				return "", 0, nil, fmt.Errorf("Invalid runner weight %q", kv[1])
+			}
+			weight = w
+		case strings.HasPrefix(kv[0], "label.") && len(kv[0]) > len("label."):
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[strings.TrimPrefix(kv[0], "label.")] = kv[1]
+		default:
+			return "", 0, nil, fmt.Errorf("Invalid runner address parameter %q", param)
+		}
+	}
+	return parts[0], weight, labels, nil
 }
 
 func (rp *staticRunnerPool) Runners(ctx context.Context, call pool.RunnerCall) ([]pool.Runner, error) {
+	rp.mtx.Lock()
+	defer rp.mtx.Unlock()
 	r := make([]pool.Runner, len(rp.runners))
 	copy(r, rp.runners)
 	return r, nil
 }
 
 func (rp *staticRunnerPool) Shutdown(ctx context.Context) error {
-	var retErr error
-	for _, r := range rp.runners {
-		err := r.Close(ctx)
-		if err != nil {
-			logrus.WithError(err).WithField("runner_addr", r.Address()).Error("Error closing runner")
-			// grab the first error only for now.
-			if retErr == nil {
-				retErr = err
+	rp.mtx.Lock()
+	runners := rp.runners
+	rp.runners, rp.entries = nil, nil
+	rp.mtx.Unlock()
+
+	// runners drain their calls in flight on Close, drain them all at once
+	errs := make(chan error, len(runners))
+	for _, r := range runners {
+		go func(r pool.Runner) {
+			err := r.Close(ctx)
+			if err != nil {
+				logrus.WithError(err).WithField("runner_addr", r.Address()).Error("Error closing runner")
 			}
+			errs <- err
+		}(r)
+	}
+
+	var retErr error
+	for range runners {
+		// grab the first error only for now.
+		if err := <-errs; err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+
+	// removed runners close with a background context, stop waiting for them when ctx is done
+	closed := make(chan struct{})
+	go func() {
+		rp.closing.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		if retErr == nil {
+			retErr = ctx.Err()
 		}
 	}
 	return retErr
 }
+
+var _ pool.RunnerPoolUpdater = &staticRunnerPool{}
+var _ pool.RunnerPoolNotifier = &staticRunnerPool{}
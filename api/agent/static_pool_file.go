@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	pool "github.com/fnproject/fn/api/runnerpool"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ReadRunnerAddresses reads a runner address file: runner addresses separated by commas
+// or new lines, as for NewStaticRunnerPoolWithOptions. Empty lines and lines starting
+// with '#' are ignored.
+func ReadRunnerAddresses(path string) ([]string, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRunnerAddresses(buf), nil
+}
+
+func parseRunnerAddresses(buf []byte) []string {
+	var addrs []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, addr := range strings.Split(line, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs
+}
+
+// WatchRunnerAddresses checks the runner address file at path every interval and updates
+// the runners of rp when its content changed, until ctx is done. The file is polled rather
+// than watched for events, which keeps working when it is replaced, eg. by a config map
+// update. A file that cannot be read leaves the runners unchanged.
+func WatchRunnerAddresses(ctx context.Context, rp pool.RunnerPoolUpdater, path string, interval time.Duration) {
+	log := logrus.WithField("runner_addresses_file", path)
+	last, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.WithError(err).Warn("Failed to read runner addresses")
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		buf, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.WithError(err).Warn("Failed to read runner addresses")
+			continue
+		}
+		if bytes.Equal(buf, last) {
+			continue
+		}
+		last = buf
+
+		log.Info("Runner addresses changed, updating runner pool")
+		err = rp.SetRunners(ctx, parseRunnerAddresses(buf))
+		if err != nil {
+			log.WithError(err).Error("Failed to update runner pool")
+		}
+	}
+}
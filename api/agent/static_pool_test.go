@@ -2,6 +2,7 @@ package agent
 
 import (
 	"context"
+	"reflect"
 	"testing"
 
 	pool "github.com/fnproject/fn/api/runnerpool"
@@ -46,3 +47,82 @@ func TestEmptyPool(t *testing.T) {
 		t.Fatalf("Unexpected error from shutdown %v", err)
 	}
 }
+
+func TestStaticPoolWeights(t *testing.T) {
+	addrs := []string{"192.0.2.255:8080;weight=4", "192.0.2.255:8081", "192.0.2.255:8082;weight=0", "192.0.2.255:8083;size=4"}
+	np := setupStaticPool(addrs)
+	defer np.Shutdown(context.Background())
+
+	runners, err := np.Runners(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("Failed to list runners %v", err)
+	}
+	// runners with invalid parameters are skipped
+	if len(runners) != 2 {
+		t.Fatalf("Invalid number of runners %v", len(runners))
+	}
+	if runners[0].Address() != "192.0.2.255:8080" || pool.RunnerWeight(runners[0]) != 4 || pool.RunnerWeight(runners[1]) != 1 {
+		t.Fatalf("Expected weights 4 and 1, got %s=%d %s=%d", runners[0].Address(), pool.RunnerWeight(runners[0]), runners[1].Address(), pool.RunnerWeight(runners[1]))
+	}
+}
+
+func TestStaticPoolLabels(t *testing.T) {
+	np := setupStaticPool([]string{"192.0.2.255:8080;label.zone=phx-1;weight=2;label.gpu=true", "192.0.2.255:8081;label.=x"}).(*staticRunnerPool)
+	defer np.Shutdown(context.Background())
+
+	runners, _ := np.Runners(context.Background(), nil)
+	if len(runners) != 1 {
+		t.Fatalf("Invalid number of runners %v", len(runners))
+	}
+	expected := map[string]string{"gpu": "true", "zone": "phx-1"}
+	if labels := runners[0].(pool.LabeledRunner).Labels(); !reflect.DeepEqual(labels, expected) {
+		t.Fatalf("Expected labels %v, got %v", expected, labels)
+	}
+	// address entries are normalized
+	if addrs := np.RunnerAddresses(); len(addrs) != 1 || addrs[0] != "192.0.2.255:8080;weight=2;label.gpu=true;label.zone=phx-1" {
+		t.Fatalf("Unexpected runner addresses %v", addrs)
+	}
+}
+
+func TestStaticPoolSetRunners(t *testing.T) {
+	np := setupStaticPool([]string{"192.0.2.255:8080", "192.0.2.255:8081"}).(*staticRunnerPool)
+	defer np.Shutdown(context.Background())
+
+	before, _ := np.Runners(context.Background(), nil)
+	added := np.RunnersAdded()
+
+	err := np.SetRunners(context.Background(), []string{"192.0.2.255:8081", "192.0.2.255:8082;weight=2"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	after, _ := np.Runners(context.Background(), nil)
+	if len(after) != 2 || after[0] != before[1] || after[1].Address() != "192.0.2.255:8082" || pool.RunnerWeight(after[1]) != 2 {
+		t.Fatalf("Expected kept runner and weighted new runner, got %v", np.RunnerAddresses())
+	}
+	select {
+	case <-added:
+	default:
+		t.Fatal("Expected runners added notification")
+	}
+
+	// removed runners are closed
+	np.closing.Wait()
+	placed, err := before[0].TryExec(context.Background(), &mockRunnerCall{})
+	if placed || err != ErrorRunnerClosed {
+		t.Fatalf("Expected removed runner closed, got placed=%v err=%v", placed, err)
+	}
+
+	// invalid addresses leave the pool unchanged
+	err = np.SetRunners(context.Background(), []string{"192.0.2.255:8083;weight=x"})
+	if err == nil || len(np.RunnerAddresses()) != 2 {
+		t.Fatalf("Expected an error and unchanged runners, got %v %v", err, np.RunnerAddresses())
+	}
+}
+
+func TestParseRunnerAddresses(t *testing.T) {
+	addrs := parseRunnerAddresses([]byte("# runners\n192.0.2.255:8080, 192.0.2.255:8081\n\n  192.0.2.255:8082;weight=2\n"))
+	expected := []string{"192.0.2.255:8080", "192.0.2.255:8081", "192.0.2.255:8082;weight=2"}
+	if !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("Expected %v, got %v", expected, addrs)
+	}
+}
@@ -17,6 +17,9 @@ var (
 	containerStateKey    = common.MakeKey("container_state")
 	callStatusKey        = common.MakeKey("call_status")
 	containerUDSStateKey = common.MakeKey("container_uds_state")
+	runnerAddrKey        = common.MakeKey("runner_addr")
+	runnerErrorKey       = common.MakeKey("runner_error")
+	runnerPoolKey        = common.MakeKey("runner_pool")
 
 	// tri-state values below: error/true/false
 	statusCallCacheKey    = common.MakeKey("cached")
@@ -79,6 +82,79 @@ func statsLBAgentRunnerExecLatency(ctx context.Context, dur time.Duration) {
 	stats.Record(ctx, runnerExecLatencyMeasure.M(int64(dur/time.Millisecond)))
 }
 
+func statsRunnerPlacementLatency(ctx context.Context, runnerAddress string, dur time.Duration) {
+	ctx, err := tag.New(ctx, tag.Upsert(runnerAddrKey, runnerAddress))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	stats.Record(ctx, runnerPlacementLatencyMeasure.M(int64(dur/time.Millisecond)))
+}
+
+func statsRunnerCallLatency(ctx context.Context, runnerAddress string, dur time.Duration) {
+	ctx, err := tag.New(ctx, tag.Upsert(runnerAddrKey, runnerAddress))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	stats.Record(ctx, runnerCallLatencyMeasure.M(int64(dur/time.Millisecond)))
+}
+
+func statsRunnerCordoned(ctx context.Context, delta int64) {
+	stats.Record(ctx, runnerCordonedMeasure.M(delta))
+}
+
+func statsRunnerCircuitOpen(ctx context.Context, delta int64) {
+	stats.Record(ctx, runnerCircuitOpenMeasure.M(delta))
+}
+
+func statsRunnerEjected(ctx context.Context, delta int64) {
+	stats.Record(ctx, runnerEjectedMeasure.M(delta))
+}
+
+func statsPreempted(ctx context.Context) {
+	stats.Record(ctx, preemptedMeasure.M(1))
+}
+
+func statsCallEventDropped(ctx context.Context) {
+	stats.Record(ctx, callEventsDroppedMeasure.M(1))
+}
+
+func statsResponseHashMismatch(ctx context.Context) {
+	stats.Record(ctx, responseHashMismatchMeasure.M(1))
+}
+
+func statsShadowCall(ctx context.Context) {
+	stats.Record(ctx, shadowCallsMeasure.M(1))
+}
+
+func statsShadowError(ctx context.Context) {
+	stats.Record(ctx, shadowErrorsMeasure.M(1))
+}
+
+func statsResponseCacheHit(ctx context.Context) {
+	stats.Record(ctx, responseCacheHitsMeasure.M(1))
+}
+
+// runner stream failure classes, see statsRunnerStreamError
+const (
+	runnerErrorDial         = "dial"
+	runnerErrorSend         = "send"
+	runnerErrorRecv         = "recv"
+	runnerErrorNoEOF        = "no_eof"
+	runnerErrorPartialWrite = "partial_write"
+	runnerErrorTooBusy      = "too_busy"
+)
+
+func statsRunnerStreamError(ctx context.Context, runnerAddress string, errorClass string) {
+	ctx, err := tag.New(ctx,
+		tag.Upsert(runnerAddrKey, runnerAddress),
+		tag.Upsert(runnerErrorKey, errorClass),
+	)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	stats.Record(ctx, runnerStreamErrorsMeasure.M(0))
+}
+
 func statsContainerUDSInitLatency(ctx context.Context, start time.Time, end time.Time, containerUDSState string) {
 	if end.Before(start) {
 		return
@@ -106,11 +182,28 @@ func statsContainerEvicted(ctx context.Context, containerState string) {
 	stats.Record(ctx, containerEvictedMeasure.M(0))
 }
 
+func statsContainerOOM(ctx context.Context, call *call) {
+	ctx, err := tag.New(ctx,
+		tag.Upsert(AppIDMetricKey, call.AppID),
+		tag.Upsert(FnIDMetricKey, call.FnID),
+		tag.Upsert(ImageNameMetricKey, call.Image),
+	)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	stats.Record(ctx, containerOOMMeasure.M(0))
+}
+
 func statsUtilization(ctx context.Context, util ResourceUtilization) {
 	stats.Record(ctx, utilCpuUsedMeasure.M(int64(util.CpuUsed)))
 	stats.Record(ctx, utilCpuAvailMeasure.M(int64(util.CpuAvail)))
 	stats.Record(ctx, utilMemUsedMeasure.M(int64(util.MemUsed)))
 	stats.Record(ctx, utilMemAvailMeasure.M(int64(util.MemAvail)))
+	stats.Record(ctx, utilGpuUsedMeasure.M(int64(util.GpuUsed)))
+	stats.Record(ctx, utilGpuAvailMeasure.M(int64(util.GpuAvail)))
+	stats.Record(ctx, utilMemPressureMeasure.M(int64(util.MemPressure)))
+	stats.Record(ctx, utilCpuPressureMeasure.M(int64(util.CpuPressure)))
 }
 
 func statsCallLatency(ctx context.Context, dur time.Duration, callStatus string) {
@@ -172,16 +265,37 @@ const (
 
 	containerEvictedMetricName        = "container_evictions"
 	containerUDSInitLatencyMetricName = "container_uds_init_latency"
+	containerOOMMetricName            = "container_oom_kills"
 
 	utilCpuUsedMetricName  = "util_cpu_used"
 	utilCpuAvailMetricName = "util_cpu_avail"
 	utilMemUsedMetricName  = "util_mem_used"
 	utilMemAvailMetricName = "util_mem_avail"
+	utilGpuUsedMetricName  = "util_gpu_used"
+	utilGpuAvailMetricName = "util_gpu_avail"
+
+	utilMemPressureMetricName = "util_mem_pressure"
+	utilCpuPressureMetricName = "util_cpu_pressure"
 
 	// Reported By LB
-	runnerSchedLatencyMetricName = "lb_runner_sched_latency"
-	runnerExecLatencyMetricName  = "lb_runner_exec_latency"
-	callLatencyMetricName        = "lb_call_latency"
+	runnerSchedLatencyMetricName   = "lb_runner_sched_latency"
+	runnerExecLatencyMetricName    = "lb_runner_exec_latency"
+	callLatencyMetricName          = "lb_call_latency"
+	runnerPlacementLatencyName     = "lb_runner_placement_latency"
+	runnerCallLatencyName          = "lb_runner_call_latency"
+	runnerCordonedMetricName       = "lb_runner_cordoned"
+	runnerCircuitOpenMetricName    = "lb_runner_circuit_open"
+	runnerEjectedMetricName        = "lb_runner_ejected"
+	preemptedMetricName            = "lb_preempted_calls"
+	callEventsDroppedMetricName    = "lb_call_events_dropped"
+	responseHashMismatchMetricName = "lb_response_hash_mismatch"
+	runnerStreamErrorsMetricName   = "lb_runner_stream_errors"
+	shadowCallsMetricName          = "lb_shadow_calls"
+	shadowErrorsMetricName         = "lb_shadow_errors"
+	responseCacheHitsMetricName    = "lb_response_cache_hits"
+	desiredRunnersMetricName       = "lb_desired_runners"
+	queueDepthMetricName           = "lb_queue_depth"
+	queueWaitMetricName            = "lb_queue_wait"
 
 	// Reported by Runner
 	statusCallMetricName = "status_call"
@@ -203,8 +317,13 @@ var (
 	utilCpuAvailMeasure            = common.MakeMeasure(utilCpuAvailMetricName, "agent cpu available", "")
 	utilMemUsedMeasure             = common.MakeMeasure(utilMemUsedMetricName, "agent memory in use", "By")
 	utilMemAvailMeasure            = common.MakeMeasure(utilMemAvailMetricName, "agent memory available", "By")
+	utilGpuUsedMeasure             = common.MakeMeasure(utilGpuUsedMetricName, "agent gpus in use", "")
+	utilGpuAvailMeasure            = common.MakeMeasure(utilGpuAvailMetricName, "agent gpus available", "")
+	utilMemPressureMeasure         = common.MakeMeasure(utilMemPressureMetricName, "host memory pressure", "%")
+	utilCpuPressureMeasure         = common.MakeMeasure(utilCpuPressureMetricName, "host cpu pressure", "%")
 	containerEvictedMeasure        = common.MakeMeasure(containerEvictedMetricName, "containers evicted", "")
 	containerUDSInitLatencyMeasure = common.MakeMeasure(containerUDSInitLatencyMetricName, "container UDS Init-Wait Latency", "msecs")
+	containerOOMMeasure            = common.MakeMeasure(containerOOMMetricName, "containers killed out of memory", "")
 
 	// Reported By LB: How long does a runner scheduler wait for a committed call? eg. wait/launch/pull containers
 	runnerSchedLatencyMeasure = common.MakeMeasure(runnerSchedLatencyMetricName, "Runner Scheduler Latency Reported By LBAgent", "msecs")
@@ -212,6 +331,36 @@ var (
 	runnerExecLatencyMeasure = common.MakeMeasure(runnerExecLatencyMetricName, "Runner Container Execution Latency Reported By LBAgent", "msecs")
 	// Reported By LB: Function total call latency (except function execution inside container)
 	callLatencyMeasure = common.MakeMeasure(callLatencyMetricName, "LB Call Latency Reported By LBAgent", "msecs")
+	// Reported By LB: Time until a runner accepts a call, by runner address
+	runnerPlacementLatencyMeasure = common.MakeMeasure(runnerPlacementLatencyName, "Runner Placement Latency Reported By LBAgent", "msecs")
+	// Reported By LB: Time until a runner completes a call it accepted, by runner address
+	runnerCallLatencyMeasure = common.MakeMeasure(runnerCallLatencyName, "Runner Call Completion Latency Reported By LBAgent", "msecs")
+	// Reported By LB: Number of runners currently cordoned
+	runnerCordonedMeasure = common.MakeMeasure(runnerCordonedMetricName, "Runners Cordoned By LBAgent", "")
+	// Reported By LB: Number of runners with an open circuit breaker
+	runnerCircuitOpenMeasure = common.MakeMeasure(runnerCircuitOpenMetricName, "Runners With Open Circuit Breaker In LBAgent", "")
+	// Reported By LB: Number of runners ejected from placement after failures
+	runnerEjectedMeasure = common.MakeMeasure(runnerEjectedMetricName, "Runners Ejected By LBAgent", "")
+	// Reported By LB: Preemptible calls canceled for calls of the reserved placement class
+	preemptedMeasure = common.MakeMeasure(preemptedMetricName, "Calls Preempted By LBAgent", "")
+	// Reported By LB: Call events dropped because the event sink queue was full
+	callEventsDroppedMeasure = common.MakeMeasure(callEventsDroppedMetricName, "Call Events Dropped By LBAgent", "")
+	// Reported By LB: Responses where the runner reported hash did not match the data received
+	responseHashMismatchMeasure = common.MakeMeasure(responseHashMismatchMetricName, "Response Hash Mismatches Reported By LBAgent", "")
+	// Reported By LB: Failed runner engagements by runner address and failure class
+	runnerStreamErrorsMeasure = common.MakeMeasure(runnerStreamErrorsMetricName, "Runner Stream Errors Reported By LBAgent", "")
+	// Reported By LB: Calls mirrored to the shadow runner pool
+	shadowCallsMeasure = common.MakeMeasure(shadowCallsMetricName, "Calls Mirrored To The Shadow Runner Pool By LBAgent", "")
+	// Reported By LB: Mirrored calls that failed on the shadow runner pool
+	shadowErrorsMeasure = common.MakeMeasure(shadowErrorsMetricName, "Shadow Calls Failed In LBAgent", "")
+	// Reported By LB: Calls served from the response cache, see WithLBResponseCache
+	responseCacheHitsMeasure = common.MakeMeasure(responseCacheHitsMetricName, "Calls Served From The Response Cache Of LBAgent", "")
+	// Reported By LB: Runners a runner pool should have, see WithLBCapacitySignal
+	desiredRunnersMeasure = common.MakeMeasure(desiredRunnersMetricName, "Desired Runners Of A Runner Pool Computed By LBAgent", "")
+	// Reported By LB: Calls waiting in the call queue of a runner pool, see WithLBCallQueue
+	queueDepthMeasure = common.MakeMeasure(queueDepthMetricName, "Calls Queued For A Runner Pool In LBAgent", "")
+	// Reported By LB: Time calls waited in the call queue of a runner pool until woken or rejected
+	queueWaitMeasure = common.MakeMeasure(queueWaitMetricName, "Call Queue Wait Time In LBAgent", "msecs")
 	// Reported By Runner: Status Call Results
 	statusCallMeasure = common.MakeMeasure(statusCallMetricName, "Status Call Results Reported By Runner", "")
 )
@@ -226,10 +375,52 @@ func RegisterLBAgentViews(tagKeys []string, latencyDist []float64) {
 		}
 	}
 
+	// add runner_addr and runner_error tags for runner stream errors
+	streamErrorTags := make([]string, 0, len(tagKeys)+2)
+	streamErrorTags = append(streamErrorTags, "runner_addr", "runner_error")
+	for _, key := range tagKeys {
+		if key != "runner_addr" && key != "runner_error" {
+			streamErrorTags = append(streamErrorTags, key)
+		}
+	}
+
+	// add runner_addr tag for per-runner latencies
+	runnerTags := make([]string, 0, len(tagKeys)+1)
+	runnerTags = append(runnerTags, "runner_addr")
+	for _, key := range tagKeys {
+		if key != "runner_addr" {
+			runnerTags = append(runnerTags, key)
+		}
+	}
+
+	// add runner_pool tag for capacity signals
+	poolTags := make([]string, 0, len(tagKeys)+1)
+	poolTags = append(poolTags, "runner_pool")
+	for _, key := range tagKeys {
+		if key != "runner_pool" {
+			poolTags = append(poolTags, key)
+		}
+	}
+
 	err := view.Register(
 		common.CreateView(runnerSchedLatencyMeasure, view.Distribution(latencyDist...), tagKeys),
 		common.CreateView(runnerExecLatencyMeasure, view.Distribution(latencyDist...), tagKeys),
 		common.CreateView(callLatencyMeasure, view.Distribution(latencyDist...), callLatencyTags),
+		common.CreateView(runnerPlacementLatencyMeasure, view.Distribution(latencyDist...), runnerTags),
+		common.CreateView(runnerCallLatencyMeasure, view.Distribution(latencyDist...), runnerTags),
+		common.CreateView(runnerCordonedMeasure, view.Sum(), tagKeys),
+		common.CreateView(runnerCircuitOpenMeasure, view.Sum(), tagKeys),
+		common.CreateView(runnerEjectedMeasure, view.Sum(), tagKeys),
+		common.CreateView(preemptedMeasure, view.Count(), tagKeys),
+		common.CreateView(callEventsDroppedMeasure, view.Count(), tagKeys),
+		common.CreateView(responseHashMismatchMeasure, view.Count(), tagKeys),
+		common.CreateView(runnerStreamErrorsMeasure, view.Count(), streamErrorTags),
+		common.CreateView(shadowCallsMeasure, view.Count(), tagKeys),
+		common.CreateView(shadowErrorsMeasure, view.Count(), tagKeys),
+		common.CreateView(responseCacheHitsMeasure, view.Count(), tagKeys),
+		common.CreateView(desiredRunnersMeasure, view.LastValue(), poolTags),
+		common.CreateView(queueDepthMeasure, view.Sum(), poolTags),
+		common.CreateView(queueWaitMeasure, view.Distribution(latencyDist...), poolTags),
 	)
 	if err != nil {
 		logrus.WithError(err).Fatal("cannot register view")
@@ -251,6 +442,10 @@ func RegisterAgentViews(tagKeys []string, latencyDist []float64) {
 		common.CreateView(utilCpuAvailMeasure, view.LastValue(), tagKeys),
 		common.CreateView(utilMemUsedMeasure, view.LastValue(), tagKeys),
 		common.CreateView(utilMemAvailMeasure, view.LastValue(), tagKeys),
+		common.CreateView(utilGpuUsedMeasure, view.LastValue(), tagKeys),
+		common.CreateView(utilGpuAvailMeasure, view.LastValue(), tagKeys),
+		common.CreateView(utilMemPressureMeasure, view.LastValue(), tagKeys),
+		common.CreateView(utilCpuPressureMeasure, view.LastValue(), tagKeys),
 	)
 
 	if err != nil {
@@ -355,6 +550,7 @@ func RegisterContainerViews(tagKeys []string, latencyDist []float64) {
 	err := view.Register(
 		common.CreateView(containerEvictedMeasure, view.Count(), evictTags),
 		common.CreateView(containerUDSInitLatencyMeasure, view.Distribution(latencyDist...), udsInitTags),
+		common.CreateView(containerOOMMeasure, view.Count(), tagKeys),
 	)
 	if err != nil {
 		logrus.WithError(err).Fatal("cannot register view")
@@ -8,6 +8,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 // NewTLSSimple creates a new tls config with the given cert and key file paths
@@ -34,6 +38,103 @@ func NewTLSSimple(certPath, keyPath string) (*tls.Config, error) {
 	}, nil
 }
 
+// NewTLSReloading creates a new tls config like NewTLSSimple, but the key pair is
+// reloaded from disk when the files change. Established connections keep the
+// certificate they were set up with, new connections use the reloaded one.
+func NewTLSReloading(certPath, keyPath string) (*tls.Config, error) {
+	reloader, err := NewCertReloader(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetCertificate:       reloader.GetCertificate,
+		GetClientCertificate: reloader.GetClientCertificate,
+	}, nil
+}
+
+// CertReloader serves a key pair from disk, checking the files for changes on
+// every TLS handshake. If a changed key pair cannot be loaded, eg. because only
+// one of the files was replaced so far, the previous key pair is served.
+type CertReloader struct {
+	certPath string
+	keyPath  string
+
+	mtx     sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// NewCertReloader loads the key pair at the given cert and key file paths
+func NewCertReloader(certPath, keyPath string) (*CertReloader, error) {
+	err := checkFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	err = checkFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &CertReloader{certPath: certPath, keyPath: keyPath}
+	err = r.reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the key pair if either file changed since it was last loaded
+func (r *CertReloader) reload() error {
+	certInfo, err := os.Stat(r.certPath)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(r.keyPath)
+	if err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.cert != nil && certInfo.ModTime().Equal(r.certMod) && keyInfo.ModTime().Equal(r.keyMod) {
+		return nil
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("Could not load key pair: %s", err)
+	}
+	if r.cert != nil {
+		logrus.WithFields(logrus.Fields{"cert": r.certPath, "key": r.keyPath}).Info("Reloaded TLS key pair")
+	}
+	r.cert = &certificate
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// certificate returns the current key pair, reloading it first if it changed
+func (r *CertReloader) certificate() *tls.Certificate {
+	err := r.reload()
+	if err != nil {
+		logrus.WithError(err).WithField("cert", r.certPath).Warn("Failed to reload TLS key pair, using previous one")
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.cert
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate for servers
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
+// GetClientCertificate can be used as tls.Config.GetClientCertificate for clients
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.certificate(), nil
+}
+
 // AddClientCA adds a client cert to the given tls config
 func AddClientCA(tlsConf *tls.Config, clientCAPath string) error {
 
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes a self signed key pair with the given serial number and sets
+// the modification time of both files to mod
+func writeKeyPair(t *testing.T, certPath, keyPath string, serial int64, mod time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "runner"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{certPath, keyPath} {
+		err = os.Chtimes(path, mod, mod)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func servedSerial(t *testing.T, r *CertReloader) int64 {
+	cert, err := r.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-reloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	now := time.Now()
+	writeKeyPair(t, certPath, keyPath, 1, now.Add(-time.Minute))
+
+	r, err := NewCertReloader(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("Failed to load key pair %v", err)
+	}
+	if serial := servedSerial(t, r); serial != 1 {
+		t.Fatalf("Expected serial 1, got %d", serial)
+	}
+
+	writeKeyPair(t, certPath, keyPath, 2, now)
+	if serial := servedSerial(t, r); serial != 2 {
+		t.Fatalf("Expected reloaded serial 2, got %d", serial)
+	}
+
+	// a key that does not match the certificate keeps the previous key pair
+	writeKeyPair(t, certPath, filepath.Join(dir, "other.pem"), 3, now.Add(time.Minute))
+	if serial := servedSerial(t, r); serial != 2 {
+		t.Fatalf("Expected previous serial 2 after failed reload, got %d", serial)
+	}
+
+	_, err = NewCertReloader(filepath.Join(dir, "missing.pem"), keyPath)
+	if err == nil {
+		t.Fatal("Expected an error for a missing certificate")
+	}
+}
@@ -668,6 +668,205 @@ func RunFnsTest(t *testing.T, dsf DataStoreFunc, rp ResourceProvider) {
 			}
 		})
 
+		t.Run("Update function gpus", func(t *testing.T) {
+			h := NewHarness(t, ctx, ds)
+			defer h.Cleanup()
+			testApp := h.GivenAppInDb(rp.ValidApp())
+			testFn := h.GivenFnInDb(rp.ValidFn(testApp.ID))
+
+			gpus := uint64(2)
+			updated, err := ds.UpdateFn(ctx, &models.Fn{
+				ID:             testFn.ID,
+				ResourceConfig: models.ResourceConfig{GPUs: &gpus},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fn, err := ds.GetFnByID(ctx, testFn.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if updated.GPUCount() != 2 || fn.GPUCount() != 2 {
+				t.Fatalf("expected 2 gpus but got %d updated and %d stored", updated.GPUCount(), fn.GPUCount())
+			}
+
+			gpus = 0
+			_, err = ds.UpdateFn(ctx, &models.Fn{
+				ID:             testFn.ID,
+				ResourceConfig: models.ResourceConfig{GPUs: &gpus},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fn, err = ds.GetFnByID(ctx, testFn.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fn.GPUs != nil {
+				t.Fatalf("expected gpus to be removed but got %d", *fn.GPUs)
+			}
+		})
+
+		t.Run("Update function init timeout", func(t *testing.T) {
+			h := NewHarness(t, ctx, ds)
+			defer h.Cleanup()
+			testApp := h.GivenAppInDb(rp.ValidApp())
+			testFn := h.GivenFnInDb(rp.ValidFn(testApp.ID))
+
+			initTimeout := int32(120)
+			updated, err := ds.UpdateFn(ctx, &models.Fn{
+				ID:             testFn.ID,
+				ResourceConfig: models.ResourceConfig{InitTimeout: &initTimeout},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fn, err := ds.GetFnByID(ctx, testFn.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if updated.InitTimeoutSecs() != 120 || fn.InitTimeoutSecs() != 120 {
+				t.Fatalf("expected init timeout of 120 but got %d updated and %d stored", updated.InitTimeoutSecs(), fn.InitTimeoutSecs())
+			}
+
+			initTimeout = 0
+			_, err = ds.UpdateFn(ctx, &models.Fn{
+				ID:             testFn.ID,
+				ResourceConfig: models.ResourceConfig{InitTimeout: &initTimeout},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fn, err = ds.GetFnByID(ctx, testFn.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fn.InitTimeout != nil {
+				t.Fatalf("expected init timeout to be reset but got %d", *fn.InitTimeout)
+			}
+		})
+
+		t.Run("Update function concurrency", func(t *testing.T) {
+			h := NewHarness(t, ctx, ds)
+			defer h.Cleanup()
+			testApp := h.GivenAppInDb(rp.ValidApp())
+			testFn := h.GivenFnInDb(rp.ValidFn(testApp.ID))
+
+			concurrency := uint64(4)
+			updated, err := ds.UpdateFn(ctx, &models.Fn{
+				ID:             testFn.ID,
+				ResourceConfig: models.ResourceConfig{Concurrency: &concurrency},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fn, err := ds.GetFnByID(ctx, testFn.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if updated.ContainerConcurrency() != 4 || fn.ContainerConcurrency() != 4 {
+				t.Fatalf("expected concurrency of 4 but got %d updated and %d stored", updated.ContainerConcurrency(), fn.ContainerConcurrency())
+			}
+
+			concurrency = 0
+			_, err = ds.UpdateFn(ctx, &models.Fn{
+				ID:             testFn.ID,
+				ResourceConfig: models.ResourceConfig{Concurrency: &concurrency},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fn, err = ds.GetFnByID(ctx, testFn.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fn.Concurrency != nil {
+				t.Fatalf("expected concurrency to be reset but got %d", *fn.Concurrency)
+			}
+		})
+
+		t.Run("Update function cpus", func(t *testing.T) {
+			h := NewHarness(t, ctx, ds)
+			defer h.Cleanup()
+			testApp := h.GivenAppInDb(rp.ValidApp())
+			testFn := h.GivenFnInDb(rp.ValidFn(testApp.ID))
+
+			cpus := models.MilliCPUs(1500)
+			updated, err := ds.UpdateFn(ctx, &models.Fn{
+				ID:             testFn.ID,
+				ResourceConfig: models.ResourceConfig{CPUs: &cpus},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fn, err := ds.GetFnByID(ctx, testFn.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, f := range []*models.Fn{updated, fn} {
+				if f.CPUQuota() != 1500 {
+					t.Fatalf("expected cpus 1500m but got %s", f.CPUQuota())
+				}
+			}
+
+			cpus = 0
+			_, err = ds.UpdateFn(ctx, &models.Fn{
+				ID:             testFn.ID,
+				ResourceConfig: models.ResourceConfig{CPUs: &cpus},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fn, err = ds.GetFnByID(ctx, testFn.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fn.CPUs != nil {
+				t.Fatalf("expected cpus to be reset but got %s", fn.CPUQuota())
+			}
+		})
+
+		t.Run("Update function file system limits", func(t *testing.T) {
+			h := NewHarness(t, ctx, ds)
+			defer h.Cleanup()
+			testApp := h.GivenAppInDb(rp.ValidApp())
+			testFn := h.GivenFnInDb(rp.ValidFn(testApp.ID))
+
+			tmpFsSize, fsSize, readOnly := uint64(64), uint64(512), true
+			updated, err := ds.UpdateFn(ctx, &models.Fn{
+				ID:             testFn.ID,
+				ResourceConfig: models.ResourceConfig{TmpFsSize: &tmpFsSize, FsSize: &fsSize, ReadOnlyRootFs: &readOnly},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fn, err := ds.GetFnByID(ctx, testFn.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, f := range []*models.Fn{updated, fn} {
+				if f.TmpFsSizeMB() != 64 || f.FsSizeMB() != 512 || !f.IsReadOnlyRootFs() {
+					t.Fatalf("expected tmpfs size 64, fs size 512 and read only root fs but got %d, %d and %v", f.TmpFsSizeMB(), f.FsSizeMB(), f.IsReadOnlyRootFs())
+				}
+			}
+
+			tmpFsSize, fsSize, readOnly = 0, 0, false
+			_, err = ds.UpdateFn(ctx, &models.Fn{
+				ID:             testFn.ID,
+				ResourceConfig: models.ResourceConfig{TmpFsSize: &tmpFsSize, FsSize: &fsSize, ReadOnlyRootFs: &readOnly},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			fn, err = ds.GetFnByID(ctx, testFn.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if fn.TmpFsSize != nil || fn.FsSize != nil || fn.ReadOnlyRootFs != nil {
+				t.Fatalf("expected file system limits to be reset but got %+v", fn.ResourceConfig)
+			}
+		})
+
 		t.Run("basic pagination no functions", func(t *testing.T) {
 			h := NewHarness(t, ctx, ds)
 			defer h.Cleanup()
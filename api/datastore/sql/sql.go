@@ -64,6 +64,13 @@ var tables = [...]string{
 	memory int NOT NULL,
 	timeout int NOT NULL,
 	idle_timeout int NOT NULL,
+	init_timeout int,
+	gpus int,
+	concurrency int,
+	tmpfs_size int,
+	fs_size int,
+	read_only_rootfs boolean,
+	cpus int,
 	config text NOT NULL,
 	annotations text NOT NULL,
 	created_at varchar(256) NOT NULL,
@@ -76,7 +83,7 @@ const (
 	appIDSelector     = `SELECT id, name, config, annotations, syslog_url, created_at, updated_at FROM apps WHERE id=?`
 	ensureAppSelector = `SELECT id FROM apps WHERE name=?`
 
-	fnSelector   = `SELECT id,name,app_id,image,memory,timeout,idle_timeout,config,annotations,created_at,updated_at FROM fns`
+	fnSelector   = `SELECT id,name,app_id,image,memory,timeout,idle_timeout,init_timeout,gpus,concurrency,tmpfs_size,fs_size,read_only_rootfs,cpus,config,annotations,created_at,updated_at FROM fns`
 	fnIDSelector = fnSelector + ` WHERE id=?`
 
 	triggerSelector   = `SELECT id,name,app_id,fn_id,type,source,annotations,created_at,updated_at FROM triggers`
@@ -504,6 +511,13 @@ func (ds *SQLStore) InsertFn(ctx context.Context, newFn *models.Fn) (*models.Fn,
 				memory,
 				timeout,
 				idle_timeout,
+				init_timeout,
+				gpus,
+				concurrency,
+				tmpfs_size,
+				fs_size,
+				read_only_rootfs,
+				cpus,
 				config,
 				annotations,
 				created_at,
@@ -517,6 +531,13 @@ func (ds *SQLStore) InsertFn(ctx context.Context, newFn *models.Fn) (*models.Fn,
 				:memory,
 				:timeout,
 				:idle_timeout,
+				:init_timeout,
+				:gpus,
+				:concurrency,
+				:tmpfs_size,
+				:fs_size,
+				:read_only_rootfs,
+				:cpus,
 				:config,
 				:annotations,
 				:created_at,
@@ -563,6 +584,13 @@ func (ds *SQLStore) UpdateFn(ctx context.Context, fn *models.Fn) (*models.Fn, er
 				memory = :memory,
 				timeout = :timeout,
 				idle_timeout = :idle_timeout,
+				init_timeout = :init_timeout,
+				gpus = :gpus,
+				concurrency = :concurrency,
+				tmpfs_size = :tmpfs_size,
+				fs_size = :fs_size,
+				read_only_rootfs = :read_only_rootfs,
+				cpus = :cpus,
 				config = :config,
 				annotations = :annotations,
 				updated_at = :updated_at
@@ -52,6 +52,20 @@ func EmptyAnnotations() Annotations {
 	return nil
 }
 
+// AnnotationsFromRaw returns annotations with the given compacted JSON values, as
+// returned by Get. Values are neither parsed nor validated.
+func AnnotationsFromRaw(raw map[string][]byte) Annotations {
+	if len(raw) == 0 {
+		return nil
+	}
+	m := make(Annotations, len(raw))
+	for k, v := range raw {
+		val := annotationValue(v)
+		m[k] = &val
+	}
+	return m
+}
+
 func (mv *annotationValue) String() string {
 	return string(*mv)
 }
@@ -149,6 +163,67 @@ func (m Annotations) Validate() APIError {
 	if len(m) > maxAnnotationsKeys {
 		return ErrTooManyAnnotationKeys
 	}
+
+	if _, err := m.RunnerConstraints(); err != nil {
+		return ErrInvalidRunnerConstraints
+	}
+	if _, err := m.RunnerPool(); err != nil {
+		return ErrInvalidRunnerPool
+	}
+	if _, err := m.PlacementClass(); err != nil {
+		return ErrInvalidPlacementClass
+	}
+	if _, err := m.SessionHeader(); err != nil {
+		return ErrInvalidSessionHeader
+	}
+	if _, err := m.Runtime(); err != nil {
+		return ErrInvalidRuntime
+	}
+	if _, err := m.RuntimeClass(); err != nil {
+		return ErrInvalidRuntimeClass
+	}
+	if _, err := m.MinWarm(); err != nil {
+		return ErrInvalidMinWarm
+	}
+	if _, err := m.Protocol(); err != nil {
+		return ErrInvalidProtocol
+	}
+	if _, err := m.Secrets(); err != nil {
+		return ErrInvalidSecrets
+	}
+	if _, err := m.Sidecars(); err != nil {
+		return ErrInvalidSidecars
+	}
+	if _, err := m.DNS(); err != nil {
+		return ErrInvalidDNS
+	}
+	if _, err := m.Egress(); err != nil {
+		return ErrInvalidEgress
+	}
+	if _, err := m.ImageSignature(); err != nil {
+		return ErrInvalidImageSignature
+	}
+	if _, err := m.Platform(); err != nil {
+		return ErrInvalidPlatform
+	}
+	if _, err := m.BlockIO(); err != nil {
+		return ErrInvalidBlockIO
+	}
+	if _, err := m.ConfigTemplates(); err != nil {
+		return ErrInvalidConfigTemplates
+	}
+	if _, err := m.FairShareWeight(); err != nil {
+		return ErrInvalidFairShareWeight
+	}
+	if _, err := m.CallPriority(); err != nil {
+		return ErrInvalidCallPriority
+	}
+	if _, err := m.ResponseCacheTTL(); err != nil {
+		return ErrInvalidResponseCacheTTL
+	}
+	if _, err := m.Hedge(); err != nil {
+		return ErrInvalidHedge
+	}
 	return nil
 }
 
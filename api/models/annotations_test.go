@@ -1,11 +1,18 @@
 package models
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"net/http"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type testObj struct {
@@ -301,3 +308,509 @@ func TestGetAnnotations(t *testing.T) {
 		t.Error("Expected error trying to retrieve a string value for array annotation")
 	}
 }
+
+func TestRunnerConstraintsAnnotation(t *testing.T) {
+	constraints, err := EmptyAnnotations().RunnerConstraints()
+	if constraints != nil || err != nil {
+		t.Fatalf("Expected no constraints, got %v %v", constraints, err)
+	}
+
+	md := EmptyAnnotations().withRawKey(RunnerConstraintsAnnotation, `{"gpu":"true","zone":"phx-1"}`)
+	constraints, err = md.RunnerConstraints()
+	expected := map[string]string{"gpu": "true", "zone": "phx-1"}
+	if err != nil || !reflect.DeepEqual(constraints, expected) {
+		t.Fatalf("Expected constraints %v, got %v %v", expected, constraints, err)
+	}
+	if md.Validate() != nil {
+		t.Fatalf("Unexpected validation error %v", md.Validate())
+	}
+
+	for _, val := range []string{`"gpu=true"`, `{"gpu":true}`, `["gpu"]`} {
+		md = EmptyAnnotations().withRawKey(RunnerConstraintsAnnotation, val)
+		if md.Validate() != ErrInvalidRunnerConstraints {
+			t.Fatalf("Expected invalid runner constraints for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestRunnerPoolAnnotation(t *testing.T) {
+	pool, err := EmptyAnnotations().RunnerPool()
+	if pool != "" || err != nil {
+		t.Fatalf("Expected no runner pool, got %q %v", pool, err)
+	}
+
+	md, _ := EmptyAnnotations().With(RunnerPoolAnnotation, "gpu")
+	pool, err = md.RunnerPool()
+	if pool != "gpu" || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected runner pool gpu, got %q %v %v", pool, err, md.Validate())
+	}
+
+	md = EmptyAnnotations().withRawKey(RunnerPoolAnnotation, `{"name":"gpu"}`)
+	if md.Validate() != ErrInvalidRunnerPool {
+		t.Fatalf("Expected invalid runner pool, got %v", md.Validate())
+	}
+}
+
+func TestPlacementClassAnnotation(t *testing.T) {
+	class, err := EmptyAnnotations().PlacementClass()
+	if class != PlacementClassStandard || err != nil {
+		t.Fatalf("Expected standard placement class, got %q %v", class, err)
+	}
+
+	md, _ := EmptyAnnotations().With(PlacementClassAnnotation, PlacementClassReserved)
+	class, err = md.PlacementClass()
+	if class != PlacementClassReserved || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected reserved placement class, got %q %v %v", class, err, md.Validate())
+	}
+
+	for _, val := range []string{`"gold"`, `"Reserved"`, `1`} {
+		md = EmptyAnnotations().withRawKey(PlacementClassAnnotation, val)
+		if md.Validate() != ErrInvalidPlacementClass {
+			t.Fatalf("Expected invalid placement class for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestSessionHeaderAnnotation(t *testing.T) {
+	header, err := EmptyAnnotations().SessionHeader()
+	if header != "" || err != nil {
+		t.Fatalf("Expected no session header, got %q %v", header, err)
+	}
+
+	md, _ := EmptyAnnotations().With(SessionHeaderAnnotation, "Fn-Session-Id")
+	header, err = md.SessionHeader()
+	if header != "Fn-Session-Id" || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected session header Fn-Session-Id, got %q %v %v", header, err, md.Validate())
+	}
+
+	for _, val := range []string{`"Fn Session"`, `"Fn-Session:"`, `true`} {
+		md = EmptyAnnotations().withRawKey(SessionHeaderAnnotation, val)
+		if md.Validate() != ErrInvalidSessionHeader {
+			t.Fatalf("Expected invalid session header for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestRuntimeAnnotation(t *testing.T) {
+	runtime, err := EmptyAnnotations().Runtime()
+	if runtime != "" || err != nil {
+		t.Fatalf("Expected no runtime, got %q %v", runtime, err)
+	}
+
+	md, _ := EmptyAnnotations().With(RuntimeAnnotation, "runsc")
+	runtime, err = md.Runtime()
+	if runtime != "runsc" || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected runtime runsc, got %q %v %v", runtime, err, md.Validate())
+	}
+
+	for _, val := range []string{`"-runsc"`, `"runsc --debug"`, `"../runc"`, `1`} {
+		md = EmptyAnnotations().withRawKey(RuntimeAnnotation, val)
+		if md.Validate() != ErrInvalidRuntime {
+			t.Fatalf("Expected invalid runtime for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestRuntimeClassAnnotation(t *testing.T) {
+	class, err := EmptyAnnotations().RuntimeClass()
+	if class != "" || err != nil {
+		t.Fatalf("Expected no runtime class, got %q %v", class, err)
+	}
+
+	md, _ := EmptyAnnotations().With(RuntimeClassAnnotation, "kata")
+	class, err = md.RuntimeClass()
+	if class != "kata" || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected runtime class kata, got %q %v %v", class, err, md.Validate())
+	}
+
+	for _, val := range []string{`"kata vm"`, `"kata=runc"`, `{}`} {
+		md = EmptyAnnotations().withRawKey(RuntimeClassAnnotation, val)
+		if md.Validate() != ErrInvalidRuntimeClass {
+			t.Fatalf("Expected invalid runtime class for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestProtocolAnnotation(t *testing.T) {
+	protocol, err := EmptyAnnotations().Protocol()
+	if protocol != ProtocolHTTPStream || err != nil {
+		t.Fatalf("Expected the http-stream protocol, got %s %v", protocol, err)
+	}
+
+	md, _ := EmptyAnnotations().With(ProtocolAnnotation, ProtocolGRPC)
+	protocol, err = md.Protocol()
+	if protocol != ProtocolGRPC || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected the grpc protocol, got %s %v %v", protocol, err, md.Validate())
+	}
+
+	for _, val := range []string{`"http"`, `1`, `"GRPC"`} {
+		md = EmptyAnnotations().withRawKey(ProtocolAnnotation, val)
+		if md.Validate() != ErrInvalidProtocol {
+			t.Fatalf("Expected invalid protocol for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestSecretsAnnotation(t *testing.T) {
+	secrets, err := EmptyAnnotations().Secrets()
+	if secrets != nil || err != nil {
+		t.Fatalf("Expected no secrets, got %v %v", secrets, err)
+	}
+
+	want := []Secret{{Ref: "db#password", Env: "DB_PASSWORD"}, {Ref: "certs/tls#key", File: "tls.key"}}
+	md, _ := EmptyAnnotations().With(SecretsAnnotation, want)
+	secrets, err = md.Secrets()
+	if !reflect.DeepEqual(secrets, want) || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected secrets %v, got %v %v %v", want, secrets, err, md.Validate())
+	}
+
+	for _, val := range []string{
+		`{"ref": "secret/data/db#password", "env": "DB_PASSWORD"}`,
+		`[{"env": "DB_PASSWORD"}]`,
+		`[{"ref": "secret/data/db#password"}]`,
+		`[{"ref": "secret/data/db#password", "env": "DB_PASSWORD", "file": "password"}]`,
+		`[{"ref": "secret/data/db#password", "env": "FN_APP_ID"}]`,
+		`[{"ref": "secret/data/db#password", "env": "DB PASSWORD"}]`,
+		`[{"ref": "secret/data/db#password", "file": "../password"}]`,
+		`[{"ref": "secret/data/db#password", "file": ".."}]`,
+		`[{"ref": "a", "env": "DB_PASSWORD"}, {"ref": "b", "env": "DB_PASSWORD"}]`,
+		`[{"ref": "#password", "env": "DB_PASSWORD"}]`,
+		`[{"ref": "/secret/data/db#password", "env": "DB_PASSWORD"}]`,
+		`[{"ref": "../other-app/db#password", "env": "DB_PASSWORD"}]`,
+		`[{"ref": "db/../../other-app/db#password", "env": "DB_PASSWORD"}]`,
+		`[{"ref": "./db#password", "env": "DB_PASSWORD"}]`,
+		`[{"ref": "db//password", "env": "DB_PASSWORD"}]`,
+	} {
+		md = EmptyAnnotations().withRawKey(SecretsAnnotation, val)
+		if md.Validate() != ErrInvalidSecrets {
+			t.Fatalf("Expected invalid secrets for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestSidecarsAnnotation(t *testing.T) {
+	sidecars, err := EmptyAnnotations().Sidecars()
+	if sidecars != nil || err != nil {
+		t.Fatalf("Expected no sidecars, got %v %v", sidecars, err)
+	}
+
+	want := []Sidecar{
+		{Name: "fetch-model", Image: "busybox", Cmd: []string{"wget", "http://models/model.bin"}, Init: true},
+		{Name: "proxy", Image: "envoyproxy/envoy:v1.14.1", Env: map[string]string{"LOG_LEVEL": "info"}},
+	}
+	md, _ := EmptyAnnotations().With(SidecarsAnnotation, want)
+	sidecars, err = md.Sidecars()
+	if !reflect.DeepEqual(sidecars, want) || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected sidecars %v, got %v %v %v", want, sidecars, err, md.Validate())
+	}
+
+	for _, val := range []string{
+		`{"name": "proxy", "image": "envoyproxy/envoy"}`,
+		`[{"name": "proxy"}]`,
+		`[{"image": "envoyproxy/envoy"}]`,
+		`[{"name": "Proxy", "image": "envoyproxy/envoy"}]`,
+		`[{"name": "../proxy", "image": "envoyproxy/envoy"}]`,
+		`[{"name": "proxy", "image": "envoyproxy/envoy"}, {"name": "proxy", "image": "busybox"}]`,
+	} {
+		md = EmptyAnnotations().withRawKey(SidecarsAnnotation, val)
+		if md.Validate() != ErrInvalidSidecars {
+			t.Fatalf("Expected invalid sidecars for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestDNSAnnotation(t *testing.T) {
+	dns, err := EmptyAnnotations().DNS()
+	if dns != nil || err != nil {
+		t.Fatalf("Expected no DNS, got %v %v", dns, err)
+	}
+
+	want := &DNS{Servers: []string{"10.0.0.2", "fd00::2"}, Search: []string{"svc.internal"}, Hosts: map[string]string{"db.internal": "10.0.0.5"}}
+	md, _ := EmptyAnnotations().With(DNSAnnotation, want)
+	dns, err = md.DNS()
+	if !reflect.DeepEqual(dns, want) || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected DNS %v, got %v %v %v", want, dns, err, md.Validate())
+	}
+
+	for _, val := range []string{
+		`["10.0.0.2"]`,
+		`{"servers": ["dns.internal"]}`,
+		`{"servers": ["10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"]}`,
+		`{"search": ["svc internal"]}`,
+		`{"search": ["-svc.internal"]}`,
+		`{"hosts": {"db.internal": "db"}}`,
+		`{"hosts": {"db internal": "10.0.0.5"}}`,
+	} {
+		md = EmptyAnnotations().withRawKey(DNSAnnotation, val)
+		if md.Validate() != ErrInvalidDNS {
+			t.Fatalf("Expected invalid DNS for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestEgressAnnotation(t *testing.T) {
+	egress, err := EmptyAnnotations().Egress()
+	if egress != nil || err != nil {
+		t.Fatalf("Expected no egress policy, got %v %v", egress, err)
+	}
+
+	for _, want := range []*Egress{
+		{Disabled: true},
+		{Allow: []string{"10.0.0.0/8", "fd00::/8", "1.2.3.4", "api.example.com"}},
+	} {
+		md, _ := EmptyAnnotations().With(EgressAnnotation, want)
+		egress, err = md.Egress()
+		if !reflect.DeepEqual(egress, want) || err != nil || md.Validate() != nil {
+			t.Fatalf("Expected egress policy %v, got %v %v %v", want, egress, err, md.Validate())
+		}
+	}
+
+	for _, val := range []string{
+		`["10.0.0.0/8"]`,
+		`{}`,
+		`{"disabled": false}`,
+		`{"disabled": true, "allow": ["10.0.0.0/8"]}`,
+		`{"allow": ["10.0.0.0/33"]}`,
+		`{"allow": ["api example.com"]}`,
+		`{"allow": ["*.example.com"]}`,
+	} {
+		md := EmptyAnnotations().withRawKey(EgressAnnotation, val)
+		if md.Validate() != ErrInvalidEgress {
+			t.Fatalf("Expected invalid egress policy for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestImageSignatureAnnotation(t *testing.T) {
+	sig, err := EmptyAnnotations().ImageSignature()
+	if sig != nil || err != nil {
+		t.Fatalf("Expected no image signature keys, got %v %v", sig, err)
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	want := &ImageSignature{Keys: []string{key}}
+	md, _ := EmptyAnnotations().With(ImageSignatureAnnotation, want)
+	sig, err = md.ImageSignature()
+	if !reflect.DeepEqual(sig, want) || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected image signature keys %v, got %v %v %v", want, sig, err, md.Validate())
+	}
+
+	for _, val := range []interface{}{
+		[]string{key},
+		ImageSignature{},
+		ImageSignature{Keys: []string{"not a key"}},
+		ImageSignature{Keys: []string{strings.Replace(key, "PUBLIC KEY", "CERTIFICATE", -1)[:40]}},
+	} {
+		raw, _ := json.Marshal(val)
+		md = EmptyAnnotations().withRawKey(ImageSignatureAnnotation, string(raw))
+		if md.Validate() != ErrInvalidImageSignature {
+			t.Fatalf("Expected invalid image signature keys for %s, got %v", raw, md.Validate())
+		}
+	}
+}
+
+func TestPlatformAnnotation(t *testing.T) {
+	platform, err := EmptyAnnotations().Platform()
+	if platform != nil || err != nil {
+		t.Fatalf("Expected no platform, got %v %v", platform, err)
+	}
+
+	md, _ := EmptyAnnotations().With(PlatformAnnotation, "Linux/aarch64/v8")
+	platform, err = md.Platform()
+	want := &Platform{OS: "linux", Arch: "arm64", Variant: "v8"}
+	if !reflect.DeepEqual(platform, want) || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected platform %v, got %v %v %v", want, platform, err, md.Validate())
+	}
+	if platform.String() != "linux/arm64/v8" {
+		t.Fatalf("Unexpected platform %s", platform)
+	}
+
+	// the platform constrains the platform labels of runners
+	md = md.withRawKey(RunnerConstraintsAnnotation, `{"zone":"phx-1","arch":"amd64"}`)
+	constraints, err := md.RunnerConstraints()
+	expected := map[string]string{"zone": "phx-1", RunnerOSLabel: "linux", RunnerArchLabel: "arm64"}
+	if err != nil || !reflect.DeepEqual(constraints, expected) {
+		t.Fatalf("Expected constraints %v, got %v %v", expected, constraints, err)
+	}
+
+	for _, val := range []string{`"linux"`, `"linux/arm/v7/extra"`, `"linux/"`, `"linux/arm 64"`, `["linux/arm64"]`} {
+		md = EmptyAnnotations().withRawKey(PlatformAnnotation, val)
+		if md.Validate() != ErrInvalidPlatform {
+			t.Fatalf("Expected invalid platform for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestBlockIOAnnotation(t *testing.T) {
+	blkio, err := EmptyAnnotations().BlockIO()
+	if blkio != nil || err != nil {
+		t.Fatalf("Expected no block IO, got %v %v", blkio, err)
+	}
+
+	want := &BlockIO{Weight: 100, WriteBps: 10 << 20, WriteIOps: 500}
+	md, _ := EmptyAnnotations().With(BlockIOAnnotation, want)
+	blkio, err = md.BlockIO()
+	if !reflect.DeepEqual(blkio, want) || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected block IO %v, got %v %v %v", want, blkio, err, md.Validate())
+	}
+
+	for _, val := range []string{
+		`[100]`,
+		`{"weight": 5}`,
+		`{"weight": 1001}`,
+		`{"write_bps": -1}`,
+		`{"read_iops": "500"}`,
+	} {
+		md = EmptyAnnotations().withRawKey(BlockIOAnnotation, val)
+		if md.Validate() != ErrInvalidBlockIO {
+			t.Fatalf("Expected invalid block IO for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestConfigTemplatesAnnotation(t *testing.T) {
+	on, err := EmptyAnnotations().ConfigTemplates()
+	if on || err != nil {
+		t.Fatalf("Expected no config templates, got %v %v", on, err)
+	}
+
+	md, _ := EmptyAnnotations().With(ConfigTemplatesAnnotation, true)
+	on, err = md.ConfigTemplates()
+	if !on || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected config templates, got %v %v %v", on, err, md.Validate())
+	}
+
+	for _, val := range []string{`1`, `"true"`, `{}`} {
+		md = EmptyAnnotations().withRawKey(ConfigTemplatesAnnotation, val)
+		if md.Validate() != ErrInvalidConfigTemplates {
+			t.Fatalf("Expected invalid config templates for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestFairShareWeightAnnotation(t *testing.T) {
+	w, err := EmptyAnnotations().FairShareWeight()
+	if w != 1 || err != nil {
+		t.Fatalf("Expected weight 1, got %d %v", w, err)
+	}
+
+	md, _ := EmptyAnnotations().With(FairShareWeightAnnotation, 5)
+	w, err = md.FairShareWeight()
+	if w != 5 || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected weight 5, got %d %v %v", w, err, md.Validate())
+	}
+
+	for _, val := range []string{`0`, `-1`, `1.5`, `"2"`, `101`} {
+		md = EmptyAnnotations().withRawKey(FairShareWeightAnnotation, val)
+		if md.Validate() != ErrInvalidFairShareWeight {
+			t.Fatalf("Expected invalid fair share weight for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestCallPriorityAnnotation(t *testing.T) {
+	priority, err := EmptyAnnotations().CallPriority()
+	if priority != "" || err != nil {
+		t.Fatalf("Expected no priority, got %q %v", priority, err)
+	}
+
+	md, _ := EmptyAnnotations().With(CallPriorityAnnotation, CallPriorityBatch)
+	priority, err = md.CallPriority()
+	if priority != CallPriorityBatch || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected batch priority, got %q %v %v", priority, err, md.Validate())
+	}
+
+	for _, val := range []string{`"urgent"`, `1`} {
+		md = EmptyAnnotations().withRawKey(CallPriorityAnnotation, val)
+		if md.Validate() != ErrInvalidCallPriority {
+			t.Fatalf("Expected invalid priority for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestResponseCacheTTLAnnotation(t *testing.T) {
+	ttl, err := EmptyAnnotations().ResponseCacheTTL()
+	if ttl != 0 || err != nil {
+		t.Fatalf("Expected no response cache, got %v %v", ttl, err)
+	}
+
+	md, _ := EmptyAnnotations().With(ResponseCacheTTLAnnotation, 30)
+	ttl, err = md.ResponseCacheTTL()
+	if ttl != 30*time.Second || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected a TTL of 30s, got %v %v %v", ttl, err, md.Validate())
+	}
+
+	for _, val := range []string{`0`, `-1`, `1.5`, `"30s"`, `86401`} {
+		md = EmptyAnnotations().withRawKey(ResponseCacheTTLAnnotation, val)
+		if md.Validate() != ErrInvalidResponseCacheTTL {
+			t.Fatalf("Expected invalid response cache TTL for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestHedgeAnnotation(t *testing.T) {
+	on, err := EmptyAnnotations().Hedge()
+	if on || err != nil {
+		t.Fatalf("Expected no hedging, got %v %v", on, err)
+	}
+
+	md, _ := EmptyAnnotations().With(HedgeAnnotation, true)
+	on, err = md.Hedge()
+	if !on || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected hedging, got %v %v %v", on, err, md.Validate())
+	}
+
+	for _, val := range []string{`1`, `"true"`, `{}`} {
+		md = EmptyAnnotations().withRawKey(HedgeAnnotation, val)
+		if md.Validate() != ErrInvalidHedge {
+			t.Fatalf("Expected invalid hedge for %s, got %v", val, md.Validate())
+		}
+	}
+}
+
+func TestCallIdempotent(t *testing.T) {
+	for _, tc := range []struct {
+		call       Call
+		idempotent bool
+	}{
+		{Call{Type: TypeSync, Method: "GET"}, true},
+		{Call{Type: TypeSync, Method: "PUT"}, true},
+		{Call{Type: TypeSync, Method: "POST"}, false},
+		{Call{Type: TypeSync, Method: "POST", Headers: http.Header{IdempotencyKeyHeader: {"key"}}}, true},
+		{Call{Type: TypeDetached, Method: "GET"}, false},
+		{Call{Type: TypeStream, Method: "POST", Headers: http.Header{IdempotencyKeyHeader: {"key"}}}, false},
+	} {
+		if tc.call.Idempotent() != tc.idempotent {
+			t.Fatalf("Expected idempotent=%v for %s %s call with %v", tc.idempotent, tc.call.Type, tc.call.Method, tc.call.Headers)
+		}
+	}
+}
+
+func TestMinWarmAnnotation(t *testing.T) {
+	n, err := EmptyAnnotations().MinWarm()
+	if n != 0 || err != nil {
+		t.Fatalf("Expected no warm containers, got %d %v", n, err)
+	}
+
+	md, _ := EmptyAnnotations().With(MinWarmAnnotation, 2)
+	n, err = md.MinWarm()
+	if n != 2 || err != nil || md.Validate() != nil {
+		t.Fatalf("Expected 2 warm containers, got %d %v %v", n, err, md.Validate())
+	}
+
+	for _, val := range []string{`-1`, `1.5`, `"2"`, `101`} {
+		md = EmptyAnnotations().withRawKey(MinWarmAnnotation, val)
+		if md.Validate() != ErrInvalidMinWarm {
+			t.Fatalf("Expected invalid min warm for %s, got %v", val, md.Validate())
+		}
+	}
+}
@@ -76,6 +76,12 @@ func (a *App) Validate() error {
 		return err
 	}
 
+	if on, _ := a.Annotations.ConfigTemplates(); on {
+		if err := a.Config.validateSecretTemplates(); err != nil {
+			return err
+		}
+	}
+
 	if a.SyslogURL != nil && *a.SyslogURL != "" {
 		url, err := url.Parse(strings.TrimSpace(*a.SyslogURL))
 		if err == nil {
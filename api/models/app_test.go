@@ -179,6 +179,7 @@ func TestValidateAppName(t *testing.T) {
 func TestValidateApp(t *testing.T) {
 	valid_name := "valid_name"
 	valid_syslog := "tcp://localhost:13371"
+	templates, _ := EmptyAnnotations().With(ConfigTemplatesAnnotation, true)
 
 	testCases := []struct {
 		App  App
@@ -186,6 +187,8 @@ func TestValidateApp(t *testing.T) {
 	}{
 		{App{Name: valid_name, SyslogURL: &valid_syslog}, nil},
 		{App{Name: ""}, ErrMissingName},
+		{App{Name: valid_name, Annotations: templates, Config: Config{"KEY": "${secret:tls#key}"}}, nil},
+		{App{Name: valid_name, Annotations: templates, Config: Config{"KEY": "${secret:/secret/data/tls#key}"}}, ErrInvalidConfigSecretTemplate},
 	}
 
 	for _, testCase := range testCases {
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+)
+
+// BlockIOAnnotation is the annotation of an app or fn that throttles the block IO of its
+// containers, so that a fn writing to disk does not starve the other fns of its runners,
+// as a BlockIO object, eg. {"weight": 100, "write_bps": 10485760, "write_iops": 500}.
+// A fn annotation replaces the block IO of its app. Rates are throttled on the block
+// devices of the runner, runners with no block devices only apply the weight.
+const BlockIOAnnotation = "fnproject.io/container/blkio"
+
+const (
+	// minBlockIOWeight and maxBlockIOWeight are the bounds of the blkio weight of cgroups
+	minBlockIOWeight = 10
+	maxBlockIOWeight = 1000
+)
+
+// BlockIO is the block IO throttling of the containers of a fn, see BlockIOAnnotation. Zero
+// values are not throttled.
+type BlockIO struct {
+	// Weight is the share of the block IO of the containers, relative to the others, from
+	// 10 to 1000
+	Weight uint16 `json:"weight,omitempty"`
+	// ReadBps and WriteBps are the bytes per second the containers may read and write
+	ReadBps  uint64 `json:"read_bps,omitempty"`
+	WriteBps uint64 `json:"write_bps,omitempty"`
+	// ReadIOps and WriteIOps are the IO operations per second the containers may do
+	ReadIOps  uint64 `json:"read_iops,omitempty"`
+	WriteIOps uint64 `json:"write_iops,omitempty"`
+}
+
+// BlockIO returns the block IO throttling in the annotations, nil if there is none
+func (m Annotations) BlockIO() (*BlockIO, error) {
+	v, ok := m.Get(BlockIOAnnotation)
+	if !ok {
+		return nil, nil
+	}
+	var blkio BlockIO
+	if err := json.Unmarshal(v, &blkio); err != nil {
+		return nil, ErrInvalidBlockIO
+	}
+	if blkio.Weight != 0 && (blkio.Weight < minBlockIOWeight || blkio.Weight > maxBlockIOWeight) {
+		return nil, ErrInvalidBlockIO
+	}
+	return &blkio, nil
+}
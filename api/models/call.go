@@ -15,6 +15,9 @@ const (
 	TypeSync = "sync"
 	// TypeDetached is used for calls which return an ack to the caller as soon as the call starts
 	TypeDetached = "detached"
+	// TypeStream is used for calls which stream messages both ways between the caller and the
+	// function for as long as the call runs, eg. over a WebSocket
+	TypeStream = "stream"
 )
 
 var possibleStatuses = [...]string{"delayed", "queued", "running", "success", "error", "cancelled"}
@@ -88,9 +91,19 @@ type Call struct {
 	// Hot function idle timeout in seconds before termination.
 	IdleTimeout int32 `json:"idle_timeout,omitempty" db:"-"`
 
+	// InitTimeout is the max time in seconds the cold start of a container for this call takes,
+	// zero for the timeouts of the agent.
+	InitTimeout int32 `json:"init_timeout,omitempty" db:"-"`
+
 	// Tmpfs size in megabytes.
 	TmpFsSize uint32 `json:"tmpfs_size,omitempty" db:"-"`
 
+	// FsSize is the quota of the writable layer of the container of this call, in megabytes.
+	FsSize uint64 `json:"fs_size,omitempty" db:"-"`
+
+	// ReadOnlyRootFs mounts the root file system of the container of this call read only.
+	ReadOnlyRootFs bool `json:"read_only_rootfs,omitempty" db:"-"`
+
 	// Memory is the amount of RAM this call is allocated.
 	Memory uint64 `json:"memory,omitempty" db:"-"`
 
@@ -99,6 +112,12 @@ type Call struct {
 	// *) as floating point number "0.1" which is 1/10 of a CPU
 	CPUs MilliCPUs `json:"cpus,omitempty" db:"-"`
 
+	// GPUs is the number of GPUs this call is allocated.
+	GPUs uint64 `json:"gpus,omitempty" db:"-"`
+
+	// Concurrency is the number of calls the hot container running this call takes at once.
+	Concurrency uint64 `json:"concurrency,omitempty" db:"-"`
+
 	// Config is the set of configuration variables for the call
 	Config Config `json:"config,omitempty" db:"-"`
 
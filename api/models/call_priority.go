@@ -0,0 +1,36 @@
+package models
+
+// CallPriorityAnnotation is the annotation of a trigger that sets the priority class of the
+// calls it invokes, CallPriorityInteractive or CallPriorityBatch. Saturated runners serve
+// interactive calls ahead of batch calls. Calls of triggers without the annotation are batch
+// if they are detached, and interactive otherwise.
+const CallPriorityAnnotation = "fnproject.io/trigger/priority"
+
+// CallPriorityHeader is the header of an invoke request that sets the priority class of the
+// call, like CallPriorityAnnotation
+const CallPriorityHeader = "Fn-Call-Priority"
+
+// Priority classes of calls, see CallPriorityAnnotation
+const (
+	// CallPriorityInteractive calls have a client waiting for the response
+	CallPriorityInteractive = "interactive"
+	// CallPriorityBatch calls have nobody waiting for the response, eg. detached calls
+	CallPriorityBatch = "batch"
+)
+
+// ValidCallPriority returns whether priority is CallPriorityInteractive or CallPriorityBatch
+func ValidCallPriority(priority string) bool {
+	return priority == CallPriorityInteractive || priority == CallPriorityBatch
+}
+
+// CallPriority returns the priority class of calls in the annotations, empty if there is none
+func (m Annotations) CallPriority() (string, error) {
+	if _, ok := m.Get(CallPriorityAnnotation); !ok {
+		return "", nil
+	}
+	priority, err := m.GetString(CallPriorityAnnotation)
+	if err != nil || !ValidCallPriority(priority) {
+		return "", ErrInvalidCallPriority
+	}
+	return priority, nil
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ConfigTemplatesAnnotation is the annotation of an app or fn that turns on the templates of
+// its config, true or false. Runners expand the templates of config values when they create
+// containers: ${NAME} is the value of the config var NAME of the fn or its app, or of a var
+// the runner sets, eg. FN_APP_ID, ${NAME:-default} is default if there is no NAME,
+// ${secret:REF} is the secret REF of the app in the secret store of the runner (see
+// SecretsAnnotation) and $${ is a literal ${. A fn annotation replaces the one of its app.
+const ConfigTemplatesAnnotation = "fnproject.io/fn/config-templates"
+
+// ConfigTemplates returns whether the templates of the config are on in the annotations,
+// false if there is no annotation
+func (m Annotations) ConfigTemplates() (bool, error) {
+	v, ok := m.Get(ConfigTemplatesAnnotation)
+	if !ok {
+		return false, nil
+	}
+	var on bool
+	if err := json.Unmarshal(v, &on); err != nil {
+		return false, ErrInvalidConfigTemplates
+	}
+	return on, nil
+}
+
+// validateSecretTemplates returns ErrInvalidConfigSecretTemplate if the ref of a
+// ${secret:REF} template of the values of c could reach out of the secrets of the app, see
+// ValidSecretRef
+func (c Config) validateSecretTemplates() error {
+	for _, v := range c {
+		for {
+			i := strings.IndexByte(v, '$')
+			if i < 0 {
+				break
+			}
+			v = v[i:]
+			switch {
+			case strings.HasPrefix(v, "$${"):
+				v = v[3:]
+			case strings.HasPrefix(v, "${secret:"):
+				end := strings.IndexByte(v, '}')
+				if end < 0 {
+					// unclosed templates fail when they are expanded
+					end = len(v) - 1
+				}
+				if !ValidSecretRef(v[len("${secret:"):end]) {
+					return ErrInvalidConfigSecretTemplate
+				}
+				v = v[end+1:]
+			default:
+				v = v[1:]
+			}
+		}
+	}
+	return nil
+}
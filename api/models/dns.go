@@ -0,0 +1,67 @@
+package models
+
+import (
+	"encoding/json"
+	"net"
+	"regexp"
+)
+
+// DNSAnnotation is the annotation of an app or fn that customizes name resolution in its
+// containers, so that fns can resolve internal service names, as a DNS object, eg.
+// {"servers": ["10.0.0.2"], "search": ["svc.internal"], "hosts": {"db.internal":
+// "10.0.0.5"}}. A fn annotation replaces the DNS of its app. Runners whose containers share
+// the network of a prefork pool container ignore it.
+const DNSAnnotation = "fnproject.io/container/dns"
+
+const (
+	// maxDNSServers is the number of name servers the resolver of the libc uses
+	maxDNSServers = 3
+	// maxDNSSearch is the number of search domains the resolver of the libc uses
+	maxDNSSearch = 6
+	// maxDNSHosts is the largest number of extra hosts entries of DNSAnnotation
+	maxDNSHosts = 64
+)
+
+// hostnamePattern matches host and domain names
+var hostnamePattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// DNS is the name resolution of the containers of a fn, see DNSAnnotation
+type DNS struct {
+	// Servers are the IPs of the name servers, the ones of the runner if empty
+	Servers []string `json:"servers,omitempty"`
+	// Search are the search domains, the ones of the runner if empty
+	Search []string `json:"search,omitempty"`
+	// Hosts are the IPs of host names, added to /etc/hosts
+	Hosts map[string]string `json:"hosts,omitempty"`
+}
+
+// DNS returns the DNS customization in the annotations, nil if there is none
+func (m Annotations) DNS() (*DNS, error) {
+	v, ok := m.Get(DNSAnnotation)
+	if !ok {
+		return nil, nil
+	}
+	var dns DNS
+	if err := json.Unmarshal(v, &dns); err != nil {
+		return nil, ErrInvalidDNS
+	}
+	if len(dns.Servers) > maxDNSServers || len(dns.Search) > maxDNSSearch || len(dns.Hosts) > maxDNSHosts {
+		return nil, ErrInvalidDNS
+	}
+	for _, ip := range dns.Servers {
+		if net.ParseIP(ip) == nil {
+			return nil, ErrInvalidDNS
+		}
+	}
+	for _, domain := range dns.Search {
+		if len(domain) > 253 || !hostnamePattern.MatchString(domain) {
+			return nil, ErrInvalidDNS
+		}
+	}
+	for host, ip := range dns.Hosts {
+		if len(host) > 253 || !hostnamePattern.MatchString(host) || net.ParseIP(ip) == nil {
+			return nil, ErrInvalidDNS
+		}
+	}
+	return &dns, nil
+}
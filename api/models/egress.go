@@ -0,0 +1,55 @@
+package models
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+)
+
+// EgressAnnotation is the annotation of an app or fn that restricts the outbound
+// connections of its containers, as an egress object, either {"disabled": true} to cut
+// them off the network, or an allow list of CIDRs, IPs and host names, eg. {"allow":
+// ["10.0.0.0/8", "api.example.com"]}. Name resolution and replies to inbound connections
+// stay allowed. A fn annotation replaces the egress of its app.
+const EgressAnnotation = "fnproject.io/container/egress"
+
+// maxEgressAllow is the largest number of entries of the allow list of EgressAnnotation
+const maxEgressAllow = 64
+
+// Egress is the outbound connectivity of the containers of a fn, see EgressAnnotation
+type Egress struct {
+	// Disabled cuts the containers off the network
+	Disabled bool `json:"disabled,omitempty"`
+	// Allow are the CIDRs, IPs and host names the containers may connect to
+	Allow []string `json:"allow,omitempty"`
+}
+
+// Egress returns the egress policy in the annotations, nil if there is none
+func (m Annotations) Egress() (*Egress, error) {
+	v, ok := m.Get(EgressAnnotation)
+	if !ok {
+		return nil, nil
+	}
+	var egress Egress
+	if err := json.Unmarshal(v, &egress); err != nil {
+		return nil, ErrInvalidEgress
+	}
+	if egress.Disabled == (len(egress.Allow) > 0) || len(egress.Allow) > maxEgressAllow {
+		return nil, ErrInvalidEgress
+	}
+	for _, dest := range egress.Allow {
+		if !validEgressDest(dest) {
+			return nil, ErrInvalidEgress
+		}
+	}
+	return &egress, nil
+}
+
+// validEgressDest reports whether dest is a CIDR, an IP or a host name
+func validEgressDest(dest string) bool {
+	if strings.Contains(dest, "/") {
+		_, _, err := net.ParseCIDR(dest)
+		return err == nil
+	}
+	return net.ParseIP(dest) != nil || (len(dest) <= 253 && hostnamePattern.MatchString(dest))
+}
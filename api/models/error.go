@@ -34,6 +34,26 @@ var (
 		code:  http.StatusServiceUnavailable,
 		error: errors.New("Timed out - server too busy"),
 	}
+	ErrTooManyCallsInFlight = err{
+		code:  http.StatusServiceUnavailable,
+		error: errors.New("Too many calls in flight - server too busy"),
+	}
+	ErrCallPreempted = err{
+		code:  http.StatusServiceUnavailable,
+		error: errors.New("Call preempted by a call of a higher placement class - server too busy"),
+	}
+	ErrServerOverloaded = err{
+		code:  http.StatusServiceUnavailable,
+		error: errors.New("Too many calls failed to be placed - server overloaded"),
+	}
+	ErrServerDraining = err{
+		code:  http.StatusServiceUnavailable,
+		error: errors.New("Server is draining - retry on another server"),
+	}
+	ErrCallQueueFull = err{
+		code:  http.StatusTooManyRequests,
+		error: errors.New("Too many calls waiting for runners - slow down"),
+	}
 	ErrUnsupportedMediaType = err{
 		code:  http.StatusUnsupportedMediaType,
 		error: errors.New("Content Type not supported")}
@@ -99,6 +119,26 @@ var (
 		code:  http.StatusBadRequest,
 		error: fmt.Errorf("memory value is out of range. It should be between 0 and %d", MaxMemory),
 	}
+	ErrInvalidFnCPUs = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("cpus value is out of range. It should be 0 or between %s and %dm", MinFnCPUs, MaxMilliCPUs),
+	}
+	ErrInvalidGPUs = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("gpus value is out of range. It should be between 0 and %d", MaxGPUs),
+	}
+	ErrInvalidConcurrency = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("concurrency value is out of range. It should be between 0 and %d", MaxConcurrency),
+	}
+	ErrInvalidTmpFsSize = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("tmpfs_size value is out of range. It should be between 0 and %d", MaxTmpFsSize),
+	}
+	ErrInvalidFsSize = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("fs_size value is out of range. It should be between 0 and %d", MaxFsSize),
+	}
 	ErrCallResourceTooBig = err{
 		code:  http.StatusBadRequest,
 		error: fmt.Errorf("Requested CPU/Memory cannot be allocated"),
@@ -136,6 +176,102 @@ var (
 		code:  http.StatusBadRequest,
 		error: fmt.Errorf("Invalid annotation value length, annotation values may not be larger than %d bytes when serialized as JSON", maxAnnotationValueBytes),
 	}
+	ErrInvalidRunnerConstraints = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, runner constraints must be an object of runner labels to string values", RunnerConstraintsAnnotation),
+	}
+	ErrInvalidRunnerPool = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, the runner pool must be a non-empty string", RunnerPoolAnnotation),
+	}
+	ErrInvalidPlacementClass = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, the placement class must be one of %q, %q or %q", PlacementClassAnnotation, PlacementClassReserved, PlacementClassStandard, PlacementClassPreemptible),
+	}
+	ErrInvalidSessionHeader = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, the session header must be the name of a request header", SessionHeaderAnnotation),
+	}
+	ErrInvalidRuntime = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, the runtime must be the name of an OCI runtime", RuntimeAnnotation),
+	}
+	ErrRuntimeNotAllowed = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("The runtime of the %s annotation is not allowed on this runner", RuntimeAnnotation),
+	}
+	ErrInvalidRuntimeClass = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, the runtime class must be a name", RuntimeClassAnnotation),
+	}
+	ErrRuntimeClassNotAvailable = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("The runtime class of the %s annotation is not available on this runner", RuntimeClassAnnotation),
+	}
+	ErrInvalidProtocol = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, the protocol must be %s or %s", ProtocolAnnotation, ProtocolHTTPStream, ProtocolGRPC),
+	}
+	ErrInvalidSecrets = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, it must be a list of at most %d secrets with a relative ref, without . or .. elements, and either a distinct env var, not starting with FN_, or file name", SecretsAnnotation, maxSecrets),
+	}
+	ErrInvalidSidecars = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, it must be a list of at most %d sidecars with an image and a distinct lower case name", SidecarsAnnotation, maxSidecars),
+	}
+	ErrInvalidDNS = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, it must have at most %d server IPs, %d search domains and %d hosts to IPs", DNSAnnotation, maxDNSServers, maxDNSSearch, maxDNSHosts),
+	}
+	ErrInvalidEgress = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, it must either be disabled or allow at most %d CIDRs, IPs and host names", EgressAnnotation, maxEgressAllow),
+	}
+	ErrInvalidImageSignature = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, it must have between 1 and %d PEM encoded ECDSA or RSA public keys", ImageSignatureAnnotation, maxImageSignatureKeys),
+	}
+	ErrInvalidPlatform = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, it must be a platform of the form os/arch[/variant], eg. linux/arm64", PlatformAnnotation),
+	}
+	ErrInvalidBlockIO = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, it must have a weight from %d to %d and positive rates", BlockIOAnnotation, minBlockIOWeight, maxBlockIOWeight),
+	}
+	ErrInvalidConfigTemplates = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, it must be true or false", ConfigTemplatesAnnotation),
+	}
+	ErrInvalidConfigSecretTemplate = err{
+		code:  http.StatusBadRequest,
+		error: errors.New("Invalid ${secret:REF} template in config, REF must be relative to the secrets of the app, without . or .. elements"),
+	}
+	ErrInvalidFairShareWeight = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, the weight must be an integer from 1 to %d", FairShareWeightAnnotation, maxFairShareWeight),
+	}
+	ErrInvalidCallPriority = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, it must be %s or %s", CallPriorityAnnotation, CallPriorityInteractive, CallPriorityBatch),
+	}
+	ErrInvalidCallPriorityHeader = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s header, it must be %s or %s", CallPriorityHeader, CallPriorityInteractive, CallPriorityBatch),
+	}
+	ErrInvalidHedge = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, it must be true or false", HedgeAnnotation),
+	}
+	ErrInvalidResponseCacheTTL = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, the TTL must be an integer from 1 to %d seconds", ResponseCacheTTLAnnotation, maxResponseCacheTTL),
+	}
+	ErrInvalidMinWarm = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("Invalid %s annotation, the number of warm containers must be an integer from 0 to %d", MinWarmAnnotation, maxMinWarm),
+	}
 	ErrTooManyAnnotationKeys = err{
 		code:  http.StatusBadRequest,
 		error: fmt.Errorf("Invalid annotation change, new key(s) exceed maximum permitted number of annotations keys (%d)", maxAnnotationsKeys),
@@ -158,6 +294,14 @@ var (
 		code:  http.StatusInternalServerError,
 		error: errors.New("Unable to find the call handle"),
 	}
+	ErrCallExtensionsTooBig = err{
+		code:  http.StatusInternalServerError,
+		error: errors.New("Call extensions exceed the maximum size allowed by the runner client"),
+	}
+	ErrResponseHashMismatch = err{
+		code:  http.StatusBadGateway,
+		error: errors.New("Response integrity check failed"),
+	}
 	ErrServiceReservationFailure = err{
 		code:  http.StatusInternalServerError,
 		error: errors.New("Unable to service the request for the reservation period"),
@@ -196,6 +340,50 @@ var (
 		code:  http.StatusBadGateway,
 		error: fmt.Errorf("function closed pipe while receiving request"),
 	}
+	ErrInvokeStreamUnsupported = err{
+		code:  http.StatusNotImplemented,
+		error: errors.New("Stream invocations are not supported by this server"),
+	}
+	ErrInvokeGRPCUnsupported = err{
+		code:  http.StatusNotImplemented,
+		error: errors.New("gRPC invocations are not supported by this server"),
+	}
+	ErrSecretsUnsupported = err{
+		code:  http.StatusNotImplemented,
+		error: errors.New("Secrets are not supported by this runner, it has no secret store"),
+	}
+	ErrSecretsUnavailable = err{
+		code:  http.StatusBadGateway,
+		error: errors.New("The secrets of the function could not be resolved"),
+	}
+	ErrSidecarsUnsupported = err{
+		code:  http.StatusNotImplemented,
+		error: errors.New("Sidecars are not supported by this runner"),
+	}
+	ErrEgressPolicyUnsupported = err{
+		code:  http.StatusNotImplemented,
+		error: errors.New("Egress allow lists are not supported by this runner"),
+	}
+	ErrImageNotSigned = err{
+		code:  http.StatusForbidden,
+		error: errors.New("The image of the function is not signed by any of the keys it must be signed by"),
+	}
+	ErrImageSignatureUnavailable = err{
+		code:  http.StatusBadGateway,
+		error: errors.New("The signatures of the image of the function could not be fetched from its registry"),
+	}
+	ErrImageVerifyUnsupported = err{
+		code:  http.StatusNotImplemented,
+		error: errors.New("Image signatures are not verified by this runner"),
+	}
+	ErrImagePlatformMismatch = err{
+		code:  http.StatusBadRequest,
+		error: errors.New("The image of the function is not built for the platform of the runner"),
+	}
+	ErrInvokeProtocol = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("The invocation does not match the protocol of the %s annotation of the fn", ProtocolAnnotation),
+	}
 	ErrRequestContentTooBig = ferr{
 		code:  http.StatusRequestEntityTooLarge,
 		error: fmt.Errorf("Request content too large"),
@@ -280,6 +468,55 @@ func (e ferr) Code() int            { return e.code }
 // NewFuncError returns a FuncError
 func NewFuncError(err APIError) error { return ferr{code: err.Code(), error: err} }
 
+// ErrorCoder is an APIError with a code, which tells it apart from the other errors
+// of its HTTP status in API responses, see Error
+type ErrorCoder interface {
+	APIError
+	ErrorCode() string
+}
+
+// ErrorCodeFunctionOOM is the code of the errors of calls whose container ran out of memory
+const ErrorCodeFunctionOOM = "FunctionOutOfMemory"
+
+type codedFuncErr struct {
+	ferr
+	errorCode string
+}
+
+var _ ErrorCoder = codedFuncErr{}
+
+func (e codedFuncErr) ErrorCode() string { return e.errorCode }
+
+// NewCodedFuncError returns a FuncError with the given code, see ErrorCoder
+func NewCodedFuncError(err APIError, code string) error {
+	return codedFuncErr{ferr: ferr{code: err.Code(), error: err}, errorCode: code}
+}
+
+// NewFuncOOMError returns the FuncError of a call whose container was killed as it ran
+// out of its memory MB, after it was seen to use at most peak MB
+func NewFuncOOMError(memory, peak uint64) error {
+	err := fmt.Errorf("container out of memory, it used up to %dMB of its %dMB, you may want to raise fn.memory for this function", peak, memory)
+	return NewCodedFuncError(NewAPIError(http.StatusBadGateway, err), ErrorCodeFunctionOOM)
+}
+
+// GetErrorCode returns the code of err if it is an ErrorCoder, empty otherwise
+func GetErrorCode(err error) string {
+	if e, ok := err.(ErrorCoder); ok {
+		return e.ErrorCode()
+	}
+	return ""
+}
+
+// IsTooBusy returns whether err rejects a call because the server is too busy to run it,
+// and the client should retry it later
+func IsTooBusy(err error) bool {
+	switch err {
+	case ErrCallTimeoutServerBusy, ErrTooManyCallsInFlight, ErrCallPreempted, ErrServerOverloaded, ErrServerDraining, ErrCallQueueFull:
+		return true
+	}
+	return false
+}
+
 // IsFuncError checks if err is of type FuncError
 func IsFuncError(err error) bool { _, ok := err.(FuncError); return ok }
 
@@ -2,7 +2,9 @@ package models
 
 type Error struct {
 	Message string `json:"message,omitempty"`
-	Fields  string `json:"fields,omitempty"`
+	// Code tells the error apart from the others of its HTTP status, eg. FunctionOutOfMemory
+	Code   string `json:"code,omitempty"`
+	Fields string `json:"fields,omitempty"`
 }
 
 // Validate validates this error body
@@ -0,0 +1,30 @@
+package models
+
+import "encoding/json"
+
+// FairShareWeightAnnotation is the annotation of an app or fn that sets the weight of its
+// share of the memory and CPU of runners that share them fairly across fns, an integer from
+// 1 to 100. Under contention a fn of weight 2 is granted twice the resources of a fn of
+// weight 1 that waits for them too. A fn annotation replaces the weight of its app. Fns
+// without the annotation have weight 1.
+const FairShareWeightAnnotation = "fnproject.io/fn/fair-share-weight"
+
+const (
+	// defaultFairShareWeight is the weight of fns without FairShareWeightAnnotation
+	defaultFairShareWeight = 1
+	// maxFairShareWeight is the largest weight of FairShareWeightAnnotation
+	maxFairShareWeight = 100
+)
+
+// FairShareWeight returns the weight of the fair share in the annotations, 1 if there is none
+func (m Annotations) FairShareWeight() (uint64, error) {
+	v, ok := m.Get(FairShareWeightAnnotation)
+	if !ok {
+		return defaultFairShareWeight, nil
+	}
+	var w uint64
+	if err := json.Unmarshal(v, &w); err != nil || w < 1 || w > maxFairShareWeight {
+		return 0, ErrInvalidFairShareWeight
+	}
+	return w, nil
+}
@@ -17,6 +17,14 @@ var (
 	MaxMemory      uint64 = 8 * 1024 // 8GB
 	MaxTimeout     int32  = 300      // 5m
 	MaxIdleTimeout int32  = 3600     // 1h
+	MaxInitTimeout int32  = 600      // 10m
+	MaxGPUs        uint64 = 16
+	MaxConcurrency uint64 = 100
+	MaxTmpFsSize   uint64 = 1024      // 1GB
+	MaxFsSize      uint64 = 10 * 1024 // 10GB
+
+	// MinFnCPUs is the least CPU quota of a fn, 1ms of each 100ms CFS period
+	MinFnCPUs MilliCPUs = 10
 
 	DefaultTimeout     int32  = 30  // seconds
 	DefaultIdleTimeout int32  = 30  // seconds
@@ -66,6 +74,10 @@ var (
 		code:  http.StatusBadRequest,
 		error: fmt.Errorf("idle_timeout value is out of range, must be between 0 and %d", MaxIdleTimeout),
 	}
+	ErrFnsInvalidInitTimeout = err{
+		code:  http.StatusBadRequest,
+		error: fmt.Errorf("init_timeout value is out of range, must be between 0 and %d", MaxInitTimeout),
+	}
 	ErrFnsNotFound = err{
 		code:  http.StatusNotFound,
 		error: errors.New("Fn not found"),
@@ -106,12 +118,37 @@ type Fn struct {
 type ResourceConfig struct {
 	// Memory is the amount of memory allotted, in MB.
 	Memory uint64 `json:"memory,omitempty" db:"memory"`
-	// Timeout is the max execution time for a function, in seconds.
+	// CPUs is the CPU quota allotted to each container of the fn, eg. "500m" or "0.5", and
+	// reserved from the CPUs of the runner as Memory is. Updating it to zero removes it.
+	CPUs *MilliCPUs `json:"cpus,omitempty" db:"cpus"`
+	// Timeout is the max execution time for a function, in seconds. It does not include the
+	// cold start of a container for the call, see InitTimeout.
 	// TODO this should probably be milliseconds?
 	Timeout int32 `json:"timeout,omitempty" db:"timeout"`
 	// IdleTimeout is the
 	// TODO this should probably be milliseconds
 	IdleTimeout int32 `json:"idle_timeout,omitempty" db:"idle_timeout"`
+	// InitTimeout is the max time the cold start of a container of the fn takes, pulling its
+	// image, creating it and its FDK getting ready, in seconds. The timeouts of the agent
+	// apply if it is not set, updating it to zero resets it.
+	InitTimeout *int32 `json:"init_timeout,omitempty" db:"init_timeout"`
+	// GPUs is the number of GPUs allotted to each container of the fn, calls of fns with
+	// GPUs only run on runners with as many GPUs, see RunnerConstraintsAnnotation to place
+	// them there. Updating it to zero removes them.
+	GPUs *uint64 `json:"gpus,omitempty" db:"gpus"`
+	// Concurrency is the number of calls each hot container of the fn takes at once, for
+	// FDKs that serve concurrent requests. Defaults to one, updating it to zero resets it.
+	Concurrency *uint64 `json:"concurrency,omitempty" db:"concurrency"`
+	// TmpFsSize is the size of the tmpfs mounted at /tmp in each container of the fn, in
+	// MB. It is allotted on top of Memory. Updating it to zero removes the limit.
+	TmpFsSize *uint64 `json:"tmpfs_size,omitempty" db:"tmpfs_size"`
+	// FsSize is the quota of the writable scratch layer of each container of the fn, in
+	// MB, at most the max file system size of the agent. Updating it to zero removes it.
+	FsSize *uint64 `json:"fs_size,omitempty" db:"fs_size"`
+	// ReadOnlyRootFs mounts the root file system of the containers of the fn read only,
+	// so that they can only write to /tmp, even if the agent allows writable ones.
+	// Updating it to false removes it.
+	ReadOnlyRootFs *bool `json:"read_only_rootfs,omitempty" db:"read_only_rootfs"`
 }
 
 // SetCreated sets zeroed field to defaults.
@@ -166,11 +203,100 @@ func (f *Fn) Validate() error {
 		return ErrFnsInvalidIdleTimeout
 	}
 
+	if t := f.InitTimeoutSecs(); t < 0 || t > MaxInitTimeout {
+		return ErrFnsInvalidInitTimeout
+	}
+
 	if f.Memory < 1 || f.Memory > MaxMemory {
 		return ErrInvalidMemory
 	}
 
-	return f.Annotations.Validate()
+	if cpus := f.CPUQuota(); cpus != 0 && (cpus < MinFnCPUs || cpus > MaxMilliCPUs) {
+		return ErrInvalidFnCPUs
+	}
+
+	if f.GPUCount() > MaxGPUs {
+		return ErrInvalidGPUs
+	}
+
+	if f.ContainerConcurrency() > MaxConcurrency {
+		return ErrInvalidConcurrency
+	}
+
+	if f.TmpFsSizeMB() > MaxTmpFsSize {
+		return ErrInvalidTmpFsSize
+	}
+
+	if f.FsSizeMB() > MaxFsSize {
+		return ErrInvalidFsSize
+	}
+
+	if err := f.Annotations.Validate(); err != nil {
+		return err
+	}
+
+	// templates turned on by the app are validated when they are expanded
+	if on, _ := f.Annotations.ConfigTemplates(); on {
+		return f.Config.validateSecretTemplates()
+	}
+	return nil
+}
+
+// InitTimeoutSecs returns the init timeout of the containers of f in seconds, zero if it is
+// not set
+func (f *Fn) InitTimeoutSecs() int32 {
+	if f.InitTimeout == nil {
+		return 0
+	}
+	return *f.InitTimeout
+}
+
+// CPUQuota returns the milli CPUs of the containers of f, zero if they are not limited
+func (f *Fn) CPUQuota() MilliCPUs {
+	if f.CPUs == nil {
+		return 0
+	}
+	return *f.CPUs
+}
+
+// GPUCount returns the number of GPUs of the containers of f, zero if it has none
+func (f *Fn) GPUCount() uint64 {
+	if f.GPUs == nil {
+		return 0
+	}
+	return *f.GPUs
+}
+
+// ContainerConcurrency returns the number of calls each hot container of f takes at once,
+// one if it is not set
+func (f *Fn) ContainerConcurrency() uint64 {
+	if f.Concurrency == nil || *f.Concurrency == 0 {
+		return 1
+	}
+	return *f.Concurrency
+}
+
+// TmpFsSizeMB returns the size of the tmpfs of the containers of f in MB, zero if it is
+// not limited
+func (f *Fn) TmpFsSizeMB() uint64 {
+	if f.TmpFsSize == nil {
+		return 0
+	}
+	return *f.TmpFsSize
+}
+
+// FsSizeMB returns the quota of the writable layer of the containers of f in MB, zero if
+// it is not set
+func (f *Fn) FsSizeMB() uint64 {
+	if f.FsSize == nil {
+		return 0
+	}
+	return *f.FsSize
+}
+
+// IsReadOnlyRootFs returns whether the containers of f have a read only root file system
+func (f *Fn) IsReadOnlyRootFs() bool {
+	return f.ReadOnlyRootFs != nil && *f.ReadOnlyRootFs
 }
 
 func (f *Fn) ValidateName() error {
@@ -220,8 +346,15 @@ func (f1 *Fn) Equals(f2 *Fn) bool {
 	eq = eq && f1.AppID == f2.AppID
 	eq = eq && f1.Image == f2.Image
 	eq = eq && f1.Memory == f2.Memory
+	eq = eq && f1.CPUQuota() == f2.CPUQuota()
 	eq = eq && f1.Timeout == f2.Timeout
 	eq = eq && f1.IdleTimeout == f2.IdleTimeout
+	eq = eq && f1.InitTimeoutSecs() == f2.InitTimeoutSecs()
+	eq = eq && f1.GPUCount() == f2.GPUCount()
+	eq = eq && f1.ContainerConcurrency() == f2.ContainerConcurrency()
+	eq = eq && f1.TmpFsSizeMB() == f2.TmpFsSizeMB()
+	eq = eq && f1.FsSizeMB() == f2.FsSizeMB()
+	eq = eq && f1.IsReadOnlyRootFs() == f2.IsReadOnlyRootFs()
 	eq = eq && f1.Config.Equals(f2.Config)
 	eq = eq && f1.Annotations.Equals(f2.Annotations)
 	// NOTE: datastore tests are not very fun to write with timestamp checks,
@@ -241,8 +374,15 @@ func (f1 *Fn) EqualsWithAnnotationSubset(f2 *Fn) bool {
 	eq = eq && f1.AppID == f2.AppID
 	eq = eq && f1.Image == f2.Image
 	eq = eq && f1.Memory == f2.Memory
+	eq = eq && f1.CPUQuota() == f2.CPUQuota()
 	eq = eq && f1.Timeout == f2.Timeout
 	eq = eq && f1.IdleTimeout == f2.IdleTimeout
+	eq = eq && f1.InitTimeoutSecs() == f2.InitTimeoutSecs()
+	eq = eq && f1.GPUCount() == f2.GPUCount()
+	eq = eq && f1.ContainerConcurrency() == f2.ContainerConcurrency()
+	eq = eq && f1.TmpFsSizeMB() == f2.TmpFsSizeMB()
+	eq = eq && f1.FsSizeMB() == f2.FsSizeMB()
+	eq = eq && f1.IsReadOnlyRootFs() == f2.IsReadOnlyRootFs()
 	eq = eq && f1.Config.Equals(f2.Config)
 	eq = eq && f1.Annotations.Subset(f2.Annotations)
 	// NOTE: datastore tests are not very fun to write with timestamp checks,
@@ -264,6 +404,14 @@ func (f *Fn) Update(patch *Fn) {
 	if patch.Memory != 0 {
 		f.Memory = patch.Memory
 	}
+	if patch.CPUs != nil {
+		if *patch.CPUs == 0 {
+			f.CPUs = nil // hides it from json
+		} else {
+			cpus := *patch.CPUs
+			f.CPUs = &cpus
+		}
+	}
 
 	if patch.Timeout != 0 {
 		f.Timeout = patch.Timeout
@@ -271,6 +419,54 @@ func (f *Fn) Update(patch *Fn) {
 	if patch.IdleTimeout != 0 {
 		f.IdleTimeout = patch.IdleTimeout
 	}
+	if patch.InitTimeout != nil {
+		if *patch.InitTimeout == 0 {
+			f.InitTimeout = nil // hides it from json
+		} else {
+			timeout := *patch.InitTimeout
+			f.InitTimeout = &timeout
+		}
+	}
+	if patch.GPUs != nil {
+		if *patch.GPUs == 0 {
+			f.GPUs = nil // hides it from json
+		} else {
+			gpus := *patch.GPUs
+			f.GPUs = &gpus
+		}
+	}
+	if patch.Concurrency != nil {
+		if *patch.Concurrency == 0 {
+			f.Concurrency = nil // hides it from json
+		} else {
+			concurrency := *patch.Concurrency
+			f.Concurrency = &concurrency
+		}
+	}
+	if patch.TmpFsSize != nil {
+		if *patch.TmpFsSize == 0 {
+			f.TmpFsSize = nil // hides it from json
+		} else {
+			size := *patch.TmpFsSize
+			f.TmpFsSize = &size
+		}
+	}
+	if patch.FsSize != nil {
+		if *patch.FsSize == 0 {
+			f.FsSize = nil // hides it from json
+		} else {
+			size := *patch.FsSize
+			f.FsSize = &size
+		}
+	}
+	if patch.ReadOnlyRootFs != nil {
+		if !*patch.ReadOnlyRootFs {
+			f.ReadOnlyRootFs = nil // hides it from json
+		} else {
+			readOnly := true
+			f.ReadOnlyRootFs = &readOnly
+		}
+	}
 	if patch.Config != nil {
 		if f.Config == nil {
 			f.Config = make(Config)
@@ -18,8 +18,15 @@ func resourceConfigGenerator(t *testing.T) gopter.Gen {
 	fieldGens := make(map[string]gopter.Gen)
 
 	fieldGens["Memory"] = gen.UInt64()
+	fieldGens["CPUs"] = gen.UInt64Range(uint64(MinFnCPUs), MaxMilliCPUs).Map(func(v uint64) *MilliCPUs { c := MilliCPUs(v); return &c })
 	fieldGens["Timeout"] = gen.Int32()
 	fieldGens["IdleTimeout"] = gen.Int32()
+	fieldGens["InitTimeout"] = gen.Int32Range(1, MaxInitTimeout).Map(func(v int32) *int32 { return &v })
+	fieldGens["GPUs"] = gen.UInt64Range(1, MaxGPUs).Map(func(v uint64) *uint64 { return &v })
+	fieldGens["Concurrency"] = gen.UInt64Range(1, MaxConcurrency).Map(func(v uint64) *uint64 { return &v })
+	fieldGens["TmpFsSize"] = gen.UInt64Range(1, MaxTmpFsSize).Map(func(v uint64) *uint64 { return &v })
+	fieldGens["FsSize"] = gen.UInt64Range(1, MaxFsSize).Map(func(v uint64) *uint64 { return &v })
+	fieldGens["ReadOnlyRootFs"] = gen.Const(true).Map(func(v bool) *bool { return &v })
 
 	resourceConfig := ResourceConfig{}
 	resourceConfigFieldCount := reflect.TypeOf(resourceConfig).NumField()
@@ -169,10 +176,60 @@ func TestValidateFn(t *testing.T) {
 	testFn.IdleTimeout = 0
 	testCases = append(testCases, test{testFn, ErrFnsInvalidIdleTimeout})
 
+	testFn = generateValidFn()
+	initTimeout := MaxInitTimeout + 1
+	testFn.InitTimeout = &initTimeout
+	testCases = append(testCases, test{testFn, ErrFnsInvalidInitTimeout})
+
 	testFn = generateValidFn()
 	testFn.Memory = 0
 	testCases = append(testCases, test{testFn, ErrInvalidMemory})
 
+	testFn = generateValidFn()
+	cpus := MinFnCPUs - 1
+	testFn.CPUs = &cpus
+	testCases = append(testCases, test{testFn, ErrInvalidFnCPUs})
+
+	testFn = generateValidFn()
+	cpus = MaxMilliCPUs + 1
+	testFn.CPUs = &cpus
+	testCases = append(testCases, test{testFn, ErrInvalidFnCPUs})
+
+	testFn = generateValidFn()
+	gpus := MaxGPUs + 1
+	testFn.GPUs = &gpus
+	testCases = append(testCases, test{testFn, ErrInvalidGPUs})
+
+	testFn = generateValidFn()
+	concurrency := MaxConcurrency + 1
+	testFn.Concurrency = &concurrency
+	testCases = append(testCases, test{testFn, ErrInvalidConcurrency})
+
+	testFn = generateValidFn()
+	tmpFsSize := MaxTmpFsSize + 1
+	testFn.TmpFsSize = &tmpFsSize
+	testCases = append(testCases, test{testFn, ErrInvalidTmpFsSize})
+
+	testFn = generateValidFn()
+	fsSize := MaxFsSize + 1
+	testFn.FsSize = &fsSize
+	testCases = append(testCases, test{testFn, ErrInvalidFsSize})
+
+	testFn = generateValidFn()
+	testFn.Annotations, _ = EmptyAnnotations().With(ConfigTemplatesAnnotation, true)
+	testFn.Config = Config{"DB_URL": "postgres://fn:${secret:db#password}@db/fn", "PRICE": "$${secret:../other#key}"}
+	testCases = append(testCases, test{testFn, nil})
+
+	testFn = generateValidFn()
+	testFn.Annotations, _ = EmptyAnnotations().With(ConfigTemplatesAnnotation, true)
+	testFn.Config = Config{"DB_URL": "postgres://fn:${secret:../other/db#password}@db/fn"}
+	testCases = append(testCases, test{testFn, ErrInvalidConfigSecretTemplate})
+
+	// the config is not expanded without templates
+	testFn = generateValidFn()
+	testFn.Config = Config{"DB_URL": "postgres://fn:${secret:../other/db#password}@db/fn"}
+	testCases = append(testCases, test{testFn, nil})
+
 	for _, testCase := range testCases {
 		got := testCase.Fn.Validate()
 
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HedgeAnnotation is the annotation of an app or fn that lets an LB with a hedge delay race
+// its calls on two runners, true or false. Both runners may start a hedged call, so only fns
+// whose calls are safe to run twice should opt in. Even then only the idempotent calls are
+// hedged, see Call.Idempotent. A fn annotation replaces the one of its app.
+const HedgeAnnotation = "fnproject.io/placement/hedge"
+
+// IdempotencyKeyHeader is the request header of calls that are safe to run twice although
+// their method is not idempotent, eg. POST requests to the invoke endpoint
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Hedge returns whether the calls of the annotations may be hedged, false if there is no
+// annotation
+func (m Annotations) Hedge() (bool, error) {
+	v, ok := m.Get(HedgeAnnotation)
+	if !ok {
+		return false, nil
+	}
+	var on bool
+	if err := json.Unmarshal(v, &on); err != nil {
+		return false, ErrInvalidHedge
+	}
+	return on, nil
+}
+
+// Idempotent reports whether running the call twice has the effect of running it once: a
+// sync call with an idempotent HTTP method, or with an IdempotencyKeyHeader
+func (c *Call) Idempotent() bool {
+	if c.Type != TypeSync {
+		return false
+	}
+	switch c.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return c.Headers.Get(IdempotencyKeyHeader) != ""
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+)
+
+// ImageSignatureAnnotation is the annotation of an app or fn that requires the images of
+// its fns to be signed with cosign by one of its keys, as an object of PEM encoded ECDSA or
+// RSA public keys, eg. {"keys": ["-----BEGIN PUBLIC KEY-----\n..."]}. Runners check it on
+// top of the keys of their deployment, see agent.EnvImageVerifyKeys. A fn annotation
+// replaces the keys of its app.
+const ImageSignatureAnnotation = "fnproject.io/image/signature"
+
+// maxImageSignatureKeys is the largest number of keys of ImageSignatureAnnotation
+const maxImageSignatureKeys = 8
+
+// ImageSignature are the keys the images of a fn must be signed by, see
+// ImageSignatureAnnotation
+type ImageSignature struct {
+	Keys []string `json:"keys"`
+}
+
+// ImageSignature returns the image signature keys in the annotations, nil if there are none
+func (m Annotations) ImageSignature() (*ImageSignature, error) {
+	v, ok := m.Get(ImageSignatureAnnotation)
+	if !ok {
+		return nil, nil
+	}
+	var sig ImageSignature
+	if err := json.Unmarshal(v, &sig); err != nil {
+		return nil, ErrInvalidImageSignature
+	}
+	if len(sig.Keys) == 0 || len(sig.Keys) > maxImageSignatureKeys {
+		return nil, ErrInvalidImageSignature
+	}
+	for _, key := range sig.Keys {
+		if _, err := ParsePublicKey([]byte(key)); err != nil {
+			return nil, ErrInvalidImageSignature
+		}
+	}
+	return &sig, nil
+}
+
+// ParsePublicKey parses a PEM encoded ECDSA or RSA public key, of the keys images are signed
+// with, see ImageSignatureAnnotation
+func ParsePublicKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidImageSignature
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey:
+		return key, nil
+	}
+	return nil, ErrInvalidImageSignature
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// PlatformAnnotation is the annotation of an app or fn that restricts its calls to runners of
+// the platform its images are built for, as os/arch[/variant] like the platforms of image
+// manifests, eg. "linux/arm64". Calls are placed on runners whose RunnerOSLabel and
+// RunnerArchLabel labels match, which pull the image of their platform from multi-arch
+// images. A fn annotation replaces the platform of its app.
+const PlatformAnnotation = "fnproject.io/image/platform"
+
+// Labels of runners with their platform, which runners advertise unless configured with
+// other values, see PlatformAnnotation
+const (
+	RunnerOSLabel   = "os"
+	RunnerArchLabel = "arch"
+)
+
+// platformPartPattern matches the os, arch and variant of platforms
+var platformPartPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// archAliases are the names of architectures other than the GOARCH ones image manifests use,
+// eg. in the info of the docker daemon
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x86-64":  "amd64",
+	"aarch64": "arm64",
+	"armhf":   "arm",
+	"armel":   "arm",
+	"i386":    "386",
+	"i686":    "386",
+}
+
+// Platform is the os, architecture and optional variant of images and runners, see
+// PlatformAnnotation
+type Platform struct {
+	OS      string
+	Arch    string
+	Variant string
+}
+
+// String returns the platform as os/arch[/variant]
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Arch
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Matches reports whether the os and architecture of p are the ones of other, variants are
+// not compared
+func (p Platform) Matches(other Platform) bool {
+	return p.OS == other.OS && p.Arch == other.Arch
+}
+
+// NormalizeArch returns the image manifest name of architecture arch, eg. amd64 for x86_64
+func NormalizeArch(arch string) string {
+	arch = strings.ToLower(arch)
+	if a, ok := archAliases[arch]; ok {
+		return a
+	}
+	return arch
+}
+
+// ParsePlatform parses a platform of the form os/arch[/variant], the architecture may be
+// any of its names, see NormalizeArch
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(strings.ToLower(s), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Platform{}, ErrInvalidPlatform
+	}
+	for _, part := range parts {
+		if !platformPartPattern.MatchString(part) {
+			return Platform{}, ErrInvalidPlatform
+		}
+	}
+	p := Platform{OS: parts[0], Arch: NormalizeArch(parts[1])}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// Platform returns the platform in the annotations, nil if there is none
+func (m Annotations) Platform() (*Platform, error) {
+	v, ok := m.Get(PlatformAnnotation)
+	if !ok {
+		return nil, nil
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err != nil {
+		return nil, ErrInvalidPlatform
+	}
+	p, err := ParsePlatform(s)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ResponseCacheTTLAnnotation is the annotation of an app or fn that caches the responses of its
+// calls on LBs for a number of seconds, from 1 to a day. Only pure fns should cache their
+// responses: a call with the same method, URL and body as a cached call is served the cached
+// response without running the fn. Only successful responses of sync calls are cached, and
+// not those with a Cache-Control: no-store header. A fn annotation replaces the one of its
+// app.
+const ResponseCacheTTLAnnotation = "fnproject.io/fn/response-cache-ttl"
+
+// maxResponseCacheTTL is the longest ResponseCacheTTLAnnotation, in seconds
+const maxResponseCacheTTL = 24 * 60 * 60
+
+// ResponseCacheTTL returns how long responses are cached in the annotations, zero if there is
+// no annotation
+func (m Annotations) ResponseCacheTTL() (time.Duration, error) {
+	v, ok := m.Get(ResponseCacheTTLAnnotation)
+	if !ok {
+		return 0, nil
+	}
+	var secs uint64
+	if err := json.Unmarshal(v, &secs); err != nil || secs < 1 || secs > maxResponseCacheTTL {
+		return 0, ErrInvalidResponseCacheTTL
+	}
+	return time.Duration(secs) * time.Second, nil
+}
@@ -0,0 +1,190 @@
+package models
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// RunnerConstraintsAnnotation is the annotation of an app or fn that restricts the runners
+// its calls are placed on. The value is an object of runner labels to the values the labels
+// of a runner must have, eg. {"gpu": "true", "zone": "phx-1"}. A fn annotation replaces the
+// constraints of its app.
+const RunnerConstraintsAnnotation = "fnproject.io/runner/constraints"
+
+// RunnerPoolAnnotation is the annotation of an app or fn that selects the named runner pool
+// of the LB its calls are placed on, eg. "gpu". A fn annotation replaces the pool of its app.
+const RunnerPoolAnnotation = "fnproject.io/runner/pool"
+
+// SessionHeaderAnnotation is the annotation of an app or fn that opts its calls into session
+// affinity on an LB that enables it. The value is the name of the request header carrying
+// the session key, eg. "Fn-Session-Id". Calls with the same session key are placed on the
+// runner that took the last call of the session, which likely has its warm container.
+const SessionHeaderAnnotation = "fnproject.io/session/header"
+
+// RuntimeAnnotation is the annotation of an app or fn that runs its containers with another
+// OCI runtime than the default one of the runners, eg. "runsc" for the gVisor sandbox. A fn
+// annotation replaces the runtime of its app. Runners only run containers with the
+// runtimes their operator allows.
+const RuntimeAnnotation = "fnproject.io/container/runtime"
+
+// RuntimeClassAnnotation is the annotation of an app or fn that runs its containers with the
+// OCI runtime runners map its runtime class to, eg. "kata" for VM isolated containers, so that
+// apps need not know the runtimes of the runners. It replaces RuntimeAnnotation, and a fn
+// annotation replaces the runtime class of its app.
+const RuntimeClassAnnotation = "fnproject.io/container/runtime-class"
+
+// MinWarmAnnotation is the annotation of an app or fn that sets the number of hot containers
+// runners keep warm for its calls once they ran one, eg. 2, rather than letting them idle
+// out. A fn annotation replaces the number of its app. Runners cap the number to the maximum
+// their operator allows.
+const MinWarmAnnotation = "fnproject.io/container/min-warm"
+
+// maxMinWarm is the largest number of warm containers of MinWarmAnnotation
+const maxMinWarm = 100
+
+// ProtocolAnnotation is the annotation of an app or fn that sets the protocol its containers
+// speak on their FDK socket, one of ProtocolHTTPStream or ProtocolGRPC. A fn annotation
+// replaces the protocol of its app. Fns without the annotation speak ProtocolHTTPStream.
+const ProtocolAnnotation = "fnproject.io/fn/protocol"
+
+// Protocols of fn containers, see ProtocolAnnotation
+const (
+	// ProtocolHTTPStream containers serve calls as HTTP/1.1 requests of the FDK contract
+	ProtocolHTTPStream = "http-stream"
+	// ProtocolGRPC containers serve a gRPC server over HTTP/2 without TLS, whose methods
+	// are called directly by gRPC clients of the fn
+	ProtocolGRPC = "grpc"
+)
+
+// runtimePattern matches the names of OCI runtimes as configured in dockerd
+var runtimePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// PlacementClassAnnotation is the annotation of an app or fn that sets the placement class of
+// its calls on an LB with reserved capacity, one of PlacementClassReserved,
+// PlacementClassStandard or PlacementClassPreemptible. A fn annotation replaces the class of
+// its app. Calls without the annotation are PlacementClassStandard.
+const PlacementClassAnnotation = "fnproject.io/placement/class"
+
+// Placement classes, see PlacementClassAnnotation
+const (
+	// PlacementClassReserved calls may use the capacity reserved on the LB and preempt
+	// preemptible calls when the LB is full
+	PlacementClassReserved = "reserved"
+	// PlacementClassStandard calls may only use the capacity that is not reserved
+	PlacementClassStandard = "standard"
+	// PlacementClassPreemptible calls are like standard calls, but may be canceled to make
+	// room for reserved calls
+	PlacementClassPreemptible = "preemptible"
+)
+
+// RunnerConstraints returns the placement constraints in the annotations, nil if there are none.
+// The platform of PlatformAnnotation constrains the platform labels of runners, over the
+// constraints of RunnerConstraintsAnnotation.
+func (m Annotations) RunnerConstraints() (map[string]string, error) {
+	var constraints map[string]string
+	if v, ok := m.Get(RunnerConstraintsAnnotation); ok {
+		if err := json.Unmarshal(v, &constraints); err != nil {
+			return nil, ErrInvalidRunnerConstraints
+		}
+	}
+	// an invalid platform is not a constraint, Validate rejects it
+	if p, err := m.Platform(); err == nil && p != nil {
+		if constraints == nil {
+			constraints = make(map[string]string, 2)
+		}
+		constraints[RunnerOSLabel] = p.OS
+		constraints[RunnerArchLabel] = p.Arch
+	}
+	return constraints, nil
+}
+
+// RunnerPool returns the runner pool selected in the annotations, empty if there is none
+func (m Annotations) RunnerPool() (string, error) {
+	if _, ok := m.Get(RunnerPoolAnnotation); !ok {
+		return "", nil
+	}
+	pool, err := m.GetString(RunnerPoolAnnotation)
+	if err != nil || pool == "" {
+		return "", ErrInvalidRunnerPool
+	}
+	return pool, nil
+}
+
+// PlacementClass returns the placement class in the annotations, PlacementClassStandard if
+// there is none
+func (m Annotations) PlacementClass() (string, error) {
+	if _, ok := m.Get(PlacementClassAnnotation); !ok {
+		return PlacementClassStandard, nil
+	}
+	class, err := m.GetString(PlacementClassAnnotation)
+	if err != nil {
+		return "", ErrInvalidPlacementClass
+	}
+	switch class {
+	case PlacementClassReserved, PlacementClassStandard, PlacementClassPreemptible:
+		return class, nil
+	}
+	return "", ErrInvalidPlacementClass
+}
+
+// Runtime returns the OCI runtime in the annotations, empty if there is none
+func (m Annotations) Runtime() (string, error) {
+	if _, ok := m.Get(RuntimeAnnotation); !ok {
+		return "", nil
+	}
+	runtime, err := m.GetString(RuntimeAnnotation)
+	if err != nil || !runtimePattern.MatchString(runtime) {
+		return "", ErrInvalidRuntime
+	}
+	return runtime, nil
+}
+
+// RuntimeClass returns the runtime class in the annotations, empty if there is none
+func (m Annotations) RuntimeClass() (string, error) {
+	if _, ok := m.Get(RuntimeClassAnnotation); !ok {
+		return "", nil
+	}
+	class, err := m.GetString(RuntimeClassAnnotation)
+	if err != nil || !runtimePattern.MatchString(class) {
+		return "", ErrInvalidRuntimeClass
+	}
+	return class, nil
+}
+
+// MinWarm returns the number of warm containers in the annotations, zero if there is none
+func (m Annotations) MinWarm() (uint64, error) {
+	v, ok := m.Get(MinWarmAnnotation)
+	if !ok {
+		return 0, nil
+	}
+	var n uint64
+	if err := json.Unmarshal(v, &n); err != nil || n > maxMinWarm {
+		return 0, ErrInvalidMinWarm
+	}
+	return n, nil
+}
+
+// Protocol returns the protocol in the annotations, ProtocolHTTPStream if there is none
+func (m Annotations) Protocol() (string, error) {
+	if _, ok := m.Get(ProtocolAnnotation); !ok {
+		return ProtocolHTTPStream, nil
+	}
+	protocol, err := m.GetString(ProtocolAnnotation)
+	if err != nil || (protocol != ProtocolHTTPStream && protocol != ProtocolGRPC) {
+		return "", ErrInvalidProtocol
+	}
+	return protocol, nil
+}
+
+// SessionHeader returns the session key header in the annotations, empty if there is none
+func (m Annotations) SessionHeader() (string, error) {
+	if _, ok := m.Get(SessionHeaderAnnotation); !ok {
+		return "", nil
+	}
+	header, err := m.GetString(SessionHeaderAnnotation)
+	if err != nil || header == "" || strings.ContainsAny(header, " \t\r\n:") {
+		return "", ErrInvalidSessionHeader
+	}
+	return header, nil
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// SecretsAnnotation is the annotation of an app or fn that lists the secrets injected into
+// its containers, as a list of Secret, eg. [{"ref": "db#password", "env": "DB_PASSWORD"}].
+// Only references to the secrets are stored, runners resolve them from their secret store
+// when they create containers, under the secrets prefix of the app so that an app cannot
+// read the secrets of others. A fn annotation replaces the secrets of its app.
+const SecretsAnnotation = "fnproject.io/fn/secrets"
+
+// maxSecrets is the largest number of secrets of SecretsAnnotation
+const maxSecrets = 64
+
+var (
+	// secretEnvPattern matches the env vars secrets may be injected as
+	secretEnvPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// secretFilePattern matches the names of the files secrets may be written to
+	secretFilePattern = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$`)
+)
+
+// Secret is a secret injected into the containers of a fn, see SecretsAnnotation. It is
+// injected either as an env var or as a file.
+type Secret struct {
+	// Ref is the reference of the secret in the secret store of the runners relative to the
+	// secrets of the app, eg. the path and key of a Vault secret, db#password, see
+	// ValidSecretRef
+	Ref string `json:"ref"`
+	// Env is the env var the secret is injected as, env vars starting with FN_ are reserved
+	Env string `json:"env,omitempty"`
+	// File is the name of the file the secret is written to, in a tmpfs of the container
+	File string `json:"file,omitempty"`
+}
+
+// Secrets returns the secrets in the annotations, nil if there are none
+func (m Annotations) Secrets() ([]Secret, error) {
+	v, ok := m.Get(SecretsAnnotation)
+	if !ok {
+		return nil, nil
+	}
+	var secrets []Secret
+	if err := json.Unmarshal(v, &secrets); err != nil || len(secrets) > maxSecrets {
+		return nil, ErrInvalidSecrets
+	}
+
+	envs := make(map[string]bool, len(secrets))
+	files := make(map[string]bool, len(secrets))
+	for _, s := range secrets {
+		if !ValidSecretRef(s.Ref) || (s.Env == "") == (s.File == "") {
+			return nil, ErrInvalidSecrets
+		}
+		if s.Env != "" {
+			if !secretEnvPattern.MatchString(s.Env) || strings.HasPrefix(strings.ToUpper(s.Env), "FN_") || envs[s.Env] {
+				return nil, ErrInvalidSecrets
+			}
+			envs[s.Env] = true
+		}
+		if s.File != "" {
+			if !secretFilePattern.MatchString(s.File) || files[s.File] {
+				return nil, ErrInvalidSecrets
+			}
+			files[s.File] = true
+		}
+	}
+	return secrets, nil
+}
+
+// ValidSecretRef returns whether ref is a valid reference of a secret relative to the
+// secrets of an app, a path of elements separated by / that neither starts with / nor has
+// . or .. elements, optionally followed by a #key
+func ValidSecretRef(ref string) bool {
+	if i := strings.LastIndexByte(ref, '#'); i >= 0 {
+		ref = ref[:i]
+	}
+	if ref == "" {
+		return false
+	}
+	for _, elem := range strings.Split(ref, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return false
+		}
+	}
+	return true
+}
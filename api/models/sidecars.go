@@ -0,0 +1,55 @@
+package models
+
+import (
+	"encoding/json"
+	"regexp"
+)
+
+// SidecarsAnnotation is the annotation of an app or fn that declares the auxiliary containers
+// run with each container of the fn, as a list of Sidecar, eg. [{"name": "proxy", "image":
+// "envoyproxy/envoy:v1.14.1"}]. Sidecars run in the network and IPC namespaces of the fn
+// container, with its limits, for as long as it runs. Init sidecars run to completion, in
+// order, before the fn container starts, on its network. A fn annotation replaces the
+// sidecars of its app.
+const SidecarsAnnotation = "fnproject.io/container/sidecars"
+
+// maxSidecars is the largest number of sidecars of SidecarsAnnotation
+const maxSidecars = 8
+
+// sidecarNamePattern matches the names of sidecars, which are part of their container names
+var sidecarNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,62}$`)
+
+// Sidecar is an auxiliary container of a fn, see SidecarsAnnotation
+type Sidecar struct {
+	// Name is the name of the sidecar, unique among the sidecars of the fn
+	Name string `json:"name"`
+	// Image is the image the sidecar runs
+	Image string `json:"image"`
+	// Cmd is the command of the sidecar, the one of its image if empty
+	Cmd []string `json:"cmd,omitempty"`
+	// Env is the env of the sidecar
+	Env map[string]string `json:"env,omitempty"`
+	// Init sidecars run to completion before the fn container starts, and must succeed
+	Init bool `json:"init,omitempty"`
+}
+
+// Sidecars returns the sidecars in the annotations, nil if there are none
+func (m Annotations) Sidecars() ([]Sidecar, error) {
+	v, ok := m.Get(SidecarsAnnotation)
+	if !ok {
+		return nil, nil
+	}
+	var sidecars []Sidecar
+	if err := json.Unmarshal(v, &sidecars); err != nil || len(sidecars) > maxSidecars {
+		return nil, ErrInvalidSidecars
+	}
+
+	names := make(map[string]bool, len(sidecars))
+	for _, s := range sidecars {
+		if !sidecarNamePattern.MatchString(s.Name) || names[s.Name] || s.Image == "" {
+			return nil, ErrInvalidSidecars
+		}
+		names[s.Name] = true
+	}
+	return sidecars, nil
+}
@@ -6,8 +6,6 @@ package runnerpool
 import (
 	"context"
 
-	"github.com/fnproject/fn/api/models"
-
 	"github.com/dchest/siphash"
 	"github.com/sirupsen/logrus"
 )
@@ -37,38 +35,15 @@ func (p *chPlacer) PlaceCall(ctx context.Context, rp RunnerPool, call RunnerCall
 	key := call.Model().FnID
 	sum64 := siphash.Hash(0, 0x4c617279426f6174, []byte(key))
 
-	var runnerPoolErr error
-	for {
-		var runners []Runner
-		runners, runnerPoolErr = rp.Runners(ctx, call)
-
+	return state.PlaceCall(rp, func(runners []Runner) []Runner {
+		// runners in order starting from the hash position of the fn
 		i := int(jumpConsistentHash(sum64, int32(len(runners))))
-		for j := 0; j < len(runners) && !state.IsDone(); j++ {
-
-			r := runners[i]
-
-			placed, err := state.TryRunner(r, call)
-			if placed {
-				return err
-			}
-
-			i = (i + 1) % len(runners)
+		ordered := make([]Runner, 0, len(runners))
+		for j := 0; j < len(runners); j++ {
+			ordered = append(ordered, runners[(i+j)%len(runners)])
 		}
-
-		if !state.RetryAllBackoff(len(runners), runnerPoolErr) {
-			break
-		}
-	}
-
-	if runnerPoolErr != nil {
-		// If we haven't been able to place the function and we got an error
-		// from the runner pool, return that error (since we don't have
-		// enough runners to handle the current load and the runner pool is
-		// having trouble).
-		state.HandleFindRunnersFailure(runnerPoolErr)
-		return runnerPoolErr
-	}
-	return models.ErrCallTimeoutServerBusy
+		return state.ReadyRunners(ordered)
+	}, nil)
 }
 
 // A Fast, Minimal Memory, Consistent Hash Algorithm:
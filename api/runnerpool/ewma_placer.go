@@ -0,0 +1,186 @@
+package runnerpool
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxNackRate caps the NACK rate of a runner in its cost, so that a runner that rejected
+	// all its recent calls is tried last rather than never again
+	maxNackRate = 0.99
+	// minLatency is the latency of runners in their cost until they ran a call, so that
+	// runners that only rejected calls are not the cheapest
+	minLatency = float64(time.Millisecond)
+	// minSampleWeight is the least weight of an observation in a moving average, so that
+	// bursts of calls move it even if they are observed at once
+	minSampleWeight = 0.05
+)
+
+// runnerEWMA is what the EWMA placer observed of a runner, as moving averages decaying
+// with PlacerConfig.EWMADecay
+type runnerEWMA struct {
+	// latency of the calls placed on the runner, in nanoseconds, zero before the first call
+	latency  float64
+	measured time.Time
+	// share of recent attempts the runner rejected as too busy or failed to take
+	nackRate float64
+	nacked   time.Time
+	// calls this placer has in flight on the runner
+	inFlight int32
+	seen     time.Time
+}
+
+// cost is the expected latency of a call placed on a runner of weight, the effective
+// weight of the runner is its inverse. Runners not observed yet are cheap, so that they
+// are tried and get a latency.
+func (s *runnerEWMA) cost(weight int) float64 {
+	latency := math.Max(s.latency, minLatency)
+	nackRate := math.Min(s.nackRate, maxNackRate)
+	return latency * float64(s.inFlight+1) / (1 - nackRate) / float64(weight)
+}
+
+// decayed returns avg moved towards sample, more so the longer ago avg was updated
+func decayed(avg, sample float64, elapsed, decay time.Duration) float64 {
+	if decay <= 0 {
+		return sample
+	}
+	w := math.Min(math.Exp(-float64(elapsed)/float64(decay)), 1-minSampleWeight)
+	return avg*w + sample*(1-w)
+}
+
+type ewmaPlacer struct {
+	cfg PlacerConfig
+
+	mtx     sync.Mutex
+	runners map[string]*runnerEWMA
+}
+
+// NewEWMAPlacer returns a placer that orders runners by two random choices: of two random
+// runners the one with the lower cost is tried first. The cost of a runner is the moving
+// average of its TryExec latency, times its calls in flight, increased by the moving
+// average of its NACK rate and divided by its weight, so that calls move away from
+// runners on slower or degrading hosts as soon as they are observed.
+func NewEWMAPlacer(cfg *PlacerConfig) Placer {
+	logrus.Infof("Creating new ewma runnerpool placer with config=%+v", cfg)
+	return &ewmaPlacer{
+		cfg:     *cfg,
+		runners: make(map[string]*runnerEWMA),
+	}
+}
+
+func (p *ewmaPlacer) GetPlacerConfig() PlacerConfig {
+	return p.cfg
+}
+
+func (p *ewmaPlacer) PlaceCall(ctx context.Context, rp RunnerPool, call RunnerCall) error {
+	state := NewPlacerTracker(ctx, &p.cfg, call)
+	defer state.HandleDone()
+
+	return state.PlaceCall(rp, func(runners []Runner) []Runner {
+		return p.twoChoices(state.ReadyRunners(runners))
+	}, func(r, hedge Runner) (Runner, int, error) {
+		p.started(r)
+		start := time.Now()
+		placedOn, tried, err := state.TryRunnerHedged(r, hedge, call)
+		p.finished(r, hedge, placedOn, tried, err, time.Since(start))
+		return placedOn, tried, err
+	})
+}
+
+// twoChoices returns runners ordered by repeated two random choices, the cheaper of two
+// random runners left goes next. Runners not seen for a while are forgotten.
+func (p *ewmaPlacer) twoChoices(runners []Runner) []Runner {
+	now := time.Now()
+	costs := make([]float64, len(runners))
+
+	p.mtx.Lock()
+	for i, r := range runners {
+		s := p.runner(r.Address())
+		s.seen = now
+		costs[i] = s.cost(RunnerWeight(r))
+	}
+	for addr, s := range p.runners {
+		if now.Sub(s.seen) > 10*p.cfg.EWMADecay && s.inFlight == 0 {
+			delete(p.runners, addr)
+		}
+	}
+	p.mtx.Unlock()
+
+	left := rand.Perm(len(runners))
+	ordered := make([]Runner, 0, len(runners))
+	for len(left) > 1 {
+		// the loser stays first and is drawn against the next random runner
+		pick := 0
+		if costs[left[1]] < costs[left[0]] {
+			pick = 1
+		}
+		ordered = append(ordered, runners[left[pick]])
+		left = append(left[:pick], left[pick+1:]...)
+	}
+	for _, i := range left {
+		ordered = append(ordered, runners[i])
+	}
+	return ordered
+}
+
+// runner returns what was observed of the runner at addr, p.mtx must be held
+func (p *ewmaPlacer) runner(addr string) *runnerEWMA {
+	s, ok := p.runners[addr]
+	if !ok {
+		s = &runnerEWMA{}
+		p.runners[addr] = s
+	}
+	return s
+}
+
+// started records a call tried on r
+func (p *ewmaPlacer) started(r Runner) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.runner(r.Address()).inFlight++
+}
+
+// finished records the outcome and latency of a call tried on r, and on hedge if tried on both
+func (p *ewmaPlacer) finished(r, hedge, placedOn Runner, tried int, err error, latency time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.runner(r.Address()).inFlight--
+
+	// the outcome of a hedged call is the winner's, or the first runner's if neither accepted it
+	if tried > 1 && placedOn == hedge {
+		r = hedge
+	}
+	s := p.runner(r.Address())
+	now := time.Now()
+
+	nack := 0.0
+	switch {
+	case err == ErrHedgeLost, err == context.Canceled, err == context.DeadlineExceeded:
+		// says nothing about the runner
+		return
+	case err == nil, ErrorClassOf(err) == ErrorClassUser:
+		if s.measured.IsZero() {
+			s.latency = float64(latency)
+		} else {
+			s.latency = decayed(s.latency, float64(latency), now.Sub(s.measured), p.cfg.EWMADecay)
+		}
+		s.measured = now
+	default:
+		// too busy or failed to take the call
+		nack = 1
+	}
+
+	if s.nacked.IsZero() {
+		s.nackRate = nack
+	} else {
+		s.nackRate = decayed(s.nackRate, nack, now.Sub(s.nacked), p.cfg.EWMADecay)
+	}
+	s.nacked = now
+}
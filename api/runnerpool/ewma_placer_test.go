@@ -0,0 +1,96 @@
+package runnerpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestEWMAPlacer_Order(t *testing.T) {
+	cfg := NewPlacerConfig()
+	p := NewEWMAPlacer(&cfg).(*ewmaPlacer)
+
+	fast := &addrRunner{addr: "fast"}
+	slow := &addrRunner{addr: "slow"}
+	runners := []Runner{slow, fast}
+
+	// runners not observed yet are tried before slow ones
+	p.started(slow)
+	p.finished(slow, nil, slow, 1, nil, 100*time.Millisecond)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, []string{"fast", "slow"}, runnerAddrs(p.twoChoices(runners)))
+	}
+
+	p.started(fast)
+	p.finished(fast, nil, fast, 1, nil, 10*time.Millisecond)
+	assert.Equal(t, []string{"fast", "slow"}, runnerAddrs(p.twoChoices(runners)))
+
+	// calls in flight count towards the cost
+	for i := 0; i < 20; i++ {
+		p.started(fast)
+	}
+	assert.Equal(t, []string{"slow", "fast"}, runnerAddrs(p.twoChoices(runners)))
+	for i := 0; i < 20; i++ {
+		p.finished(fast, nil, nil, 1, context.Canceled, 0)
+	}
+	assert.Equal(t, []string{"fast", "slow"}, runnerAddrs(p.twoChoices(runners)))
+
+	// repeated call errors and NACKs move calls away from a runner
+	for i := 0; i < 60; i++ {
+		p.started(fast)
+		p.finished(fast, nil, fast, 1, errors.New("connection reset"), time.Millisecond)
+	}
+	assert.Equal(t, []string{"slow", "fast"}, runnerAddrs(p.twoChoices(runners)))
+
+	other := &addrRunner{addr: "other"}
+	p.started(other)
+	p.finished(other, nil, other, 1, nil, 10*time.Millisecond)
+	for i := 0; i < 60; i++ {
+		p.started(other)
+		p.finished(other, nil, nil, 1, &RunnerBusyError{Reason: RejectMemory}, time.Millisecond)
+	}
+	assert.Equal(t, []string{"slow", "other"}, runnerAddrs(p.twoChoices([]Runner{other, slow})))
+
+	// heavier runners are cheaper
+	big := &weightedRunner{addrRunner: addrRunner{addr: "big"}, weight: 20}
+	p.started(big)
+	p.finished(big, nil, big, 1, nil, 100*time.Millisecond)
+	assert.Equal(t, []string{"big", "slow"}, runnerAddrs(p.twoChoices([]Runner{slow, big})))
+}
+
+func TestEWMAPlacer_Decay(t *testing.T) {
+	assert.Equal(t, 10.0, decayed(0, 10, time.Second, 0))
+	assert.InDelta(t, minSampleWeight, decayed(0, 1, 0, time.Second), 0.001)
+	assert.InDelta(t, 1-1/2.718281828, decayed(0, 1, time.Second, time.Second), 0.001)
+	assert.InDelta(t, 1, decayed(0, 1, time.Hour, time.Second), 0.001)
+}
+
+// Calls are placed on the runner with the lowest latency
+func TestEWMAPlacer_PlacesOnFastest(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	p := NewEWMAPlacer(&cfg).(*ewmaPlacer)
+
+	pool := &dummyPool{}
+	call := &dummyCall{}
+
+	slow := &addrRunner{addr: "slow"}
+	fast := &addrRunner{addr: "fast"}
+	fast.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(true, nil)
+	runners := []Runner{slow, fast}
+	pool.On("Runners", ctx, call).Return(runners, nil)
+
+	p.started(slow)
+	p.finished(slow, nil, slow, 1, nil, time.Second)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, p.PlaceCall(ctx, pool, call))
+	}
+	assert.Equal(t, 0, CallCount(&slow.Mock, "TryExec"))
+	assert.Equal(t, 3, CallCount(&fast.Mock, "TryExec"))
+}
@@ -0,0 +1,187 @@
+package runnerpool
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runnerLoad is what the load placer knows about the load of a runner
+type runnerLoad struct {
+	// active calls the runner reported in its last status
+	active int32
+	// calls this placer had in flight on the runner, now and when the last status was taken
+	inFlight       int32
+	inFlightAtPoll int32
+	// consecutive failed status polls and call errors, other than too busy rejections
+	failures int
+	// set if the last status reported the runner cordoned, draining or without network
+	unavailable bool
+
+	polled  time.Time
+	polling bool
+	seen    time.Time
+}
+
+// estimate returns the estimated number of active calls on the runner, from its last
+// status and the calls placed on it or finished since
+func (l *runnerLoad) estimate() int32 {
+	n := l.active + l.inFlight - l.inFlightAtPoll
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func (l *runnerLoad) healthy() bool {
+	return l.failures == 0 && !l.unavailable
+}
+
+type loadPlacer struct {
+	cfg PlacerConfig
+
+	mtx   sync.Mutex
+	loads map[string]*runnerLoad
+}
+
+// NewLoadPlacer returns a placer that tries runners in order of their estimated load,
+// least loaded healthy runners first. The runners' Status is polled in the background
+// at most every cfg.LoadPollInterval, as they are used to place calls, and runners with
+// failed polls or call errors are tried after healthy ones.
+func NewLoadPlacer(cfg *PlacerConfig) Placer {
+	logrus.Infof("Creating new load runnerpool placer with config=%+v", cfg)
+	return &loadPlacer{
+		cfg:   *cfg,
+		loads: make(map[string]*runnerLoad),
+	}
+}
+
+func (p *loadPlacer) GetPlacerConfig() PlacerConfig {
+	return p.cfg
+}
+
+func (p *loadPlacer) PlaceCall(ctx context.Context, rp RunnerPool, call RunnerCall) error {
+	state := NewPlacerTracker(ctx, &p.cfg, call)
+	defer state.HandleDone()
+
+	return state.PlaceCall(rp, func(runners []Runner) []Runner {
+		return p.leastLoaded(state.ReadyRunners(runners))
+	}, func(r, hedge Runner) (Runner, int, error) {
+		p.started(r)
+		placedOn, tried, err := state.TryRunnerHedged(r, hedge, call)
+		p.finished(r, hedge, placedOn, tried, err)
+		return placedOn, tried, err
+	})
+}
+
+// leastLoaded returns runners ordered by health and estimated load, runners with the
+// same load in random order. Runners with a status older than the poll interval are
+// polled in the background, runners not seen for a while are forgotten.
+func (p *loadPlacer) leastLoaded(runners []Runner) []Runner {
+	now := time.Now()
+
+	type ranked struct {
+		r       Runner
+		healthy bool
+		load    int32
+	}
+	ranks := make([]ranked, 0, len(runners))
+
+	p.mtx.Lock()
+	for _, r := range runners {
+		l := p.load(r.Address())
+		l.seen = now
+		if !l.polling && now.Sub(l.polled) >= p.cfg.LoadPollInterval {
+			l.polling = true
+			go p.poll(r)
+		}
+		ranks = append(ranks, ranked{r, l.healthy(), l.estimate()})
+	}
+	for addr, l := range p.loads {
+		if now.Sub(l.seen) > 10*p.cfg.LoadPollInterval && l.inFlight == 0 && !l.polling {
+			delete(p.loads, addr)
+		}
+	}
+	p.mtx.Unlock()
+
+	rand.Shuffle(len(ranks), func(i, j int) {
+		ranks[i], ranks[j] = ranks[j], ranks[i]
+	})
+	sort.SliceStable(ranks, func(i, j int) bool {
+		if ranks[i].healthy != ranks[j].healthy {
+			return ranks[i].healthy
+		}
+		return ranks[i].load < ranks[j].load
+	})
+
+	ordered := make([]Runner, 0, len(ranks))
+	for _, rank := range ranks {
+		ordered = append(ordered, rank.r)
+	}
+	return ordered
+}
+
+// load returns the load of the runner at addr, p.mtx must be held
+func (p *loadPlacer) load(addr string) *runnerLoad {
+	l, ok := p.loads[addr]
+	if !ok {
+		l = &runnerLoad{}
+		p.loads[addr] = l
+	}
+	return l
+}
+
+// poll records the load from the status of r
+func (p *loadPlacer) poll(r Runner) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.LoadPollInterval)
+	status, err := r.Status(ctx)
+	cancel()
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	l := p.load(r.Address())
+	l.polling = false
+	l.polled = time.Now()
+	if err != nil || status == nil || status.StatusFailed {
+		logrus.WithError(err).WithField("runner_addr", r.Address()).Debug("Failed to get runner status for placement")
+		l.failures++
+		return
+	}
+	l.failures = 0
+	l.active = status.ActiveRequestCount
+	l.inFlightAtPoll = l.inFlight
+	l.unavailable = status.IsCordoned || status.IsDraining || status.IsNetworkDisabled
+}
+
+// started records a call tried on r
+func (p *loadPlacer) started(r Runner) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.load(r.Address()).inFlight++
+}
+
+// finished records the outcome of a call tried on r, and on hedge if tried on both
+func (p *loadPlacer) finished(r, hedge, placedOn Runner, tried int, err error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.load(r.Address()).inFlight--
+
+	// the outcome of a hedged call is the winner's, or the first runner's if neither accepted it
+	if tried > 1 && placedOn == hedge {
+		r = hedge
+	}
+	l := p.load(r.Address())
+	switch _, busy := RejectReasonOf(err); {
+	case err == nil:
+		l.failures = 0
+	case busy, err == ErrHedgeLost, err == context.Canceled, err == context.DeadlineExceeded:
+	case ErrorClassOf(err) != ErrorClassUser:
+		l.failures++
+	}
+}
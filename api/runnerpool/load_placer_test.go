@@ -0,0 +1,104 @@
+package runnerpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// implements Runner, reporting a status
+type statusRunner struct {
+	addrRunner
+	status *RunnerStatus
+	err    error
+}
+
+func (o *statusRunner) Status(ctx context.Context) (*RunnerStatus, error) { return o.status, o.err }
+
+// waitPolled waits for the load placer to have polled all runners
+func waitPolled(t *testing.T, p *loadPlacer, runners []Runner) {
+	p.leastLoaded(runners)
+	for i := 0; i < 100; i++ {
+		p.mtx.Lock()
+		polled := true
+		for _, r := range runners {
+			if l := p.loads[r.Address()]; l == nil || l.polling || l.polled.IsZero() {
+				polled = false
+			}
+		}
+		p.mtx.Unlock()
+		if polled {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Runners were not polled")
+}
+
+func TestLoadPlacer_Order(t *testing.T) {
+	cfg := NewPlacerConfig()
+	cfg.LoadPollInterval = time.Hour
+	p := NewLoadPlacer(&cfg).(*loadPlacer)
+
+	busy := &statusRunner{addrRunner: addrRunner{addr: "busy"}, status: &RunnerStatus{ActiveRequestCount: 5}}
+	idle := &statusRunner{addrRunner: addrRunner{addr: "idle"}, status: &RunnerStatus{ActiveRequestCount: 1}}
+	failed := &statusRunner{addrRunner: addrRunner{addr: "failed"}, err: errors.New("unreachable")}
+	cordoned := &statusRunner{addrRunner: addrRunner{addr: "cordoned"}, status: &RunnerStatus{IsCordoned: true}}
+	runners := []Runner{failed, cordoned, busy, idle}
+
+	waitPolled(t, p, runners)
+	ordered := runnerAddrs(p.leastLoaded(runners))
+	assert.Equal(t, []string{"idle", "busy"}, ordered[:2])
+	assert.ElementsMatch(t, []string{"failed", "cordoned"}, ordered[2:])
+
+	// calls in flight count towards the load until the next poll
+	for i := 0; i < 5; i++ {
+		p.started(idle)
+	}
+	assert.Equal(t, []string{"busy", "idle"}, runnerAddrs(p.leastLoaded(runners))[:2])
+	for i := 0; i < 5; i++ {
+		p.finished(idle, nil, idle, 1, nil)
+	}
+	assert.Equal(t, []string{"idle", "busy"}, runnerAddrs(p.leastLoaded(runners))[:2])
+
+	// call errors make a runner unhealthy until it runs a call again
+	p.started(idle)
+	p.finished(idle, nil, idle, 1, errors.New("connection reset"))
+	assert.Equal(t, "busy", runnerAddrs(p.leastLoaded(runners))[0])
+	p.started(idle)
+	p.finished(idle, nil, nil, 1, &RunnerBusyError{Reason: RejectMemory})
+	assert.Equal(t, "busy", runnerAddrs(p.leastLoaded(runners))[0])
+	p.started(idle)
+	p.finished(idle, nil, idle, 1, nil)
+	assert.Equal(t, "idle", runnerAddrs(p.leastLoaded(runners))[0])
+}
+
+// Calls are placed on the least loaded runner
+func TestLoadPlacer_PlacesOnLeastLoaded(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	cfg.LoadPollInterval = time.Hour
+	placer := NewLoadPlacer(&cfg)
+
+	pool := &dummyPool{}
+	call := &dummyCall{}
+
+	busy := &statusRunner{addrRunner: addrRunner{addr: "busy"}, status: &RunnerStatus{ActiveRequestCount: 5}}
+	idle := &statusRunner{addrRunner: addrRunner{addr: "idle"}, status: &RunnerStatus{}}
+	idle.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(true, nil)
+	runners := []Runner{busy, idle}
+	pool.On("Runners", ctx, call).Return(runners, nil)
+
+	waitPolled(t, placer.(*loadPlacer), runners)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	}
+	assert.Equal(t, 0, CallCount(&busy.Mock, "TryExec"))
+	assert.Equal(t, 3, CallCount(&idle.Mock, "TryExec"))
+}
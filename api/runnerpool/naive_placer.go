@@ -2,21 +2,24 @@ package runnerpool
 
 import (
 	"context"
+	"math"
+	"math/rand"
+	"sort"
 	"time"
 
-	"github.com/fnproject/fn/api/models"
-
 	"github.com/sirupsen/logrus"
 )
 
 type naivePlacer struct {
-	cfg PlacerConfig
+	cfg    PlacerConfig
+	recent *recentRunners
 }
 
 func NewNaivePlacer(cfg *PlacerConfig) Placer {
 	logrus.Infof("Creating new naive runnerpool placer with config=%+v", cfg)
 	return &naivePlacer{
-		cfg: *cfg,
+		cfg:    *cfg,
+		recent: newRecentRunners(cfg.RecentRunnersCacheSize, cfg.RecentRunnersTTL),
 	}
 }
 
@@ -28,36 +31,68 @@ func (sp *naivePlacer) PlaceCall(ctx context.Context, rp RunnerPool, call Runner
 	state := NewPlacerTracker(ctx, &sp.cfg, call)
 	defer state.HandleDone()
 
-	var runnerPoolErr error
-	for {
-		var runners []Runner
-		runners, runnerPoolErr = rp.Runners(ctx, call)
-
-		rrIndex := uint64(time.Now().Nanosecond())
-
-		for j := 0; j < len(runners) && !state.IsDone(); j++ {
-
-			rrIndex += 1
-			r := runners[rrIndex%uint64(len(runners))]
+	order := func(runners []Runner) []Runner {
+		// round robin order, or weighted random order for runners of different weights,
+		// biased toward runners that recently ran this slot hash
+		ordered := weightedOrder(runners)
+		if ordered == nil {
+			rrIndex := uint64(time.Now().Nanosecond())
+			ordered = make([]Runner, 0, len(runners))
+			for j := 0; j < len(runners); j++ {
+				rrIndex += 1
+				ordered = append(ordered, runners[rrIndex%uint64(len(runners))])
+			}
+		}
+		return sp.recent.PreferRecent(call.SlotHashId(), state.ReadyRunners(ordered))
+	}
 
-			placed, err := state.TryRunner(r, call)
-			if placed {
-				return err
+	return state.PlaceCall(rp, order, func(r, hedge Runner) (Runner, int, error) {
+		placedOn, tried, err := state.TryRunnerHedged(r, hedge, call)
+		if placedOn != nil {
+			if err == nil {
+				sp.recent.Add(call.SlotHashId(), placedOn.Address())
 			}
+			return placedOn, tried, err
 		}
+		// a runner without the image is no better than any other for this slot,
+		// stop preferring it
+		if reason, _ := RejectReasonOf(err); tried == 1 && reason == RejectImageNotCached {
+			sp.recent.Remove(call.SlotHashId(), r.Address())
+		}
+		return placedOn, tried, err
+	})
+}
 
-		if !state.RetryAllBackoff(len(runners), runnerPoolErr) {
+// weightedOrder returns runners in random order, each runner being tried first with a
+// probability proportional to its weight. Returns nil if all runners have the same weight.
+func weightedOrder(runners []Runner) []Runner {
+	weighted := false
+	for _, r := range runners {
+		if RunnerWeight(r) != RunnerWeight(runners[0]) {
+			weighted = true
 			break
 		}
 	}
+	if !weighted {
+		return nil
+	}
+
+	// weighted random sampling without replacement (Efraimidis, Spirakis)
+	type ranked struct {
+		r   Runner
+		key float64
+	}
+	ranks := make([]ranked, 0, len(runners))
+	for _, r := range runners {
+		ranks = append(ranks, ranked{r, math.Pow(rand.Float64(), 1/float64(RunnerWeight(r)))})
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		return ranks[i].key > ranks[j].key
+	})
 
-	if runnerPoolErr != nil {
-		// If we haven't been able to place the function and we got an error
-		// from the runner pool, return that error (since we don't have
-		// enough runners to handle the current load and the runner pool is
-		// having trouble).
-		state.HandleFindRunnersFailure(runnerPoolErr)
-		return runnerPoolErr
+	ordered := make([]Runner, 0, len(ranks))
+	for _, rank := range ranks {
+		ordered = append(ordered, rank.r)
 	}
-	return models.ErrCallTimeoutServerBusy
+	return ordered
 }
@@ -5,6 +5,7 @@ import (
 	"io"
 	"math"
 	"net/http"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -20,6 +21,7 @@ type dummyCall struct {
 }
 
 func (o *dummyCall) SlotHashId() string                     { return "" }
+func (o *dummyCall) Priority() CallPriority                 { return PriorityInteractive }
 func (o *dummyCall) Extensions() map[string]string          { return nil }
 func (o *dummyCall) RequestBody() io.ReadCloser             { return nil }
 func (o *dummyCall) ResponseWriter() http.ResponseWriter    { return nil }
@@ -51,6 +53,7 @@ type dummyRunner struct {
 func (o *dummyRunner) Status(ctx context.Context) (*RunnerStatus, error) { return nil, nil }
 func (o *dummyRunner) Close(ctx context.Context) error                   { return nil }
 func (o *dummyRunner) Address() string                                   { return "" }
+func (o *dummyRunner) Ready() bool                                       { return true }
 func (o *dummyRunner) TryExec(ctx context.Context, call RunnerCall) (bool, error) {
 	args := o.Called(ctx, call)
 	return args.Bool(0), args.Error(1)
@@ -227,3 +230,498 @@ func TestNaivePlacer_SimpleList_NoError(t *testing.T) {
 	// should not be more than 1
 	assert.True(t, math.Abs(float64(r1Count)-float64(r2Count)) <= float64(1), "runner hit count inbalance")
 }
+
+// implements CallQueue, letting the call try the runners again waits times
+type countingQueue struct {
+	waits int32
+	since []time.Time
+}
+
+func (o *countingQueue) Wait(ctx context.Context, call RunnerCall, since time.Time) bool {
+	o.since = append(o.since, since)
+	return atomic.AddInt32(&o.waits, -1) >= 0
+}
+
+// Busy runners with a call queue, should wait in the queue instead of spinning
+func TestNaivePlacer_SimpleList_CallQueue(t *testing.T) {
+
+	queue := &countingQueue{waits: 2}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+	ctx = WithCallQueue(ctx, queue)
+
+	cfg := NewPlacerConfig()
+	placer := NewNaivePlacer(&cfg)
+
+	pool := &dummyPool{}
+	call := &dummyCall{}
+
+	runner1 := &dummyRunner{}
+	runner1.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(false, models.ErrCallTimeoutServerBusy)
+	pool.On("Runners", ctx, call).Return([]Runner{runner1}, nil)
+
+	assert.Equal(t, models.ErrCallTimeoutServerBusy, placer.PlaceCall(ctx, pool, call))
+	assert.Nil(t, ctx.Err())
+
+	// tried once, then again after each of the two waits the queue allowed
+	assert.Equal(t, 3, CallCount(&runner1.Mock, "TryExec"))
+	assert.Len(t, queue.since, 3)
+	assert.Equal(t, queue.since[0], queue.since[2], "call should keep its place in the queue")
+}
+
+// implements RunnerPool and RunnerPoolNotifier
+type notifyingPool struct {
+	dummyPool
+	added chan struct{}
+}
+
+func (o *notifyingPool) RunnersAdded() <-chan struct{} { return o.added }
+
+// Empty list with fail fast configured, should not spin
+func TestNaivePlacer_EmptyList_FailFast(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	cfg.EmptyPoolWait = -1
+	placer := NewNaivePlacer(&cfg)
+
+	pool := &dummyPool{}
+	call := &dummyCall{}
+
+	pool.On("Runners", ctx, call).Return([]Runner{}, nil)
+
+	assert.Equal(t, models.ErrCallTimeoutServerBusy, placer.PlaceCall(ctx, pool, call))
+
+	pCount := CallCount(&pool.Mock, "Runners")
+	assert.True(t, pCount == 1, "should not be spinning, hit count %d", pCount)
+}
+
+// Empty list that gets a runner added while the placer waits for it
+func TestNaivePlacer_EmptyList_WaitForRunner(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	cfg.RetryAllDelay = time.Hour // only the notification should wake the placer up
+	cfg.EmptyPoolWait = time.Second
+	placer := NewNaivePlacer(&cfg)
+
+	pool := &notifyingPool{added: make(chan struct{})}
+	call := &dummyCall{}
+
+	runner1 := &dummyRunner{}
+	runner1.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(true, nil)
+
+	pool.On("Runners", ctx, call).Return([]Runner{}, nil).Once()
+	pool.On("Runners", ctx, call).Return([]Runner{runner1}, nil)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(pool.added)
+	}()
+
+	assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	assert.Nil(t, ctx.Err())
+	assert.Equal(t, 1, CallCount(&runner1.Mock, "TryExec"))
+}
+
+// Empty list, wait for a runner up to the deadline then give up
+func TestNaivePlacer_EmptyList_WaitDeadline(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	cfg.EmptyPoolWait = 100 * time.Millisecond
+	placer := NewNaivePlacer(&cfg)
+
+	pool := &dummyPool{}
+	call := &dummyCall{}
+
+	pool.On("Runners", ctx, call).Return([]Runner{}, nil)
+
+	start := time.Now()
+	assert.Equal(t, models.ErrCallTimeoutServerBusy, placer.PlaceCall(ctx, pool, call))
+	assert.True(t, time.Since(start) < time.Second, "should give up after the empty pool wait")
+	assert.Nil(t, ctx.Err())
+}
+
+// implements AckRunner, accepts calls after a delay
+type ackRunner struct {
+	dummyRunner
+	addr  string
+	delay time.Duration
+	execs int32
+	lost  int32
+}
+
+func (o *ackRunner) Address() string { return o.addr }
+func (o *ackRunner) TryExec(ctx context.Context, call RunnerCall) (bool, error) {
+	return o.TryExecWithAck(ctx, call, nil)
+}
+func (o *ackRunner) TryExecWithAck(ctx context.Context, call RunnerCall, ack func() bool) (bool, error) {
+	atomic.AddInt32(&o.execs, 1)
+	select {
+	case <-time.After(o.delay):
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+	if ack != nil && !ack() {
+		atomic.AddInt32(&o.lost, 1)
+		return false, ErrHedgeLost
+	}
+	return true, nil
+}
+
+var _ AckRunner = &ackRunner{}
+
+func TestPlacerTracker_Hedged(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	cfg.HedgeDelay = 20 * time.Millisecond
+	call := newHedgeableCall()
+
+	slow := &ackRunner{addr: "slow", delay: 2 * time.Second}
+	fast := &ackRunner{addr: "fast", delay: 10 * time.Millisecond}
+
+	// slow runner does not accept within the hedge delay, fast one wins
+	state := NewPlacerTracker(ctx, &cfg, call)
+	placedOn, tried, err := state.TryRunnerHedged(slow, fast, call)
+	state.HandleDone()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, tried)
+	assert.Equal(t, fast, placedOn)
+
+	// fast runner accepts before the hedge delay, slow one is not tried
+	slow = &ackRunner{addr: "slow", delay: 2 * time.Second}
+	state = NewPlacerTracker(ctx, &cfg, call)
+	placedOn, tried, err = state.TryRunnerHedged(fast, slow, call)
+	state.HandleDone()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, tried)
+	assert.Equal(t, fast, placedOn)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&slow.execs))
+
+	// both accept, only the first one to do so runs the call
+	first := &ackRunner{addr: "first", delay: 40 * time.Millisecond}
+	second := &ackRunner{addr: "second", delay: 100 * time.Millisecond}
+	state = NewPlacerTracker(ctx, &cfg, call)
+	placedOn, tried, err = state.TryRunnerHedged(first, second, call)
+	state.HandleDone()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, tried)
+	assert.Equal(t, first, placedOn)
+	assert.Nil(t, ctx.Err())
+}
+
+func TestPlacerTracker_HedgeDisabled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5*time.Second))
+	defer cancel()
+
+	hedged := NewPlacerConfig()
+	hedged.HedgeDelay = 10 * time.Millisecond
+
+	notOptedIn := newHedgeableCall()
+	notOptedIn.Annotations = models.EmptyAnnotations()
+	notIdempotent := newHedgeableCall()
+	notIdempotent.Method = "POST"
+
+	for _, tc := range []struct {
+		cfg  PlacerConfig
+		call *dummyCall
+	}{
+		// no hedge delay
+		{NewPlacerConfig(), newHedgeableCall()},
+		// fn without the hedge annotation
+		{hedged, notOptedIn},
+		// call that may not run twice
+		{hedged, notIdempotent},
+	} {
+		slow := &ackRunner{addr: "slow", delay: 50 * time.Millisecond}
+		fast := &ackRunner{addr: "fast", delay: time.Millisecond}
+
+		state := NewPlacerTracker(ctx, &tc.cfg, tc.call)
+		placedOn, tried, err := state.TryRunnerHedged(slow, fast, tc.call)
+		state.HandleDone()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, tried)
+		assert.Equal(t, slow, placedOn)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&fast.execs))
+	}
+}
+
+// newHedgeableCall returns an idempotent call of a fn opting into hedged placement
+func newHedgeableCall() *dummyCall {
+	annotations, _ := models.EmptyAnnotations().With(models.HedgeAnnotation, true)
+	return &dummyCall{Call: models.Call{Type: models.TypeSync, Method: "GET", Annotations: annotations}}
+}
+
+// implements Runner, never ready
+type notReadyRunner struct {
+	dummyRunner
+}
+
+func (o *notReadyRunner) Ready() bool { return false }
+
+// Not ready runners are skipped
+func TestNaivePlacer_SkipsNotReady(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	placer := NewNaivePlacer(&cfg)
+
+	pool := &dummyPool{}
+	call := &dummyCall{}
+
+	runner1 := &notReadyRunner{}
+	runner2 := &dummyRunner{}
+
+	runner2.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(true, nil)
+	pool.On("Runners", ctx, call).Return([]Runner{runner1, runner2}, nil)
+
+	assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	assert.Equal(t, 0, CallCount(&runner1.Mock, "TryExec"))
+	assert.Equal(t, 1, CallCount(&runner2.Mock, "TryExec"))
+}
+
+// Not ready runners are skipped, placer retries until its timeout
+func TestCHPlacer_SkipsNotReady(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	cfg.PlacerTimeout = time.Duration(200 * time.Millisecond)
+	placer := NewCHPlacer(&cfg)
+
+	pool := &dummyPool{}
+	call := &dummyCall{}
+
+	runner1 := &notReadyRunner{}
+	pool.On("Runners", ctx, call).Return([]Runner{runner1}, nil)
+
+	assert.Equal(t, models.ErrCallTimeoutServerBusy, placer.PlaceCall(ctx, pool, call))
+	assert.Equal(t, 0, CallCount(&runner1.Mock, "TryExec"))
+	assert.Nil(t, ctx.Err())
+}
+
+// implements RunnerCall with a slot hash
+type slotCall struct {
+	dummyCall
+	slot string
+}
+
+func (o *slotCall) SlotHashId() string { return o.slot }
+
+// Runners rejecting a call for lack of its image are no longer preferred for its slot
+func TestNaivePlacer_ForgetsRejectingRunner(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	placer := NewNaivePlacer(&cfg).(*naivePlacer)
+
+	pool := &dummyPool{}
+	call := &slotCall{slot: "slot"}
+
+	runner1 := &addrRunner{addr: "r1"}
+	runner2 := &addrRunner{addr: "r2"}
+	runner1.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(false, &RunnerBusyError{Reason: RejectImageNotCached})
+	runner2.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(true, nil)
+
+	pool.On("Runners", ctx, call).Return([]Runner{runner1}, nil).Once()
+	pool.On("Runners", ctx, call).Return([]Runner{runner2}, nil)
+
+	placer.recent.Add("slot", "r1")
+	assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	assert.Equal(t, map[string]bool{"r2": true}, placer.recent.Get("slot"))
+}
+
+func TestRejectReasonOf(t *testing.T) {
+	reason, busy := RejectReasonOf(&RunnerBusyError{Reason: RejectMemory})
+	assert.True(t, busy)
+	assert.Equal(t, RejectMemory, reason)
+	assert.Equal(t, models.ErrCallTimeoutServerBusy.Code(), models.GetAPIErrorCode(&RunnerBusyError{Reason: RejectMemory}))
+
+	reason, busy = RejectReasonOf(models.ErrCallTimeoutServerBusy)
+	assert.True(t, busy)
+	assert.Equal(t, RejectUnknown, reason)
+
+	_, busy = RejectReasonOf(models.ErrCallTimeout)
+	assert.False(t, busy)
+}
+
+// implements WeightedRunner
+type weightedRunner struct {
+	addrRunner
+	weight int
+}
+
+func (o *weightedRunner) Weight() int { return o.weight }
+
+// Runners are tried first in proportion to their weight
+func TestWeightedOrder(t *testing.T) {
+	assert.Nil(t, weightedOrder([]Runner{&addrRunner{addr: "r1"}, &weightedRunner{addrRunner: addrRunner{addr: "r2"}, weight: 1}}))
+
+	runners := []Runner{&weightedRunner{addrRunner: addrRunner{addr: "big"}, weight: 3}, &addrRunner{addr: "small"}}
+	first := 0
+	for i := 0; i < 4000; i++ {
+		ordered := weightedOrder(runners)
+		assert.Len(t, ordered, 2)
+		if ordered[0].Address() == "big" {
+			first++
+		}
+	}
+	assert.InDelta(t, 3000, first, 200, "big runner should be tried first 3 times out of 4")
+}
+
+// implements LabeledRunner
+type labeledRunner struct {
+	dummyRunner
+	labels map[string]string
+}
+
+func (o *labeledRunner) Labels() map[string]string { return o.labels }
+
+// Only runners with labels matching the runner constraints of the call are tried
+func TestNaivePlacer_RunnerConstraints(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	cfg.PlacerTimeout = time.Duration(200 * time.Millisecond)
+	placer := NewNaivePlacer(&cfg)
+
+	pool := &dummyPool{}
+	call := &dummyCall{}
+	call.Annotations, _ = models.EmptyAnnotations().With(models.RunnerConstraintsAnnotation, map[string]string{"gpu": "true"})
+
+	runner1 := &dummyRunner{}
+	runner2 := &labeledRunner{labels: map[string]string{"gpu": "false"}}
+	runner3 := &labeledRunner{labels: map[string]string{"gpu": "true", "zone": "phx-1"}}
+
+	runner3.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(true, nil)
+	pool.On("Runners", ctx, call).Return([]Runner{runner1, runner2, runner3}, nil)
+
+	assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	assert.Equal(t, 0, CallCount(&runner1.Mock, "TryExec"))
+	assert.Equal(t, 0, CallCount(&runner2.Mock, "TryExec"))
+	assert.Equal(t, 1, CallCount(&runner3.Mock, "TryExec"))
+
+	// no runner matches, the call is not placed
+	pool = &dummyPool{}
+	pool.On("Runners", ctx, call).Return([]Runner{runner1, runner2}, nil)
+	assert.Equal(t, models.ErrCallTimeoutServerBusy, placer.PlaceCall(ctx, pool, call))
+	assert.Equal(t, 0, CallCount(&runner1.Mock, "TryExec"))
+	assert.Equal(t, 0, CallCount(&runner2.Mock, "TryExec"))
+	assert.Nil(t, ctx.Err())
+}
+
+// Calls of fns of a platform are only placed on runners of that platform
+func TestNaivePlacer_Platform(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	cfg.PlacerTimeout = time.Duration(200 * time.Millisecond)
+	placer := NewNaivePlacer(&cfg)
+
+	pool := &dummyPool{}
+	call := &dummyCall{}
+	call.Annotations, _ = models.EmptyAnnotations().With(models.PlatformAnnotation, "linux/arm64")
+
+	runner1 := &labeledRunner{labels: map[string]string{"os": "linux", "arch": "amd64"}}
+	runner2 := &labeledRunner{labels: map[string]string{"os": "linux", "arch": "arm64"}}
+
+	runner2.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(true, nil)
+	pool.On("Runners", ctx, call).Return([]Runner{runner1, runner2}, nil)
+
+	assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	assert.Equal(t, 0, CallCount(&runner1.Mock, "TryExec"))
+	assert.Equal(t, 1, CallCount(&runner2.Mock, "TryExec"))
+}
+
+// implements RunnerCall with a priority class
+type priorityCall struct {
+	dummyCall
+	priority CallPriority
+}
+
+func (o *priorityCall) Priority() CallPriority { return o.priority }
+
+// Calls are placed within the placement budget of their priority class
+func TestNaivePlacer_PriorityBudgets(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	cfg.PriorityBudgets = map[CallPriority]PlacementBudget{
+		PriorityInteractive: {Timeout: 100 * time.Millisecond},
+		PriorityBatch:       {MaxAttempts: 5},
+	}
+	placer := NewNaivePlacer(&cfg)
+
+	runner1 := &dummyRunner{}
+	runner2 := &dummyRunner{}
+	runner1.On("TryExec", mock.Anything, mock.Anything).Return(false, models.ErrCallTimeoutServerBusy)
+	runner2.On("TryExec", mock.Anything, mock.Anything).Return(false, models.ErrCallTimeoutServerBusy)
+	pool := &dummyPool{}
+	pool.On("Runners", ctx, mock.Anything).Return([]Runner{runner1, runner2}, nil)
+
+	// interactive calls fail fast
+	start := time.Now()
+	call := &priorityCall{priority: PriorityInteractive}
+	assert.Equal(t, models.ErrCallTimeoutServerBusy, placer.PlaceCall(ctx, pool, call))
+	assert.True(t, time.Since(start) < time.Second, "interactive call should fail within its budget, took %v", time.Since(start))
+
+	// batch calls fail once they tried the maximum number of runners
+	runner1.Calls, runner2.Calls = nil, nil
+	call = &priorityCall{priority: PriorityBatch}
+	assert.Equal(t, models.ErrCallTimeoutServerBusy, placer.PlaceCall(ctx, pool, call))
+	assert.Equal(t, 5, CallCount(&runner1.Mock, "TryExec")+CallCount(&runner2.Mock, "TryExec"))
+	assert.Nil(t, ctx.Err())
+
+	// without a budget timeout, detached calls keep the detached placer timeout
+	cfg.DetachedPlacerTimeout = time.Minute
+	call.Type = models.TypeDetached
+	assert.Equal(t, PlacementBudget{Timeout: time.Minute, MaxAttempts: 5}, cfg.PlacementBudget(call))
+}
+
+// Calls are placed on runners in the zone of the LB, and spill over to other zones once
+// those are busy
+func TestNaivePlacer_Zone(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(5*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	cfg.Zone = "phx-1"
+	placer := NewNaivePlacer(&cfg)
+
+	remote1 := &labeledRunner{labels: map[string]string{ZoneLabel: "phx-2"}}
+	remote2 := &dummyRunner{}
+	local := &labeledRunner{labels: map[string]string{ZoneLabel: "phx-1"}}
+	remote1.On("TryExec", mock.Anything, mock.Anything).Return(true, nil)
+	remote2.On("TryExec", mock.Anything, mock.Anything).Return(true, nil)
+	local.On("TryExec", mock.Anything, mock.Anything).Return(true, nil).Once()
+	local.On("TryExec", mock.Anything, mock.Anything).Return(false, models.ErrCallTimeoutServerBusy)
+	pool := &dummyPool{}
+	pool.On("Runners", ctx, mock.Anything).Return([]Runner{remote1, remote2, local}, nil)
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, placer.PlaceCall(ctx, pool, &dummyCall{}))
+	}
+	// the first call ran in the local zone, the second spilled over
+	assert.Equal(t, 2, CallCount(&local.Mock, "TryExec"))
+	assert.Equal(t, 1, CallCount(&remote1.Mock, "TryExec")+CallCount(&remote2.Mock, "TryExec"))
+}
@@ -2,6 +2,8 @@ package runnerpool
 
 import (
 	"time"
+
+	"github.com/fnproject/fn/api/models"
 )
 
 // Common config for placers.
@@ -14,12 +16,79 @@ type PlacerConfig struct {
 
 	// Maximum amount of time a placer can hold an ack sync request during runner attempts
 	DetachedPlacerTimeout time.Duration `json:"detached_placer_timeout"`
+
+	// Number of slot hashes for which recently successful runners are remembered and
+	// preferred on retries. Zero disables the cache.
+	RecentRunnersCacheSize int `json:"recent_runners_cache_size"`
+
+	// How long a runner is remembered as recently successful for a slot hash
+	RecentRunnersTTL time.Duration `json:"recent_runners_ttl"`
+
+	// Maximum amount of time a placer waits for a runner to appear when the runner
+	// pool is empty. Negative fails fast, zero keeps retrying until the placer timeout.
+	EmptyPoolWait time.Duration `json:"empty_pool_wait"`
+
+	// If a runner has not accepted a call within this delay, the call is also tried on
+	// the next runner and runs on whichever accepts it first. A runner accepts a call
+	// when it sends its first response message, so both runners may start executing it
+	// before the other is cancelled. Only idempotent calls of fns with
+	// models.HedgeAnnotation are hedged. Zero disables.
+	HedgeDelay time.Duration `json:"hedge_delay"`
+
+	// Number of runners ranked first for a slot hash that the slot hash placer spreads
+	// its calls over, before trying other runners
+	SlotHashRunners int `json:"slot_hash_runners"`
+
+	// How often the load placer polls the status of the runners it places calls on
+	LoadPollInterval time.Duration `json:"load_poll_interval"`
+
+	// How fast the EWMA placer forgets the latencies and NACK rates it observed of runners,
+	// observations this long ago weigh 1/e of the latest
+	EWMADecay time.Duration `json:"ewma_decay"`
+
+	// Placement budgets of calls by priority class, overriding PlacerTimeout and
+	// DetachedPlacerTimeout, eg. to fail interactive calls fast while batch calls
+	// wait longer for capacity
+	PriorityBudgets map[CallPriority]PlacementBudget `json:"priority_budgets,omitempty"`
+
+	// Zone of the LB. Calls are tried on runners with the same ZoneLabel first, and only
+	// spill over to runners of other zones once the local ones are busy or not ready.
+	// Empty disables zone aware placement.
+	Zone string `json:"zone,omitempty"`
+}
+
+// PlacementBudget limits how long and on how many runners a placer tries to place calls
+// of a priority class before failing them as too busy
+type PlacementBudget struct {
+	// Maximum amount of time a placer can hold a call during runner attempts, zero uses
+	// PlacerTimeout or DetachedPlacerTimeout
+	Timeout time.Duration `json:"timeout"`
+
+	// Maximum number of runner attempts for a call, zero is unlimited
+	MaxAttempts int `json:"max_attempts"`
+}
+
+// PlacementBudget returns the placement budget of call, with the timeout set
+func (cfg *PlacerConfig) PlacementBudget(call RunnerCall) PlacementBudget {
+	budget := cfg.PriorityBudgets[call.Priority()]
+	if budget.Timeout <= 0 {
+		budget.Timeout = cfg.PlacerTimeout
+		if call.Model().Type == models.TypeDetached {
+			budget.Timeout = cfg.DetachedPlacerTimeout
+		}
+	}
+	return budget
 }
 
 func NewPlacerConfig() PlacerConfig {
 	return PlacerConfig{
-		RetryAllDelay:         10 * time.Millisecond,
-		PlacerTimeout:         360 * time.Second,
-		DetachedPlacerTimeout: 30 * time.Second,
+		RetryAllDelay:          10 * time.Millisecond,
+		PlacerTimeout:          360 * time.Second,
+		DetachedPlacerTimeout:  30 * time.Second,
+		RecentRunnersCacheSize: 1024,
+		RecentRunnersTTL:       30 * time.Second,
+		SlotHashRunners:        2,
+		LoadPollInterval:       time.Second,
+		EWMADecay:              10 * time.Second,
 	}
 }
@@ -10,12 +10,14 @@ import (
 	"github.com/sirupsen/logrus"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
 )
 
 var (
 	attemptCountMeasure      = common.MakeMeasure("lb_placer_attempt_count", "LB Placer Number of Runners Attempted Count", "")
 	errorPoolCountMeasure    = common.MakeMeasure("lb_placer_rp_error_count", "LB Placer RunnerPool RunnerList Error Count", "")
 	emptyPoolCountMeasure    = common.MakeMeasure("lb_placer_rp_empty_count", "LB Placer RunnerPool RunnerList Empty Count", "")
+	emptyPoolWaitMeasure     = common.MakeMeasure("lb_placer_rp_empty_wait_count", "LB Placer Placed Call Count After Waiting For Empty RunnerPool", "")
 	cancelCountMeasure       = common.MakeMeasure("lb_placer_client_cancelled_count", "LB Placer Client Cancel Count", "")
 	timeoutCountMeasure      = common.MakeMeasure("lb_placer_client_timeout_count", "LB Placer Client Timeout Count", "")
 	placerTimeoutMeasure     = common.MakeMeasure("lb_placer_timeout_count", "LB Placer Timeout Count", "")
@@ -24,9 +26,25 @@ var (
 	placedOKCountMeasure     = common.MakeMeasure("lb_placer_placed_ok_count", "LB Placer Placed Call Count Without Errors", "")
 	retryTooBusyCountMeasure = common.MakeMeasure("lb_placer_retry_busy_count", "LB Placer Retry Count - Too Busy", "")
 	retryErrorCountMeasure   = common.MakeMeasure("lb_placer_retry_error_count", "LB Placer Retry Count - Errors", "")
+	hedgedCountMeasure       = common.MakeMeasure("lb_placer_hedged_count", "LB Placer Hedged Runner Attempt Count", "")
+	notReadyCountMeasure     = common.MakeMeasure("lb_placer_runner_not_ready_count", "LB Placer Skipped Not Ready Runner Count", "")
+	hedgeWonCountMeasure     = common.MakeMeasure("lb_placer_hedge_won_count", "LB Placer Calls Placed On Hedged Runner Count", "")
 	placerLatencyMeasure     = common.MakeMeasure("lb_placer_latency", "LB Placer Latency", "msecs")
+	retryRejectCountMeasure  = common.MakeMeasure("lb_placer_retry_reject_count", "LB Placer Retry Count - Too Busy By Reject Reason", "")
+	crossZoneCountMeasure    = common.MakeMeasure("lb_placer_cross_zone_count", "LB Placer Calls Placed On Runners Of Other Zones Count", "")
+
+	rejectReasonKey = common.MakeKey("reject_reason")
 )
 
+// statsRejectReason counts a too busy rejection for which the runner reported a reason
+func statsRejectReason(ctx context.Context, reason RejectReason) {
+	ctx, err := tag.New(ctx, tag.Upsert(rejectReasonKey, string(reason)))
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	stats.Record(ctx, retryRejectCountMeasure.M(0))
+}
+
 // Helper struct for tracking LB Placer latency and attempt counts
 type attemptTracker struct {
 	ctx             context.Context
@@ -68,10 +86,18 @@ func (data *attemptTracker) recordAttempt() {
 }
 
 func RegisterPlacerViews(tagKeys []string, latencyDist []float64) {
+	rejectTags := []string{"reject_reason"}
+	for _, key := range tagKeys {
+		if key != "reject_reason" {
+			rejectTags = append(rejectTags, key)
+		}
+	}
+
 	err := view.Register(
 		common.CreateView(attemptCountMeasure, view.Distribution(0, 2, 3, 4, 8, 16, 32, 64, 128, 256), tagKeys),
 		common.CreateView(errorPoolCountMeasure, view.Count(), tagKeys),
 		common.CreateView(emptyPoolCountMeasure, view.Count(), tagKeys),
+		common.CreateView(emptyPoolWaitMeasure, view.Count(), tagKeys),
 		common.CreateView(timeoutCountMeasure, view.Count(), tagKeys),
 		common.CreateView(cancelCountMeasure, view.Count(), tagKeys),
 		common.CreateView(placerTimeoutMeasure, view.Count(), tagKeys),
@@ -80,6 +106,11 @@ func RegisterPlacerViews(tagKeys []string, latencyDist []float64) {
 		common.CreateView(placedOKCountMeasure, view.Count(), tagKeys),
 		common.CreateView(retryTooBusyCountMeasure, view.Count(), tagKeys),
 		common.CreateView(retryErrorCountMeasure, view.Count(), tagKeys),
+		common.CreateView(retryRejectCountMeasure, view.Count(), rejectTags),
+		common.CreateView(hedgedCountMeasure, view.Count(), tagKeys),
+		common.CreateView(notReadyCountMeasure, view.Count(), tagKeys),
+		common.CreateView(hedgeWonCountMeasure, view.Count(), tagKeys),
+		common.CreateView(crossZoneCountMeasure, view.Count(), tagKeys),
 		common.CreateView(placerLatencyMeasure, view.Distribution(latencyDist...), tagKeys),
 	)
 	if err != nil {
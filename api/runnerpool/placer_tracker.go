@@ -2,6 +2,9 @@ package runnerpool
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/fnproject/fn/api/common"
 	"github.com/fnproject/fn/api/models"
@@ -11,33 +14,69 @@ import (
 
 type placerTracker struct {
 	cfg        *PlacerConfig
+	call       RunnerCall
 	requestCtx context.Context
 	placerCtx  context.Context
 	cancel     context.CancelFunc
 	tracker    *attemptTracker
 	isPlaced   bool
+
+	// set when the placer started waiting on an empty runner pool
+	emptyPoolDeadline time.Time
+
+	// placement constraints of the call, see MatchingRunners
+	constraints        map[string]string
+	invalidConstraints bool
+	// set when the last runner list had runners, but none matching the constraints
+	unmatched bool
+	// maximum number of runner attempts, zero is unlimited
+	maxAttempts int
+	// set when the call first waited in the call queue, see WithCallQueue
+	queuedAt time.Time
+	// session of the call, see WithSession
+	session *session
 }
 
 func NewPlacerTracker(requestCtx context.Context, cfg *PlacerConfig, call RunnerCall) *placerTracker {
 
-	timeout := cfg.PlacerTimeout
-	if call.Model().Type == models.TypeDetached {
-		timeout = cfg.DetachedPlacerTimeout
+	budget := cfg.PlacementBudget(call)
+
+	constraints, err := call.Model().Annotations.RunnerConstraints()
+	if err != nil {
+		common.Logger(requestCtx).WithError(err).Warn("Invalid runner constraints, no runner matches the call")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), budget.Timeout)
 	return &placerTracker{
-		cfg:        cfg,
-		requestCtx: requestCtx,
-		placerCtx:  ctx,
-		cancel:     cancel,
-		tracker:    newAttemptTracker(requestCtx),
+		cfg:                cfg,
+		call:               call,
+		requestCtx:         requestCtx,
+		placerCtx:          ctx,
+		cancel:             cancel,
+		tracker:            newAttemptTracker(requestCtx),
+		constraints:        constraints,
+		invalidConstraints: err != nil,
+		maxAttempts:        budget.MaxAttempts,
+		session:            sessionOf(requestCtx),
 	}
 }
 
-// IsDone is a non-blocking check to see if the underlying deadlines are exceeded.
+// IsDone is a non-blocking check to see if the underlying deadlines or the runner
+// attempts of the placement budget are exceeded.
 func (tr *placerTracker) IsDone() bool {
-	return tr.requestCtx.Err() != nil || tr.placerCtx.Err() != nil
+	return tr.requestCtx.Err() != nil || tr.placerCtx.Err() != nil || tr.attemptsLeft() == 0
+}
+
+// attemptsLeft returns the number of runner attempts left in the placement budget, -1 if
+// not limited
+func (tr *placerTracker) attemptsLeft() int {
+	if tr.maxAttempts <= 0 {
+		return -1
+	}
+	if left := int64(tr.maxAttempts) - tr.tracker.attemptCount; left > 0 {
+		return int(left)
+	}
+	return 0
 }
 
 // HandleFindRunnersFailure is a convenience function to record error from runnerpool.Runners()
@@ -51,6 +90,88 @@ func (tr *placerTracker) HandleFindRunnersFailure(err error) {
 	stats.Record(tr.requestCtx, errorPoolCountMeasure.M(0))
 }
 
+// MatchingRunners returns the runners whose labels match the placement constraints of
+// the call, in the same order. If no runner matches, placers treat the pool as empty.
+func (tr *placerTracker) MatchingRunners(runners []Runner) []Runner {
+	if len(tr.constraints) == 0 && !tr.invalidConstraints {
+		tr.unmatched = false
+		return runners
+	}
+	matching := make([]Runner, 0, len(runners))
+	for _, r := range runners {
+		if !tr.invalidConstraints && MatchesConstraints(r, tr.constraints) {
+			matching = append(matching, r)
+		}
+	}
+	tr.unmatched = len(runners) > 0 && len(matching) == 0
+	return matching
+}
+
+// ReadyRunners returns the runners that are ready to take calls, in the same order
+func (tr *placerTracker) ReadyRunners(runners []Runner) []Runner {
+	ready := make([]Runner, 0, len(runners))
+	for _, r := range runners {
+		if r.Ready() {
+			ready = append(ready, r)
+		} else {
+			stats.Record(tr.requestCtx, notReadyCountMeasure.M(0))
+		}
+	}
+	return ready
+}
+
+// ZoneOrder moves the runners in the zone of the LB, see PlacerConfig.Zone, ahead of the
+// runners of other zones, keeping their order otherwise
+func (tr *placerTracker) ZoneOrder(runners []Runner) []Runner {
+	if tr.cfg.Zone == "" {
+		return runners
+	}
+	ordered := make([]Runner, 0, len(runners))
+	var others []Runner
+	for _, r := range runners {
+		if RunnerZone(r) == tr.cfg.Zone {
+			ordered = append(ordered, r)
+		} else {
+			others = append(others, r)
+		}
+	}
+	return append(ordered, others...)
+}
+
+// SessionOrder moves the runner hosting the session of the call, see WithSession, ahead
+// of the other runners. Runners are unchanged if the call has no session or its runner
+// is not in the list.
+func (tr *placerTracker) SessionOrder(runners []Runner) []Runner {
+	if tr.session == nil {
+		return runners
+	}
+	addr := tr.session.runners.Get(tr.session.key)
+	for i, r := range runners {
+		if r.Address() != addr {
+			continue
+		}
+		if i == 0 {
+			return runners
+		}
+		ordered := make([]Runner, 0, len(runners))
+		ordered = append(ordered, r)
+		ordered = append(ordered, runners[:i]...)
+		return append(ordered, runners[i+1:]...)
+	}
+	return runners
+}
+
+// recordPlacedOn records the zone of the runner a call was placed on, and the runner as
+// the host of the session of the call
+func (tr *placerTracker) recordPlacedOn(r Runner) {
+	if tr.cfg.Zone != "" && RunnerZone(r) != tr.cfg.Zone {
+		stats.Record(tr.requestCtx, crossZoneCountMeasure.M(0))
+	}
+	if tr.session != nil {
+		tr.session.runners.Set(tr.session.key, r.Address())
+	}
+}
+
 // TryRunner is a convenience function to TryExec a call on a runner and
 // analyze the results.
 func (tr *placerTracker) TryRunner(r Runner, call RunnerCall) (bool, error) {
@@ -62,11 +183,22 @@ func (tr *placerTracker) TryRunner(r Runner, call RunnerCall) (bool, error) {
 	isPlaced, err := r.TryExec(ctx, call)
 	cancel()
 
+	tr.recordResult(isPlaced, err)
+	if isPlaced {
+		tr.recordPlacedOn(r)
+	}
+	return isPlaced, err
+}
+
+func (tr *placerTracker) recordResult(isPlaced bool, err error) {
 	if !isPlaced {
 
 		// Too Busy is super common case, we track it separately
-		if err == models.ErrCallTimeoutServerBusy {
+		if reason, busy := RejectReasonOf(err); busy {
 			stats.Record(tr.requestCtx, retryTooBusyCountMeasure.M(0))
+			if reason != RejectUnknown {
+				statsRejectReason(tr.requestCtx, reason)
+			}
 		} else if tr.requestCtx.Err() != err {
 			// only record retry due to an error if client did not abort/cancel/timeout
 			stats.Record(tr.requestCtx, retryErrorCountMeasure.M(0))
@@ -84,8 +216,183 @@ func (tr *placerTracker) TryRunner(r Runner, call RunnerCall) (bool, error) {
 		// Call is now committed. In other words, it was 'run'. We are done.
 		tr.isPlaced = true
 	}
+}
 
-	return isPlaced, err
+type hedgeResult struct {
+	idx      int
+	isPlaced bool
+	err      error
+}
+
+// hedgeable reports whether the call may run on two runners, an idempotent call of a fn
+// opting in with models.HedgeAnnotation
+func hedgeable(call RunnerCall) bool {
+	c := call.Model()
+	if c == nil || !c.Idempotent() {
+		return false
+	}
+	on, _ := c.Annotations.Hedge()
+	return on
+}
+
+// TryRunnerHedged is TryRunner that, with PlacerConfig.HedgeDelay set, also tries the
+// call on hedge if r has not accepted it within the delay. The call runs on the first
+// runner to accept it, the other abandons its engagement. Hedging requires both runners
+// to implement AckRunner, and a hedgeable call. Returns the runner the call was placed
+// on (nil if not placed) and the number of runners tried.
+func (tr *placerTracker) TryRunnerHedged(r, hedge Runner, call RunnerCall) (Runner, int, error) {
+	first, ok1 := r.(AckRunner)
+	second, ok2 := hedge.(AckRunner)
+	if tr.cfg.HedgeDelay <= 0 || hedge == nil || !ok1 || !ok2 || tr.attemptsLeft() == 1 || !hedgeable(call) {
+		isPlaced, err := tr.TryRunner(r, call)
+		if isPlaced {
+			return r, 1, err
+		}
+		return nil, 1, err
+	}
+
+	runners := [2]AckRunner{first, second}
+	var ctxs [2]context.Context
+	var cancels [2]context.CancelFunc
+	for i := range runners {
+		// WARNING: Do not use placerCtx here to let requestCtx take its time
+		// during container execution.
+		ctxs[i], cancels[i] = context.WithCancel(tr.requestCtx)
+		defer cancels[i]()
+	}
+
+	// index+1 of the runner that accepted the call first, the other one is cancelled
+	var winner int32
+	accepted := make(chan struct{})
+	var acceptOnce sync.Once
+	ack := func(idx int) func() bool {
+		return func() bool {
+			if !atomic.CompareAndSwapInt32(&winner, 0, int32(idx+1)) && atomic.LoadInt32(&winner) != int32(idx+1) {
+				return false
+			}
+			acceptOnce.Do(func() {
+				cancels[1-idx]()
+				close(accepted)
+			})
+			return true
+		}
+	}
+
+	results := make(chan hedgeResult, 2)
+	tryExec := func(idx int) {
+		tr.tracker.recordAttempt()
+		go func() {
+			isPlaced, err := runners[idx].TryExecWithAck(ctxs[idx], call, ack(idx))
+			results <- hedgeResult{idx: idx, isPlaced: isPlaced, err: err}
+		}()
+	}
+
+	tryExec(0)
+
+	t := common.NewTimer(tr.cfg.HedgeDelay)
+	defer t.Stop()
+
+	var res hedgeResult
+	select {
+	case res = <-results:
+		// first runner was done before the hedge delay
+		return tr.hedgeDone(r, hedge, 1, res)
+	case <-accepted:
+		return tr.hedgeDone(r, hedge, 1, <-results)
+	case <-t.C:
+	}
+
+	stats.Record(tr.requestCtx, hedgedCountMeasure.M(0))
+	tryExec(1)
+
+	var all [2]hedgeResult
+	for i := 0; i < 2; i++ {
+		res = <-results
+		all[res.idx] = res
+	}
+
+	switch w := atomic.LoadInt32(&winner); {
+	case w != 0:
+		res = all[w-1]
+	case all[0].isPlaced || !all[1].isPlaced:
+		// neither accepted the call, prefer the first runner's outcome
+		res = all[0]
+	default:
+		res = all[1]
+	}
+	if res.idx == 1 && res.isPlaced {
+		stats.Record(tr.requestCtx, hedgeWonCountMeasure.M(0))
+	}
+	return tr.hedgeDone(r, hedge, 2, res)
+}
+
+func (tr *placerTracker) hedgeDone(r, hedge Runner, tried int, res hedgeResult) (Runner, int, error) {
+	tr.recordResult(res.isPlaced, res.err)
+	if !res.isPlaced {
+		return nil, tried, res.err
+	}
+	if res.idx == 1 {
+		r = hedge
+	}
+	tr.recordPlacedOn(r)
+	return r, tried, res.err
+}
+
+// PlaceCall is the placement loop of the placers. It lists the runners of rp matching the
+// call, orders them with order and tries the call on them in that order until it is
+// placed, retrying the runner list until the placement budget is used up. try tries the
+// call on a runner, hedged with the next one, it is TryRunnerHedged if nil.
+func (tr *placerTracker) PlaceCall(rp RunnerPool, order func([]Runner) []Runner, try func(r, hedge Runner) (Runner, int, error)) error {
+	if try == nil {
+		try = func(r, hedge Runner) (Runner, int, error) {
+			return tr.TryRunnerHedged(r, hedge, tr.call)
+		}
+	}
+
+	var runnerPoolErr error
+	for {
+		var runners []Runner
+		runners, runnerPoolErr = rp.Runners(tr.requestCtx, tr.call)
+		runners = tr.MatchingRunners(runners)
+
+		ordered := tr.SessionOrder(tr.ZoneOrder(order(runners)))
+
+		for j := 0; j < len(ordered) && !tr.IsDone(); {
+
+			r := ordered[j]
+			var hedge Runner
+			if j+1 < len(ordered) {
+				hedge = ordered[j+1]
+			}
+
+			placedOn, tried, err := try(r, hedge)
+			if placedOn != nil {
+				return err
+			}
+			j += tried
+		}
+
+		if len(runners) == 0 && runnerPoolErr == nil {
+			if !tr.WaitForRunners(rp) {
+				break
+			}
+			continue
+		}
+
+		if !tr.RetryAllBackoff(len(runners), runnerPoolErr) {
+			break
+		}
+	}
+
+	if runnerPoolErr != nil {
+		// If we haven't been able to place the function and we got an error
+		// from the runner pool, return that error (since we don't have
+		// enough runners to handle the current load and the runner pool is
+		// having trouble).
+		tr.HandleFindRunnersFailure(runnerPoolErr)
+		return runnerPoolErr
+	}
+	return models.ErrCallTimeoutServerBusy
 }
 
 // HandleDone is cleanup function to cancel pending contexts and to
@@ -107,6 +414,14 @@ func (tr *placerTracker) HandleDone() {
 		stats.Record(tr.requestCtx, placerTimeoutMeasure.M(0))
 	}
 
+	if tr.isPlaced && !tr.emptyPoolDeadline.IsZero() {
+		stats.Record(tr.requestCtx, emptyPoolWaitMeasure.M(0))
+	}
+
+	if !tr.isPlaced && tr.unmatched {
+		common.Logger(tr.requestCtx).WithField("runner_constraints", tr.constraints).Warn("No runner matches the runner constraints of the call")
+	}
+
 	tr.tracker.finalizeAttempts(tr.isPlaced)
 	tr.cancel()
 }
@@ -120,6 +435,11 @@ func (tr *placerTracker) RetryAllBackoff(numOfRunners int, err error) bool {
 		stats.Record(tr.requestCtx, emptyPoolCountMeasure.M(0))
 	}
 
+	// the runner attempts of the placement budget are used up
+	if tr.attemptsLeft() == 0 {
+		return false
+	}
+
 	// If there are no runners and last call to provision runners failed due
 	// to a user error (or misconfiguration) then fail fast.
 	if numOfRunners == 0 && err != nil {
@@ -136,6 +456,10 @@ func (tr *placerTracker) RetryAllBackoff(numOfRunners int, err error) bool {
 		}
 	}
 
+	if q := callQueueOf(tr.requestCtx); q != nil && numOfRunners > 0 {
+		return tr.waitInQueue(q)
+	}
+
 	t := common.NewTimer(tr.cfg.RetryAllDelay)
 	defer t.Stop()
 
@@ -149,3 +473,63 @@ func (tr *placerTracker) RetryAllBackoff(numOfRunners int, err error) bool {
 
 	return true
 }
+
+// waitInQueue waits in q until capacity may have freed, or the call timed out
+func (tr *placerTracker) waitInQueue(q CallQueue) bool {
+	if tr.queuedAt.IsZero() {
+		tr.queuedAt = time.Now()
+	}
+	ctx := tr.requestCtx
+	if deadline, ok := tr.placerCtx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	return q.Wait(ctx, tr.call, tr.queuedAt) && !tr.IsDone()
+}
+
+// WaitForRunners blocks until it is time to list runners again after the runner
+// pool returned no runners and no error. Depending on PlacerConfig.EmptyPoolWait,
+// it either fails fast, behaves as RetryAllBackoff or waits up to EmptyPoolWait for
+// the pool to report added runners (polling at RetryAllDelay if the pool does not
+// implement RunnerPoolNotifier). Returns false if the placer should stop trying.
+func (tr *placerTracker) WaitForRunners(rp RunnerPool) bool {
+	if tr.cfg.EmptyPoolWait == 0 {
+		return tr.RetryAllBackoff(0, nil)
+	}
+
+	stats.Record(tr.requestCtx, emptyPoolCountMeasure.M(0))
+	if tr.cfg.EmptyPoolWait < 0 {
+		return false
+	}
+
+	now := time.Now()
+	if tr.emptyPoolDeadline.IsZero() {
+		tr.emptyPoolDeadline = now.Add(tr.cfg.EmptyPoolWait)
+	}
+	wait := tr.emptyPoolDeadline.Sub(now)
+	if wait <= 0 {
+		return false
+	}
+
+	var added <-chan struct{}
+	if notifier, ok := rp.(RunnerPoolNotifier); ok {
+		added = notifier.RunnersAdded()
+	} else if tr.cfg.RetryAllDelay < wait {
+		wait = tr.cfg.RetryAllDelay
+	}
+
+	t := common.NewTimer(wait)
+	defer t.Stop()
+
+	select {
+	case <-tr.requestCtx.Done(): // client side timeout/cancel
+		return false
+	case <-tr.placerCtx.Done(): // placer wait timeout
+		return false
+	case <-added:
+	case <-t.C:
+	}
+
+	return true
+}
@@ -0,0 +1,156 @@
+package runnerpool
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// max number of runners remembered per slot hash
+const maxRecentRunnersPerKey = 4
+
+// recentRunners is a small LRU cache of runners that recently ran a call
+// successfully for a given slot hash. Placers use it to prefer these runners
+// on retries, since they are likely to have a warm container for the call.
+type recentRunners struct {
+	mtx     sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type recentRunnersEntry struct {
+	key     string
+	runners map[string]time.Time
+}
+
+// newRecentRunners returns nil if the cache is disabled (size or ttl not positive)
+func newRecentRunners(size int, ttl time.Duration) *recentRunners {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &recentRunners{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Add records a successful call for key on the runner with address addr
+func (c *recentRunners) Add(key, addr string) {
+	if c == nil || key == "" {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	elem, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(elem)
+	} else {
+		elem = c.lru.PushFront(&recentRunnersEntry{key: key, runners: make(map[string]time.Time)})
+		c.entries[key] = elem
+		if c.lru.Len() > c.size {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.entries, oldest.Value.(*recentRunnersEntry).key)
+		}
+	}
+
+	entry := elem.Value.(*recentRunnersEntry)
+	entry.runners[addr] = now
+	if len(entry.runners) > maxRecentRunnersPerKey {
+		var oldestAddr string
+		var oldestTime time.Time
+		for a, t := range entry.runners {
+			if oldestAddr == "" || t.Before(oldestTime) {
+				oldestAddr, oldestTime = a, t
+			}
+		}
+		delete(entry.runners, oldestAddr)
+	}
+}
+
+// Get returns the set of runner addresses that recently succeeded for key
+func (c *recentRunners) Get(key string) map[string]bool {
+	if c == nil || key == "" {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := elem.Value.(*recentRunnersEntry)
+	now := time.Now()
+	var res map[string]bool
+	for addr, t := range entry.runners {
+		if now.Sub(t) > c.ttl {
+			delete(entry.runners, addr)
+			continue
+		}
+		if res == nil {
+			res = make(map[string]bool, len(entry.runners))
+		}
+		res[addr] = true
+	}
+	if len(entry.runners) == 0 {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+	return res
+}
+
+// Remove forgets that the runner with address addr recently succeeded for key
+func (c *recentRunners) Remove(key, addr string) {
+	if c == nil || key == "" {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	entry := elem.Value.(*recentRunnersEntry)
+	delete(entry.runners, addr)
+	if len(entry.runners) == 0 {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// PreferRecent reorders runners so that, after the first runner, those that recently
+// succeeded for key are tried before the rest. Relative order is otherwise kept.
+// Only runners in the given list are considered, so runners that the pool no
+// longer reports are never tried.
+func (c *recentRunners) PreferRecent(key string, runners []Runner) []Runner {
+	if len(runners) < 3 {
+		return runners
+	}
+	recent := c.Get(key)
+	if len(recent) == 0 {
+		return runners
+	}
+
+	ordered := make([]Runner, 0, len(runners))
+	ordered = append(ordered, runners[0])
+	for _, r := range runners[1:] {
+		if recent[r.Address()] {
+			ordered = append(ordered, r)
+		}
+	}
+	for _, r := range runners[1:] {
+		if !recent[r.Address()] {
+			ordered = append(ordered, r)
+		}
+	}
+	return ordered
+}
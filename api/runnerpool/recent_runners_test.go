@@ -0,0 +1,91 @@
+package runnerpool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// implements Runner
+type addrRunner struct {
+	dummyRunner
+	addr string
+}
+
+func (o *addrRunner) Address() string { return o.addr }
+
+func runnerAddrs(runners []Runner) []string {
+	var addrs []string
+	for _, r := range runners {
+		addrs = append(addrs, r.Address())
+	}
+	return addrs
+}
+
+func TestRecentRunners_Disabled(t *testing.T) {
+	c := newRecentRunners(0, time.Minute)
+	assert.Nil(t, c)
+
+	// nil cache is a no-op
+	c.Add("slot", "r1")
+	assert.Nil(t, c.Get("slot"))
+
+	runners := []Runner{&addrRunner{addr: "r1"}, &addrRunner{addr: "r2"}, &addrRunner{addr: "r3"}}
+	assert.Equal(t, runners, c.PreferRecent("slot", runners))
+}
+
+func TestRecentRunners_PreferRecent(t *testing.T) {
+	c := newRecentRunners(10, time.Minute)
+	c.Add("slot", "r3")
+
+	runners := []Runner{&addrRunner{addr: "r1"}, &addrRunner{addr: "r2"}, &addrRunner{addr: "r3"}, &addrRunner{addr: "r4"}}
+
+	// first runner keeps its spot, recent runner is tried next
+	assert.Equal(t, []string{"r1", "r3", "r2", "r4"}, runnerAddrs(c.PreferRecent("slot", runners)))
+
+	// other slot hashes are not affected
+	assert.Equal(t, []string{"r1", "r2", "r3", "r4"}, runnerAddrs(c.PreferRecent("other", runners)))
+
+	// runners no longer in the list are never tried
+	assert.Equal(t, []string{"r1", "r2", "r4"}, runnerAddrs(c.PreferRecent("slot", []Runner{runners[0], runners[1], runners[3]})))
+}
+
+func TestRecentRunners_TTLAndSize(t *testing.T) {
+	c := newRecentRunners(1, 50*time.Millisecond)
+	c.Add("slot1", "r1")
+	c.Add("slot2", "r2")
+
+	// slot1 evicted by size
+	assert.Nil(t, c.Get("slot1"))
+	assert.Equal(t, map[string]bool{"r2": true}, c.Get("slot2"))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, c.Get("slot2"))
+}
+
+func TestRecentRunners_PerKeyLimit(t *testing.T) {
+	c := newRecentRunners(10, time.Minute)
+	for _, addr := range []string{"r1", "r2", "r3", "r4", "r5"} {
+		c.Add("slot", addr)
+		time.Sleep(time.Millisecond)
+	}
+
+	recent := c.Get("slot")
+	assert.Len(t, recent, maxRecentRunnersPerKey)
+	assert.False(t, recent["r1"], "oldest runner should be evicted")
+}
+
+func TestRecentRunners_Remove(t *testing.T) {
+	c := newRecentRunners(10, time.Minute)
+	c.Add("slot", "r1")
+	c.Add("slot", "r2")
+
+	c.Remove("slot", "r1")
+	assert.Equal(t, map[string]bool{"r2": true}, c.Get("slot"))
+
+	// removing the last runner drops the slot
+	c.Remove("slot", "r2")
+	assert.Nil(t, c.Get("slot"))
+	assert.Equal(t, 0, c.lru.Len())
+}
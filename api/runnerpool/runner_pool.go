@@ -2,6 +2,8 @@ package runnerpool
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
@@ -24,6 +26,23 @@ type RunnerPool interface {
 	Shutdown(ctx context.Context) error
 }
 
+// RunnerPoolNotifier is optionally implemented by a RunnerPool that can notify
+// placers waiting on an empty pool that runners were added
+type RunnerPoolNotifier interface {
+	// RunnersAdded returns a channel that is closed the next time runners are added
+	RunnersAdded() <-chan struct{}
+}
+
+// RunnerPoolUpdater is optionally implemented by a RunnerPool whose runners can be
+// replaced at runtime
+type RunnerPoolUpdater interface {
+	// SetRunners replaces the runners of the pool with the runners at runnerAddresses.
+	// Runners already in the pool keep their connections, removed runners are drained.
+	SetRunners(ctx context.Context, runnerAddresses []string) error
+	// RunnerAddresses returns the addresses of the runners in the pool
+	RunnerAddresses() []string
+}
+
 // RunnerStatus is general information on Runner health as returned by Runner::Status() call
 type RunnerStatus struct {
 	ActiveRequestCount    int32           // Number of active running requests on Runner
@@ -46,6 +65,45 @@ type RunnerStatus struct {
 	CtrCreateDuration     time.Duration   //Amount of time spent creating the container
 	InitStartTime         time.Duration   // Container Init UDS Latency time
 	IsNetworkDisabled     bool            // True if network on runner is offline
+	IsCordoned            bool            // True if runner is cordoned and not accepting new calls
+	IsDraining            bool            // True if runner is draining and not accepting new calls
+}
+
+type statusRefreshKey struct{}
+
+// WithStatusRefresh returns a context that asks Runner.Status to query the runner
+// even if the runner client caches status results
+func WithStatusRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, statusRefreshKey{}, true)
+}
+
+// IsStatusRefresh reports whether ctx was returned by WithStatusRefresh
+func IsStatusRefresh(ctx context.Context) bool {
+	refresh, _ := ctx.Value(statusRefreshKey{}).(bool)
+	return refresh
+}
+
+// CallQueue holds calls that no runner took until capacity may have freed, see
+// WithCallQueue
+type CallQueue interface {
+	// Wait blocks until capacity may have freed for call, which first waited at since.
+	// Returns false if the call should fail as too busy, eg. the queue is full or the call
+	// waited too long. Calls are woken in order of priority, then of since.
+	Wait(ctx context.Context, call RunnerCall, since time.Time) bool
+}
+
+type callQueueKey struct{}
+
+// WithCallQueue returns a context that makes placers wait in q after all runners failed
+// to take a call, instead of retrying them after PlacerConfig.RetryAllDelay
+func WithCallQueue(ctx context.Context, q CallQueue) context.Context {
+	return context.WithValue(ctx, callQueueKey{}, q)
+}
+
+// callQueueOf returns the queue of ctx set by WithCallQueue, nil if there is none
+func callQueueOf(ctx context.Context) CallQueue {
+	q, _ := ctx.Value(callQueueKey{}).(CallQueue)
+	return q
 }
 
 // Runner is the interface to invoke the execution of a function call on a specific runner
@@ -54,12 +112,224 @@ type Runner interface {
 	Status(ctx context.Context) (*RunnerStatus, error)
 	Close(ctx context.Context) error
 	Address() string
+	// Ready is a non-blocking check whether the runner can currently take calls,
+	// placers skip runners that are not ready
+	Ready() bool
+}
+
+// AckRunner is optionally implemented by a Runner that can report when a runner
+// accepts a call. Only such runners are used for hedged placement.
+type AckRunner interface {
+	Runner
+	// TryExecWithAck is TryExec, invoking ack when the runner accepts the call and
+	// before anything is written to the call. If ack returns false, the engagement is
+	// abandoned and TryExecWithAck returns false with ErrHedgeLost.
+	TryExecWithAck(ctx context.Context, call RunnerCall, ack func() bool) (bool, error)
+}
+
+// WeightedRunner is optionally implemented by a Runner that should get a share of the
+// calls proportional to its weight, eg. in pools of hosts of different sizes
+type WeightedRunner interface {
+	Runner
+	// Weight is the relative share of calls of the runner, runners without a weight
+	// have a weight of 1
+	Weight() int
+}
+
+// RunnerWeight returns the weight of r, 1 if r is not a WeightedRunner
+func RunnerWeight(r Runner) int {
+	if w, ok := r.(WeightedRunner); ok && w.Weight() > 0 {
+		return w.Weight()
+	}
+	return 1
+}
+
+// DrainableRunner is optionally implemented by a Runner that can be drained for
+// maintenance of its host
+type DrainableRunner interface {
+	Runner
+	// Drain stops, or with false resumes, placing new calls on the runner, calls in
+	// flight are not affected. A draining runner is not Ready.
+	Drain(draining bool)
+	// IsDraining reports whether the runner is draining
+	IsDraining() bool
+	// CallsInFlight is the number of calls placed on the runner that did not finish yet
+	CallsInFlight() int
+}
+
+// ImagePullRunner is optionally implemented by a Runner that can pull the images of fns
+// ahead of their calls
+type ImagePullRunner interface {
+	Runner
+	// PullImage pulls image on the runner if it does not have it yet
+	PullImage(ctx context.Context, image string) error
+}
+
+// ContainerProfile is a profile of a hot container of a fn on a runner, eg. a pprof CPU profile
+type ContainerProfile struct {
+	ContainerID string
+	ContentType string
+	Data        []byte
+}
+
+// ContainerProfileRunner is optionally implemented by a Runner that can profile the hot
+// containers of fns
+type ContainerProfileRunner interface {
+	Runner
+	// ProfileContainer returns a profile of type typ, over seconds for CPU profiles, of a
+	// hot container of the fn on the runner
+	ProfileContainer(ctx context.Context, fnID, typ string, seconds int) (*ContainerProfile, error)
 }
 
+// LabeledRunner is optionally implemented by a Runner with labels describing it, eg. its
+// zone or hardware, which placers match against the placement constraints of calls, see
+// models.RunnerConstraintsAnnotation
+type LabeledRunner interface {
+	Runner
+	// Labels returns the labels of the runner, the map must not be modified
+	Labels() map[string]string
+}
+
+// ZoneLabel is the label of runners with the zone, eg. availability zone, they run in. See
+// PlacerConfig.Zone.
+const ZoneLabel = "zone"
+
+// RunnerZone returns the zone label of r, empty if it has none
+func RunnerZone(r Runner) string {
+	if lr, ok := r.(LabeledRunner); ok {
+		return lr.Labels()[ZoneLabel]
+	}
+	return ""
+}
+
+// MatchesConstraints reports whether r has all labels of constraints with the same values.
+// Runners that are not a LabeledRunner only match empty constraints.
+func MatchesConstraints(r Runner, constraints map[string]string) bool {
+	if len(constraints) == 0 {
+		return true
+	}
+	lr, ok := r.(LabeledRunner)
+	if !ok {
+		return false
+	}
+	labels := lr.Labels()
+	for k, v := range constraints {
+		if l, ok := labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrHedgeLost is returned by an AckRunner that abandoned an engagement because
+// another runner accepted the call first
+var ErrHedgeLost = errors.New("Call was accepted by another runner")
+
+// RejectReason is why a runner rejected a call as too busy
+type RejectReason string
+
+const (
+	// RejectUnknown is a too busy rejection without reason, eg. from older runners
+	RejectUnknown RejectReason = ""
+	// RejectMemory means the runner did not have enough memory for the call
+	RejectMemory RejectReason = "memory"
+	// RejectCPU means the runner did not have enough CPU for the call
+	RejectCPU RejectReason = "cpu"
+	// RejectGPU means the runner did not have enough GPUs free for the call
+	RejectGPU RejectReason = "gpu"
+	// RejectPressure means the runner host was under memory or CPU pressure
+	RejectPressure RejectReason = "pressure"
+	// RejectImageNotCached means the runner did not have the function image
+	RejectImageNotCached RejectReason = "image_not_cached"
+	// RejectFairShare means other functions waiting on the runner had a lower share of its
+	// resources than the function
+	RejectFairShare RejectReason = "fair_share"
+	// RejectPriority means interactive calls waited for the resources the batch call needed
+	RejectPriority RejectReason = "priority"
+)
+
+// RunnerBusyError is returned by TryExec, with false, when the runner rejected the call
+// as too busy and reported why. It has the same code as models.ErrCallTimeoutServerBusy.
+type RunnerBusyError struct {
+	Reason RejectReason
+}
+
+func (e *RunnerBusyError) Error() string {
+	return fmt.Sprintf("%s (%s)", models.ErrCallTimeoutServerBusy.Error(), e.Reason)
+}
+
+// Code implements models.APIError
+func (e *RunnerBusyError) Code() int {
+	return models.ErrCallTimeoutServerBusy.Code()
+}
+
+// RejectReasonOf returns the reject reason of err, and whether err is a too busy rejection
+func RejectReasonOf(err error) (RejectReason, bool) {
+	if err == models.ErrCallTimeoutServerBusy {
+		return RejectUnknown, true
+	}
+	if e, ok := err.(*RunnerBusyError); ok {
+		return e.Reason, true
+	}
+	return RejectUnknown, false
+}
+
+// ErrorClass tells whose fault a call error reported by a runner is
+type ErrorClass string
+
+const (
+	// ErrorClassUnknown errors were not classified by the runner
+	ErrorClassUnknown ErrorClass = ""
+	// ErrorClassUser errors are caused by the function or its caller
+	ErrorClassUser ErrorClass = "user"
+	// ErrorClassQuota errors are resource limits the call ran into, the call may succeed elsewhere or later
+	ErrorClassQuota ErrorClass = "quota"
+	// ErrorClassInfra errors are failures of the runner or the infrastructure it depends on
+	ErrorClassInfra ErrorClass = "infra"
+)
+
+// RunnerCallError is a call error reported by a runner along with details on the error
+type RunnerCallError struct {
+	models.APIError
+	Class ErrorClass
+	// Retriable is set if the runner suggested retrying the call after RetryDelay
+	Retriable  bool
+	RetryDelay time.Duration
+	// Debug is diagnostic information from the runner, eg. the underlying error
+	Debug string
+}
+
+// ErrorClassOf returns the class of a call error returned by a runner
+func ErrorClassOf(err error) ErrorClass {
+	if _, busy := RejectReasonOf(err); busy {
+		return ErrorClassQuota
+	}
+	if models.IsFuncError(err) {
+		return ErrorClassUser
+	}
+	if e, ok := err.(*RunnerCallError); ok {
+		return e.Class
+	}
+	return ErrorClassUnknown
+}
+
+// CallPriority is the priority class of a RunnerCall
+type CallPriority string
+
+const (
+	// PriorityInteractive calls have a client waiting for the response
+	PriorityInteractive CallPriority = "interactive"
+	// PriorityBatch calls have nobody waiting for the response, eg. detached calls
+	PriorityBatch CallPriority = "batch"
+)
+
 // RunnerCall provides access to the necessary details of request in order for it to be
 // processed by a RunnerPool
 type RunnerCall interface {
 	SlotHashId() string
+	// Priority is the priority class of the call, which runners may use to decide
+	// which calls to admit when resources are scarce
+	Priority() CallPriority
 	Extensions() map[string]string
 	RequestBody() io.ReadCloser
 	ResponseWriter() http.ResponseWriter
@@ -0,0 +1,106 @@
+package runnerpool
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// SessionRunners is an LRU cache of the runner hosting each session, the runner that last
+// took a call of the session and likely has its warm container. Placers try the runner of
+// the session of a call first, see WithSession.
+type SessionRunners struct {
+	mtx     sync.Mutex
+	size    int
+	ttl     time.Duration
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type sessionRunnersEntry struct {
+	key  string
+	addr string
+	seen time.Time
+}
+
+// NewSessionRunners returns a cache of up to size sessions, each forgotten ttl after its
+// last call. Returns nil if size or ttl is not positive, a nil cache remembers nothing.
+func NewSessionRunners(size int, ttl time.Duration) *SessionRunners {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &SessionRunners{
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Set records that the runner with address addr hosts the session key
+func (c *SessionRunners) Set(key, addr string) {
+	if c == nil || key == "" {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*sessionRunnersEntry)
+		entry.addr, entry.seen = addr, time.Now()
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&sessionRunnersEntry{key: key, addr: addr, seen: time.Now()})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*sessionRunnersEntry).key)
+	}
+}
+
+// Get returns the address of the runner hosting the session key, empty if there is none
+func (c *SessionRunners) Get(key string) string {
+	if c == nil || key == "" {
+		return ""
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return ""
+	}
+	entry := elem.Value.(*sessionRunnersEntry)
+	if time.Since(entry.seen) > c.ttl {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return ""
+	}
+	return entry.addr
+}
+
+// session is the session of a call, see WithSession
+type session struct {
+	runners *SessionRunners
+	key     string
+}
+
+type sessionKey struct{}
+
+// WithSession returns a context that makes placers try the runner hosting the session key
+// in runners first, and record the runner the call is placed on as its new host. If the
+// runner is gone or does not take the call, the call is placed as usual.
+func WithSession(ctx context.Context, runners *SessionRunners, key string) context.Context {
+	if runners == nil || key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionKey{}, &session{runners: runners, key: key})
+}
+
+// sessionOf returns the session of ctx set by WithSession, nil if there is none
+func sessionOf(ctx context.Context) *session {
+	s, _ := ctx.Value(sessionKey{}).(*session)
+	return s
+}
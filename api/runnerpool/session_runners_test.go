@@ -0,0 +1,114 @@
+package runnerpool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fnproject/fn/api/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSessionRunners_Disabled(t *testing.T) {
+	c := NewSessionRunners(0, time.Minute)
+	assert.Nil(t, c)
+
+	// nil cache is a no-op
+	c.Set("session", "r1")
+	assert.Equal(t, "", c.Get("session"))
+
+	ctx := context.Background()
+	assert.Equal(t, ctx, WithSession(ctx, c, "session"))
+}
+
+func TestSessionRunners_TTLAndSize(t *testing.T) {
+	c := NewSessionRunners(1, 50*time.Millisecond)
+	c.Set("session1", "r1")
+	c.Set("session2", "r2")
+
+	// session1 evicted by size
+	assert.Equal(t, "", c.Get("session1"))
+	assert.Equal(t, "r2", c.Get("session2"))
+
+	// a session moves to the runner of its last call
+	c.Set("session2", "r3")
+	assert.Equal(t, "r3", c.Get("session2"))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "", c.Get("session2"))
+	assert.Equal(t, 0, c.lru.Len())
+}
+
+// Calls of a session are placed on the runner of the session, then wherever the runner
+// pool places them once that runner is gone
+func TestNaivePlacer_Session(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	sessions := NewSessionRunners(10, time.Minute)
+	sessions.Set("session", "r2")
+	ctx = WithSession(ctx, sessions, "session")
+
+	cfg := NewPlacerConfig()
+	placer := NewNaivePlacer(&cfg)
+
+	pool := &dummyPool{}
+	call := &dummyCall{}
+
+	r1 := &addrRunner{addr: "r1"}
+	r2 := &addrRunner{addr: "r2"}
+	r3 := &addrRunner{addr: "r3"}
+	for _, r := range []*addrRunner{r1, r2, r3} {
+		r.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(true, nil)
+	}
+	pool.On("Runners", ctx, call).Return([]Runner{r1, r2, r3}, nil).Times(3)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	}
+	assert.Equal(t, 3, CallCount(&r2.Mock, "TryExec"))
+	assert.Equal(t, "r2", sessions.Get("session"))
+
+	// the runner of the session is gone
+	pool.On("Runners", ctx, call).Return([]Runner{r1, r3}, nil)
+	assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	moved := sessions.Get("session")
+	assert.Contains(t, []string{"r1", "r3"}, moved)
+
+	assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	assert.Equal(t, moved, sessions.Get("session"))
+	assert.Equal(t, 2, CallCount(&r1.Mock, "TryExec")+CallCount(&r3.Mock, "TryExec"))
+	if moved == "r1" {
+		assert.Equal(t, 2, CallCount(&r1.Mock, "TryExec"))
+	} else {
+		assert.Equal(t, 2, CallCount(&r3.Mock, "TryExec"))
+	}
+}
+
+// A busy session runner is skipped, the session moves to the runner taking the call
+func TestNaivePlacer_SessionBusy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	sessions := NewSessionRunners(10, time.Minute)
+	sessions.Set("session", "r1")
+	ctx = WithSession(ctx, sessions, "session")
+
+	cfg := NewPlacerConfig()
+	placer := NewNaivePlacer(&cfg)
+
+	pool := &dummyPool{}
+	call := &dummyCall{}
+
+	r1 := &addrRunner{addr: "r1"}
+	r2 := &addrRunner{addr: "r2"}
+	r1.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(false, models.ErrCallTimeoutServerBusy)
+	r2.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(true, nil)
+	pool.On("Runners", ctx, call).Return([]Runner{r2, r1}, nil)
+
+	assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	assert.Equal(t, 1, CallCount(&r1.Mock, "TryExec"))
+	assert.Equal(t, "r2", sessions.Get("session"))
+}
@@ -0,0 +1,313 @@
+package runnerpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fnproject/fn/api/models"
+)
+
+// maxSimulatedCalls caps the calls of a simulation, see Simulate
+const maxSimulatedCalls = 100000
+
+// SimulatedRunner is a runner of a placement simulation, see Simulate
+type SimulatedRunner struct {
+	Address string            `json:"address"`
+	Weight  int               `json:"weight,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	// Slots is the number of calls the runner runs at once, zero is unlimited
+	Slots int `json:"slots,omitempty"`
+}
+
+// SimulatedCalls are calls of the call mix of a placement simulation, see Simulate. A
+// synthetic mix has a few entries with a count each, a recorded mix an entry per call.
+type SimulatedCalls struct {
+	FnID string `json:"fn_id"`
+	// SlotHashID is the slot hash of the calls, eg. to tell fn versions apart. Defaults to
+	// the fn id.
+	SlotHashID  string             `json:"slot_hash_id,omitempty"`
+	Priority    CallPriority       `json:"priority,omitempty"`
+	Annotations models.Annotations `json:"annotations,omitempty"`
+	// Count is the number of calls, defaults to one
+	Count int `json:"count,omitempty"`
+	// IntervalMS is the time between the calls, and since the calls of the previous entry,
+	// in milliseconds
+	IntervalMS int64 `json:"interval_ms,omitempty"`
+	// DurationMS is how long each call runs once placed, in milliseconds
+	DurationMS int64 `json:"duration_ms,omitempty"`
+}
+
+// SimulationResult is the placement distribution of the call mix of a simulation
+type SimulationResult struct {
+	Calls    int `json:"calls"`
+	Placed   int `json:"placed"`
+	Rejected int `json:"rejected"`
+	// Runners is the number of calls placed on each runner, by address
+	Runners map[string]int `json:"runners"`
+	// Fns is the number of calls of each fn placed on each runner, by fn id and address
+	Fns map[string]map[string]int `json:"fns"`
+}
+
+// Simulate places the calls of calls in order with placer on a pool of runners, which run
+// the calls without contacting any runner. Time only passes between calls, by their
+// interval, so that calls finish and free their slots as they would. A call that every
+// runner rejects, or that no runner matches, is counted as rejected rather than retried.
+// The placer should not be used to place real calls, as it learns from the simulation, and
+// the placer stats of the simulated calls are recorded with those of real calls.
+func Simulate(ctx context.Context, placer Placer, runners []SimulatedRunner, calls []SimulatedCalls) (*SimulationResult, error) {
+	total := 0
+	for _, c := range calls {
+		if c.FnID == "" {
+			return nil, errors.New("simulated calls need an fn id")
+		}
+		if c.Count < 0 || c.IntervalMS < 0 || c.DurationMS < 0 {
+			return nil, fmt.Errorf("simulated calls of fn %s cannot have a negative count, interval or duration", c.FnID)
+		}
+		if c.Priority != "" && c.Priority != PriorityInteractive && c.Priority != PriorityBatch {
+			return nil, fmt.Errorf("simulated calls of fn %s have an invalid priority %s", c.FnID, c.Priority)
+		}
+		total += simulatedCount(c)
+		if total > maxSimulatedCalls {
+			return nil, fmt.Errorf("simulations are limited to %d calls", maxSimulatedCalls)
+		}
+	}
+
+	sim := &simulation{}
+	for _, r := range runners {
+		if r.Address == "" {
+			return nil, errors.New("simulated runners need an address")
+		}
+		sim.runners = append(sim.runners, &simulatedRunner{sim: sim, desc: r})
+	}
+
+	res := &SimulationResult{Runners: make(map[string]int), Fns: make(map[string]map[string]int)}
+	for _, r := range runners {
+		res.Runners[r.Address] = 0
+	}
+	n := 0
+	for _, c := range calls {
+		for i := 0; i < simulatedCount(c); i++ {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			sim.advance(time.Duration(c.IntervalMS) * time.Millisecond)
+
+			call := newSimulatedCall(c, n)
+			n++
+			res.Calls++
+			addr, err := sim.place(ctx, placer, call)
+			if err != nil || addr == "" {
+				res.Rejected++
+				continue
+			}
+			res.Placed++
+			res.Runners[addr]++
+			if res.Fns[c.FnID] == nil {
+				res.Fns[c.FnID] = make(map[string]int)
+			}
+			res.Fns[c.FnID][addr]++
+		}
+	}
+	return res, nil
+}
+
+func simulatedCount(c SimulatedCalls) int {
+	if c.Count == 0 {
+		return 1
+	}
+	return c.Count
+}
+
+// simulation is the runner pool of Simulate
+type simulation struct {
+	runners []*simulatedRunner
+
+	mtx sync.Mutex
+	// time since the simulation started
+	now time.Duration
+	// the call being placed, and its state
+	call     *simulatedCall
+	cancel   context.CancelFunc
+	rounds   int
+	rejected map[string]bool
+	placedOn string
+}
+
+var _ RunnerPool = &simulation{}
+
+// advance passes d, finishing the calls that ran for their duration
+func (sim *simulation) advance(d time.Duration) {
+	sim.mtx.Lock()
+	defer sim.mtx.Unlock()
+	sim.now += d
+	for _, r := range sim.runners {
+		running := r.running[:0]
+		for _, end := range r.running {
+			if end > sim.now {
+				running = append(running, end)
+			}
+		}
+		r.running = running
+	}
+}
+
+// place places call with placer, returns the address of the runner running it
+func (sim *simulation) place(ctx context.Context, placer Placer, call *simulatedCall) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sim.mtx.Lock()
+	sim.call, sim.cancel, sim.rounds, sim.rejected, sim.placedOn = call, cancel, 0, make(map[string]bool), ""
+	sim.mtx.Unlock()
+
+	err := placer.PlaceCall(ctx, sim, call)
+
+	sim.mtx.Lock()
+	defer sim.mtx.Unlock()
+	sim.call, sim.cancel = nil, nil
+	return sim.placedOn, err
+}
+
+// Runners implements RunnerPool, the placement of a call ends once its placer asks for the
+// runners again, or if no runner matches the call
+func (sim *simulation) Runners(ctx context.Context, call RunnerCall) ([]Runner, error) {
+	sim.mtx.Lock()
+	defer sim.mtx.Unlock()
+
+	runners := make([]Runner, 0, len(sim.runners))
+	for _, r := range sim.runners {
+		runners = append(runners, r)
+	}
+	if sim.call == nil || call != sim.call {
+		return runners, nil
+	}
+	sim.rounds++
+	if sim.rounds > 1 || sim.matching() == 0 {
+		sim.cancel()
+	}
+	return runners, nil
+}
+
+// matching returns the number of runners matching the constraints of the call being
+// placed, sim.mtx must be held
+func (sim *simulation) matching() int {
+	constraints, err := sim.call.Model().Annotations.RunnerConstraints()
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, r := range sim.runners {
+		if MatchesConstraints(r, constraints) {
+			n++
+		}
+	}
+	return n
+}
+
+// Shutdown implements RunnerPool
+func (sim *simulation) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// simulatedRunner is a runner of a simulation, running calls until the simulation passes
+// their duration
+type simulatedRunner struct {
+	desc SimulatedRunner
+	sim  *simulation
+
+	// when the calls running on the runner end, sim.mtx must be held
+	running []time.Duration
+}
+
+var _ LabeledRunner = &simulatedRunner{}
+var _ WeightedRunner = &simulatedRunner{}
+
+// TryExec implements Runner, the placement of a call ends once every runner matching it
+// rejected it
+func (r *simulatedRunner) TryExec(ctx context.Context, call RunnerCall) (bool, error) {
+	sim := r.sim
+	sim.mtx.Lock()
+	defer sim.mtx.Unlock()
+
+	if call != sim.call || ctx.Err() != nil {
+		return false, context.Canceled
+	}
+	if r.desc.Slots > 0 && len(r.running) >= r.desc.Slots {
+		sim.rejected[r.desc.Address] = true
+		if len(sim.rejected) >= sim.matching() {
+			sim.cancel()
+		}
+		return false, models.ErrCallTimeoutServerBusy
+	}
+	r.running = append(r.running, sim.now+sim.call.duration)
+	sim.placedOn = r.desc.Address
+	return true, nil
+}
+
+// Status implements Runner
+func (r *simulatedRunner) Status(ctx context.Context) (*RunnerStatus, error) {
+	r.sim.mtx.Lock()
+	defer r.sim.mtx.Unlock()
+	return &RunnerStatus{ActiveRequestCount: int32(len(r.running))}, nil
+}
+
+func (r *simulatedRunner) Close(ctx context.Context) error { return nil }
+func (r *simulatedRunner) Address() string                 { return r.desc.Address }
+func (r *simulatedRunner) Ready() bool                     { return true }
+func (r *simulatedRunner) Labels() map[string]string       { return r.desc.Labels }
+
+// Weight implements WeightedRunner
+func (r *simulatedRunner) Weight() int {
+	if r.desc.Weight <= 0 {
+		return 1
+	}
+	return r.desc.Weight
+}
+
+// simulatedCall is a call of a simulation
+type simulatedCall struct {
+	model    *models.Call
+	slotHash string
+	priority CallPriority
+	duration time.Duration
+}
+
+var _ RunnerCall = &simulatedCall{}
+
+func newSimulatedCall(c SimulatedCalls, n int) *simulatedCall {
+	slotHash := c.SlotHashID
+	if slotHash == "" {
+		slotHash = c.FnID
+	}
+	priority := c.Priority
+	if priority == "" {
+		priority = PriorityInteractive
+	}
+	return &simulatedCall{
+		model: &models.Call{
+			ID:          fmt.Sprintf("simulated-%d", n),
+			FnID:        c.FnID,
+			Annotations: c.Annotations,
+		},
+		slotHash: slotHash,
+		priority: priority,
+		duration: time.Duration(c.DurationMS) * time.Millisecond,
+	}
+}
+
+func (c *simulatedCall) SlotHashId() string                     { return c.slotHash }
+func (c *simulatedCall) Priority() CallPriority                 { return c.priority }
+func (c *simulatedCall) Extensions() map[string]string          { return nil }
+func (c *simulatedCall) RequestBody() io.ReadCloser             { return ioutil.NopCloser(strings.NewReader("")) }
+func (c *simulatedCall) ResponseWriter() http.ResponseWriter    { return nil }
+func (c *simulatedCall) StdErr() io.ReadWriteCloser             { return nil }
+func (c *simulatedCall) Model() *models.Call                    { return c.model }
+func (c *simulatedCall) AddUserExecutionTime(dur time.Duration) {}
+func (c *simulatedCall) GetUserExecutionTime() *time.Duration   { return nil }
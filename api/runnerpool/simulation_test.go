@@ -0,0 +1,84 @@
+package runnerpool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fnproject/fn/api/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulate_Slots(t *testing.T) {
+	cfg := NewPlacerConfig()
+	runners := []SimulatedRunner{{Address: "r1", Slots: 1}, {Address: "r2", Slots: 1}}
+
+	// calls arriving at once take every slot, the rest is rejected
+	start := time.Now()
+	res, err := Simulate(context.Background(), NewNaivePlacer(&cfg), runners, []SimulatedCalls{
+		{FnID: "fn1", Count: 3, DurationMS: 1000},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, res.Calls)
+	assert.Equal(t, 2, res.Placed)
+	assert.Equal(t, 1, res.Rejected)
+	assert.Equal(t, map[string]int{"r1": 1, "r2": 1}, res.Runners)
+	assert.True(t, time.Since(start) < time.Second, "rejected calls should not be retried")
+
+	// calls finishing before the next call leave their slot
+	res, err = Simulate(context.Background(), NewNaivePlacer(&cfg), runners, []SimulatedCalls{
+		{FnID: "fn1", Count: 3, DurationMS: 1000},
+		{FnID: "fn2", Count: 2, IntervalMS: 1000, DurationMS: 1000},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, res.Placed)
+	assert.Equal(t, 2, res.Fns["fn2"]["r1"]+res.Fns["fn2"]["r2"])
+}
+
+func TestSimulate_Distribution(t *testing.T) {
+	cfg := NewPlacerConfig()
+	runners := []SimulatedRunner{{Address: "r1"}, {Address: "r2"}, {Address: "r3", Labels: map[string]string{"gpu": "true"}}}
+
+	// the calls of an fn land on the same runner with consistent hashing
+	res, err := Simulate(context.Background(), NewCHPlacer(&cfg), runners, []SimulatedCalls{
+		{FnID: "fn1", Count: 50},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 50, res.Placed)
+	assert.Equal(t, 1, len(res.Fns["fn1"]))
+
+	// calls are only placed on runners matching their constraints
+	annotations, err := models.EmptyAnnotations().With(models.RunnerConstraintsAnnotation, map[string]string{"gpu": "true"})
+	assert.Nil(t, err)
+	res, err = Simulate(context.Background(), NewNaivePlacer(&cfg), runners, []SimulatedCalls{
+		{FnID: "fn1", Count: 10, Annotations: annotations},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{"r1": 0, "r2": 0, "r3": 10}, res.Runners)
+
+	annotations, err = models.EmptyAnnotations().With(models.RunnerConstraintsAnnotation, map[string]string{"gpu": "false"})
+	assert.Nil(t, err)
+	res, err = Simulate(context.Background(), NewNaivePlacer(&cfg), runners, []SimulatedCalls{
+		{FnID: "fn1", Count: 10, Annotations: annotations},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, res.Rejected)
+}
+
+func TestSimulate_Invalid(t *testing.T) {
+	cfg := NewPlacerConfig()
+	p := NewNaivePlacer(&cfg)
+	runners := []SimulatedRunner{{Address: "r1"}}
+
+	_, err := Simulate(context.Background(), p, runners, []SimulatedCalls{{Count: 1}})
+	assert.NotNil(t, err)
+	_, err = Simulate(context.Background(), p, runners, []SimulatedCalls{{FnID: "fn1", Count: -1}})
+	assert.NotNil(t, err)
+	_, err = Simulate(context.Background(), p, runners, []SimulatedCalls{{FnID: "fn1", Priority: "urgent"}})
+	assert.NotNil(t, err)
+	_, err = Simulate(context.Background(), p, runners, []SimulatedCalls{{FnID: "fn1", Count: maxSimulatedCalls + 1}})
+	assert.NotNil(t, err)
+	_, err = Simulate(context.Background(), p, []SimulatedRunner{{}}, []SimulatedCalls{{FnID: "fn1"}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,79 @@
+package runnerpool
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+
+	"github.com/dchest/siphash"
+	"github.com/sirupsen/logrus"
+)
+
+// slotPlacer places calls of a slot hash on the same few runners, so that they find
+// hot containers and cached images there. Runners are ranked per slot with rendezvous
+// hashing on their address, which keeps the ranking stable when runners come and go
+// or the runner pool returns them in a different order: only the slots ranked first
+// on a removed runner move elsewhere.
+type slotPlacer struct {
+	cfg PlacerConfig
+}
+
+// NewSlotHashPlacer returns a placer that tries the calls of a slot hash (or of a fn,
+// for calls without one) on the cfg.SlotHashRunners runners ranked first for it, in
+// random order to spread the load between them, before trying the other runners in
+// rank order.
+func NewSlotHashPlacer(cfg *PlacerConfig) Placer {
+	logrus.Infof("Creating new slot hash runnerpool placer with config=%+v", cfg)
+	return &slotPlacer{
+		cfg: *cfg,
+	}
+}
+
+func (p *slotPlacer) GetPlacerConfig() PlacerConfig {
+	return p.cfg
+}
+
+func (p *slotPlacer) PlaceCall(ctx context.Context, rp RunnerPool, call RunnerCall) error {
+	state := NewPlacerTracker(ctx, &p.cfg, call)
+	defer state.HandleDone()
+
+	key := call.SlotHashId()
+	if key == "" {
+		key = call.Model().FnID
+	}
+
+	return state.PlaceCall(rp, func(runners []Runner) []Runner {
+		return state.ReadyRunners(rankRunners(key, runners, p.cfg.SlotHashRunners))
+	}, nil)
+}
+
+// rankRunners returns runners in their rendezvous hash order for key, with the first
+// subset of them shuffled
+func rankRunners(key string, runners []Runner, subset int) []Runner {
+	type ranked struct {
+		r      Runner
+		weight uint64
+	}
+	ranks := make([]ranked, 0, len(runners))
+	for _, r := range runners {
+		ranks = append(ranks, ranked{r, siphash.Hash(0, 0x4c617279426f6174, []byte(key+"/"+r.Address()))})
+	}
+	sort.Slice(ranks, func(i, j int) bool {
+		return ranks[i].weight > ranks[j].weight
+	})
+
+	ordered := make([]Runner, 0, len(ranks))
+	for _, rank := range ranks {
+		ordered = append(ordered, rank.r)
+	}
+
+	if subset > len(ordered) {
+		subset = len(ordered)
+	}
+	if subset > 1 {
+		rand.Shuffle(subset, func(i, j int) {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		})
+	}
+	return ordered
+}
@@ -0,0 +1,73 @@
+package runnerpool
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestRankRunners(t *testing.T) {
+	var runners []Runner
+	for i := 0; i < 8; i++ {
+		runners = append(runners, &addrRunner{addr: fmt.Sprintf("r%d", i)})
+	}
+	ranked := runnerAddrs(rankRunners("slot", runners, 0))
+
+	// the ranking does not depend on the order runners come in
+	reversed := make([]Runner, 0, len(runners))
+	for i := len(runners) - 1; i >= 0; i-- {
+		reversed = append(reversed, runners[i])
+	}
+	assert.Equal(t, ranked, runnerAddrs(rankRunners("slot", reversed, 0)))
+
+	// removing a runner keeps the rank of the others
+	var removed []Runner
+	for _, r := range runners {
+		if r.Address() != ranked[1] {
+			removed = append(removed, r)
+		}
+	}
+	assert.Equal(t, append([]string{ranked[0]}, ranked[2:]...), runnerAddrs(rankRunners("slot", removed, 0)))
+
+	// only the first runners are shuffled
+	for i := 0; i < 10; i++ {
+		shuffled := runnerAddrs(rankRunners("slot", runners, 3))
+		assert.ElementsMatch(t, ranked[:3], shuffled[:3])
+		assert.Equal(t, ranked[3:], shuffled[3:])
+	}
+	assert.Len(t, rankRunners("slot", runners, 100), len(runners))
+}
+
+// Calls of a slot are placed on its first ranked runner, then on the next one when it is busy
+func TestSlotHashPlacer_PrefersRankedRunner(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(2*time.Second))
+	defer cancel()
+
+	cfg := NewPlacerConfig()
+	cfg.SlotHashRunners = 1
+	placer := NewSlotHashPlacer(&cfg)
+
+	pool := &dummyPool{}
+	call := &slotCall{slot: "slot"}
+
+	runners := []Runner{&addrRunner{addr: "r1"}, &addrRunner{addr: "r2"}, &addrRunner{addr: "r3"}}
+	ranked := rankRunners("slot", runners, 0)
+	first, second := ranked[0].(*addrRunner), ranked[1].(*addrRunner)
+	first.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(true, nil).Once()
+	first.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(false, &RunnerBusyError{Reason: RejectMemory})
+	second.On("TryExec", mock.AnythingOfType("*context.cancelCtx"), call).Return(true, nil)
+	pool.On("Runners", ctx, call).Return(runners, nil)
+
+	assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	assert.Equal(t, 1, CallCount(&first.Mock, "TryExec"))
+	assert.Equal(t, 0, CallCount(&second.Mock, "TryExec"))
+
+	assert.Nil(t, placer.PlaceCall(ctx, pool, call))
+	assert.Equal(t, 2, CallCount(&first.Mock, "TryExec"))
+	assert.Equal(t, 1, CallCount(&second.Mock, "TryExec"))
+	assert.Equal(t, 0, CallCount(&ranked[2].(*addrRunner).Mock, "TryExec"))
+}
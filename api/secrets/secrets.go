@@ -0,0 +1,50 @@
+// Package secrets resolves the secrets of fns, see models.SecretsAnnotation, from the
+// secret store of the runners, eg. HashiCorp Vault. Other stores, eg. the KMS of a cloud,
+// are added by registering a Provider for their URLs.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Store is a secret store
+type Store interface {
+	// Get returns the value of the secret referenced by ref. The format of references is
+	// up to the store.
+	Get(ctx context.Context, ref string) ([]byte, error)
+}
+
+// Provider is a secret store provider
+type Provider interface {
+	fmt.Stringer
+	// Supports indicates if this provider can handle a given secret store.
+	Supports(url *url.URL) bool
+	// New creates a new secret store from the specified URL
+	New(ctx context.Context, url *url.URL) (Store, error)
+}
+
+var providers []Provider
+
+// Register globally registers a secret store provider
+func Register(provider Provider) {
+	logrus.Infof("Registering secret store provider '%s'", provider)
+	providers = append(providers, provider)
+}
+
+// New creates a Store from the specified URL
+func New(ctx context.Context, storeURL string) (Store, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, fmt.Errorf("bad secret store URL %s: %v", storeURL, err)
+	}
+	for _, provider := range providers {
+		if provider.Supports(u) {
+			return provider.New(ctx, u)
+		}
+	}
+	return nil, fmt.Errorf("no secret store provider found for secret store url scheme %s", u.Scheme)
+}
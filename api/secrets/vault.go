@@ -0,0 +1,130 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// vaultScheme is the scheme of the URLs of Vault servers served over https, eg.
+	// vault://vault.example.com:8200, vaultHTTPScheme that of servers served over http
+	vaultScheme     = "vault"
+	vaultHTTPScheme = "vault+http"
+
+	// EnvVaultToken is the token of the agent to read secrets from Vault with
+	EnvVaultToken = "VAULT_TOKEN"
+
+	vaultTimeout = 10 * time.Second
+
+	// maxVaultResponse caps the size of the secrets read from Vault
+	maxVaultResponse = 1024 * 1024
+)
+
+type vaultProvider struct{}
+
+func (vaultProvider) String() string { return "vault" }
+
+func (vaultProvider) Supports(u *url.URL) bool {
+	return u.Scheme == vaultScheme || u.Scheme == vaultHTTPScheme
+}
+
+// New creates a store of the secrets of the Vault server at u, read with the token of the
+// VAULT_TOKEN env
+func (vaultProvider) New(ctx context.Context, u *url.URL) (Store, error) {
+	token := os.Getenv(EnvVaultToken)
+	if token == "" {
+		return nil, fmt.Errorf("vault secret store needs a token in %s", EnvVaultToken)
+	}
+	addr := url.URL{Scheme: "https", Host: u.Host, Path: strings.TrimSuffix(u.Path, "/")}
+	if u.Scheme == vaultHTTPScheme {
+		addr.Scheme = "http"
+	}
+	return &vaultStore{
+		addr:   addr.String(),
+		token:  token,
+		client: &http.Client{Timeout: vaultTimeout},
+	}, nil
+}
+
+// vaultStore reads secrets from the KV secrets engine, version 1 or 2, of a Vault server.
+// Its references are the path of a secret and its key, eg. secret/data/db#password for the
+// password key of the db secret of a KV version 2 engine mounted at secret.
+type vaultStore struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+var _ Store = &vaultStore{}
+
+func (s *vaultStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	i := strings.LastIndex(ref, "#")
+	if i <= 0 || i == len(ref)-1 {
+		return nil, fmt.Errorf("vault secret reference %s needs a path and a key, eg. secret/data/db#password", ref)
+	}
+	path, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+	// vault cleans paths, refs must not reach out of the prefix of the secrets of their app
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "." || elem == ".." {
+			return nil, fmt.Errorf("vault secret reference %s has a relative path", ref)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.addr+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", s.token)
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxVaultResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned status %d reading secret %s", resp.StatusCode, path)
+	}
+
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return nil, fmt.Errorf("invalid vault secret %s: %v", path, err)
+	}
+	data := secret.Data
+	// KV version 2 engines have the values of the secret in data, next to its metadata
+	if inner, ok := data["data"]; ok {
+		if _, ok := data["metadata"]; ok {
+			data = nil
+			if err := json.Unmarshal(inner, &data); err != nil {
+				return nil, fmt.Errorf("invalid vault secret %s: %v", path, err)
+			}
+		}
+	}
+
+	v, ok := data[key]
+	if !ok {
+		return nil, fmt.Errorf("vault secret %s has no key %s", path, key)
+	}
+	// string values are the value itself, others their JSON
+	var str string
+	if err := json.Unmarshal(v, &str); err == nil {
+		return []byte(str), nil
+	}
+	return v, nil
+}
+
+func init() {
+	Register(vaultProvider{})
+}
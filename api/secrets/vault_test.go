@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestVaultStore(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		// like vault, which cleans the paths of requests
+		switch path.Clean(r.URL.Path) {
+		case "/v1/secret/data/db":
+			w.Write([]byte(`{"data": {"data": {"password": "hunter2", "port": 5432}, "metadata": {"version": 1}}}`))
+		case "/v1/kv/db":
+			w.Write([]byte(`{"data": {"password": "hunter3"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	os.Setenv(EnvVaultToken, "test-token")
+	defer os.Unsetenv(EnvVaultToken)
+	store, err := New(context.Background(), strings.Replace(srv.URL, "http://", "vault+http://", 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ref, want := range map[string]string{
+		"secret/data/db#password": "hunter2",
+		"secret/data/db#port":     "5432",
+		"/kv/db#password":         "hunter3",
+	} {
+		v, err := store.Get(context.Background(), ref)
+		if err != nil || string(v) != want {
+			t.Fatalf("Expected %s for %s, got %s %v", want, ref, v, err)
+		}
+	}
+
+	for _, ref := range []string{"secret/data/db", "#password", "secret/data/db#", "secret/data/db#user", "secret/data/tls#key", "secret/data/fn/app/../../db#password", "secret/./data/db#password"} {
+		if _, err := store.Get(context.Background(), ref); err == nil {
+			t.Fatalf("Expected an error for %s", ref)
+		}
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	os.Unsetenv(EnvVaultToken)
+	if _, err := New(context.Background(), "vault://vault.example.com:8200"); err == nil {
+		t.Fatal("Expected an error for Vault without a token")
+	}
+	if _, err := New(context.Background(), "kms://example.com"); err == nil {
+		t.Fatal("Expected an error for a store without provider")
+	}
+
+	os.Setenv(EnvVaultToken, "test-token")
+	defer os.Unsetenv(EnvVaultToken)
+	store, err := New(context.Background(), "vault://vault.example.com:8200/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := store.(*vaultStore).addr; addr != "https://vault.example.com:8200" {
+		t.Fatalf("Expected the https address of vault, got %s", addr)
+	}
+}
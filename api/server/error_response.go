@@ -18,7 +18,7 @@ import (
 var ErrInternalServerError = errors.New("internal server error")
 
 func simpleError(err error) *models.Error {
-	return &models.Error{Message: err.Error()}
+	return &models.Error{Message: err.Error(), Code: models.GetErrorCode(err)}
 }
 
 func handleErrorResponse(c *gin.Context, err error) {
@@ -43,9 +43,9 @@ func HandleErrorResponse(ctx context.Context, w http.ResponseWriter, err error)
 		if e.Code() >= 500 {
 			log.WithFields(logrus.Fields{"code": e.Code()}).WithError(e).Error("api error")
 		}
-		if err == models.ErrCallTimeoutServerBusy {
-			// TODO: Determine a better delay value here (perhaps ask Agent). For now 15 secs with
-			// the hopes that fnlb will land this on a better server immediately.
+		if models.IsTooBusy(err) && w.Header().Get("Retry-After") == "" {
+			// agents shedding load set their own delay, see agent.Backpressure. Otherwise 15 secs
+			// with the hopes that fnlb will land this on a better server immediately.
 			w.Header().Set("Retry-After", "15")
 		}
 		statuscode = e.Code()
@@ -100,7 +100,14 @@ func TestFnCreate(t *testing.T) {
 		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "%s", "image": "fnproject/fn-test-utils" }`, a.ID, tooLongName), http.StatusBadRequest, models.ErrFnsTooLongName},
 		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "a", "image": "fnproject/fn-test-utils", "timeout": 3601 }`, a.ID), http.StatusBadRequest, models.ErrFnsInvalidTimeout},
 		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "a", "image": "fnproject/fn-test-utils", "idle_timeout": 3601 }`, a.ID), http.StatusBadRequest, models.ErrFnsInvalidIdleTimeout},
+		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "a", "image": "fnproject/fn-test-utils", "init_timeout": 601 }`, a.ID), http.StatusBadRequest, models.ErrFnsInvalidInitTimeout},
 		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "a", "image": "fnproject/fn-test-utils", "memory": 100000000000000 }`, a.ID), http.StatusBadRequest, models.ErrInvalidMemory},
+		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "a", "image": "fnproject/fn-test-utils", "cpus": "5m" }`, a.ID), http.StatusBadRequest, models.ErrInvalidFnCPUs},
+		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "a", "image": "fnproject/fn-test-utils", "gpus": 17 }`, a.ID), http.StatusBadRequest, models.ErrInvalidGPUs},
+		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "a", "image": "fnproject/fn-test-utils", "concurrency": 101 }`, a.ID), http.StatusBadRequest, models.ErrInvalidConcurrency},
+		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "a", "image": "fnproject/fn-test-utils", "tmpfs_size": 1025 }`, a.ID), http.StatusBadRequest, models.ErrInvalidTmpFsSize},
+		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "a", "image": "fnproject/fn-test-utils", "fs_size": 10241 }`, a.ID), http.StatusBadRequest, models.ErrInvalidFsSize},
+		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "a", "image": "fnproject/fn-test-utils", "annotations": {"fnproject.io/fn/secrets": [{"ref": "../other/db#password", "env": "DB_PASSWORD"}]} }`, a.ID), http.StatusBadRequest, models.ErrInvalidSecrets},
 
 		// success create & update
 		{ds, http.MethodPost, "/v2/fns", fmt.Sprintf(`{ "app_id": "%s", "name": "myfunc", "image": "fnproject/fn-test-utils" }`, a.ID), http.StatusOK, nil},
@@ -125,13 +132,30 @@ func TestFnUpdate(t *testing.T) {
 		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "memory": 1000 }`, http.StatusOK, nil},
 		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "timeout": 10 }`, http.StatusOK, nil},
 		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "idle_timeout": 10 }`, http.StatusOK, nil},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "init_timeout": 120 }`, http.StatusOK, nil},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "init_timeout": 0 }`, http.StatusOK, nil},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "cpus": "500m" }`, http.StatusOK, nil},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "cpus": "0" }`, http.StatusOK, nil},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "gpus": 2 }`, http.StatusOK, nil},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "gpus": 0 }`, http.StatusOK, nil},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "concurrency": 4 }`, http.StatusOK, nil},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "concurrency": 0 }`, http.StatusOK, nil},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "tmpfs_size": 64, "fs_size": 512, "read_only_rootfs": true }`, http.StatusOK, nil},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "tmpfs_size": 0, "fs_size": 0, "read_only_rootfs": false }`, http.StatusOK, nil},
 		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "config": {"k":"v"} }`, http.StatusOK, nil},
 		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "annotations": {"k":"v"} }`, http.StatusOK, nil},
 
 		// test that partial update fails w/ same errors as create
 		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "timeout": 3601 }`, http.StatusBadRequest, models.ErrFnsInvalidTimeout},
 		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "idle_timeout": 3601 }`, http.StatusBadRequest, models.ErrFnsInvalidIdleTimeout},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "init_timeout": 601 }`, http.StatusBadRequest, models.ErrFnsInvalidInitTimeout},
 		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "memory": 100000000000000 }`, http.StatusBadRequest, models.ErrInvalidMemory},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "cpus": "5m" }`, http.StatusBadRequest, models.ErrInvalidFnCPUs},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "gpus": 17 }`, http.StatusBadRequest, models.ErrInvalidGPUs},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "concurrency": 101 }`, http.StatusBadRequest, models.ErrInvalidConcurrency},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "tmpfs_size": 1025 }`, http.StatusBadRequest, models.ErrInvalidTmpFsSize},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "fs_size": 10241 }`, http.StatusBadRequest, models.ErrInvalidFsSize},
+		{ds, http.MethodPut, fmt.Sprintf("/v2/fns/%s", f.ID), `{ "annotations": {"fnproject.io/fn/secrets": [{"ref": "/secret/data/fn/other/db#password", "env": "DB_PASSWORD"}]} }`, http.StatusBadRequest, models.ErrInvalidSecrets},
 	} {
 		test.run(t, i, buf)
 	}
@@ -4,6 +4,7 @@ package server
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"strconv"
 	"strings"
@@ -183,6 +184,19 @@ func apiMetricsWrap(s *Server) {
 	}
 }
 
+// requireAdminToken rejects requests without the admin token as bearer token, see
+// WithAdminToken
+func (s *Server) requireAdminToken(c *gin.Context) {
+	auth := c.GetHeader("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if s.adminToken == "" || token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		handleErrorResponse(c, errAdminUnauthorized)
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
 func panicWrap(c *gin.Context) {
 	defer func(c *gin.Context) {
 		if rec := recover(); rec != nil {
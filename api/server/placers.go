@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
+	"github.com/fnproject/fn/fnext"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// newPlacer returns a placer of the placement algorithm of the lb, see EnvLBPlacementAlg
+func (s *Server) newPlacer(cfg *pool.PlacerConfig) pool.Placer {
+	switch name := getEnv(EnvLBPlacementAlg, ""); name {
+	case "ch":
+		return pool.NewCHPlacer(cfg)
+	case "slot":
+		return pool.NewSlotHashPlacer(cfg)
+	case "load":
+		return pool.NewLoadPlacer(cfg)
+	case "ewma":
+		return pool.NewEWMAPlacer(cfg)
+	case "", "naive":
+		return pool.NewNaivePlacer(cfg)
+	default:
+		if factory, ok := s.placers[name]; ok {
+			// extensions are set up, eg. for placer simulations
+			return factory(cfg)
+		}
+		return s.newExtPlacer(name, cfg)
+	}
+}
+
+// AddPlacer implements fnext.ExtServer, the placer is used by lb nodes with FN_PLACER
+// set to name. Adding a placer with the name of a built-in placer has no effect.
+func (s *Server) AddPlacer(name string, factory fnext.PlacerFactory) {
+	if s.placers == nil {
+		s.placers = make(map[string]fnext.PlacerFactory)
+	}
+	s.placers[name] = factory
+}
+
+// extPlacer is a placer added by an extension. Extensions are set up after the lb agent
+// is created, so the placer is created once the server starts, see resolvePlacers.
+type extPlacer struct {
+	s    *Server
+	name string
+	cfg  *pool.PlacerConfig
+
+	once   sync.Once
+	placer pool.Placer
+}
+
+func (s *Server) newExtPlacer(name string, cfg *pool.PlacerConfig) pool.Placer {
+	p := &extPlacer{s: s, name: name, cfg: cfg}
+	s.extPlacers = append(s.extPlacers, p)
+	return p
+}
+
+// resolvePlacers creates the placers of the lb added by extensions, exiting if one was not
+// added by any extension
+func (s *Server) resolvePlacers() {
+	for _, p := range s.extPlacers {
+		p.get()
+	}
+}
+
+func (p *extPlacer) get() pool.Placer {
+	p.once.Do(func() {
+		factory, ok := p.s.placers[p.name]
+		if !ok {
+			logrus.Fatalf("Placer %v not added by any extension, check %s", p.name, EnvLBPlacementAlg)
+		}
+		p.placer = factory(p.cfg)
+	})
+	return p.placer
+}
+
+func (p *extPlacer) PlaceCall(ctx context.Context, rp pool.RunnerPool, call pool.RunnerCall) error {
+	return p.get().PlaceCall(ctx, rp, call)
+}
+
+func (p *extPlacer) GetPlacerConfig() pool.PlacerConfig {
+	return p.get().GetPlacerConfig()
+}
+
+// placerSimulation is the body of the placer simulation admin endpoint
+type placerSimulation struct {
+	// Runners to place the calls on, the runners of the pool of the lb if empty
+	Runners []pool.SimulatedRunner `json:"runners"`
+	// Slots of each runner of the pool, if Runners is empty. Zero is unlimited.
+	Slots int                   `json:"slots"`
+	Calls []pool.SimulatedCalls `json:"calls"`
+}
+
+// handlePlacerSimulate places the call mix in the request with a new placer configured as
+// the placers of the lb, without contacting any runner, and returns how the calls were
+// placed, see pool.Simulate
+func (s *Server) handlePlacerSimulate(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var sim placerSimulation
+	err := c.BindJSON(&sim)
+	if err != nil {
+		handleErrorResponse(c, models.ErrInvalidJSON)
+		return
+	}
+
+	if len(sim.Runners) == 0 {
+		list, err := s.runnerPoolList(c)
+		if err != nil {
+			handleErrorResponse(c, err)
+			return
+		}
+		for _, d := range list.Details {
+			sim.Runners = append(sim.Runners, pool.SimulatedRunner{Address: d.Address, Weight: d.Weight, Labels: d.Labels, Slots: sim.Slots})
+		}
+	}
+
+	cfg := *s.placerConfig
+	res, err := pool.Simulate(ctx, s.newPlacer(&cfg), sim.Runners, sim.Calls)
+	if err != nil {
+		handleErrorResponse(c, models.NewAPIError(http.StatusBadRequest, err))
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+var _ pool.Placer = &extPlacer{}
+var _ fnext.ExtServer = &Server{}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pool "github.com/fnproject/fn/api/runnerpool"
+	"github.com/gin-gonic/gin"
+)
+
+// testPlacer counts the calls placed through it
+type testPlacer struct {
+	cfg    pool.PlacerConfig
+	placed int
+}
+
+func (p *testPlacer) PlaceCall(ctx context.Context, rp pool.RunnerPool, call pool.RunnerCall) error {
+	p.placed++
+	return nil
+}
+
+func (p *testPlacer) GetPlacerConfig() pool.PlacerConfig {
+	return p.cfg
+}
+
+func TestAddPlacer(t *testing.T) {
+	s := &Server{}
+	cfg := pool.NewPlacerConfig()
+	cfg.SlotHashRunners = 7
+
+	// the lb agent is created before extensions add their placers
+	placer := s.newExtPlacer("custom", &cfg)
+	var created *testPlacer
+	s.AddPlacer("custom", func(cfg *pool.PlacerConfig) pool.Placer {
+		created = &testPlacer{cfg: *cfg}
+		return created
+	})
+	s.resolvePlacers()
+
+	if created == nil || placer.GetPlacerConfig().SlotHashRunners != 7 {
+		t.Fatalf("Expected the placer created with the placer config, got %+v", created)
+	}
+	err := placer.PlaceCall(context.Background(), nil, nil)
+	if err != nil || created.placed != 1 {
+		t.Fatalf("Expected the call placed by the added placer, got %v", err)
+	}
+}
+
+func TestPlacerSimulate(t *testing.T) {
+	cfg := pool.NewPlacerConfig()
+	s := &Server{placerConfig: &cfg, runnerPool: statusRunnerPool{
+		&statusRunner{addr: "192.0.2.1:9190"},
+		&statusRunner{addr: "192.0.2.2:9190"},
+	}}
+	router := gin.New()
+	router.POST("/placer/simulate", s.handlePlacerSimulate)
+
+	simulate := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/placer/simulate", bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the runners of the pool, which are not contacted
+	rec := simulate(`{"slots": 2, "calls": [{"fn_id": "fn1", "count": 5, "duration_ms": 1000}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected a simulation, got %d %s", rec.Code, rec.Body.String())
+	}
+	var res pool.SimulationResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Calls != 5 || res.Placed != 4 || res.Rejected != 1 || res.Runners["192.0.2.1:9190"] != 2 {
+		t.Fatalf("Unexpected simulation result %+v", res)
+	}
+
+	// runners in the request
+	rec = simulate(`{"runners": [{"address": "r1", "slots": 1}], "calls": [{"fn_id": "fn1", "count": 3, "interval_ms": 10, "duration_ms": 5}]}`)
+	res = pool.SimulationResult{}
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || res.Placed != 3 || res.Runners["r1"] != 3 {
+		t.Fatalf("Unexpected simulation result %d %+v", rec.Code, res)
+	}
+
+	for _, body := range []string{`{"calls": [{"count": 1}]}`, `not json`} {
+		if rec := simulate(body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("Expected a bad request for %s, got %d", body, rec.Code)
+		}
+	}
+}
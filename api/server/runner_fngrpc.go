@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+)
+
+// GRPCFnIDHeader is the header, ie. the gRPC metadata, of gRPC calls with the id of the fn
+// they call
+const GRPCFnIDHeader = "Fn-Id"
+
+// isGRPCRequest reports whether req is a gRPC call
+func isGRPCRequest(req *http.Request) bool {
+	return req.Method == http.MethodPost && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// checkProtocol returns models.ErrInvokeProtocol unless req is a gRPC call of an fn speaking
+// gRPC, or another call of an fn that does not
+func checkProtocol(req *http.Request, app *models.App, fn *models.Fn) error {
+	protocol, err := app.Annotations.MergeChange(fn.Annotations).Protocol()
+	if err != nil {
+		return err
+	}
+	if (protocol == models.ProtocolGRPC) != isGRPCRequest(req) {
+		return models.ErrInvokeProtocol
+	}
+	return nil
+}
+
+// handleFnGRPCCall executes a gRPC call of the fn of its GRPCFnIDHeader, whose path is the
+// method called, for requests matching no route
+func (s *Server) handleFnGRPCCall(c *gin.Context) {
+	ctx, _ := common.LoggerWithFields(c.Request.Context(), logrus.Fields{"fn_id": c.Request.Header.Get(GRPCFnIDHeader)})
+	c.Request = c.Request.WithContext(ctx)
+	err := s.handleFnGRPCCall2(c)
+	if err != nil {
+		writeGRPCError(c.Request.Context(), c.Writer, err)
+	}
+}
+
+func (s *Server) handleFnGRPCCall2(c *gin.Context) error {
+	// the runners of an lb cannot send the trailers of gRPC responses back yet
+	if s.nodeType != ServerTypeFull {
+		return models.ErrInvokeGRPCUnsupported
+	}
+	fnID := c.Request.Header.Get(GRPCFnIDHeader)
+	if fnID == "" {
+		return models.ErrMissingFnID
+	}
+
+	ctx := c.Request.Context()
+	fn, err := s.lbReadAccess.GetFnByID(ctx, fnID)
+	if err != nil {
+		return err
+	}
+	app, err := s.lbReadAccess.GetAppByID(ctx, fn.AppID)
+	if err != nil {
+		return err
+	}
+	return s.fnInvoke(c.Writer, c.Request, app, fn, nil)
+}
+
+// writeGRPCError writes err as a gRPC response without messages, whose status gRPC clients
+// read from its headers
+func writeGRPCError(ctx context.Context, w http.ResponseWriter, err error) {
+	code := codes.Internal
+	msg := ErrInternalServerError.Error()
+	if ctx.Err() == context.Canceled {
+		code, msg = codes.Canceled, models.ErrClientCancel.Error()
+	} else if e, ok := err.(models.APIError); ok {
+		code, msg = grpcCode(e.Code()), e.Error()
+	} else {
+		common.Logger(ctx).WithError(err).Error("internal server error")
+	}
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(code)))
+	w.Header().Set("Grpc-Message", grpcMessage(msg))
+	w.WriteHeader(http.StatusOK)
+}
+
+// grpcCode returns the gRPC code of the http status of an api error
+func grpcCode(status int) codes.Code {
+	switch status {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	return codes.Internal
+}
+
+// grpcMessage percent encodes msg for the Grpc-Message header
+func grpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
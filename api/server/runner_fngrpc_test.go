@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fnproject/fn/api/models"
+	"google.golang.org/grpc/codes"
+)
+
+func TestGRPCProtocol(t *testing.T) {
+	grpcReq, _ := http.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+	grpcReq.Header.Set("Content-Type", "application/grpc+proto")
+	httpReq, _ := http.NewRequest("POST", "/invoke/fn_id", nil)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	app := &models.App{ID: "app_id"}
+	httpFn := &models.Fn{ID: "fn_id"}
+	grpcFn := &models.Fn{ID: "fn_id"}
+	grpcFn.Annotations, _ = models.EmptyAnnotations().With(models.ProtocolAnnotation, models.ProtocolGRPC)
+
+	if !isGRPCRequest(grpcReq) || isGRPCRequest(httpReq) {
+		t.Fatal("expected only the gRPC request to be a gRPC call")
+	}
+	for _, tc := range []struct {
+		req *http.Request
+		fn  *models.Fn
+		err error
+	}{
+		{grpcReq, grpcFn, nil},
+		{httpReq, httpFn, nil},
+		{grpcReq, httpFn, models.ErrInvokeProtocol},
+		{httpReq, grpcFn, models.ErrInvokeProtocol},
+	} {
+		if err := checkProtocol(tc.req, app, tc.fn); err != tc.err {
+			t.Fatalf("expected %v for %s, got %v", tc.err, tc.req.URL, err)
+		}
+	}
+}
+
+func TestWriteGRPCError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeGRPCError(context.Background(), rec, models.ErrCallTimeoutServerBusy)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/grpc" {
+		t.Fatalf("expected a gRPC response, got %d %v", rec.Code, rec.Header())
+	}
+	if rec.Header().Get("Grpc-Status") != "14" || rec.Header().Get("Grpc-Message") != "Timed out - server too busy" {
+		t.Fatalf("expected an unavailable status, got %v", rec.Header())
+	}
+
+	if grpcCode(http.StatusGatewayTimeout) != codes.DeadlineExceeded || grpcCode(http.StatusTeapot) != codes.Internal {
+		t.Fatal("unexpected gRPC codes")
+	}
+	if msg := grpcMessage("100% done\n"); msg != "100%25 done%0A" {
+		t.Fatalf("unexpected encoded message %q", msg)
+	}
+}
@@ -2,15 +2,18 @@ package server
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/fnproject/fn/api"
 	"github.com/fnproject/fn/api/agent"
 	"github.com/fnproject/fn/api/common"
 	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	"go.opencensus.io/tag"
@@ -43,6 +46,86 @@ func (s *syncResponseWriter) Header() http.Header  { return s.headers }
 func (s *syncResponseWriter) WriteHeader(code int) { s.status = code }
 func (s *syncResponseWriter) Status() int          { return s.status }
 
+// streamResponseWriter buffers responses like syncResponseWriter, unless the fn responds
+// with a streaming content type (see agent.IsStreamingContentType), eg. server-sent
+// events of a model producing tokens. Those are written through to the client, flushing
+// every write, which means their status and headers are sent before the fn is done.
+type streamResponseWriter struct {
+	*syncResponseWriter
+
+	mtx  sync.Mutex
+	resp http.ResponseWriter
+	// streaming is set once the status of a streaming response was sent to resp
+	streaming bool
+	// closed is set once the call returned, resp must not be written to after that
+	closed bool
+}
+
+var _ http.ResponseWriter = new(streamResponseWriter)
+var _ http.Flusher = new(streamResponseWriter)
+
+func newStreamResponseWriter(resp http.ResponseWriter, buf *bytes.Buffer) *streamResponseWriter {
+	return &streamResponseWriter{
+		syncResponseWriter: &syncResponseWriter{
+			headers: resp.Header(),
+			status:  200,
+			Buffer:  buf,
+		},
+		resp: resp,
+	}
+}
+
+func (s *streamResponseWriter) WriteHeader(code int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.streaming || s.closed {
+		return
+	}
+	s.status = code
+	if agent.IsStreamingContentType(s.headers.Get("Content-Type")) {
+		s.streaming = true
+		s.resp.WriteHeader(code)
+		s.flush()
+	}
+}
+
+func (s *streamResponseWriter) Write(b []byte) (int, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.streaming {
+		return s.Buffer.Write(b)
+	}
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := s.resp.Write(b)
+	s.flush()
+	return n, err
+}
+
+// Flush implements http.Flusher, it only flushes streaming responses
+func (s *streamResponseWriter) Flush() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.streaming && !s.closed {
+		s.flush()
+	}
+}
+
+func (s *streamResponseWriter) flush() {
+	if f, ok := s.resp.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close stops writes to the client, it returns whether the response was streamed
+func (s *streamResponseWriter) close() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.closed = true
+	return s.streaming
+}
+
 // handleFnInvokeCall executes the function, for router handlers
 func (s *Server) handleFnInvokeCall(c *gin.Context) {
 	fnID := c.Param(api.FnID)
@@ -58,6 +141,10 @@ func (s *Server) handleFnInvokeCall(c *gin.Context) {
 // Requires the following in the context:
 func (s *Server) handleFnInvokeCall2(c *gin.Context) error {
 	ctx := c.Request.Context()
+	// fns are invoked with a GET only to upgrade to a stream call
+	if c.Request.Method == http.MethodGet && !isWebSocketUpgrade(c.Request) {
+		return models.ErrMethodNotAllowed
+	}
 	fn, err := s.lbReadAccess.GetFnByID(ctx, c.Param(api.FnID))
 	if err != nil {
 		return err
@@ -84,25 +171,34 @@ func (s *Server) handleFnInvokeCall2(c *gin.Context) error {
 }
 
 func (s *Server) ServeFnInvoke(c *gin.Context, app *models.App, fn *models.Fn) error {
+	if isWebSocketUpgrade(c.Request) {
+		return s.fnInvokeStream(c.Writer, c.Request, app, fn, nil)
+	}
 	return s.fnInvoke(c.Writer, c.Request, app, fn, nil)
 }
 
 func (s *Server) fnInvoke(resp http.ResponseWriter, req *http.Request, app *models.App, fn *models.Fn, trig *models.Trigger) error {
-	// TODO: we should get rid of the buffers, and stream back (saves memory (+splice), faster (splice), allows streaming, don't have to cap resp size)
-	// buffer the response before writing it out to client to prevent partials from trying to stream
+	if err := checkProtocol(req, app, fn); err != nil {
+		return err
+	}
+	if priority := req.Header.Get(models.CallPriorityHeader); priority != "" && !models.ValidCallPriority(priority) {
+		return models.ErrInvalidCallPriorityHeader
+	}
+
+	// TODO: we should get rid of the buffers, and stream back (saves memory (+splice), faster (splice), don't have to cap resp size)
+	// buffer the response before writing it out to client to prevent partials from trying to stream,
+	// unless the fn streams its response, see streamResponseWriter
 	buf := bufPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	var writer ResponseBuffer
+	var stream *streamResponseWriter
 
 	isDetached := req.Header.Get("Fn-Invoke-Type") == models.TypeDetached
 	if isDetached {
 		writer = agent.NewDetachedResponseWriter(resp.Header(), 202)
 	} else {
-		writer = &syncResponseWriter{
-			headers: resp.Header(),
-			status:  200,
-			Buffer:  buf,
-		}
+		stream = newStreamResponseWriter(resp, buf)
+		writer = stream
 	}
 	opts := getCallOptions(req, app, fn, trig, writer)
 
@@ -115,7 +211,19 @@ func (s *Server) fnInvoke(resp http.ResponseWriter, req *http.Request, app *mode
 	writer.Header().Add("Fn-Call-Id", call.Model().ID)
 
 	err = s.agent.Submit(call)
+	if stream != nil && stream.close() {
+		// the status and part of the response already went out, errors cannot be sent
+		if err != nil {
+			common.Logger(req.Context()).WithError(err).Info("streamed fn response failed")
+		}
+		return nil
+	}
 	if err != nil {
+		if bp, ok := s.agent.(agent.Backpressure); ok && models.IsTooBusy(err) {
+			if after := bp.RetryAfter(); after > 0 {
+				resp.Header().Set("Retry-After", strconv.Itoa(int(after/time.Second)))
+			}
+		}
 		return err
 	}
 
@@ -136,6 +244,36 @@ func (s *Server) fnInvoke(resp http.ResponseWriter, req *http.Request, app *mode
 	return nil
 }
 
+// handleFnCallResult returns the result of a detached call, see agent.CallResults. The
+// result is the response of the fn, or the error of the call, once the call is done.
+// Until then it is an empty 202.
+func (s *Server) handleFnCallResult(c *gin.Context) {
+	ctx := c.Request.Context()
+	result, err := s.agent.(agent.CallResults).CallResult(ctx, c.Param(api.FnID), c.Param(api.CallID))
+	if err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+
+	c.Header("Fn-Call-Id", result.CallID)
+	switch {
+	case !result.Done:
+		c.Header(agent.CallResultStatusHeader, "running")
+		c.Status(http.StatusAccepted)
+	case result.Error != "":
+		c.Header(agent.CallResultStatusHeader, "error")
+		WriteError(ctx, c.Writer, result.Status, errors.New(result.Error))
+	default:
+		for k, vs := range result.Header {
+			c.Writer.Header()[k] = vs
+		}
+		c.Header(agent.CallResultStatusHeader, "success")
+		c.Header("Content-Length", strconv.Itoa(len(result.Body)))
+		c.Writer.WriteHeader(result.Status)
+		c.Writer.Write(result.Body)
+	}
+}
+
 func getCallOptions(req *http.Request, app *models.App, fn *models.Fn, trig *models.Trigger, rw http.ResponseWriter) []agent.CallOpt {
 	var opts []agent.CallOpt
 	opts = append(opts, agent.WithWriter(rw)) // XXX (reed): order matters [for now]
@@ -148,5 +286,9 @@ func getCallOptions(req *http.Request, app *models.App, fn *models.Fn, trig *mod
 	if trig != nil {
 		opts = append(opts, agent.WithTrigger(trig))
 	}
+	// the header of invoke requests replaces the priority of the trigger
+	if priority := req.Header.Get(models.CallPriorityHeader); priority != "" {
+		opts = append(opts, agent.WithPriority(pool.CallPriority(priority)))
+	}
 	return opts
 }
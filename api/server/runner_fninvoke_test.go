@@ -1,16 +1,22 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/fnproject/fn/api/agent"
 	"github.com/fnproject/fn/api/datastore"
 	"github.com/fnproject/fn/api/models"
+	"github.com/gin-gonic/gin"
 )
 
 func TestBadRequests(t *testing.T) {
@@ -212,6 +218,8 @@ func TestFnInvokeRunnerExecution(t *testing.T) {
 		{"/invoke/http_stream_fn_id", bighdroutput, nil, http.MethodPost, http.StatusBadGateway, nil, models.ErrFunctionResponseHdrTooBig.Error(), nil},
 		{"/invoke/http_stream_fn_id", striphdr, nil, http.MethodPost, http.StatusOK, expStripHeaders, "", nil},
 		{"/invoke/http_stream_fn_id", striphdrin, inStripHeaders, http.MethodPost, http.StatusOK, nil, "", nil},
+		{"/invoke/http_stream_fn_id", ok, map[string][]string{models.CallPriorityHeader: {models.CallPriorityBatch}}, http.MethodPost, http.StatusOK, expHeaders, "", nil},
+		{"/invoke/http_stream_fn_id", ok, map[string][]string{models.CallPriorityHeader: {"urgent"}}, http.MethodPost, http.StatusBadRequest, nil, models.ErrInvalidCallPriorityHeader.Error(), nil},
 		{"/invoke/http_stream_fn_id", bigoutput, nil, http.MethodPost, http.StatusBadGateway, nil, models.ErrFunctionResponseTooBig.Error(), nil},
 		{"/invoke/http_stream_fn_id", smalloutput, nil, http.MethodPost, http.StatusOK, expHeaders, "", nil},
 		// XXX(reed): nil, meh we really should try to get oom out, but maybe it's better left to the logs?
@@ -227,6 +235,7 @@ func TestFnInvokeRunnerExecution(t *testing.T) {
 		{"/invoke/fail_fn_quick", ok, nil, http.MethodPost, http.StatusBadGateway, nil, models.ErrContainerInitFail.Error(), nil},
 		{"/invoke/fail_fn_timeout", ok, nil, http.MethodPost, http.StatusGatewayTimeout, nil, models.ErrContainerInitTimeout.Error(), nil},
 		{"/invoke/fn_id", ok, nil, http.MethodPut, http.StatusMethodNotAllowed, nil, models.ErrMethodNotAllowed.Error(), nil},
+		{"/invoke/fn_id", ok, nil, http.MethodGet, http.StatusMethodNotAllowed, nil, models.ErrMethodNotAllowed.Error(), nil},
 
 		{"/invoke/bigmem", ok, nil, http.MethodPost, http.StatusBadRequest, nil, "cannot be allocated", nil},
 	}
@@ -441,3 +450,123 @@ func TestInvokeRunnerMinimalConcurrentHotSync(t *testing.T) {
 		}
 	}
 }
+
+func TestStreamResponseWriter(t *testing.T) {
+	// buffered until the call returns
+	rec := httptest.NewRecorder()
+	w := newStreamResponseWriter(rec, new(bytes.Buffer))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("{}"))
+	if rec.Body.Len() != 0 || rec.Flushed {
+		t.Fatalf("expected a buffered response, got %q", rec.Body.String())
+	}
+	if w.close() {
+		t.Fatal("expected the response not to be streamed")
+	}
+	if w.Buffer.String() != "{}" {
+		t.Fatalf("unexpected buffered response %q", w.Buffer.String())
+	}
+
+	// streamed as written
+	rec = httptest.NewRecorder()
+	w = newStreamResponseWriter(rec, new(bytes.Buffer))
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	if !rec.Flushed || rec.Code != http.StatusOK {
+		t.Fatalf("expected the status to be flushed, got %d", rec.Code)
+	}
+	w.Write([]byte("data: one\n\n"))
+	if rec.Body.String() != "data: one\n\n" {
+		t.Fatalf("expected a streamed response, got %q", rec.Body.String())
+	}
+	if !w.close() {
+		t.Fatal("expected the response to be streamed")
+	}
+	if _, err := w.Write([]byte("data: two\n\n")); err == nil {
+		t.Fatal("expected writes after close to fail")
+	}
+	if rec.Body.String() != "data: one\n\n" || w.Buffer.Len() != 0 {
+		t.Fatalf("unexpected response %q", rec.Body.String())
+	}
+}
+
+// resultsAgent is an agent that only keeps call results
+type resultsAgent struct {
+	agent.Agent
+	results agent.CallResultStore
+}
+
+func (a *resultsAgent) CallResult(ctx context.Context, fnID, callID string) (*agent.CallResult, error) {
+	return a.results.GetCallResult(ctx, fnID, callID)
+}
+
+func (a *resultsAgent) KeepsCallResults() bool {
+	return a.results != nil
+}
+
+func TestFnCallResult(t *testing.T) {
+	ctx := context.Background()
+	store := agent.NewMemoryCallResultStore(10, time.Minute)
+	store.PutCallResult(ctx, &agent.CallResult{CallID: "running", FnID: "fn_id"})
+	store.PutCallResult(ctx, &agent.CallResult{CallID: "success", FnID: "fn_id", Done: true,
+		Status: http.StatusCreated, Header: http.Header{"Content-Type": {"text/plain"}}, Body: []byte("hello")})
+	store.PutCallResult(ctx, &agent.CallResult{CallID: "error", FnID: "fn_id", Done: true,
+		Status: http.StatusBadGateway, Error: models.ErrFunctionResponse.Error()})
+
+	s := &Server{agent: &resultsAgent{results: store}}
+	router := gin.New()
+	router.GET("/invoke/:fn_id/calls/:call_id", s.handleFnCallResult)
+
+	for _, test := range []struct {
+		path   string
+		status int
+		call   string
+		body   string
+	}{
+		{"/invoke/fn_id/calls/running", http.StatusAccepted, "running", ""},
+		{"/invoke/fn_id/calls/success", http.StatusCreated, "success", "hello"},
+		{"/invoke/fn_id/calls/error", http.StatusBadGateway, "error", models.ErrFunctionResponse.Error()},
+		{"/invoke/fn_id/calls/unknown", http.StatusNotFound, "", models.ErrCallNotFound.Error()},
+		{"/invoke/other_fn/calls/success", http.StatusNotFound, "", models.ErrCallNotFound.Error()},
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.status || !strings.Contains(rec.Body.String(), test.body) {
+			t.Fatalf("Expected %d %q for %s, got %d %q", test.status, test.body, test.path, rec.Code, rec.Body.String())
+		}
+		if status := rec.Header().Get(agent.CallResultStatusHeader); status != test.call {
+			t.Fatalf("Expected call status %q for %s, got %q", test.call, test.path, status)
+		}
+	}
+}
+
+// The call result route is only there if the agent keeps call results
+func TestFnCallResultRoute(t *testing.T) {
+	ctx := context.Background()
+	store := agent.NewMemoryCallResultStore(10, time.Minute)
+	store.PutCallResult(ctx, &agent.CallResult{CallID: "running", FnID: "fn_id"})
+
+	for _, test := range []struct {
+		results agent.CallResultStore
+		status  int
+	}{
+		{nil, http.StatusNotFound},
+		{store, http.StatusAccepted},
+	} {
+		s := &Server{
+			agent:              &resultsAgent{results: test.results},
+			Router:             gin.New(),
+			AdminRouter:        gin.New(),
+			nodeType:           ServerTypeLB,
+			noProfilerEndpoint: true,
+		}
+		s.bindHandlers(ctx)
+
+		rec := httptest.NewRecorder()
+		s.Router.ServeHTTP(rec, httptest.NewRequest("GET", "/invoke/fn_id/calls/running", nil))
+		if rec.Code != test.status {
+			t.Fatalf("Expected %d with results=%v, got %d %q", test.status, test.results != nil, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/fnproject/fn/api/agent"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	"golang.org/x/net/websocket"
+)
+
+// the headers of a WebSocket upgrade, which are not passed to fns
+var webSocketHeaders = []string{
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Extensions",
+	"Sec-Websocket-Protocol",
+}
+
+// isWebSocketUpgrade reports whether req asks to upgrade its connection to a WebSocket
+func isWebSocketUpgrade(req *http.Request) bool {
+	return strings.EqualFold(req.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade")
+}
+
+// wsResponseWriter sends each write of the response of a stream call as a binary message
+// of a WebSocket. The status and headers of the response are not sent, the upgrade of the
+// connection already answered the request.
+type wsResponseWriter struct {
+	headers http.Header
+	ws      *websocket.Conn
+
+	mtx sync.Mutex
+	// closed is set once the call returned, ws must not be written to after that
+	closed bool
+}
+
+var _ http.ResponseWriter = new(wsResponseWriter)
+
+func (w *wsResponseWriter) Header() http.Header { return w.headers }
+func (w *wsResponseWriter) WriteHeader(int)     {}
+
+func (w *wsResponseWriter) Write(b []byte) (int, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if err := websocket.Message.Send(w.ws, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *wsResponseWriter) close() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	w.closed = true
+}
+
+// fnInvokeStream upgrades the connection of req to a WebSocket and invokes fn as a stream
+// call for as long as both ends keep it open, see agent.InvokeStream. The messages of the
+// client are the request body of the call, and each write of the fn is sent as a message
+// to the client. Errors once the connection was upgraded are logged, and close it.
+func (s *Server) fnInvokeStream(resp http.ResponseWriter, req *http.Request, app *models.App, fn *models.Fn, trig *models.Trigger) error {
+	if _, ok := resp.(http.Hijacker); !ok {
+		return models.ErrInvokeStreamUnsupported
+	}
+	if err := checkProtocol(req, app, fn); err != nil {
+		return err
+	}
+
+	body, bodyW := io.Pipe()
+	callReq := req.Clone(req.Context())
+	callReq.Body = body
+	for _, h := range webSocketHeaders {
+		callReq.Header.Del(h)
+	}
+	callReq.Header.Set("Fn-Invoke-Type", models.TypeStream)
+
+	writer := &wsResponseWriter{headers: make(http.Header)}
+	opts := append(getCallOptions(callReq, app, fn, trig, writer), agent.InvokeStream())
+	call, err := s.agent.GetCall(opts...)
+	if err != nil {
+		body.Close()
+		return err
+	}
+
+	websocket.Server{
+		// fns check the origin of their callers if they need to, from the call headers
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			ws.PayloadType = websocket.BinaryFrame
+			writer.ws = ws
+			go pumpMessages(ws, bodyW)
+
+			err := s.agent.Submit(call)
+			writer.close()
+			// unblocks pumpMessages if the fn stopped reading
+			body.Close()
+			if err != nil {
+				common.Logger(req.Context()).WithError(err).Info("stream call failed")
+			}
+		},
+	}.ServeHTTP(resp, req)
+	return nil
+}
+
+// pumpMessages writes the messages of ws to w until either is closed, which ends the
+// request body of the call
+func pumpMessages(ws *websocket.Conn, w *io.PipeWriter) {
+	defer w.Close()
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return
+		}
+		// an empty write would end the request body
+		if len(msg) == 0 {
+			continue
+		}
+		if _, err := w.Write(msg); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/invoke/fn_id", nil)
+	if isWebSocketUpgrade(req) {
+		t.Fatal("expected a plain request not to be an upgrade")
+	}
+	req.Header.Set("Upgrade", "WebSocket")
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	if !isWebSocketUpgrade(req) {
+		t.Fatal("expected a WebSocket upgrade")
+	}
+	req.Header.Set("Upgrade", "h2c")
+	if isWebSocketUpgrade(req) {
+		t.Fatal("expected an h2c upgrade not to be a WebSocket upgrade")
+	}
+}
+
+func TestWebSocketStreamCall(t *testing.T) {
+	body := make(chan string, 1)
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		r, w := io.Pipe()
+		go pumpMessages(ws, w)
+
+		// echo the first message back as a response, an fn would stream instead
+		buf := make([]byte, 3)
+		io.ReadFull(r, buf)
+		writer := &wsResponseWriter{headers: make(http.Header), ws: ws}
+		writer.Write(buf)
+		writer.close()
+		if _, err := writer.Write([]byte("late")); err == nil {
+			t.Error("expected writes after close to fail")
+		}
+
+		// the body ends once the client closes
+		rest, _ := ioutil.ReadAll(r)
+		body <- string(buf) + string(rest)
+	}))
+	defer srv.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), "", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"one", "", "two"} {
+		if err := websocket.Message.Send(ws, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var resp []byte
+	if err := websocket.Message.Receive(ws, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "one" {
+		t.Fatalf("expected the response as a message, got %q", resp)
+	}
+	ws.Close()
+
+	if b := <-body; b != "onetwo" {
+		t.Fatalf("expected the messages as the request body, got %q", b)
+	}
+}
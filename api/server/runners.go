@@ -0,0 +1,321 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fnproject/fn/api"
+	"github.com/fnproject/fn/api/agent"
+	"github.com/fnproject/fn/api/common"
+	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
+	"github.com/gin-gonic/gin"
+)
+
+var errRunnerNotFound = models.NewAPIError(http.StatusNotFound, errors.New("Runner not found"))
+var errRunnerNotDrainable = models.NewAPIError(http.StatusBadRequest, errors.New("Runner cannot be drained"))
+var errAdminUnauthorized = models.NewAPIError(http.StatusUnauthorized, errors.New("Invalid or missing admin token"))
+
+// runnerStatusTimeout is how long the pool status endpoint waits for the status of runners
+const runnerStatusTimeout = 5 * time.Second
+
+// runnerList is the body of the runner pool admin endpoints
+type runnerList struct {
+	Runners []string `json:"runners"`
+	// state of the runners, only in responses
+	Details []runnerDetails `json:"details,omitempty"`
+}
+
+// runnerDetails is the state of a runner of the pool
+type runnerDetails struct {
+	Address       string `json:"address"`
+	Weight        int    `json:"weight"`
+	Ready         bool   `json:"ready"`
+	Draining      bool   `json:"draining"`
+	CallsInFlight int    `json:"calls_in_flight"`
+	// Labels are matched against the runner constraints of fns
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// runnerPoolList returns the runners of the pool along with their details
+func (s *Server) runnerPoolList(c *gin.Context) (*runnerList, error) {
+	runners, err := s.runnerPool.Runners(c.Request.Context(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &runnerList{Runners: []string{}, Details: []runnerDetails{}}
+	if rp, ok := s.runnerPool.(pool.RunnerPoolUpdater); ok {
+		list.Runners = rp.RunnerAddresses()
+	}
+	for _, r := range runners {
+		details := runnerDetails{Address: r.Address(), Weight: pool.RunnerWeight(r), Ready: r.Ready()}
+		if d, ok := r.(pool.DrainableRunner); ok {
+			details.Draining = d.IsDraining()
+			details.CallsInFlight = d.CallsInFlight()
+		}
+		if l, ok := r.(pool.LabeledRunner); ok {
+			details.Labels = l.Labels()
+		}
+		list.Details = append(list.Details, details)
+	}
+	return list, nil
+}
+
+func (s *Server) handleRunnersGet(c *gin.Context) {
+	list, err := s.runnerPoolList(c)
+	if err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// handleRunnersUpdate replaces the runners of the lb with the runners in the request
+func (s *Server) handleRunnersUpdate(c *gin.Context) {
+	ctx := c.Request.Context()
+	rp := s.runnerPool.(pool.RunnerPoolUpdater)
+
+	var runners runnerList
+	err := c.BindJSON(&runners)
+	if err != nil {
+		handleErrorResponse(c, models.ErrInvalidJSON)
+		return
+	}
+
+	err = rp.SetRunners(ctx, runners.Runners)
+	if err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+
+	s.handleRunnersGet(c)
+}
+
+// handleRunnerDrain drains the runner with the address in the path, or resumes placing
+// calls on it for DELETE requests
+func (s *Server) handleRunnerDrain(c *gin.Context) {
+	runners, err := s.runnerPool.Runners(c.Request.Context(), nil)
+	if err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+
+	addr := c.Param("runner_addr")
+	for _, r := range runners {
+		if r.Address() != addr {
+			continue
+		}
+		d, ok := r.(pool.DrainableRunner)
+		if !ok {
+			handleErrorResponse(c, errRunnerNotDrainable)
+			return
+		}
+		d.Drain(c.Request.Method != http.MethodDelete)
+		s.handleRunnersGet(c)
+		return
+	}
+	handleErrorResponse(c, errRunnerNotFound)
+}
+
+// handleDrainGet returns the drain status of the agent, see agent.Drainer. Orchestration
+// systems poll it until the agent is drained before stopping it.
+func (s *Server) handleDrainGet(c *gin.Context) {
+	c.JSON(http.StatusOK, s.agent.(agent.Drainer).DrainStatus())
+}
+
+// handleDrain drains the agent, or resumes taking calls for DELETE requests, and returns
+// its drain status
+func (s *Server) handleDrain(c *gin.Context) {
+	d := s.agent.(agent.Drainer)
+	d.Drain(c.Request.Method != http.MethodDelete)
+	c.JSON(http.StatusOK, d.DrainStatus())
+}
+
+// capacityList is the body of the capacity admin endpoint
+type capacityList struct {
+	Pools []agent.CapacitySignal `json:"pools"`
+}
+
+// handleCapacityGet returns the desired capacity of the runner pools of the lb
+func (s *Server) handleCapacityGet(c *gin.Context) {
+	signals := s.agent.(agent.CapacitySignaler).CapacitySignals()
+	if signals == nil {
+		signals = []agent.CapacitySignal{}
+	}
+	c.JSON(http.StatusOK, &capacityList{Pools: signals})
+}
+
+// prePullList is the body of the pre-pull admin endpoint
+type prePullList struct {
+	Results []agent.PrePullResult `json:"results"`
+}
+
+// handleFnPrePull pulls the images of the fn in the path on the runners that may run its
+// calls, see agent.ImagePrePuller. Runners that could not pull an image are listed with
+// the error, the request does not fail for them.
+func (s *Server) handleFnPrePull(c *gin.Context) {
+	ctx := c.Request.Context()
+	fn, err := s.lbReadAccess.GetFnByID(ctx, c.Param(api.FnID))
+	if err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+	app, err := s.lbReadAccess.GetAppByID(ctx, fn.AppID)
+	if err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+
+	results := s.agent.(agent.ImagePrePuller).PrePullImages(ctx, app, fn)
+	if results == nil {
+		results = []agent.PrePullResult{}
+	}
+	c.JSON(http.StatusOK, &prePullList{Results: results})
+}
+
+// defaultProfileSeconds is how long CPU profiles of the profile admin endpoint last by default
+const defaultProfileSeconds = 30
+
+// handleFnProfile returns a profile of a running hot container of the fn in the path, see
+// agent.ContainerProfiler. The type query parameter selects a cpu (the default) or heap
+// profile, and seconds how long CPU profiles last. The runner and the container profiled
+// are in the Fn-Runner-Address and Fn-Container-Id headers.
+func (s *Server) handleFnProfile(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := agent.ProfileRequest{Type: c.DefaultQuery("type", agent.ProfileCPU), Seconds: defaultProfileSeconds}
+	if seconds := c.Query("seconds"); seconds != "" {
+		n, err := strconv.Atoi(seconds)
+		if err != nil {
+			handleErrorResponse(c, agent.ErrInvalidProfileSeconds)
+			return
+		}
+		req.Seconds = n
+	}
+	if err := req.Validate(); err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+
+	fn, err := s.lbReadAccess.GetFnByID(ctx, c.Param(api.FnID))
+	if err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+	app, err := s.lbReadAccess.GetAppByID(ctx, fn.AppID)
+	if err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+
+	prof, err := s.agent.(agent.ContainerProfiler).ProfileContainer(ctx, app, fn, req)
+	if err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+	if prof.Address != "" {
+		c.Header("Fn-Runner-Address", prof.Address)
+	}
+	c.Header("Fn-Container-Id", prof.ContainerID)
+	c.Data(http.StatusOK, prof.ContentType, prof.Data)
+}
+
+// poolStatus is the body of the pool status endpoint, the status of every runner of the
+// pool along with totals over the runners
+type poolStatus struct {
+	Runners          int            `json:"runners"`
+	ActiveRequests   int64          `json:"active_requests"`
+	RequestsReceived uint64         `json:"requests_received"`
+	RequestsHandled  uint64         `json:"requests_handled"`
+	FailedStatuses   int            `json:"failed_statuses"`
+	NetworkDisabled  int            `json:"network_disabled"`
+	Details          []runnerStatus `json:"details"`
+}
+
+// runnerStatus is the status of a runner of the pool, see pool.RunnerStatus
+type runnerStatus struct {
+	Address            string          `json:"address"`
+	ActiveRequestCount int32           `json:"active_request_count"`
+	RequestsReceived   uint64          `json:"requests_received"`
+	RequestsHandled    uint64          `json:"requests_handled"`
+	KdumpsOnDisk       uint64          `json:"kdumps_on_disk"`
+	StatusFailed       bool            `json:"status_failed"`
+	Cached             bool            `json:"cached"`
+	ErrorCode          int32           `json:"error_code,omitempty"`
+	Error              string          `json:"error,omitempty"`
+	NetworkDisabled    bool            `json:"network_disabled"`
+	Cordoned           bool            `json:"cordoned"`
+	Draining           bool            `json:"draining"`
+	CompletedAt        common.DateTime `json:"completed_at"`
+}
+
+func newRunnerStatus(addr string, status *pool.RunnerStatus, err error) runnerStatus {
+	if err != nil {
+		return runnerStatus{Address: addr, StatusFailed: true, Error: err.Error()}
+	}
+	if status == nil {
+		return runnerStatus{Address: addr, StatusFailed: true, Error: "Runner returned no status"}
+	}
+	return runnerStatus{
+		Address:            addr,
+		ActiveRequestCount: status.ActiveRequestCount,
+		RequestsReceived:   status.RequestsReceived,
+		RequestsHandled:    status.RequestsHandled,
+		KdumpsOnDisk:       status.KdumpsOnDisk,
+		StatusFailed:       status.StatusFailed,
+		Cached:             status.Cached,
+		ErrorCode:          status.ErrorCode,
+		Error:              status.ErrorStr,
+		NetworkDisabled:    status.IsNetworkDisabled,
+		Cordoned:           status.IsCordoned,
+		Draining:           status.IsDraining,
+		CompletedAt:        status.CompletedAt,
+	}
+}
+
+// handleRunnerPoolStatus returns the status of all runners of the lb, queried in parallel.
+// With refresh=true runners are queried even if their status is cached.
+func (s *Server) handleRunnerPoolStatus(c *gin.Context) {
+	ctx := c.Request.Context()
+	runners, err := s.runnerPool.Runners(ctx, nil)
+	if err != nil {
+		handleErrorResponse(c, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, runnerStatusTimeout)
+	defer cancel()
+	if refresh, _ := strconv.ParseBool(c.Query("refresh")); refresh {
+		ctx = pool.WithStatusRefresh(ctx)
+	}
+
+	details := make([]runnerStatus, len(runners))
+	done := make(chan struct{}, len(runners))
+	for i, r := range runners {
+		go func(i int, r pool.Runner) {
+			status, err := r.Status(ctx)
+			details[i] = newRunnerStatus(r.Address(), status, err)
+			done <- struct{}{}
+		}(i, r)
+	}
+	for range runners {
+		<-done
+	}
+
+	status := poolStatus{Runners: len(runners), Details: details}
+	for _, d := range details {
+		status.ActiveRequests += int64(d.ActiveRequestCount)
+		status.RequestsReceived += d.RequestsReceived
+		status.RequestsHandled += d.RequestsHandled
+		if d.StatusFailed {
+			status.FailedStatuses++
+		}
+		if d.NetworkDisabled {
+			status.NetworkDisabled++
+		}
+	}
+	c.JSON(http.StatusOK, &status)
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fnproject/fn/api/agent"
+	"github.com/fnproject/fn/api/datastore"
+	"github.com/fnproject/fn/api/models"
+	pool "github.com/fnproject/fn/api/runnerpool"
+	"github.com/gin-gonic/gin"
+)
+
+// statusRunner is a runner that only reports a status
+type statusRunner struct {
+	addr   string
+	status *pool.RunnerStatus
+	err    error
+}
+
+func (r *statusRunner) Status(ctx context.Context) (*pool.RunnerStatus, error) {
+	return r.status, r.err
+}
+func (r *statusRunner) TryExec(ctx context.Context, call pool.RunnerCall) (bool, error) {
+	return false, nil
+}
+func (r *statusRunner) Close(ctx context.Context) error { return nil }
+func (r *statusRunner) Address() string                 { return r.addr }
+func (r *statusRunner) Ready() bool                     { return true }
+
+// statusRunnerPool is a runner pool of fixed runners
+type statusRunnerPool []pool.Runner
+
+func (rp statusRunnerPool) Runners(ctx context.Context, call pool.RunnerCall) ([]pool.Runner, error) {
+	return rp, nil
+}
+func (rp statusRunnerPool) Shutdown(ctx context.Context) error { return nil }
+
+func TestRunnerPoolStatus(t *testing.T) {
+	s := &Server{adminToken: "secret", runnerPool: statusRunnerPool{
+		&statusRunner{addr: "192.0.2.1:9190", status: &pool.RunnerStatus{ActiveRequestCount: 2, RequestsReceived: 10, RequestsHandled: 9, Cached: true}},
+		&statusRunner{addr: "192.0.2.2:9190", status: &pool.RunnerStatus{ActiveRequestCount: 1, IsNetworkDisabled: true}},
+		&statusRunner{addr: "192.0.2.3:9190", err: errors.New("connection refused")},
+	}}
+	router := gin.New()
+	router.GET("/pool/status", s.requireAdminToken, s.handleRunnerPoolStatus)
+
+	for _, auth := range []string{"", "secret", "Bearer wrong"} {
+		req := httptest.NewRequest("GET", "/pool/status", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected unauthorized for %q, got %d", auth, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/pool/status?refresh=true", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected pool status, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	var status poolStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Runners != 3 || status.ActiveRequests != 3 || status.RequestsReceived != 10 || status.FailedStatuses != 1 || status.NetworkDisabled != 1 {
+		t.Fatalf("Unexpected pool status %+v", status)
+	}
+	if d := status.Details[0]; d.Address != "192.0.2.1:9190" || !d.Cached || d.RequestsHandled != 9 {
+		t.Fatalf("Unexpected runner status %+v", d)
+	}
+	if d := status.Details[2]; !d.StatusFailed || d.Error != "connection refused" {
+		t.Fatalf("Unexpected runner status %+v", d)
+	}
+}
+
+// updaterRunnerPool is a runner pool whose runner addresses can be replaced
+type updaterRunnerPool struct {
+	statusRunnerPool
+	addrs []string
+}
+
+func (rp *updaterRunnerPool) SetRunners(ctx context.Context, runnerAddresses []string) error {
+	rp.addrs = runnerAddresses
+	return nil
+}
+func (rp *updaterRunnerPool) RunnerAddresses() []string { return rp.addrs }
+
+// newAdminTestServer binds the admin routes of s as an lb with the admin token "secret"
+func newAdminTestServer(s *Server) *Server {
+	s.adminToken = "secret"
+	s.Router = gin.New()
+	s.AdminRouter = gin.New()
+	s.nodeType = ServerTypeLB
+	s.noProfilerEndpoint = true
+	s.noHTTTPTriggerEndpoint = true
+	s.noFnInvokeEndpoint = true
+	s.bindHandlers(context.Background())
+	return s
+}
+
+func TestRunnersUpdateRequiresAdminToken(t *testing.T) {
+	rp := &updaterRunnerPool{addrs: []string{"192.0.2.1:9190"}}
+	s := newAdminTestServer(&Server{runnerPool: rp})
+
+	for _, auth := range []string{"", "secret", "Bearer wrong", "Bearer secret"} {
+		req := httptest.NewRequest("PUT", "/runners", strings.NewReader(`{"runners":["192.0.2.2:9190"]}`))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		s.AdminRouter.ServeHTTP(rec, req)
+
+		if auth != "Bearer secret" {
+			if rec.Code != http.StatusUnauthorized || rp.addrs[0] != "192.0.2.1:9190" {
+				t.Fatalf("Expected unauthorized for %q with runners unchanged, got %d %v", auth, rec.Code, rp.addrs)
+			}
+			continue
+		}
+		if rec.Code != http.StatusOK || len(rp.addrs) != 1 || rp.addrs[0] != "192.0.2.2:9190" {
+			t.Fatalf("Expected runners updated, got %d %s %v", rec.Code, rec.Body.String(), rp.addrs)
+		}
+	}
+}
+
+// drainableRunner is a runner that records whether it is draining
+type drainableRunner struct {
+	statusRunner
+	draining bool
+}
+
+func (r *drainableRunner) Drain(draining bool) { r.draining = draining }
+func (r *drainableRunner) IsDraining() bool    { return r.draining }
+func (r *drainableRunner) CallsInFlight() int  { return 0 }
+
+func TestRunnerDrainRequiresAdminToken(t *testing.T) {
+	r := &drainableRunner{statusRunner: statusRunner{addr: "192.0.2.1:9190"}}
+	s := newAdminTestServer(&Server{runnerPool: statusRunnerPool{r}})
+
+	for _, tc := range []struct {
+		method   string
+		auth     string
+		code     int
+		draining bool
+	}{
+		{"PUT", "", http.StatusUnauthorized, false},
+		{"PUT", "Bearer wrong", http.StatusUnauthorized, false},
+		{"PUT", "Bearer secret", http.StatusOK, true},
+		{"DELETE", "", http.StatusUnauthorized, true},
+		{"DELETE", "Bearer secret", http.StatusOK, false},
+	} {
+		req := httptest.NewRequest(tc.method, "/runners/192.0.2.1:9190/drain", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		s.AdminRouter.ServeHTTP(rec, req)
+		if rec.Code != tc.code || r.draining != tc.draining {
+			t.Fatalf("%s with %q: expected %d and draining=%v, got %d and draining=%v", tc.method, tc.auth, tc.code, tc.draining, rec.Code, r.draining)
+		}
+	}
+}
+
+// profilerAgent is an agent that profiles a container of any fn
+type profilerAgent struct {
+	agent.Agent
+	profiles int
+}
+
+func (a *profilerAgent) ProfileContainer(ctx context.Context, app *models.App, fn *models.Fn, req agent.ProfileRequest) (*agent.Profile, error) {
+	a.profiles++
+	return &agent.Profile{ContainerID: "container1", ContentType: "application/octet-stream", Data: []byte("profile")}, nil
+}
+
+func TestFnProfileRequiresAdminToken(t *testing.T) {
+	ds := datastore.NewMockInit(
+		[]*models.App{{ID: "app_id", Name: "myapp"}},
+		[]*models.Fn{{ID: "fn_id", AppID: "app_id", Name: "myfn"}},
+	)
+	a := &profilerAgent{}
+	s := newAdminTestServer(&Server{agent: a, lbReadAccess: agent.NewMetricReadDataAccess(ds)})
+
+	for _, auth := range []string{"", "Bearer wrong", "Bearer secret"} {
+		req := httptest.NewRequest("GET", "/fns/fn_id/profile?type=heap", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		s.AdminRouter.ServeHTTP(rec, req)
+
+		if auth != "Bearer secret" {
+			if rec.Code != http.StatusUnauthorized || a.profiles != 0 {
+				t.Fatalf("Expected unauthorized for %q without a profile, got %d and %d profiles", auth, rec.Code, a.profiles)
+			}
+			continue
+		}
+		if rec.Code != http.StatusOK || rec.Body.String() != "profile" || a.profiles != 1 {
+			t.Fatalf("Expected a profile, got %d %q", rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// drainerAgent is an agent that records whether it is draining
+type drainerAgent struct {
+	agent.Agent
+	draining bool
+}
+
+func (a *drainerAgent) Drain(draining bool) { a.draining = draining }
+func (a *drainerAgent) DrainStatus() agent.DrainStatus {
+	return agent.DrainStatus{Draining: a.draining, Drained: a.draining}
+}
+
+func TestDrainRequiresAdminToken(t *testing.T) {
+	a := &drainerAgent{}
+	s := newAdminTestServer(&Server{agent: a})
+
+	for _, tc := range []struct {
+		method   string
+		auth     string
+		code     int
+		draining bool
+	}{
+		{"GET", "", http.StatusUnauthorized, false},
+		{"PUT", "", http.StatusUnauthorized, false},
+		{"PUT", "Bearer wrong", http.StatusUnauthorized, false},
+		{"PUT", "Bearer secret", http.StatusOK, true},
+		{"GET", "Bearer secret", http.StatusOK, true},
+		{"DELETE", "", http.StatusUnauthorized, true},
+		{"DELETE", "Bearer secret", http.StatusOK, false},
+	} {
+		req := httptest.NewRequest(tc.method, "/drain", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		s.AdminRouter.ServeHTTP(rec, req)
+		if rec.Code != tc.code || a.draining != tc.draining {
+			t.Fatalf("%s with %q: expected %d and draining=%v, got %d and draining=%v", tc.method, tc.auth, tc.code, tc.draining, rec.Code, a.draining)
+		}
+	}
+}
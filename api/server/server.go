@@ -15,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 	"unicode"
 
 	"contrib.go.opencensus.io/exporter/jaeger"
@@ -27,6 +28,8 @@ import (
 	"go.opencensus.io/plugin/ochttp"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 
 	"github.com/fnproject/fn/api/agent"
 	"github.com/fnproject/fn/api/agent/hybrid"
@@ -67,9 +70,152 @@ const (
 	// EnvRunnerURL is a url pointing to an Fn API service.
 	EnvRunnerURL = "FN_RUNNER_API_URL"
 
-	// EnvRunnerAddresses is a list of runner urls for an lb to use.
+	// EnvRunnerAddresses is a list of runner urls for an lb to use. An address may be followed by
+	// ";weight=N" to give the runner N times the share of calls of runners without a weight, and
+	// by ";label.<key>=<value>" to add labels to those the runner advertises.
 	EnvRunnerAddresses = "FN_RUNNER_ADDRESSES"
 
+	// EnvRunnerPools is a list of names of runner pools, in addition to the runners of
+	// FN_RUNNER_ADDRESSES, that apps and fns select with the fnproject.io/runner/pool annotation.
+	// The runner urls of a pool are in FN_RUNNER_POOL_<NAME>_ADDRESSES, the name in upper case
+	// with characters other than letters and digits replaced by underscores.
+	EnvRunnerPools = "FN_RUNNER_POOLS"
+
+	// EnvRunnerShadowAddresses is a list of runner urls, eg. canary runners with a new build, an
+	// lb mirrors a percentage of the calls to. Responses of mirrored calls are discarded.
+	EnvRunnerShadowAddresses = "FN_RUNNER_SHADOW_ADDRESSES"
+
+	// EnvRunnerShadowPercent is the percentage of calls an lb mirrors to the shadow runners.
+	EnvRunnerShadowPercent = "FN_RUNNER_SHADOW_PERCENT"
+
+	// EnvAdminToken is a bearer token required by admin endpoints that expose or change the state
+	// of an lb and its runners, or profile the containers of fns. Those endpoints are disabled if it
+	// is not set.
+	EnvAdminToken = "FN_ADMIN_TOKEN"
+
+	// EnvLBMaxInFlight is the number of calls an lb places or runs on each of its runner pools
+	// at once, calls over it are rejected with a 503 right away. Zero is no limit.
+	EnvLBMaxInFlight = "FN_LB_MAX_IN_FLIGHT_CALLS"
+
+	// EnvLBReservedCapacity is the fraction of FN_LB_MAX_IN_FLIGHT_CALLS an lb reserves for
+	// calls of apps and fns of the reserved placement class, see
+	// models.PlacementClassAnnotation. Zero reserves nothing.
+	EnvLBReservedCapacity = "FN_LB_RESERVED_CAPACITY"
+
+	// EnvLBQueueDepth is the number of calls an lb holds per runner pool while all runners are
+	// busy, retrying them as calls finish instead of in a loop. Zero disables the queue.
+	EnvLBQueueDepth = "FN_LB_QUEUE_DEPTH"
+
+	// EnvLBQueueMaxWait is how long calls wait in the queue of FN_LB_QUEUE_DEPTH before they
+	// are rejected with a 503, as a duration or seconds. Defaults to a second.
+	EnvLBQueueMaxWait = "FN_LB_QUEUE_MAX_WAIT"
+
+	// EnvLBBackpressureThreshold is the share of calls of a runner pool failing to be placed as too
+	// busy above which an lb sheds new calls of the pool early with a 503, and with a 429 while
+	// the queue of FN_LB_QUEUE_DEPTH is full. Zero disables shedding.
+	EnvLBBackpressureThreshold = "FN_LB_BACKPRESSURE_THRESHOLD"
+
+	// EnvLBBackpressureWindow is the window the failure rate of FN_LB_BACKPRESSURE_THRESHOLD is
+	// measured over, as a duration or seconds. Defaults to a second.
+	EnvLBBackpressureWindow = "FN_LB_BACKPRESSURE_WINDOW"
+
+	// EnvLBSessions is the number of sessions an lb remembers the runners of, for fns opting into
+	// session affinity with models.SessionHeaderAnnotation. Zero disables session affinity.
+	EnvLBSessions = "FN_LB_SESSIONS"
+
+	// EnvLBSessionTTL is how long an lb remembers the runner of a session after its last call,
+	// as a duration or seconds. Defaults to 10 minutes.
+	EnvLBSessionTTL = "FN_LB_SESSION_TTL"
+
+	// EnvLBResponseCacheSize is the number of responses an lb caches, for fns opting into the
+	// response cache with models.ResponseCacheTTLAnnotation. Zero disables the response cache.
+	EnvLBResponseCacheSize = "FN_LB_RESPONSE_CACHE_SIZE"
+
+	// EnvLBResponseCacheMaxBody is the size in bytes of the largest response an lb caches.
+	// Defaults to 64KB.
+	EnvLBResponseCacheMaxBody = "FN_LB_RESPONSE_CACHE_MAX_BODY"
+
+	// EnvLBBodyMaxMemory is the size in bytes of the largest request body an lb buffers in
+	// memory to retry calls, larger bodies are spooled to disk. Zero buffers all bodies in memory.
+	EnvLBBodyMaxMemory = "FN_LB_BODY_MAX_MEMORY"
+
+	// EnvLBBodyMaxSize is the size in bytes of the largest request body an lb spools to disk,
+	// calls with larger bodies fail. Zero for no limit.
+	EnvLBBodyMaxSize = "FN_LB_BODY_MAX_SIZE"
+
+	// EnvLBBodySpoolDir is the directory an lb spools request bodies to. Defaults to the temp dir.
+	EnvLBBodySpoolDir = "FN_LB_BODY_SPOOL_DIR"
+
+	// EnvLBCallResultsSize is the number of results of detached calls an lb keeps in memory,
+	// for clients to retrieve from /invoke/:fn_id/calls/:call_id. Zero disables call results.
+	EnvLBCallResultsSize = "FN_LB_CALL_RESULTS_SIZE"
+
+	// EnvLBCallResultsTTL is how long an lb keeps the results of detached calls, as a duration or
+	// seconds. Defaults to an hour.
+	EnvLBCallResultsTTL = "FN_LB_CALL_RESULTS_TTL"
+
+	// EnvLBCallResultsMaxBody is the size in bytes of the largest response of a detached call an lb
+	// keeps, calls with larger responses fail. Defaults to 1MB.
+	EnvLBCallResultsMaxBody = "FN_LB_CALL_RESULTS_MAX_BODY"
+
+	// EnvLBCapacityInterval is how often an lb computes the desired capacity of its runner pools
+	// for external autoscalers, as a duration or seconds. Disabled if not set.
+	EnvLBCapacityInterval = "FN_LB_CAPACITY_INTERVAL"
+
+	// EnvLBCapacityTargetCalls is the number of calls running on a runner the desired capacity
+	// is computed for.
+	EnvLBCapacityTargetCalls = "FN_LB_CAPACITY_TARGET_CALLS"
+
+	// EnvLBCapacityMaxQueueWait is the average time calls may wait in an lb above which more
+	// runners are desired, as a duration or seconds.
+	EnvLBCapacityMaxQueueWait = "FN_LB_CAPACITY_MAX_QUEUE_WAIT"
+
+	// EnvLBCapacityWebhook is a url the desired capacity of a runner pool is posted to when it
+	// changes.
+	EnvLBCapacityWebhook = "FN_LB_CAPACITY_WEBHOOK"
+
+	// EnvRunnerAddressesFile is a file with the runner urls for an lb to use, separated by commas or
+	// new lines. The file is reloaded when it changes, runners that are kept keep their connections.
+	EnvRunnerAddressesFile = "FN_RUNNER_ADDRESSES_FILE"
+
+	// EnvRunnerAddressesReload is how often the runner addresses file is checked for changes, as a
+	// duration or seconds.
+	EnvRunnerAddressesReload = "FN_RUNNER_ADDRESSES_RELOAD_INTERVAL"
+
+	// EnvRunnerDNSName is a DNS name that resolves to the runners for an lb to use, either a host
+	// name with a port resolved to its A/AAAA records or a name resolved to its SRV records.
+	EnvRunnerDNSName = "FN_RUNNER_DNS_NAME"
+
+	// EnvRunnerDNSInterval is how often FN_RUNNER_DNS_NAME is resolved, as a duration or seconds.
+	EnvRunnerDNSInterval = "FN_RUNNER_DNS_INTERVAL"
+
+	// EnvRunnerK8sService is a Kubernetes service, as "[namespace/]name", whose ready endpoints are
+	// the runners for an lb to use.
+	EnvRunnerK8sService = "FN_RUNNER_K8S_SERVICE"
+
+	// EnvRunnerK8sPort is the name or number of the port of FN_RUNNER_K8S_SERVICE runners listen on,
+	// only needed for services with more than one port.
+	EnvRunnerK8sPort = "FN_RUNNER_K8S_PORT"
+
+	// EnvRunnerConsulPrefix is the Consul KV prefix runners register under. An lb uses the registered
+	// runners, a pure runner with FN_RUNNER_ADVERTISE_ADDR set registers itself. The Consul agent is
+	// set with CONSUL_HTTP_ADDR and CONSUL_HTTP_TOKEN.
+	EnvRunnerConsulPrefix = "FN_RUNNER_CONSUL_PREFIX"
+
+	// EnvRunnerConsulTTL is the TTL of the registration of a pure runner, as a duration or seconds.
+	EnvRunnerConsulTTL = "FN_RUNNER_CONSUL_TTL"
+
+	// EnvRunnerAdvertiseAddr is the address lbs reach a pure runner at, for its registration.
+	EnvRunnerAdvertiseAddr = "FN_RUNNER_ADVERTISE_ADDR"
+
+	// EnvRunnerCapacity is the relative share of calls a pure runner registers to take.
+	EnvRunnerCapacity = "FN_RUNNER_CAPACITY"
+
+	// EnvRunnerLabels are the labels a pure runner advertises to LBs and registers with, as
+	// "key=value,key=value". LBs place calls of fns with runner constraints only on runners
+	// with matching labels. The os and arch labels default to the platform of the runner.
+	EnvRunnerLabels = "FN_RUNNER_LABELS"
+
 	// EnvPublicLoadBalancerURL is the url to inject into trigger responses to get a public url.
 	EnvPublicLoadBalancerURL = "FN_PUBLIC_LB_URL"
 
@@ -101,9 +247,51 @@ const (
 	// EnvProcessCollectorList is the list of procid's to collect metrics for.
 	EnvProcessCollectorList = "FN_PROCESS_COLLECTOR_LIST"
 
-	// EnvLBPlacementAlg is the algorithm to place fn calls to fn runners in lb.[0w
+	// EnvLBPlacementAlg is the algorithm to place fn calls to fn runners in lb, one of naive
+	// (the default), ch, slot, load and ewma, or the name of a placer added by an extension.
 	EnvLBPlacementAlg = "FN_PLACER"
 
+	// EnvLBPlacerHedgeDelay is the delay after which the lb also tries a call on a second runner if
+	// the first has not accepted it, as a duration or seconds. Only idempotent calls of fns opting
+	// in with models.HedgeAnnotation are hedged. Unset or zero disables hedging.
+	EnvLBPlacerHedgeDelay = "FN_PLACER_HEDGE_DELAY"
+
+	// EnvLBPlacerSlotRunners is the number of runners the slot hash placer spreads the calls
+	// of a function over, see runnerpool.PlacerConfig.SlotHashRunners.
+	EnvLBPlacerSlotRunners = "FN_PLACER_SLOT_RUNNERS"
+
+	// EnvLBPlacerLoadPollInterval is how often the load placer polls the status of runners, as a
+	// duration or seconds.
+	EnvLBPlacerLoadPollInterval = "FN_PLACER_LOAD_POLL_INTERVAL"
+
+	// EnvLBPlacerEWMADecay is how fast the ewma placer forgets the latencies and NACK rates it
+	// observed of runners, as a duration or seconds.
+	EnvLBPlacerEWMADecay = "FN_PLACER_EWMA_DECAY"
+
+	// EnvLBPlacerZone is the zone of the lb. Calls are placed on runners labeled with the same
+	// zone first, and only spill over to runners of other zones once those are busy.
+	EnvLBPlacerZone = "FN_PLACER_ZONE"
+
+	// EnvLBPlacerInteractiveTimeout is how long the lb tries to place interactive calls before
+	// failing them with a 503, as a duration or seconds. Unset uses the default placer timeout.
+	EnvLBPlacerInteractiveTimeout = "FN_PLACER_INTERACTIVE_TIMEOUT"
+
+	// EnvLBPlacerInteractiveAttempts is the maximum number of runners the lb tries an interactive
+	// call on. Unset or zero is unlimited.
+	EnvLBPlacerInteractiveAttempts = "FN_PLACER_INTERACTIVE_MAX_ATTEMPTS"
+
+	// EnvLBPlacerBatchTimeout is how long the lb tries to place batch calls, eg. detached calls, as
+	// a duration or seconds. Unset uses the default detached placer timeout for detached calls.
+	EnvLBPlacerBatchTimeout = "FN_PLACER_BATCH_TIMEOUT"
+
+	// EnvLBPlacerBatchAttempts is the maximum number of runners the lb tries a batch call on. Unset
+	// or zero is unlimited.
+	EnvLBPlacerBatchAttempts = "FN_PLACER_BATCH_MAX_ATTEMPTS"
+
+	// EnvGRPCInvoke enables gRPC calls of fns speaking models.ProtocolGRPC on the web server of
+	// full nodes, over HTTP/2 without TLS (h2c) unless the web server has TLS.
+	EnvGRPCInvoke = "FN_GRPC_INVOKE"
+
 	// EnvMaxRequestSize sets the limit in bytes for any API request body's length.
 	EnvMaxRequestSize = "FN_MAX_REQUEST_SIZE"
 
@@ -196,6 +384,8 @@ type Server struct {
 	agent     agent.Agent
 	datastore models.Datastore
 	nodeType  NodeType
+	// runner pool of an lb node created from env
+	runnerPool pool.RunnerPool
 
 	// Service Settings for Admin/Web/gRPC. Note that for gRPC only
 	// TLSConfig and Addr are transferrable from http.Server to GRPC service.
@@ -205,6 +395,7 @@ type Server struct {
 	lbReadAccess           agent.ReadDataAccess
 	noHTTTPTriggerEndpoint bool
 	noFnInvokeEndpoint     bool
+	grpcInvoke             bool
 	noProfilerEndpoint     bool
 	noWebServer            bool
 	noAdminServer          bool
@@ -216,6 +407,13 @@ type Server struct {
 	promExporter           *prometheus.Exporter
 	triggerAnnotator       TriggerAnnotator
 	fnAnnotator            FnAnnotator
+	// bearer token of authenticated admin endpoints, see WithAdminToken
+	adminToken string
+	// placers added by extensions, and the placers of the lb using them, see AddPlacer
+	placers    map[string]fnext.PlacerFactory
+	extPlacers []*extPlacer
+	// config of the placers of the lb, for placer simulations
+	placerConfig *pool.PlacerConfig
 
 	// Extensions can append to this list of contexts so that cancellations are properly handled.
 	extraCtxs []context.Context
@@ -255,6 +453,10 @@ func NewFromEnv(ctx context.Context, opts ...Option) *Server {
 	opts = append(opts, WithType(nodeType))
 
 	opts = append(opts, LimitRequestBody(int64(getEnvInt(EnvMaxRequestSize, 0))))
+	if grpcInvoke, _ := strconv.ParseBool(getEnv(EnvGRPCInvoke, "")); grpcInvoke {
+		opts = append(opts, WithGRPCInvoke())
+	}
+	opts = append(opts, WithAdminToken(getEnv(EnvAdminToken, "")))
 
 	publicLBURL := getEnv(EnvPublicLoadBalancerURL, "")
 	if publicLBURL != "" {
@@ -344,6 +546,14 @@ func WithDBURL(dbURL string) Option {
 	}
 }
 
+// WithAdminToken sets the bearer token of authenticated admin endpoints, see EnvAdminToken
+func WithAdminToken(token string) Option {
+	return func(ctx context.Context, s *Server) error {
+		s.adminToken = token
+		return nil
+	}
+}
+
 // WithType maps EnvNodeType
 func WithType(t NodeType) Option {
 	return func(ctx context.Context, s *Server) error {
@@ -389,7 +599,44 @@ func WithAgent(agent agent.Agent) Option {
 	}
 }
 
-func (s *Server) defaultRunnerPool() (pool.RunnerPool, error) {
+// runnerPoolAddressesEnv returns the environment variable of the runner urls of the named
+// runner pool, see EnvRunnerPools
+func runnerPoolAddressesEnv(name string) string {
+	env := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	return "FN_RUNNER_POOL_" + env + "_ADDRESSES"
+}
+
+func (s *Server) defaultRunnerPool(ctx context.Context) (pool.RunnerPool, error) {
+	if service := getEnv(EnvRunnerK8sService, ""); service != "" {
+		return agent.DefaultK8sRunnerPool(service, getEnv(EnvRunnerK8sPort, "")), nil
+	}
+	if prefix := getEnv(EnvRunnerConsulPrefix, ""); prefix != "" {
+		return agent.DefaultConsulRunnerPool(prefix), nil
+	}
+	if name := getEnv(EnvRunnerDNSName, ""); name != "" {
+		return agent.DefaultDNSRunnerPool(name, getEnvDuration(EnvRunnerDNSInterval, 30*time.Second)), nil
+	}
+
+	if path := getEnv(EnvRunnerAddressesFile, ""); path != "" {
+		runnerAddresses, err := agent.ReadRunnerAddresses(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read FN_RUNNER_ADDRESSES_FILE: %v", err)
+		}
+		rp := agent.DefaultStaticRunnerPool(runnerAddresses)
+		if updater, ok := rp.(pool.RunnerPoolUpdater); ok {
+			go agent.WatchRunnerAddresses(ctx, updater, path, getEnvDuration(EnvRunnerAddressesReload, 10*time.Second))
+		}
+		return rp, nil
+	}
+
 	runnerAddresses := getEnv(EnvRunnerAddresses, "")
 	if runnerAddresses == "" {
 		return nil, errors.New("must provide FN_RUNNER_ADDRESSES  when running in default load-balanced mode")
@@ -414,13 +661,24 @@ func WithAgentFromEnv() Option {
 		case ServerTypeAPI:
 			return errors.New("should not initialize an agent for an Fn API node")
 		case ServerTypePureRunner:
+			labels, err := agent.ParseRunnerLabels(getEnv(EnvRunnerLabels, ""))
+			if err != nil {
+				return err
+			}
+			labels = agent.WithPlatformLabels(labels)
 			cancelCtx, cancel := context.WithCancel(ctx)
-			prAgent, err := agent.DefaultPureRunner(cancel, s.svcConfigs[GRPCServer].Addr, s.svcConfigs[GRPCServer].TLSConfig)
+			prAgent, err := agent.DefaultPureRunner(cancel, s.svcConfigs[GRPCServer].Addr, s.svcConfigs[GRPCServer].TLSConfig, agent.PureRunnerWithLabels(labels))
 			if err != nil {
 				return err
 			}
 			s.agent = prAgent
 			s.extraCtxs = append(s.extraCtxs, cancelCtx)
+
+			prefix, advertise := getEnv(EnvRunnerConsulPrefix, ""), getEnv(EnvRunnerAdvertiseAddr, "")
+			if prefix != "" && advertise != "" {
+				reg := agent.RunnerRegistration{Address: advertise, Capacity: getEnvInt(EnvRunnerCapacity, 0), Labels: labels}
+				go agent.RegisterConsulRunner(cancelCtx, "", prefix, reg, getEnvDuration(EnvRunnerConsulTTL, 10*time.Second))
+			}
 		case ServerTypeLB:
 			s.nodeType = ServerTypeLB
 			runnerURL := getEnv(EnvRunnerURL, "")
@@ -433,26 +691,104 @@ func WithAgentFromEnv() Option {
 				return err
 			}
 
-			runnerPool, err := s.defaultRunnerPool()
+			runnerPool, err := s.defaultRunnerPool(ctx)
 			if err != nil {
 				return err
 			}
+			s.runnerPool = runnerPool
 
 			// Select the placement algorithm
 			placerCfg := pool.NewPlacerConfig()
-			var placer pool.Placer
-			switch getEnv(EnvLBPlacementAlg, "") {
-			case "ch":
-				placer = pool.NewCHPlacer(&placerCfg)
-			default:
-				placer = pool.NewNaivePlacer(&placerCfg)
+			placerCfg.HedgeDelay = getEnvDuration(EnvLBPlacerHedgeDelay, 0)
+			placerCfg.SlotHashRunners = getEnvInt(EnvLBPlacerSlotRunners, placerCfg.SlotHashRunners)
+			placerCfg.LoadPollInterval = getEnvDuration(EnvLBPlacerLoadPollInterval, placerCfg.LoadPollInterval)
+			placerCfg.EWMADecay = getEnvDuration(EnvLBPlacerEWMADecay, placerCfg.EWMADecay)
+			placerCfg.Zone = getEnv(EnvLBPlacerZone, "")
+			placerCfg.PriorityBudgets = map[pool.CallPriority]pool.PlacementBudget{
+				pool.PriorityInteractive: {
+					Timeout:     getEnvDuration(EnvLBPlacerInteractiveTimeout, 0),
+					MaxAttempts: getEnvInt(EnvLBPlacerInteractiveAttempts, 0),
+				},
+				pool.PriorityBatch: {
+					Timeout:     getEnvDuration(EnvLBPlacerBatchTimeout, 0),
+					MaxAttempts: getEnvInt(EnvLBPlacerBatchAttempts, 0),
+				},
 			}
+			s.placerConfig = &placerCfg
+			placer := s.newPlacer(&placerCfg)
 
 			err = WithReadDataAccess(agent.NewCachedDataAccess(cl))(ctx, s)
 			if err != nil {
 				return errors.New("LBAgent creation failed")
 			}
-			s.agent, err = agent.NewLBAgent(runnerPool, placer)
+			var lbOpts []agent.LBAgentOption
+			if logFrames, _ := strconv.ParseBool(getEnv(agent.EnvRunnerLogFrames, "")); logFrames {
+				// keep the function logs runners send for each call
+				lbOpts = append(lbOpts, agent.WithLBCallLogs())
+			}
+			// named runner pools, each with its own placer
+			for _, name := range strings.Split(getEnv(EnvRunnerPools, ""), ",") {
+				name = strings.TrimSpace(name)
+				if name == "" {
+					continue
+				}
+				addresses := getEnv(runnerPoolAddressesEnv(name), "")
+				if addresses == "" {
+					return fmt.Errorf("must provide %s for runner pool %s", runnerPoolAddressesEnv(name), name)
+				}
+				namedPool := agent.DefaultStaticRunnerPool(strings.Split(addresses, ","))
+				lbOpts = append(lbOpts, agent.WithLBRunnerPool(name, namedPool, s.newPlacer(&placerCfg)))
+			}
+			if shadowAddresses := getEnv(EnvRunnerShadowAddresses, ""); shadowAddresses != "" {
+				percent, err := strconv.ParseFloat(getEnv(EnvRunnerShadowPercent, "0"), 64)
+				if err != nil {
+					return fmt.Errorf("invalid %s: %v", EnvRunnerShadowPercent, err)
+				}
+				shadowPool := agent.DefaultStaticRunnerPool(strings.Split(shadowAddresses, ","))
+				lbOpts = append(lbOpts, agent.WithLBShadowPool(shadowPool, percent))
+			}
+			if maxInFlight := getEnvInt(EnvLBMaxInFlight, 0); maxInFlight > 0 {
+				lbOpts = append(lbOpts, agent.WithLBMaxInFlight(maxInFlight))
+				reserved, err := strconv.ParseFloat(getEnv(EnvLBReservedCapacity, "0"), 64)
+				if err != nil {
+					return fmt.Errorf("invalid %s: %v", EnvLBReservedCapacity, err)
+				}
+				if reserved > 0 {
+					lbOpts = append(lbOpts, agent.WithLBReservedCapacity(reserved))
+				}
+			}
+			if depth := getEnvInt(EnvLBQueueDepth, 0); depth > 0 {
+				lbOpts = append(lbOpts, agent.WithLBCallQueue(depth, getEnvDuration(EnvLBQueueMaxWait, time.Second)))
+			}
+			threshold, err := strconv.ParseFloat(getEnv(EnvLBBackpressureThreshold, "0"), 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %v", EnvLBBackpressureThreshold, err)
+			}
+			if threshold > 0 {
+				lbOpts = append(lbOpts, agent.WithLBBackpressure(threshold, getEnvDuration(EnvLBBackpressureWindow, time.Second)))
+			}
+			if sessions := getEnvInt(EnvLBSessions, 0); sessions > 0 {
+				lbOpts = append(lbOpts, agent.WithLBSessionAffinity(sessions, getEnvDuration(EnvLBSessionTTL, 10*time.Minute)))
+			}
+			if size := getEnvInt(EnvLBResponseCacheSize, 0); size > 0 {
+				lbOpts = append(lbOpts, agent.WithLBResponseCache(size, getEnvInt(EnvLBResponseCacheMaxBody, 64*1024)))
+			}
+			if maxMemory := getEnvInt(EnvLBBodyMaxMemory, 0); maxMemory > 0 {
+				lbOpts = append(lbOpts, agent.WithLBBodySpool(int64(maxMemory), int64(getEnvInt(EnvLBBodyMaxSize, 0)), getEnv(EnvLBBodySpoolDir, "")))
+			}
+			if size := getEnvInt(EnvLBCallResultsSize, 0); size > 0 {
+				store := agent.NewMemoryCallResultStore(size, getEnvDuration(EnvLBCallResultsTTL, time.Hour))
+				lbOpts = append(lbOpts, agent.WithLBCallResults(store, int64(getEnvInt(EnvLBCallResultsMaxBody, 1024*1024))))
+			}
+			if interval := getEnvDuration(EnvLBCapacityInterval, 0); interval > 0 {
+				lbOpts = append(lbOpts, agent.WithLBCapacitySignal(agent.CapacitySignalConfig{
+					Interval:          interval,
+					TargetActiveCalls: getEnvInt(EnvLBCapacityTargetCalls, 0),
+					MaxQueueWait:      getEnvDuration(EnvLBCapacityMaxQueueWait, 0),
+					WebhookURL:        getEnv(EnvLBCapacityWebhook, ""),
+				}))
+			}
+			s.agent, err = agent.NewLBAgent(runnerPool, placer, lbOpts...)
 			if err != nil {
 				return errors.New("LBAgent creation failed")
 			}
@@ -656,6 +992,15 @@ func WithoutFnInvokeEndpoints() Option {
 	}
 }
 
+// WithGRPCInvoke enables gRPC calls of fns on the web server, see EnvGRPCInvoke. gRPC
+// clients call the methods of an fn with its id in their GRPCFnIDHeader metadata.
+func WithGRPCInvoke() Option {
+	return func(ctx context.Context, s *Server) error {
+		s.grpcInvoke = true
+		return nil
+	}
+}
+
 // WithoutProfilerEndpoints disables the /debug endpoints
 func WithoutProfilerEndpoints() Option {
 	return func(ctx context.Context, s *Server) error {
@@ -854,6 +1199,8 @@ func (s *Server) startGears(ctx context.Context, cancel context.CancelFunc) {
 
 	installChildReaper()
 
+	s.resolvePlacers()
+
 	server := s.svcConfigs[WebServer]
 	if server.Handler == nil {
 		server.Handler = &ochttp.Handler{
@@ -870,6 +1217,11 @@ func (s *Server) startGears(ctx context.Context, cancel context.CancelFunc) {
 		}
 	}
 
+	if s.grpcInvoke && server.TLSConfig == nil {
+		// gRPC clients speak HTTP/2 without TLS
+		server.Handler = h2c.NewHandler(server.Handler, &http2.Server{})
+	}
+
 	if !s.noWebServer {
 		go func() {
 			var err error
@@ -970,6 +1322,35 @@ func (s *Server) bindHandlers(ctx context.Context) {
 		profilerSetup(admin, "/debug")
 	}
 
+	if s.runnerPool != nil {
+		admin.GET("/runners", s.handleRunnersGet)
+		admin.PUT("/runners/:runner_addr/drain", s.requireAdminToken, s.handleRunnerDrain)
+		admin.DELETE("/runners/:runner_addr/drain", s.requireAdminToken, s.handleRunnerDrain)
+		if _, ok := s.runnerPool.(pool.RunnerPoolUpdater); ok {
+			admin.PUT("/runners", s.requireAdminToken, s.handleRunnersUpdate)
+		}
+		if s.adminToken != "" {
+			admin.GET("/pool/status", s.requireAdminToken, s.handleRunnerPoolStatus)
+		}
+	}
+	if s.placerConfig != nil {
+		admin.POST("/placer/simulate", s.handlePlacerSimulate)
+	}
+	if _, ok := s.agent.(agent.CapacitySignaler); ok {
+		admin.GET("/capacity", s.handleCapacityGet)
+	}
+	if _, ok := s.agent.(agent.Drainer); ok {
+		admin.GET("/drain", s.requireAdminToken, s.handleDrainGet)
+		admin.PUT("/drain", s.requireAdminToken, s.handleDrain)
+		admin.DELETE("/drain", s.requireAdminToken, s.handleDrain)
+	}
+	if _, ok := s.agent.(agent.ImagePrePuller); ok && s.lbReadAccess != nil {
+		admin.POST("/fns/:fn_id/prepull", s.handleFnPrePull)
+	}
+	if _, ok := s.agent.(agent.ContainerProfiler); ok && s.lbReadAccess != nil {
+		admin.GET("/fns/:fn_id/profile", s.requireAdminToken, s.handleFnProfile)
+	}
+
 	// Pure runners don't have any route, they have grpc
 	switch s.nodeType {
 
@@ -1020,10 +1401,20 @@ func (s *Server) bindHandlers(ctx context.Context) {
 		if !s.noFnInvokeEndpoint {
 			lbFnInvokeGroup := engine.Group("/invoke")
 			lbFnInvokeGroup.POST("/:fn_id", s.handleFnInvokeCall)
+			// WebSocket upgrades of stream calls
+			lbFnInvokeGroup.GET("/:fn_id", s.handleFnInvokeCall)
+			if r, ok := s.agent.(agent.CallResults); ok && r.KeepsCallResults() {
+				lbFnInvokeGroup.GET("/:fn_id/calls/:call_id", s.handleFnCallResult)
+			}
 		}
 	}
 
 	engine.NoRoute(func(c *gin.Context) {
+		// the paths of gRPC calls are their methods
+		if s.grpcInvoke && isGRPCRequest(c.Request) {
+			s.handleFnGRPCCall(c)
+			return
+		}
 		var e models.APIError = models.ErrPathNotFound
 		err := models.NewAPIError(e.Code(), fmt.Errorf("%v: %s %s", e.Error(), c.Request.Method, c.Request.URL.Path))
 		handleErrorResponse(c, err)
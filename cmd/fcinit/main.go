@@ -0,0 +1,23 @@
+// +build linux
+
+// fcinit is the init of the microVMs of the firecracker driver, the drive of its root
+// device. It must be built statically, eg. with CGO_ENABLED=0, and given to the driver
+// with the FIRECRACKER_INIT env. See
+// github.com/fnproject/fn/api/agent/drivers/firecracker.
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fnproject/fn/api/agent/drivers/firecracker/guest"
+)
+
+func main() {
+	err := guest.Init()
+	fmt.Fprintln(os.Stderr, "fcinit:", err)
+	// the kernel panics once process 1 exits, wait for the console to be written
+	time.Sleep(time.Second)
+	os.Exit(1)
+}
@@ -0,0 +1,9 @@
+package fnext
+
+import (
+	pool "github.com/fnproject/fn/api/runnerpool"
+)
+
+// PlacerFactory creates a placer for calls of an LB node. Every runner pool of the LB gets
+// its own placer, created with the placer config the LB got from its environment.
+type PlacerFactory func(cfg *pool.PlacerConfig) pool.Placer
@@ -35,6 +35,9 @@ type ExtServer interface {
 	// AddEndpoint adds an endpoint to /v2/x
 	AddEndpointFunc(method, path string, handler func(w http.ResponseWriter, r *http.Request))
 
+	// AddPlacer adds a placer that LB nodes use when FN_PLACER is set to name
+	AddPlacer(name string, factory PlacerFactory)
+
 	// Datastore returns the Datastore Fn is using
 	Datastore() models.Datastore
 }
@@ -2,6 +2,9 @@ package grpcutil
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"math/rand"
 	"net"
 	"time"
 
@@ -13,20 +16,73 @@ import (
 	"google.golang.org/grpc/metadata"
 )
 
+// BackoffConfig is a policy for retrying failed connection attempts, see
+// https://github.com/grpc/grpc/blob/master/doc/connection-backoff.md
+type BackoffConfig struct {
+	// BaseDelay is the delay after the first failed attempt
+	BaseDelay time.Duration
+	// Multiplier is applied to the delay after every further failed attempt
+	Multiplier float64
+	// Jitter randomizes delays by up to this fraction of the delay
+	Jitter float64
+	// MaxDelay is the upper bound of the delay
+	MaxDelay time.Duration
+}
+
+// DefaultBackoffConfig is the backoff policy gRPC uses by default
+var DefaultBackoffConfig = BackoffConfig{
+	BaseDelay:  time.Second,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+	MaxDelay:   grpc.DefaultBackoffConfig.MaxDelay,
+}
+
+// Validate returns an error if the policy is not usable
+func (b BackoffConfig) Validate() error {
+	if b.BaseDelay <= 0 || b.Multiplier < 1 || b.Jitter < 0 || b.Jitter > 1 || b.MaxDelay < b.BaseDelay {
+		return fmt.Errorf("Invalid backoff config %+v", b)
+	}
+	return nil
+}
+
+// Delay returns how long to wait after the given number of consecutive failed attempts, minus one
+func (b BackoffConfig) Delay(retries int) time.Duration {
+	delay := float64(b.BaseDelay) * math.Pow(b.Multiplier, float64(retries))
+	if delay > float64(b.MaxDelay) {
+		delay = float64(b.MaxDelay)
+	}
+	delay *= 1 + b.Jitter*(rand.Float64()*2-1)
+	return time.Duration(delay)
+}
+
 // DialWithBackoff creates a grpc connection using backoff strategy for reconnections
 func DialWithBackoff(ctx context.Context, address string, creds credentials.TransportCredentials, timeout time.Duration, backoffCfg grpc.BackoffConfig, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	opts = append(opts, grpc.WithBackoffConfig(backoffCfg))
-	return dial(ctx, address, creds, timeout, opts...)
+	return dial(ctx, address, creds, timeout, nil, opts...)
+}
+
+// DialWithBackoffConfig creates a grpc connection that retries failed connection attempts
+// with backoffCfg. gRPC itself only allows to configure the largest delay between attempts,
+// so each attempt of gRPC to connect is retried with backoffCfg until the attempt times out,
+// after which gRPC waits up to backoffCfg.MaxDelay before trying again.
+func DialWithBackoffConfig(ctx context.Context, address string, creds credentials.TransportCredentials, timeout time.Duration, backoffCfg BackoffConfig, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	err := backoffCfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, grpc.WithBackoffConfig(grpc.BackoffConfig{MaxDelay: backoffCfg.MaxDelay}))
+	return dial(ctx, address, creds, timeout, &backoffCfg, opts...)
 }
 
 // uses grpc connection backoff protocol https://github.com/grpc/grpc/blob/master/doc/connection-backoff.md
-func dial(ctx context.Context, address string, creds credentials.TransportCredentials, timeoutDialer time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+// Failed dials are retried with retry, if set, until gRPC gives up on the connection attempt.
+func dial(ctx context.Context, address string, creds credentials.TransportCredentials, timeoutDialer time.Duration, retry *BackoffConfig, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	dialer := func(address string, timeout time.Duration) (net.Conn, error) {
 		log := common.Logger(ctx).WithField("grpc_addr", address)
 
 		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
-		conn, err := (&net.Dialer{Cancel: ctx.Done(), Timeout: timeoutDialer}).Dial("tcp", address)
+		conn, err := dialTCP(ctx, address, timeoutDialer, retry)
 		if err != nil {
 			log.WithError(err).Debug("Failed to dial grpc connection")
 			return nil, err
@@ -52,6 +108,28 @@ func dial(ctx context.Context, address string, creds credentials.TransportCreden
 
 }
 
+// dialTCP dials address, retrying failed dials with retry until ctx is done
+func dialTCP(ctx context.Context, address string, timeout time.Duration, retry *BackoffConfig) (net.Conn, error) {
+	for retries := 0; ; retries++ {
+		conn, err := (&net.Dialer{Cancel: ctx.Done(), Timeout: timeout}).Dial("tcp", address)
+		if err == nil || retry == nil {
+			return conn, err
+		}
+
+		delay := retry.Delay(retries)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return nil, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+	}
+}
+
 // RIDStreamServerInterceptor is a gRPC stream interceptor which gets the request ID out of the context and put a logger with request ID logged into the common logger in the context
 func RIDStreamServerInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
 	newStream := grpc_middleware.WrapServerStream(stream)
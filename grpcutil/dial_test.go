@@ -2,7 +2,9 @@ package grpcutil
 
 import (
 	"context"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/fnproject/fn/api/common"
 	"google.golang.org/grpc/metadata"
@@ -31,3 +33,73 @@ func TestRIDNotFoundInMetadata(t *testing.T) {
 		t.Fatalf("Expected empty request ID got '%s'", actual)
 	}
 }
+
+func TestBackoffConfigDelay(t *testing.T) {
+	b := BackoffConfig{BaseDelay: 100 * time.Millisecond, Multiplier: 2, MaxDelay: time.Second}
+	for retries, expected := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		if delay := b.Delay(retries); delay != expected*time.Millisecond {
+			t.Fatalf("Expected delay %v after %d retries, got %v", expected*time.Millisecond, retries, delay)
+		}
+	}
+
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := b.Delay(0); delay < 50*time.Millisecond || delay > 150*time.Millisecond {
+			t.Fatalf("Expected jittered delay within 50%% of 100ms, got %v", delay)
+		}
+	}
+
+	for _, invalid := range []BackoffConfig{
+		{BaseDelay: 0, Multiplier: 1, MaxDelay: time.Second},
+		{BaseDelay: time.Second, Multiplier: 0.5, MaxDelay: time.Second},
+		{BaseDelay: time.Second, Multiplier: 1, Jitter: 2, MaxDelay: time.Second},
+		{BaseDelay: time.Second, Multiplier: 1, MaxDelay: time.Millisecond},
+	} {
+		if invalid.Validate() == nil {
+			t.Fatalf("Expected invalid backoff config %+v", invalid)
+		}
+	}
+	if DefaultBackoffConfig.Validate() != nil {
+		t.Fatalf("Expected valid default backoff config")
+	}
+}
+
+func TestDialTCPRetries(t *testing.T) {
+	// reserve a port and close it, so that dials fail until we listen again
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(50 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		defer l.Close()
+		conn, err := l.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	retry := &BackoffConfig{BaseDelay: 10 * time.Millisecond, Multiplier: 1, MaxDelay: 10 * time.Millisecond}
+	conn, err := dialTCP(ctx, addr, time.Second, retry)
+	if err != nil {
+		t.Fatalf("Expected dial to succeed once the port is listened on, got %v", err)
+	}
+	conn.Close()
+	<-done
+
+	_, err = dialTCP(ctx, addr, time.Second, nil)
+	if err == nil {
+		t.Fatalf("Expected dial without retries to fail")
+	}
+}
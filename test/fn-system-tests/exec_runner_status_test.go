@@ -102,6 +102,7 @@ type myCall struct{}
 
 // implements RunnerCall
 func (c *myCall) SlotHashId() string                   { return "" }
+func (c *myCall) Priority() runnerpool.CallPriority    { return runnerpool.PriorityInteractive }
 func (c *myCall) Extensions() map[string]string        { return nil }
 func (c *myCall) RequestBody() io.ReadCloser           { return nil }
 func (c *myCall) ResponseWriter() http.ResponseWriter  { return nil }